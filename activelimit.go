@@ -0,0 +1,127 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ActiveCounter is a session store that is capable of counting its sessions,
+// which is required by Options.MaxActiveSessions.
+type ActiveCounter interface {
+	// CountActive returns the number of sessions in the session store, including
+	// the ones that are expired but not yet recycled by GC.
+	CountActive(ctx context.Context) (int, error)
+}
+
+// Evicter is a session store that is capable of evicting the least recently
+// used sessions, see session.ActiveLimitEvictLRU.
+type Evicter interface {
+	// EvictLRU destroys at most n least recently used sessions, and returns the
+	// number of sessions that are destroyed.
+	EvictLRU(ctx context.Context, n int) (int, error)
+}
+
+// ActiveLimitAction is the action to take when creating a new session would
+// exceed Options.MaxActiveSessions.
+type ActiveLimitAction int
+
+const (
+	// ActiveLimitEphemeral serves ephemeral sessions in place of new sessions,
+	// which are neither persisted to the session store nor written to the client
+	// (see session.IsEphemeral).
+	ActiveLimitEphemeral ActiveLimitAction = iota
+	// ActiveLimitReject responds with 503 Service Unavailable without invoking
+	// other handlers. Sessions deferred by Options.DisableAutoCreate are served
+	// as ephemeral sessions instead, as handlers have been invoked by the time
+	// they are started.
+	ActiveLimitReject
+	// ActiveLimitEvictLRU evicts the least recently used sessions to make room
+	// for new sessions. It requires the session store to implement
+	// session.Evicter, and falls back to ActiveLimitEphemeral otherwise.
+	ActiveLimitEvictLRU
+)
+
+// String returns the name of the action.
+func (a ActiveLimitAction) String() string {
+	switch a {
+	case ActiveLimitEphemeral:
+		return "ephemeral"
+	case ActiveLimitReject:
+		return "reject"
+	case ActiveLimitEvictLRU:
+		return "evict-lru"
+	default:
+		return fmt.Sprintf("ActiveLimitAction(%d)", int(a))
+	}
+}
+
+// ErrTooManySessions is returned when creating a new session is refused
+// because the session store has reached Options.MaxActiveSessions.
+var ErrTooManySessions = errors.New("too many active sessions")
+
+// activeLimit is the limit of the number of sessions in the session store.
+type activeLimit struct {
+	max     int               // The maximum number of sessions
+	action  ActiveLimitAction // The action to take when the limit is reached
+	counter ActiveCounter     // The session store to count sessions
+	evicter Evicter           // The session store to evict sessions, may be nil
+}
+
+// newActiveLimit returns a new limit of active sessions of the session store
+// with given options. It returns nil if the limit is disabled or the session
+// store is not capable of counting sessions.
+func newActiveLimit(store Store, opt Options) *activeLimit {
+	if opt.MaxActiveSessions <= 0 {
+		return nil
+	}
+	caps := Capabilities(store)
+	counter, ok := StoreAs[ActiveCounter](store)
+	if !ok || !caps.CountActive {
+		return nil
+	}
+
+	l := &activeLimit{
+		max:     opt.MaxActiveSessions,
+		action:  opt.MaxActiveAction,
+		counter: counter,
+	}
+	if l.action == ActiveLimitEvictLRU {
+		evicter, ok := StoreAs[Evicter](store)
+		if ok && caps.EvictLRU {
+			l.evicter = evicter
+		} else {
+			l.action = ActiveLimitEphemeral
+		}
+	}
+	return l
+}
+
+// admit returns true if a new session is allowed to be created, or false if an
+// ephemeral session should be served instead. It returns ErrTooManySessions
+// when the creation is rejected.
+func (l *activeLimit) admit(ctx context.Context) (bool, error) {
+	n, err := l.counter.CountActive(ctx)
+	if err != nil {
+		return false, fmt.Errorf("count: %w", err)
+	} else if n < l.max {
+		return true, nil
+	}
+
+	switch l.action {
+	case ActiveLimitReject:
+		return false, ErrTooManySessions
+	case ActiveLimitEvictLRU:
+		_, err = l.evicter.EvictLRU(ctx, n-l.max+1)
+		if err != nil {
+			return false, fmt.Errorf("evict: %w", err)
+		}
+		return true, nil
+	default:
+		return false, nil
+	}
+}
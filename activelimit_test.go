@@ -0,0 +1,128 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestSessioner_MaxActiveSessions(t *testing.T) {
+	newServer := func(opt Options) *flamego.Flame {
+		f := flamego.NewWithLogger(&bytes.Buffer{})
+		opt.MaxActiveSessions = 1
+		opt.GCMode = GCDisabled
+		f.Use(Sessioner(opt))
+		f.Get("/", func(s Session) string {
+			existed := s.Get("username") != nil
+			s.Set("username", "flamego")
+			return strconv.FormatBool(IsEphemeral(s)) + "," + strconv.FormatBool(existed)
+		})
+		return f
+	}
+	get := func(f *flamego.Flame, cookie string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("ephemeral", func(t *testing.T) {
+		for _, disableAutoCreate := range []bool{false, true} {
+			t.Run("disableAutoCreate="+strconv.FormatBool(disableAutoCreate), func(t *testing.T) {
+				f := newServer(Options{DisableAutoCreate: disableAutoCreate})
+
+				resp := get(f, "")
+				assert.Equal(t, "false,false", resp.Body.String())
+				cookie := resp.Header().Get("Set-Cookie")
+				assert.NotEmpty(t, cookie)
+
+				// The existing session is not limited
+				resp = get(f, cookie)
+				assert.Equal(t, "false,true", resp.Body.String())
+
+				resp = get(f, "")
+				assert.Equal(t, "true,false", resp.Body.String())
+				assert.Empty(t, resp.Header().Get("Set-Cookie"))
+			})
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		f := newServer(Options{MaxActiveAction: ActiveLimitReject})
+
+		resp := get(f, "")
+		assert.Equal(t, "false,false", resp.Body.String())
+
+		resp = get(f, "")
+		assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+		assert.Empty(t, resp.Body.String())
+
+		// Deferred sessions are served as ephemeral sessions
+		f = newServer(Options{MaxActiveAction: ActiveLimitReject, DisableAutoCreate: true})
+		resp = get(f, "")
+		assert.Equal(t, "false,false", resp.Body.String())
+		resp = get(f, "")
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "true,false", resp.Body.String())
+	})
+
+	t.Run("evict LRU", func(t *testing.T) {
+		f := newServer(Options{MaxActiveAction: ActiveLimitEvictLRU})
+
+		resp := get(f, "")
+		assert.Equal(t, "false,false", resp.Body.String())
+		first := resp.Header().Get("Set-Cookie")
+
+		resp = get(f, "")
+		assert.Equal(t, "false,false", resp.Body.String())
+		second := resp.Header().Get("Set-Cookie")
+		assert.NotEmpty(t, second)
+
+		// The first session has been evicted to make room for the second one
+		resp = get(f, first)
+		assert.Equal(t, "false,false", resp.Body.String())
+
+		// The second session has been evicted in turn
+		resp = get(f, second)
+		assert.Equal(t, "false,false", resp.Body.String())
+	})
+
+	t.Run("unknown action", func(t *testing.T) {
+		assert.Panics(t, func() { Sessioner(Options{MaxActiveAction: 3}) })
+	})
+}
+
+func TestActiveLimit_Capabilities(t *testing.T) {
+	// The limit is ignored by session stores that cannot count sessions
+	assert.Nil(t, newActiveLimit(&noopStore{}, Options{MaxActiveSessions: 1}))
+
+	// Eviction falls back to ephemeral sessions without session.Evicter
+	store := &namedStore{Store: &countOnlyStore{}}
+	l := newActiveLimit(store, Options{MaxActiveSessions: 1, MaxActiveAction: ActiveLimitEvictLRU})
+	require.NotNil(t, l)
+	assert.Equal(t, ActiveLimitEphemeral, l.action)
+}
+
+type countOnlyStore struct {
+	noopStore
+}
+
+func (*countOnlyStore) CountActive(context.Context) (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,284 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package admin provides helpers and HTTP handlers for inspecting and managing
+// sessions in a session store, which is useful for debugging purposes.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/flamego/flamego"
+	"github.com/flamego/session"
+)
+
+var (
+	// ErrNotExist is returned when the session does not exist in the session
+	// store.
+	ErrNotExist = errors.New("session does not exist")
+	// ErrNotSupported is returned when the session store is not capable of the
+	// operation, i.e. listing sessions or reporting summaries of sessions.
+	ErrNotSupported = errors.New("the operation is not supported by the session store")
+)
+
+// List returns IDs of all sessions in the session store in ascending order.
+func List(ctx context.Context, store session.Store) ([]string, error) {
+	lister, ok := session.StoreAs[session.Lister](store)
+	if !ok || !session.Capabilities(store).List {
+		return nil, ErrNotSupported
+	}
+
+	sids, err := lister.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list: %w", err)
+	}
+	sort.Strings(sids)
+	return sids, nil
+}
+
+// View returns the decoded data of the session with given ID. Keys and values
+// are converted to be suitable for printing. The session is read without side
+// effects when supported by the session store (see session.Peeker and
+// session.MultiReader), other session stores fall back to session.Store.Read,
+// which may extend the lifetime of the session.
+func View(ctx context.Context, store session.Store, sid string) (map[string]interface{}, error) {
+	sess, err := peek(ctx, store, sid)
+	if err != nil {
+		return nil, err
+	}
+
+	ds, ok := sess.(interface{ Data() session.Data })
+	if !ok {
+		return nil, fmt.Errorf("session with the type %T does not expose its data", sess)
+	}
+	return printable(ds.Data()), nil
+}
+
+// peek reads the session with given ID, preferring the ways without side
+// effects. It returns ErrNotExist if the session does not exist.
+func peek(ctx context.Context, store session.Store, sid string) (session.Session, error) {
+	if peeker, ok := session.StoreAs[session.Peeker](store); ok {
+		sess, err := peeker.Peek(ctx, sid)
+		if err != nil {
+			return nil, fmt.Errorf("peek: %w", err)
+		} else if sess == nil {
+			return nil, ErrNotExist
+		}
+		return sess, nil
+	}
+
+	if reader, ok := session.StoreAs[session.MultiReader](store); ok {
+		sessions, err := reader.ReadMany(ctx, []string{sid})
+		if err != nil {
+			return nil, fmt.Errorf("read many: %w", err)
+		}
+		sess, ok := sessions[sid]
+		if !ok {
+			return nil, ErrNotExist
+		}
+		return sess, nil
+	}
+
+	err := checkExist(ctx, store, sid)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := store.Read(ctx, sid)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	return sess, nil
+}
+
+// ViewMany returns the decoded data of sessions with given IDs keyed by session
+// IDs, which are read at once when supported by the session store (see
+// session.ReadMany). Sessions that do not exist are omitted. Like View,
+// sessions are read without side effects when supported by the session store.
+func ViewMany(ctx context.Context, store session.Store, sids []string) (map[string]map[string]interface{}, error) {
+	var sessions map[string]session.Session
+	if peeker, ok := session.StoreAs[session.Peeker](store); ok {
+		sessions = make(map[string]session.Session, len(sids))
+		for _, sid := range sids {
+			sess, err := peeker.Peek(ctx, sid)
+			if err != nil {
+				return nil, fmt.Errorf("peek %q: %w", sid, err)
+			} else if sess != nil {
+				sessions[sid] = sess
+			}
+		}
+	} else {
+		var err error
+		sessions, err = session.ReadMany(ctx, store, sids)
+		if err != nil {
+			return nil, fmt.Errorf("read many: %w", err)
+		}
+	}
+
+	views := make(map[string]map[string]interface{}, len(sessions))
+	for sid, sess := range sessions {
+		ds, ok := sess.(interface{ Data() session.Data })
+		if !ok {
+			return nil, fmt.Errorf("session with the type %T does not expose its data", sess)
+		}
+		views[sid] = printable(ds.Data())
+	}
+	return views, nil
+}
+
+// Summarize returns the summary of the session with given ID, which is read
+// without decoding the session data (see session.Summarizer).
+func Summarize(ctx context.Context, store session.Store, sid string) (*session.Summary, error) {
+	summarizer, ok := session.StoreAs[session.Summarizer](store)
+	if !ok || !session.Capabilities(store).Summarize {
+		return nil, ErrNotSupported
+	}
+
+	summary, err := summarizer.Summarize(ctx, sid)
+	if err != nil {
+		return nil, fmt.Errorf("summarize: %w", err)
+	} else if summary == nil {
+		return nil, ErrNotExist
+	}
+	return summary, nil
+}
+
+// checkExist returns ErrNotExist if the session with given ID does not exist in
+// the session store.
+func checkExist(ctx context.Context, store session.Store, sid string) error {
+	ok, err := session.CheckExist(ctx, store, sid)
+	if err != nil {
+		return fmt.Errorf("check existence: %w", err)
+	} else if !ok {
+		return ErrNotExist
+	}
+	return nil
+}
+
+// printable converts given session data to be suitable for printing. Values
+// that cannot be marshalled as JSON are formatted using fmt.
+func printable(data session.Data) map[string]interface{} {
+	m := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		if _, err := json.Marshal(v); err != nil {
+			v = fmt.Sprintf("%v", v)
+		}
+		m[fmt.Sprintf("%v", k)] = v
+	}
+	return m
+}
+
+// Touch updates the expiry time of the session with given ID.
+func Touch(ctx context.Context, store session.Store, sid string) error {
+	err := checkExist(ctx, store, sid)
+	if err != nil {
+		return err
+	}
+	return store.Touch(ctx, sid)
+}
+
+// Destroy deletes the session with given ID from the session store.
+func Destroy(ctx context.Context, store session.Store, sid string) error {
+	err := checkExist(ctx, store, sid)
+	if err != nil {
+		return err
+	}
+	return store.Destroy(ctx, sid)
+}
+
+// Register registers routes for inspecting and managing sessions using the
+// session.Store injected by the session.Sessioner to the given router. These
+// routes expose session data, and thus must be mounted under an authenticated
+// route group. The following routes are registered:
+//
+//	GET    /               List IDs of all sessions
+//	POST   /view           View the decoded data of sessions whose IDs are in the JSON array body
+//	GET    /{sid}          View the decoded data of a session
+//	GET    /{sid}/summary  View the summary of a session
+//	POST   /{sid}/touch    Update the expiry time of a session
+//	DELETE /{sid}          Destroy a session
+//
+// Example:
+//
+//	f.Group("/admin/sessions", func() {
+//		admin.Register(f)
+//	}, requireAdmin)
+func Register(r flamego.Router) {
+	r.Get("/", func(c flamego.Context, store session.Store) {
+		sids, err := List(c.Request().Context(), store)
+		if err != nil {
+			writeError(c.ResponseWriter(), err)
+			return
+		}
+		writeJSON(c.ResponseWriter(), http.StatusOK, sids)
+	})
+	r.Post("/view", func(c flamego.Context, store session.Store) {
+		var sids []string
+		err := json.NewDecoder(c.Request().Request.Body).Decode(&sids)
+		if err != nil {
+			writeJSON(c.ResponseWriter(), http.StatusBadRequest, map[string]string{"error": "decode IDs: " + err.Error()})
+			return
+		}
+
+		views, err := ViewMany(c.Request().Context(), store, sids)
+		if err != nil {
+			writeError(c.ResponseWriter(), err)
+			return
+		}
+		writeJSON(c.ResponseWriter(), http.StatusOK, views)
+	})
+	r.Get("/{sid}", func(c flamego.Context, store session.Store) {
+		data, err := View(c.Request().Context(), store, c.Param("sid"))
+		if err != nil {
+			writeError(c.ResponseWriter(), err)
+			return
+		}
+		writeJSON(c.ResponseWriter(), http.StatusOK, data)
+	})
+	r.Get("/{sid}/summary", func(c flamego.Context, store session.Store) {
+		summary, err := Summarize(c.Request().Context(), store, c.Param("sid"))
+		if err != nil {
+			writeError(c.ResponseWriter(), err)
+			return
+		}
+		writeJSON(c.ResponseWriter(), http.StatusOK, summary)
+	})
+	r.Post("/{sid}/touch", func(c flamego.Context, store session.Store) {
+		err := Touch(c.Request().Context(), store, c.Param("sid"))
+		if err != nil {
+			writeError(c.ResponseWriter(), err)
+			return
+		}
+		c.ResponseWriter().WriteHeader(http.StatusNoContent)
+	})
+	r.Delete("/{sid}", func(c flamego.Context, store session.Store) {
+		err := Destroy(c.Request().Context(), store, c.Param("sid"))
+		if err != nil {
+			writeError(c.ResponseWriter(), err)
+			return
+		}
+		c.ResponseWriter().WriteHeader(http.StatusNoContent)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotExist):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrNotSupported):
+		status = http.StatusNotImplemented
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
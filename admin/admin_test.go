@@ -0,0 +1,181 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+	"github.com/flamego/session"
+)
+
+func TestRegister(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(session.Sessioner())
+	f.Get("/set", func(s session.Session) string {
+		s.Set("username", "flamego")
+		return s.ID()
+	})
+	f.Group("/admin/sessions", func() {
+		Register(f)
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/set", nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	sid := resp.Body.String()
+
+	// List
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/admin/sessions/", nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var sids []string
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &sids))
+	assert.Contains(t, sids, sid)
+
+	// View
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/admin/sessions/"+sid, nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var data map[string]interface{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &data))
+	assert.Equal(t, "flamego", data["username"])
+
+	// View many
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodPost, "/admin/sessions/view", strings.NewReader(`["`+sid+`", "nonexistent"]`))
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var views map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &views))
+	assert.Len(t, views, 1)
+	assert.Equal(t, "flamego", views[sid]["username"])
+
+	// Summary is not supported by the memory session store
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/admin/sessions/"+sid+"/summary", nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotImplemented, resp.Code)
+
+	// Touch
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodPost, "/admin/sessions/"+sid+"/touch", nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+
+	// Destroy
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodDelete, "/admin/sessions/"+sid, nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+
+	// View after destroyed
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/admin/sessions/"+sid, nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+// summaryStore is a session store that reports summaries of sessions that exist
+// in the underlying session store.
+type summaryStore struct {
+	session.Store
+}
+
+func (s *summaryStore) Summarize(ctx context.Context, sid string) (*session.Summary, error) {
+	if !s.Exist(ctx, sid) {
+		return nil, nil
+	}
+	return &session.Summary{ID: sid, UserID: "alice", Size: 42}, nil
+}
+
+func TestSummarize(t *testing.T) {
+	ctx := context.Background()
+	memory, err := session.MemoryIniter()(ctx, session.MemoryConfig{}, session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}))
+	require.NoError(t, err)
+	store := &summaryStore{Store: memory}
+	_, err = store.Read(ctx, "111")
+	require.NoError(t, err)
+
+	summary, err := Summarize(ctx, store, "111")
+	require.NoError(t, err)
+	assert.Equal(t, &session.Summary{ID: "111", UserID: "alice", Size: 42}, summary)
+
+	_, err = Summarize(ctx, store, "222")
+	assert.ErrorIs(t, err, ErrNotExist)
+
+	_, err = Summarize(ctx, memory, "111")
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestView(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	store, err := session.MemoryIniter()(ctx,
+		session.MemoryConfig{
+			NowFunc:  func() time.Time { return now },
+			Lifetime: time.Hour,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.NoError(t, err)
+
+	sess, err := store.Read(ctx, "111")
+	require.NoError(t, err)
+	sess.Set("username", "flamego")
+
+	// Viewing the session does not extend its lifetime
+	now = now.Add(30 * time.Minute)
+	data, err := View(ctx, store, "111")
+	require.NoError(t, err)
+	assert.Equal(t, "flamego", data["username"])
+	views, err := ViewMany(ctx, store, []string{"111", "222"})
+	require.NoError(t, err)
+	assert.Len(t, views, 1)
+
+	expiresAt, err := store.(session.Expirer).ExpiresAt(ctx, "111")
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1700000000, 0).Add(time.Hour), expiresAt)
+
+	// Nor does it create missing sessions
+	_, err = View(ctx, store, "222")
+	assert.ErrorIs(t, err, ErrNotExist)
+	assert.False(t, store.Exist(ctx, "222"))
+
+	now = now.Add(time.Hour)
+	_, err = View(ctx, store, "111")
+	assert.ErrorIs(t, err, ErrNotExist)
+}
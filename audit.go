@@ -0,0 +1,152 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// AuditOp is the type of operation recorded in an audit entry.
+type AuditOp string
+
+const (
+	AuditOpSet    AuditOp = "set"
+	AuditOpDelete AuditOp = "delete"
+	AuditOpFlush  AuditOp = "flush"
+)
+
+// AuditEntry is a record of a change made to the session data.
+type AuditEntry struct {
+	// SessionID is the ID of the session, which should not be logged as is, use
+	// HashedSessionID instead.
+	SessionID string
+	// HashedSessionID is the hex-encoded prefix of the HMAC-SHA256 of the session
+	// ID with AuditOptions.HashKey, which identifies the session in logs without
+	// revealing the session ID.
+	HashedSessionID string
+	// RequestID is the ID of the request that made the change.
+	RequestID string
+	// Op is the type of the operation.
+	Op AuditOp
+	// Key is the key of the session data, formatted as a string.
+	Key string
+	// OldHash is the hex-encoded HMAC-SHA256 of the value before the change with
+	// AuditOptions.HashKey, or empty if the key did not exist.
+	OldHash string
+	// NewHash is the hex-encoded HMAC-SHA256 of the value after the change with
+	// AuditOptions.HashKey, or empty if the key was removed.
+	NewHash string
+	// Time is the time when the change was made.
+	Time time.Time
+}
+
+// AuditSink is a destination of audit entries, e.g. a database table or a log.
+type AuditSink func(ctx context.Context, entries []AuditEntry) error
+
+// LogAuditSink returns an AuditSink that writes each audit entry as a line to
+// the given logger. Sessions are identified by AuditEntry.HashedSessionID.
+func LogAuditSink(logger *log.Logger) AuditSink {
+	return func(_ context.Context, entries []AuditEntry) error {
+		for _, e := range entries {
+			logger.Printf("session=%s request=%s op=%s key=%q old=%s new=%s time=%s",
+				e.HashedSessionID, e.RequestID, e.Op, e.Key, e.OldHash, e.NewHash, e.Time.Format(time.RFC3339Nano),
+			)
+		}
+		return nil
+	}
+}
+
+// AuditOptions contains options for auditing changes made to the session data.
+type AuditOptions struct {
+	// Sink is the destination of audit entries, which are emitted after the
+	// session is saved. Default is not set, i.e. auditing is disabled.
+	Sink AuditSink
+	// RequestIDFunc is the function to read the request ID from the request.
+	// Default is reading the "X-Request-Id" header.
+	RequestIDFunc func(r *http.Request) string
+	// HashKey is the key of the HMAC-SHA256 to hash session IDs and values in
+	// audit entries, as well as session IDs in session.Info, which prevents
+	// guessing values of low entropy from their hashes. Hashes are only
+	// comparable between audit entries with the same key.
+	// Default is a random key generated on start, i.e. hashes are not comparable
+	// across restarts of the application, and session IDs are not hashed in
+	// session.Info.
+	HashKey []byte
+}
+
+// journalEntry is a raw record of a change made to the session data.
+type journalEntry struct {
+	op       AuditOp
+	key      interface{}
+	old, new interface{}
+	hasOld   bool
+	hasNew   bool
+	time     time.Time
+}
+
+// auditor is a session that is capable of journaling changes made to the
+// session data.
+type auditor interface {
+	// startAudit starts journaling changes made to the session data and discards
+	// any existing journal.
+	startAudit()
+	// takeJournal returns the journal and stops journaling.
+	takeJournal() []journalEntry
+}
+
+// hashValue returns the hex-encoded HMAC-SHA256 of given value with the key.
+// The value is encoded by the DeterministicGobEncoder, and falls back to its Go
+// syntax representation for types that cannot be encoded (e.g. not registered
+// to Gob).
+func hashValue(key []byte, v interface{}) string {
+	binary, err := DeterministicGobEncoder(Data{"": v})
+	if err != nil {
+		binary = []byte(fmt.Sprintf("%T:%#v", v, v))
+	}
+
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(binary)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashSessionID returns the hex-encoded prefix of the HMAC-SHA256 of the
+// session ID with the key.
+func hashSessionID(key []byte, sid string) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(sid))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// auditEntries converts the journal to audit entries, where values are hashed
+// with the key.
+func auditEntries(sid, requestID string, journal []journalEntry, key []byte) []AuditEntry {
+	hashedSID := hashSessionID(key, sid)
+	entries := make([]AuditEntry, 0, len(journal))
+	for _, j := range journal {
+		e := AuditEntry{
+			SessionID:       sid,
+			HashedSessionID: hashedSID,
+			RequestID:       requestID,
+			Op:              j.op,
+			Key:             fmt.Sprintf("%v", j.key),
+			Time:            j.time,
+		}
+		if j.hasOld {
+			e.OldHash = hashValue(key, j.old)
+		}
+		if j.hasNew {
+			e.NewHash = hashValue(key, j.new)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
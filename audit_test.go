@@ -0,0 +1,176 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestSessioner_Audit(t *testing.T) {
+	var entries []AuditEntry
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Audit: AuditOptions{
+				Sink: func(_ context.Context, e []AuditEntry) error {
+					entries = append(entries, e...)
+					return nil
+				},
+				HashKey: []byte("audit-key"),
+			},
+		},
+	))
+	f.Get("/", func(s Session) {
+		s.Set("username", "flamego")
+		s.Set("username", "flamego2")
+		s.Delete("username")
+		s.Set("remark", "remark")
+		s.Flush()
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Request-Id", "req-1")
+
+	f.ServeHTTP(resp, req)
+	require.Len(t, entries, 5)
+
+	want := []struct {
+		op     AuditOp
+		key    string
+		hasOld bool
+		hasNew bool
+	}{
+		{AuditOpSet, "username", false, true},
+		{AuditOpSet, "username", true, true},
+		{AuditOpDelete, "username", true, false},
+		{AuditOpSet, "remark", false, true},
+		{AuditOpFlush, "remark", true, false},
+	}
+	for i, w := range want {
+		e := entries[i]
+		assert.Equal(t, "req-1", e.RequestID)
+		assert.NotEmpty(t, e.SessionID)
+		assert.Equal(t, w.op, e.Op)
+		assert.Equal(t, w.key, e.Key)
+		assert.Equal(t, w.hasOld, e.OldHash != "")
+		assert.Equal(t, w.hasNew, e.NewHash != "")
+		assert.False(t, e.Time.IsZero())
+	}
+	assert.Equal(t, entries[0].NewHash, entries[1].OldHash)
+	assert.Equal(t, hashValue([]byte("audit-key"), "flamego"), entries[0].NewHash)
+	assert.NotEqual(t, hashValue([]byte("other-key"), "flamego"), entries[0].NewHash)
+	assert.Equal(t, hashSessionID([]byte("audit-key"), entries[0].SessionID), entries[0].HashedSessionID)
+}
+
+func TestSessioner_AuditConcurrentRequests(t *testing.T) {
+	var mu sync.Mutex
+	entries := make(map[string][]AuditEntry)
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Audit: AuditOptions{
+				Sink: func(_ context.Context, e []AuditEntry) error {
+					mu.Lock()
+					defer mu.Unlock()
+					for _, entry := range e {
+						entries[entry.RequestID] = append(entries[entry.RequestID], entry)
+					}
+					return nil
+				},
+			},
+		},
+	))
+	f.Get("/", func(s Session) {
+		s.Set("fast", true)
+	})
+	started, proceed := make(chan struct{}), make(chan struct{})
+	f.Get("/slow", func(s Session) {
+		s.Set("slow", true)
+		close(started)
+		<-proceed
+		s.Set("slow", false)
+	})
+
+	var cookie *http.Cookie
+	request := func(path, requestID string) {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Request-Id", requestID)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if cookies := resp.Result().Cookies(); len(cookies) > 0 {
+			cookie = cookies[0]
+		}
+	}
+	request("/", "req-1")
+
+	// Each request only journals its own changes
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		request("/slow", "req-2")
+	}()
+	<-started
+	request("/", "req-3")
+	close(proceed)
+	<-done
+
+	keys := func(requestID string) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		var keys []string
+		for _, e := range entries[requestID] {
+			keys = append(keys, e.Key)
+		}
+		return keys
+	}
+	assert.Equal(t, []string{"slow", "slow"}, keys("req-2"))
+	assert.Equal(t, []string{"fast"}, keys("req-3"))
+}
+
+func TestHashValue(t *testing.T) {
+	key := []byte("audit-key")
+	assert.Equal(t,
+		hashValue(key, Data{"a": 1, "b": 2, "c": 3}),
+		hashValue(key, Data{"c": 3, "b": 2, "a": 1}),
+	)
+	assert.NotEqual(t, hashValue(key, int64(1)), hashValue(key, "1"))
+
+	// Types that cannot be encoded are hashed by their Go syntax representation
+	type unregistered struct{ Name string }
+	assert.Equal(t, hashValue(key, unregistered{"a"}), hashValue(key, unregistered{"a"}))
+	assert.NotEqual(t, hashValue(key, unregistered{"a"}), hashValue(key, unregistered{"b"}))
+}
+
+func TestLogAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	err := LogAuditSink(log.New(&buf, "", 0))(context.Background(), []AuditEntry{
+		{
+			SessionID:       "ad2c7e3cbf9a1d05",
+			HashedSessionID: "5e884898da280471",
+			Op:              AuditOpSet,
+			Key:             "username",
+		},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "session=5e884898da280471 ")
+	assert.NotContains(t, buf.String(), "ad2c7e3cbf9a1d05")
+}
@@ -0,0 +1,393 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/flamego/flamego"
+)
+
+// ImpersonatorTag is the key of the session tag that holds the ID of the
+// original user of an impersonating session (see session.Impersonate). It is
+// reserved outside of the MetadataTagPrefix, thus cannot be produced by the
+// Options.MetadataFunc.
+const ImpersonatorTag = "flamego::impersonator"
+
+var (
+	// ErrNotSignedIn is returned when an operation requires a signed-in user.
+	ErrNotSignedIn = errors.New("not signed in")
+	// ErrNotImpersonating is returned when stopping impersonation of a session
+	// that is not impersonating.
+	ErrNotImpersonating = errors.New("not impersonating")
+)
+
+// fromContext returns the session and the session store injected into the
+// request context by the session.Sessioner.
+func fromContext(c flamego.Context) (Session, Store, error) {
+	sess := c.Value(reflect.TypeOf((*Session)(nil)).Elem())
+	if !sess.IsValid() {
+		return nil, nil, errors.New("no session in the request context, is session.Sessioner used?")
+	}
+	store := c.Value(reflect.TypeOf((*Store)(nil)).Elem())
+	if !store.IsValid() {
+		return nil, nil, errors.New("no session store in the request context, is session.Sessioner used?")
+	}
+	return sess.Interface().(Session), store.Interface().(Store), nil
+}
+
+// renew regenerates the ID of the session and destroys the session with the old
+// ID in the session store through the manager of the session.Sessioner, which
+// prevents session fixation attacks.
+func renew(c flamego.Context, s Session, store Store) error {
+	started := IsStarted(s)
+	oldSID := s.ID()
+	err := s.RegenerateID(c.ResponseWriter(), c.Request().Request)
+	if err != nil {
+		return fmt.Errorf("regenerate ID: %w", err)
+	}
+	if !started {
+		return nil
+	}
+
+	destroy := store.Destroy
+	if state := requestStateOf(c); state != nil {
+		destroy = state.destroy
+	}
+	err = destroy(c.Request().Context(), oldSID)
+	if err != nil {
+		return fmt.Errorf("destroy %q: %w", oldSID, err)
+	}
+	return nil
+}
+
+// RequireFreshID is a middleware that refuses to accept session IDs presented
+// by clients on routes where session fixation attacks matter, e.g. sign in and
+// sign up. The session ID is regenerated unless it was issued by the current
+// request, and the session with the old ID is destroyed. The session data is
+// kept. It must be used after the session.Sessioner.
+//
+// Example:
+//
+//	f.Post("/login", session.RequireFreshID, handleLogin)
+func RequireFreshID(c flamego.Context) {
+	s, store, err := fromContext(c)
+	if err != nil {
+		panic("session: " + err.Error())
+	}
+
+	state := requestStateOf(c)
+	if IsEphemeral(s) || state != nil && state.freshID {
+		return
+	}
+
+	err = renew(c, s, store)
+	if err != nil {
+		panic("session: require fresh ID: " + err.Error())
+	}
+}
+
+// SignIn signs in the user with given ID to the current session, after
+// regenerating the session ID to prevent session fixation attacks. The session
+// is also bound to the user (see session.BindUser). It must be called after
+// the session.Sessioner.
+func SignIn(c flamego.Context, userID string) error {
+	if userID == "" {
+		return errors.New("empty user ID")
+	}
+
+	s, store, err := fromContext(c)
+	if err != nil {
+		return err
+	}
+
+	err = renew(c, s, store)
+	if err != nil {
+		return fmt.Errorf("renew: %w", err)
+	}
+
+	setInternal(s, authKey, Data{
+		"user_id":      userID,
+		"signed_in_at": nowOf(s).UnixNano(),
+	})
+	BindUser(s, userID)
+	Tag(s, ImpersonatorTag, "")
+	return nil
+}
+
+// PromoteOptions contains options for session.Promote.
+type PromoteOptions struct {
+	// Keep is the list of keys of the session data to be carried over from the
+	// guest session, e.g. the shopping cart. The preferred locale and time zone
+	// (see session.SetLocale and session.SetTimeZone) and Options.PreserveKeys
+	// are always carried over. Values set with a TTL are carried over without the
+	// TTL.
+	Keep []interface{}
+}
+
+// Promote upgrades the current guest session to an authenticated session of
+// the user with given ID. It is like session.SignIn, but the session data is
+// flushed before signing in except for the keys allow-listed by the options,
+// so that data planted in the guest session (e.g. by an attacker who fixated
+// the session) is never inherited by the authenticated session. It must be
+// called after the session.Sessioner.
+//
+// Example:
+//
+//	err := session.Promote(c, userID, session.PromoteOptions{Keep: []interface{}{"cart"}})
+func Promote(c flamego.Context, userID string, opts ...PromoteOptions) error {
+	if userID == "" {
+		return errors.New("empty user ID")
+	}
+
+	var opt PromoteOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	s, _, err := fromContext(c)
+	if err != nil {
+		return err
+	}
+
+	kept := preservedData(s, append([]interface{}{localeKey, timeZoneKey}, opt.Keep...))
+	s.Flush()
+	err = SignIn(c, userID)
+	if err != nil {
+		return err
+	}
+	for key, val := range kept {
+		setInternal(s, key, val)
+	}
+	return nil
+}
+
+// SignOut signs out the current session by flushing the session data, unbinding
+// the user and regenerating the session ID. It must be called after the
+// session.Sessioner.
+func SignOut(c flamego.Context) error {
+	s, store, err := fromContext(c)
+	if err != nil {
+		return err
+	}
+
+	if !IsStarted(s) {
+		return nil
+	}
+	s.Flush()
+	signOut(s)
+
+	err = renew(c, s, store)
+	if err != nil {
+		return fmt.Errorf("renew: %w", err)
+	}
+	return nil
+}
+
+// signOut clears the authentication state of the session that is kept across
+// Flush, including the idle lock that only makes sense for signed-in users.
+func signOut(s Session) {
+	if s.Get(authKey) != nil {
+		deleteInternal(s, authKey)
+	}
+	Unlock(s)
+	BindUser(s, "")
+	Tag(s, ImpersonatorTag, "")
+}
+
+// CurrentUser returns the ID of the user that is signed in to the current
+// session via session.SignIn, or empty if not signed in.
+func CurrentUser(c flamego.Context) string {
+	s, _, err := fromContext(c)
+	if err != nil {
+		return ""
+	}
+	return currentUser(s)
+}
+
+// currentUser returns the ID of the user that is signed in to the session.
+func currentUser(s Session) string {
+	auth, _ := s.Get(authKey).(Data)
+	userID, _ := auth["user_id"].(string)
+	return userID
+}
+
+// Impersonator returns the ID of the original user of the session that is
+// impersonating another user, or empty if the session is not impersonating.
+func Impersonator(s Session) string {
+	return TagsOf(s)[ImpersonatorTag]
+}
+
+// impersonatable is a session that is capable of impersonating users.
+type impersonatable interface {
+	// impersonate makes the signed-in user act as the target user.
+	impersonate(targetUserID string) error
+	// stopImpersonation restores the identity before the last impersonate.
+	stopImpersonation() error
+}
+
+// Impersonate makes the user signed in to the session via session.SignIn act as
+// the target user, and stacks the original identity to be restored by
+// session.StopImpersonation. The session is marked as impersonated by the
+// session.ImpersonatorTag. It returns session.ErrNotSignedIn if no user is
+// signed in.
+func Impersonate(s Session, targetUserID string) error {
+	i, ok := rootOf(s).(impersonatable)
+	if !ok {
+		return fmt.Errorf("session with the type %T does not support impersonation", s)
+	}
+	return i.impersonate(targetUserID)
+}
+
+// StopImpersonation restores the identity of the session before the last
+// session.Impersonate. It returns session.ErrNotImpersonating if the session is
+// not impersonating.
+func StopImpersonation(s Session) error {
+	i, ok := rootOf(s).(impersonatable)
+	if !ok {
+		return ErrNotImpersonating
+	}
+	return i.stopImpersonation()
+}
+
+func (s *BaseSession) impersonate(targetUserID string) error {
+	if targetUserID == "" {
+		return errors.New("empty target user ID")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.syncBindings()
+
+	auth, _ := s.data[authKey].(Data)
+	userID, _ := auth["user_id"].(string)
+	if userID == "" {
+		return ErrNotSignedIn
+	}
+
+	impersonators := append(impersonatorsOf(auth), userID)
+	updated := make(Data, len(auth)+2)
+	for k, v := range auth {
+		updated[k] = v
+	}
+	updated["user_id"] = targetUserID
+	updated["impersonators"] = impersonators
+	updated["impersonated_at"] = s.now().UnixNano()
+	s.setAuth(updated)
+
+	s.tags[UserTag] = targetUserID
+	s.tags[ImpersonatorTag] = impersonators[0]
+	return nil
+}
+
+func (s *BaseSession) stopImpersonation() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.syncBindings()
+
+	auth, _ := s.data[authKey].(Data)
+	impersonators := impersonatorsOf(auth)
+	if len(impersonators) == 0 {
+		return ErrNotImpersonating
+	}
+
+	userID := impersonators[len(impersonators)-1]
+	impersonators = impersonators[:len(impersonators)-1]
+	updated := make(Data, len(auth))
+	for k, v := range auth {
+		updated[k] = v
+	}
+	updated["user_id"] = userID
+	if len(impersonators) > 0 {
+		updated["impersonators"] = impersonators
+	} else {
+		delete(updated, "impersonators")
+		delete(updated, "impersonated_at")
+	}
+	s.setAuth(updated)
+
+	s.tags[UserTag] = userID
+	if len(impersonators) == 0 {
+		delete(s.tags, ImpersonatorTag)
+	}
+	return nil
+}
+
+// setAuth replaces the authentication state of the session, and makes sure the
+// tags are initialized. It is not concurrent-safe and is the caller's
+// responsibility to ensure the lock is held.
+func (s *BaseSession) setAuth(auth Data) {
+	s.changed = true
+	s.record(AuditOpSet, authKey, auth, true)
+	s.data[authKey] = auth
+	s.loadBindings()
+
+	if s.tags == nil {
+		s.tags = make(map[string]string)
+	}
+}
+
+// impersonatorsOf returns a copy of the stack of original identities in the
+// authentication state, which is decoded as []interface{} by encoders other than
+// Gob, e.g. JSON.
+func impersonatorsOf(auth Data) []string {
+	switch v := auth["impersonators"].(type) {
+	case []string:
+		return append([]string(nil), v...)
+	case []interface{}:
+		impersonators := make([]string, 0, len(v))
+		for _, id := range v {
+			if id, ok := id.(string); ok {
+				impersonators = append(impersonators, id)
+			}
+		}
+		return impersonators
+	}
+	return nil
+}
+
+// int64Of returns the value as an int64 if it is of any numeric kind, which
+// tolerates numbers decoded as other types by encoders other than Gob, e.g.
+// float64 by JSON.
+func int64Of(v interface{}) (int64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return int64(rv.Float()), true
+	}
+	return 0, false
+}
+
+// expireImpersonation restores the original identity of the session when the
+// impersonation has lasted for the TTL as of now. The session data is flushed
+// if the original identity could not be restored.
+func expireImpersonation(s Session, ttl time.Duration, now time.Time) {
+	auth, _ := s.Get(authKey).(Data)
+	impersonatedAt, ok := int64Of(auth["impersonated_at"])
+	if !ok || now.Sub(time.Unix(0, impersonatedAt)) < ttl {
+		return
+	}
+
+	for {
+		err := StopImpersonation(s)
+		if err == nil {
+			continue
+		} else if errors.Is(err, ErrNotImpersonating) && currentUser(s) != "" {
+			return
+		}
+
+		// Never leave the session acting as the target user
+		s.Flush()
+		signOut(s)
+		return
+	}
+}
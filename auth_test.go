@@ -0,0 +1,329 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestSignIn(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Config: FileConfig{
+				RootDir: t.TempDir(),
+			},
+			Initer: FileIniter(),
+		},
+	))
+	f.Get("/", func(c flamego.Context) string {
+		return CurrentUser(c)
+	})
+	f.Get("/sign-in", func(c flamego.Context) {
+		require.NoError(t, SignIn(c, "alice"))
+	})
+	f.Get("/sign-out", func(c flamego.Context) {
+		require.NoError(t, SignOut(c))
+	})
+
+	var cookie string
+	request := func(path string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if c := resp.Header().Get("Set-Cookie"); c != "" {
+			cookie = strings.Split(c, ";")[0]
+		}
+		return resp
+	}
+
+	resp := request("/")
+	assert.Empty(t, resp.Body.String())
+	anonymous := cookie
+
+	// Signing in regenerates the session ID
+	request("/sign-in")
+	assert.NotEqual(t, anonymous, cookie)
+	resp = request("/")
+	assert.Equal(t, "alice", resp.Body.String())
+
+	// The session with the old ID is no longer signed in
+	signedIn := cookie
+	cookie = anonymous
+	resp = request("/")
+	assert.Empty(t, resp.Body.String())
+
+	cookie = signedIn
+	request("/sign-out")
+	assert.NotEqual(t, signedIn, cookie)
+	resp = request("/")
+	assert.Empty(t, resp.Body.String())
+}
+
+// flakyDestroyStore is a session store whose Destroy fails once with a
+// transient error.
+type flakyDestroyStore struct {
+	Store
+	failed bool
+}
+
+func (s *flakyDestroyStore) Destroy(ctx context.Context, sid string) error {
+	if !s.failed {
+		s.failed = true
+		return syscall.ECONNRESET
+	}
+	return s.Store.Destroy(ctx, sid)
+}
+
+func TestSignIn_Retry(t *testing.T) {
+	var store *flakyDestroyStore
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			StoreWrappers: []StoreMiddleware{
+				func(s Store) Store {
+					store = &flakyDestroyStore{Store: s}
+					return store
+				},
+			},
+			Retry: RetryPolicy{
+				Attempts: 2,
+				Backoff:  func(int) time.Duration { return 0 },
+			},
+		},
+	))
+	f.Get("/", func(s Session) {
+		s.Set("visited", true)
+	})
+	f.Get("/sign-in", func(c flamego.Context) {
+		require.NoError(t, SignIn(c, "alice"))
+	})
+
+	var cookie string
+	request := func(path string) {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if c := resp.Header().Get("Set-Cookie"); c != "" {
+			cookie = strings.Split(c, ";")[0]
+		}
+	}
+
+	request("/")
+	// Destroying the session with the old ID is retried by the manager
+	request("/sign-in")
+	assert.True(t, store.failed)
+}
+
+func TestPromote(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Config: FileConfig{
+				RootDir: t.TempDir(),
+			},
+			Initer: FileIniter(),
+		},
+	))
+	f.Get("/guest", func(s Session) {
+		s.Set("cart", []string{"apple"})
+		s.Set("role", "admin") // Planted by an attacker
+		require.NoError(t, SetLocale(s, "en-NZ"))
+	})
+	f.Get("/promote", func(c flamego.Context) {
+		require.NoError(t, Promote(c, "alice", PromoteOptions{Keep: []interface{}{"cart"}}))
+	})
+	f.Get("/", func(c flamego.Context, s Session) string {
+		return fmt.Sprintf("%s %v %v %s", CurrentUser(c), s.Get("cart"), s.Get("role"), LocaleOf(s))
+	})
+
+	var cookie string
+	request := func(path string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if c := resp.Header().Get("Set-Cookie"); c != "" {
+			cookie = strings.Split(c, ";")[0]
+		}
+		return resp
+	}
+
+	request("/guest")
+	guest := cookie
+
+	request("/promote")
+	assert.NotEqual(t, guest, cookie)
+	resp := request("/")
+	assert.Equal(t, "alice [apple] <nil> en-NZ", resp.Body.String())
+
+	// The guest session is gone
+	cookie = guest
+	resp = request("/")
+	assert.Equal(t, " <nil> <nil> ", resp.Body.String())
+}
+
+func TestCurrentUser_WithoutSessioner(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Get("/", func(c flamego.Context) string {
+		assert.Error(t, SignIn(c, "alice"))
+		return CurrentUser(c)
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	assert.Empty(t, resp.Body.String())
+}
+
+func TestBaseSession_Impersonate(t *testing.T) {
+	s := NewBaseSession("1", GobEncoder, nil)
+	assert.Equal(t, ErrNotSignedIn, Impersonate(s, "alice"))
+	assert.Equal(t, ErrNotImpersonating, StopImpersonation(s))
+
+	setInternal(s, authKey, Data{"user_id": "admin"})
+	require.NoError(t, Impersonate(s, "alice"))
+	require.NoError(t, Impersonate(s, "bob"))
+	assert.Equal(t, "bob", currentUser(s))
+	assert.Equal(t, "bob", UserOf(s))
+	assert.Equal(t, "admin", Impersonator(s))
+
+	// The stack of original identities survives encoding
+	binary, err := s.Encode()
+	require.NoError(t, err)
+	data, err := GobDecoder(binary)
+	require.NoError(t, err)
+	s = NewBaseSessionWithData("1", GobEncoder, nil, data)
+	s.LoadTags(map[string]string{UserTag: "bob", ImpersonatorTag: "admin"})
+
+	require.NoError(t, StopImpersonation(s))
+	assert.Equal(t, "alice", currentUser(s))
+	assert.Equal(t, "admin", Impersonator(s))
+
+	require.NoError(t, StopImpersonation(s))
+	assert.Equal(t, "admin", currentUser(s))
+	assert.Equal(t, "admin", UserOf(s))
+	assert.Empty(t, Impersonator(s))
+	assert.Equal(t, ErrNotImpersonating, StopImpersonation(s))
+}
+
+func TestExpireImpersonation(t *testing.T) {
+	s := NewBaseSession("1", GobEncoder, nil)
+	setInternal(s, authKey, Data{"user_id": "admin"})
+	require.NoError(t, Impersonate(s, "alice"))
+	require.NoError(t, Impersonate(s, "bob"))
+
+	expireImpersonation(s, time.Hour, time.Now())
+	assert.Equal(t, "bob", currentUser(s))
+
+	auth := s.Get(authKey).(Data)
+	auth["impersonated_at"] = time.Now().Add(-2 * time.Hour).UnixNano()
+	expireImpersonation(s, time.Hour, time.Now())
+	assert.Equal(t, "admin", currentUser(s))
+	assert.Empty(t, Impersonator(s))
+
+	// The session is flushed when the original identity is missing
+	setInternal(s, authKey, Data{
+		"impersonated_at": time.Now().Add(-2 * time.Hour).UnixNano(),
+	})
+	expireImpersonation(s, time.Hour, time.Now())
+	assert.Nil(t, s.Get(authKey))
+}
+
+// jsonEncoder encodes the session data as JSON with keys formatted as strings.
+func jsonEncoder(data Data) ([]byte, error) {
+	var convert func(data Data) map[string]interface{}
+	convert = func(data Data) map[string]interface{} {
+		m := make(map[string]interface{}, len(data))
+		for k, v := range data {
+			if nested, ok := v.(Data); ok {
+				v = convert(nested)
+			}
+			m[fmt.Sprintf("%v", k)] = v
+		}
+		return m
+	}
+	return json.Marshal(convert(data))
+}
+
+// jsonDecoder decodes the session data encoded by the jsonEncoder, objects are
+// decoded as nested session data.
+func jsonDecoder(binary []byte) (Data, error) {
+	var m map[string]interface{}
+	err := json.Unmarshal(binary, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	var convert func(m map[string]interface{}) Data
+	convert = func(m map[string]interface{}) Data {
+		data := make(Data, len(m))
+		for k, v := range m {
+			if nested, ok := v.(map[string]interface{}); ok {
+				v = convert(nested)
+			}
+			data[k] = v
+		}
+		return data
+	}
+	return convert(m), nil
+}
+
+func TestBaseSession_Impersonate_JSON(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := NewBaseSession("1", jsonEncoder, nil)
+	s.setRequestContext(&requestContext{now: func() time.Time { return now }})
+	setInternal(s, authKey, Data{"user_id": "admin"})
+	require.NoError(t, Impersonate(s, "alice"))
+	require.NoError(t, Impersonate(s, "bob"))
+	assert.Equal(t, now.UnixNano(), s.Get(authKey).(Data)["impersonated_at"])
+
+	binary, err := s.Encode()
+	require.NoError(t, err)
+	data, err := jsonDecoder(binary)
+	require.NoError(t, err)
+	s = NewBaseSessionWithData("1", jsonEncoder, nil, data)
+	s.setRequestContext(&requestContext{now: func() time.Time { return now }})
+	s.LoadTags(map[string]string{UserTag: "bob", ImpersonatorTag: "admin"})
+
+	// Numbers are decoded as float64 and slices as []interface{}
+	expireImpersonation(s, time.Hour, now.Add(30*time.Minute))
+	assert.Equal(t, "bob", currentUser(s))
+
+	require.NoError(t, StopImpersonation(s))
+	assert.Equal(t, "alice", currentUser(s))
+	assert.Equal(t, "admin", Impersonator(s))
+
+	require.NoError(t, Impersonate(s, "bob"))
+	expireImpersonation(s, time.Hour, now.Add(2*time.Hour))
+	assert.Equal(t, "admin", currentUser(s))
+	assert.Empty(t, Impersonator(s))
+}
@@ -0,0 +1,196 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// binder is a session that is capable of binding its data to structs.
+type binder interface {
+	// bind returns the pointer to the struct with given type that is bound to the
+	// session data.
+	bind(typ reflect.Type) reflect.Value
+	// unbind writes changes made to bound structs back to the session data and
+	// releases all bound structs.
+	unbind()
+}
+
+// Bind returns a pointer to the struct with the type T that is bound to the
+// session data, where each exported field is mapped to the session key of the
+// same name or the name specified by the `session` struct tag. Fields with the
+// tag `session:"-"` are ignored. Changes made to the struct are detected and
+// written back to the session data automatically, and thus saved at the end of
+// the request. Calling Bind multiple times with the same type in the same
+// request returns the same pointer, and the pointer must not be retained after
+// the request.
+//
+// Example:
+//
+//	type Model struct {
+//		UserID   int64  `session:"user_id"`
+//		Username string `session:"username"`
+//	}
+//
+//	m := session.Bind[Model](s)
+//	m.Username = "flamego"
+//
+// It panics if T is not a struct or the session does not support binding.
+// Binding a session that has not been started starts it.
+func Bind[T any](s Session) *T {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("session: bind: want a struct but got %s", typ))
+	}
+
+	b, ok := s.(binder)
+	if !ok {
+		panic(fmt.Sprintf("session: bind: session with the type %T does not support binding", s))
+	}
+	return b.bind(typ).Interface().(*T)
+}
+
+// binding is a struct bound to the session data.
+type binding struct {
+	ptr    reflect.Value // The pointer to the struct
+	keys   []string      // The session keys of the struct fields, empty for ignored fields
+	loaded []interface{} // The copies of the struct fields when last loaded or synced
+}
+
+// newBinding returns a new binding of given struct type.
+func newBinding(typ reflect.Type) *binding {
+	keys := make([]string, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		key := field.Tag.Get("session")
+		switch key {
+		case "-":
+			continue
+		case "":
+			key = field.Name
+		}
+		keys[i] = key
+	}
+
+	return &binding{
+		ptr:    reflect.New(typ),
+		keys:   keys,
+		loaded: make([]interface{}, len(keys)),
+	}
+}
+
+// load loads values from given data to the struct. Fields whose keys do not
+// exist in the data or have unassignable values are reset to zero values.
+// Values are deep copied so that in-place changes made to maps and slices of
+// the struct are detected by sync.
+func (b *binding) load(data Data) {
+	v := b.ptr.Elem()
+	for i, key := range b.keys {
+		if key == "" {
+			continue
+		}
+
+		field := v.Field(i)
+		val, ok := data[key]
+		if !ok || val == nil || !reflect.TypeOf(val).AssignableTo(field.Type()) {
+			field.Set(reflect.Zero(field.Type()))
+		} else {
+			field.Set(deepCopy(reflect.ValueOf(val)))
+		}
+		b.loaded[i] = deepCopy(field).Interface()
+	}
+}
+
+// sync writes values of the struct that have changed since last loaded or
+// synced back to given data, and calls the `record` before each value is
+// written. Fields that are left unchanged never overwrite the data, which may
+// have been changed via other views of the session. It returns true if any
+// value has been written.
+func (b *binding) sync(data Data, record func(op AuditOp, key, val interface{}, hasVal bool)) (changed bool) {
+	v := b.ptr.Elem()
+	for i, key := range b.keys {
+		if key == "" {
+			continue
+		}
+
+		field := v.Field(i)
+		if reflect.DeepEqual(b.loaded[i], field.Interface()) {
+			continue
+		}
+
+		val := deepCopy(field).Interface()
+		record(AuditOpSet, key, val, true)
+		data[key] = val
+		b.loaded[i] = deepCopy(field).Interface()
+		changed = true
+	}
+	return changed
+}
+
+// deepCopy returns a deep copy of given value, where maps, slices, arrays,
+// pointers, interfaces and exported struct fields are copied recursively.
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		dst := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			dst.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+		return dst
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		dst := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			dst.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return dst
+
+	case reflect.Array:
+		dst := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			dst.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return dst
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		dst := reflect.New(v.Type().Elem())
+		dst.Elem().Set(deepCopy(v.Elem()))
+		return dst
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		dst := reflect.New(v.Type()).Elem()
+		dst.Set(deepCopy(v.Elem()))
+		return dst
+
+	case reflect.Struct:
+		dst := reflect.New(v.Type()).Elem()
+		dst.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if dst.Field(i).CanSet() {
+				dst.Field(i).Set(deepCopy(v.Field(i)))
+			}
+		}
+		return dst
+	}
+	return v
+}
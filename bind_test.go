@@ -0,0 +1,148 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+type testModel struct {
+	UserID   int64  `session:"user_id"`
+	Username string `session:"username"`
+	Ignored  string `session:"-"`
+	Remark   string
+}
+
+func TestBind(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner())
+	f.Get("/set", func(s Session) {
+		m := Bind[testModel](s)
+		assert.Same(t, m, Bind[testModel](s))
+
+		m.UserID = 1
+		m.Username = "flamego"
+		m.Ignored = "ignored"
+		assert.Equal(t, "flamego", s.Get("username"))
+
+		// Changes made via the session should be reflected to the struct
+		s.Set("Remark", "remark")
+		assert.Equal(t, "remark", m.Remark)
+	})
+	f.Get("/get", func(s Session) {
+		m := Bind[testModel](s)
+		assert.Equal(t, testModel{UserID: 1, Username: "flamego", Remark: "remark"}, *m)
+		assert.Nil(t, s.Get("Ignored"))
+
+		s.Delete("username")
+		assert.Empty(t, m.Username)
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/set", nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	cookie := resp.Header().Get("Set-Cookie")
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/get", nil)
+	require.NoError(t, err)
+
+	req.Header.Set("Cookie", cookie)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestBind_HasChanged(t *testing.T) {
+	sess := NewBaseSessionWithData("1", GobEncoder, nil, Data{"username": "flamego"})
+
+	m := Bind[testModel](sess)
+	assert.False(t, sess.HasChanged())
+
+	m.Username = "flamego"
+	assert.False(t, sess.HasChanged())
+
+	m.UserID = 1
+	assert.True(t, sess.HasChanged())
+	assert.Equal(t, int64(1), sess.Get("user_id"))
+}
+
+func TestBind_InPlaceChanges(t *testing.T) {
+	type model struct {
+		Roles []string `session:"roles"`
+		Prefs Data     `session:"prefs"`
+	}
+
+	store, err := FileIniter()(context.Background(),
+		FileConfig{
+			RootDir: t.TempDir(),
+		},
+		IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.NoError(t, err)
+
+	var sess Session = NewBaseSessionWithData("ad2c7e3cbf9a1d05", GobEncoder, nil, Data{
+		"roles": []string{"reader"},
+		"prefs": Data{"theme": "light"},
+	})
+	require.NoError(t, store.Save(context.Background(), sess))
+
+	read := func() Session {
+		sess, err := store.Read(context.Background(), "ad2c7e3cbf9a1d05")
+		require.NoError(t, err)
+		return sess
+	}
+
+	sess = read()
+	m := Bind[model](sess)
+	m.Roles = append(m.Roles, "writer")
+	m.Prefs["theme"] = "dark"
+	assert.True(t, sess.HasChanged())
+	require.NoError(t, store.Save(context.Background(), sess))
+
+	sess = read()
+	assert.Equal(t, []string{"reader", "writer"}, sess.Get("roles"))
+	assert.Equal(t, Data{"theme": "dark"}, sess.Get("prefs"))
+
+	// Changes made after syncing are detected as well
+	m = Bind[model](sess)
+	m.Roles[0] = "admin"
+	assert.True(t, sess.HasChanged())
+	m.Roles[0] = "owner"
+	assert.True(t, sess.HasChanged())
+	assert.Equal(t, []string{"owner", "writer"}, sess.Get("roles"))
+}
+
+func TestBind_Views(t *testing.T) {
+	sess := NewBaseSessionWithData("1", GobEncoder, nil, Data{"username": "flamego"})
+	view1, view2 := sess.view(), sess.view()
+
+	// Structs are bound per view, and unchanged fields of one view never
+	// overwrite changes made via another view.
+	m1, m2 := Bind[testModel](view1), Bind[testModel](view2)
+	assert.NotSame(t, m1, m2)
+	m1.Username = "alice"
+	assert.Equal(t, "alice", view1.Get("username"))
+	m2.UserID = 1
+	assert.Equal(t, int64(1), view2.Get("user_id"))
+	assert.Equal(t, "alice", view2.Get("username"))
+	assert.Equal(t, Data{"username": "alice", "user_id": int64(1)}, sess.Data())
+	assert.Equal(t, "flamego", m2.Username)
+
+	// The end of one request does not release structs bound by another
+	view1.(binder).unbind()
+	m2.Remark = "remark"
+	assert.Equal(t, "remark", view2.Get("Remark"))
+}
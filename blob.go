@@ -0,0 +1,153 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoBlobStore is returned when putting blobs to a session without a blob
+// store, see Options.BlobStore.
+var ErrNoBlobStore = errors.New("no blob store")
+
+// BlobStore is a storage of large values of sessions (see session.PutBlob),
+// e.g. a local directory or an object storage, so that only references to the
+// values are kept in the session store. Blobs are never deleted by sessions,
+// blobs that are no longer referenced should be recycled by the blob store,
+// e.g. using lifecycle rules of the object storage.
+type BlobStore interface {
+	// PutBlob writes the content of the reader as the blob with given ID.
+	PutBlob(ctx context.Context, id string, r io.Reader) error
+	// GetBlob returns the content of the blob with given ID.
+	GetBlob(ctx context.Context, id string) ([]byte, error)
+}
+
+// blobIDLength is the length of generated blob IDs.
+const blobIDLength = 32
+
+// blobRef is the reference to a blob that is stored in the session data in
+// place of the content of the blob.
+type blobRef struct {
+	ID string
+}
+
+// blobber is a session that is capable of keeping values in the blob store.
+type blobber interface {
+	// putBlob writes the content of the reader to the blob store and returns the
+	// reference to the new blob.
+	putBlob(r io.Reader) (blobRef, error)
+	// readBlob returns the content of the referenced blob. It returns
+	// session.ErrNoBlobStore if there is no blob store.
+	readBlob(ref blobRef) ([]byte, error)
+	// getBlob returns the content of the blob referenced by the value of given
+	// key, or nil if no such key exists.
+	getBlob(key interface{}) ([]byte, error)
+}
+
+// PutBlob writes the content of the reader to the blob store (see
+// Options.BlobStore) and sets the value of given key in the session to be a
+// reference to the blob, which keeps the session data small in the session
+// store. The value is resolved transparently by Session.Get as []byte, or nil
+// if the blob store fails to read the blob (see session.GetBlob). It returns
+// session.ErrNoBlobStore if there is no blob store.
+func PutBlob(s Session, key string, r io.Reader) error {
+	b, ok := s.(blobber)
+	if !ok {
+		return ErrNoBlobStore
+	}
+
+	ref, err := b.putBlob(r)
+	if err != nil {
+		return err
+	}
+	s.Set(key, ref)
+	return nil
+}
+
+// GetBlob returns the content of the blob referenced by the value of given key
+// in the session (see session.PutBlob), or nil if no such key exists. Unlike
+// Session.Get, it returns the error if the blob store fails to read the blob.
+// It returns session.ErrNoBlobStore if there is no blob store.
+func GetBlob(s Session, key string) ([]byte, error) {
+	b, ok := s.(blobber)
+	if !ok {
+		return nil, ErrNoBlobStore
+	}
+	return b.getBlob(key)
+}
+
+var _ BlobStore = FileBlobStore("")
+
+// FileBlobStore is a blob store that stores each blob as a file in the
+// directory of the path.
+type FileBlobStore string
+
+// filename returns the path of the blob file with given ID.
+func (dir FileBlobStore) filename(id string) (string, error) {
+	if id == "" || filepath.Base(id) != id {
+		return "", fmt.Errorf("invalid blob ID %q", id)
+	}
+	return filepath.Join(string(dir), id), nil
+}
+
+// PutBlob implements `BlobStore.PutBlob`. The file is replaced atomically, thus
+// a failure in the middle never leaves a partial blob behind.
+func (dir FileBlobStore) PutBlob(_ context.Context, id string, r io.Reader) error {
+	filename, err := dir.filename(id)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(string(dir), 0700)
+	if err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(string(dir), id+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write: %w", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	err = os.Rename(f.Name(), filename)
+	if err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+// GetBlob implements `BlobStore.GetBlob`.
+func (dir FileBlobStore) GetBlob(_ context.Context, id string) ([]byte, error) {
+	filename, err := dir.filename(id)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	return b, nil
+}
+
+func init() {
+	gob.Register(blobRef{})
+}
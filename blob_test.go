@@ -0,0 +1,123 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestFileBlobStore(t *testing.T) {
+	ctx := context.Background()
+	store := FileBlobStore(t.TempDir())
+
+	require.NoError(t, store.PutBlob(ctx, "1", strings.NewReader("flamego")))
+	got, err := store.GetBlob(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, "flamego", string(got))
+
+	_, err = store.GetBlob(ctx, "2")
+	assert.Error(t, err)
+
+	assert.Error(t, store.PutBlob(ctx, "../1", strings.NewReader("flamego")))
+	_, err = store.GetBlob(ctx, "")
+	assert.Error(t, err)
+}
+
+func TestSession_PutBlob(t *testing.T) {
+	blobs := FileBlobStore(t.TempDir())
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			BlobStore: blobs,
+		},
+	))
+	f.Get("/put", func(s Session) {
+		require.NoError(t, PutBlob(s, "avatar", strings.NewReader("large")))
+		require.NoError(t, PutBlob(Scope(s, "plugin"), "avatar", strings.NewReader("scoped")))
+	})
+	f.Get("/get", func(s Session) string {
+		avatar, _ := s.Get("avatar").([]byte)
+		scoped, _ := Scope(s, "plugin").Get("avatar").([]byte)
+		return string(avatar) + "|" + string(scoped)
+	})
+	f.Get("/export", func(s Session) {
+		b, err := Export(s)
+		require.NoError(t, err)
+
+		// Only references to blobs are kept in the session data
+		assert.NotContains(t, string(b), "large")
+
+		imported := NewBaseSession("2", GobEncoder, nil)
+		require.NoError(t, Import(imported, b))
+		imported.setRequestContext(&requestContext{ctx: context.Background(), blobs: blobs})
+		assert.Equal(t, []byte("large"), imported.Get("avatar"))
+	})
+
+	var cookie string
+	request := func(path string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if c := resp.Header().Get("Set-Cookie"); c != "" {
+			cookie = strings.Split(c, ";")[0]
+		}
+		return resp
+	}
+
+	request("/put")
+	assert.Equal(t, "large|scoped", request("/get").Body.String())
+	request("/export")
+}
+
+func TestSession_PutBlob_NoBlobStore(t *testing.T) {
+	s := NewBaseSession("1", GobEncoder, nil)
+	assert.Equal(t, ErrNoBlobStore, PutBlob(s, "avatar", strings.NewReader("large")))
+	assert.Equal(t, ErrNoBlobStore, PutBlob(Scope(s, "plugin"), "avatar", strings.NewReader("large")))
+	assert.Nil(t, s.Get("avatar"))
+}
+
+func TestSession_GetBlob(t *testing.T) {
+	dir := t.TempDir()
+	s := NewBaseSession("1", GobEncoder, nil)
+	s.setRequestContext(&requestContext{ctx: context.Background(), blobs: FileBlobStore(dir)})
+	require.NoError(t, PutBlob(s, "avatar", strings.NewReader("large")))
+	require.NoError(t, PutBlob(Scope(s, "plugin"), "avatar", strings.NewReader("scoped")))
+
+	got, err := GetBlob(s, "avatar")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("large"), got)
+	got, err = GetBlob(Scope(s, "plugin"), "avatar")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("scoped"), got)
+
+	got, err = GetBlob(s, "missing")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	s.Set("name", "flamego")
+	_, err = GetBlob(s, "name")
+	assert.EqualError(t, err, "value of name is not a blob")
+
+	// Failures of reading blobs are only returned by GetBlob
+	require.NoError(t, os.RemoveAll(dir))
+	assert.Nil(t, s.Get("avatar"))
+	_, err = GetBlob(s, "avatar")
+	assert.ErrorContains(t, err, "get blob: read file")
+}
@@ -0,0 +1,252 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by the circuit breaker when the circuit is open
+// and the operation is rejected without calling the underlying session store.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed is the state that operations are passed to the underlying
+	// session store.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen is the state that operations are rejected without calling the
+	// underlying session store.
+	CircuitOpen
+	// CircuitHalfOpen is the state that a single probing operation is passed to
+	// the underlying session store to determine whether to close the circuit.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerOptions contains options for the circuit breaker of the
+// session store.
+type CircuitBreakerOptions struct {
+	// Threshold is the number of consecutive failures to open the circuit. Default
+	// is 0, i.e. the circuit breaker is disabled.
+	Threshold int
+	// Cooldown is the duration that the circuit stays open before a probing
+	// operation is allowed. Default is 30 seconds.
+	Cooldown time.Duration
+	// Degraded indicates whether to serve ephemeral sessions (see
+	// session.IsEphemeral) that only live within the request when the circuit is
+	// open or reading fails, instead of failing with the ErrCircuitOpen. They are
+	// never saved, so that existing sessions are not overwritten with empty data
+	// once the circuit is closed. Writes to the session store are dropped silently
+	// in the degraded mode, except for Destroy.
+	Degraded bool
+	// OnStateChange is the function to be called when the state of the circuit
+	// changes. Default is not set.
+	OnStateChange func(from, to CircuitState)
+	// NowFunc is the function to return the current time for the cooldown.
+	// Default is Options.NowFunc when used via the session.Sessioner, otherwise
+	// time.Now.
+	NowFunc func() time.Time
+}
+
+var _ Store = (*circuitBreakerStore)(nil)
+
+// circuitBreakerStore is a session store wrapper that stops calling the
+// underlying session store after consecutive failures.
+type circuitBreakerStore struct {
+	Store
+	opts CircuitBreakerOptions
+
+	lock     sync.Mutex   // The mutex to guard accesses to the fields below
+	state    CircuitState // The current state of the circuit
+	failures int          // The number of consecutive failures
+	openedAt time.Time    // The time when the circuit is opened
+	probing  bool         // Whether a probing operation is in-flight
+}
+
+// NewCircuitBreaker returns a session store wrapper that opens the circuit
+// after consecutive failures of the underlying session store. While the
+// circuit is open, operations are rejected with the ErrCircuitOpen (or served
+// with ephemeral sessions in the degraded mode) without calling the underlying
+// session store. After the cooldown, a single probing operation is let through
+// to determine whether to close the circuit.
+func NewCircuitBreaker(store Store, opts CircuitBreakerOptions) Store {
+	if opts.Threshold < 1 {
+		opts.Threshold = 1
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 30 * time.Second
+	}
+	if opts.NowFunc == nil {
+		opts.NowFunc = time.Now
+	}
+	return &circuitBreakerStore{
+		Store: store,
+		opts:  opts,
+	}
+}
+
+// Unwrap returns the underlying session store.
+func (s *circuitBreakerStore) Unwrap() Store {
+	return s.Store
+}
+
+// setState sets the state of the circuit. It is not concurrent-safe and is the
+// caller's responsibility to ensure the lock is held.
+func (s *circuitBreakerStore) setState(state CircuitState) {
+	if s.state == state {
+		return
+	}
+
+	from := s.state
+	s.state = state
+	if s.opts.OnStateChange != nil {
+		s.opts.OnStateChange(from, state)
+	}
+}
+
+// allow returns true if an operation is allowed to be passed to the
+// underlying session store.
+func (s *circuitBreakerStore) allow() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch s.state {
+	case CircuitClosed:
+		return true
+	case CircuitOpen:
+		if s.opts.NowFunc().Before(s.openedAt.Add(s.opts.Cooldown)) {
+			return false
+		}
+		s.setState(CircuitHalfOpen)
+	}
+
+	// Only one probing operation is allowed at a time in the half-open state.
+	if s.probing {
+		return false
+	}
+	s.probing = true
+	return true
+}
+
+// done records the result of an operation that was allowed.
+func (s *circuitBreakerStore) done(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.probing = false
+	if err == nil || errors.Is(err, context.Canceled) {
+		if err == nil {
+			s.failures = 0
+			s.setState(CircuitClosed)
+		}
+		return
+	}
+
+	s.failures++
+	if s.state == CircuitHalfOpen || s.failures >= s.opts.Threshold {
+		s.openedAt = s.opts.NowFunc()
+		s.setState(CircuitOpen)
+	}
+}
+
+// State returns the current state of the circuit.
+func (s *circuitBreakerStore) State() CircuitState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.state
+}
+
+func (s *circuitBreakerStore) Exist(ctx context.Context, sid string) bool {
+	ok, _ := s.CheckExist(ctx, sid)
+	return ok
+}
+
+var _ ExistChecker = (*circuitBreakerStore)(nil)
+
+func (s *circuitBreakerStore) CheckExist(ctx context.Context, sid string) (bool, error) {
+	if !s.allow() {
+		return false, ErrCircuitOpen
+	}
+	ok, err := CheckExist(ctx, s.Store, sid)
+	s.done(err)
+	return ok, err
+}
+
+func (s *circuitBreakerStore) Read(ctx context.Context, sid string) (Session, error) {
+	if !s.allow() {
+		if s.opts.Degraded {
+			return newEphemeralSession(sid, s.Store), nil
+		}
+		return nil, ErrCircuitOpen
+	}
+
+	sess, err := s.Store.Read(ctx, sid)
+	s.done(err)
+	if err != nil && s.opts.Degraded && !errors.Is(err, context.Canceled) {
+		return newEphemeralSession(sid, s.Store), nil
+	}
+	return sess, err
+}
+
+func (s *circuitBreakerStore) Destroy(ctx context.Context, sid string) error {
+	if !s.allow() {
+		return ErrCircuitOpen
+	}
+	err := s.Store.Destroy(ctx, sid)
+	s.done(err)
+	return err
+}
+
+// write calls the function as a write operation to the underlying session
+// store.
+func (s *circuitBreakerStore) write(fn func() error) error {
+	if !s.allow() {
+		if s.opts.Degraded {
+			return nil
+		}
+		return ErrCircuitOpen
+	}
+
+	err := fn()
+	s.done(err)
+	if err != nil && s.opts.Degraded && !errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func (s *circuitBreakerStore) Touch(ctx context.Context, sid string) error {
+	return s.write(func() error { return s.Store.Touch(ctx, sid) })
+}
+
+func (s *circuitBreakerStore) Save(ctx context.Context, sess Session) error {
+	return s.write(func() error { return s.Store.Save(ctx, sess) })
+}
+
+func (s *circuitBreakerStore) GC(ctx context.Context) error {
+	if !s.allow() {
+		return ErrCircuitOpen
+	}
+	err := s.Store.GC(ctx)
+	s.done(err)
+	return err
+}
@@ -0,0 +1,199 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+type failingStore struct {
+	noopStore
+	err   error
+	calls int
+}
+
+func (s *failingStore) Read(ctx context.Context, sid string) (Session, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.noopStore.Read(ctx, sid)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	underlying := &failingStore{err: errors.New("connection refused")}
+
+	var states []CircuitState
+	store := NewCircuitBreaker(underlying, CircuitBreakerOptions{
+		Threshold: 2,
+		Cooldown:  time.Minute,
+		OnStateChange: func(_, to CircuitState) {
+			states = append(states, to)
+		},
+		NowFunc: func() time.Time { return now },
+	}).(*circuitBreakerStore)
+
+	for i := 0; i < 2; i++ {
+		_, err := store.Read(ctx, "1")
+		assert.Equal(t, underlying.err, err)
+	}
+	assert.Equal(t, CircuitOpen, store.State())
+
+	// Operations are rejected without calling the underlying store
+	_, err := store.Read(ctx, "1")
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, 2, underlying.calls)
+
+	// A failed probe opens the circuit again
+	now = now.Add(time.Minute)
+	_, err = store.Read(ctx, "1")
+	assert.Equal(t, underlying.err, err)
+	assert.Equal(t, CircuitOpen, store.State())
+
+	// A successful probe closes the circuit
+	now = now.Add(time.Minute)
+	underlying.err = nil
+	_, err = store.Read(ctx, "1")
+	require.Nil(t, err)
+	assert.Equal(t, CircuitClosed, store.State())
+
+	assert.Equal(t, []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}, states)
+}
+
+func TestCircuitBreaker_Degraded(t *testing.T) {
+	ctx := context.Background()
+	underlying := &failingStore{err: errors.New("connection refused")}
+	store := NewCircuitBreaker(underlying, CircuitBreakerOptions{
+		Threshold: 1,
+		Degraded:  true,
+	})
+
+	sess, err := store.Read(ctx, "1")
+	require.Nil(t, err)
+	sess.Set("name", "flamego")
+	assert.Nil(t, store.Save(ctx, sess))
+
+	sess, err = store.Read(ctx, "1")
+	require.Nil(t, err)
+	assert.Nil(t, sess.Get("name"))
+	assert.Equal(t, 1, underlying.calls)
+
+	assert.Equal(t, ErrCircuitOpen, store.Destroy(ctx, "1"))
+}
+
+// flakyStore is a session store whose reads fail while the err is set.
+type flakyStore struct {
+	Store
+	err error
+}
+
+func (s *flakyStore) Read(ctx context.Context, sid string) (Session, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Store.Read(ctx, sid)
+}
+
+func TestCircuitBreaker_DegradedNotSaved(t *testing.T) {
+	var underlying *flakyStore
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Initer: func(ctx context.Context, args ...interface{}) (Store, error) {
+				store, err := FileIniter()(ctx, args...)
+				if err != nil {
+					return nil, err
+				}
+				underlying = &flakyStore{Store: store}
+				return underlying, nil
+			},
+			Config: FileConfig{
+				RootDir: t.TempDir(),
+			},
+			GCMode: GCDisabled,
+			CircuitBreaker: CircuitBreakerOptions{
+				Threshold: 1,
+				Cooldown:  time.Nanosecond,
+				Degraded:  true,
+			},
+		},
+	))
+	f.Get("/set", func(s Session) {
+		s.Set("name", "flamego")
+	})
+	f.Get("/get", func(s Session) string {
+		name, _ := s.Get("name").(string)
+		return name
+	})
+	f.Get("/flaky", func(s Session, store Store) {
+		assert.True(t, IsEphemeral(s))
+
+		// The circuit is closed by another request before this one ends
+		underlying.err = nil
+		_, err := store.Read(context.Background(), "other")
+		require.NoError(t, err)
+		breaker, ok := StoreAs[*circuitBreakerStore](store)
+		require.True(t, ok)
+		assert.Equal(t, CircuitClosed, breaker.State())
+	})
+
+	var cookie string
+	request := func(path string) string {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if c := resp.Header().Get("Set-Cookie"); c != "" {
+			cookie = c
+		}
+		return resp.Body.String()
+	}
+
+	request("/set")
+	underlying.err = errors.New("connection refused")
+	request("/flaky")
+
+	// The degraded session is not saved over the existing one
+	assert.Equal(t, "flamego", request("/get"))
+}
+
+type encodingStore struct {
+	noopStore
+	encoder Encoder
+}
+
+func (s *encodingStore) Encoder() Encoder {
+	return s.encoder
+}
+
+func TestCircuitBreaker_DegradedEncoder(t *testing.T) {
+	encoder := func(Data) ([]byte, error) { return []byte("encoded"), nil }
+	store := NewCircuitBreaker(&encodingStore{encoder: encoder}, CircuitBreakerOptions{Degraded: true})
+	store.(*circuitBreakerStore).setState(CircuitOpen)
+	store.(*circuitBreakerStore).openedAt = time.Now()
+
+	sess, err := store.Read(context.Background(), "1")
+	require.NoError(t, err)
+	assert.True(t, IsEphemeral(sess))
+	binary, err := sess.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, "encoded", string(binary))
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+// StoreCapabilities is the set of optional features that are supported by a
+// session store.
+type StoreCapabilities struct {
+	// List indicates whether the session store supports listing sessions, see
+	// session.Lister.
+	List bool
+	// ExpiresAt indicates whether the session store supports reporting expiry
+	// times of sessions, see session.Expirer.
+	ExpiresAt bool
+	// FindByTag indicates whether the session store supports finding sessions by
+	// tags, see session.TagFinder.
+	FindByTag bool
+	// Incr indicates whether the session store supports atomic counters, see
+	// session.Incrementer.
+	Incr bool
+	// Lists indicates whether the session store supports atomic lists, see
+	// session.ListStore.
+	Lists bool
+	// Snapshot indicates whether the session store supports taking snapshots, see
+	// session.Snapshotter.
+	Snapshot bool
+	// ArchiveOnGC indicates whether the session store supports reading expired
+	// sessions in GC operations, see session.ExpiryArchiver.
+	ArchiveOnGC bool
+	// CountActive indicates whether the session store supports counting
+	// sessions, see session.ActiveCounter.
+	CountActive bool
+	// EvictLRU indicates whether the session store supports evicting the least
+	// recently used sessions, see session.Evicter.
+	EvictLRU bool
+	// Summarize indicates whether the session store supports reporting summaries
+	// of sessions, see session.Summarizer.
+	Summarize bool
+}
+
+// CapabilityReporter is a session store that reports its own capabilities,
+// e.g. when features of optional interfaces depend on its configuration.
+type CapabilityReporter interface {
+	// Capabilities returns the capabilities of the session store.
+	Capabilities() StoreCapabilities
+}
+
+// Capabilities returns the capabilities of the session store, which are
+// discovered from optional interfaces in the chain of session store wrappers
+// (see session.StoreAs). Capabilities that are not reported by the session
+// store implementing session.CapabilityReporter are turned off, as the session
+// store would fail at runtime when using them.
+func Capabilities(store Store) StoreCapabilities {
+	_, list := StoreAs[Lister](store)
+	_, expiresAt := StoreAs[Expirer](store)
+	_, findByTag := StoreAs[TagFinder](store)
+	_, incr := StoreAs[Incrementer](store)
+	_, lists := StoreAs[ListStore](store)
+	_, snapshot := StoreAs[Snapshotter](store)
+	_, archiveOnGC := StoreAs[ExpiryArchiver](store)
+	_, countActive := StoreAs[ActiveCounter](store)
+	_, evictLRU := StoreAs[Evicter](store)
+	_, summarize := StoreAs[Summarizer](store)
+	caps := StoreCapabilities{
+		List:        list,
+		ExpiresAt:   expiresAt,
+		FindByTag:   findByTag,
+		Incr:        incr,
+		Lists:       lists,
+		Snapshot:    snapshot,
+		ArchiveOnGC: archiveOnGC,
+		CountActive: countActive,
+		EvictLRU:    evictLRU,
+		Summarize:   summarize,
+	}
+
+	reporter, ok := StoreAs[CapabilityReporter](store)
+	if !ok {
+		return caps
+	}
+	reported := reporter.Capabilities()
+	caps.List = caps.List && reported.List
+	caps.ExpiresAt = caps.ExpiresAt && reported.ExpiresAt
+	caps.FindByTag = caps.FindByTag && reported.FindByTag
+	caps.Incr = caps.Incr && reported.Incr
+	caps.Lists = caps.Lists && reported.Lists
+	caps.Snapshot = caps.Snapshot && reported.Snapshot
+	caps.ArchiveOnGC = caps.ArchiveOnGC && reported.ArchiveOnGC
+	caps.CountActive = caps.CountActive && reported.CountActive
+	caps.EvictLRU = caps.EvictLRU && reported.EvictLRU
+	caps.Summarize = caps.Summarize && reported.Summarize
+	return caps
+}
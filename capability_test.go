@@ -0,0 +1,40 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type incrOnlyReporter struct {
+	noopStore
+}
+
+func (*incrOnlyReporter) Capabilities() StoreCapabilities {
+	return StoreCapabilities{Incr: true, List: true}
+}
+
+func TestCapabilities(t *testing.T) {
+	assert.Equal(t, StoreCapabilities{}, Capabilities(&noopStore{}))
+
+	want := StoreCapabilities{
+		List:        true,
+		ExpiresAt:   true,
+		FindByTag:   true,
+		ArchiveOnGC: true,
+		CountActive: true,
+		EvictLRU:    true,
+	}
+	store := newMemoryStore(MemoryConfig{}, nil)
+	assert.Equal(t, want, Capabilities(store))
+
+	// Capabilities are discovered through wrappers
+	assert.Equal(t, want, Capabilities(&namedStore{Store: store}))
+
+	// Reported capabilities cannot turn on features without their interfaces
+	assert.Equal(t, StoreCapabilities{}, Capabilities(&incrOnlyReporter{}))
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// slowStoreCall is the duration of session store calls to be flagged as
+// blocking by the checked store.
+const slowStoreCall = 500 * time.Millisecond
+
+var _ Store = (*checkedStore)(nil)
+
+// checkedStore is a session store wrapper that asserts the contract of the
+// Store interface on every call, which catches integration mistakes of session
+// stores and their wrappers early. It reports calls with contexts without
+// deadlines (i.e. Options.StoreTimeouts is not set), calls that block longer
+// than the threshold, and calls that return successfully after their deadlines
+// (i.e. the session store does not respect the context). It is only enabled in
+// debug builds, see debugStoreChecks.
+//
+// Optional interfaces of the session store are not checked as they are
+// discovered via session.StoreAs, which unwraps the checked store.
+type checkedStore struct {
+	Store
+	threshold time.Duration    // The duration of calls to be flagged as blocking
+	report    func(err error)  // The function to report violations
+	nowFunc   func() time.Time // The function to return the current time
+}
+
+// newCheckedStore returns a new checked store wrapping the session store, which
+// reports violations to the `report`.
+func newCheckedStore(store Store, threshold time.Duration, report func(err error)) *checkedStore {
+	return &checkedStore{
+		Store:     store,
+		threshold: threshold,
+		report:    report,
+		nowFunc:   time.Now,
+	}
+}
+
+// Unwrap returns the underlying session store.
+func (s *checkedStore) Unwrap() Store {
+	return s.Store
+}
+
+// check asserts the context of the operation has a deadline, and returns the
+// function to be called with the result of the operation once it returns.
+func (s *checkedStore) check(ctx context.Context, op string) (done func(err error)) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		s.report(fmt.Errorf("session store %s: context has no deadline", op))
+	}
+
+	start := s.nowFunc()
+	return func(err error) {
+		now := s.nowFunc()
+		if elapsed := now.Sub(start); elapsed > s.threshold {
+			s.report(fmt.Errorf("session store %s: blocked for %v", op, elapsed))
+		}
+		if ok && now.After(deadline) && err == nil {
+			s.report(fmt.Errorf("session store %s: returned after the deadline without an error", op))
+		} else if err != nil && ctx.Err() != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			s.report(fmt.Errorf("session store %s: error of the done context does not wrap the context error: %v", op, err))
+		}
+	}
+}
+
+func (s *checkedStore) Exist(ctx context.Context, sid string) bool {
+	done := s.check(ctx, "Exist")
+	ok := s.Store.Exist(ctx, sid)
+	// Exist has no way to return the context error
+	done(ctx.Err())
+	return ok
+}
+
+func (s *checkedStore) Read(ctx context.Context, sid string) (Session, error) {
+	done := s.check(ctx, "Read")
+	sess, err := s.Store.Read(ctx, sid)
+	done(err)
+	return sess, err
+}
+
+func (s *checkedStore) Destroy(ctx context.Context, sid string) error {
+	done := s.check(ctx, "Destroy")
+	err := s.Store.Destroy(ctx, sid)
+	done(err)
+	return err
+}
+
+func (s *checkedStore) Touch(ctx context.Context, sid string) error {
+	done := s.check(ctx, "Touch")
+	err := s.Store.Touch(ctx, sid)
+	done(err)
+	return err
+}
+
+func (s *checkedStore) Save(ctx context.Context, sess Session) error {
+	done := s.check(ctx, "Save")
+	err := s.Store.Save(ctx, sess)
+	done(err)
+	return err
+}
+
+func (s *checkedStore) GC(ctx context.Context) error {
+	done := s.check(ctx, "GC")
+	err := s.Store.GC(ctx)
+	done(err)
+	return err
+}
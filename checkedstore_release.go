@@ -0,0 +1,11 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !sessiondebug
+
+package session
+
+// debugStoreChecks indicates whether to wrap session stores with the checked
+// store, which is enabled by the "sessiondebug" build tag.
+const debugStoreChecks = false
@@ -0,0 +1,71 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deadlineIgnoringStore is a session store that blocks on Touch regardless of
+// the context.
+type deadlineIgnoringStore struct {
+	Store
+	block time.Duration
+}
+
+func (s *deadlineIgnoringStore) Touch(context.Context, string) error {
+	time.Sleep(s.block)
+	return nil
+}
+
+func TestCheckedStore(t *testing.T) {
+	file, err := FileIniter()(context.Background(),
+		FileConfig{
+			RootDir: t.TempDir(),
+		},
+		IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.NoError(t, err)
+
+	var reports []string
+	store := newCheckedStore(
+		&deadlineIgnoringStore{Store: file, block: 20 * time.Millisecond},
+		10*time.Millisecond,
+		func(err error) { reports = append(reports, err.Error()) },
+	)
+
+	// Calls with deadlines that return promptly are fine
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	sess, err := store.Read(ctx, "111")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(ctx, sess))
+	assert.Empty(t, reports)
+
+	// Calls without deadlines
+	_ = store.Exist(context.Background(), "111")
+	require.Len(t, reports, 1)
+	assert.Contains(t, reports[0], "Exist: context has no deadline")
+
+	// Calls that block beyond the threshold and the deadline
+	reports = nil
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	require.NoError(t, store.Touch(ctx, "111"))
+	assert.Equal(t, 2, len(reports), strings.Join(reports, "\n"))
+	assert.Contains(t, reports[0], "Touch: blocked for")
+	assert.Contains(t, reports[1], "Touch: returned after the deadline without an error")
+
+	// The underlying session store is discoverable
+	_, ok := StoreAs[*deadlineIgnoringStore](store)
+	assert.True(t, ok)
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// checksumMagic is the prefix of session data encoded by encoders returned by
+// session.ChecksumEncoder, which is followed by the checksum and the payload.
+var checksumMagic = []byte("\xfcfs1")
+
+// checksumHeaderSize is the size of the magic and the checksum.
+const checksumHeaderSize = 4 + 8
+
+// CorruptedDataError is the error of decoding session data whose checksum does
+// not match its payload, i.e. the session data was damaged in the session
+// store or in transit.
+type CorruptedDataError struct {
+	Want uint64 // The checksum stored alongside the payload
+	Got  uint64 // The checksum of the payload
+}
+
+func (e *CorruptedDataError) Error() string {
+	return fmt.Sprintf("session data is corrupted: checksum mismatch, want %016x but got %016x", e.Want, e.Got)
+}
+
+// FormatChangedError is the error of decoding session data whose checksum
+// matches its payload but the payload cannot be decoded, i.e. the format of
+// the session data has changed since encoded, e.g. a type stored in the
+// session data is no longer registered to Gob.
+type FormatChangedError struct {
+	Err error // The error of the decoder
+}
+
+func (e *FormatChangedError) Error() string {
+	return "session data format has changed: " + e.Err.Error()
+}
+
+func (e *FormatChangedError) Unwrap() error {
+	return e.Err
+}
+
+// ChecksumEncoder returns an encoder that prefixes the session data encoded by
+// the encoder with its checksum (xxHash), which lets the decoder returned by
+// session.ChecksumDecoder tell corruption from format changes. It must be used
+// with the session.ChecksumDecoder.
+func ChecksumEncoder(encoder Encoder) Encoder {
+	return func(data Data) ([]byte, error) {
+		payload, err := encoder(data)
+		if err != nil {
+			return nil, err
+		}
+
+		encoded := make([]byte, checksumHeaderSize, checksumHeaderSize+len(payload))
+		copy(encoded, checksumMagic)
+		binary.BigEndian.PutUint64(encoded[len(checksumMagic):], xxhash.Sum64(payload))
+		return append(encoded, payload...), nil
+	}
+}
+
+// ChecksumDecoder returns a decoder for the session.ChecksumEncoder, which
+// verifies the checksum before decoding the payload by the decoder. Failures
+// are classified as *session.CorruptedDataError and
+// *session.FormatChangedError, and reported to the onFailure if it is not nil,
+// e.g. to be counted in metrics. Session data without a checksum (i.e.
+// encoded before adopting the session.ChecksumEncoder) is decoded as-is, whose
+// failures are unclassified.
+func ChecksumDecoder(decoder Decoder, onFailure func(err error)) Decoder {
+	return func(encoded []byte) (Data, error) {
+		if len(encoded) < checksumHeaderSize || !bytes.HasPrefix(encoded, checksumMagic) {
+			return decoder(encoded)
+		}
+
+		want := binary.BigEndian.Uint64(encoded[len(checksumMagic):checksumHeaderSize])
+		payload := encoded[checksumHeaderSize:]
+		if got := xxhash.Sum64(payload); got != want {
+			err := &CorruptedDataError{Want: want, Got: got}
+			if onFailure != nil {
+				onFailure(err)
+			}
+			return nil, err
+		}
+
+		data, err := decoder(payload)
+		if err != nil {
+			err := &FormatChangedError{Err: err}
+			if onFailure != nil {
+				onFailure(err)
+			}
+			return nil, err
+		}
+		return data, nil
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksum(t *testing.T) {
+	var failures []error
+	encoder := ChecksumEncoder(GobEncoder)
+	decoder := ChecksumDecoder(GobDecoder, func(err error) { failures = append(failures, err) })
+
+	binary, err := encoder(Data{"name": "flamego"})
+	require.NoError(t, err)
+
+	data, err := decoder(binary)
+	require.NoError(t, err)
+	assert.Equal(t, Data{"name": "flamego"}, data)
+
+	// Session data without a checksum is decoded as-is
+	legacy, err := GobEncoder(Data{"name": "flamego"})
+	require.NoError(t, err)
+	data, err = decoder(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, Data{"name": "flamego"}, data)
+
+	t.Run("corrupted", func(t *testing.T) {
+		failures = nil
+		corrupted := append([]byte(nil), binary...)
+		corrupted[len(corrupted)-1] ^= 0xff
+
+		_, err := decoder(corrupted)
+		var corruptedErr *CorruptedDataError
+		assert.True(t, errors.As(fmt.Errorf("decode: %w", err), &corruptedErr))
+		assert.Len(t, failures, 1)
+	})
+
+	t.Run("format changed", func(t *testing.T) {
+		failures = nil
+		changed, err := ChecksumEncoder(func(Data) ([]byte, error) {
+			return []byte("not gob"), nil
+		})(nil)
+		require.NoError(t, err)
+
+		_, err = decoder(changed)
+		var formatErr *FormatChangedError
+		assert.True(t, errors.As(fmt.Errorf("decode: %w", err), &formatErr))
+		assert.Len(t, failures, 1)
+	})
+}
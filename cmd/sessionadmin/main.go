@@ -0,0 +1,138 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Sessionadmin is a command-line tool for inspecting and managing sessions in
+// the file, Postgres, MySQL, SQLite and Redis session stores.
+//
+// Usage:
+//
+//	go run github.com/flamego/session/cmd/sessionadmin [flags] <list|view|summary|touch|destroy> [sid]
+//
+// Examples:
+//
+//	sessionadmin -store file -root-dir ./sessions list
+//	sessionadmin -store postgres -dsn "postgres://localhost/app" view 7ac5e0b3f4e91c2d
+//	sessionadmin -store redis -dsn "redis://localhost:6379/0" summary 7ac5e0b3f4e91c2d
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/flamego/session"
+	"github.com/flamego/session/admin"
+	"github.com/flamego/session/mysql"
+	"github.com/flamego/session/postgres"
+	"github.com/flamego/session/redis"
+	"github.com/flamego/session/sqlite"
+)
+
+func main() {
+	storeType := flag.String("store", "file", "The type of the session store, one of file, postgres, mysql, sqlite and redis")
+	dsn := flag.String("dsn", "", "The database source name for the SQL session stores, or the URL for the Redis session store")
+	table := flag.String("table", "sessions", "The table name for the SQL session stores")
+	rootDir := flag.String("root-dir", "sessions", "The root directory for the file session store")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <list|view|summary|touch|destroy> [sid]\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := run(context.Background(), *storeType, *dsn, *table, *rootDir, flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func newStore(ctx context.Context, storeType, dsn, table, rootDir string) (session.Store, error) {
+	var initer session.Initer
+	var config interface{}
+	switch storeType {
+	case "file":
+		initer = session.FileIniter()
+		config = session.FileConfig{RootDir: rootDir}
+	case "postgres":
+		initer = postgres.Initer()
+		config = postgres.Config{DSN: dsn, Table: table}
+	case "mysql":
+		initer = mysql.Initer()
+		config = mysql.Config{DSN: dsn, Table: table}
+	case "sqlite":
+		initer = sqlite.Initer()
+		config = sqlite.Config{DSN: dsn, Table: table}
+	case "redis":
+		opts, err := goredis.ParseURL(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("parse URL: %w", err)
+		}
+		initer = redis.Initer()
+		// Summaries are read from the metadata maintained by the application
+		config = redis.Config{Options: opts, WriteMetadata: true}
+	default:
+		return nil, fmt.Errorf("unsupported store type %q", storeType)
+	}
+	return initer(ctx, config, session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}))
+}
+
+func run(ctx context.Context, storeType, dsn, table, rootDir string, args []string) error {
+	store, err := newStore(ctx, storeType, dsn, table, rootDir)
+	if err != nil {
+		return fmt.Errorf("init store: %w", err)
+	}
+
+	cmd := args[0]
+	if cmd != "list" && len(args) < 2 {
+		return fmt.Errorf("command %q requires a session ID", cmd)
+	}
+
+	switch cmd {
+	case "list":
+		sids, err := admin.List(ctx, store)
+		if err != nil {
+			return err
+		}
+		for _, sid := range sids {
+			fmt.Println(sid)
+		}
+	case "view":
+		data, err := admin.View(ctx, store, args[1])
+		if err != nil {
+			return err
+		}
+		p, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshal: %w", err)
+		}
+		fmt.Println(string(p))
+	case "summary":
+		summary, err := admin.Summarize(ctx, store, args[1])
+		if err != nil {
+			return err
+		}
+		p, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshal: %w", err)
+		}
+		fmt.Println(string(p))
+	case "touch":
+		return admin.Touch(ctx, store, args[1])
+	case "destroy":
+		return admin.Destroy(ctx, store, args[1])
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// CookiePreset is a preset of consistent combinations of cookie attributes for
+// a kind of application.
+type CookiePreset int
+
+const (
+	// PresetNone applies no preset, i.e. cookie attributes are used as-is.
+	PresetNone CookiePreset = iota
+	// PresetClassic is for classic server-rendered web applications where pages
+	// and forms are served from the same site: HttpOnly and SameSite=Lax, which
+	// keeps the session on top-level navigations from other sites (e.g. following
+	// a link) while blocking cross-site subrequests. Secure is not set so that it
+	// also works over plain HTTP in development.
+	PresetClassic
+	// PresetSPA is for single-page applications that are served from a different
+	// site than the backend and send requests with credentials: HttpOnly, Secure
+	// and SameSite=None, which is required for cookies to be sent on cross-site
+	// requests. Make sure the backend also guards against CSRF.
+	PresetSPA
+	// PresetAPI is for APIs that are only called by the same site or non-browser
+	// clients: HttpOnly, Secure and SameSite=Strict, which never sends the cookie
+	// on cross-site requests.
+	PresetAPI
+)
+
+// applyPreset sets the cookie attributes of the preset, attributes that are
+// explicitly set (i.e. non-zero) are kept as overrides.
+func (opts CookieOptions) applyPreset() CookieOptions {
+	var sameSite http.SameSite
+	switch opts.Preset {
+	case PresetClassic:
+		opts.HTTPOnly = true
+		sameSite = http.SameSiteLaxMode
+	case PresetSPA:
+		opts.HTTPOnly = true
+		opts.Secure = true
+		sameSite = http.SameSiteNoneMode
+	case PresetAPI:
+		opts.HTTPOnly = true
+		opts.Secure = true
+		sameSite = http.SameSiteStrictMode
+	default:
+		return opts
+	}
+
+	if opts.SameSite == 0 {
+		opts.SameSite = sameSite
+	}
+	return opts
+}
+
+// validate returns an error if the cookie attributes are incompatible, which
+// would make browsers reject the cookie.
+func (opts CookieOptions) validate() error {
+	if opts.SameSite == http.SameSiteNoneMode && !opts.Secure {
+		return errors.New("SameSite=None requires Secure")
+	}
+	if strings.HasPrefix(opts.Name, "__Secure-") && !opts.Secure {
+		return errors.New("cookie name with the __Secure- prefix requires Secure")
+	}
+	if strings.HasPrefix(opts.Name, "__Host-") && (!opts.Secure || opts.Path != "/" || opts.Domain != "") {
+		return errors.New("cookie name with the __Host- prefix requires Secure, Path=/ and no Domain")
+	}
+	return nil
+}
+
+// cookieEnvelopeVersion is the current format version of the cookie envelope.
+const cookieEnvelopeVersion = "v1"
+
+// CookieEnvelope contains options for wrapping the session ID in an envelope
+// as the cookie value, i.e. "v1.<base64url(sid)>" and optionally followed by
+// ".<base64url(signature)>", which carries the format version so that future
+// changes to the format can be rolled out without breaking existing cookies.
+// Cookies in legacy formats are upgraded to the current format on the first
+// request.
+type CookieEnvelope struct {
+	// Enabled indicates whether to wrap the session ID in the envelope. Cookies
+	// with bare session IDs are considered in the legacy format. Default is
+	// false.
+	Enabled bool
+	// SigningKeys are the keys to sign the envelope with HMAC-SHA256. The first
+	// key is used to sign and all keys are used to verify, which allows rotating
+	// keys by prepending the new key. Envelopes that are unsigned or signed with
+	// other keys than the first one are considered in the legacy format. Default
+	// is not set, i.e. envelopes are not signed.
+	SigningKeys KeyRing
+	// RejectLegacy indicates whether to reject cookies in the legacy formats
+	// instead of accepting and upgrading them, which should be turned on once all
+	// cookies in the legacy formats have been upgraded or expired. Default is
+	// false.
+	RejectLegacy bool
+}
+
+// encode returns the cookie value of the session ID in the envelope of the
+// current format.
+func (e CookieEnvelope) encode(sid string) string {
+	payload := cookieEnvelopeVersion + "." + base64.RawURLEncoding.EncodeToString([]byte(sid))
+	if len(e.SigningKeys) == 0 {
+		return payload
+	}
+	return payload + "." + base64.RawURLEncoding.EncodeToString(e.SigningKeys.Sign([]byte(payload)))
+}
+
+// decode returns the session ID in the cookie value and whether the cookie value
+// is in the current format. It returns an empty session ID if the cookie value
+// is invalid or rejected.
+func (e CookieEnvelope) decode(value string) (sid string, current bool) {
+	fields := strings.Split(value, ".")
+	if fields[0] != cookieEnvelopeVersion {
+		// A bare session ID never contains dots
+		if len(fields) > 1 || e.RejectLegacy {
+			return "", false
+		}
+		return value, false
+	} else if len(fields) != 2 && len(fields) != 3 {
+		return "", false
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", false
+	}
+	sid = string(b)
+
+	if len(fields) == 2 {
+		if len(e.SigningKeys) == 0 {
+			return sid, true
+		} else if e.RejectLegacy {
+			return "", false
+		}
+		return sid, false
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(fields[2])
+	if err != nil {
+		return "", false
+	}
+	payload := fields[0] + "." + fields[1]
+	ok, current := e.SigningKeys.Verify([]byte(payload), signature)
+	if !ok || (!current && e.RejectLegacy) {
+		return "", false
+	}
+	return sid, current
+}
+
+// IsPrefetch returns true if the request is a speculative prefetch or prerender
+// request made by the browser, as indicated by the "Sec-Purpose" header or the
+// legacy "Purpose" and "X-Moz" headers.
+func IsPrefetch(r *http.Request) bool {
+	for _, h := range []string{"Sec-Purpose", "Purpose", "X-Moz"} {
+		for _, v := range strings.Split(r.Header.Get(h), ";") {
+			if v := strings.TrimSpace(v); v == "prefetch" || v == "prerender" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dedupeCookies removes all but the last Set-Cookie header of the cookie with
+// given name from the response header, so that only the final session ID is
+// sent when the session cookie has been written more than once within a
+// request, e.g. the session ID is regenerated after the session is loaded.
+func dedupeCookies(h http.Header, name string) {
+	values := h.Values("Set-Cookie")
+	last := -1
+	for i, v := range values {
+		if cookieName(v) == name {
+			last = i
+		}
+	}
+	if last < 0 {
+		return
+	}
+
+	kept := values[:0:0]
+	for i, v := range values {
+		if i == last || cookieName(v) != name {
+			kept = append(kept, v)
+		}
+	}
+	h["Set-Cookie"] = kept
+}
+
+// cookieName returns the name of the cookie in the Set-Cookie header value.
+func cookieName(setCookie string) string {
+	name, _, _ := strings.Cut(setCookie, "=")
+	return strings.TrimSpace(name)
+}
+
+// setRequestCookie replaces cookies with the same name in the request with the
+// cookie, so that the cookie is seen by later reads of the request within the
+// same request.
+func setRequestCookie(r *http.Request, cookie *http.Cookie) {
+	others := make([]string, 0, len(r.Cookies()))
+	for _, c := range r.Cookies() {
+		if c.Name != cookie.Name {
+			others = append(others, c.String())
+		}
+	}
+	r.Header.Del("Cookie")
+	if len(others) > 0 {
+		r.Header.Set("Cookie", strings.Join(others, "; "))
+	}
+	r.AddCookie(cookie)
+}
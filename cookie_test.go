@@ -0,0 +1,265 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestCookieOptions_Preset(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie CookieOptions
+		want   string
+	}{
+		{
+			name:   "classic",
+			cookie: CookieOptions{Preset: PresetClassic},
+			want:   "; Path=/; HttpOnly; SameSite=Lax",
+		},
+		{
+			name:   "spa",
+			cookie: CookieOptions{Preset: PresetSPA},
+			want:   "; Path=/; HttpOnly; Secure; SameSite=None",
+		},
+		{
+			name:   "api",
+			cookie: CookieOptions{Preset: PresetAPI},
+			want:   "; Path=/; HttpOnly; Secure; SameSite=Strict",
+		},
+		{
+			name:   "override",
+			cookie: CookieOptions{Preset: PresetAPI, SameSite: http.SameSiteLaxMode, Domain: "example.com"},
+			want:   "; Path=/; Domain=example.com; HttpOnly; Secure; SameSite=Lax",
+		},
+		{
+			name:   "spa with explicit SameSite",
+			cookie: CookieOptions{Preset: PresetSPA, SameSite: http.SameSiteStrictMode},
+			want:   "; Path=/; HttpOnly; Secure; SameSite=Strict",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(Sessioner(Options{Cookie: test.cookie}))
+			f.Get("/", func() {})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			require.NoError(t, err)
+
+			f.ServeHTTP(resp, req)
+			cookie := resp.Header().Get("Set-Cookie")
+			assert.True(t, strings.HasSuffix(cookie, test.want), cookie)
+		})
+	}
+}
+
+func TestCookieOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie CookieOptions
+		want   string
+	}{
+		{
+			name:   "SameSite=None without Secure",
+			cookie: CookieOptions{SameSite: http.SameSiteNoneMode},
+			want:   "session: cookie: SameSite=None requires Secure",
+		},
+		{
+			name:   "__Host- prefix with Domain",
+			cookie: CookieOptions{Preset: PresetAPI, Name: "__Host-session", Domain: "example.com"},
+			want:   "session: cookie: cookie name with the __Host- prefix requires Secure, Path=/ and no Domain",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.PanicsWithValue(t, test.want, func() {
+				Sessioner(Options{Cookie: test.cookie})
+			})
+		})
+	}
+}
+
+func TestCookieEnvelope(t *testing.T) {
+	envelope := CookieEnvelope{
+		Enabled:     true,
+		SigningKeys: [][]byte{[]byte("new"), []byte("old")},
+	}
+	sid := "abcdefghijklmnop"
+	value := envelope.encode(sid)
+	assert.True(t, strings.HasPrefix(value, "v1."))
+
+	tests := []struct {
+		name         string
+		value        string
+		rejectLegacy bool
+		wantSID      string
+		wantCurrent  bool
+	}{
+		{name: "current", value: value, wantSID: sid, wantCurrent: true},
+		{name: "bare", value: sid, wantSID: sid},
+		{name: "bare rejected", value: sid, rejectLegacy: true},
+		{name: "unsigned", value: CookieEnvelope{Enabled: true}.encode(sid), wantSID: sid},
+		{name: "unsigned rejected", value: CookieEnvelope{Enabled: true}.encode(sid), rejectLegacy: true},
+		{name: "old key", value: CookieEnvelope{SigningKeys: [][]byte{[]byte("old")}}.encode(sid), wantSID: sid},
+		{name: "unknown key", value: CookieEnvelope{SigningKeys: [][]byte{[]byte("unknown")}}.encode(sid)},
+		{name: "tampered", value: strings.Replace(value, "v1.", "v1.x", 1)},
+		{name: "unknown version", value: strings.Replace(value, "v1.", "v9.", 1)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := envelope
+			e.RejectLegacy = test.rejectLegacy
+			gotSID, gotCurrent := e.decode(test.value)
+			assert.Equal(t, test.wantSID, gotSID)
+			assert.Equal(t, test.wantCurrent, gotCurrent)
+		})
+	}
+}
+
+func TestSessioner_CookieEnvelope(t *testing.T) {
+	// Both instances share the same session store
+	rootDir := filepath.Join(t.TempDir(), "sessions")
+	newFlame := func(cookie CookieOptions) *flamego.Flame {
+		f := flamego.NewWithLogger(&bytes.Buffer{})
+		f.Use(Sessioner(
+			Options{
+				Initer: FileIniter(),
+				Config: FileConfig{
+					RootDir: rootDir,
+				},
+				Cookie: cookie,
+			},
+		))
+		f.Get("/", func(s Session) string {
+			if s.Get("username") == nil {
+				s.Set("username", "flamego")
+			}
+			return s.ID()
+		})
+		return f
+	}
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+
+	newFlame(CookieOptions{}).ServeHTTP(resp, req)
+	sid := resp.Body.String()
+	legacy := resp.Result().Cookies()[0]
+	assert.Equal(t, sid, legacy.Value)
+
+	// The cookie in the legacy format is upgraded on the first request
+	f := newFlame(CookieOptions{
+		Envelope: CookieEnvelope{
+			Enabled:     true,
+			SigningKeys: [][]byte{[]byte("key")},
+		},
+	})
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+
+	req.AddCookie(legacy)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, sid, resp.Body.String())
+	upgraded := resp.Result().Cookies()[0]
+	assert.True(t, strings.HasPrefix(upgraded.Value, "v1."))
+
+	// The cookie in the current format is not written again
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+
+	req.AddCookie(upgraded)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, sid, resp.Body.String())
+	assert.Empty(t, resp.Header().Get("Set-Cookie"))
+}
+
+func TestIsPrefetch(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   bool
+	}{
+		{name: "none", header: http.Header{}, want: false},
+		{name: "Sec-Purpose", header: http.Header{"Sec-Purpose": {"prefetch"}}, want: true},
+		{name: "Sec-Purpose prerender", header: http.Header{"Sec-Purpose": {"prefetch;prerender"}}, want: true},
+		{name: "Purpose", header: http.Header{"Purpose": {"prefetch"}}, want: true},
+		{name: "X-Moz", header: http.Header{"X-Moz": {"prefetch"}}, want: true},
+		{name: "other", header: http.Header{"Sec-Purpose": {"other"}}, want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &http.Request{Header: test.header}
+			assert.Equal(t, test.want, IsPrefetch(r))
+		})
+	}
+}
+
+func TestSessioner_ShouldWriteID(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			ShouldWriteID: func(r *http.Request) bool { return !IsPrefetch(r) },
+		},
+	))
+	f.Get("/", func(s Session) string {
+		return s.ID()
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Sec-Purpose", "prefetch")
+	f.ServeHTTP(resp, req)
+	assert.NotEmpty(t, resp.Body.String())
+	assert.Empty(t, resp.Header().Get("Set-Cookie"))
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	assert.NotEmpty(t, resp.Header().Get("Set-Cookie"))
+}
+
+func TestSessioner_LateRegenerateID(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner())
+	f.Get("/", func(w http.ResponseWriter, r *http.Request, s Session) {
+		require.NoError(t, s.RegenerateID(w, r))
+	})
+	f.Get("/write", func(w http.ResponseWriter, r *http.Request, s Session) string {
+		require.NoError(t, s.RegenerateID(w, r))
+		return s.ID()
+	})
+
+	for _, path := range []string{"/", "/write"} {
+		t.Run(path, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, path, nil)
+			require.NoError(t, err)
+			f.ServeHTTP(resp, req)
+
+			cookies := resp.Result().Cookies()
+			require.Len(t, cookies, 1, "only the final session ID is sent")
+			if path == "/write" {
+				assert.Equal(t, resp.Body.String(), cookies[0].Value)
+			}
+		})
+	}
+}
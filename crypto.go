@@ -0,0 +1,198 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// newGCM returns a new AES-GCM cipher with given key, which must be 16, 24 or
+// 32 bytes long to select AES-128, AES-192 or AES-256 respectively.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("new cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Labels to derive subkeys of a key for distinct purposes, which ensures a key
+// is never used for both encrypting and signing.
+const (
+	encryptionKeyLabel = "flamego/session encryption"
+	signingKeyLabel    = "flamego/session signing"
+)
+
+// deriveKey returns the subkey of given key for the purpose of the label using
+// HKDF-SHA256, which has the given length.
+func deriveKey(key []byte, label string, length int) []byte {
+	subkey := make([]byte, length)
+	// Reading less than 255 blocks of the hash size from HKDF never fails
+	_, _ = io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(label)), subkey)
+	return subkey
+}
+
+// encrypt encrypts the plaintext using AES-GCM with given key and additional
+// authenticated data. The returned ciphertext is prefixed with the randomly
+// generated nonce.
+func encrypt(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// decrypt decrypts the ciphertext produced by encrypt using AES-GCM with given
+// key and additional authenticated data.
+func decrypt(key, ciphertext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+// KeyRing is an ordered list of keys that allows rotating keys without
+// invalidating existing sessions. The first key is the current key to encrypt
+// and sign with, and all keys are tried to decrypt and verify. Keys are rotated
+// by prepending the new key, and old keys should be removed once all sessions
+// have been upgraded or expired. Separate subkeys are derived from each key for
+// encrypting and signing.
+type KeyRing [][]byte
+
+// validateAES returns an error if the key ring is empty or any of the keys is
+// not a valid AES key.
+func (r KeyRing) validateAES() error {
+	if len(r) == 0 {
+		return errors.New("no key")
+	}
+	for i, key := range r {
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return fmt.Errorf("invalid length %d of key %d, must be 16, 24 or 32", len(key), i)
+		}
+	}
+	return nil
+}
+
+// Encrypt encrypts the plaintext using AES-GCM with the current key. The aad
+// is the additional authenticated data that binds the ciphertext to its
+// context, e.g. the session ID, which must be the same to decrypt.
+func (r KeyRing) Encrypt(plaintext, aad []byte) ([]byte, error) {
+	if len(r) == 0 {
+		return nil, errors.New("no key")
+	}
+	return encrypt(deriveKey(r[0], encryptionKeyLabel, len(r[0])), plaintext, aad)
+}
+
+// Decrypt decrypts the ciphertext produced by KeyRing.Encrypt with any of the
+// keys and the same additional authenticated data. It also returns whether the
+// ciphertext was encrypted with the current key, i.e. whether it is up to date.
+func (r KeyRing) Decrypt(ciphertext, aad []byte) (plaintext []byte, current bool, err error) {
+	for i, key := range r {
+		plaintext, err = decrypt(deriveKey(key, encryptionKeyLabel, len(key)), ciphertext, aad)
+		if err == nil {
+			return plaintext, i == 0, nil
+		}
+	}
+	return nil, false, errors.New("no key could decrypt the ciphertext")
+}
+
+// Sign returns the HMAC-SHA256 signature of the payload with the current key.
+func (r KeyRing) Sign(payload []byte) []byte {
+	if len(r) == 0 {
+		return nil
+	}
+	return sign(deriveKey(r[0], signingKeyLabel, sha256.Size), payload)
+}
+
+// Verify returns whether the signature of the payload is valid with any of the
+// keys, and whether it was signed with the current key.
+func (r KeyRing) Verify(payload, signature []byte) (ok, current bool) {
+	for i, key := range r {
+		if hmac.Equal(signature, sign(deriveKey(key, signingKeyLabel, sha256.Size), payload)) {
+			return true, i == 0
+		}
+	}
+	return false, false
+}
+
+// sign returns the HMAC-SHA256 signature of the payload with given key.
+func sign(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// EncryptedEncoder returns a session data encoder that encrypts the encoding of
+// the encoder with the key ring, which makes session data confidential in any
+// session store. Each key must be 16, 24 or 32 bytes long, otherwise it panics.
+// As encoders are not given session IDs, the ciphertext is not bound to the
+// session, use session stores that encrypt with session IDs as additional
+// authenticated data (e.g. FileConfig.EncryptionKeys) where available.
+func EncryptedEncoder(ring KeyRing, encoder Encoder) Encoder {
+	mustValidateAES(ring)
+	return func(data Data) ([]byte, error) {
+		binary, err := encoder(data)
+		if err != nil {
+			return nil, err
+		}
+		return ring.Encrypt(binary, nil)
+	}
+}
+
+// mustValidateAES panics if the key ring is not valid for encryption.
+func mustValidateAES(ring KeyRing) {
+	err := ring.validateAES()
+	if err != nil {
+		panic("session: invalid encryption keys: " + err.Error())
+	}
+}
+
+// EncryptedDecoder returns a session data decoder for the EncryptedEncoder with
+// the same key ring. Sessions that were encrypted with keys other than the
+// current key are marked as changed once read, thus re-encrypted with the
+// current key when saved at the end of the request. Each key must be 16, 24 or
+// 32 bytes long, otherwise it panics.
+func EncryptedDecoder(ring KeyRing, decoder Decoder) Decoder {
+	mustValidateAES(ring)
+	return func(binary []byte) (Data, error) {
+		plaintext, current, err := ring.Decrypt(binary, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := decoder(plaintext)
+		if err != nil {
+			return nil, err
+		}
+		if !current {
+			markStale(data)
+		}
+		return data, nil
+	}
+}
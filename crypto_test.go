@@ -0,0 +1,87 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRing(t *testing.T) {
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	newKey := bytes.Repeat([]byte("n"), 32)
+	oldRing := KeyRing{oldKey}
+	ring := KeyRing{newKey, oldKey}
+
+	ciphertext, err := oldRing.Encrypt([]byte("flamego"), []byte("1"))
+	require.NoError(t, err)
+	plaintext, current, err := ring.Decrypt(ciphertext, []byte("1"))
+	require.NoError(t, err)
+	assert.Equal(t, "flamego", string(plaintext))
+	assert.False(t, current)
+
+	ciphertext, err = ring.Encrypt([]byte("flamego"), []byte("1"))
+	require.NoError(t, err)
+	_, current, err = ring.Decrypt(ciphertext, []byte("1"))
+	require.NoError(t, err)
+	assert.True(t, current)
+	_, _, err = oldRing.Decrypt(ciphertext, []byte("1"))
+	assert.Error(t, err)
+
+	// The ciphertext is bound to the additional authenticated data
+	_, _, err = ring.Decrypt(ciphertext, []byte("2"))
+	assert.Error(t, err)
+
+	// Subkeys are used rather than the keys themselves
+	_, err = decrypt(newKey, ciphertext, []byte("1"))
+	assert.Error(t, err)
+	assert.NotEqual(t, sign(newKey, []byte("payload")), ring.Sign([]byte("payload")))
+
+	ok, current := ring.Verify([]byte("payload"), oldRing.Sign([]byte("payload")))
+	assert.True(t, ok)
+	assert.False(t, current)
+	ok, current = ring.Verify([]byte("payload"), ring.Sign([]byte("payload")))
+	assert.True(t, ok)
+	assert.True(t, current)
+	ok, _ = oldRing.Verify([]byte("payload"), ring.Sign([]byte("payload")))
+	assert.False(t, ok)
+
+	assert.Error(t, KeyRing{[]byte("short")}.validateAES())
+	assert.Error(t, KeyRing{}.validateAES())
+}
+
+func TestEncryptedEncoder(t *testing.T) {
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	newKey := bytes.Repeat([]byte("n"), 32)
+	ring := KeyRing{newKey, oldKey}
+
+	binary, err := EncryptedEncoder(KeyRing{oldKey}, GobEncoder)(Data{"name": "flamego"})
+	require.NoError(t, err)
+	assert.NotContains(t, string(binary), "flamego")
+
+	// Sessions encrypted with the old key are marked as changed to be upgraded
+	data, err := EncryptedDecoder(ring, GobDecoder)(binary)
+	require.NoError(t, err)
+	sess := NewBaseSessionWithData("1", EncryptedEncoder(ring, GobEncoder), nil, data)
+	assert.Equal(t, "flamego", sess.Get("name"))
+	assert.True(t, sess.HasChanged())
+
+	binary, err = sess.Encode()
+	require.NoError(t, err)
+	data, err = EncryptedDecoder(ring, GobDecoder)(binary)
+	require.NoError(t, err)
+	sess = NewBaseSessionWithData("1", nil, nil, data)
+	assert.Equal(t, Data{"name": "flamego"}, sess.Data())
+	assert.False(t, sess.HasChanged())
+
+	_, err = EncryptedDecoder(KeyRing{bytes.Repeat([]byte("x"), 32)}, GobDecoder)(binary)
+	assert.Error(t, err)
+
+	assert.Panics(t, func() { EncryptedEncoder(KeyRing{[]byte("short")}, GobEncoder) })
+	assert.Panics(t, func() { EncryptedDecoder(nil, GobDecoder) })
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/flamego/flamego"
+)
+
+// CSRFOptions contains options for the session.CSRF middleware.
+type CSRFOptions struct {
+	// Keys is the key ring to derive CSRF tokens from session IDs with
+	// HMAC-SHA256, tokens derived with any of the keys are accepted. It is
+	// required.
+	Keys KeyRing
+	// CookieName is the name of the CSRF cookie. Default is "flamego_csrf".
+	CookieName string
+	// CookiePath is the Path attribute of the CSRF cookie. Default is "/".
+	CookiePath string
+	// CookieDomain is the Domain attribute of the CSRF cookie. Default is not set.
+	CookieDomain string
+	// Secure specifies whether to set Secure for the CSRF cookie.
+	Secure bool
+	// SameSite is the SameSite attribute of the CSRF cookie. Default is
+	// http.SameSiteLaxMode.
+	SameSite http.SameSite
+	// Header is the name of the request header that carries the CSRF token.
+	// Default is "X-CSRF-Token".
+	Header string
+}
+
+// csrfPayload is the prefix of the payload to derive CSRF tokens from, which
+// keeps CSRF tokens apart from other signatures by the same keys.
+const csrfPayload = "flamego::session::csrf:"
+
+// token returns the CSRF token of the session with given ID.
+func (opt CSRFOptions) token(sid string) string {
+	return base64.RawURLEncoding.EncodeToString(opt.Keys.Sign([]byte(csrfPayload + sid)))
+}
+
+// verify returns true if the request carries the same CSRF token in both the
+// cookie and the header, and the token is derived from the session ID.
+func (opt CSRFOptions) verify(r *http.Request, sid string) bool {
+	token := r.Header.Get(opt.Header)
+	cookie, err := r.Cookie(opt.CookieName)
+	if token == "" || err != nil || subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) != 1 {
+		return false
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return false
+	}
+	ok, _ := opt.Keys.Verify([]byte(csrfPayload+sid), signature)
+	return ok
+}
+
+// csrfSafeMethod returns true if the HTTP method is not meant to change states.
+func csrfSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// CSRF returns a middleware that guards against CSRF with double-submit
+// cookies, which must be used after the session.Sessioner. It is meant for
+// single-page applications that cannot render CSRF tokens into forms, and can
+// be used for a group of routes with options of its own.
+//
+// A CSRF token is derived from the session ID and issued in a cookie that is
+// readable by JavaScript, which is to be sent back in the header by the client.
+// Requests with unsafe methods (i.e. other than GET, HEAD, OPTIONS and TRACE)
+// are rejected with 403 Forbidden unless the header and the cookie carry the
+// same token of the current session. Because tokens are bound to session IDs,
+// a new token is issued whenever the session ID changes, e.g. on sign-in.
+//
+// Requests without a session (see Options.DisableAutoCreate) or with an
+// ephemeral session (see session.IsEphemeral) carry no session to be forged,
+// thus they are neither checked nor issued tokens.
+//
+// Example:
+//
+//	f.Group("/api", func() {
+//		f.Post("/orders", handleCreateOrder)
+//	}, session.CSRF(session.CSRFOptions{Keys: keys}))
+func CSRF(opt CSRFOptions) flamego.Handler {
+	if len(opt.Keys) == 0 {
+		panic("session: CSRF: no key")
+	}
+	if opt.CookieName == "" {
+		opt.CookieName = "flamego_csrf"
+	}
+	if opt.CookiePath == "" {
+		opt.CookiePath = "/"
+	}
+	if opt.SameSite == 0 {
+		opt.SameSite = http.SameSiteLaxMode
+	}
+	if opt.Header == "" {
+		opt.Header = "X-CSRF-Token"
+	}
+
+	return flamego.ContextInvoker(func(c flamego.Context) {
+		s, _, err := fromContext(c)
+		if err != nil {
+			panic("session: " + err.Error())
+		}
+
+		r := c.Request().Request
+		if !csrfSafeMethod(r.Method) && IsStarted(s) && !IsEphemeral(s) && !opt.verify(r, s.ID()) {
+			c.ResponseWriter().WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		// The session may be started or have its ID regenerated by handlers, issue
+		// the token of the final session ID.
+		issued := false
+		issue := func(w http.ResponseWriter) {
+			if issued || !IsStarted(s) || IsEphemeral(s) {
+				return
+			}
+			issued = true
+
+			token := opt.token(s.ID())
+			if cookie, err := r.Cookie(opt.CookieName); err == nil && cookie.Value == token {
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     opt.CookieName,
+				Value:    token,
+				Path:     opt.CookiePath,
+				Domain:   opt.CookieDomain,
+				Secure:   opt.Secure,
+				HttpOnly: false, // The token must be readable by JavaScript
+				SameSite: opt.SameSite,
+			})
+		}
+		c.ResponseWriter().Before(func(w flamego.ResponseWriter) { issue(w) })
+		c.Next()
+		if !c.ResponseWriter().Written() {
+			issue(c.ResponseWriter())
+		}
+	})
+}
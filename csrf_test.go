@@ -0,0 +1,133 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestCSRF(t *testing.T) {
+	newServer := func(keys KeyRing) *flamego.Flame {
+		f := flamego.NewWithLogger(&bytes.Buffer{})
+		f.Use(Sessioner(Options{GCMode: GCDisabled}))
+		f.Group("/api", func() {
+			f.Get("/", func(s Session) { s.Set("username", "flamego") })
+			f.Post("/", func() string { return "ok" })
+		}, CSRF(CSRFOptions{Keys: keys}))
+		f.Post("/form", func() string { return "ok" })
+		return f
+	}
+	do := func(f *flamego.Flame, method, path string, cookies []*http.Cookie, token string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(method, path, nil)
+		require.NoError(t, err)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		if token != "" {
+			req.Header.Set("X-CSRF-Token", token)
+		}
+		f.ServeHTTP(resp, req)
+		return resp
+	}
+	cookieOf := func(resp *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, cookie := range resp.Result().Cookies() {
+			if cookie.Name == name {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	oldKey := []byte("old-key")
+	f := newServer(KeyRing{oldKey})
+
+	resp := do(f, http.MethodGet, "/api/", nil, "")
+	session := cookieOf(resp, "flamego_session")
+	require.NotNil(t, session)
+	csrf := cookieOf(resp, "flamego_csrf")
+	require.NotNil(t, csrf)
+	assert.False(t, csrf.HttpOnly)
+
+	// The token is not issued again when it is up to date
+	resp = do(f, http.MethodGet, "/api/", []*http.Cookie{session, csrf}, "")
+	assert.Nil(t, cookieOf(resp, "flamego_csrf"))
+
+	t.Run("missing header", func(t *testing.T) {
+		resp := do(f, http.MethodPost, "/api/", []*http.Cookie{session, csrf}, "")
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("mismatched header", func(t *testing.T) {
+		resp := do(f, http.MethodPost, "/api/", []*http.Cookie{session, csrf}, csrf.Value+"x")
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("token of another session", func(t *testing.T) {
+		other := cookieOf(do(f, http.MethodGet, "/api/", nil, ""), "flamego_csrf")
+		require.NotNil(t, other)
+		resp := do(f, http.MethodPost, "/api/", []*http.Cookie{session, other}, other.Value)
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		resp := do(f, http.MethodPost, "/api/", []*http.Cookie{session, csrf}, csrf.Value)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "ok", resp.Body.String())
+	})
+
+	t.Run("routes outside the group", func(t *testing.T) {
+		resp := do(f, http.MethodPost, "/form", []*http.Cookie{session}, "")
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		f := newServer(KeyRing{[]byte("new-key"), oldKey})
+		resp := do(f, http.MethodPost, "/api/", []*http.Cookie{session, csrf}, csrf.Value)
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		// The token is upgraded to the current key
+		upgraded := cookieOf(resp, "flamego_csrf")
+		require.NotNil(t, upgraded)
+		assert.NotEqual(t, csrf.Value, upgraded.Value)
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		assert.Panics(t, func() { CSRF(CSRFOptions{}) })
+	})
+}
+
+func TestCSRF_DeferredSession(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(Options{GCMode: GCDisabled, DisableAutoCreate: true}))
+	f.Use(CSRF(CSRFOptions{Keys: KeyRing{[]byte("key")}}))
+	f.Post("/", func() string { return "ok" })
+	f.Post("/start", func(s Session) { s.Set("username", "flamego") })
+
+	// Requests without a session are not checked, nor issued tokens
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Empty(t, resp.Header().Get("Set-Cookie"))
+
+	// The token is issued once the session is started
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodPost, "/start", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, strings.Join(resp.Header().Values("Set-Cookie"), "\n"), "flamego_csrf=")
+}
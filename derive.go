@@ -0,0 +1,45 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"github.com/flamego/flamego"
+)
+
+// mappedTo is a derived value to be mapped to an interface type.
+type mappedTo struct {
+	val      interface{} // The derived value
+	ifacePtr interface{} // The pointer to the interface type
+}
+
+// MapTo returns a derived value that is mapped to the interface type pointed
+// by the `ifacePtr` instead of its own type when returned by
+// Options.DeriveFunc, e.g.
+//
+//	DeriveFunc: func(s session.Session) []interface{} {
+//		return []interface{}{
+//			session.MapTo(currentUser(s), (*User)(nil)),
+//		}
+//	}
+func MapTo(val interface{}, ifacePtr interface{}) interface{} {
+	return mappedTo{
+		val:      val,
+		ifacePtr: ifacePtr,
+	}
+}
+
+// mapDerived maps values derived from the session to the request context.
+// Nil values are skipped.
+func mapDerived(c flamego.Context, s Session, deriveFunc func(s Session) []interface{}) {
+	for _, v := range deriveFunc(s) {
+		switch v := v.(type) {
+		case nil:
+		case mappedTo:
+			c.MapTo(v.val, v.ifacePtr)
+		default:
+			c.Map(v)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+type testUser struct {
+	Name string
+}
+
+type testNamer interface {
+	name() string
+}
+
+func (u *testUser) name() string { return u.Name }
+
+func TestSessioner_DeriveFunc(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			DeriveFunc: func(s Session) []interface{} {
+				username, _ := s.Get("username").(string)
+				user := &testUser{Name: username}
+				return []interface{}{user, MapTo(user, (*testNamer)(nil)), nil}
+			},
+		},
+	))
+	f.Get("/set", func(s Session) {
+		s.Set("username", "flamego")
+	})
+	f.Get("/", func(user *testUser, namer testNamer) string {
+		return fmt.Sprintf("%s %s", user.Name, namer.name())
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/set", nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	cookie := resp.Header().Get("Set-Cookie")
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+
+	req.Header.Set("Cookie", cookie)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, "flamego flamego", resp.Body.String())
+}
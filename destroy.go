@@ -0,0 +1,68 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/flamego/flamego"
+)
+
+// requestState is the state of the session of the current request, which is
+// injected into the request context by the session.Sessioner.
+type requestState struct {
+	created   bool   // Whether the session was created by the current request
+	freshID   bool   // Whether the session ID was issued by the current request
+	discarded bool   // Whether the session is not to be saved at the end of the request
+	clearID   func() // The function to clear the session ID from the client
+
+	// The function to destroy sessions in the session store through the manager,
+	// i.e. with the configured timeouts and retries
+	destroy func(ctx context.Context, sid string) error
+
+	reportError func(err error) // The function to report errors, i.e. Options.ErrorFunc
+}
+
+// requestStateOf returns the state of the session of the current request, or
+// nil if the session.Sessioner is not used.
+func requestStateOf(c flamego.Context) *requestState {
+	state := c.Value(reflect.TypeOf((*requestState)(nil)))
+	if !state.IsValid() {
+		return nil
+	}
+	return state.Interface().(*requestState)
+}
+
+// Destroy destroys the session of the current request in the session store and
+// clears the session ID from the client (see Options.ClearIDFunc), so that the
+// next request starts over without the session. The session is no longer saved
+// at the end of the request. It must be called after the session.Sessioner and
+// before the response header is written.
+func Destroy(c flamego.Context) error {
+	s, _, err := fromContext(c)
+	if err != nil {
+		return err
+	}
+	state := requestStateOf(c)
+	if state == nil {
+		return errors.New("no session state in the request context, is session.Sessioner used?")
+	}
+	if headerWritten(c.ResponseWriter()) {
+		return ErrHeaderWritten
+	}
+
+	if IsStarted(s) && !IsEphemeral(s) {
+		err = state.destroy(c.Request().Context(), s.ID())
+		if err != nil {
+			return fmt.Errorf("destroy %q: %w", s.ID(), err)
+		}
+	}
+	state.discarded = true
+	state.clearID()
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestDestroy(t *testing.T) {
+	var store Store
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Initer: func(ctx context.Context, args ...interface{}) (Store, error) {
+				var err error
+				store, err = FileIniter()(ctx, args...)
+				return store, err
+			},
+			Config: FileConfig{
+				RootDir: t.TempDir(),
+			},
+		},
+	))
+	f.Get("/", func(s Session) string {
+		s.Set("name", "flamego")
+		return s.ID()
+	})
+	f.Get("/destroy", func(c flamego.Context) {
+		require.NoError(t, Destroy(c))
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	sid := resp.Body.String()
+	cookie := strings.Split(resp.Header().Get("Set-Cookie"), ";")[0]
+	assert.True(t, store.Exist(context.Background(), sid))
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/destroy", nil)
+	require.NoError(t, err)
+	req.Header.Set("Cookie", cookie)
+	f.ServeHTTP(resp, req)
+
+	assert.Equal(t, "flamego_session=; Path=/; Max-Age=0; HttpOnly; SameSite=Lax", resp.Header().Get("Set-Cookie"))
+	assert.False(t, store.Exist(context.Background(), sid), "the destroyed session is not saved again")
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultDraftTTL is the default duration to keep a draft since it was last
+	// saved.
+	DefaultDraftTTL = 24 * time.Hour
+	// DefaultDraftMaxSize is the default maximum size in bytes of the Gob
+	// encoding of values of a draft.
+	DefaultDraftMaxSize = 64 << 10
+)
+
+// ErrDraftTooLarge is returned when saving a value would make the draft exceed
+// its maximum size.
+var ErrDraftTooLarge = errors.New("draft too large")
+
+// Draft is the partial state of a form that is kept in the session for users
+// to restore unsaved changes, e.g. auto-saved periodically by the frontend.
+// Each save extends the draft to be kept for its TTL.
+type Draft struct {
+	s       Session       // The session that the draft belongs to
+	key     string        // The session key of the draft
+	ttl     time.Duration // The duration to keep the draft since it was last saved
+	maxSize int           // The maximum size in bytes of the Gob encoding of values
+}
+
+// newDraft returns the draft of the form with given ID in the session, with the
+// default TTL and maximum size.
+func newDraft(s Session, formID string) *Draft {
+	return &Draft{
+		s:       s,
+		key:     draftKeyPrefix + formID,
+		ttl:     DefaultDraftTTL,
+		maxSize: DefaultDraftMaxSize,
+	}
+}
+
+// DraftOf returns the draft of the form with given ID in the session, which
+// keeps partial state of the form for users to restore unsaved changes. Drafts
+// expire after session.DefaultDraftTTL since they were last saved unless
+// configured otherwise, see Draft.WithTTL.
+func DraftOf(s Session, formID string) *Draft {
+	return newDraft(s, formID)
+}
+
+// WithTTL returns a copy of the draft that is kept for the duration since it
+// was last saved.
+func (d *Draft) WithTTL(ttl time.Duration) *Draft {
+	dd := *d
+	dd.ttl = ttl
+	return &dd
+}
+
+// WithMaxSize returns a copy of the draft whose values are limited to the
+// maximum size in bytes of their Gob encoding.
+func (d *Draft) WithMaxSize(maxSize int) *Draft {
+	dd := *d
+	dd.maxSize = maxSize
+	return &dd
+}
+
+// data returns the values and the time when the draft was last saved, which
+// must not be modified.
+func (d *Draft) data() (values Data, savedAt time.Time) {
+	data, _ := d.s.Get(d.key).(Data)
+	values, _ = data["values"].(Data)
+	if ns, ok := data["saved_at"].(int64); ok {
+		savedAt = time.Unix(0, ns)
+	}
+	return values, savedAt
+}
+
+// Set saves the value of given key in the draft. It returns
+// session.ErrDraftTooLarge if the draft would exceed its maximum size, in which
+// case the draft is unchanged.
+func (d *Draft) Set(key string, val interface{}) error {
+	old, _ := d.data()
+	values := make(Data, len(old)+1)
+	for k, v := range old {
+		values[k] = v
+	}
+	values[key] = val
+
+	binary, err := GobEncoder(values)
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	} else if len(binary) > d.maxSize {
+		return ErrDraftTooLarge
+	}
+
+	setInternalWithTTL(d.s, d.key, Data{
+		"values":   values,
+		"saved_at": nowOf(d.s).UnixNano(),
+	}, d.ttl)
+	return nil
+}
+
+// Get returns the value of given key in the draft. It returns nil if no such
+// key exists.
+func (d *Draft) Get(key string) interface{} {
+	values, _ := d.data()
+	return values[key]
+}
+
+// Values returns a copy of all values of the draft, e.g. to restore the form.
+// It returns nil if there is no draft.
+func (d *Draft) Values() map[string]interface{} {
+	values, _ := d.data()
+	if values == nil {
+		return nil
+	}
+
+	m := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if k, ok := k.(string); ok {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// SavedAt returns the time when the draft was last saved. It returns zero time
+// if there is no draft.
+func (d *Draft) SavedAt() time.Time {
+	_, savedAt := d.data()
+	return savedAt
+}
+
+// Discard deletes the draft, e.g. once the form is submitted.
+func (d *Draft) Discard() {
+	deleteInternal(d.s, d.key)
+}
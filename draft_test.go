@@ -0,0 +1,52 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDraft(t *testing.T) {
+	s := NewBaseSession("1", GobEncoder, nil)
+	d := DraftOf(s, "signup")
+	assert.Nil(t, d.Values())
+	assert.True(t, d.SavedAt().IsZero())
+
+	require.NoError(t, d.Set("email", "alice@example.com"))
+	require.NoError(t, d.Set("name", "Alice"))
+	assert.Equal(t, "Alice", DraftOf(s, "signup").Get("name"))
+	assert.Equal(t, map[string]interface{}{"email": "alice@example.com", "name": "Alice"}, d.Values())
+	assert.False(t, d.SavedAt().IsZero())
+
+	// Drafts of different forms and scopes are isolated
+	assert.Nil(t, DraftOf(s, "checkout").Values())
+	assert.Nil(t, DraftOf(Scope(s, "plugin"), "signup").Values())
+
+	d.Discard()
+	assert.Nil(t, d.Values())
+}
+
+func TestDraft_TTL(t *testing.T) {
+	s := NewBaseSession("1", GobEncoder, nil)
+	d := DraftOf(s, "signup").WithTTL(time.Millisecond)
+	require.NoError(t, d.Set("name", "Alice"))
+
+	time.Sleep(2 * time.Millisecond)
+	assert.Nil(t, d.Values(), "expired draft is restored")
+}
+
+func TestDraft_MaxSize(t *testing.T) {
+	s := NewBaseSession("1", GobEncoder, nil)
+	d := DraftOf(s, "signup").WithMaxSize(256)
+	require.NoError(t, d.Set("name", "Alice"))
+
+	assert.Equal(t, ErrDraftTooLarge, d.Set("bio", strings.Repeat("a", 256)))
+	assert.Equal(t, map[string]interface{}{"name": "Alice"}, d.Values(), "draft is changed")
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"sort"
+)
+
+// sortedData is the envelope of session data with entries sorted by their
+// keys, whose Gob encoding is deterministic unlike maps.
+type sortedData []sortedEntry
+
+// sortedEntry is a key-value pair of the session data in the envelope.
+type sortedEntry struct {
+	Key   interface{}
+	Value interface{}
+}
+
+func init() {
+	// Nested session data is stored as the envelope, which needs to be registered
+	// to be encoded as an interface value.
+	gob.Register(sortedData{})
+}
+
+// toSortedData returns the envelope of the data, nested session data is
+// converted recursively.
+func toSortedData(data Data) sortedData {
+	sorted := make(sortedData, 0, len(data))
+	for k, v := range data {
+		if nested, ok := v.(Data); ok {
+			v = toSortedData(nested)
+		}
+		sorted = append(sorted, sortedEntry{Key: k, Value: v})
+	}
+
+	sortKeys := make(map[interface{}]string, len(sorted))
+	for _, e := range sorted {
+		sortKeys[e.Key] = fmt.Sprintf("%T\x00%v", e.Key, e.Key)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sortKeys[sorted[i].Key] < sortKeys[sorted[j].Key]
+	})
+	return sorted
+}
+
+// toData returns the session data of the envelope, nested envelopes are
+// converted recursively.
+func (d sortedData) toData() Data {
+	data := make(Data, len(d))
+	for _, e := range d {
+		v := e.Value
+		if nested, ok := v.(sortedData); ok {
+			v = nested.toData()
+		}
+		data[e.Key] = v
+	}
+	return data
+}
+
+// DeterministicGobEncoder is a session data encoder using Gob, which encodes
+// the same session data to identical bytes by sorting keys of the session data
+// (including nested session.Data). Maps of other types in the session data
+// are encoded as-is, thus in random order. It must be used with the
+// session.DeterministicGobDecoder.
+func DeterministicGobEncoder(data Data) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(toSortedData(data))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DeterministicGobDecoder is a session data decoder for the
+// session.DeterministicGobEncoder. It falls back to the session.GobDecoder for
+// session data that was encoded by the session.GobEncoder, which eases
+// switching encoders of existing sessions.
+func DeterministicGobDecoder(binary []byte) (Data, error) {
+	var sorted sortedData
+	err := gob.NewDecoder(bytes.NewReader(binary)).Decode(&sorted)
+	if err == nil {
+		return sorted.toData(), nil
+	}
+
+	data, fallbackErr := GobDecoder(binary)
+	if fallbackErr != nil {
+		return nil, &DataError{Op: "decode", Size: len(binary), Err: err}
+	}
+	return data, nil
+}
+
+// encodingTracker is a session that remembers the digest of its encoding when
+// loaded, which tells whether the session has changed by its content rather
+// than by operations made to it.
+type encodingTracker interface {
+	// trackEncoding remembers the digest of the current encoding.
+	trackEncoding() error
+	// identicalEncoding returns true if the current encoding is identical to the
+	// remembered one.
+	identicalEncoding() bool
+}
+
+// digest returns the digest of the encoding and the tags of the session. It is
+// not concurrent-safe and is the caller's responsibility to ensure the lock is
+// held.
+func (s *BaseSession) digest() ([]byte, error) {
+	s.syncBindings()
+	s.expire()
+	binary, err := s.encoder(s.data)
+	if err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+
+	h := sha256.New()
+	_, _ = h.Write(binary)
+	keys := make([]string, 0, len(s.tags))
+	for k := range s.tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		_, _ = fmt.Fprintf(h, "\x00%s\x00%s", k, s.tags[k])
+	}
+	return h.Sum(nil), nil
+}
+
+func (s *BaseSession) trackEncoding() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Sessions without an encoder (e.g. of the memory session store) are never
+	// encoded, thus nothing to compare. Sessions that have changed when loaded
+	// (e.g. to be upgraded with the current key) must be saved regardless.
+	if s.encoder == nil || s.changed {
+		return nil
+	}
+
+	digest, err := s.digest()
+	if err != nil {
+		return err
+	}
+	s.loadedDigest = digest
+	return nil
+}
+
+func (s *BaseSession) identicalEncoding() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.loadedDigest == nil {
+		return false
+	}
+	digest, err := s.digest()
+	return err == nil && bytes.Equal(digest, s.loadedDigest)
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestDeterministicGobEncoder(t *testing.T) {
+	newData := func() Data {
+		data := make(Data)
+		for i := 0; i < 20; i++ {
+			data["key"+strconv.Itoa(i)] = i
+			data[i] = Data{"nested" + strconv.Itoa(i): true, "other": "value"}
+		}
+		return data
+	}
+
+	want, err := DeterministicGobEncoder(newData())
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		got, err := DeterministicGobEncoder(newData())
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	data, err := DeterministicGobDecoder(want)
+	require.NoError(t, err)
+	assert.Equal(t, newData(), data)
+
+	// Session data encoded by the GobEncoder can still be decoded
+	binary, err := GobEncoder(newData())
+	require.NoError(t, err)
+	data, err = DeterministicGobDecoder(binary)
+	require.NoError(t, err)
+	assert.Equal(t, newData(), data)
+
+	_, err = DeterministicGobDecoder([]byte("garbage"))
+	assert.Error(t, err)
+}
+
+func TestSessioner_SkipIdenticalSave(t *testing.T) {
+	var store *writeCountingStore
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Initer: func(ctx context.Context, args ...interface{}) (Store, error) {
+				file, err := FileIniter()(ctx, args...)
+				if err != nil {
+					return nil, err
+				}
+				store = &writeCountingStore{Store: file}
+				return store, nil
+			},
+			Config: FileConfig{
+				RootDir: t.TempDir(),
+				Encoder: DeterministicGobEncoder,
+				Decoder: DeterministicGobDecoder,
+			},
+			SkipIdenticalSave: true,
+		},
+	))
+	f.Get("/set", func(s Session) {
+		s.Set("username", "flamego")
+		s.Set("profile", Data{"name": "Flamego", "lang": "Go"})
+	})
+	f.Get("/tag", func(s Session) {
+		Tag(s, "device", "laptop")
+	})
+
+	var cookie string
+	request := func(path string) {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if cookie == "" {
+			cookie = resp.Header().Get("Set-Cookie")
+		}
+	}
+
+	request("/set")
+	assert.Equal(t, 1, store.saves)
+
+	// Writing identical values touches the session instead
+	request("/set")
+	assert.Equal(t, 1, store.saves)
+	assert.Equal(t, 1, store.touches)
+
+	// Changes of tags are saved
+	request("/tag")
+	assert.Equal(t, 2, store.saves)
+}
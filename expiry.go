@@ -0,0 +1,182 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ExpiryEvent is the event of a session that has expired and been recycled by
+// a GC operation, e.g. to learn about users that are signed out by timeout.
+type ExpiryEvent struct {
+	// SessionID is the ID of the expired session.
+	SessionID string `json:"session_id"`
+	// UserID is the ID of the user that was signed in via session.SignIn, or
+	// empty if no user was signed in.
+	UserID string `json:"user_id,omitempty"`
+	// LastSeenAt is the time when the session was last used (see
+	// session.SessionInfo), or zero time if unknown.
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// ExpiredAt is the time when the session was recycled.
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+// newExpiryEvent returns the expiry event of the session with given ID and
+// data, which was recycled at given time.
+func newExpiryEvent(sid string, data Data, expiredAt time.Time) ExpiryEvent {
+	event := ExpiryEvent{
+		SessionID: sid,
+		ExpiredAt: expiredAt,
+	}
+	if auth, ok := data[authKey].(Data); ok {
+		event.UserID, _ = auth["user_id"].(string)
+	}
+	if info, ok := data[infoKey].(Data); ok {
+		if ns, ok := info["last_seen_at"].(int64); ok {
+			event.LastSeenAt = time.Unix(0, ns)
+		}
+	}
+	return event
+}
+
+// ExpirySink is the destination of expiry events of sessions, see
+// Options.ExpirySink.
+type ExpirySink interface {
+	// Send delivers the batch of expiry events.
+	Send(ctx context.Context, events []ExpiryEvent) error
+}
+
+var _ ExpirySink = (*WebhookSink)(nil)
+
+// WebhookSink is an expiry sink that delivers each batch of expiry events as a
+// JSON array in the body of a POST request to the URL.
+type WebhookSink struct {
+	// URL is the URL of the webhook.
+	URL string
+	// Header is the additional header of requests, e.g. the authorization of the
+	// webhook.
+	Header http.Header
+	// Client is the HTTP client to send requests. Default is http.DefaultClient,
+	// which relies on the deadline of the context (see
+	// Options.ExpirySendTimeout) to not hang forever.
+	Client *http.Client
+}
+
+// Send implements `ExpirySink.Send`. Responses with status codes other than 2xx
+// are treated as errors.
+func (s *WebhookSink) Send(ctx context.Context, events []ExpiryEvent) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	for k, vs := range s.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// expiryBatchSize is the maximum number of expiry events to be sent at once.
+const expiryBatchSize = 100
+
+// expiryNotifier enqueues expiry events of sessions recycled by GC operations,
+// and sends them to the expiry sink in a background goroutine so that GC
+// operations are never blocked by the expiry sink.
+type expiryNotifier struct {
+	sink    ExpirySink       // The destination of expiry events
+	queue   chan ExpiryEvent // The queue of expiry events to be sent
+	timeout time.Duration    // The timeout for each delivery of expiry events
+	nowFunc func() time.Time // The function to return the current time
+	errFunc func(error)      // The function to print errors of sending expiry events
+}
+
+// newExpiryNotifier returns a new expiry notifier with the queue of given size,
+// and starts the background goroutine to send expiry events. Each delivery of
+// expiry events is given up after the timeout.
+func newExpiryNotifier(sink ExpirySink, queueSize int, timeout time.Duration, nowFunc func() time.Time, errFunc func(error)) *expiryNotifier {
+	n := &expiryNotifier{
+		sink:    sink,
+		queue:   make(chan ExpiryEvent, queueSize),
+		timeout: timeout,
+		nowFunc: nowFunc,
+		errFunc: errFunc,
+	}
+	go n.run()
+	return n
+}
+
+// onExpire returns an OnExpireFunc that enqueues expiry events, then calls the
+// next function if it is not nil. Events are dropped with errors printed when
+// the queue is full.
+func (n *expiryNotifier) onExpire(next OnExpireFunc) OnExpireFunc {
+	return func(ctx context.Context, sid string, data Data) {
+		select {
+		case n.queue <- newExpiryEvent(sid, data, n.nowFunc()):
+		default:
+			n.errFunc(fmt.Errorf("expiry queue is full, dropped event of %q", sid))
+		}
+
+		if next != nil {
+			next(ctx, sid, data)
+		}
+	}
+}
+
+// run sends enqueued expiry events in batches of events that are available at
+// once.
+func (n *expiryNotifier) run() {
+	for event := range n.queue {
+		batch := []ExpiryEvent{event}
+	drain:
+		for len(batch) < expiryBatchSize {
+			select {
+			case event := <-n.queue:
+				batch = append(batch, event)
+			default:
+				break drain
+			}
+		}
+
+		err := n.send(batch)
+		if err != nil {
+			n.errFunc(fmt.Errorf("send %d expiry events: %w", len(batch), err))
+		}
+	}
+}
+
+// send delivers the batch of expiry events to the expiry sink with the timeout.
+func (n *expiryNotifier) send(batch []ExpiryEvent) error {
+	ctx, cancel := withTimeout(context.Background(), n.timeout)
+	defer cancel()
+	return n.sink.Send(ctx, batch)
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiryNotifier(t *testing.T) {
+	received := make(chan []ExpiryEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		var events []ExpiryEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&events))
+		received <- events
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	store := newMemoryStore(
+		MemoryConfig{
+			NowFunc:  func() time.Time { return now },
+			Lifetime: time.Second,
+		},
+		nil,
+	)
+	sess, err := store.Read(ctx, "1")
+	require.NoError(t, err)
+	lastSeenAt := time.Unix(0, now.UnixNano()).UTC()
+	setInternal(sess, authKey, Data{"user_id": "alice"})
+	setInternal(sess, infoKey, Data{"last_seen_at": lastSeenAt.UnixNano()})
+	now = now.Add(2 * time.Second)
+
+	sink := &WebhookSink{
+		URL:    server.URL,
+		Header: http.Header{"Authorization": []string{"Bearer token"}},
+	}
+	notifier := newExpiryNotifier(sink, 10, time.Minute, func() time.Time { return now }, func(err error) { t.Error(err) })
+	var archived []string
+	m := newManager(store, Options{
+		OnExpire: notifier.onExpire(func(_ context.Context, sid string, _ Data) {
+			archived = append(archived, sid)
+		}),
+		OnExpireBatchSize: 10,
+	})
+	require.NoError(t, m.gc(ctx))
+	assert.Equal(t, []string{"1"}, archived, "the next OnExpire is called")
+
+	select {
+	case events := <-received:
+		require.Len(t, events, 1)
+		assert.Equal(t, "1", events[0].SessionID)
+		assert.Equal(t, "alice", events[0].UserID)
+		assert.True(t, lastSeenAt.Equal(events[0].LastSeenAt))
+		assert.True(t, now.Equal(events[0].ExpiredAt))
+	case <-time.After(5 * time.Second):
+		t.Fatal("no events received")
+	}
+}
+
+func TestWebhookSink_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	sink := &WebhookSink{URL: server.URL}
+	err := sink.Send(context.Background(), []ExpiryEvent{{SessionID: "1"}})
+	assert.EqualError(t, err, "unexpected status code 503")
+}
+
+// blockingSink is an expiry sink that blocks until the context is done.
+type blockingSink struct{}
+
+func (blockingSink) Send(ctx context.Context, _ []ExpiryEvent) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestExpiryNotifier_Timeout(t *testing.T) {
+	errs := make(chan error, 2)
+	notifier := newExpiryNotifier(blockingSink{}, 10, 10*time.Millisecond, time.Now, func(err error) { errs <- err })
+	onExpire := notifier.onExpire(nil)
+	onExpire(context.Background(), "1", nil)
+
+	// The notifier moves on to later events after the timeout
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		case <-time.After(5 * time.Second):
+			t.Fatal("sending is not timed out")
+		}
+		onExpire(context.Background(), "2", nil)
+	}
+}
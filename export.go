@@ -0,0 +1,304 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// exportVersion is the version of the JSON envelope of exported sessions.
+const exportVersion = 1
+
+// exportEnvelope is the JSON envelope of an exported session.
+type exportEnvelope struct {
+	Version int               `json:"version"`
+	ID      string            `json:"id"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	Data    []exportEntry     `json:"data"`
+}
+
+// exportEntry is a key-value pair of the session data in the JSON envelope.
+type exportEntry struct {
+	Key   typedValue `json:"key"`
+	Value typedValue `json:"value"`
+}
+
+// typedValue is a value with the hint of its Go type in the JSON envelope.
+// Values of "data" are lists of key-value pairs, and values of "list" are
+// lists of typed values.
+type typedValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Export exports the session ID, the session data and tags of the session as
+// JSON with type hints, which can be imported by session.Import regardless of
+// the encoders of session stores. Supported types of keys and values are nil,
+// bool, string, signed and unsigned integers, float32, float64, []byte,
+// []string, []interface{}, time.Time, time.Duration and session.Data, any
+// other type results in an error. Values put by session.PutBlob are exported as
+// references to the blobs.
+func Export(s Session) ([]byte, error) {
+	ds, ok := s.(interface{ Data() Data })
+	if !ok {
+		return nil, fmt.Errorf("session with the type %T does not expose its data", s)
+	}
+
+	entries, err := exportData(ds.Data())
+	if err != nil {
+		return nil, err
+	}
+
+	tags := TagsOf(s)
+	if len(tags) == 0 {
+		tags = nil
+	}
+	return json.MarshalIndent(exportEnvelope{
+		Version: exportVersion,
+		ID:      s.ID(),
+		Tags:    tags,
+		Data:    entries,
+	}, "", "  ")
+}
+
+// exportData returns key-value pairs of the data in the JSON envelope, sorted
+// by their keys for stable output.
+func exportData(data Data) ([]exportEntry, error) {
+	entries := make([]exportEntry, 0, len(data))
+	for k, v := range data {
+		key, err := exportValue(k)
+		if err != nil {
+			return nil, fmt.Errorf("key %v: %w", k, err)
+		}
+		val, err := exportValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("value of key %v: %w", k, err)
+		}
+		entries = append(entries, exportEntry{Key: key, Value: val})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Key.Type != entries[j].Key.Type {
+			return entries[i].Key.Type < entries[j].Key.Type
+		}
+		return string(entries[i].Key.Value) < string(entries[j].Key.Value)
+	})
+	return entries, nil
+}
+
+// exportValue returns the value with the hint of its type.
+func exportValue(v interface{}) (typedValue, error) {
+	var typ string
+	var val interface{}
+	switch v := v.(type) {
+	case nil:
+		return typedValue{Type: "nil"}, nil
+	case bool, string, float32, float64:
+		typ, val = fmt.Sprintf("%T", v), v
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		// Integers are quoted to not lose precision in JSON numbers
+		typ, val = fmt.Sprintf("%T", v), fmt.Sprint(v)
+	case []byte:
+		typ, val = "bytes", base64.StdEncoding.EncodeToString(v)
+	case []string:
+		typ, val = "strings", v
+	case time.Time:
+		typ, val = "time", v.Format(time.RFC3339Nano)
+	case time.Duration:
+		typ, val = "duration", v.String()
+	case []interface{}:
+		list := make([]typedValue, 0, len(v))
+		for i := range v {
+			elem, err := exportValue(v[i])
+			if err != nil {
+				return typedValue{}, fmt.Errorf("element %d: %w", i, err)
+			}
+			list = append(list, elem)
+		}
+		typ, val = "list", list
+	case Data:
+		entries, err := exportData(v)
+		if err != nil {
+			return typedValue{}, err
+		}
+		typ, val = "data", entries
+	case blobRef:
+		typ, val = "blob", v.ID
+	default:
+		return typedValue{}, fmt.Errorf("unsupported type %T", v)
+	}
+
+	raw, err := json.Marshal(val)
+	if err != nil {
+		return typedValue{}, fmt.Errorf("marshal: %w", err)
+	}
+	return typedValue{Type: typ, Value: raw}, nil
+}
+
+// integerTypes are the integer types indexed by their names.
+var integerTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, v := range []interface{}{
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+	} {
+		integerTypes[fmt.Sprintf("%T", v)] = reflect.TypeOf(v)
+	}
+}
+
+// Import replaces the session data and tags of the session with those exported
+// by session.Export. The session ID in the export is ignored, i.e. the export
+// of one session can be imported to another session.
+func Import(s Session, b []byte) error {
+	var envelope exportEnvelope
+	err := json.Unmarshal(b, &envelope)
+	if err != nil {
+		return fmt.Errorf("unmarshal: %w", err)
+	} else if envelope.Version != exportVersion {
+		return fmt.Errorf("unsupported version %d", envelope.Version)
+	}
+
+	data, err := importData(envelope.Data)
+	if err != nil {
+		return err
+	}
+
+	// Bookkeeping keys are kept by Flush, but belong to the export as well
+	s.Flush()
+	for _, k := range bookkeepingKeys() {
+		if _, ok := data[k]; !ok && s.Get(k) != nil {
+			deleteInternal(s, k)
+		}
+	}
+	for k, v := range data {
+		if k != expiriesKey {
+			setInternal(s, k, v)
+		}
+	}
+	// Expiry times must be set last, as setting keys removes their expiry times
+	if expiries, ok := data[expiriesKey]; ok {
+		setInternal(s, expiriesKey, expiries)
+	}
+	for k := range TagsOf(s) {
+		if _, ok := envelope.Tags[k]; !ok {
+			Tag(s, k, "")
+		}
+	}
+	for k, v := range envelope.Tags {
+		Tag(s, k, v)
+	}
+	return nil
+}
+
+// importData returns the data of key-value pairs in the JSON envelope.
+func importData(entries []exportEntry) (Data, error) {
+	data := make(Data, len(entries))
+	for i, e := range entries {
+		key, err := importValue(e.Key)
+		if err != nil {
+			return nil, fmt.Errorf("key of entry %d: %w", i, err)
+		}
+		val, err := importValue(e.Value)
+		if err != nil {
+			return nil, fmt.Errorf("value of key %v: %w", key, err)
+		}
+		data[key] = val
+	}
+	return data, nil
+}
+
+// unmarshalAs unmarshals the JSON value as the type.
+func unmarshalAs[T any](raw json.RawMessage) (T, error) {
+	var v T
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
+
+// importValue returns the value of its type from the value with the type hint.
+func importValue(tv typedValue) (interface{}, error) {
+	if t, ok := integerTypes[tv.Type]; ok {
+		s, err := unmarshalAs[string](tv.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		v := reflect.New(t).Elem()
+		switch t.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n, err := strconv.ParseUint(s, 10, t.Bits())
+			if err != nil {
+				return nil, err
+			}
+			v.SetUint(n)
+		default:
+			n, err := strconv.ParseInt(s, 10, t.Bits())
+			if err != nil {
+				return nil, err
+			}
+			v.SetInt(n)
+		}
+		return v.Interface(), nil
+	}
+
+	switch tv.Type {
+	case "nil":
+		return nil, nil
+	case "bool":
+		return unmarshalAs[bool](tv.Value)
+	case "string":
+		return unmarshalAs[string](tv.Value)
+	case "float32":
+		return unmarshalAs[float32](tv.Value)
+	case "float64":
+		return unmarshalAs[float64](tv.Value)
+	case "strings":
+		return unmarshalAs[[]string](tv.Value)
+	case "bytes", "time", "duration":
+		s, err := unmarshalAs[string](tv.Value)
+		if err != nil {
+			return nil, err
+		}
+		switch tv.Type {
+		case "bytes":
+			return base64.StdEncoding.DecodeString(s)
+		case "time":
+			return time.Parse(time.RFC3339Nano, s)
+		}
+		return time.ParseDuration(s)
+	case "list":
+		list, err := unmarshalAs[[]typedValue](tv.Value)
+		if err != nil {
+			return nil, err
+		}
+		v := make([]interface{}, 0, len(list))
+		for i := range list {
+			elem, err := importValue(list[i])
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			v = append(v, elem)
+		}
+		return v, nil
+	case "data":
+		entries, err := unmarshalAs[[]exportEntry](tv.Value)
+		if err != nil {
+			return nil, err
+		}
+		return importData(entries)
+	case "blob":
+		id, err := unmarshalAs[string](tv.Value)
+		if err != nil {
+			return nil, err
+		}
+		return blobRef{ID: id}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %q", tv.Type)
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 30, 0, 123456789, time.UTC)
+	data := Data{
+		"string":   "flamego",
+		"int":      42,
+		"int64":    int64(9007199254740993),
+		"uint8":    uint8(255),
+		"float64":  3.14,
+		"bool":     true,
+		"nil":      nil,
+		"bytes":    []byte("binary"),
+		"strings":  []string{"a", "b"},
+		"time":     now,
+		"duration": 90 * time.Second,
+		"list":     []interface{}{"a", 1, Data{"nested": true}},
+		"data":     Data{"user": Data{"id": int64(1)}},
+		7:          "non-string key",
+	}
+
+	src := NewBaseSessionWithData("src", GobEncoder, nil, data)
+	Tag(src, UserTag, "alice")
+	SetWithTTL(src, "token", "secret", time.Hour)
+
+	b, err := Export(src)
+	require.NoError(t, err)
+
+	// Exports are stable
+	again, err := Export(src)
+	require.NoError(t, err)
+	assert.Equal(t, string(b), string(again))
+
+	dst := NewBaseSession("dst", GobEncoder, nil)
+	dst.Set("stale", "value")
+	Tag(dst, "stale", "value")
+	require.NoError(t, Import(dst, b))
+
+	assert.Equal(t, "dst", dst.ID())
+	assert.Nil(t, dst.Get("stale"))
+	assert.Equal(t, map[string]string{UserTag: "alice"}, TagsOf(dst))
+	for k, v := range data {
+		assert.Equal(t, v, dst.Get(k), "key %v", k)
+	}
+	assert.Equal(t, "secret", dst.Get("token"))
+	assert.Equal(t, src.Data()[expiriesKey], dst.Data()[expiriesKey], "expiry times are lost")
+}
+
+func TestExport_Unsupported(t *testing.T) {
+	s := NewBaseSession("1", GobEncoder, nil)
+	s.Set("chan", make(chan int))
+	_, err := Export(s)
+	assert.Error(t, err)
+}
+
+func TestImport_Invalid(t *testing.T) {
+	s := NewBaseSession("1", GobEncoder, nil)
+	assert.Error(t, Import(s, []byte(`{"version":2}`)))
+	assert.Error(t, Import(s, []byte(`{"version":1,"data":[{"key":{"type":"string","value":"k"},"value":{"type":"chan"}}]}`)))
+	assert.Error(t, Import(s, []byte(`{"version":1,"data":[{"key":{"type":"string","value":"k"},"value":{"type":"int8","value":"1000"}}]}`)))
+}
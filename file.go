@@ -5,39 +5,56 @@
 package session
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
-
-	"github.com/pkg/errors"
 )
 
 var _ Store = (*fileStore)(nil)
 
 // fileStore is a file implementation of the session store.
 type fileStore struct {
-	nowFunc  func() time.Time // The function to return the current time
-	lifetime time.Duration    // The duration to have no access to a session before being recycled
-	rootDir  string           // The root directory of file session items stored on the local file system
+	nowFunc   func() time.Time // The function to return the current time
+	lifetime  time.Duration    // The duration to have no access to a session before being recycled
+	rootDir   string           // The root directory of file session items stored on the local file system
+	gcWorkers int              // The number of concurrent workers for GC
+	sync      bool             // Whether to flush session files to the stable storage on save
+	keys      KeyRing          // The keys to encrypt session files, empty for no encryption
 
-	encoder  Encoder
-	decoder  Decoder
-	idWriter IDWriter
+	encoder       Encoder
+	decoder       Decoder
+	streamEncoder StreamEncoder // The encoder to stream session data to files, may be nil
+	streamDecoder StreamDecoder // The decoder to stream session data from files, may be nil
+	idWriter      IDWriter
 }
 
 // newFileStore returns a new file session store based on given configuration.
 func newFileStore(cfg FileConfig, idWriter IDWriter) *fileStore {
-	return &fileStore{
-		nowFunc:  cfg.nowFunc,
-		lifetime: cfg.Lifetime,
-		rootDir:  cfg.RootDir,
-		encoder:  cfg.Encoder,
-		decoder:  cfg.Decoder,
-		idWriter: idWriter,
+	store := &fileStore{
+		nowFunc:   cfg.NowFunc,
+		lifetime:  cfg.Lifetime,
+		rootDir:   cfg.RootDir,
+		gcWorkers: cfg.GCWorkers,
+		sync:      cfg.Sync,
+		keys:      cfg.EncryptionKeys,
+		encoder:   cfg.Encoder,
+		decoder:   cfg.Decoder,
+		idWriter:  idWriter,
+	}
+	// Session files are encrypted as a whole, which cannot be streamed.
+	if len(cfg.EncryptionKeys) == 0 {
+		store.streamEncoder = cfg.StreamEncoder
+		store.streamDecoder = cfg.StreamDecoder
 	}
+	return store
 }
 
 // filename returns the computed file name with given sid.
@@ -54,11 +71,26 @@ func isFile(path string) bool {
 	return !f.IsDir()
 }
 
-func (s *fileStore) Exist(_ context.Context, sid string) bool {
+func (s *fileStore) Exist(ctx context.Context, sid string) bool {
+	ok, _ := s.CheckExist(ctx, sid)
+	return ok
+}
+
+var _ ExistChecker = (*fileStore)(nil)
+
+func (s *fileStore) CheckExist(_ context.Context, sid string) (bool, error) {
 	if len(sid) < minimumSIDLength {
-		return false
+		return false, nil
 	}
-	return isFile(s.filename(sid))
+
+	fi, err := os.Stat(s.filename(sid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("stat: %w", err)
+	}
+	return !fi.IsDir(), nil
 }
 
 func (s *fileStore) Read(_ context.Context, sid string) (Session, error) {
@@ -70,7 +102,7 @@ func (s *fileStore) Read(_ context.Context, sid string) (Session, error) {
 	if !isFile(filename) {
 		err := os.MkdirAll(filepath.Dir(filename), 0700)
 		if err != nil {
-			return nil, errors.Wrap(err, "create parent directory")
+			return nil, fmt.Errorf("create parent directory: %w", err)
 		}
 
 		return NewBaseSession(sid, s.encoder, s.idWriter), nil
@@ -79,29 +111,117 @@ func (s *fileStore) Read(_ context.Context, sid string) (Session, error) {
 	// Discard existing data if it's expired
 	fi, err := os.Stat(filename)
 	if err != nil {
-		return nil, errors.Wrap(err, "stat file")
+		return nil, fmt.Errorf("stat file: %w", err)
 	}
 	if !fi.ModTime().Add(s.lifetime).After(s.nowFunc()) {
 		return NewBaseSession(sid, s.encoder, s.idWriter), nil
 	}
 
+	// Treat zero-length and truncated files (e.g. left by a crash in the middle of
+	// writing) as missing sessions, and report files that are otherwise corrupted.
+	if s.streamDecoder != nil {
+		if fi.Size() == 0 {
+			return NewBaseSession(sid, s.encoder, s.idWriter), nil
+		}
+		data, err := s.readStream(filename)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return NewBaseSession(sid, s.encoder, s.idWriter), nil
+			}
+			return nil, fmt.Errorf("read file: %w", err)
+		}
+		if data == nil {
+			return NewBaseSession(sid, s.encoder, s.idWriter), nil
+		}
+		return NewBaseSessionWithData(sid, s.encoder, s.idWriter, data), nil
+	}
+
 	binary, err := os.ReadFile(filename)
 	if err != nil {
-		return nil, errors.Wrap(err, "read file")
+		return nil, fmt.Errorf("read file: %w", err)
 	}
 
+	if len(binary) == 0 {
+		return NewBaseSession(sid, s.encoder, s.idWriter), nil
+	}
+	current := true
+	if len(s.keys) > 0 {
+		binary, current, err = s.keys.Decrypt(binary, []byte(sid))
+		if err != nil {
+			// Never start over with an empty session, which would overwrite the session
+			// file that may be decryptable with the right keys.
+			return nil, fmt.Errorf("decrypt: %w", err)
+		}
+	}
 	data, err := s.decoder(binary)
 	if err != nil {
-		return nil, errors.Wrap(err, "decode")
+		if isTruncated(err) {
+			return NewBaseSession(sid, s.encoder, s.idWriter), nil
+		}
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	if !current {
+		markStale(data)
 	}
 	return NewBaseSessionWithData(sid, s.encoder, s.idWriter, data), nil
 }
 
+var _ Peeker = (*fileStore)(nil)
+
+// Peek reads the session from its file via Read, which has no side effects on
+// sessions that exist.
+func (s *fileStore) Peek(ctx context.Context, sid string) (Session, error) {
+	if len(sid) < minimumSIDLength {
+		return nil, nil
+	}
+
+	fi, err := os.Stat(s.filename(sid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("stat file: %w", err)
+	} else if fi.IsDir() || !fi.ModTime().Add(s.lifetime).After(s.nowFunc()) {
+		return nil, nil
+	}
+	return s.Read(ctx, sid)
+}
+
+// readStream decodes the session data from the named file by the stream
+// decoder. It returns nil data if the file is truncated.
+func (s *fileStore) readStream(filename string) (Data, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	data, err := s.streamDecoder(bufio.NewReader(f))
+	if err != nil {
+		if isTruncated(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return data, nil
+}
+
+// isTruncated returns true if the error of decoding session data indicates the
+// encoding ends prematurely, i.e. the session file was partially written.
+func isTruncated(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
 func (s *fileStore) Destroy(_ context.Context, sid string) error {
 	if len(sid) < minimumSIDLength {
 		return nil
 	}
-	return os.Remove(s.filename(sid))
+
+	err := os.Remove(s.filename(sid))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 func (s *fileStore) Touch(_ context.Context, sid string) error {
@@ -112,36 +232,146 @@ func (s *fileStore) Touch(_ context.Context, sid string) error {
 
 	err := os.Chtimes(filename, s.nowFunc(), s.nowFunc())
 	if err != nil {
-		return errors.Wrap(err, "change times")
+		return fmt.Errorf("change times: %w", err)
+	}
+	return nil
+}
+
+// writeFile writes the content produced by the write function to the named
+// file, which is truncated before writing.
+func writeFile(filename string, perm os.FileMode, write func(w io.Writer) error) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+	err = write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeFileSync writes the content produced by the write function to the named
+// file atomically by writing to a temporary file and renaming it, and makes
+// sure both the file and its parent directory are flushed to the stable
+// storage.
+func writeFileSync(filename string, perm os.FileMode, write func(w io.Writer) error) error {
+	dir := filepath.Dir(filename)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	bw := bufio.NewWriter(f)
+	err = write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write temporary file: %w", err)
+	}
+
+	err = os.Rename(f.Name(), filename)
+	if err != nil {
+		return fmt.Errorf("rename: %w", err)
 	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open parent directory: %w", err)
+	}
+	defer func() { _ = d.Close() }()
+
+	// Not all platforms support syncing directories (e.g. Windows), where the
+	// rename is the best effort we can do.
+	_ = d.Sync()
 	return nil
 }
 
+// encode returns the function to write the encoding of the session to a file.
+// The session data is streamed by the stream encoder if possible, otherwise it
+// is encoded (and encrypted) upfront.
+func (s *fileStore) encode(sess Session) (func(w io.Writer) error, error) {
+	if ds, ok := sess.(interface{ Data() Data }); ok && s.streamEncoder != nil {
+		data := ds.Data()
+		return func(w io.Writer) error {
+			err := s.streamEncoder(w, data)
+			if err != nil {
+				return fmt.Errorf("encode: %w", err)
+			}
+			return nil
+		}, nil
+	}
+
+	binary, err := sess.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+
+	if len(s.keys) > 0 {
+		binary, err = s.keys.Encrypt(binary, []byte(sess.ID()))
+		if err != nil {
+			return nil, fmt.Errorf("encrypt: %w", err)
+		}
+	}
+	return func(w io.Writer) error {
+		_, err := w.Write(binary)
+		return err
+	}, nil
+}
+
 func (s *fileStore) Save(_ context.Context, sess Session) error {
 	if len(sess.ID()) < minimumSIDLength {
 		return ErrMinimumSIDLength
 	}
 
-	binary, err := sess.Encode()
+	write, err := s.encode(sess)
 	if err != nil {
-		return errors.Wrap(err, "encode")
+		return err
 	}
 
+	// The parent directory may not exist when the session ID has been regenerated
+	// since the session was read.
 	filename := s.filename(sess.ID())
-	err = os.WriteFile(filename, binary, 0600)
+	err = os.MkdirAll(filepath.Dir(filename), 0700)
 	if err != nil {
-		return errors.Wrap(err, "write file")
+		return fmt.Errorf("create parent directory: %w", err)
+	}
+
+	if s.sync {
+		err = writeFileSync(filename, 0600, write)
+	} else {
+		err = writeFile(filename, 0600, write)
+	}
+	if err != nil {
+		return fmt.Errorf("write file: %w", err)
 	}
 
 	err = os.Chtimes(filename, s.nowFunc(), s.nowFunc())
 	if err != nil {
-		return errors.Wrap(err, "change times")
+		return fmt.Errorf("change times: %w", err)
 	}
 	return nil
 }
 
-func (s *fileStore) GC(ctx context.Context) error {
-	err := filepath.WalkDir(s.rootDir, func(path string, d fs.DirEntry, err error) error {
+// gcDir removes expired session files under given directory recursively.
+func (s *fileStore) gcDir(ctx context.Context, dir string) error {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
@@ -170,14 +400,152 @@ func (s *fileStore) GC(ctx context.Context) error {
 	return nil
 }
 
+func (s *fileStore) GC(ctx context.Context) error {
+	if s.gcWorkers <= 1 {
+		return s.gcDir(ctx, s.rootDir)
+	}
+
+	// Each top-level directory is a partition to be walked by one of the workers,
+	// and session files that are directly under the root directory are handled by
+	// a partition of their own.
+	entries, err := os.ReadDir(s.rootDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read root directory: %w", err)
+	}
+
+	partitions := make(chan string)
+	errs := make(chan error, s.gcWorkers)
+	var wg sync.WaitGroup
+	for i := 0; i < s.gcWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := range partitions {
+				err := s.gcDir(ctx, dir)
+				if err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	var hasFiles bool
+loop:
+	for _, e := range entries {
+		if !e.IsDir() {
+			hasFiles = true
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			break loop
+		case partitions <- filepath.Join(s.rootDir, e.Name()):
+		}
+	}
+	close(partitions)
+	wg.Wait()
+	close(errs)
+
+	// Only the first error is returned, the rest are likely to be the same.
+	if err = <-errs; err != nil {
+		return err
+	}
+
+	if hasFiles {
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			err = s.gcFile(filepath.Join(s.rootDir, e.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// gcFile removes the session file if it is expired.
+func (s *fileStore) gcFile(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.ModTime().Add(s.lifetime).After(s.nowFunc()) {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+var _ EncoderReporter = (*fileStore)(nil)
+
+func (s *fileStore) Encoder() Encoder {
+	return s.encoder
+}
+
+var _ Expirer = (*fileStore)(nil)
+
+func (s *fileStore) ExpiresAt(_ context.Context, sid string) (time.Time, error) {
+	if len(sid) < minimumSIDLength {
+		return time.Time{}, nil
+	}
+
+	fi, err := os.Stat(s.filename(sid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("stat file: %w", err)
+	}
+	return fi.ModTime().Add(s.lifetime), nil
+}
+
+var _ Lister = (*fileStore)(nil)
+
+func (s *fileStore) List(ctx context.Context) ([]string, error) {
+	var sids []string
+	err := filepath.WalkDir(s.rootDir, func(path string, d fs.DirEntry, err error) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		sids = append(sids, d.Name())
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return sids, nil
+}
+
 // FileConfig contains options for the file session store.
 type FileConfig struct {
-	// For tests only.
-	nowFunc func() time.Time
-
 	// Lifetime is the duration to have no access to a session before being
 	// recycled. Default is 3600 seconds.
 	Lifetime time.Duration
+	// NowFunc is the function to return the current time for expiry of sessions,
+	// e.g. to simulate the passage of time in integration tests. Default is
+	// time.Now.
+	NowFunc func() time.Time
 	// RootDir is the root directory of file session items stored on the local file
 	// system. Default is "sessions".
 	RootDir string
@@ -185,6 +553,38 @@ type FileConfig struct {
 	Encoder Encoder
 	// Decoder is the decoder to decode session data. Default is GobDecoder.
 	Decoder Decoder
+	// GCWorkers is the number of concurrent workers for walking the directory tree
+	// during GC, where each top-level directory under the RootDir is walked by one
+	// worker. Default is 1.
+	GCWorkers int
+	// Sync indicates whether to write session files atomically and flush them and
+	// their parent directories to the stable storage on save, which makes session
+	// data survive crashes at the cost of write performance.
+	Sync bool
+	// EncryptionKey is the key to encrypt session files using AES-GCM, which must
+	// be 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256
+	// respectively. Session files that cannot be decrypted are treated as missing.
+	// Default is not set, i.e. session files are not encrypted.
+	//
+	// Deprecated: Use EncryptionKeys instead, which supports rotating keys.
+	EncryptionKey []byte
+	// EncryptionKeys is the key ring to encrypt session files using AES-GCM, each
+	// key must be 16, 24 or 32 bytes long. Session files encrypted with keys other
+	// than the current key are re-encrypted with the current key when they are
+	// read and saved, and are bound to their session IDs which cannot be swapped
+	// between files. It takes precedence over the EncryptionKey. Default is not
+	// set, i.e. session files are not encrypted.
+	EncryptionKeys KeyRing
+	// StreamEncoder is the encoder to stream session data to session files
+	// without materializing the whole encoding in memory, which must produce the
+	// same encoding as the Encoder. It is not used when session files are
+	// encrypted. Default is not set, i.e. the Encoder is used.
+	StreamEncoder StreamEncoder
+	// StreamDecoder is the decoder to stream session data from session files
+	// without reading whole files in memory, which must be able to decode the
+	// encoding of the Encoder. It is not used when session files are encrypted.
+	// Default is not set, i.e. the Decoder is used.
+	StreamDecoder StreamDecoder
 }
 
 // FileIniter returns the Initer for the file session store.
@@ -207,8 +607,8 @@ func FileIniter() Initer {
 		if cfg == nil {
 			return nil, fmt.Errorf("config object with the type '%T' not found", FileConfig{})
 		}
-		if cfg.nowFunc == nil {
-			cfg.nowFunc = time.Now
+		if cfg.NowFunc == nil {
+			cfg.NowFunc = time.Now
 		}
 		if cfg.Lifetime.Seconds() < 1 {
 			cfg.Lifetime = 3600 * time.Second
@@ -222,6 +622,18 @@ func FileIniter() Initer {
 		if cfg.Decoder == nil {
 			cfg.Decoder = GobDecoder
 		}
+		if cfg.GCWorkers < 1 {
+			cfg.GCWorkers = 1
+		}
+		if len(cfg.EncryptionKeys) == 0 && cfg.EncryptionKey != nil {
+			cfg.EncryptionKeys = KeyRing{cfg.EncryptionKey}
+		}
+		if len(cfg.EncryptionKeys) > 0 {
+			err := cfg.EncryptionKeys.validateAES()
+			if err != nil {
+				return nil, fmt.Errorf("encryption keys: %w", err)
+			}
+		}
 
 		return newFileStore(*cfg, idWriter), nil
 	}
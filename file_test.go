@@ -7,6 +7,8 @@ package session
 import (
 	"bytes"
 	"context"
+	"encoding/gob"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -26,7 +28,7 @@ func TestFileStore(t *testing.T) {
 		Options{
 			Initer: FileIniter(),
 			Config: FileConfig{
-				nowFunc: time.Now,
+				NowFunc: time.Now,
 				RootDir: filepath.Join(os.TempDir(), "sessions"),
 			},
 		},
@@ -87,7 +89,7 @@ func TestFileStore_GC(t *testing.T) {
 	now := time.Now()
 	store, err := FileIniter()(ctx,
 		FileConfig{
-			nowFunc:  func() time.Time { return now },
+			NowFunc:  func() time.Time { return now },
 			RootDir:  filepath.Join(os.TempDir(), "sessions"),
 			Lifetime: time.Second,
 		},
@@ -134,7 +136,7 @@ func TestFileStore_Touch(t *testing.T) {
 	now := time.Now()
 	store, err := FileIniter()(ctx,
 		FileConfig{
-			nowFunc:  func() time.Time { return now },
+			NowFunc:  func() time.Time { return now },
 			RootDir:  filepath.Join(os.TempDir(), "sessions"),
 			Lifetime: time.Second,
 		},
@@ -156,3 +158,254 @@ func TestFileStore_Touch(t *testing.T) {
 	require.Nil(t, err)
 	assert.True(t, store.Exist(ctx, sess.ID()))
 }
+
+func TestFileStore_GCWorkers(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store, err := FileIniter()(ctx,
+		FileConfig{
+			NowFunc:   func() time.Time { return now },
+			RootDir:   t.TempDir(),
+			Lifetime:  time.Second,
+			GCWorkers: 4,
+		},
+		IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	expired := []string{"111", "222", "333", "444", "555"}
+	now = now.Add(-2 * time.Second)
+	for _, sid := range expired {
+		sess, err := store.Read(ctx, sid)
+		require.Nil(t, err)
+		err = store.Save(ctx, sess)
+		require.Nil(t, err)
+	}
+
+	now = now.Add(2 * time.Second)
+	alive := []string{"666", "777", "888"}
+	for _, sid := range alive {
+		sess, err := store.Read(ctx, sid)
+		require.Nil(t, err)
+		err = store.Save(ctx, sess)
+		require.Nil(t, err)
+	}
+
+	err = store.GC(ctx)
+	require.Nil(t, err)
+
+	for _, sid := range expired {
+		assert.False(t, store.Exist(ctx, sid), sid)
+	}
+	for _, sid := range alive {
+		assert.True(t, store.Exist(ctx, sid), sid)
+	}
+}
+
+func TestFileStore_Sync(t *testing.T) {
+	ctx := context.Background()
+	rootDir := t.TempDir()
+	store, err := FileIniter()(ctx,
+		FileConfig{
+			RootDir: rootDir,
+			Sync:    true,
+		},
+		IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	sess, err := store.Read(ctx, "111")
+	require.Nil(t, err)
+	sess.Set("name", "flamego")
+	err = store.Save(ctx, sess)
+	require.Nil(t, err)
+
+	sess, err = store.Read(ctx, "111")
+	require.Nil(t, err)
+	assert.Equal(t, "flamego", sess.Get("name"))
+
+	// No temporary files should be left behind
+	entries, err := os.ReadDir(filepath.Join(rootDir, "1", "1"))
+	require.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestFileStore_Stream(t *testing.T) {
+	ctx := context.Background()
+	rootDir := t.TempDir()
+	var encoded, decoded int
+	store, err := FileIniter()(ctx,
+		FileConfig{
+			RootDir: rootDir,
+			StreamEncoder: func(w io.Writer, data Data) error {
+				encoded++
+				return GobStreamEncoder(w, data)
+			},
+			StreamDecoder: func(r io.Reader) (Data, error) {
+				decoded++
+				return GobStreamDecoder(r)
+			},
+		},
+		IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	sess, err := store.Read(ctx, "111")
+	require.Nil(t, err)
+	sess.Set("name", "flamego")
+	err = store.Save(ctx, sess)
+	require.Nil(t, err)
+	assert.Equal(t, 1, encoded)
+
+	sess, err = store.Read(ctx, "111")
+	require.Nil(t, err)
+	assert.Equal(t, "flamego", sess.Get("name"))
+	assert.Equal(t, 1, decoded)
+
+	// The stream encoding is compatible with the byte-based encoding
+	binary, err := os.ReadFile(filepath.Join(rootDir, "1", "1", "111"))
+	require.Nil(t, err)
+	data, err := GobDecoder(binary)
+	require.Nil(t, err)
+	assert.Equal(t, Data{"name": "flamego"}, data)
+
+	// Truncated files are treated as missing sessions
+	err = os.WriteFile(filepath.Join(rootDir, "1", "1", "111"), binary[:len(binary)/2], 0600)
+	require.Nil(t, err)
+	sess, err = store.Read(ctx, "111")
+	require.Nil(t, err)
+	assert.Nil(t, sess.Get("name"))
+}
+
+func TestFileStore_Corrupted(t *testing.T) {
+	ctx := context.Background()
+	rootDir := t.TempDir()
+	store, err := FileIniter()(ctx,
+		FileConfig{
+			RootDir: rootDir,
+		},
+		IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	binary, err := GobEncoder(Data{"name": "flamego"})
+	require.Nil(t, err)
+	var corrupted bytes.Buffer
+	err = gob.NewEncoder(&corrupted).Encode("not session data")
+	require.Nil(t, err)
+
+	// Empty and truncated files are left by partial writes
+	for sid, content := range map[string][]byte{
+		"111": nil,
+		"222": binary[:len(binary)/2],
+	} {
+		_, err = store.Read(ctx, sid)
+		require.Nil(t, err)
+		err = os.WriteFile(filepath.Join(rootDir, sid[:1], sid[1:2], sid), content, 0600)
+		require.Nil(t, err)
+
+		sess, err := store.Read(ctx, sid)
+		require.Nil(t, err)
+		assert.Empty(t, sess.(*BaseSession).Data())
+	}
+
+	_, err = store.Read(ctx, "333")
+	require.Nil(t, err)
+	err = os.WriteFile(filepath.Join(rootDir, "3", "3", "333"), corrupted.Bytes(), 0600)
+	require.Nil(t, err)
+	_, err = store.Read(ctx, "333")
+	assert.ErrorContains(t, err, "decode")
+}
+
+func TestFileStore_EncryptionKey(t *testing.T) {
+	ctx := context.Background()
+	rootDir := t.TempDir()
+	newStore := func(key []byte) Store {
+		store, err := FileIniter()(ctx,
+			FileConfig{
+				RootDir:       rootDir,
+				EncryptionKey: key,
+			},
+			IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+		)
+		require.Nil(t, err)
+		return store
+	}
+
+	key := bytes.Repeat([]byte("k"), 32)
+	store := newStore(key)
+	sess, err := store.Read(ctx, "111")
+	require.Nil(t, err)
+	sess.Set("name", "flamego")
+	err = store.Save(ctx, sess)
+	require.Nil(t, err)
+
+	binary, err := os.ReadFile(filepath.Join(rootDir, "1", "1", "111"))
+	require.Nil(t, err)
+	assert.NotContains(t, string(binary), "flamego")
+
+	sess, err = store.Read(ctx, "111")
+	require.Nil(t, err)
+	assert.Equal(t, "flamego", sess.Get("name"))
+
+	// Session files cannot be decrypted with a different key, and are left intact
+	_, err = newStore(bytes.Repeat([]byte("x"), 32)).Read(ctx, "111")
+	assert.ErrorContains(t, err, "decrypt")
+	sess, err = store.Read(ctx, "111")
+	require.Nil(t, err)
+	assert.Equal(t, "flamego", sess.Get("name"))
+
+	// Session files are bound to their session IDs
+	err = os.Rename(filepath.Join(rootDir, "1", "1", "111"), filepath.Join(rootDir, "1", "1", "112"))
+	require.Nil(t, err)
+	_, err = store.Read(ctx, "112")
+	assert.ErrorContains(t, err, "decrypt")
+
+	_, err = FileIniter()(ctx,
+		FileConfig{
+			RootDir:       rootDir,
+			EncryptionKey: []byte("short"),
+		},
+		IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	assert.NotNil(t, err)
+}
+
+func TestFileStore_EncryptionKeys(t *testing.T) {
+	ctx := context.Background()
+	rootDir := t.TempDir()
+	newStore := func(keys ...[]byte) Store {
+		store, err := FileIniter()(ctx,
+			FileConfig{
+				RootDir:        rootDir,
+				EncryptionKeys: keys,
+			},
+			IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+		)
+		require.Nil(t, err)
+		return store
+	}
+
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	newKey := bytes.Repeat([]byte("n"), 16)
+	store := newStore(oldKey)
+	sess, err := store.Read(ctx, "111")
+	require.Nil(t, err)
+	sess.Set("name", "flamego")
+	err = store.Save(ctx, sess)
+	require.Nil(t, err)
+
+	// Sessions encrypted with the old key are readable and upgraded once saved
+	store = newStore(newKey, oldKey)
+	sess, err = store.Read(ctx, "111")
+	require.Nil(t, err)
+	assert.Equal(t, "flamego", sess.Get("name"))
+	assert.True(t, sess.HasChanged(), "session to be upgraded")
+	err = store.Save(ctx, sess)
+	require.Nil(t, err)
+
+	sess, err = newStore(newKey).Read(ctx, "111")
+	require.Nil(t, err)
+	assert.Equal(t, "flamego", sess.Get("name"))
+	assert.False(t, sess.HasChanged())
+}
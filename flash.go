@@ -0,0 +1,126 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// FlashStore is a storage of flashes separate from the session data (see
+// Options.FlashStore), so that consuming a flash never requires saving the
+// session.
+type FlashStore interface {
+	// Write stores the flash to be read by the next request of the client.
+	Write(w http.ResponseWriter, r *http.Request, val interface{}) error
+	// Read returns and removes the flash of the client, or nil if there is no
+	// flash.
+	Read(w http.ResponseWriter, r *http.Request) (interface{}, error)
+}
+
+var _ FlashStore = (*CookieFlashStore)(nil)
+
+// CookieFlashStore is a flash store that keeps the flash in a separate cookie
+// signed with SigningKeys, which is removed once read. Values of flashes must be
+// registered to Gob if they are not of builtin types.
+type CookieFlashStore struct {
+	// Name is the name of the flash cookie. Default is "flamego_flash".
+	Name string
+	// Path is the path of the flash cookie. Default is "/".
+	Path string
+	// Secure indicates whether the flash cookie is only sent over HTTPS.
+	Secure bool
+	// SigningKeys is the key ring to sign the flash cookie with HMAC-SHA256,
+	// which keeps clients from forging flashes. It is required.
+	SigningKeys KeyRing
+}
+
+// validate returns an error if the flash store cannot sign flashes.
+func (s *CookieFlashStore) validate() error {
+	if len(s.SigningKeys) == 0 {
+		return errors.New("no signing keys")
+	}
+	return nil
+}
+
+// cookie returns the flash cookie with given value.
+func (s *CookieFlashStore) cookie(value string) *http.Cookie {
+	name := s.Name
+	if name == "" {
+		name = "flamego_flash"
+	}
+	path := s.Path
+	if path == "" {
+		path = "/"
+	}
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Secure:   s.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Write implements `FlashStore.Write`.
+func (s *CookieFlashStore) Write(w http.ResponseWriter, _ *http.Request, val interface{}) error {
+	if headerWritten(w) {
+		return ErrHeaderWritten
+	}
+	err := s.validate()
+	if err != nil {
+		return err
+	}
+
+	binary, err := GobEncoder(Data{flashKey: val})
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	value := base64.RawURLEncoding.EncodeToString(binary)
+	value += "." + base64.RawURLEncoding.EncodeToString(s.SigningKeys.Sign([]byte(value)))
+	http.SetCookie(w, s.cookie(value))
+	return nil
+}
+
+// Read implements `FlashStore.Read`. Flashes with invalid signatures are
+// removed and reported as errors.
+func (s *CookieFlashStore) Read(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	err := s.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	cookie, err := r.Cookie(s.cookie("").Name)
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+
+	expired := s.cookie("")
+	expired.MaxAge = -1
+	http.SetCookie(w, expired)
+
+	value, signature, _ := strings.Cut(cookie.Value, ".")
+	b, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, fmt.Errorf("decode signature: %w", err)
+	}
+	if ok, _ := s.SigningKeys.Verify([]byte(value), b); !ok {
+		return nil, errors.New("invalid signature")
+	}
+
+	binary, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("decode base64: %w", err)
+	}
+	data, err := GobDecoder(binary)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return data[flashKey], nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestSessioner_FlashStore(t *testing.T) {
+	var store *writeCountingStore
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Initer: func(ctx context.Context, args ...interface{}) (Store, error) {
+				file, err := FileIniter()(ctx, args...)
+				if err != nil {
+					return nil, err
+				}
+				store = &writeCountingStore{Store: file}
+				return store, nil
+			},
+			Config: FileConfig{
+				RootDir: t.TempDir(),
+			},
+			GCMode: GCDisabled,
+			FlashStore: &CookieFlashStore{
+				SigningKeys: KeyRing{[]byte("0123456789abcdef")},
+			},
+			ErrorFunc: func(err error) { t.Fatalf("Unexpected error: %v", err) },
+		},
+	))
+	f.Get("/", func(s Session) {
+		s.Set("name", "flamego")
+	})
+	f.Get("/set", func(s Session) {
+		s.SetFlash("Welcome back!")
+	})
+	f.Get("/get", func(flash Flash) string {
+		return fmt.Sprintf("%v", flash)
+	})
+
+	cookies := make(map[string]string)
+	request := func(path string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		for name, value := range cookies {
+			req.AddCookie(&http.Cookie{Name: name, Value: value})
+		}
+		f.ServeHTTP(resp, req)
+		for _, c := range resp.Result().Cookies() {
+			if c.MaxAge < 0 {
+				delete(cookies, c.Name)
+			} else {
+				cookies[c.Name] = c.Value
+			}
+		}
+		return resp
+	}
+
+	request("/")
+	saves := store.saves
+
+	request("/set")
+	assert.Contains(t, cookies, "flamego_flash")
+
+	// Consuming the flash does not save the session
+	assert.Equal(t, "Welcome back!", request("/get").Body.String())
+	assert.NotContains(t, cookies, "flamego_flash")
+	assert.Equal(t, saves, store.saves)
+	assert.NotContains(t, request("/get").Body.String(), "Welcome back!")
+}
+
+func TestSessioner_FlashStoreConcurrentRequests(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			FlashStore: &CookieFlashStore{
+				SigningKeys: KeyRing{[]byte("0123456789abcdef")},
+			},
+			ErrorFunc: func(err error) { t.Fatalf("Unexpected error: %v", err) },
+		},
+	))
+	f.Get("/", func(s Session) {})
+	started, proceed := make(chan struct{}), make(chan struct{})
+	f.Get("/slow", func(s Session) {
+		close(started)
+		<-proceed
+		s.SetFlash("Welcome back!")
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	cookie := resp.Result().Cookies()[0]
+
+	request := func(path string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		req.AddCookie(cookie)
+		f.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// The end of another request of the same session does not affect the flash
+	// writer of the slow request.
+	slow := make(chan *httptest.ResponseRecorder)
+	go func() { slow <- request("/slow") }()
+	<-started
+	assert.Empty(t, request("/").Result().Cookies())
+	close(proceed)
+
+	var names []string
+	for _, c := range (<-slow).Result().Cookies() {
+		names = append(names, c.Name)
+	}
+	assert.Contains(t, names, "flamego_flash")
+}
+
+func TestCookieFlashStore_Forged(t *testing.T) {
+	flashes := &CookieFlashStore{SigningKeys: KeyRing{[]byte("0123456789abcdef")}}
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	require.NoError(t, flashes.Write(resp, req, "Welcome back!"))
+
+	value := resp.Result().Cookies()[0].Value
+	payload, signature, _ := strings.Cut(value, ".")
+	forged := payload[:len(payload)-1] + "A" + "." + signature
+	req.AddCookie(&http.Cookie{Name: "flamego_flash", Value: forged})
+	_, err = flashes.Read(httptest.NewRecorder(), req)
+	assert.EqualError(t, err, "invalid signature")
+}
+
+func TestCookieFlashStore_NoSigningKeys(t *testing.T) {
+	assert.PanicsWithValue(t, "session: flash store: no signing keys", func() {
+		Sessioner(Options{FlashStore: &CookieFlashStore{}})
+	})
+
+	flashes := &CookieFlashStore{}
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	assert.EqualError(t, flashes.Write(httptest.NewRecorder(), req, "Welcome back!"), "no signing keys")
+
+	req.AddCookie(&http.Cookie{Name: "flamego_flash", Value: "forged"})
+	_, err = flashes.Read(httptest.NewRecorder(), req)
+	assert.EqualError(t, err, "no signing keys")
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// GCMode is the mode of performing GC operations on the session store.
+type GCMode int
+
+const (
+	// GCBackground performs GC operations in a background goroutine of every
+	// application instance in the time interval of Options.GCInterval, unless the
+	// session store does not need GC operations (see session.GCNeeder).
+	GCBackground GCMode = iota
+	// GCDisabled never performs GC operations, which is meant for session stores
+	// that expire sessions on their own, e.g. via TTLs of Redis keys.
+	GCDisabled
+	// GCExternal does not perform GC operations in the application, and relies on
+	// an external job runner (e.g. a cron job) to call session.RunGC.
+	GCExternal
+)
+
+// String returns the name of the GC mode.
+func (m GCMode) String() string {
+	switch m {
+	case GCBackground:
+		return "background"
+	case GCDisabled:
+		return "disabled"
+	case GCExternal:
+		return "external"
+	}
+	return "unknown"
+}
+
+// GCLease is a lease shared by application instances to coordinate background
+// GC operations, so that only one instance performs GC in each time interval.
+type GCLease interface {
+	// Acquire tries to acquire the lease for given duration. It returns false
+	// without an error when the lease is held by another instance.
+	Acquire(ctx context.Context, ttl time.Duration) (bool, error)
+}
+
+// OnExpireFunc is the function to be called with the data of each expired
+// session that is recycled by GC operations.
+type OnExpireFunc func(ctx context.Context, sid string, data Data)
+
+// ExpiryArchiver is a session store that is capable of reading the data of
+// expired sessions when recycling them in GC operations, e.g. to archive
+// analytics summaries of abandoned sessions.
+type ExpiryArchiver interface {
+	// GCWithArchive performs a GC operation as Store.GC, but recycles expired
+	// sessions in batches of at most given size, and calls the onExpire with the
+	// data of each expired session in the batch when recycling the batch. A nil
+	// onExpire makes it equivalent to Store.GC.
+	GCWithArchive(ctx context.Context, batchSize int, onExpire OnExpireFunc) error
+}
+
+// GCNeeder is a session store that tells whether it needs GC operations at
+// all, e.g. session stores that expire sessions on their own via TTLs of Redis
+// keys do not. Background GC operations are skipped entirely for session
+// stores that do not need them.
+type GCNeeder interface {
+	// NeedsGC returns false if GC operations are no-ops for the session store.
+	NeedsGC() bool
+}
+
+// GCScheduler is a session store that prefers its own time interval of
+// background GC operations, which is used when Options.GCInterval is not set.
+type GCScheduler interface {
+	// GCInterval returns the preferred time interval of background GC
+	// operations, or 0 to use the default.
+	GCInterval() time.Duration
+}
+
+// needsGC returns false if the session store tells it does not need GC
+// operations.
+func needsGC(store Store) bool {
+	n, ok := StoreAs[GCNeeder](store)
+	return !ok || n.NeedsGC()
+}
+
+// gcInterval returns the time interval of background GC operations on the
+// session store, which is the given interval if set, or the preferred interval
+// of the session store, or 5 minutes otherwise.
+func gcInterval(store Store, interval time.Duration) time.Duration {
+	if interval >= time.Second {
+		return interval
+	}
+	if s, ok := StoreAs[GCScheduler](store); ok && s.GCInterval() >= time.Second {
+		return s.GCInterval()
+	}
+	return 5 * time.Minute
+}
+
+// RunGC performs a GC operation on the session store, which is meant to be
+// called by external job runners when Options.GCMode is session.GCExternal.
+func RunGC(ctx context.Context, store Store) error {
+	err := store.GC(ctx)
+	if err != nil {
+		return fmt.Errorf("GC: %w", err)
+	}
+	return nil
+}
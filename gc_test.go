@@ -0,0 +1,155 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type gcCountingStore struct {
+	noopStore
+	gcs atomic.Int32
+}
+
+func (s *gcCountingStore) GC(context.Context) error {
+	s.gcs.Add(1)
+	return nil
+}
+
+func TestSessioner_GCMode(t *testing.T) {
+	newStore := func(mode GCMode) *gcCountingStore {
+		store := &gcCountingStore{}
+		Sessioner(Options{
+			Initer: func(context.Context, ...interface{}) (Store, error) {
+				return store, nil
+			},
+			GCMode: mode,
+		})
+		return store
+	}
+
+	t.Run("background", func(t *testing.T) {
+		store := newStore(GCBackground)
+		assert.Eventually(t, func() bool { return store.gcs.Load() > 0 }, time.Second, 10*time.Millisecond)
+	})
+
+	for _, mode := range []GCMode{GCDisabled, GCExternal} {
+		t.Run(mode.String(), func(t *testing.T) {
+			store := newStore(mode)
+			time.Sleep(50 * time.Millisecond)
+			assert.Zero(t, store.gcs.Load())
+
+			assert.NoError(t, RunGC(context.Background(), store))
+			assert.EqualValues(t, 1, store.gcs.Load())
+		})
+	}
+
+	assert.Panics(t, func() { newStore(GCMode(-1)) })
+}
+
+// ttlStore is a session store that expires sessions on its own.
+type ttlStore struct {
+	gcCountingStore
+}
+
+func (*ttlStore) NeedsGC() bool {
+	return false
+}
+
+// scheduledStore is a session store that prefers its own GC interval.
+type scheduledStore struct {
+	noopStore
+	interval time.Duration
+}
+
+func (s *scheduledStore) GCInterval() time.Duration {
+	return s.interval
+}
+
+func TestSessioner_NeedsGC(t *testing.T) {
+	store := &ttlStore{}
+	Sessioner(Options{
+		Initer: func(context.Context, ...interface{}) (Store, error) {
+			return &namedStore{Store: store}, nil
+		},
+	})
+	time.Sleep(50 * time.Millisecond)
+	assert.Zero(t, store.gcs.Load())
+
+	assert.True(t, needsGC(&gcCountingStore{}))
+	assert.False(t, needsGC(&namedStore{Store: store}))
+}
+
+func TestGCInterval(t *testing.T) {
+	assert.Equal(t, 5*time.Minute, gcInterval(&noopStore{}, 0))
+	assert.Equal(t, time.Minute, gcInterval(&noopStore{}, time.Minute))
+
+	store := &namedStore{Store: &scheduledStore{interval: time.Hour}}
+	assert.Equal(t, time.Hour, gcInterval(store, 0))
+	assert.Equal(t, time.Minute, gcInterval(store, time.Minute), "options take precedence")
+
+	// Intervals shorter than a second are ignored
+	assert.Equal(t, 5*time.Minute, gcInterval(&scheduledStore{interval: time.Millisecond}, 0))
+}
+
+type staticGCLease struct {
+	acquired bool
+	ttl      time.Duration
+}
+
+func (l *staticGCLease) Acquire(_ context.Context, ttl time.Duration) (bool, error) {
+	l.ttl = ttl
+	return l.acquired, nil
+}
+
+func TestManager_GCLease(t *testing.T) {
+	for _, acquired := range []bool{true, false} {
+		store := &gcCountingStore{}
+		lease := &staticGCLease{acquired: acquired}
+		m := newManager(store, Options{GCLease: lease})
+		stop := m.startGC(context.Background(), time.Minute, func(error) { panic("unreachable") })
+		stop <- struct{}{}
+
+		assert.Equal(t, time.Minute, lease.ttl)
+		if acquired {
+			assert.EqualValues(t, 1, store.gcs.Load())
+		} else {
+			assert.Zero(t, store.gcs.Load(), "GC without the lease")
+		}
+	}
+}
+
+func TestManager_OnExpire(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := newMemoryStore(
+		MemoryConfig{
+			NowFunc:  func() time.Time { return now },
+			Lifetime: time.Second,
+		},
+		nil,
+	)
+	sess, err := store.Read(ctx, "1")
+	require.NoError(t, err)
+	sess.Set("cart", "apple")
+	now = now.Add(2 * time.Second)
+
+	var archived []string
+	m := newManager(store, Options{
+		OnExpire: func(_ context.Context, sid string, data Data) {
+			archived = append(archived, sid+":"+data["cart"].(string))
+		},
+		OnExpireBatchSize: 10,
+	})
+	require.NoError(t, m.gc(ctx))
+	assert.Equal(t, []string{"1:apple"}, archived)
+	assert.False(t, store.Exist(ctx, "1"))
+}
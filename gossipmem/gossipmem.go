@@ -0,0 +1,492 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package gossipmem provides an in-memory session store that replicates
+// sessions between peers over gossip (memberlist), for small clusters (e.g. 2
+// to 3 nodes) without a shared session store, so that losing one node does not
+// lose the sessions it served.
+package gossipmem
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+
+	"github.com/flamego/session"
+)
+
+// entry is a replicated session.
+type entry struct {
+	binary     []byte    // The encoded session data, nil for destroyed sessions
+	accessedAt time.Time // The last time of the session being saved, touched or destroyed
+	node       string    // The name of the node that made the last change
+}
+
+// message is a change of a session to be replicated to peers.
+type message struct {
+	SID        string
+	Data       []byte // The encoded session data, nil for destroying or touching
+	Touch      bool   // Whether only the access time is changed
+	AccessedAt int64  // The time of the change in Unix nanoseconds
+	Node       string // The name of the node that made the change
+}
+
+// newer returns true if the message should take precedence over the entry,
+// i.e. the change was made later, ties are broken by the order of node names
+// for all peers to agree.
+func (m *message) newer(e *entry) bool {
+	at := time.Unix(0, m.AccessedAt)
+	if at.Equal(e.accessedAt) {
+		return m.Node > e.node
+	}
+	return at.After(e.accessedAt)
+}
+
+var _ session.Store = (*gossipStore)(nil)
+
+// gossipStore is an in-memory session store that replicates changes to peers.
+type gossipStore struct {
+	nowFunc  func() time.Time // The function to return the current time
+	lifetime time.Duration    // The duration to have no access to a session before being recycled
+
+	lock    sync.RWMutex      // The mutex to guard accesses to the entries
+	entries map[string]*entry // The sessions indexed by session IDs
+
+	members   *memberlist.Memberlist           // The membership of peers
+	queue     *memberlist.TransmitLimitedQueue // The queue of changes to be gossiped
+	errorFunc func(err error)
+
+	encoder  session.Encoder
+	decoder  session.Decoder
+	idWriter session.IDWriter
+}
+
+// newGossipStore returns a new gossiping memory session store based on given
+// configuration. The membership is not created.
+func newGossipStore(cfg Config, idWriter session.IDWriter) *gossipStore {
+	return &gossipStore{
+		nowFunc:   cfg.NowFunc,
+		lifetime:  cfg.Lifetime,
+		entries:   make(map[string]*entry),
+		errorFunc: cfg.ErrorFunc,
+		encoder:   cfg.Encoder,
+		decoder:   cfg.Decoder,
+		idWriter:  idWriter,
+	}
+}
+
+// alive returns the entry of the session if it exists and has not expired.
+func (s *gossipStore) alive(sid string) (*entry, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	e, ok := s.entries[sid]
+	if !ok || e.binary == nil || !s.nowFunc().Before(e.accessedAt.Add(s.lifetime)) {
+		return nil, false
+	}
+	return e, true
+}
+
+func (s *gossipStore) Exist(_ context.Context, sid string) bool {
+	_, ok := s.alive(sid)
+	return ok
+}
+
+func (s *gossipStore) Read(_ context.Context, sid string) (session.Session, error) {
+	e, ok := s.alive(sid)
+	if !ok {
+		return session.NewBaseSession(sid, s.encoder, s.idWriter), nil
+	}
+
+	data, err := s.decoder(e.binary)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return session.NewBaseSessionWithData(sid, s.encoder, s.idWriter, data), nil
+}
+
+// name returns the name of the local node.
+func (s *gossipStore) name() string {
+	if s.members == nil {
+		return ""
+	}
+	return s.members.LocalNode().Name
+}
+
+// change applies the change made locally and replicates it to peers.
+func (s *gossipStore) change(m *message) {
+	m.AccessedAt = s.nowFunc().UnixNano()
+	m.Node = s.name()
+	s.apply(m)
+	s.replicate(m)
+}
+
+// apply applies the change to the session if it is newer than the existing one.
+// It returns true if the change is applied.
+func (s *gossipStore) apply(m *message) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, ok := s.entries[m.SID]
+	if ok && !m.newer(e) {
+		return false
+	}
+
+	if m.Touch {
+		if !ok || e.binary == nil {
+			return false // The session will be brought by the next state sync
+		}
+		e.accessedAt = time.Unix(0, m.AccessedAt)
+		e.node = m.Node
+		return true
+	}
+
+	s.entries[m.SID] = &entry{
+		binary:     m.Data,
+		accessedAt: time.Unix(0, m.AccessedAt),
+		node:       m.Node,
+	}
+	return true
+}
+
+func (s *gossipStore) Destroy(_ context.Context, sid string) error {
+	// Destroyed sessions are kept as tombstones until they expire, which keeps
+	// stale changes from peers from resurrecting them.
+	s.change(&message{SID: sid})
+	return nil
+}
+
+func (s *gossipStore) Touch(_ context.Context, sid string) error {
+	if _, ok := s.alive(sid); !ok {
+		return nil
+	}
+	s.change(&message{SID: sid, Touch: true})
+	return nil
+}
+
+func (s *gossipStore) Save(_ context.Context, sess session.Session) error {
+	binary, err := sess.Encode()
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	s.change(&message{SID: sess.ID(), Data: binary})
+	return nil
+}
+
+// GC removes expired sessions and tombstones. Each peer runs GC on its own, as
+// expiry is determined by the replicated access times.
+func (s *gossipStore) GC(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.nowFunc()
+	for sid, e := range s.entries {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if !now.Before(e.accessedAt.Add(s.lifetime)) {
+			delete(s.entries, sid)
+		}
+	}
+	return nil
+}
+
+var _ session.EncoderReporter = (*gossipStore)(nil)
+
+func (s *gossipStore) Encoder() session.Encoder {
+	return s.encoder
+}
+
+var _ session.Expirer = (*gossipStore)(nil)
+
+func (s *gossipStore) ExpiresAt(_ context.Context, sid string) (time.Time, error) {
+	e, ok := s.alive(sid)
+	if !ok {
+		return time.Time{}, nil
+	}
+	return e.accessedAt.Add(s.lifetime), nil
+}
+
+var _ session.Lister = (*gossipStore)(nil)
+
+func (s *gossipStore) List(context.Context) ([]string, error) {
+	s.lock.RLock()
+	sids := make([]string, 0, len(s.entries))
+	for sid := range s.entries {
+		sids = append(sids, sid)
+	}
+	s.lock.RUnlock()
+
+	alive := sids[:0]
+	for _, sid := range sids {
+		if _, ok := s.alive(sid); ok {
+			alive = append(alive, sid)
+		}
+	}
+	sort.Strings(alive)
+	return alive, nil
+}
+
+var _ session.CapabilityReporter = (*gossipStore)(nil)
+
+func (s *gossipStore) Capabilities() session.StoreCapabilities {
+	return session.StoreCapabilities{
+		List:      true,
+		ExpiresAt: true,
+	}
+}
+
+// Close leaves the cluster and stops replicating. Sessions are kept in memory
+// but no longer replicated.
+func (s *gossipStore) Close() error {
+	err := s.members.Leave(5 * time.Second)
+	if err != nil {
+		s.errorFunc(fmt.Errorf("leave: %w", err))
+	}
+	return s.members.Shutdown()
+}
+
+// encodeMessages encodes the changes to be sent to peers.
+func encodeMessages(msgs []*message) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(msgs)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeMessages decodes the changes received from peers.
+func decodeMessages(binary []byte) ([]*message, error) {
+	var msgs []*message
+	err := gob.NewDecoder(bytes.NewReader(binary)).Decode(&msgs)
+	if err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// replicate sends the change to peers. Changes that fit in a gossip message are
+// gossiped, others are sent to each peer over reliable connections in the
+// background. Changes that fail to be delivered are reconciled by the periodic
+// state sync of the memberlist.
+func (s *gossipStore) replicate(m *message) {
+	if s.members == nil {
+		return
+	}
+
+	binary, err := encodeMessages([]*message{m})
+	if err != nil {
+		s.errorFunc(fmt.Errorf("encode %q for replication: %w", m.SID, err))
+		return
+	}
+
+	if len(binary) <= maxGossipSize {
+		s.queue.QueueBroadcast(&broadcast{sid: m.SID, binary: binary})
+		return
+	}
+
+	self := s.members.LocalNode()
+	for _, node := range s.members.Members() {
+		if node.Name == self.Name {
+			continue
+		}
+		go func(node *memberlist.Node) {
+			err := s.members.SendReliable(node, binary)
+			if err != nil {
+				s.errorFunc(fmt.Errorf("send %q to %q: %w", m.SID, node.Name, err))
+			}
+		}(node)
+	}
+}
+
+// maxGossipSize is the maximum size of encoded changes to be gossiped, which
+// leaves room for the overhead within the UDP buffer size of the memberlist.
+const maxGossipSize = 1024
+
+var _ memberlist.Broadcast = (*broadcast)(nil)
+
+// broadcast is a change of a session to be gossiped.
+type broadcast struct {
+	sid    string
+	binary []byte
+}
+
+// Invalidates implements `memberlist.Broadcast.Invalidates`. Changes of the
+// same session invalidate the earlier ones in the queue.
+func (b *broadcast) Invalidates(other memberlist.Broadcast) bool {
+	o, ok := other.(*broadcast)
+	return ok && o.sid == b.sid
+}
+
+func (b *broadcast) Message() []byte {
+	return b.binary
+}
+
+func (b *broadcast) Finished() {}
+
+var _ memberlist.Delegate = (*delegate)(nil)
+
+// delegate hooks the session store into the memberlist.
+type delegate struct {
+	store *gossipStore
+}
+
+func (d *delegate) NodeMeta(int) []byte {
+	return nil
+}
+
+// NotifyMsg implements `memberlist.Delegate.NotifyMsg`, which applies changes
+// received from peers.
+func (d *delegate) NotifyMsg(binary []byte) {
+	msgs, err := decodeMessages(binary)
+	if err != nil {
+		d.store.errorFunc(fmt.Errorf("decode replicated changes: %w", err))
+		return
+	}
+	for _, m := range msgs {
+		d.store.apply(m)
+	}
+}
+
+func (d *delegate) GetBroadcasts(overhead, limit int) [][]byte {
+	return d.store.queue.GetBroadcasts(overhead, limit)
+}
+
+// LocalState implements `memberlist.Delegate.LocalState`, which sends all
+// sessions (including tombstones) to the peer for the full state sync, e.g.
+// when a node (re)joins the cluster.
+func (d *delegate) LocalState(bool) []byte {
+	s := d.store
+	s.lock.RLock()
+	msgs := make([]*message, 0, len(s.entries))
+	for sid, e := range s.entries {
+		msgs = append(msgs, &message{
+			SID:        sid,
+			Data:       e.binary,
+			AccessedAt: e.accessedAt.UnixNano(),
+			Node:       e.node,
+		})
+	}
+	s.lock.RUnlock()
+
+	binary, err := encodeMessages(msgs)
+	if err != nil {
+		s.errorFunc(fmt.Errorf("encode local state: %w", err))
+		return nil
+	}
+	return binary
+}
+
+// MergeRemoteState implements `memberlist.Delegate.MergeRemoteState`, where
+// newer sessions of the peer win.
+func (d *delegate) MergeRemoteState(binary []byte, _ bool) {
+	if len(binary) == 0 {
+		return
+	}
+	d.NotifyMsg(binary)
+}
+
+// Config contains options for the gossiping memory session store.
+type Config struct {
+	// Lifetime is the duration to have no access to a session before being
+	// recycled. Default is 3600 seconds.
+	Lifetime time.Duration
+	// NowFunc is the function to return the current time for expiry of sessions
+	// and resolving conflicts, e.g. to simulate the passage of time in
+	// integration tests. Clocks of peers should be synchronized. Default is
+	// time.Now.
+	NowFunc func() time.Time
+	// Encoder is the encoder to encode session data. Default is
+	// session.GobEncoder.
+	Encoder session.Encoder
+	// Decoder is the decoder to decode session data. Default is
+	// session.GobDecoder.
+	Decoder session.Decoder
+	// Memberlist is the configuration of the membership of peers, whose Delegate
+	// is set by the store. The Name must be unique within the cluster. Default is
+	// memberlist.DefaultLANConfig().
+	Memberlist *memberlist.Config
+	// Peers is the list of addresses (i.e. "host:port") of peers to join. Nodes
+	// that fail to join any peer start a cluster on their own, which peers may
+	// join later. Default is not set, i.e. starting a new cluster.
+	Peers []string
+	// ErrorFunc is the function used to print errors of replicating sessions.
+	// Default is to drop errors silently.
+	ErrorFunc func(err error)
+}
+
+// Initer returns the session.Initer for the gossiping memory session store.
+// The session store implements the `Close() error` method to leave the
+// cluster.
+func Initer() session.Initer {
+	return func(_ context.Context, args ...interface{}) (session.Store, error) {
+		var cfg *Config
+		var idWriter session.IDWriter
+		for i := range args {
+			switch v := args[i].(type) {
+			case Config:
+				cfg = &v
+			case session.IDWriter:
+				idWriter = v
+			}
+		}
+		if idWriter == nil {
+			return nil, errors.New("IDWriter not given")
+		}
+
+		if cfg == nil {
+			return nil, fmt.Errorf("config object with the type '%T' not found", Config{})
+		}
+		if cfg.NowFunc == nil {
+			cfg.NowFunc = time.Now
+		}
+		if cfg.Lifetime.Seconds() < 1 {
+			cfg.Lifetime = 3600 * time.Second
+		}
+		if cfg.Encoder == nil {
+			cfg.Encoder = session.GobEncoder
+		}
+		if cfg.Decoder == nil {
+			cfg.Decoder = session.GobDecoder
+		}
+		if cfg.Memberlist == nil {
+			cfg.Memberlist = memberlist.DefaultLANConfig()
+		}
+		if cfg.ErrorFunc == nil {
+			cfg.ErrorFunc = func(error) {}
+		}
+
+		store := newGossipStore(*cfg, idWriter)
+		mlConfig := *cfg.Memberlist
+		mlConfig.Delegate = &delegate{store: store}
+		members, err := memberlist.Create(&mlConfig)
+		if err != nil {
+			return nil, fmt.Errorf("create memberlist: %w", err)
+		}
+		store.members = members
+		store.queue = &memberlist.TransmitLimitedQueue{
+			NumNodes:       members.NumMembers,
+			RetransmitMult: mlConfig.RetransmitMult,
+		}
+
+		if len(cfg.Peers) > 0 {
+			_, err = members.Join(cfg.Peers)
+			if err != nil {
+				cfg.ErrorFunc(fmt.Errorf("join peers: %w", err))
+			}
+		}
+		return store, nil
+	}
+}
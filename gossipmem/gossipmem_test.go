@@ -0,0 +1,142 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gossipmem
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/session"
+	"github.com/flamego/session/storetest"
+)
+
+func newTestMemberlistConfig(name string) *memberlist.Config {
+	cfg := memberlist.DefaultLocalConfig()
+	cfg.Name = name
+	cfg.BindAddr = "127.0.0.1"
+	cfg.BindPort = 0
+	cfg.Logger = log.New(io.Discard, "", 0)
+	return cfg
+}
+
+func newTestNode(t *testing.T, name string, peers ...*gossipStore) *gossipStore {
+	var addrs []string
+	for _, peer := range peers {
+		node := peer.members.LocalNode()
+		addrs = append(addrs, fmt.Sprintf("%s:%d", node.Addr, node.Port))
+	}
+
+	store, err := Initer()(
+		context.Background(),
+		Config{
+			Memberlist: newTestMemberlistConfig(name),
+			Peers:      addrs,
+			ErrorFunc:  func(err error) { t.Error(err) },
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.(*gossipStore).members.Shutdown() })
+	return store.(*gossipStore)
+}
+
+func TestGossipStore(t *testing.T) {
+	ctx := context.Background()
+	node1 := newTestNode(t, "node1")
+	node2 := newTestNode(t, "node2", node1)
+	node3 := newTestNode(t, "node3", node1)
+
+	sess, err := node1.Read(ctx, "111")
+	require.NoError(t, err)
+	sess.Set("name", "flamego")
+	require.NoError(t, node1.Save(ctx, sess))
+
+	for _, node := range []*gossipStore{node2, node3} {
+		require.Eventually(t, func() bool { return node.Exist(ctx, "111") }, 5*time.Second, 10*time.Millisecond)
+		got, err := node.Read(ctx, "111")
+		require.NoError(t, err)
+		assert.Equal(t, "flamego", got.Get("name"))
+	}
+
+	// Sessions larger than a gossip message are sent over reliable connections
+	sess.Set("blob", make([]byte, 4*maxGossipSize))
+	require.NoError(t, node1.Save(ctx, sess))
+	require.Eventually(t, func() bool {
+		got, err := node2.Read(ctx, "111")
+		return err == nil && got.Get("blob") != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, node2.Destroy(ctx, "111"))
+	for _, node := range []*gossipStore{node1, node3} {
+		require.Eventually(t, func() bool { return !node.Exist(ctx, "111") }, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestGossipStore_Join(t *testing.T) {
+	ctx := context.Background()
+	node1 := newTestNode(t, "node1")
+
+	sess, err := node1.Read(ctx, "111")
+	require.NoError(t, err)
+	sess.Set("name", "flamego")
+	require.NoError(t, node1.Save(ctx, sess))
+
+	// Sessions saved before joining are brought by the full state sync
+	node2 := newTestNode(t, "node2", node1)
+	assert.True(t, node2.Exist(ctx, "111"))
+}
+
+func TestGossipStore_Conflict(t *testing.T) {
+	now := time.Now()
+	store := newGossipStore(
+		Config{
+			NowFunc:  func() time.Time { return now },
+			Lifetime: time.Hour,
+			Encoder:  session.GobEncoder,
+			Decoder:  session.GobDecoder,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+
+	newer := &message{SID: "111", Data: []byte("newer"), AccessedAt: now.UnixNano(), Node: "node1"}
+	older := &message{SID: "111", Data: []byte("older"), AccessedAt: now.Add(-time.Second).UnixNano(), Node: "node2"}
+	assert.True(t, store.apply(newer))
+	assert.False(t, store.apply(older))
+	assert.Equal(t, []byte("newer"), store.entries["111"].binary)
+
+	// Ties are broken by node names
+	tie := &message{SID: "111", Data: []byte("tie"), AccessedAt: now.UnixNano(), Node: "node0"}
+	assert.False(t, store.apply(tie))
+	tie.Node = "node2"
+	assert.True(t, store.apply(tie))
+	assert.Equal(t, []byte("tie"), store.entries["111"].binary)
+
+	// Stale saves do not resurrect destroyed sessions
+	tombstone := &message{SID: "111", AccessedAt: now.Add(time.Second).UnixNano(), Node: "node1"}
+	assert.True(t, store.apply(tombstone))
+	assert.False(t, store.apply(newer))
+	assert.False(t, store.Exist(context.Background(), "111"))
+
+	// Touches do not create sessions
+	touch := &message{SID: "222", Touch: true, AccessedAt: now.UnixNano(), Node: "node1"}
+	assert.False(t, store.apply(touch))
+}
+
+func TestGossipStore_Conformance(t *testing.T) {
+	storetest.Conformance(t, Initer(), Config{
+		NowFunc:    time.Now,
+		Lifetime:   time.Second,
+		Memberlist: newTestMemberlistConfig("node1"),
+	})
+}
@@ -0,0 +1,144 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// DefaultIDAlphabet is the default alphabet of session IDs.
+const DefaultIDAlphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// IDEncoding is the encoding of random bytes into session IDs when
+// Options.IDEntropyBytes is set.
+type IDEncoding int
+
+const (
+	// IDEncodingBase62 encodes random bytes with digits and letters in both cases,
+	// left-padded with "0" to a fixed length.
+	IDEncodingBase62 IDEncoding = iota
+	// IDEncodingBase64URL encodes random bytes with the unpadded URL-safe base64
+	// encoding as defined in RFC 4648.
+	IDEncodingBase64URL
+)
+
+const (
+	base62Alphabet    = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	base64URLAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+)
+
+// idPolicy is the policy of generating and validating session IDs.
+type idPolicy struct {
+	length       int        // The length of session IDs
+	alphabet     string     // The alphabet of session IDs
+	entropyBytes int        // The number of random bytes to encode, 0 to draw characters from the alphabet
+	encoding     IDEncoding // The encoding of random bytes
+}
+
+// newIDPolicy returns a new ID policy based on given options. It returns an
+// error if the alphabet or the encoding is invalid.
+func newIDPolicy(opts Options) (idPolicy, error) {
+	if opts.IDEntropyBytes > 0 {
+		p := idPolicy{
+			entropyBytes: opts.IDEntropyBytes,
+			encoding:     opts.IDEncoding,
+		}
+		switch opts.IDEncoding {
+		case IDEncodingBase62:
+			p.alphabet = base62Alphabet
+			p.length = base62Length(opts.IDEntropyBytes)
+		case IDEncodingBase64URL:
+			p.alphabet = base64URLAlphabet
+			p.length = base64.RawURLEncoding.EncodedLen(opts.IDEntropyBytes)
+		default:
+			return idPolicy{}, fmt.Errorf("unknown encoding %d", opts.IDEncoding)
+		}
+		return p, nil
+	}
+
+	if opts.IDAlphabet == "" {
+		opts.IDAlphabet = DefaultIDAlphabet
+	}
+	for i := range opts.IDAlphabet {
+		if !isIDChar(opts.IDAlphabet[i]) {
+			return idPolicy{}, fmt.Errorf("invalid character %q in alphabet, only [0-9A-Za-z_-] are allowed", opts.IDAlphabet[i])
+		} else if strings.IndexByte(opts.IDAlphabet[i+1:], opts.IDAlphabet[i]) >= 0 {
+			return idPolicy{}, fmt.Errorf("duplicated character %q in alphabet", opts.IDAlphabet[i])
+		}
+	}
+	if len(opts.IDAlphabet) < 2 {
+		return idPolicy{}, errors.New("alphabet must contain at least 2 characters")
+	}
+	return idPolicy{
+		length:   opts.IDLength,
+		alphabet: opts.IDAlphabet,
+	}, nil
+}
+
+// isIDChar returns true if the character is allowed in session IDs, i.e. one of
+// [0-9A-Za-z_-], which are safe as cookie values and file names.
+func isIDChar(c byte) bool {
+	return '0' <= c && c <= '9' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || c == '_' || c == '-'
+}
+
+// base62Length returns the length of the base62 encoding of given number of
+// bytes, which is the smallest number of digits to represent any value of
+// the bytes.
+func base62Length(n int) int {
+	max := new(big.Int).Lsh(big.NewInt(1), uint(n*8))
+	limit := big.NewInt(1)
+	length := 0
+	for limit.Cmp(max) < 0 {
+		limit.Mul(limit, big.NewInt(62))
+		length++
+	}
+	return length
+}
+
+// generate returns a new session ID.
+func (p idPolicy) generate() (string, error) {
+	if p.entropyBytes <= 0 {
+		return randomString(p.alphabet, p.length)
+	}
+
+	b := make([]byte, p.entropyBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	if p.encoding == IDEncodingBase64URL {
+		return base64.RawURLEncoding.EncodeToString(b), nil
+	}
+
+	v := new(big.Int).SetBytes(b)
+	base := big.NewInt(62)
+	mod := new(big.Int)
+	buffer := make([]byte, p.length)
+	for i := p.length - 1; i >= 0; i-- {
+		v.DivMod(v, base, mod)
+		buffer[i] = base62Alphabet[mod.Int64()]
+	}
+	return string(buffer), nil
+}
+
+// valid returns true if given session ID looks like a valid ID.
+func (p idPolicy) valid(sid string) bool {
+	if len(sid) != p.length {
+		return false
+	}
+
+	for i := range sid {
+		if strings.IndexByte(p.alphabet, sid[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
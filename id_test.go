@@ -0,0 +1,132 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestNewIDPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantLen int
+		wantErr string
+	}{
+		{
+			name:    "default alphabet",
+			opts:    Options{IDLength: 16},
+			wantLen: 16,
+		},
+		{
+			name:    "custom alphabet",
+			opts:    Options{IDLength: 32, IDAlphabet: "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"},
+			wantLen: 32,
+		},
+		{
+			name:    "base62",
+			opts:    Options{IDEntropyBytes: 32},
+			wantLen: 43,
+		},
+		{
+			name:    "base64url",
+			opts:    Options{IDEntropyBytes: 32, IDEncoding: IDEncodingBase64URL},
+			wantLen: 43,
+		},
+		{
+			name:    "short alphabet",
+			opts:    Options{IDLength: 16, IDAlphabet: "a"},
+			wantErr: "alphabet must contain at least 2 characters",
+		},
+		{
+			name:    "duplicated character",
+			opts:    Options{IDLength: 16, IDAlphabet: "abca"},
+			wantErr: `duplicated character 'a' in alphabet`,
+		},
+		{
+			name:    "path separator",
+			opts:    Options{IDLength: 16, IDAlphabet: "abc./"},
+			wantErr: `invalid character '.' in alphabet, only [0-9A-Za-z_-] are allowed`,
+		},
+		{
+			name:    "cookie delimiter",
+			opts:    Options{IDLength: 16, IDAlphabet: "abc;"},
+			wantErr: `invalid character ';' in alphabet, only [0-9A-Za-z_-] are allowed`,
+		},
+		{
+			name:    "unknown encoding",
+			opts:    Options{IDEntropyBytes: 32, IDEncoding: 99},
+			wantErr: "unknown encoding 99",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ids, err := newIDPolicy(test.opts)
+			if test.wantErr != "" {
+				require.Error(t, err)
+				assert.Equal(t, test.wantErr, err.Error())
+				return
+			}
+			require.NoError(t, err)
+
+			for i := 0; i < 10; i++ {
+				sid, err := ids.generate()
+				require.NoError(t, err)
+				assert.Len(t, sid, test.wantLen)
+				assert.True(t, ids.valid(sid), "generated session ID %q is valid", sid)
+			}
+		})
+	}
+}
+
+func TestSessioner_IDEntropyBytes(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			IDEntropyBytes: 32,
+			IDEncoding:     IDEncodingBase64URL,
+		},
+	))
+	f.Get("/", func(s Session) string {
+		return s.ID()
+	})
+	f.Get("/regenerate", func(w http.ResponseWriter, r *http.Request, s Session) string {
+		require.NoError(t, s.RegenerateID(w, r))
+		return s.ID()
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	sid := resp.Body.String()
+	assert.Len(t, sid, 43)
+
+	// The session ID is accepted in subsequent requests
+	cookie := resp.Header().Get("Set-Cookie")
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Cookie", cookie)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, sid, resp.Body.String())
+
+	// Regenerated session IDs follow the policy
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/regenerate", nil)
+	require.NoError(t, err)
+	req.Header.Set("Cookie", cookie)
+	f.ServeHTTP(resp, req)
+	assert.Len(t, resp.Body.String(), 43)
+	assert.NotEqual(t, sid, resp.Body.String())
+}
@@ -0,0 +1,32 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"fmt"
+)
+
+// counter is a session that is capable of maintaining counters, which may be
+// delegated to the session store.
+type counter interface {
+	// incr increments the counter of given key by delta and returns the new value.
+	incr(key string, delta int64) (int64, error)
+}
+
+// Incr increments the counter of given key in the session by delta and returns
+// the new value, use delta of 0 to read the counter. When the session store
+// implements session.Incrementer, counters are maintained by the session store
+// and increments are atomic across all instances. Otherwise, counters fall back
+// to int64 values in the session data, where increments are only atomic within
+// the current instance and concurrent requests may overwrite each other when
+// the session is saved. It returns an error if the session store fails to
+// increment, or the session is not capable of counters.
+func Incr(s Session, key string, delta int64) (int64, error) {
+	c, ok := s.(counter)
+	if !ok {
+		return 0, fmt.Errorf("session with the type %T does not support counters", s)
+	}
+	return c.incr(key, delta)
+}
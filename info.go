@@ -0,0 +1,47 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"fmt"
+	"time"
+)
+
+// Info is the lightweight information of the session of the current request,
+// which is injected by the session.Sessioner alongside the session. It is meant
+// for handlers such as logging middleware that need to identify the session
+// without touching the session data.
+//
+// The information is taken when the session is loaded, thus it does not reflect
+// changes made by subsequent handlers, e.g. ID regeneration, or sessions
+// deferred by Options.DisableAutoCreate being started.
+type Info struct {
+	// HashedID is the hex-encoded prefix of the HMAC-SHA256 of the session ID with
+	// AuditOptions.HashKey, which identifies the session in logs without revealing
+	// the session ID, the same as AuditEntry.HashedSessionID. It is empty if the
+	// session ID is empty or AuditOptions.HashKey is not set, as hashes with the
+	// random default key are not comparable across instances and restarts.
+	HashedID string
+	// Created indicates whether the session is created by the current request.
+	Created bool
+	// CreatedAt is the time when the session was created, or zero if unknown,
+	// e.g. the creation time is not tracked (see Options.TrackInfo).
+	CreatedAt time.Time
+	// StoreType is the type of the underlying session store, e.g.
+	// "*session.memoryStore".
+	StoreType string
+}
+
+// storeType returns the type name of the innermost session store in the chain
+// of session store wrappers.
+func storeType(store Store) string {
+	for {
+		u, ok := store.(interface{ Unwrap() Store })
+		if !ok || u.Unwrap() == nil {
+			return fmt.Sprintf("%T", store)
+		}
+		store = u.Unwrap()
+	}
+}
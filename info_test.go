@@ -0,0 +1,75 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestSessioner_Info(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			NowFunc:   func() time.Time { return now },
+			Audit:     AuditOptions{HashKey: []byte("secret")},
+			TrackInfo: true,
+			StoreWrappers: []StoreMiddleware{
+				func(store Store) Store { return &namedStore{Store: store} },
+			},
+		},
+	))
+	f.Get("/", func(s Session, info Info) string {
+		s.Set("username", "flamego")
+		assert.Equal(t, hashSessionID([]byte("secret"), s.ID()), info.HashedID)
+		return strconv.FormatBool(info.Created) + "," + strconv.FormatInt(info.CreatedAt.Unix(), 10) + "," + info.StoreType
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, "true,1700000000,*session.memoryStore", resp.Body.String())
+
+	now = now.Add(time.Minute)
+	cookie := resp.Header().Get("Set-Cookie")
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Cookie", cookie)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, "false,1700000000,*session.memoryStore", resp.Body.String())
+}
+
+func TestSessioner_InfoWithoutHashKey(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner())
+	f.Get("/", func(s Session, info Info) {
+		assert.NotEmpty(t, s.ID())
+		assert.Empty(t, info.HashedID)
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestStoreType(t *testing.T) {
+	store := newMemoryStore(MemoryConfig{NowFunc: time.Now}, nil)
+	assert.Equal(t, "*session.memoryStore", storeType(store))
+	assert.Equal(t, "*session.memoryStore", storeType(&namedStore{Store: &namedStore{Store: store}}))
+}
@@ -0,0 +1,463 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package php provides session data encoders and decoders that are compatible
+// with PHP sessions, which allows sharing sessions with PHP applications
+// through the same session store, e.g. Redis.
+//
+// PHP values are mapped to Go values as follows:
+//
+//	null   <-> nil
+//	bool   <-> bool
+//	int    <-> int64 (all Go integer types when encoding)
+//	float  <-> float64 (float32 when encoding)
+//	string <-> string
+//	array  <-> []interface{} (arrays with sequential keys from 0),
+//	           map[interface{}]interface{} (others, keys are int64 or string)
+//	object <-> Object
+//
+// When encoding, maps and slices of any types are also accepted.
+package php
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/flamego/session"
+)
+
+const (
+	// RedisKeyPrefix is the default prefix of session keys used by the Redis
+	// session handler of PHP (phpredis), use it as redis.Config.KeyPrefix.
+	RedisKeyPrefix = "PHPREDIS_SESSION:"
+	// CookieName is the default name of the session cookie of PHP, use it as
+	// session.CookieOptions.Name.
+	CookieName = "PHPSESSID"
+	// IDLength is the default length of session IDs of PHP, use it as
+	// session.Options.IDLength.
+	IDLength = 26
+)
+
+// Object is a PHP object.
+type Object struct {
+	// Class is the class name of the object.
+	Class string
+	// Properties is the properties of the object. Names of private and protected
+	// properties are kept as-is, i.e. prefixed with the class name or "*"
+	// surrounded by NUL bytes.
+	Properties map[string]interface{}
+}
+
+// Decoder is a session data decoder for the "php" serialize handler of PHP,
+// which is the default format of PHP sessions.
+func Decoder(binary []byte) (session.Data, error) {
+	data := make(session.Data)
+	d := &decoder{buf: binary}
+	for d.pos < len(d.buf) {
+		i := bytes.IndexByte(d.buf[d.pos:], '|')
+		if i < 0 {
+			return nil, fmt.Errorf("missing separator after the key at offset %d", d.pos)
+		}
+		key := string(d.buf[d.pos : d.pos+i])
+		d.pos += i + 1
+
+		val, err := d.value()
+		if err != nil {
+			return nil, fmt.Errorf("decode value of %q: %w", key, err)
+		}
+		data[key] = val
+	}
+	return data, nil
+}
+
+// Encoder is a session data encoder for the "php" serialize handler of PHP.
+// Keys of the session data must be strings without the "|" character.
+func Encoder(data session.Data) ([]byte, error) {
+	keys, err := sortedKeys(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		if bytes.IndexByte([]byte(key), '|') >= 0 {
+			return nil, fmt.Errorf("key %q contains the separator", key)
+		}
+		buf.WriteString(key)
+		buf.WriteByte('|')
+
+		err = serialize(&buf, data[key])
+		if err != nil {
+			return nil, fmt.Errorf("encode value of %q: %w", key, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// SerializeDecoder is a session data decoder for the "php_serialize" serialize
+// handler of PHP.
+func SerializeDecoder(binary []byte) (session.Data, error) {
+	v, err := Unserialize(binary)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(session.Data)
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		for k, val := range v {
+			data[k] = val
+		}
+	case []interface{}:
+		for k, val := range v {
+			data[int64(k)] = val
+		}
+	default:
+		return nil, fmt.Errorf("want an array but got %T", v)
+	}
+	return data, nil
+}
+
+// SerializeEncoder is a session data encoder for the "php_serialize" serialize
+// handler of PHP.
+func SerializeEncoder(data session.Data) ([]byte, error) {
+	return Serialize(map[interface{}]interface{}(data))
+}
+
+// Unserialize decodes the value in the format of PHP's serialize function.
+func Unserialize(binary []byte) (interface{}, error) {
+	d := &decoder{buf: binary}
+	v, err := d.value()
+	if err != nil {
+		return nil, err
+	} else if d.pos != len(d.buf) {
+		return nil, fmt.Errorf("unexpected trailing data at offset %d", d.pos)
+	}
+	return v, nil
+}
+
+// Serialize encodes the value in the format of PHP's serialize function.
+func Serialize(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := serialize(&buf, v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decoder is a decoder of values in the format of PHP's serialize function.
+type decoder struct {
+	buf []byte // The buffer to decode
+	pos int    // The current offset in the buffer
+}
+
+// errorf returns an error with the current offset.
+func (d *decoder) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("offset %d: %s", d.pos, fmt.Sprintf(format, args...))
+}
+
+// expect consumes the given byte.
+func (d *decoder) expect(c byte) error {
+	if d.pos >= len(d.buf) || d.buf[d.pos] != c {
+		return d.errorf("want %q", c)
+	}
+	d.pos++
+	return nil
+}
+
+// until consumes and returns the bytes until the given delimiter, the delimiter
+// is consumed but not returned.
+func (d *decoder) until(delim byte) (string, error) {
+	i := bytes.IndexByte(d.buf[d.pos:], delim)
+	if i < 0 {
+		return "", d.errorf("missing %q", delim)
+	}
+	s := string(d.buf[d.pos : d.pos+i])
+	d.pos += i + 1
+	return s, nil
+}
+
+// int consumes an integer until the given delimiter.
+func (d *decoder) int(delim byte) (int64, error) {
+	s, err := d.until(delim)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, d.errorf("invalid integer %q", s)
+	}
+	return n, nil
+}
+
+// string consumes a length-prefixed string in the form of `<len>:"<bytes>"`,
+// followed by the given delimiter.
+func (d *decoder) string(delim byte) (string, error) {
+	n, err := d.int(':')
+	if err != nil {
+		return "", err
+	}
+	err = d.expect('"')
+	if err != nil {
+		return "", err
+	}
+	if n < 0 || int64(len(d.buf)-d.pos) < n {
+		return "", d.errorf("invalid string length %d", n)
+	}
+	s := string(d.buf[d.pos : d.pos+int(n)])
+	d.pos += int(n)
+
+	err = d.expect('"')
+	if err != nil {
+		return "", err
+	}
+	return s, d.expect(delim)
+}
+
+// value consumes a value.
+func (d *decoder) value() (interface{}, error) {
+	if d.pos+1 >= len(d.buf) {
+		return nil, d.errorf("unexpected end of data")
+	}
+
+	typ := d.buf[d.pos]
+	if typ == 'N' {
+		d.pos++
+		return nil, d.expect(';')
+	}
+
+	d.pos++
+	err := d.expect(':')
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case 'b':
+		n, err := d.int(';')
+		if err != nil {
+			return nil, err
+		}
+		return n != 0, nil
+	case 'i':
+		return d.int(';')
+	case 'd':
+		s, err := d.until(';')
+		if err != nil {
+			return nil, err
+		}
+		switch s {
+		case "INF":
+			s = "+Inf"
+		case "-INF":
+			s = "-Inf"
+		case "NAN":
+			s = "NaN"
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, d.errorf("invalid float %q", s)
+		}
+		return f, nil
+	case 's':
+		return d.string(';')
+	case 'a':
+		return d.array()
+	case 'O':
+		class, err := d.string(':')
+		if err != nil {
+			return nil, err
+		}
+		arr, err := d.array()
+		if err != nil {
+			return nil, err
+		}
+
+		obj := Object{
+			Class:      class,
+			Properties: make(map[string]interface{}),
+		}
+		switch arr := arr.(type) {
+		case map[interface{}]interface{}:
+			for k, v := range arr {
+				obj.Properties[fmt.Sprintf("%v", k)] = v
+			}
+		case []interface{}:
+			for k, v := range arr {
+				obj.Properties[strconv.Itoa(k)] = v
+			}
+		}
+		return obj, nil
+	}
+	return nil, d.errorf("unsupported type %q", typ)
+}
+
+// array consumes the rest of an array in the form of `<n>:{<key><value>...}`.
+func (d *decoder) array() (interface{}, error) {
+	n, err := d.int(':')
+	if err != nil {
+		return nil, err
+	} else if n < 0 {
+		return nil, d.errorf("invalid array length %d", n)
+	}
+	err = d.expect('{')
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[interface{}]interface{}, n)
+	list := true
+	for i := int64(0); i < n; i++ {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		switch k.(type) {
+		case int64, string:
+		default:
+			return nil, d.errorf("invalid array key type %T", k)
+		}
+		if k != i {
+			list = false
+		}
+
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+
+	err = d.expect('}')
+	if err != nil {
+		return nil, err
+	}
+
+	if !list || n == 0 {
+		return m, nil
+	}
+	s := make([]interface{}, n)
+	for i := range s {
+		s[i] = m[int64(i)]
+	}
+	return s, nil
+}
+
+// serialize writes the value in the format of PHP's serialize function to the
+// buffer.
+func serialize(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("N;")
+		return nil
+	case bool:
+		if v {
+			buf.WriteString("b:1;")
+		} else {
+			buf.WriteString("b:0;")
+		}
+		return nil
+	case string:
+		fmt.Fprintf(buf, "s:%d:\"%s\";", len(v), v)
+		return nil
+	case Object:
+		fmt.Fprintf(buf, "O:%d:\"%s\":", len(v.Class), v.Class)
+		props := make(map[interface{}]interface{}, len(v.Properties))
+		for k, val := range v.Properties {
+			props[k] = val
+		}
+		return serializeArray(buf, reflect.ValueOf(props))
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fmt.Fprintf(buf, "i:%d;", rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fmt.Fprintf(buf, "i:%d;", rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		buf.WriteString("d:")
+		f := rv.Float()
+		switch {
+		case math.IsInf(f, 1):
+			buf.WriteString("INF")
+		case math.IsInf(f, -1):
+			buf.WriteString("-INF")
+		case math.IsNaN(f):
+			buf.WriteString("NAN")
+		default:
+			buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+		buf.WriteByte(';')
+	case reflect.Slice, reflect.Array:
+		buf.WriteString("a:")
+		fmt.Fprintf(buf, "%d:{", rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			fmt.Fprintf(buf, "i:%d;", i)
+			err := serialize(buf, rv.Index(i).Interface())
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case reflect.Map:
+		buf.WriteString("a:")
+		return serializeArray(buf, rv)
+	default:
+		return fmt.Errorf("unsupported type %T", v)
+	}
+	return nil
+}
+
+// serializeArray writes the map as the rest of an array in the form of
+// `<n>:{<key><value>...}` to the buffer, keys are written in sorted order.
+func serializeArray(buf *bytes.Buffer, rv reflect.Value) error {
+	keys := rv.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprintf("%v", keys[i].Interface()) < fmt.Sprintf("%v", keys[j].Interface())
+	})
+
+	fmt.Fprintf(buf, "%d:{", len(keys))
+	for _, k := range keys {
+		key := k.Interface()
+		switch reflect.ValueOf(key).Kind() {
+		case reflect.String,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return fmt.Errorf("unsupported array key type %T", key)
+		}
+
+		err := serialize(buf, key)
+		if err != nil {
+			return err
+		}
+		err = serialize(buf, rv.MapIndex(k).Interface())
+		if err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// sortedKeys returns keys of the session data in sorted order, it returns an
+// error if any key is not a string.
+func sortedKeys(data session.Data) ([]string, error) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("want string keys but got %T", k)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
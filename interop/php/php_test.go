@@ -0,0 +1,72 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package php
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/session"
+)
+
+func TestDecoder(t *testing.T) {
+	binary := `user_id|i:42;username|s:7:"flamego";admin|b:1;ratio|d:0.5;flash|N;roles|a:2:{i:0;s:5:"admin";i:1;s:6:"editor";}prefs|a:1:{s:5:"theme";s:4:"dark";}cart|O:4:"Cart":1:{s:5:"items";i:3;}`
+	data, err := Decoder([]byte(binary))
+	require.NoError(t, err)
+
+	want := session.Data{
+		"user_id":  int64(42),
+		"username": "flamego",
+		"admin":    true,
+		"ratio":    0.5,
+		"flash":    nil,
+		"roles":    []interface{}{"admin", "editor"},
+		"prefs":    map[interface{}]interface{}{"theme": "dark"},
+		"cart":     Object{Class: "Cart", Properties: map[string]interface{}{"items": int64(3)}},
+	}
+	assert.Equal(t, want, data)
+
+	// Encoding and decoding again should result in the same data
+	encoded, err := Encoder(data)
+	require.NoError(t, err)
+	got, err := Decoder(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// Multi-byte strings are length-prefixed by bytes
+	data, err = Decoder([]byte(`name|s:6:"你好";`))
+	require.NoError(t, err)
+	assert.Equal(t, session.Data{"name": "你好"}, data)
+
+	for _, binary := range []string{
+		`user_id`,
+		`user_id|i:42`,
+		`name|s:10:"flamego";`,
+		`roles|a:1:{i:0;s:5:"admin";`,
+	} {
+		_, err = Decoder([]byte(binary))
+		assert.Error(t, err, binary)
+	}
+}
+
+func TestSerializeDecoder(t *testing.T) {
+	data := session.Data{
+		"user_id": 42,
+		"roles":   []string{"admin"},
+	}
+	binary, err := SerializeEncoder(data)
+	require.NoError(t, err)
+	assert.Equal(t, `a:2:{s:5:"roles";a:1:{i:0;s:5:"admin";}s:7:"user_id";i:42;}`, string(binary))
+
+	got, err := SerializeDecoder(binary)
+	require.NoError(t, err)
+	want := session.Data{
+		"user_id": int64(42),
+		"roles":   []interface{}{"admin"},
+	}
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,323 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package rails
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/flamego/session"
+)
+
+// MarshalDecoder is a session data decoder for sessions serialized with Ruby's
+// Marshal, which is the default serializer of redis-session-store and the
+// cookie store before Rails 4.1. Only the core types nil, true, false,
+// Integer, Float, String, Symbol, Array and Hash are supported, which covers
+// what Rails puts in sessions by default. Bignums that overflow int64 are
+// decoded as *big.Int.
+//
+// Sessions cannot be encoded with Marshal, use the JSON serializer on the Rails
+// side if sessions need to be written by both applications.
+func MarshalDecoder(binary []byte) (session.Data, error) {
+	d := &marshalDecoder{buf: binary}
+	if len(binary) < 2 || binary[0] != 4 || binary[1] != 8 {
+		return nil, errors.New("unsupported Marshal version")
+	}
+	d.pos = 2
+
+	v, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("want a Hash but got %T", v)
+	}
+
+	data := make(session.Data, len(m))
+	for k, v := range m {
+		data[k] = v
+	}
+	return data, nil
+}
+
+// marshalDecoder is a decoder of values in the format of Ruby's Marshal.
+type marshalDecoder struct {
+	buf     []byte        // The buffer to decode
+	pos     int           // The current offset in the buffer
+	symbols []string      // The symbols that have been decoded, for symbol links
+	objects []interface{} // The objects that have been decoded, for object links
+}
+
+// errorf returns an error with the current offset.
+func (d *marshalDecoder) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("offset %d: %s", d.pos, fmt.Sprintf(format, args...))
+}
+
+// byte consumes a byte.
+func (d *marshalDecoder) byte() (byte, error) {
+	if d.pos >= len(d.buf) {
+		return 0, d.errorf("unexpected end of data")
+	}
+	c := d.buf[d.pos]
+	d.pos++
+	return c, nil
+}
+
+// bytes consumes a length-prefixed byte sequence.
+func (d *marshalDecoder) bytes() ([]byte, error) {
+	n, err := d.int()
+	if err != nil {
+		return nil, err
+	} else if n < 0 || int64(len(d.buf)-d.pos) < n {
+		return nil, d.errorf("invalid length %d", n)
+	}
+	b := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// int consumes an integer in the packed format of Marshal.
+func (d *marshalDecoder) int() (int64, error) {
+	c, err := d.byte()
+	if err != nil {
+		return 0, err
+	}
+
+	n := int8(c)
+	switch {
+	case n == 0:
+		return 0, nil
+	case n > 4:
+		return int64(n) - 5, nil
+	case n < -4:
+		return int64(n) + 5, nil
+	case n > 0:
+		var x int64
+		for i := 0; i < int(n); i++ {
+			c, err = d.byte()
+			if err != nil {
+				return 0, err
+			}
+			x |= int64(c) << (8 * i)
+		}
+		return x, nil
+	default:
+		x := int64(-1)
+		for i := 0; i < int(-n); i++ {
+			c, err = d.byte()
+			if err != nil {
+				return 0, err
+			}
+			x &^= 0xff << (8 * i)
+			x |= int64(c) << (8 * i)
+		}
+		return x, nil
+	}
+}
+
+// symbol consumes a symbol or a symbol link.
+func (d *marshalDecoder) symbol() (string, error) {
+	c, err := d.byte()
+	if err != nil {
+		return "", err
+	}
+
+	switch c {
+	case ':':
+		b, err := d.bytes()
+		if err != nil {
+			return "", err
+		}
+		d.symbols = append(d.symbols, string(b))
+		return string(b), nil
+	case ';':
+		i, err := d.int()
+		if err != nil {
+			return "", err
+		} else if i < 0 || i >= int64(len(d.symbols)) {
+			return "", d.errorf("invalid symbol link %d", i)
+		}
+		return d.symbols[i], nil
+	}
+	return "", d.errorf("want a symbol but got %q", c)
+}
+
+// register registers the object for object links and returns its index.
+func (d *marshalDecoder) register(v interface{}) int {
+	d.objects = append(d.objects, v)
+	return len(d.objects) - 1
+}
+
+// value consumes a value.
+func (d *marshalDecoder) value() (interface{}, error) {
+	c, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch c {
+	case '0':
+		return nil, nil
+	case 'T':
+		return true, nil
+	case 'F':
+		return false, nil
+	case 'i':
+		return d.int()
+	case ':', ';':
+		d.pos--
+		return d.symbol()
+	case '"':
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		s := string(b)
+		d.register(s)
+		return s, nil
+	case 'f':
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+
+		var f float64
+		switch s := string(b); s {
+		case "inf":
+			f = math.Inf(1)
+		case "-inf":
+			f = math.Inf(-1)
+		case "nan":
+			f = math.NaN()
+		default:
+			f, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, d.errorf("invalid float %q", s)
+			}
+		}
+		d.register(f)
+		return f, nil
+	case 'l':
+		sign, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		n, err := d.int()
+		if err != nil {
+			return nil, err
+		} else if n < 0 || int64(len(d.buf)-d.pos) < 2*n {
+			return nil, d.errorf("invalid bignum length %d", n)
+		}
+
+		// The magnitude is in little-endian
+		b := make([]byte, 2*n)
+		for i := range b {
+			b[len(b)-1-i] = d.buf[d.pos+i]
+		}
+		d.pos += len(b)
+
+		x := new(big.Int).SetBytes(b)
+		if sign == '-' {
+			x.Neg(x)
+		}
+
+		var v interface{} = x
+		if x.IsInt64() {
+			v = x.Int64()
+		}
+		d.register(v)
+		return v, nil
+	case 'I':
+		// An object with instance variables, e.g. the encoding of a String, which
+		// are irrelevant for Go values.
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		n, err := d.int()
+		if err != nil {
+			return nil, err
+		}
+		for i := int64(0); i < n; i++ {
+			_, err = d.symbol()
+			if err != nil {
+				return nil, err
+			}
+			_, err = d.value()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case '[':
+		n, err := d.int()
+		if err != nil {
+			return nil, err
+		} else if n < 0 || n > int64(len(d.buf)) {
+			return nil, d.errorf("invalid array length %d", n)
+		}
+
+		idx := d.register(nil)
+		arr := make([]interface{}, n)
+		for i := range arr {
+			arr[i], err = d.value()
+			if err != nil {
+				return nil, err
+			}
+		}
+		d.objects[idx] = arr
+		return arr, nil
+	case '{', '}':
+		n, err := d.int()
+		if err != nil {
+			return nil, err
+		} else if n < 0 || n > int64(len(d.buf)) {
+			return nil, d.errorf("invalid hash length %d", n)
+		}
+
+		m := make(map[interface{}]interface{}, n)
+		d.register(m)
+		for i := int64(0); i < n; i++ {
+			k, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case nil, bool, int64, float64, string:
+			default:
+				return nil, d.errorf("unsupported hash key type %T", k)
+			}
+
+			v, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+
+		// Skip the default value of the hash
+		if c == '}' {
+			_, err = d.value()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case '@':
+		i, err := d.int()
+		if err != nil {
+			return nil, err
+		} else if i < 0 || i >= int64(len(d.objects)) {
+			return nil, d.errorf("invalid object link %d", i)
+		}
+		return d.objects[i], nil
+	}
+	return nil, d.errorf("unsupported type %q", c)
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package rails provides session data encoders and decoders that are compatible
+// with Ruby on Rails sessions, which allows sharing sessions with Rails
+// applications through the same session store, e.g. Redis.
+//
+// Ruby values are mapped to Go values as follows:
+//
+//	nil             <-> nil
+//	true, false     <-> bool
+//	Integer         <-> int64
+//	Float           <-> float64
+//	String, Symbol  <-> string
+//	Array           <-> []interface{}
+//	Hash            <-> map[string]interface{} (JSON),
+//	                    map[interface{}]interface{} (Marshal)
+package rails
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/flamego/session"
+)
+
+// JSONDecoder is a session data decoder for sessions serialized as JSON, i.e.
+// with `config.action_dispatch.cookies_serializer = :json` or the JSON
+// serializer of redis-session-store.
+func JSONDecoder(binary []byte) (session.Data, error) {
+	d := json.NewDecoder(bytes.NewReader(binary))
+	d.UseNumber()
+
+	var m map[string]interface{}
+	err := d.Decode(&m)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+
+	data := make(session.Data, len(m))
+	for k, v := range m {
+		data[k] = fromJSON(v)
+	}
+	return data, nil
+}
+
+// fromJSON converts numbers in the decoded JSON value to int64 or float64.
+func fromJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = fromJSON(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = fromJSON(v[k])
+		}
+	}
+	return v
+}
+
+// JSONEncoder is a session data encoder for sessions serialized as JSON. Keys
+// of the session data must be strings.
+func JSONEncoder(data session.Data) ([]byte, error) {
+	m := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("want string keys but got %T", k)
+		}
+		m[key] = v
+	}
+
+	binary, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+	return binary, nil
+}
+
+// PrivateIDKeyFunc returns a function to be used as redis.Config.KeyFunc that
+// derives Redis keys from session IDs the same way as Rack 2.0.8 and later, i.e.
+// the prefix followed by "2::" and the SHA-256 hex digest of the session ID in
+// the cookie. Use it with the same prefix as the `key_prefix` option of
+// redis-session-store.
+func PrivateIDKeyFunc(prefix string) func(sid string) string {
+	return func(sid string) string {
+		sum := sha256.Sum256([]byte(sid))
+		return prefix + "2::" + hex.EncodeToString(sum[:])
+	}
+}
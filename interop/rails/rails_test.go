@@ -0,0 +1,81 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package rails
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/session"
+)
+
+func TestJSON(t *testing.T) {
+	data, err := JSONDecoder([]byte(`{"session_id":"abc","user_id":42,"ratio":1.5,"roles":["admin",1]}`))
+	require.NoError(t, err)
+	want := session.Data{
+		"session_id": "abc",
+		"user_id":    int64(42),
+		"ratio":      1.5,
+		"roles":      []interface{}{"admin", int64(1)},
+	}
+	assert.Equal(t, want, data)
+
+	binary, err := JSONEncoder(data)
+	require.NoError(t, err)
+	assert.Equal(t, `{"ratio":1.5,"roles":["admin",1],"session_id":"abc","user_id":42}`, string(binary))
+
+	_, err = JSONEncoder(session.Data{1: "one"})
+	assert.Error(t, err)
+}
+
+func TestMarshalDecoder(t *testing.T) {
+	// Marshal.dump({"session_id" => "abc", "user_id" => 300, "flash" => nil, :sym => [1, true, 1.5, -300], "again" => <the same "abc">})
+	var binary []byte
+	binary = append(binary, 0x04, 0x08, '{', 0x0a)
+	binary = append(binary, 'I', '"', 0x0f)
+	binary = append(binary, "session_id"...)
+	binary = append(binary, 0x06, ':', 0x06, 'E', 'T')
+	binary = append(binary, 'I', '"', 0x08)
+	binary = append(binary, "abc"...)
+	binary = append(binary, 0x06, ';', 0x00, 'T')
+	binary = append(binary, 'I', '"', 0x0c)
+	binary = append(binary, "user_id"...)
+	binary = append(binary, 0x06, ';', 0x00, 'T')
+	binary = append(binary, 'i', 0x02, 0x2c, 0x01)
+	binary = append(binary, 'I', '"', 0x0a)
+	binary = append(binary, "flash"...)
+	binary = append(binary, 0x06, ';', 0x00, 'T')
+	binary = append(binary, '0')
+	binary = append(binary, ':', 0x08)
+	binary = append(binary, "sym"...)
+	binary = append(binary, '[', 0x09, 'i', 0x06, 'T', 'f', 0x08)
+	binary = append(binary, "1.5"...)
+	binary = append(binary, 'i', 0xfe, 0xd4, 0xfe)
+	binary = append(binary, 'I', '"', 0x0a)
+	binary = append(binary, "again"...)
+	binary = append(binary, 0x06, ';', 0x00, 'T')
+	binary = append(binary, '@', 0x07)
+
+	data, err := MarshalDecoder(binary)
+	require.NoError(t, err)
+	want := session.Data{
+		"session_id": "abc",
+		"user_id":    int64(300),
+		"flash":      nil,
+		"sym":        []interface{}{int64(1), true, 1.5, int64(-300)},
+		"again":      "abc",
+	}
+	assert.Equal(t, want, data)
+
+	_, err = MarshalDecoder([]byte{0x04, 0x08, '[', 0x00})
+	assert.Error(t, err)
+}
+
+func TestPrivateIDKeyFunc(t *testing.T) {
+	keyFunc := PrivateIDKeyFunc("session:")
+	assert.Equal(t, "session:2::ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", keyFunc("abc"))
+}
@@ -0,0 +1,207 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrKeyNotFound is returned when no key is found for the key ID.
+var ErrKeyNotFound = errors.New("key not found")
+
+// KeySet is a set of keys to verify tokens.
+type KeySet interface {
+	// Key returns the key with given key ID, which is a []byte for HS256, an
+	// *rsa.PublicKey for RS256 or an *ecdsa.PublicKey for ES256.
+	Key(kid string) (interface{}, error)
+}
+
+// StaticKeys is a static set of keys indexed by key IDs.
+type StaticKeys map[string]interface{}
+
+// Key implements `KeySet.Key`.
+func (ks StaticKeys) Key(kid string) (interface{}, error) {
+	key, ok := ks[kid]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+// JWKS is a set of keys that is fetched from a JSON Web Key Set (JWKS) URL and
+// cached. The cache is refreshed periodically, and also on demand when a key
+// ID is not found (at most once per minute) to pick up rotated keys in time.
+type JWKS struct {
+	url     string           // The URL of the JWKS
+	refresh time.Duration    // The interval of refreshing the cache
+	client  *http.Client     // The HTTP client to fetch the JWKS
+	nowFunc func() time.Time // The function to return the current time
+
+	lock      sync.Mutex             // The mutex to guard accesses to the fields below
+	keys      map[string]interface{} // The cached keys indexed by key IDs
+	fetchedAt time.Time              // The last time of fetching the JWKS
+}
+
+// NewJWKS returns a new key set that is fetched from given JWKS URL and refreshed
+// in given interval. Default interval is 1 hour.
+func NewJWKS(url string, refresh time.Duration) *JWKS {
+	if refresh <= 0 {
+		refresh = time.Hour
+	}
+	return &JWKS{
+		url:     url,
+		refresh: refresh,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		nowFunc: time.Now,
+	}
+}
+
+// Key implements `KeySet.Key`.
+func (ks *JWKS) Key(kid string) (interface{}, error) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	if ks.keys == nil || ks.nowFunc().Sub(ks.fetchedAt) >= ks.refresh {
+		err := ks.fetch()
+		if err != nil && ks.keys == nil {
+			return nil, err
+		}
+	}
+
+	key, ok := ks.keys[kid]
+	if ok {
+		return key, nil
+	}
+
+	if ks.nowFunc().Sub(ks.fetchedAt) >= time.Minute {
+		err := ks.fetch()
+		if err != nil {
+			return nil, err
+		}
+		key, ok = ks.keys[kid]
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// fetch fetches the JWKS and replaces the cached keys. It is not
+// concurrent-safe and is the caller's responsibility to ensure the lock is
+// held.
+func (ks *JWKS) fetch() error {
+	// Record the time even if failed, so that failures are not retried on every
+	// request.
+	ks.fetchedAt = ks.nowFunc()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, ks.url, nil)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch: unexpected status code %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.key()
+		if err != nil {
+			// Skip keys that are not supported, e.g. for encryption
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	ks.keys = keys
+	return nil
+}
+
+// jwk is a JSON Web Key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// Symmetric
+	K string `json:"k"`
+}
+
+// key returns the key represented by the JWK.
+func (k jwk) key() (interface{}, error) {
+	if k.Use != "" && k.Use != "sig" {
+		return nil, fmt.Errorf("unsupported use %q", k.Use)
+	}
+
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("decode n: %w", err)
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("decode e: %w", err)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("decode x: %w", err)
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("decode y: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "oct":
+		b, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return nil, fmt.Errorf("decode k: %w", err)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
@@ -0,0 +1,435 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package jwt provides a session ID transport that carries session IDs in
+// signed JSON Web Tokens (JWT), which can be verified without calling the
+// session store, e.g. by edge or CDN workers that have access to the public
+// keys.
+//
+// Example:
+//
+//	t, err := jwt.New(jwt.Options{
+//		SigningKey: privateKey,
+//		KeyID:      "2024-01",
+//		Keys:       jwt.NewJWKS("https://example.com/.well-known/jwks.json", time.Hour),
+//	})
+//	if err != nil {
+//		// ...
+//	}
+//	f.Use(session.Sessioner(session.Options{
+//		ReadIDFunc:  t.ReadID,
+//		WriteIDFunc: t.WriteID,
+//	}))
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/flamego/session"
+)
+
+// Signing algorithms supported by the transport.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	// ErrInvalidToken is returned when the token is malformed or its signature
+	// does not match.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken is returned when the token has expired.
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+// Claims is the set of claims carried by the token.
+type Claims struct {
+	// SessionID is the session ID.
+	SessionID string `json:"sid"`
+	// Issuer is the "iss" claim.
+	Issuer string `json:"iss,omitempty"`
+	// Audience is the "aud" claim.
+	Audience string `json:"aud,omitempty"`
+	// IssuedAt is the "iat" claim in Unix seconds.
+	IssuedAt int64 `json:"iat"`
+	// ExpiresAt is the "exp" claim in Unix seconds.
+	ExpiresAt int64 `json:"exp"`
+	// Extra is the additional claims, which are flattened into the token.
+	Extra map[string]interface{} `json:"-"`
+}
+
+// MarshalJSON implements `json.Marshaler`.
+func (c Claims) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(c.Extra)+5)
+	for k, v := range c.Extra {
+		m[k] = v
+	}
+
+	type claims Claims
+	binary, err := json.Marshal(claims(c))
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(binary, &m)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON implements `json.Unmarshaler`.
+func (c *Claims) UnmarshalJSON(binary []byte) error {
+	type claims Claims
+	err := json.Unmarshal(binary, (*claims)(c))
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(binary, &c.Extra)
+	if err != nil {
+		return err
+	}
+	for _, k := range []string{"sid", "iss", "aud", "iat", "exp"} {
+		delete(c.Extra, k)
+	}
+	if len(c.Extra) == 0 {
+		c.Extra = nil
+	}
+	return nil
+}
+
+// Options contains options for the JWT transport.
+type Options struct {
+	// Cookie is a set of options for the cookie to carry the token. The MaxAge
+	// defaults to TTL.
+	Cookie session.CookieOptions
+	// SigningKey is the key to sign tokens, which is a []byte for HS256, an
+	// *rsa.PrivateKey for RS256 or an *ecdsa.PrivateKey with the P-256 curve for
+	// ES256.
+	SigningKey interface{}
+	// KeyID is the "kid" header of signed tokens, which identifies the key in Keys
+	// to verify the tokens. Rotating keys is done by publishing the new key in
+	// Keys, then switching SigningKey and KeyID.
+	KeyID string
+	// Keys is the set of keys to verify tokens. Default is to verify with the
+	// SigningKey (or its public key).
+	Keys KeySet
+	// Issuer is the "iss" claim of signed tokens, which is also required when
+	// verifying tokens if set.
+	Issuer string
+	// Audience is the "aud" claim of signed tokens, which is also required when
+	// verifying tokens if set.
+	Audience string
+	// TTL is the duration that tokens are valid for. Tokens are reissued with a
+	// new expiry time when less than half of the TTL is left. It should be no
+	// longer than the lifetime of sessions. Default is 1 hour.
+	TTL time.Duration
+	// ClaimsFunc returns additional claims to be included in the token for the
+	// session with given ID. Keep the claims minimal as they are sent with every
+	// request. Default is no additional claims.
+	ClaimsFunc func(r *http.Request, sid string) map[string]interface{}
+
+	nowFunc func() time.Time // For tests only
+}
+
+// Transport is a session ID transport that carries session IDs in signed JWT.
+type Transport struct {
+	opts Options
+	alg  string
+}
+
+// New returns a new JWT transport with given options.
+func New(opts Options) (*Transport, error) {
+	alg, err := algorithm(opts.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("signing key: %w", err)
+	}
+
+	if opts.Keys == nil {
+		key := opts.SigningKey
+		if signer, ok := key.(crypto.Signer); ok {
+			key = signer.Public()
+		}
+		opts.Keys = StaticKeys(map[string]interface{}{opts.KeyID: key})
+	}
+	if opts.Cookie.Name == "" {
+		opts.Cookie.Name = "flamego_session"
+	}
+	if opts.Cookie.Path == "" {
+		opts.Cookie.Path = "/"
+	}
+	if opts.Cookie.SameSite < http.SameSiteDefaultMode || opts.Cookie.SameSite > http.SameSiteNoneMode {
+		opts.Cookie.SameSite = http.SameSiteLaxMode
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = time.Hour
+	}
+	if opts.Cookie.MaxAge == 0 {
+		opts.Cookie.MaxAge = int(opts.TTL.Seconds())
+	}
+	if opts.nowFunc == nil {
+		opts.nowFunc = time.Now
+	}
+
+	return &Transport{
+		opts: opts,
+		alg:  alg,
+	}, nil
+}
+
+// algorithm returns the signing algorithm for the key.
+func algorithm(key interface{}) (string, error) {
+	switch k := key.(type) {
+	case []byte:
+		if len(k) < 32 {
+			return "", errors.New("HMAC key must be at least 32 bytes")
+		}
+		return HS256, nil
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return RS256, nil
+	case *ecdsa.PrivateKey:
+		if k.Curve.Params().Name != "P-256" {
+			return "", errors.New("ECDSA key must use the P-256 curve")
+		}
+		return ES256, nil
+	case *ecdsa.PublicKey:
+		if k.Curve.Params().Name != "P-256" {
+			return "", errors.New("ECDSA key must use the P-256 curve")
+		}
+		return ES256, nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", key)
+}
+
+// Sign returns a signed token for the session with given ID.
+func (t *Transport) Sign(r *http.Request, sid string) (string, error) {
+	now := t.opts.nowFunc()
+	claims := Claims{
+		SessionID: sid,
+		Issuer:    t.opts.Issuer,
+		Audience:  t.opts.Audience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(t.opts.TTL).Unix(),
+	}
+	if t.opts.ClaimsFunc != nil {
+		claims.Extra = t.opts.ClaimsFunc(r, sid)
+	}
+
+	header := map[string]string{
+		"alg": t.alg,
+		"typ": "JWT",
+	}
+	if t.opts.KeyID != "" {
+		header["kid"] = t.opts.KeyID
+	}
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("marshal header: %w", err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("marshal claims: %w", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sig, err := sign(t.alg, t.opts.SigningKey, []byte(signingInput))
+	if err != nil {
+		return "", fmt.Errorf("sign: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Verify verifies the token and returns its claims.
+func (t *Transport) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	h, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err = json.Unmarshal(h, &header)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, err := t.opts.Keys.Key(header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("get key: %w", err)
+	}
+	// The algorithm is determined by the key rather than the token to prevent
+	// algorithm confusion attacks.
+	alg, err := algorithm(key)
+	if err != nil || alg != header.Alg {
+		return nil, ErrInvalidToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !verify(alg, key, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrInvalidToken
+	}
+
+	c, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	err = json.Unmarshal(c, &claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.SessionID == "" ||
+		(t.opts.Issuer != "" && claims.Issuer != t.opts.Issuer) ||
+		(t.opts.Audience != "" && claims.Audience != t.opts.Audience) {
+		return nil, ErrInvalidToken
+	}
+	if t.opts.nowFunc().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+	return &claims, nil
+}
+
+// ReadID reads the session ID from the token in the cookie, it returns an empty
+// string if the token is missing or cannot be verified. It is meant to be used
+// as session.Options.ReadIDFunc.
+func (t *Transport) ReadID(r *http.Request) string {
+	cookie, err := r.Cookie(t.opts.Cookie.Name)
+	if err != nil {
+		return ""
+	}
+
+	claims, err := t.Verify(cookie.Value)
+	if err != nil {
+		return ""
+	}
+	return claims.SessionID
+}
+
+// WriteID writes a token of the session ID to the cookie when the session is
+// created or the existing token is about to expire. It is meant to be used as
+// session.Options.WriteIDFunc.
+func (t *Transport) WriteID(w http.ResponseWriter, r *http.Request, sid string, created bool) {
+	if !created {
+		cookie, err := r.Cookie(t.opts.Cookie.Name)
+		if err == nil {
+			claims, err := t.Verify(cookie.Value)
+			if err == nil &&
+				claims.SessionID == sid &&
+				time.Unix(claims.ExpiresAt, 0).Sub(t.opts.nowFunc()) > t.opts.TTL/2 {
+				return
+			}
+		}
+	}
+
+	token, err := t.Sign(r, sid)
+	if err != nil {
+		panic("session: jwt: " + err.Error())
+	}
+
+	cookie := &http.Cookie{
+		Name:     t.opts.Cookie.Name,
+		Value:    token,
+		Path:     t.opts.Cookie.Path,
+		Domain:   t.opts.Cookie.Domain,
+		MaxAge:   t.opts.Cookie.MaxAge,
+		Secure:   t.opts.Cookie.Secure,
+		HttpOnly: t.opts.Cookie.HTTPOnly,
+		SameSite: t.opts.Cookie.SameSite,
+	}
+	http.SetCookie(w, cookie)
+	r.AddCookie(cookie)
+}
+
+// sign signs the input with the key using the algorithm.
+func sign(alg string, key interface{}, input []byte) ([]byte, error) {
+	switch alg {
+	case HS256:
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case RS256:
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("RS256 requires a private key")
+		}
+		sum := sha256.Sum256(input)
+		return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+	case ES256:
+		k, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("ES256 requires a private key")
+		}
+		sum := sha256.Sum256(input)
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			return nil, err
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+// verify returns true if the signature of the input matches with the key using
+// the algorithm.
+func verify(alg string, key interface{}, input, sig []byte) bool {
+	switch alg {
+	case HS256:
+		want, _ := sign(alg, key, input)
+		return hmac.Equal(want, sig)
+	case RS256:
+		var k *rsa.PublicKey
+		switch v := key.(type) {
+		case *rsa.PublicKey:
+			k = v
+		case *rsa.PrivateKey:
+			k = &v.PublicKey
+		}
+		sum := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) == nil
+	case ES256:
+		var k *ecdsa.PublicKey
+		switch v := key.(type) {
+		case *ecdsa.PublicKey:
+			k = v
+		case *ecdsa.PrivateKey:
+			k = &v.PublicKey
+		}
+		if len(sig) != 64 {
+			return false
+		}
+		sum := sha256.Sum256(input)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k, sum[:], r, s)
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package jwt
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+	"github.com/flamego/session"
+)
+
+func TestTransport(t *testing.T) {
+	now := time.Now()
+	tr, err := New(Options{
+		SigningKey: bytes.Repeat([]byte("k"), 32),
+		Issuer:     "flamego",
+		ClaimsFunc: func(r *http.Request, sid string) map[string]interface{} {
+			return map[string]interface{}{"tier": "gold"}
+		},
+		nowFunc: func() time.Time { return now },
+	})
+	require.NoError(t, err)
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(session.Sessioner(session.Options{
+		ReadIDFunc:  tr.ReadID,
+		WriteIDFunc: tr.WriteID,
+	}))
+	f.Get("/", func(s session.Session) string {
+		return s.ID()
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	sid := resp.Body.String()
+
+	cookie := resp.Result().Cookies()[0]
+	claims, err := tr.Verify(cookie.Value)
+	require.NoError(t, err)
+	assert.Equal(t, sid, claims.SessionID)
+	assert.Equal(t, "flamego", claims.Issuer)
+	assert.Equal(t, map[string]interface{}{"tier": "gold"}, claims.Extra)
+
+	// The same session is used with the token, and the token is not reissued
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	req.AddCookie(cookie)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, sid, resp.Body.String())
+	assert.Empty(t, resp.Header().Get("Set-Cookie"))
+
+	// The token is reissued when it is about to expire
+	now = now.Add(45 * time.Minute)
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	req.AddCookie(cookie)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, sid, resp.Body.String())
+	assert.NotEmpty(t, resp.Header().Get("Set-Cookie"))
+
+	// A new session is created with an expired token
+	now = now.Add(time.Hour)
+	_, err = tr.Verify(cookie.Value)
+	assert.Equal(t, ErrExpiredToken, err)
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	req.AddCookie(cookie)
+	f.ServeHTTP(resp, req)
+	assert.NotEqual(t, sid, resp.Body.String())
+}
+
+func TestTransport_Verify(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tr, err := New(Options{
+		SigningKey: key,
+		KeyID:      "1",
+	})
+	require.NoError(t, err)
+
+	token, err := tr.Sign(nil, "abc")
+	require.NoError(t, err)
+	claims, err := tr.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", claims.SessionID)
+
+	parts := strings.Split(token, ".")
+
+	// Tampered claims
+	c, err := json.Marshal(map[string]interface{}{"sid": "xyz", "exp": time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	_, err = tr.Verify(parts[0] + "." + base64.RawURLEncoding.EncodeToString(c) + "." + parts[2])
+	assert.Equal(t, ErrInvalidToken, err)
+
+	// Algorithm confusion
+	h, err := json.Marshal(map[string]string{"alg": "none", "kid": "1"})
+	require.NoError(t, err)
+	_, err = tr.Verify(base64.RawURLEncoding.EncodeToString(h) + "." + parts[1] + ".")
+	assert.Equal(t, ErrInvalidToken, err)
+
+	_, err = tr.Verify("not-a-token")
+	assert.Equal(t, ErrInvalidToken, err)
+}
+
+func TestJWKS(t *testing.T) {
+	key1, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	key2, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	jwkOf := func(kid string, key *ecdsa.PrivateKey) map[string]string {
+		return map[string]string{
+			"kty": "EC",
+			"kid": kid,
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}
+	}
+	published := []map[string]string{jwkOf("1", key1)}
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": published})
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	keys := NewJWKS(srv.URL, time.Hour)
+	keys.nowFunc = func() time.Time { return now }
+
+	tr1, err := New(Options{SigningKey: key1, KeyID: "1", Keys: keys})
+	require.NoError(t, err)
+	token1, err := tr1.Sign(nil, "abc")
+	require.NoError(t, err)
+	_, err = tr1.Verify(token1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, fetches)
+
+	// Rotate to the new key, the JWKS is refetched for the unknown key ID
+	published = append(published, jwkOf("2", key2))
+	tr2, err := New(Options{SigningKey: key2, KeyID: "2", Keys: keys})
+	require.NoError(t, err)
+	token2, err := tr2.Sign(nil, "abc")
+	require.NoError(t, err)
+
+	now = now.Add(2 * time.Minute)
+	_, err = tr2.Verify(token2)
+	require.NoError(t, err)
+	_, err = tr2.Verify(token1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, fetches)
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/flamego/flamego"
+)
+
+// KeepAliveResponse is the response body of the handler returned by the
+// KeepAliveHandler.
+type KeepAliveResponse struct {
+	// ExpiresIn is the number of seconds remaining before the session expires. It
+	// is nil if the session store does not implement session.Expirer.
+	ExpiresIn *int `json:"expires_in"`
+}
+
+// KeepAliveHandler returns a handler that extends the expiry time of the
+// current session and responds with the remaining time before the session
+// expires as JSON (see KeepAliveResponse), which allows frontends to keep
+// sessions alive without writing a route for it. It must be used after the
+// session.Sessioner. Errors of the session store are reported via
+// Options.ErrorFunc and respond with a generic error.
+//
+// Example:
+//
+//	f.Post("/session/keep-alive", session.KeepAliveHandler())
+func KeepAliveHandler() flamego.Handler {
+	return func(c flamego.Context, s Session, store Store) {
+		w := c.ResponseWriter()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		fail := func(err error) {
+			if state := requestStateOf(c); state != nil && state.reportError != nil {
+				state.reportError(fmt.Errorf("keep alive: %w", err))
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "internal error"})
+		}
+
+		var resp KeepAliveResponse
+		if IsStarted(s) {
+			ctx := c.Request().Context()
+			err := store.Touch(ctx, s.ID())
+			if err != nil {
+				fail(fmt.Errorf("touch: %w", err))
+				return
+			}
+
+			if expirer, ok := StoreAs[Expirer](store); ok {
+				expiresAt, err := expirer.ExpiresAt(ctx, s.ID())
+				if err != nil {
+					fail(fmt.Errorf("get expiry time: %w", err))
+					return
+				}
+
+				expiresIn := 0
+				if now := nowOf(s); !expiresAt.IsZero() && now.Before(expiresAt) {
+					expiresIn = int(expiresAt.Sub(now).Seconds())
+				}
+				resp.ExpiresIn = &expiresIn
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// KeepAlive touches the session with given ID in the session store every
+// interval until the context is done, which keeps sessions of long-lived
+// connections (e.g. WebSocket) alive as they never hit the session.Sessioner
+// again after being upgraded. The interval should be shorter than the lifetime
+// of sessions. It blocks and returns nil once the context is done, or the
+// error if the session store fails to touch the session.
+//
+// Example:
+//
+//	f.Get("/ws", func(c flamego.Context, s session.Session, store session.Store) {
+//		conn, err := upgrader.Upgrade(c.ResponseWriter(), c.Request().Request, nil)
+//		if err != nil {
+//			return
+//		}
+//		defer conn.Close()
+//
+//		ctx, cancel := context.WithCancel(c.Request().Context())
+//		defer cancel()
+//		go func() {
+//			err := session.KeepAlive(ctx, store, s.ID(), 10*time.Minute)
+//			if err != nil {
+//				log.Printf("Failed to keep session alive: %v", err)
+//			}
+//		}()
+//
+//		// Serve the connection until it is closed...
+//	})
+func KeepAlive(ctx context.Context, store Store, sid string, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("non-positive interval %v", interval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := store.Touch(ctx, sid)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("touch: %w", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestKeepAliveHandler(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner())
+	f.Post("/keep-alive", KeepAliveHandler())
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/keep-alive", nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/json; charset=utf-8", resp.Header().Get("Content-Type"))
+
+	var got KeepAliveResponse
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+	require.NotNil(t, got.ExpiresIn)
+	assert.InDelta(t, 3600, *got.ExpiresIn, 1)
+}
+
+// touchFailingStore is a session store that fails to touch sessions.
+type touchFailingStore struct {
+	Store
+}
+
+func (*touchFailingStore) Touch(context.Context, string) error {
+	return errors.New("unreachable")
+}
+
+func TestKeepAliveHandler_Error(t *testing.T) {
+	var errs []string
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			StoreWrappers: []StoreMiddleware{
+				func(store Store) Store { return &touchFailingStore{Store: store} },
+			},
+			ErrorFunc: func(err error) { errs = append(errs, err.Error()) },
+		},
+	))
+	// Changing the session makes it saved rather than touched at the end
+	f.Post("/keep-alive", func(s Session) { s.Set("name", "flamego") }, KeepAliveHandler())
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/keep-alive", nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	assert.JSONEq(t, `{"error":"internal error"}`, resp.Body.String())
+	assert.Contains(t, errs, "keep alive: touch: unreachable")
+}
+
+// touchCountingStore is a session store that counts Touch calls.
+type touchCountingStore struct {
+	noopStore
+	touches atomic.Int32
+	err     error
+}
+
+func (s *touchCountingStore) Touch(context.Context, string) error {
+	s.touches.Add(1)
+	return s.err
+}
+
+func TestKeepAlive(t *testing.T) {
+	t.Run("touch until done", func(t *testing.T) {
+		store := &touchCountingStore{}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		err := KeepAlive(ctx, store, "1", 10*time.Millisecond)
+		assert.NoError(t, err)
+		assert.Greater(t, store.touches.Load(), int32(3))
+	})
+
+	t.Run("touch error", func(t *testing.T) {
+		store := &touchCountingStore{err: errors.New("unreachable")}
+		err := KeepAlive(context.Background(), store, "1", 10*time.Millisecond)
+		assert.EqualError(t, err, "touch: unreachable")
+		assert.Equal(t, int32(1), store.touches.Load())
+	})
+
+	t.Run("invalid interval", func(t *testing.T) {
+		err := KeepAlive(context.Background(), &touchCountingStore{}, "1", 0)
+		assert.Error(t, err)
+	})
+}
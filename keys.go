@@ -0,0 +1,275 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultKeyNamespace is the default namespace of session keys that are
+// reserved for internal use, see session.SetKeyNamespace.
+const DefaultKeyNamespace = "flamego::session::"
+
+var (
+	// keyNamespace is the namespace of session keys that are reserved for
+	// internal use.
+	keyNamespace string
+	// keyNamespaceOnce guards the namespace from being changed more than once or
+	// after any session.Sessioner is created.
+	keyNamespaceOnce sync.Once
+)
+
+// Session keys that are reserved for internal use, all of which are under the
+// keyNamespace.
+var (
+	// flashKey is the session key to store the flash of the session when there
+	// is no flash store.
+	flashKey string
+	// expiriesKey is the key in the session data for expiry times (in Unix
+	// nanoseconds) of keys set via SetWithTTL.
+	expiriesKey string
+	// staleKey is the key in the session data for marking the session data as
+	// decoded with outdated keys by decoders, which is removed once the session is
+	// created with the session data.
+	staleKey string
+	// authKey is the session key to store the authentication state of the
+	// session, which is a nested Data with the "user_id" and the "signed_in_at"
+	// (in Unix nanoseconds). Impersonating sessions also have the "impersonators"
+	// (the stack of original user IDs) and the "impersonated_at" (in Unix
+	// nanoseconds).
+	authKey string
+	// infoKey is the session key to store the information of the session that is
+	// captured by the middleware, see SessionInfo.
+	infoKey string
+	// displacedKey is the session key to flag the session that is displaced by
+	// newer sessions of the same user.
+	displacedKey string
+	// extendedKey is the session key to store the time (in Unix nanoseconds) of
+	// the last extension of the session expiry when Options.TouchThreshold is
+	// set.
+	extendedKey string
+	// rotatedKey is the session key to store the time (in Unix nanoseconds) of
+	// the last rotation of the session ID when Options.RotateIDAfter is set.
+	rotatedKey string
+	// lockedKey is the session key to store the time (in Unix nanoseconds) when
+	// the session was locked due to Options.IdleLockAfter.
+	lockedKey string
+	// localeKey is the session key to store the preferred locale of the session
+	// as a canonical BCP 47 language tag.
+	localeKey string
+	// timeZoneKey is the session key to store the preferred time zone of the
+	// session as an IANA time zone name.
+	timeZoneKey string
+	// variantsKey is the session key to store the assigned variants of
+	// experiments (see session.Variant), which is a nested Data of experiment
+	// names to variant names.
+	variantsKey string
+	// draftKeyPrefix is the prefix of session keys to store drafts of forms (see
+	// session.DraftOf), each of which is a nested Data with the "values" and the
+	// "saved_at" (in Unix nanoseconds).
+	draftKeyPrefix string
+	// scopeKeyPrefix is the prefix of session keys to store the data of scopes
+	// (see session.Scope), each of which is a nested Data.
+	scopeKeyPrefix string
+)
+
+// bookkeepingKeys returns the session keys of internal bookkeeping, which are
+// states of the session rather than its data, thus always kept across Flush
+// regardless of the preserved keys, e.g. the creation time that
+// Options.AbsoluteTimeout is based on and the idle lock.
+func bookkeepingKeys() []interface{} {
+	return []interface{}{infoKey, authKey, extendedKey, rotatedKey, lockedKey}
+}
+
+func init() {
+	applyKeyNamespace(DefaultKeyNamespace)
+}
+
+// SetKeyNamespace sets the namespace of session keys that are reserved for
+// internal use, e.g. the flash and the authentication state, which avoids
+// collisions with keys of the application or other libraries sharing the same
+// session data. Writing keys under the namespace via Session.Set,
+// session.SetWithTTL or Session.Delete panics with session.ErrReservedKey.
+//
+// It is init-only: it must be called at most once and before any
+// session.Sessioner is created, typically in the init function of the main
+// package, and applies to all session stores. Existing sessions keep their data
+// under the old namespace, which is not recognized until migrated via
+// session.MigrateKeyNamespace, except that flashes under the
+// DefaultKeyNamespace are still consumed. It panics if the namespace is empty,
+// or if it is called again or after any session.Sessioner is created.
+func SetKeyNamespace(namespace string) {
+	if namespace == "" {
+		panic("session: empty key namespace")
+	}
+
+	applied := false
+	keyNamespaceOnce.Do(func() {
+		applyKeyNamespace(namespace)
+		applied = true
+	})
+	if !applied {
+		panic("session: key namespace must be set at most once and before any session.Sessioner is created")
+	}
+}
+
+// freezeKeyNamespace prevents the namespace from being changed afterwards.
+func freezeKeyNamespace() {
+	keyNamespaceOnce.Do(func() {})
+}
+
+// applyKeyNamespace derives the session keys that are reserved for internal use
+// from the namespace.
+func applyKeyNamespace(namespace string) {
+	keyNamespace = namespace
+	flashKey = namespace + "flash"
+	expiriesKey = namespace + "expiries"
+	staleKey = namespace + "stale"
+	authKey = namespace + "auth"
+	infoKey = namespace + "info"
+	displacedKey = namespace + "displaced"
+	extendedKey = namespace + "extended_at"
+	rotatedKey = namespace + "rotated_at"
+	lockedKey = namespace + "locked_at"
+	localeKey = namespace + "locale"
+	timeZoneKey = namespace + "time_zone"
+	variantsKey = namespace + "variants"
+	draftKeyPrefix = namespace + "draft::"
+	scopeKeyPrefix = namespace + "scope::"
+}
+
+// ErrReservedKey is the panic value when writing a session key that is reserved
+// for internal use, see session.SetKeyNamespace.
+var ErrReservedKey = errors.New("the session key is reserved for internal use")
+
+// isReservedKey returns true if the session key is under the namespace that is
+// reserved for internal use.
+func isReservedKey(key interface{}) bool {
+	k, ok := key.(string)
+	return ok && strings.HasPrefix(k, keyNamespace)
+}
+
+// checkKey panics if the session key is reserved for internal use.
+func checkKey(key interface{}) {
+	if isReservedKey(key) {
+		panic(fmt.Errorf("session: %q: %w", key, ErrReservedKey))
+	}
+}
+
+// internalWriter is a session that is capable of writing session keys that are
+// reserved for internal use.
+type internalWriter interface {
+	// setInternal sets the value of given key regardless of whether the key is
+	// reserved, the value expires at given time unless it is zero.
+	setInternal(key, val interface{}, expiresAt time.Time)
+	// deleteInternal deletes given key regardless of whether the key is reserved.
+	deleteInternal(key interface{})
+}
+
+// setInternal sets the value of given key in the session, which may be reserved
+// for internal use.
+func setInternal(s Session, key, val interface{}) {
+	if w, ok := s.(internalWriter); ok {
+		w.setInternal(key, val, time.Time{})
+		return
+	}
+	s.Set(key, val)
+}
+
+// setInternalWithTTL is like setInternal but the value expires after the TTL.
+func setInternalWithTTL(s Session, key, val interface{}, ttl time.Duration) {
+	if w, ok := s.(internalWriter); ok {
+		w.setInternal(key, val, nowOf(s).Add(ttl))
+		return
+	}
+	s.Set(key, val)
+}
+
+// SetWithTTL sets the value of given key in the session that expires after the
+// given duration, regardless of the lifetime of the session. Setting the key
+// again with Session.Set makes the value persistent. Sessions that are not
+// capable of expiring keys keep the value until it is deleted.
+func SetWithTTL(s Session, key, val interface{}, ttl time.Duration) {
+	checkKey(key)
+	setInternalWithTTL(s, key, val, ttl)
+}
+
+// deleteInternal deletes given key from the session, which may be reserved for
+// internal use.
+func deleteInternal(s Session, key interface{}) {
+	if w, ok := s.(internalWriter); ok {
+		w.deleteInternal(key)
+		return
+	}
+	s.Delete(key)
+}
+
+// MigrateKeyNamespace moves session keys under the old namespace to the current
+// namespace (see session.SetKeyNamespace) in the session, e.g. in a handler
+// after the session.Sessioner. Expiry times of keys set via SetWithTTL are
+// carried over. Keys that already exist under the current namespace are not
+// overwritten, and the conflicting keys are left under the old namespace along
+// with their expiry times. It returns the number of keys that are moved.
+// Sessions that do not expose their data are left untouched.
+func MigrateKeyNamespace(s Session, oldNamespace string) int {
+	if oldNamespace == "" || oldNamespace == keyNamespace {
+		return 0
+	}
+	ds, ok := s.(interface{ Data() Data })
+	if !ok {
+		return 0
+	}
+
+	data := ds.Data()
+	oldExpiriesKey := oldNamespace + "expiries"
+	oldExpiries, _ := data[oldExpiriesKey].(Data)
+	now := nowOf(s)
+	moved := 0
+	// The expiry times of keys that are left under the old namespace
+	leftExpiries := make(Data)
+	for key, val := range data {
+		k, _ := key.(string)
+		// Keys under the current namespace may also be under the old namespace when
+		// one namespace is a prefix of the other.
+		old := strings.HasPrefix(k, oldNamespace) && !isReservedKey(k)
+		expiresAt, expiring := oldExpiries[key].(int64)
+		if key == oldExpiriesKey || (!old && !expiring) {
+			continue
+		}
+
+		newKey := key
+		if old {
+			newKey = keyNamespace + strings.TrimPrefix(k, oldNamespace)
+			if _, ok := data[newKey]; ok {
+				if expiring {
+					leftExpiries[key] = expiresAt
+				}
+				continue
+			}
+			deleteInternal(s, key)
+			moved++
+		}
+
+		switch {
+		case !expiring:
+			setInternal(s, newKey, val)
+		case expiresAt > now.UnixNano():
+			setInternalWithTTL(s, newKey, val, time.Unix(0, expiresAt).Sub(now))
+		default:
+			deleteInternal(s, newKey)
+		}
+	}
+	switch {
+	case len(leftExpiries) > 0:
+		setInternal(s, oldExpiriesKey, leftExpiries)
+	case oldExpiries != nil:
+		deleteInternal(s, oldExpiriesKey)
+	}
+	return moved
+}
@@ -0,0 +1,171 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+// setKeyNamespace sets the key namespace for the test, and restores the default
+// namespace when the test finishes.
+func setKeyNamespace(t *testing.T, namespace string) {
+	keyNamespaceOnce = sync.Once{}
+	SetKeyNamespace(namespace)
+	t.Cleanup(func() {
+		applyKeyNamespace(DefaultKeyNamespace)
+		keyNamespaceOnce = sync.Once{}
+	})
+}
+
+func TestReservedKeys(t *testing.T) {
+	assertReserved := func(t *testing.T, f func()) {
+		defer func() {
+			err, _ := recover().(error)
+			assert.True(t, errors.Is(err, ErrReservedKey), "want ErrReservedKey but got %v", err)
+		}()
+		f()
+	}
+
+	s := NewBaseSession("1", GobEncoder, nil)
+	for name, sess := range map[string]Session{
+		"base":   s,
+		"scoped": Scope(s, "plugin"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			assertReserved(t, func() { sess.Set(authKey, "alice") })
+			assertReserved(t, func() { SetWithTTL(sess, flashKey, "hello", time.Minute) })
+			assertReserved(t, func() { sess.Delete(infoKey) })
+
+			// Internal writes are allowed
+			setInternal(sess, authKey, Data{"user_id": "alice"})
+			assert.Equal(t, Data{"user_id": "alice"}, sess.Get(authKey))
+			deleteInternal(sess, authKey)
+			assert.Nil(t, sess.Get(authKey))
+		})
+	}
+
+	t.Run("custom namespace", func(t *testing.T) {
+		setKeyNamespace(t, "app::internal::")
+		assert.Equal(t, "app::internal::auth", authKey)
+
+		s := NewBaseSession("1", GobEncoder, nil)
+		assertReserved(t, func() { s.Set("app::internal::auth", "alice") })
+
+		// Keys under the default namespace belong to the application
+		s.Set(DefaultKeyNamespace+"auth", "alice")
+		assert.Equal(t, "alice", s.Get(DefaultKeyNamespace+"auth"))
+	})
+
+	t.Run("empty namespace", func(t *testing.T) {
+		assert.Panics(t, func() { SetKeyNamespace("") })
+	})
+
+	t.Run("init-only", func(t *testing.T) {
+		setKeyNamespace(t, "app::internal::")
+		assert.Panics(t, func() { SetKeyNamespace("app::other::") })
+		assert.Equal(t, "app::internal::auth", authKey)
+
+		keyNamespaceOnce = sync.Once{}
+		Sessioner()
+		assert.Panics(t, func() { SetKeyNamespace("app::other::") })
+		assert.Equal(t, "app::internal::auth", authKey)
+	})
+}
+
+func TestSessioner_LegacyFlash(t *testing.T) {
+	setKeyNamespace(t, "app::internal::")
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(Options{GCMode: GCDisabled}))
+	f.Get("/legacy", func(s Session) {
+		// Simulate the flash that was set before changing the namespace
+		s.Set(DefaultKeyNamespace+"flash", "Welcome back!")
+	})
+	f.Get("/set", func(s Session) {
+		s.SetFlash("Hello world!")
+	})
+	f.Get("/get", func(flash Flash) string {
+		return fmt.Sprintf("%v", flash)
+	})
+
+	var cookie string
+	request := func(path string) string {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if c := resp.Header().Get("Set-Cookie"); c != "" {
+			cookie = c
+		}
+		return resp.Body.String()
+	}
+
+	request("/legacy")
+	assert.Equal(t, "Welcome back!", request("/get"))
+	assert.NotContains(t, request("/get"), "Welcome back!")
+
+	request("/set")
+	assert.Equal(t, "Hello world!", request("/get"))
+	assert.NotContains(t, request("/get"), "Hello world!")
+}
+
+func TestMigrateKeyNamespace(t *testing.T) {
+	setKeyNamespace(t, "app::internal::")
+
+	s := NewBaseSessionWithData("1", GobEncoder, nil, Data{
+		DefaultKeyNamespace + "auth":   Data{"user_id": "alice"},
+		DefaultKeyNamespace + "locale": "en-US",
+		"app::internal::locale":        "fr-FR",
+		"username":                     "alice",
+		"cart":                         "books",
+		DefaultKeyNamespace + "expiries": Data{
+			DefaultKeyNamespace + "draft::signup": time.Now().Add(time.Hour).UnixNano(),
+			DefaultKeyNamespace + "locale":        time.Now().Add(time.Hour).UnixNano(),
+			"cart":                                time.Now().Add(time.Hour).UnixNano(),
+		},
+		DefaultKeyNamespace + "draft::signup": Data{"values": Data{"name": "alice"}},
+	})
+
+	assert.Equal(t, 2, MigrateKeyNamespace(s, DefaultKeyNamespace))
+	assert.Equal(t, "alice", currentUser(s))
+	// Existing keys under the current namespace are not overwritten, and the
+	// conflicting keys are left under the old namespace
+	assert.Equal(t, "fr-FR", LocaleOf(s))
+	assert.Equal(t, "en-US", s.Get(DefaultKeyNamespace+"locale"))
+	assert.Equal(t, "alice", s.Get("username"))
+	assert.Equal(t, "books", s.Get("cart"))
+
+	data := s.Data()
+	for key := range data {
+		k, _ := key.(string)
+		if k != DefaultKeyNamespace+"locale" && k != DefaultKeyNamespace+"expiries" {
+			assert.NotContains(t, k, DefaultKeyNamespace)
+		}
+	}
+	expiries, _ := data[expiriesKey].(Data)
+	assert.Contains(t, expiries, "app::internal::draft::signup")
+	assert.Contains(t, expiries, "cart")
+	leftExpiries, _ := data[DefaultKeyNamespace+"expiries"].(Data)
+	assert.Len(t, leftExpiries, 1)
+	assert.Contains(t, leftExpiries, DefaultKeyNamespace+"locale")
+
+	// Nothing to migrate once migrated
+	assert.Zero(t, MigrateKeyNamespace(s, DefaultKeyNamespace))
+}
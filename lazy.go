@@ -0,0 +1,423 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var _ Session = (*lazySession)(nil)
+
+// lazySession is a session that is only read from the session store once it is
+// first written to. It is used for visitors without an existing session when
+// auto-creation of sessions is disabled.
+type lazySession struct {
+	ctx     context.Context                                        // The context to be used for reading the session
+	read    func(ctx context.Context, sid string) (Session, error) // The function to read the session from the session store
+	onStart func(sid string, created bool)                         // The function to be called once the session is started, without holding the lock
+
+	lock     sync.RWMutex    // The mutex to guard accesses to the fields below
+	sid      string          // The session ID
+	created  bool            // Whether the session ID is new to the client, i.e. it needs to be written to the client
+	sess     Session         // The underlying session, nil until started
+	auditing bool            // Whether to journal changes made to the session data once started
+	rc       *requestContext // The state of the request to be set to the underlying session once started, may be nil
+}
+
+// newLazySession returns a new lazy session with given session ID, and whether
+// the session ID is new to the client.
+func newLazySession(ctx context.Context, read func(ctx context.Context, sid string) (Session, error), sid string, created bool, onStart func(sid string, created bool)) *lazySession {
+	return &lazySession{
+		ctx:     ctx,
+		read:    read,
+		onStart: onStart,
+		sid:     sid,
+		created: created,
+	}
+}
+
+// started returns the underlying session and whether the session has been
+// started.
+func (s *lazySession) started() (Session, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.sess, s.sess != nil
+}
+
+// start reads the session from the session store if it has not been started.
+// The onStart is called after the lock is released, as it may access the
+// session.
+func (s *lazySession) start() (Session, error) {
+	sess, started, err := s.startLocked()
+	if err != nil {
+		return nil, err
+	}
+	if started {
+		s.lock.RLock()
+		sid, created := s.sid, s.created
+		s.lock.RUnlock()
+		s.onStart(sid, created)
+	}
+	return sess, nil
+}
+
+// startLocked is like start but does not call the onStart. It also returns
+// whether the session is started by this call, in which case the onStart
+// should be called.
+func (s *lazySession) startLocked() (_ Session, started bool, _ error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.sess != nil {
+		return s.sess, false, nil
+	}
+
+	sess, err := s.read(s.ctx, s.sid)
+	if err != nil {
+		return nil, false, fmt.Errorf("read: %w", err)
+	}
+	sess = viewOf(sess)
+	if a, ok := sess.(auditor); ok && s.auditing {
+		a.startAudit()
+	}
+	s.sess = sess
+	setRequestContext(sess, s.rc)
+	if _, ok := sess.(*ephemeralSession); ok {
+		return sess, false, nil
+	}
+	return sess, true, nil
+}
+
+// mustStart is like start but panics if the session could not be started.
+func (s *lazySession) mustStart() Session {
+	sess, err := s.start()
+	if err != nil {
+		panic("session: start: " + err.Error())
+	}
+	return sess
+}
+
+func (s *lazySession) ID() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.sess != nil {
+		return s.sess.ID()
+	}
+	return s.sid
+}
+
+func (s *lazySession) RegenerateID(w http.ResponseWriter, r *http.Request) error {
+	if sess, ok := s.started(); ok {
+		return sess.RegenerateID(w, r)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Nothing has been sent to the client yet, it is sufficient to only swap the
+	// session ID that will be used once the session is started. The new session
+	// ID must be written to the client once started, even if the old one came
+	// from the client.
+	var newID func() (string, error)
+	if s.rc != nil {
+		newID = s.rc.newID
+	}
+	if newID == nil {
+		newID = func() (string, error) { return randomChars(len(s.sid)) }
+	}
+	sid, err := newID()
+	if err != nil {
+		return fmt.Errorf("new ID: %w", err)
+	}
+	s.sid = sid
+	s.created = true
+	return nil
+}
+
+func (s *lazySession) Get(key interface{}) interface{} {
+	if sess, ok := s.started(); ok {
+		return sess.Get(key)
+	}
+	return nil
+}
+
+func (s *lazySession) Set(key, val interface{}) {
+	s.mustStart().Set(key, val)
+}
+
+var _ internalWriter = (*lazySession)(nil)
+
+func (s *lazySession) setInternal(key, val interface{}, expiresAt time.Time) {
+	sess := s.mustStart()
+	if w, ok := sess.(internalWriter); ok {
+		w.setInternal(key, val, expiresAt)
+	} else {
+		sess.Set(key, val)
+	}
+}
+
+func (s *lazySession) deleteInternal(key interface{}) {
+	if sess, ok := s.started(); ok {
+		deleteInternal(sess, key)
+	}
+}
+
+// SetFlash writes the flash to the flash store without starting the session
+// if there is one, as flashes in the flash store do not belong to the session
+// data.
+func (s *lazySession) SetFlash(val interface{}) {
+	s.lock.RLock()
+	rc := s.rc
+	s.lock.RUnlock()
+	if rc != nil && rc.writeFlash != nil {
+		rc.writeFlash(val)
+		return
+	}
+	s.mustStart().SetFlash(val)
+}
+
+var _ contextualizer = (*lazySession)(nil)
+
+// setRequestContext sets the state of the request, which is set to the
+// underlying session once started.
+func (s *lazySession) setRequestContext(rc *requestContext) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.rc = rc
+	if s.sess != nil {
+		setRequestContext(s.sess, rc)
+	}
+}
+
+func (s *lazySession) currentTime() time.Time {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.rc != nil && s.rc.now != nil {
+		return s.rc.now()
+	}
+	return time.Now()
+}
+
+var _ counter = (*lazySession)(nil)
+
+func (s *lazySession) incr(key string, delta int64) (int64, error) {
+	if sess, ok := s.started(); ok {
+		return Incr(sess, key, delta)
+	} else if delta == 0 {
+		return 0, nil
+	}
+
+	sess, err := s.start()
+	if err != nil {
+		return 0, fmt.Errorf("start: %w", err)
+	}
+	return Incr(sess, key, delta)
+}
+
+var _ listKeeper = (*lazySession)(nil)
+
+func (s *lazySession) listAppend(key string, val interface{}) error {
+	return ListAppend(s.mustStart(), key, val)
+}
+
+func (s *lazySession) listRemove(key string, val interface{}) (int, error) {
+	if sess, ok := s.started(); ok {
+		return ListRemove(sess, key, val)
+	}
+	return 0, nil
+}
+
+func (s *lazySession) listAll(key string) ([]interface{}, error) {
+	if sess, ok := s.started(); ok {
+		return ListAll(sess, key)
+	}
+	return []interface{}{}, nil
+}
+
+var _ blobber = (*lazySession)(nil)
+
+func (s *lazySession) putBlob(r io.Reader) (blobRef, error) {
+	b, ok := s.mustStart().(blobber)
+	if !ok {
+		return blobRef{}, ErrNoBlobStore
+	}
+	return b.putBlob(r)
+}
+
+func (s *lazySession) readBlob(ref blobRef) ([]byte, error) {
+	sess, _ := s.started()
+	b, ok := sess.(blobber)
+	if !ok {
+		return nil, ErrNoBlobStore
+	}
+	return b.readBlob(ref)
+}
+
+func (s *lazySession) getBlob(key interface{}) ([]byte, error) {
+	sess, ok := s.started()
+	if !ok {
+		return nil, nil
+	}
+	b, ok := sess.(blobber)
+	if !ok {
+		return nil, ErrNoBlobStore
+	}
+	return b.getBlob(key)
+}
+
+var _ exposer = (*lazySession)(nil)
+
+func (s *lazySession) expose(experiment, variant string) {
+	sess, _ := s.started()
+	if e, ok := sess.(exposer); ok {
+		e.expose(experiment, variant)
+	}
+}
+
+func (s *lazySession) Delete(key interface{}) {
+	if sess, ok := s.started(); ok {
+		sess.Delete(key)
+	}
+}
+
+func (s *lazySession) Flush() {
+	if sess, ok := s.started(); ok {
+		sess.Flush()
+	}
+}
+
+// Data returns a shallow copy of the session data, it is always empty for
+// sessions that have not been started.
+func (s *lazySession) Data() Data {
+	sess, ok := s.started()
+	if !ok {
+		return make(Data)
+	}
+	if ds, ok := sess.(interface{ Data() Data }); ok {
+		return ds.Data()
+	}
+	return make(Data)
+}
+
+func (s *lazySession) bind(typ reflect.Type) reflect.Value {
+	sess := s.mustStart()
+	b, ok := sess.(binder)
+	if !ok {
+		panic(fmt.Sprintf("session: bind: session with the type %T does not support binding", sess))
+	}
+	return b.bind(typ)
+}
+
+func (s *lazySession) unbind() {
+	sess, ok := s.started()
+	if !ok {
+		return
+	}
+	if b, ok := sess.(binder); ok {
+		b.unbind()
+	}
+}
+
+func (s *lazySession) startAudit() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.auditing = true
+	if a, ok := s.sess.(auditor); ok {
+		a.startAudit()
+	}
+}
+
+func (s *lazySession) takeJournal() []journalEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.auditing = false
+	if a, ok := s.sess.(auditor); ok {
+		return a.takeJournal()
+	}
+	return nil
+}
+
+func (s *lazySession) Encode() ([]byte, error) {
+	sess, ok := s.started()
+	if !ok {
+		return nil, ErrNotStarted
+	}
+	return sess.Encode()
+}
+
+var _ tagger = (*lazySession)(nil)
+
+// Tag sets the tag of given key to be the value, see session.Tag.
+func (s *lazySession) Tag(key, value string) {
+	Tag(s.mustStart(), key, value)
+}
+
+// Tags returns a copy of all tags of the session.
+func (s *lazySession) Tags() map[string]string {
+	if sess, ok := s.started(); ok {
+		return TagsOf(sess)
+	}
+	return make(map[string]string)
+}
+
+var _ impersonatable = (*lazySession)(nil)
+
+func (s *lazySession) impersonate(targetUserID string) error {
+	if sess, ok := s.started(); ok {
+		return Impersonate(sess, targetUserID)
+	}
+	return ErrNotSignedIn
+}
+
+func (s *lazySession) stopImpersonation() error {
+	if sess, ok := s.started(); ok {
+		return StopImpersonation(sess)
+	}
+	return ErrNotImpersonating
+}
+
+func (s *lazySession) HasChanged() bool {
+	sess, ok := s.started()
+	return ok && sess.HasChanged()
+}
+
+// ErrNotStarted is returned when operating on a session that has not been
+// started while auto-creation of sessions is disabled.
+var ErrNotStarted = errors.New("session has not been started")
+
+// Start starts the session if it has been deferred because auto-creation of
+// sessions is disabled, which reads the session from the session store and
+// writes the session ID to the client. It is a no-op for sessions that have
+// already been started. Writing to a deferred session starts it implicitly.
+func Start(s Session) error {
+	ls, ok := s.(*lazySession)
+	if !ok {
+		return nil
+	}
+	_, err := ls.start()
+	return err
+}
+
+// IsStarted returns true if the session has been started, i.e. it exists in
+// the session store or it has been written to.
+func IsStarted(s Session) bool {
+	ls, ok := s.(*lazySession)
+	if !ok {
+		return true
+	}
+	_, ok = ls.started()
+	return ok
+}
@@ -0,0 +1,176 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CreationLimiter is a rate limiter of creating new sessions, keyed by the
+// remote IP address of requests.
+type CreationLimiter interface {
+	// Allow consumes a token of given key and returns true if a new session is
+	// allowed to be created.
+	Allow(ctx context.Context, key string) (bool, error)
+}
+
+var _ CreationLimiter = (*MemoryCreationLimiter)(nil)
+
+// MemoryCreationLimiter is an in-memory implementation of the creation limiter
+// with a token bucket per key. It is only suitable for a single instance, use a
+// limiter backed by a shared storage (e.g. redis.NewCreationLimiter) when
+// running multiple instances.
+type MemoryCreationLimiter struct {
+	rate    float64          // The number of tokens refilled per second
+	burst   float64          // The capacity of each bucket
+	nowFunc func() time.Time // The function to return the current time
+
+	lock     sync.Mutex              // The mutex to guard accesses to the fields below
+	buckets  map[string]*tokenBucket // The token buckets indexed by keys
+	prunedAt time.Time               // The last time of pruning full buckets
+}
+
+// tokenBucket is a token bucket of a key.
+type tokenBucket struct {
+	tokens    float64   // The number of tokens as of the update time
+	updatedAt time.Time // The last time of updating the tokens
+}
+
+// NewMemoryCreationLimiter returns a new in-memory creation limiter that allows
+// creating `rate` sessions per second for each key with bursts of at most
+// `burst` sessions.
+func NewMemoryCreationLimiter(rate float64, burst int) *MemoryCreationLimiter {
+	return &MemoryCreationLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		nowFunc: time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow implements `CreationLimiter.Allow`.
+func (l *MemoryCreationLimiter) Allow(_ context.Context, key string) (bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.nowFunc()
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{
+			tokens:    l.burst,
+			updatedAt: now,
+		}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updatedAt).Seconds()*l.rate)
+	b.updatedAt = now
+	if b.tokens < 1 {
+		return false, nil
+	}
+	b.tokens--
+	return true, nil
+}
+
+// prune deletes buckets that would have been refilled to full, which are
+// indistinguishable from new buckets, at most once per minute. It is not
+// concurrent-safe and is the caller's responsibility to ensure the lock is
+// held.
+func (l *MemoryCreationLimiter) prune(now time.Time) {
+	if now.Sub(l.prunedAt) < time.Minute {
+		return
+	}
+	l.prunedAt = now
+
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updatedAt).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// remoteIP returns the IP address of the remote of the request.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+var _ Session = (*ephemeralSession)(nil)
+
+// ephemeralSession is a session that lives within a single request, which is
+// neither persisted to the session store nor written to the client. It is
+// served in place of new sessions refused by the creation limiter.
+type ephemeralSession struct {
+	*BaseSession
+}
+
+// newEphemeralSession returns a new ephemeral session with given session ID,
+// whose data is encoded by the encoder of the session store (see
+// session.EncoderReporter).
+func newEphemeralSession(sid string, store Store) *ephemeralSession {
+	return &ephemeralSession{
+		BaseSession: NewBaseSession(sid, encoderOf(store), func(http.ResponseWriter, *http.Request, string) {}),
+	}
+}
+
+// view returns the session itself, as ephemeral sessions are never shared by
+// concurrent requests.
+func (s *ephemeralSession) view() Session {
+	return s
+}
+
+// setRequestContext sets the state of the request without the features that are
+// maintained by the session store, as ephemeral sessions are never saved.
+func (s *ephemeralSession) setRequestContext(rc *requestContext) {
+	if rc != nil {
+		rc = rc.withoutStore()
+	}
+	s.BaseSession.setRequestContext(rc)
+}
+
+// EncoderReporter is a session store that reports the encoder of its session
+// data, which is used by sessions that are not read from the session store,
+// e.g. ephemeral sessions.
+type EncoderReporter interface {
+	// Encoder returns the encoder of session data.
+	Encoder() Encoder
+}
+
+// encoderOf returns the encoder of session data of the session store, or the
+// GobEncoder if the session store does not report one.
+func encoderOf(store Store) Encoder {
+	if r, ok := StoreAs[EncoderReporter](store); ok {
+		if encoder := r.Encoder(); encoder != nil {
+			return encoder
+		}
+	}
+	return GobEncoder
+}
+
+// IsEphemeral returns true if the session is an ephemeral session that is
+// served in place of a new session refused by Options.CreationLimiter, or in
+// place of a session that could not be read in the degraded mode of
+// Options.CircuitBreaker. Changes made to ephemeral sessions are discarded at
+// the end of the request.
+func IsEphemeral(s Session) bool {
+	if ls, ok := s.(*lazySession); ok {
+		s, ok = ls.started()
+		if !ok {
+			return false
+		}
+	}
+	_, ok := s.(*ephemeralSession)
+	return ok
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestMemoryCreationLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	limiter := NewMemoryCreationLimiter(1, 2)
+	limiter.nowFunc = func() time.Time { return now }
+
+	for _, want := range []bool{true, true, false} {
+		allowed, err := limiter.Allow(ctx, "192.0.2.1")
+		require.NoError(t, err)
+		assert.Equal(t, want, allowed)
+	}
+
+	// Buckets of different keys are independent
+	allowed, err := limiter.Allow(ctx, "192.0.2.2")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	// A token is refilled after a second
+	now = now.Add(time.Second)
+	allowed, err = limiter.Allow(ctx, "192.0.2.1")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	// Full buckets are pruned
+	now = now.Add(time.Minute)
+	_, err = limiter.Allow(ctx, "192.0.2.3")
+	require.NoError(t, err)
+	assert.Len(t, limiter.buckets, 1)
+}
+
+func TestSessioner_CreationLimiter(t *testing.T) {
+	for _, disableAutoCreate := range []bool{false, true} {
+		t.Run("disableAutoCreate="+strconv.FormatBool(disableAutoCreate), func(t *testing.T) {
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(Sessioner(
+				Options{
+					DisableAutoCreate: disableAutoCreate,
+					CreationLimiter:   NewMemoryCreationLimiter(0.001, 1),
+				},
+			))
+			f.Get("/", func(s Session) string {
+				s.Set("username", "flamego")
+				return strconv.FormatBool(IsEphemeral(s))
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			require.NoError(t, err)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, "false", resp.Body.String())
+			cookie := resp.Header().Get("Set-Cookie")
+			assert.NotEmpty(t, cookie)
+
+			// The existing session is not limited
+			resp = httptest.NewRecorder()
+			req, err = http.NewRequest(http.MethodGet, "/", nil)
+			require.NoError(t, err)
+
+			req.Header.Set("Cookie", cookie)
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, "false", resp.Body.String())
+
+			// An ephemeral session is served beyond the rate
+			resp = httptest.NewRecorder()
+			req, err = http.NewRequest(http.MethodGet, "/", nil)
+			require.NoError(t, err)
+
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, "true", resp.Body.String())
+			assert.Empty(t, resp.Header().Get("Set-Cookie"))
+		})
+	}
+}
+
+func TestEphemeralSession_RequestContext(t *testing.T) {
+	s := newEphemeralSession("1", newMemoryStore(MemoryConfig{}, nil))
+	now := time.Unix(1700000000, 0)
+	setRequestContext(s, &requestContext{
+		now: func() time.Time { return now },
+		incr: func(string, int64) (int64, error) {
+			return 100, nil
+		},
+	})
+
+	// Counters fall back to the session data as ephemeral sessions are never saved
+	n, err := Incr(s, "visits", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, now, nowOf(s))
+}
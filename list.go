@@ -0,0 +1,117 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// ListStore is a session store that is capable of maintaining ordered lists of
+// sessions with atomic operations, which are shared by all instances using the
+// same session store. Elements are opaque binary in the stable encoding of
+// values, thus equal values have equal elements.
+type ListStore interface {
+	// ListAppend appends the element to the end of the list of given key of the
+	// session with given ID. Lists are destroyed along with the session.
+	ListAppend(ctx context.Context, sid, key string, elem []byte) error
+	// ListRemove removes all elements that are equal to the element from the list
+	// of given key of the session with given ID, and returns the number of
+	// removed elements.
+	ListRemove(ctx context.Context, sid, key string, elem []byte) (int, error)
+	// ListAll returns all elements of the list of given key of the session with
+	// given ID in order. It returns an empty list if no such list exists.
+	ListAll(ctx context.Context, sid, key string) ([][]byte, error)
+}
+
+// listOps is the set of functions to operate lists in the session store.
+type listOps struct {
+	append func(key string, elem []byte) error
+	remove func(key string, elem []byte) (int, error)
+	all    func(key string) ([][]byte, error)
+}
+
+// listKeeper is a session that is capable of delegating operations of lists to
+// the session store.
+type listKeeper interface {
+	// listAppend appends the value to the end of the list of given key.
+	listAppend(key string, val interface{}) error
+	// listRemove removes all values that are equal to the value from the list of
+	// given key, and returns the number of removed values.
+	listRemove(key string, val interface{}) (int, error)
+	// listAll returns a copy of all values of the list of given key in order.
+	listAll(key string) ([]interface{}, error)
+}
+
+// ListAppend appends the value to the end of the list of given key in the
+// session. When the session store implements session.ListStore, lists are
+// maintained by the session store and operations are atomic across all
+// instances. Otherwise, lists fall back to []interface{} values in the session
+// data, which are copied on write. Values are compared by their stable
+// encodings, which support the same types as session.Export.
+func ListAppend(s Session, key string, val interface{}) error {
+	l, ok := s.(listKeeper)
+	if !ok {
+		return fmt.Errorf("session with the type %T does not support lists", s)
+	}
+	return l.listAppend(key, val)
+}
+
+// ListRemove removes all values that are equal to the value from the list of
+// given key in the session, and returns the number of removed values.
+func ListRemove(s Session, key string, val interface{}) (int, error) {
+	l, ok := s.(listKeeper)
+	if !ok {
+		return 0, fmt.Errorf("session with the type %T does not support lists", s)
+	}
+	return l.listRemove(key, val)
+}
+
+// ListAll returns a copy of all values of the list of given key in the session
+// in order. It returns an empty list if no such list exists.
+func ListAll(s Session, key string) ([]interface{}, error) {
+	l, ok := s.(listKeeper)
+	if !ok {
+		return nil, fmt.Errorf("session with the type %T does not support lists", s)
+	}
+	return l.listAll(key)
+}
+
+// encodeListElem returns the stable encoding of the value as an element of
+// lists, which supports the same types as session.Export.
+func encodeListElem(v interface{}) ([]byte, error) {
+	tv, err := exportValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tv)
+}
+
+// decodeListElem returns the value of the element encoded by encodeListElem.
+func decodeListElem(elem []byte) (interface{}, error) {
+	var tv typedValue
+	err := json.Unmarshal(elem, &tv)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+	return importValue(tv)
+}
+
+// removeListElems returns the list without elements whose encodings are equal
+// to the element, and the number of removed elements. Elements that cannot be
+// encoded are kept. The given list is not modified.
+func removeListElems(list []interface{}, elem []byte) ([]interface{}, int) {
+	kept := make([]interface{}, 0, len(list))
+	for _, v := range list {
+		b, err := encodeListElem(v)
+		if err == nil && bytes.Equal(b, elem) {
+			continue
+		}
+		kept = append(kept, v)
+	}
+	return kept, len(list) - len(kept)
+}
@@ -0,0 +1,185 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/flamego/flamego"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSession_Lists(t *testing.T) {
+	s := NewBaseSession("1", GobEncoder, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, ListAppend(s, "cart", "apple"))
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, ListAppend(s, "cart", Data{"sku": "banana", "qty": 2}))
+
+	list, err := ListAll(s, "cart")
+	require.NoError(t, err)
+	assert.Len(t, list, 11)
+
+	// Values are compared by their stable encodings
+	n, err := ListRemove(s, "cart", Data{"qty": 2, "sku": "banana"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = ListRemove(s, "cart", "apple")
+	require.NoError(t, err)
+	assert.Equal(t, 10, n)
+
+	// Lists returned earlier are not affected
+	assert.Len(t, list, 11)
+	list, err = ListAll(s, "cart")
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	assert.Error(t, ListAppend(s, "cart", make(chan int)))
+}
+
+// listStore is a session store that keeps lists separately from the session
+// data.
+type listStore struct {
+	Store
+	lock  sync.Mutex
+	lists map[string][][]byte
+}
+
+func (s *listStore) ListAppend(_ context.Context, sid, key string, elem []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lists[sid+":"+key] = append(s.lists[sid+":"+key], elem)
+	return nil
+}
+
+func (s *listStore) ListRemove(_ context.Context, sid, key string, elem []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var kept [][]byte
+	for _, e := range s.lists[sid+":"+key] {
+		if !bytes.Equal(e, elem) {
+			kept = append(kept, e)
+		}
+	}
+	n := len(s.lists[sid+":"+key]) - len(kept)
+	s.lists[sid+":"+key] = kept
+	return n, nil
+}
+
+func (s *listStore) ListAll(_ context.Context, sid, key string) ([][]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.lists[sid+":"+key], nil
+}
+
+func TestSession_Lists_Store(t *testing.T) {
+	store := &listStore{lists: make(map[string][][]byte)}
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Initer: func(ctx context.Context, args ...interface{}) (Store, error) {
+				memory, err := MemoryIniter()(ctx, args...)
+				if err != nil {
+					return nil, err
+				}
+				store.Store = memory
+				return store, nil
+			},
+			GCMode: GCDisabled,
+		},
+	))
+	f.Get("/", func(s Session) string {
+		err := ListAppend(s, "cart", int64(len(s.ID())))
+		require.NoError(t, err)
+		list, err := ListAll(s, "cart")
+		require.NoError(t, err)
+		return fmt.Sprint(list, s.Get("cart"))
+	})
+
+	var cookie string
+	for _, want := range []string{"[16] <nil>", "[16 16] <nil>"} {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, err)
+
+		req.Header.Set("Cookie", cookie)
+		f.ServeHTTP(resp, req)
+		assert.Equal(t, want, resp.Body.String())
+
+		if cookie == "" {
+			cookie = resp.Header().Get("Set-Cookie")
+		}
+	}
+	assert.Len(t, store.lists, 1)
+}
+
+func TestSession_Lists_StoreConcurrentRequests(t *testing.T) {
+	store := &listStore{lists: make(map[string][][]byte)}
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Initer: func(ctx context.Context, args ...interface{}) (Store, error) {
+				memory, err := MemoryIniter()(ctx, args...)
+				if err != nil {
+					return nil, err
+				}
+				store.Store = memory
+				return store, nil
+			},
+			GCMode: GCDisabled,
+		},
+	))
+	f.Get("/", func(s Session) {})
+	started, proceed := make(chan struct{}), make(chan struct{})
+	f.Get("/slow", func(s Session) {
+		close(started)
+		<-proceed
+		require.NoError(t, ListAppend(s, "cart", "apple"))
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	cookie := resp.Header().Get("Set-Cookie")
+
+	request := func(path string) {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Cookie", cookie)
+		f.ServeHTTP(resp, req)
+	}
+
+	// The end of another request of the same session does not make lists of the
+	// slow request fall back to the session data.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		request("/slow")
+	}()
+	<-started
+	request("/")
+	close(proceed)
+	<-done
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	assert.Len(t, store.lists, 1)
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// SetLocale sets the preferred locale of the session as a BCP 47 language tag,
+// e.g. "en-US". An empty locale removes the preference. It returns an error if
+// the locale is not well-formed.
+func SetLocale(s Session, locale string) error {
+	s = rootOf(s)
+	if locale == "" {
+		deleteInternal(s, localeKey)
+		return nil
+	}
+
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return fmt.Errorf("parse locale %q: %w", locale, err)
+	}
+	setInternal(s, localeKey, tag.String())
+	return nil
+}
+
+// LocaleOf returns the preferred locale of the session in the canonical form, or
+// an empty string if not set.
+func LocaleOf(s Session) string {
+	locale, _ := rootOf(s).Get(localeKey).(string)
+	return locale
+}
+
+// SetTimeZone sets the preferred time zone of the session by its IANA name, e.g.
+// "America/New_York". An empty name removes the preference. It returns an error
+// if the time zone is unknown.
+func SetTimeZone(s Session, name string) error {
+	s = rootOf(s)
+	if name == "" {
+		deleteInternal(s, timeZoneKey)
+		return nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("load time zone %q: %w", name, err)
+	}
+	setInternal(s, timeZoneKey, loc.String())
+	return nil
+}
+
+// TimeZoneOf returns the preferred time zone of the session, or time.UTC if not
+// set or no longer available.
+func TimeZoneOf(s Session) *time.Location {
+	name, _ := rootOf(s).Get(timeZoneKey).(string)
+	if name == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// localeDetector detects preferred locales from the Accept-Language header of
+// requests.
+type localeDetector struct {
+	supported []language.Tag   // The locales supported by the application, empty to accept any locale
+	matcher   language.Matcher // The matcher of the supported locales, nil if any locale is accepted
+}
+
+// newLocaleDetector returns a new locale detector that matches the supported
+// locales, or accepts any locale if none is given.
+func newLocaleDetector(supported []string) (*localeDetector, error) {
+	d := &localeDetector{}
+	if len(supported) == 0 {
+		return d, nil
+	}
+
+	d.supported = make([]language.Tag, 0, len(supported))
+	for _, locale := range supported {
+		tag, err := language.Parse(locale)
+		if err != nil {
+			return nil, fmt.Errorf("parse locale %q: %w", locale, err)
+		}
+		d.supported = append(d.supported, tag)
+	}
+	d.matcher = language.NewMatcher(d.supported)
+	return d, nil
+}
+
+// detect returns the preferred locale of the request, or an empty string if
+// there is no acceptable locale.
+func (d *localeDetector) detect(r *http.Request) string {
+	tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if err != nil || len(tags) == 0 {
+		return ""
+	}
+
+	if d.matcher == nil {
+		return tags[0].String()
+	}
+	_, i, confidence := d.matcher.Match(tags...)
+	if confidence == language.No {
+		return ""
+	}
+	return d.supported[i].String()
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestSession_Locale(t *testing.T) {
+	s := NewBaseSession("1", GobEncoder, nil)
+	assert.Empty(t, LocaleOf(s))
+
+	require.NoError(t, SetLocale(s, "en-us"))
+	assert.Equal(t, "en-US", LocaleOf(s))
+	assert.Error(t, SetLocale(s, "not a locale"))
+	assert.Equal(t, "en-US", LocaleOf(s))
+
+	require.NoError(t, SetLocale(s, ""))
+	assert.Empty(t, LocaleOf(s))
+}
+
+func TestSession_TimeZone(t *testing.T) {
+	s := NewBaseSession("1", GobEncoder, nil)
+	assert.Equal(t, time.UTC, TimeZoneOf(s))
+
+	require.NoError(t, SetTimeZone(s, "America/New_York"))
+	assert.Equal(t, "America/New_York", TimeZoneOf(s).String())
+	assert.Error(t, SetTimeZone(s, "Mars/Olympus_Mons"))
+	assert.Equal(t, "America/New_York", TimeZoneOf(s).String())
+
+	require.NoError(t, SetTimeZone(s, ""))
+	assert.Equal(t, time.UTC, TimeZoneOf(s))
+}
+
+func TestLocaleDetector(t *testing.T) {
+	tests := []struct {
+		name           string
+		supported      []string
+		acceptLanguage string
+		want           string
+	}{
+		{name: "no header", want: ""},
+		{name: "any locale", acceptLanguage: "fr-CH, fr;q=0.9, en;q=0.8", want: "fr-CH"},
+		{name: "by quality", acceptLanguage: "en;q=0.5, de", want: "de"},
+		{name: "supported", supported: []string{"en", "fr"}, acceptLanguage: "fr-CH, en;q=0.8", want: "fr"},
+		{name: "unsupported", supported: []string{"en", "fr"}, acceptLanguage: "zh", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := newLocaleDetector(test.supported)
+			require.NoError(t, err)
+
+			r := &http.Request{Header: http.Header{}}
+			if test.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", test.acceptLanguage)
+			}
+			assert.Equal(t, test.want, d.detect(r))
+		})
+	}
+
+	_, err := newLocaleDetector([]string{"not a locale"})
+	assert.Error(t, err)
+}
+
+func TestSessioner_DetectLocale(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			DetectLocale:     true,
+			SupportedLocales: []string{"en", "de"},
+		},
+	))
+	f.Get("/", func(s Session) string {
+		return LocaleOf(s)
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Language", "de-AT, en;q=0.5")
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, "de", resp.Body.String())
+}
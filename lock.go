@@ -0,0 +1,42 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"time"
+)
+
+// idleLockDue returns true if the session has been idle for at least the
+// duration since it was last seen (see session.InfoOf).
+func idleLockDue(s Session, now time.Time, after time.Duration) bool {
+	lastSeenAt := InfoOf(s).LastSeenAt
+	return !lastSeenAt.IsZero() && now.Sub(lastSeenAt) >= after
+}
+
+// lock marks the session as locked at given time, the time of an existing lock
+// is kept.
+func lock(s Session, now time.Time) {
+	if IsLocked(s) {
+		return
+	}
+	setInternal(s, lockedKey, now.UnixNano())
+}
+
+// IsLocked returns true if the session has been locked after being idle for
+// Options.IdleLockAfter. Locked sessions keep their data, and it is up to the
+// application to require re-authentication (e.g. re-entering the password)
+// before calling session.Unlock.
+func IsLocked(s Session) bool {
+	_, ok := rootOf(s).Get(lockedKey).(int64)
+	return ok
+}
+
+// Unlock clears the lock of the session.
+func Unlock(s Session) {
+	if !IsLocked(s) {
+		return
+	}
+	deleteInternal(rootOf(s), lockedKey)
+}
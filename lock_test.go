@@ -0,0 +1,76 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestSessioner_IdleLockAfter(t *testing.T) {
+	now := time.Now()
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Config: FileConfig{
+				RootDir: t.TempDir(),
+				NowFunc: func() time.Time { return now },
+			},
+			Initer:        FileIniter(),
+			GCMode:        GCDisabled,
+			IdleLockAfter: 10 * time.Minute,
+			NowFunc:       func() time.Time { return now },
+		},
+	))
+	f.Get("/", func(s Session) string {
+		s.Set("name", "flamego")
+		return strconv.FormatBool(IsLocked(s))
+	})
+	f.Get("/unlock", func(s Session) {
+		Unlock(s)
+	})
+	f.Get("/flush", func(s Session) {
+		s.Flush()
+	})
+
+	var cookie string
+	request := func(path string) string {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if c := resp.Header().Get("Set-Cookie"); c != "" {
+			cookie = strings.Split(c, ";")[0]
+		}
+		return resp.Body.String()
+	}
+
+	assert.Equal(t, "false", request("/"))
+	now = now.Add(5 * time.Minute)
+	assert.Equal(t, "false", request("/"))
+
+	// The session stays alive but is locked after being idle
+	now = now.Add(10 * time.Minute)
+	assert.Equal(t, "true", request("/"))
+	assert.Equal(t, "true", request("/"), "the lock persists until unlocked")
+	request("/flush")
+	assert.Equal(t, "true", request("/"), "the lock persists across Flush")
+
+	request("/unlock")
+	assert.Equal(t, "false", request("/"))
+}
@@ -7,15 +7,36 @@ package session
 import (
 	"context"
 	"crypto/rand"
+	"errors"
+	"fmt"
 	"math/big"
 	"net/http"
 	"time"
 
-	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
 )
 
 // Store is a session store with capabilities of checking, reading, destroying
 // and GC sessions.
+//
+// Errors returned by session stores must wrap their causes (e.g. using
+// fmt.Errorf with the %w verb) so that they can be inspected with errors.Is
+// and errors.As, and the session.Sessioner relies on the following:
+//   - Errors caused by the context match context.Canceled or
+//     context.DeadlineExceeded, which are not treated as failures of the
+//     session store.
+//   - Errors of decoding session data wrap the errors of the Decoder, e.g.
+//     *session.CorruptedDataError and *session.FormatChangedError.
+//   - Operations on sessions that do not exist are not errors, i.e. there is no
+//     "not found" error.
+//
+// Wrappers of session stores return session.ErrCircuitOpen when operations are
+// rejected by the circuit breaker.
+//
+// Session stores must respect deadlines of contexts and return promptly once
+// contexts are done. Builds with the "sessiondebug" build tag assert this on
+// every call of the Store methods and report violations via Options.ErrorFunc,
+// including calls with contexts without deadlines (see Options.StoreTimeouts).
 type Store interface {
 	// Exist returns true of the session with given ID exists.
 	Exist(ctx context.Context, sid string) bool
@@ -33,33 +54,396 @@ type Store interface {
 	GC(ctx context.Context) error
 }
 
+// Lister is a session store that is capable of listing sessions.
+type Lister interface {
+	// List returns IDs of all sessions in the session store, including the ones
+	// that are expired but not yet recycled by GC.
+	List(ctx context.Context) ([]string, error)
+}
+
+// Expirer is a session store that is capable of reporting expiry time of
+// sessions.
+type Expirer interface {
+	// ExpiresAt returns the time when the session with given ID expires. It
+	// returns zero time if there is no session associated with the ID.
+	ExpiresAt(ctx context.Context, sid string) (time.Time, error)
+}
+
+// TagFinder is a session store that is capable of persisting session tags and
+// finding sessions by tags.
+type TagFinder interface {
+	// FindByTag returns IDs of sessions that have the tag of given key with the
+	// value.
+	FindByTag(ctx context.Context, key, value string) ([]string, error)
+}
+
+// Incrementer is a session store that is capable of maintaining counters of
+// sessions with atomic increments, which are shared by all instances using the
+// same session store.
+type Incrementer interface {
+	// Incr increments the counter of given key of the session with given ID by
+	// delta, and returns the new value. Counters are destroyed along with the
+	// session.
+	Incr(ctx context.Context, sid, key string, delta int64) (int64, error)
+}
+
+// ExistChecker is a session store that is capable of reporting errors of
+// checking existence of sessions, which are indistinguishable from nonexistent
+// sessions when using Store.Exist.
+type ExistChecker interface {
+	// CheckExist returns true if the session with given ID exists. It returns an
+	// error if the existence cannot be determined, e.g. the session store is
+	// unreachable.
+	CheckExist(ctx context.Context, sid string) (bool, error)
+}
+
+// CheckExist returns true if the session with given ID exists in the session
+// store. Errors are only reported by session stores implementing
+// session.ExistChecker, other session stores fall back to Store.Exist. Unlike
+// optional interfaces that are discovered via session.StoreAs, only the given
+// session store is checked so that calls are never bypassing wrappers.
+func CheckExist(ctx context.Context, store Store, sid string) (bool, error) {
+	if checker, ok := store.(ExistChecker); ok {
+		return checker.CheckExist(ctx, sid)
+	}
+	return store.Exist(ctx, sid), nil
+}
+
+// MultiReader is a session store that is capable of reading many sessions at
+// once, e.g. in a single round trip to the database.
+type MultiReader interface {
+	// ReadMany returns sessions with given IDs keyed by session IDs. Unlike
+	// Store.Read, sessions that do not exist are omitted rather than created.
+	ReadMany(ctx context.Context, sids []string) (map[string]Session, error)
+}
+
+// Peeker is a session store that is capable of reading sessions without side
+// effects, e.g. extending the lifetime of sessions or creating missing ones like
+// Store.Read does, which is useful for inspection tooling.
+type Peeker interface {
+	// Peek returns the session with given ID, or nil if the session does not
+	// exist or has expired.
+	Peek(ctx context.Context, sid string) (Session, error)
+}
+
+// ReadMany returns sessions with given IDs that exist in the session store,
+// keyed by session IDs. Sessions are read at once by session stores
+// implementing session.MultiReader, other session stores fall back to checking
+// and reading sessions one by one. Like session.CheckExist, only the given
+// session store is checked.
+func ReadMany(ctx context.Context, store Store, sids []string) (map[string]Session, error) {
+	if reader, ok := store.(MultiReader); ok {
+		return reader.ReadMany(ctx, sids)
+	}
+
+	sessions := make(map[string]Session, len(sids))
+	for _, sid := range sids {
+		ok, err := CheckExist(ctx, store, sid)
+		if err != nil {
+			return nil, fmt.Errorf("check existence of %q: %w", sid, err)
+		} else if !ok {
+			continue
+		}
+
+		sess, err := store.Read(ctx, sid)
+		if err != nil {
+			return nil, fmt.Errorf("read %q: %w", sid, err)
+		}
+		sessions[sid] = sess
+	}
+	return sessions, nil
+}
+
+// Preloader is a session store that is capable of caching sessions, which can
+// be loaded into the cache ahead of traffic.
+type Preloader interface {
+	// Preload loads sessions with given IDs into the cache, and returns the
+	// number of loaded sessions. Sessions that do not exist are skipped.
+	Preload(ctx context.Context, sids []string) (int, error)
+}
+
+// StoreAs returns the first session store that implements T in the chain of
+// session store wrappers, starting from the given session store and following
+// the `Unwrap() Store` method of each wrapper.
+func StoreAs[T any](store Store) (T, bool) {
+	for store != nil {
+		if v, ok := store.(T); ok {
+			return v, true
+		}
+
+		u, ok := store.(interface{ Unwrap() Store })
+		if !ok {
+			break
+		}
+		store = u.Unwrap()
+	}
+
+	var zero T
+	return zero, false
+}
+
+// StoreMiddleware wraps the session store to add behaviors, e.g.
+// instrumentation, caching, encryption or failover. Wrappers should implement
+// the `Unwrap() Store` method to make optional interfaces of the underlying
+// session store discoverable via session.StoreAs.
+type StoreMiddleware func(Store) Store
+
+// wrapStore returns the session store wrapped by given wrappers in order, i.e.
+// the last wrapper is the outermost.
+func wrapStore(store Store, wrappers ...StoreMiddleware) Store {
+	for _, wrap := range wrappers {
+		if wrap != nil {
+			store = wrap(store)
+		}
+	}
+	return store
+}
+
 // Initer takes arbitrary number of arguments needed for initialization and
 // returns an initialized session store.
 type Initer func(ctx context.Context, args ...interface{}) (Store, error)
 
 // manager is wrapper for wiring HTTP request and session stores.
 type manager struct {
-	store Store // The session store that is being managed.
+	store    Store               // The session store that is being managed.
+	timeouts StoreTimeouts       // The timeouts of operations on the session store.
+	retry    RetryPolicy         // The policy of retrying failed operations on the session store.
+	limiter  CreationLimiter     // The rate limiter of creating new sessions, may be nil.
+	active   *activeLimit        // The limit of active sessions in the session store, may be nil.
+	negCache *negativeCache      // The cache of missing session IDs, may be nil.
+	reads    *singleflight.Group // The group to coalesce concurrent reads of the same session, may be nil.
+	ids      idPolicy            // The policy of generating and validating session IDs.
+	gcLease  GCLease             // The lease to coordinate GC operations across instances, may be nil.
+	onExpire OnExpireFunc        // The function to be called with expired sessions recycled by GC, may be nil.
+	gcBatch  int                 // The batch size of recycling expired sessions when onExpire is set.
+	strict   bool                // Whether to fail on errors of checking existence of sessions.
+	errFunc  func(error)         // The function to print errors of the creation limits and checking existence of sessions.
 }
 
-// newManager returns a new manager with given session store.
-func newManager(store Store) *manager {
+// newManager returns a new manager with given session store and options. It
+// panics if the ID policy of the options is invalid.
+func newManager(store Store, opt Options) *manager {
+	ids, err := newIDPolicy(opt)
+	if err != nil {
+		panic("session: ID policy: " + err.Error())
+	}
+	var reads *singleflight.Group
+	if opt.CoalesceReads {
+		reads = new(singleflight.Group)
+	}
 	return &manager{
-		store: store,
+		store:    store,
+		reads:    reads,
+		timeouts: opt.StoreTimeouts,
+		retry:    opt.Retry,
+		limiter:  opt.CreationLimiter,
+		active:   newActiveLimit(store, opt),
+		negCache: newNegativeCache(opt.NegativeCache),
+		ids:      ids,
+		gcLease:  opt.GCLease,
+		onExpire: opt.OnExpire,
+		gcBatch:  opt.OnExpireBatchSize,
+		strict:   opt.StrictErrors,
+		errFunc:  opt.ErrorFunc,
+	}
+}
+
+// withTimeout returns a copy of the context with given timeout. The context is
+// returned as-is with a no-op cancel function when the timeout is not
+// positive.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// waitUntil blocks until given time or the context is done.
+func waitUntil(ctx context.Context, t time.Time) {
+	d := time.Until(t)
+	if d <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// exist checks existence of the session with the read timeout. Errors are
+// returned in strict mode, or otherwise printed and the session is treated as
+// nonexistent.
+func (m *manager) exist(ctx context.Context, sid string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, m.timeouts.Read)
+	defer cancel()
+	ok, err := CheckExist(ctx, m.store, sid)
+	if err != nil {
+		if m.strict {
+			return false, fmt.Errorf("check existence: %w", err)
+		}
+		m.errFunc(fmt.Errorf("check existence: %w", err))
+		return false, nil
+	}
+	return ok, nil
+}
+
+// missing returns true if the session with given ID does not exist in the
+// session store. The negative cache is consulted first when enabled, and
+// session IDs that are found missing are cached.
+func (m *manager) missing(ctx context.Context, sid string) (bool, error) {
+	if m.negCache != nil && m.negCache.contains(sid) {
+		return true, nil
+	}
+	ok, err := m.exist(ctx, sid)
+	if err != nil {
+		return false, err
+	} else if ok {
+		return false, nil
+	}
+	if m.negCache != nil {
+		m.negCache.add(sid)
+	}
+	return true, nil
+}
+
+// read calls Read of the session store with the read timeout and the retry
+// policy. Concurrent reads of the same session share a single read when
+// coalescing is enabled, and each caller gets its own copy of the session.
+func (m *manager) read(ctx context.Context, sid string) (Session, error) {
+	if m.reads == nil {
+		return m.readStore(ctx, sid)
 	}
+
+	v, err, shared := m.reads.Do(sid, func() (interface{}, error) {
+		return m.readStore(ctx, sid)
+	})
+	if !shared {
+		if err != nil {
+			return nil, err
+		}
+		return v.(Session), nil
+	}
+
+	// The shared read is done with the context of another request, which may be
+	// canceled or the session may not be copied, so fall back to reading alone.
+	if sess, ok := v.(*BaseSession); ok && err == nil {
+		return sess.clone(), nil
+	}
+	return m.readStore(ctx, sid)
+}
+
+// readStore calls Read of the session store with the read timeout and the
+// retry policy.
+func (m *manager) readStore(ctx context.Context, sid string) (sess Session, err error) {
+	err = m.retry.retry(ctx, func() error {
+		ctx, cancel := withTimeout(ctx, m.timeouts.Read)
+		defer cancel()
+		sess, err = m.store.Read(ctx, sid)
+		return err
+	})
+	return sess, err
+}
+
+// save calls Save of the session store with the write timeout and the retry
+// policy.
+func (m *manager) save(ctx context.Context, sess Session) error {
+	return m.retry.retry(ctx, func() error {
+		ctx, cancel := withTimeout(ctx, m.timeouts.Write)
+		defer cancel()
+		return m.store.Save(ctx, sess)
+	})
+}
+
+// touch calls Touch of the session store with the write timeout and the retry
+// policy.
+func (m *manager) touch(ctx context.Context, sid string) error {
+	return m.retry.retry(ctx, func() error {
+		ctx, cancel := withTimeout(ctx, m.timeouts.Write)
+		defer cancel()
+		return m.store.Touch(ctx, sid)
+	})
+}
+
+// destroy calls Destroy of the session store with the write timeout and the
+// retry policy.
+func (m *manager) destroy(ctx context.Context, sid string) error {
+	return m.retry.retry(ctx, func() error {
+		ctx, cancel := withTimeout(ctx, m.timeouts.Write)
+		defer cancel()
+		return m.store.Destroy(ctx, sid)
+	})
+}
+
+// incr calls Incr of the session store with the write timeout. Increments are
+// not idempotent, thus never retried.
+func (m *manager) incr(ctx context.Context, inc Incrementer, sid, key string, delta int64) (int64, error) {
+	ctx, cancel := withTimeout(ctx, m.timeouts.Write)
+	defer cancel()
+	return inc.Incr(ctx, sid, key, delta)
+}
+
+// lists returns the functions to operate lists of the session with the ID
+// returned by sid in the session store. Appending and removing use the write
+// timeout and are never retried as appending is not idempotent, and listing
+// uses the read timeout.
+func (m *manager) lists(ctx context.Context, ls ListStore, sid func() string) *listOps {
+	return &listOps{
+		append: func(key string, elem []byte) error {
+			ctx, cancel := withTimeout(ctx, m.timeouts.Write)
+			defer cancel()
+			return ls.ListAppend(ctx, sid(), key, elem)
+		},
+		remove: func(key string, elem []byte) (int, error) {
+			ctx, cancel := withTimeout(ctx, m.timeouts.Write)
+			defer cancel()
+			return ls.ListRemove(ctx, sid(), key, elem)
+		},
+		all: func(key string) ([][]byte, error) {
+			ctx, cancel := withTimeout(ctx, m.timeouts.Read)
+			defer cancel()
+			return ls.ListAll(ctx, sid(), key)
+		},
+	}
+}
+
+// gc calls GC of the session store with the GC timeout. Expired sessions are
+// passed to the onExpire if it is set and the session store is capable of it.
+func (m *manager) gc(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, m.timeouts.GC)
+	defer cancel()
+	if m.onExpire != nil {
+		if archiver, ok := StoreAs[ExpiryArchiver](m.store); ok && Capabilities(m.store).ArchiveOnGC {
+			return archiver.GCWithArchive(ctx, m.gcBatch, m.onExpire)
+		}
+	}
+	return m.store.GC(ctx)
 }
 
 // startGC starts a background goroutine to trigger GC of the session store in
-// given time interval. Errors are printed using the `errFunc`. It returns a
+// given time interval. GC is skipped for the interval when the GC lease is held
+// by another instance. Errors are printed using the `errFunc`. It returns a
 // send-only channel for stopping the background goroutine.
 func (m *manager) startGC(ctx context.Context, interval time.Duration, errFunc func(error)) chan<- struct{} {
 	stop := make(chan struct{})
 	go func() {
 		ticker := time.NewTicker(interval)
 		for {
-			err := m.store.GC(ctx)
-			if err != nil {
-				errFunc(err)
+			acquired := true
+			if m.gcLease != nil {
+				var err error
+				acquired, err = m.gcLease.Acquire(ctx, interval)
+				if err != nil {
+					errFunc(fmt.Errorf("acquire GC lease: %w", err))
+				}
+			}
+			if acquired {
+				err := m.gc(ctx)
+				if err != nil {
+					errFunc(err)
+				}
 			}
 
 			select {
@@ -73,10 +457,15 @@ func (m *manager) startGC(ctx context.Context, interval time.Duration, errFunc f
 	return stop
 }
 
-// randomChars returns a generated string in given number of random characters.
+// randomChars returns a generated string in given number of random characters
+// of the default alphabet.
 func randomChars(n int) (string, error) {
-	const alphanum = "0123456789abcdefghijklmnopqrstuvwxyz"
+	return randomString(DefaultIDAlphabet, n)
+}
 
+// randomString returns a generated string in given number of random characters
+// of the alphabet.
+func randomString(alphabet string, n int) (string, error) {
 	randomInt := func(max *big.Int) (int, error) {
 		r, err := rand.Int(rand.Reader, max)
 		if err != nil {
@@ -87,50 +476,146 @@ func randomChars(n int) (string, error) {
 	}
 
 	buffer := make([]byte, n)
-	max := big.NewInt(int64(len(alphanum)))
+	max := big.NewInt(int64(len(alphabet)))
 	for i := 0; i < n; i++ {
 		index, err := randomInt(max)
 		if err != nil {
 			return "", err
 		}
 
-		buffer[i] = alphanum[index]
+		buffer[i] = alphabet[index]
 	}
 
 	return string(buffer), nil
 }
 
-// isValidSessionID returns true if given session ID looks like a valid ID.
-func isValidSessionID(sid string, idLength int) bool {
-	if len(sid) != idLength {
-		return false
+// load loads the session from the session store with session ID provided in the
+// named cookie. It returns `created=true` if a new session is created.
+func (m *manager) load(r *http.Request, sid string) (_ Session, created bool, err error) {
+	if !m.ids.valid(sid) {
+		sid, err = m.ids.generate()
+		if err != nil {
+			return nil, false, fmt.Errorf("new ID: %w", err)
+		}
+		created = true
 	}
 
-	for i := range sid {
-		switch {
-		case '0' <= sid[i] && sid[i] <= '9':
-		case 'a' <= sid[i] && sid[i] <= 'z':
-		default:
-			return false
+	missing := created
+	if !missing && (m.limiter != nil || m.active != nil || m.negCache != nil) {
+		missing, err = m.missing(r.Context(), sid)
+		if err != nil {
+			return nil, false, err
 		}
 	}
-	return true
-}
 
-// load loads the session from the session store with session ID provided in the
-// named cookie. It returns `created=true` if a new session is created.
-func (m *manager) load(r *http.Request, sid string, idLength int) (_ Session, created bool, err error) {
-	if !isValidSessionID(sid, idLength) {
-		sid, err = randomChars(idLength)
+	// Replace missing session IDs with new ones rather than adopting them when
+	// the negative cache is enabled, thus cached missing session IDs never reach
+	// the session store.
+	if missing && !created && m.negCache != nil {
+		sid, err = m.ids.generate()
 		if err != nil {
-			return nil, false, errors.Wrap(err, "new ID")
+			return nil, false, fmt.Errorf("new ID: %w", err)
 		}
 		created = true
 	}
 
-	sess, err := m.store.Read(r.Context(), sid)
+	var sess Session
+	if missing {
+		sess, err = m.create(r, sid)
+	} else {
+		sess, err = m.read(r.Context(), sid)
+	}
 	if err != nil {
-		return nil, false, errors.Wrap(err, "read")
+		return nil, false, fmt.Errorf("read: %w", err)
 	}
 	return sess, created, nil
 }
+
+// create reads the session with given ID that does not exist in the session
+// store, i.e. creates the session. It returns an ephemeral session instead if
+// the creation is refused by the creation limiter or the limit of active
+// sessions, or ErrTooManySessions if the limit of active sessions rejects the
+// creation. Errors of the creation limits are printed and the creation is
+// allowed.
+func (m *manager) create(r *http.Request, sid string) (Session, error) {
+	if m.limiter != nil {
+		allowed, err := m.limiter.Allow(r.Context(), remoteIP(r))
+		if err != nil {
+			m.errFunc(fmt.Errorf("creation limiter: %w", err))
+		} else if !allowed {
+			return newEphemeralSession(sid, m.store), nil
+		}
+	}
+	if m.active != nil {
+		ctx, cancel := withTimeout(r.Context(), m.timeouts.Read)
+		allowed, err := m.active.admit(ctx)
+		cancel()
+		if errors.Is(err, ErrTooManySessions) {
+			return nil, err
+		} else if err != nil {
+			m.errFunc(fmt.Errorf("active sessions limit: %w", err))
+		} else if !allowed {
+			return newEphemeralSession(sid, m.store), nil
+		}
+	}
+	return m.read(r.Context(), sid)
+}
+
+// restart destroys the session with given ID from the session store, and
+// returns a new session with a newly generated ID in place of it.
+func (m *manager) restart(ctx context.Context, sid string) (Session, error) {
+	err := m.destroy(ctx, sid)
+	if err != nil {
+		return nil, fmt.Errorf("destroy: %w", err)
+	}
+
+	sid, err = m.ids.generate()
+	if err != nil {
+		return nil, fmt.Errorf("new ID: %w", err)
+	}
+	sess, err := m.read(ctx, sid)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	return sess, nil
+}
+
+// loadLazy is like load but defers reading the session from the session store
+// until it is first written to when there is no existing session associated
+// with the session ID. The `onStart` is called with the session ID and whether
+// the session ID is newly generated once the deferred session is started.
+func (m *manager) loadLazy(r *http.Request, sid string, onStart func(sid string, created bool)) (_ Session, err error) {
+	valid := m.ids.valid(sid)
+	if valid {
+		missing, err := m.missing(r.Context(), sid)
+		if err != nil {
+			return nil, err
+		} else if !missing {
+			sess, err := m.read(r.Context(), sid)
+			if err != nil {
+				return nil, fmt.Errorf("read: %w", err)
+			}
+			return sess, nil
+		}
+	}
+
+	// See load for why missing session IDs are replaced when the negative cache
+	// is enabled.
+	created := false
+	if !valid || m.negCache != nil {
+		sid, err = m.ids.generate()
+		if err != nil {
+			return nil, fmt.Errorf("new ID: %w", err)
+		}
+		created = true
+	}
+	create := func(_ context.Context, sid string) (Session, error) {
+		sess, err := m.create(r, sid)
+		if errors.Is(err, ErrTooManySessions) {
+			// See ActiveLimitReject for why deferred sessions are not rejected.
+			return newEphemeralSession(sid, m.store), nil
+		}
+		return sess, err
+	}
+	return newLazySession(r.Context(), create, sid, created, onStart), nil
+}
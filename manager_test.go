@@ -6,6 +6,10 @@ package session
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,20 +17,22 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func TestIsValidSessionID(t *testing.T) {
+func TestIDPolicy_valid(t *testing.T) {
+	ids, err := newIDPolicy(Options{IDLength: 16})
+	require.Nil(t, err)
 	for i := 0; i < 10; i++ {
 		s, err := randomChars(16)
 		require.Nil(t, err)
-		assert.True(t, isValidSessionID(s, 16))
+		assert.True(t, ids.valid(s))
 	}
 
-	assert.False(t, isValidSessionID("123", 16))
-	assert.False(t, isValidSessionID("3qKCBYmuAqG1RQix", 16))
-	assert.False(t, isValidSessionID("../session/ad2c7", 16))
+	assert.False(t, ids.valid("123"))
+	assert.False(t, ids.valid("3qKCBYmuAqG1RQix"))
+	assert.False(t, ids.valid("../session/ad2c7"))
 }
 
 func TestManager_startGC(t *testing.T) {
-	m := newManager(newMemoryStore(MemoryConfig{}, nil))
+	m := newManager(newMemoryStore(MemoryConfig{}, nil), Options{})
 	stop := m.startGC(
 		context.Background(),
 		time.Minute,
@@ -34,3 +40,152 @@ func TestManager_startGC(t *testing.T) {
 	)
 	stop <- struct{}{}
 }
+
+type deadlineStore struct {
+	noopStore
+	readDeadline bool
+	saveDeadline bool
+}
+
+func (s *deadlineStore) Read(ctx context.Context, sid string) (Session, error) {
+	_, s.readDeadline = ctx.Deadline()
+	return s.noopStore.Read(ctx, sid)
+}
+
+func (s *deadlineStore) Save(ctx context.Context, sess Session) error {
+	_, s.saveDeadline = ctx.Deadline()
+	return s.noopStore.Save(ctx, sess)
+}
+
+func TestManager_StoreTimeouts(t *testing.T) {
+	ctx := context.Background()
+	store := &deadlineStore{}
+	m := newManager(store, Options{
+		StoreTimeouts: StoreTimeouts{
+			Read: time.Second,
+		},
+	})
+
+	sess, err := m.read(ctx, "1")
+	require.Nil(t, err)
+	err = m.save(ctx, sess)
+	require.Nil(t, err)
+
+	assert.True(t, store.readDeadline)
+	assert.False(t, store.saveDeadline)
+}
+
+type blockingStore struct {
+	noopStore
+	reads   atomic.Int32
+	release chan struct{}
+}
+
+func (s *blockingStore) Read(_ context.Context, sid string) (Session, error) {
+	s.reads.Add(1)
+	<-s.release
+	sess := NewBaseSessionWithData(sid, GobEncoder, nil, Data{"scope": Data{"name": "flamego"}})
+	return sess, nil
+}
+
+func TestManager_CoalesceReads(t *testing.T) {
+	ctx := context.Background()
+	store := &blockingStore{release: make(chan struct{})}
+	m := newManager(store, Options{CoalesceReads: true})
+
+	const n = 10
+	var wg sync.WaitGroup
+	sessions := make([]Session, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sess, err := m.read(ctx, "111")
+			assert.NoError(t, err)
+			sessions[i] = sess
+		}(i)
+	}
+
+	// Wait for the first read to reach the session store, and the rest to queue
+	// up behind it.
+	require.Eventually(t, func() bool { return store.reads.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	close(store.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), store.reads.Load())
+
+	// Each caller gets its own copy of the session
+	sessions[0].Get("scope").(Data)["name"] = "changed"
+	for _, sess := range sessions[1:] {
+		assert.NotSame(t, sessions[0], sess)
+		assert.Equal(t, "flamego", sess.Get("scope").(Data)["name"])
+	}
+}
+
+type unreachableStore struct {
+	noopStore
+}
+
+func (s *unreachableStore) CheckExist(context.Context, string) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestManager_StrictErrors(t *testing.T) {
+	const sid = "0123456789abcdef"
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+
+	t.Run("lenient", func(t *testing.T) {
+		var reported error
+		m := newManager(&unreachableStore{}, Options{
+			IDLength:      16,
+			NegativeCache: NegativeCacheOptions{Size: 10},
+			ErrorFunc:     func(err error) { reported = err },
+		})
+
+		sess, created, err := m.load(r, sid)
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.NotEqual(t, sid, sess.ID())
+		assert.EqualError(t, reported, "check existence: connection refused")
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		m := newManager(&unreachableStore{}, Options{
+			IDLength:      16,
+			NegativeCache: NegativeCacheOptions{Size: 10},
+			StrictErrors:  true,
+			ErrorFunc:     func(err error) { t.Fatalf("Unexpected reported error: %v", err) },
+		})
+
+		_, _, err := m.load(r, sid)
+		assert.EqualError(t, err, "check existence: connection refused")
+
+		_, err = m.loadLazy(r, sid, func(string, bool) {})
+		assert.EqualError(t, err, "check existence: connection refused")
+
+		// Errors are not cached as missing sessions
+		assert.False(t, m.negCache.contains(sid))
+	})
+}
+
+func TestReadMany(t *testing.T) {
+	ctx := context.Background()
+	store, err := FileIniter()(ctx,
+		FileConfig{RootDir: t.TempDir()},
+		IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.NoError(t, err)
+
+	sess, err := store.Read(ctx, "111")
+	require.NoError(t, err)
+	sess.Set("name", "flamego")
+	require.NoError(t, store.Save(ctx, sess))
+
+	// Session stores without session.MultiReader fall back to reading one by one
+	sessions, err := ReadMany(ctx, store, []string{"111", "222"})
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "flamego", sessions["111"].Get("name"))
+}
@@ -6,11 +6,14 @@ package session
 
 import (
 	"container/heap"
+	"container/list"
 	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/pkg/errors"
 )
 
 var _ Session = (*memorySession)(nil)
@@ -22,38 +25,68 @@ type memorySession struct {
 	lock           sync.RWMutex // The mutex to guard accesses to the lastAccessedAt
 	lastAccessedAt time.Time    // The last time of the session being accessed
 
-	index int // The index in the heap
+	index int           // The index in the heap
+	slot  int           // The slot in the timing wheel
+	elem  *list.Element // The element in the slot of the timing wheel
+
+	rekeyer rekeyer // The session store to re-index the session when its ID is regenerated
 }
 
 // newMemorySession returns a new memory session with given session ID.
 func newMemorySession(sid string, idWriter IDWriter) *memorySession {
-	return &memorySession{
+	sess := &memorySession{
 		BaseSession: NewBaseSession(sid, nil, idWriter),
 	}
+	// The session is re-indexed by the hook as views of the session (see viewOf)
+	// regenerate the session ID without knowing the memory session.
+	sess.onRegenerate = func(oldSID string) {
+		if sess.rekeyer != nil {
+			sess.rekeyer.rekey(sess, oldSID)
+		}
+	}
+	return sess
 }
 
-func (s *memorySession) LastAccessedAt() time.Time {
+func (s *memorySession) accessedAt() time.Time {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 	return s.lastAccessedAt
 }
 
-func (s *memorySession) SetLastAccessedAt(t time.Time) {
+func (s *memorySession) setAccessedAt(t time.Time) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	s.lastAccessedAt = t
 }
 
+// rekeyer is a session store that is capable of re-indexing sessions whose IDs
+// are regenerated.
+type rekeyer interface {
+	// rekey re-indexes the session that was indexed by the old session ID.
+	rekey(sess *memorySession, oldSID string)
+}
+
 var _ Store = (*memoryStore)(nil)
 
 // memoryStore is an in-memory implementation of the session store.
 type memoryStore struct {
 	nowFunc  func() time.Time // The function to return the current time
 	lifetime time.Duration    // The duration to have no access to a session before being recycled
+	budget   gcBudget         // The budget of each GC run
+	growth   gcGrowth         // The trigger of GC runs by the growth of sessions
 
 	lock  sync.RWMutex              // The mutex to guard accesses to the heap and index
 	heap  []*memorySession          // The heap to be managed by operations of heap.Interface
 	index map[string]*memorySession // The index to be managed by operations of heap.Interface
+	wheel *timeWheel                // The timing wheel to be used instead of the heap when not nil
+	// The number of sessions after the last GC run, guarded by the lock
+	gcBaseline int
+
+	autoGC  atomic.Bool               // Whether a GC run triggered by the growth of sessions is in progress
+	archive atomic.Pointer[gcArchive] // The archiving of the last GC run, reused by triggered GC runs
+
+	rekeyer   rekeyer          // The session store to re-index sessions whose IDs are regenerated, the store itself unless sharded
+	persister *memoryPersister // The persister of snapshots, nil when the persistence is disabled
 
 	idWriter IDWriter
 }
@@ -61,12 +94,27 @@ type memoryStore struct {
 // newMemoryStore returns a new memory session store based on given
 // configuration.
 func newMemoryStore(cfg MemoryConfig, idWriter IDWriter) *memoryStore {
-	return &memoryStore{
-		nowFunc:  cfg.nowFunc,
+	var wheel *timeWheel
+	if cfg.Engine == MemoryEngineTimeWheel {
+		wheel = newTimeWheel(cfg.Lifetime, cfg.NowFunc())
+	}
+	s := &memoryStore{
+		nowFunc:  cfg.NowFunc,
 		lifetime: cfg.Lifetime,
+		budget: gcBudget{
+			maxSessions: cfg.GCMaxSessions,
+			maxDuration: cfg.GCMaxDuration,
+		},
+		growth: gcGrowth{
+			percent:     cfg.GCGrowthPercent,
+			minSessions: cfg.GCGrowthMinSessions,
+		},
 		index:    make(map[string]*memorySession),
+		wheel:    wheel,
 		idWriter: idWriter,
 	}
+	s.rekeyer = s
+	return s
 }
 
 // Len implements `heap.Interface.Len`. It is not concurrent-safe and is the
@@ -80,7 +128,7 @@ func (s *memoryStore) Len() int {
 // caller's responsibility to ensure they're being guarded by a mutex during any
 // heap operation, i.e. heap.Fix, heap.Remove, heap.Push, heap.Pop.
 func (s *memoryStore) Less(i, j int) bool {
-	return s.heap[i].LastAccessedAt().Before(s.heap[j].LastAccessedAt())
+	return s.heap[i].accessedAt().Before(s.heap[j].accessedAt())
 }
 
 // Swap implements `heap.Interface.Swap`. It is not concurrent-safe and is the
@@ -118,6 +166,63 @@ func (s *memoryStore) Pop() interface{} {
 	return sess
 }
 
+// add adds the session to the expiry management. It is not concurrent-safe and
+// is the caller's responsibility to ensure the lock is held.
+func (s *memoryStore) add(sess *memorySession) {
+	if s.wheel == nil {
+		heap.Push(s, sess)
+		return
+	}
+
+	s.index[sess.sid] = sess
+	s.wheel.add(sess, sess.accessedAt().Add(s.lifetime))
+}
+
+// fix updates the session in the expiry management after its last accessed
+// time is changed. It is not concurrent-safe and is the caller's responsibility
+// to ensure the lock is held.
+func (s *memoryStore) fix(sess *memorySession) {
+	if s.wheel == nil {
+		heap.Fix(s, sess.index)
+		return
+	}
+	s.wheel.move(sess, sess.accessedAt().Add(s.lifetime))
+}
+
+// remove removes the session from the expiry management. It is not
+// concurrent-safe and is the caller's responsibility to ensure the lock is held.
+func (s *memoryStore) remove(sess *memorySession) {
+	if s.wheel == nil {
+		heap.Remove(s, sess.index)
+		return
+	}
+
+	s.wheel.remove(sess)
+	delete(s.index, sess.sid)
+}
+
+// expired returns a session that has expired, or nil if there is none. It is
+// not concurrent-safe and is the caller's responsibility to ensure the lock is
+// held.
+func (s *memoryStore) expired() *memorySession {
+	if s.wheel != nil {
+		return s.wheel.expired(s.nowFunc(), func(sess *memorySession) time.Time {
+			return sess.accessedAt().Add(s.lifetime)
+		})
+	}
+
+	if s.Len() == 0 {
+		return nil
+	}
+
+	// If the least accessed session is not expired, there is no expired session
+	sess := s.heap[0]
+	if s.nowFunc().Before(sess.accessedAt().Add(s.lifetime)) {
+		return nil
+	}
+	return sess
+}
+
 func (s *memoryStore) Exist(_ context.Context, sid string) bool {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
@@ -133,20 +238,64 @@ func (s *memoryStore) Read(_ context.Context, sid string) (Session, error) {
 	sess, ok := s.index[sid]
 	if ok {
 		// Discard existing data if it's expired
-		if !s.nowFunc().Before(sess.LastAccessedAt().Add(s.lifetime)) {
+		if !s.nowFunc().Before(sess.accessedAt().Add(s.lifetime)) {
 			sess.data = make(Data)
 		}
-		sess.SetLastAccessedAt(s.nowFunc())
-		heap.Fix(s, sess.index)
+		sess.setAccessedAt(s.nowFunc())
+		s.fix(sess)
 		return sess, nil
 	}
 
 	sess = newMemorySession(sid, s.idWriter)
-	sess.SetLastAccessedAt(s.nowFunc())
-	heap.Push(s, sess)
+	sess.rekeyer = s.rekeyer
+	sess.setAccessedAt(s.nowFunc())
+	s.add(sess)
+
+	if s.growth.exceeded(s.gcBaseline, len(s.index)) && s.autoGC.CompareAndSwap(false, true) {
+		go s.triggeredGC()
+	}
+	return sess, nil
+}
+
+var _ Peeker = (*memoryStore)(nil)
+
+func (s *memoryStore) Peek(_ context.Context, sid string) (Session, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	sess, ok := s.index[sid]
+	if !ok || !s.nowFunc().Before(sess.accessedAt().Add(s.lifetime)) {
+		return nil, nil
+	}
 	return sess, nil
 }
 
+func (s *memoryStore) rekey(sess *memorySession, oldSID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.index[oldSID] != sess {
+		return
+	}
+	delete(s.index, oldSID)
+	s.index[sess.sid] = sess
+}
+
+// take re-indexes the session that was indexed by the old session ID and
+// removes it from the store. It returns false if the session is not found.
+func (s *memoryStore) take(sess *memorySession, oldSID string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.index[oldSID] != sess {
+		return false
+	}
+	delete(s.index, oldSID)
+	s.index[sess.sid] = sess
+	s.remove(sess)
+	return true
+}
+
 func (s *memoryStore) Destroy(_ context.Context, sid string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -156,7 +305,7 @@ func (s *memoryStore) Destroy(_ context.Context, sid string) error {
 		return nil
 	}
 
-	heap.Remove(s, sess.index)
+	s.remove(sess)
 	return nil
 }
 
@@ -169,60 +318,516 @@ func (s *memoryStore) Touch(_ context.Context, sid string) error {
 		return nil
 	}
 
-	sess.SetLastAccessedAt(s.nowFunc())
-	heap.Fix(s, sess.index)
+	sess.setAccessedAt(s.nowFunc())
+	s.fix(sess)
 	return nil
 }
 
 func (s *memoryStore) Save(context.Context, Session) error { return nil }
 
+var _ Expirer = (*memoryStore)(nil)
+
+func (s *memoryStore) ExpiresAt(_ context.Context, sid string) (time.Time, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	sess, ok := s.index[sid]
+	if !ok {
+		return time.Time{}, nil
+	}
+	return sess.accessedAt().Add(s.lifetime), nil
+}
+
+var _ TagFinder = (*memoryStore)(nil)
+
+func (s *memoryStore) FindByTag(_ context.Context, key, value string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var sids []string
+	for sid, sess := range s.index {
+		if v, ok := sess.Tags()[key]; ok && v == value {
+			sids = append(sids, sid)
+		}
+	}
+	return sids, nil
+}
+
+var _ Lister = (*memoryStore)(nil)
+
+func (s *memoryStore) List(context.Context) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	sids := make([]string, 0, len(s.index))
+	for sid := range s.index {
+		sids = append(sids, sid)
+	}
+	return sids, nil
+}
+
+var _ ActiveCounter = (*memoryStore)(nil)
+
+func (s *memoryStore) CountActive(context.Context) (int, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.index), nil
+}
+
+// oldest returns the least recently accessed session, or nil if there is none.
+// It is not concurrent-safe and is the caller's responsibility to ensure the
+// lock is held.
+func (s *memoryStore) oldest() *memorySession {
+	if s.wheel != nil {
+		return s.wheel.oldest()
+	}
+	if s.Len() == 0 {
+		return nil
+	}
+	return s.heap[0]
+}
+
+var _ Evicter = (*memoryStore)(nil)
+
+func (s *memoryStore) EvictLRU(_ context.Context, n int) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	evicted := 0
+	for ; evicted < n; evicted++ {
+		sess := s.oldest()
+		if sess == nil {
+			break
+		}
+		s.remove(sess)
+	}
+	return evicted, nil
+}
+
+// gcBudget is the budget of a GC run of the memory session store.
+type gcBudget struct {
+	maxSessions int           // The maximum number of sessions to recycle, not positive means no limit
+	maxDuration time.Duration // The maximum duration to run, not positive means no limit
+}
+
+// start returns the remaining budget of a GC run that starts now.
+func (b gcBudget) start() *gcRemaining {
+	r := &gcRemaining{
+		limited:  b.maxSessions > 0,
+		sessions: b.maxSessions,
+	}
+	if b.maxDuration > 0 {
+		r.deadline = time.Now().Add(b.maxDuration)
+	}
+	return r
+}
+
+// gcGrowth is the trigger of GC runs by the growth of the number of sessions
+// since the last GC run.
+type gcGrowth struct {
+	percent     int // The growth in percentage to trigger a GC run, not positive means disabled
+	minSessions int // The minimum number of sessions to trigger a GC run
+}
+
+// exceeded returns true if the number of sessions has grown beyond the
+// threshold since the last GC run, which left the baseline number of sessions.
+func (g gcGrowth) exceeded(baseline, n int) bool {
+	if g.percent <= 0 || n < g.minSessions {
+		return false
+	}
+	return n*100 > baseline*(100+g.percent)
+}
+
+// gcArchive is the archiving of expired sessions of a GC run.
+type gcArchive struct {
+	batchSize int
+	onExpire  OnExpireFunc
+}
+
+// gcRemaining is the remaining budget of a GC run.
+type gcRemaining struct {
+	limited  bool      // Whether the number of sessions to recycle is limited
+	sessions int       // The remaining number of sessions to recycle
+	deadline time.Time // The time to stop running, zero means no deadline
+}
+
+// exhausted returns true if the budget has run out.
+func (r *gcRemaining) exhausted() bool {
+	if r.limited && r.sessions <= 0 {
+		return true
+	}
+	return !r.deadline.IsZero() && !time.Now().Before(r.deadline)
+}
+
+// spend spends the budget of one recycled session.
+func (r *gcRemaining) spend() {
+	r.sessions--
+}
+
 func (s *memoryStore) GC(ctx context.Context) error {
-	// Removing expired sessions from top of the heap until there is no more expired
-	// sessions found.
-	for {
+	return s.GCWithArchive(ctx, 0, nil)
+}
+
+var _ ExpiryArchiver = (*memoryStore)(nil)
+
+func (s *memoryStore) GCWithArchive(ctx context.Context, batchSize int, onExpire OnExpireFunc) error {
+	s.archive.Store(&gcArchive{batchSize: batchSize, onExpire: onExpire})
+	s.gc(ctx, s.budget.start(), batchSize, onExpire)
+	if s.persister != nil {
+		return s.persister.snapshot(ctx, []*memoryStore{s}, false)
+	}
+	return nil
+}
+
+var _ CapabilityReporter = (*memoryStore)(nil)
+
+// Capabilities reports taking snapshots only when the persistence is enabled.
+func (s *memoryStore) Capabilities() StoreCapabilities {
+	return StoreCapabilities{
+		List:        true,
+		ExpiresAt:   true,
+		FindByTag:   true,
+		Snapshot:    s.persister != nil,
+		ArchiveOnGC: true,
+		CountActive: true,
+		EvictLRU:    true,
+	}
+}
+
+var _ Snapshotter = (*memoryStore)(nil)
+
+func (s *memoryStore) Snapshot(ctx context.Context) error {
+	if s.persister == nil {
+		return errors.New("persistence is not enabled")
+	}
+	return s.persister.snapshot(ctx, []*memoryStore{s}, true)
+}
+
+// triggeredGC performs a GC run that is triggered by the growth of sessions,
+// which archives expired sessions in the same way as the last GC run. Snapshots
+// are left to regular GC runs.
+func (s *memoryStore) triggeredGC() {
+	defer s.autoGC.Store(false)
+
+	var archive gcArchive
+	if a := s.archive.Load(); a != nil {
+		archive = *a
+	}
+	s.gc(context.Background(), s.budget.start(), archive.batchSize, archive.onExpire)
+}
+
+// gc removes expired sessions until there is no more expired sessions or the
+// budget has run out, the rest are left to the next GC run. Expired sessions
+// are removed in batches of given size, and the onExpire is called for each of
+// them after each batch if it is not nil.
+func (s *memoryStore) gc(ctx context.Context, budget *gcRemaining, batchSize int, onExpire OnExpireFunc) {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	defer func() {
+		s.lock.Lock()
+		s.gcBaseline = len(s.index)
+		s.lock.Unlock()
+	}()
+
+	// Removing expired sessions until there is no more expired sessions found.
+	for !budget.exhausted() {
 		select {
 		case <-ctx.Done():
-			return nil
+			return
 		default:
 		}
 
-		done := func() bool {
+		batch := func() []*memorySession {
 			s.lock.Lock()
 			defer s.lock.Unlock()
 
-			if s.Len() == 0 {
-				return true
+			var batch []*memorySession
+			for len(batch) < batchSize && !budget.exhausted() {
+				sess := s.expired()
+				if sess == nil {
+					break
+				}
+
+				s.remove(sess)
+				budget.spend()
+				batch = append(batch, sess)
+			}
+			return batch
+		}()
+		if onExpire != nil {
+			for _, sess := range batch {
+				onExpire(ctx, sess.ID(), sess.Data())
 			}
+		}
+		if len(batch) < batchSize {
+			break
+		}
+	}
+}
 
-			sess := s.heap[0]
+var _ Store = (*shardedMemoryStore)(nil)
 
-			// If the least accessed session is not expired, there is no need to continue
-			if s.nowFunc().Before(sess.LastAccessedAt().Add(s.lifetime)) {
-				return true
-			}
+// shardedMemoryStore is an in-memory implementation of the session store that
+// distributes sessions to independent shards by the hash of session IDs, which
+// reduces lock contention on machines with many cores.
+type shardedMemoryStore struct {
+	shards    []*memoryStore
+	next      atomic.Uint32    // The counter of GC runs to determine the first shard to recycle
+	persister *memoryPersister // The persister of snapshots, nil when the persistence is disabled
+}
 
-			heap.Remove(s, sess.index)
-			return false
-		}()
-		if done {
+// newShardedMemoryStore returns a new sharded memory session store based on
+// given configuration.
+func newShardedMemoryStore(cfg MemoryConfig, idWriter IDWriter) *shardedMemoryStore {
+	s := &shardedMemoryStore{
+		shards: make([]*memoryStore, cfg.Shards),
+	}
+	for i := range s.shards {
+		s.shards[i] = newMemoryStore(cfg, idWriter)
+		s.shards[i].rekeyer = s
+	}
+	return s
+}
+
+// shard returns the shard that the session with given ID belongs to.
+func (s *shardedMemoryStore) shard(sid string) *memoryStore {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(sid))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+func (s *shardedMemoryStore) Exist(ctx context.Context, sid string) bool {
+	return s.shard(sid).Exist(ctx, sid)
+}
+
+func (s *shardedMemoryStore) Read(ctx context.Context, sid string) (Session, error) {
+	return s.shard(sid).Read(ctx, sid)
+}
+
+var _ Peeker = (*shardedMemoryStore)(nil)
+
+func (s *shardedMemoryStore) Peek(ctx context.Context, sid string) (Session, error) {
+	return s.shard(sid).Peek(ctx, sid)
+}
+
+func (s *shardedMemoryStore) Destroy(ctx context.Context, sid string) error {
+	return s.shard(sid).Destroy(ctx, sid)
+}
+
+func (s *shardedMemoryStore) Touch(ctx context.Context, sid string) error {
+	return s.shard(sid).Touch(ctx, sid)
+}
+
+func (s *shardedMemoryStore) Save(context.Context, Session) error { return nil }
+
+// rekey moves the session to the shard of its new ID.
+func (s *shardedMemoryStore) rekey(sess *memorySession, oldSID string) {
+	from, to := s.shard(oldSID), s.shard(sess.ID())
+	if from == to {
+		from.rekey(sess, oldSID)
+		return
+	}
+
+	if !from.take(sess, oldSID) {
+		return
+	}
+	to.lock.Lock()
+	defer to.lock.Unlock()
+	to.add(sess)
+}
+
+func (s *shardedMemoryStore) GC(ctx context.Context) error {
+	return s.GCWithArchive(ctx, 0, nil)
+}
+
+var _ ExpiryArchiver = (*shardedMemoryStore)(nil)
+
+func (s *shardedMemoryStore) GCWithArchive(ctx context.Context, batchSize int, onExpire OnExpireFunc) error {
+	// The budget is shared by all shards, start from a different shard in each run
+	// so that every shard gets its turn when the budget runs out.
+	budget := s.shards[0].budget.start()
+	archive := &gcArchive{batchSize: batchSize, onExpire: onExpire}
+	for _, shard := range s.shards {
+		shard.archive.Store(archive)
+	}
+	offset := int(s.next.Add(1))
+	for i := range s.shards {
+		if budget.exhausted() {
 			break
 		}
+		s.shards[(offset+i)%len(s.shards)].gc(ctx, budget, batchSize, onExpire)
+	}
+	if s.persister != nil {
+		return s.persister.snapshot(ctx, s.shards, false)
 	}
 	return nil
 }
 
+var _ CapabilityReporter = (*shardedMemoryStore)(nil)
+
+// Capabilities reports taking snapshots only when the persistence is enabled.
+func (s *shardedMemoryStore) Capabilities() StoreCapabilities {
+	return StoreCapabilities{
+		List:        true,
+		ExpiresAt:   true,
+		FindByTag:   true,
+		Snapshot:    s.persister != nil,
+		ArchiveOnGC: true,
+		CountActive: true,
+		EvictLRU:    true,
+	}
+}
+
+var _ Snapshotter = (*shardedMemoryStore)(nil)
+
+func (s *shardedMemoryStore) Snapshot(ctx context.Context) error {
+	if s.persister == nil {
+		return errors.New("persistence is not enabled")
+	}
+	return s.persister.snapshot(ctx, s.shards, true)
+}
+
+var _ Expirer = (*shardedMemoryStore)(nil)
+
+func (s *shardedMemoryStore) ExpiresAt(ctx context.Context, sid string) (time.Time, error) {
+	return s.shard(sid).ExpiresAt(ctx, sid)
+}
+
+var _ TagFinder = (*shardedMemoryStore)(nil)
+
+func (s *shardedMemoryStore) FindByTag(ctx context.Context, key, value string) ([]string, error) {
+	var sids []string
+	for _, shard := range s.shards {
+		found, err := shard.FindByTag(ctx, key, value)
+		if err != nil {
+			return nil, err
+		}
+		sids = append(sids, found...)
+	}
+	return sids, nil
+}
+
+var _ Lister = (*shardedMemoryStore)(nil)
+
+func (s *shardedMemoryStore) List(ctx context.Context) ([]string, error) {
+	var sids []string
+	for _, shard := range s.shards {
+		found, err := shard.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		sids = append(sids, found...)
+	}
+	return sids, nil
+}
+
+var _ ActiveCounter = (*shardedMemoryStore)(nil)
+
+func (s *shardedMemoryStore) CountActive(ctx context.Context) (int, error) {
+	total := 0
+	for _, shard := range s.shards {
+		n, err := shard.CountActive(ctx)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+var _ Evicter = (*shardedMemoryStore)(nil)
+
+// EvictLRU evicts sessions one at a time from the shard whose least recently
+// accessed session is the oldest across shards.
+func (s *shardedMemoryStore) EvictLRU(ctx context.Context, n int) (int, error) {
+	evicted := 0
+	for evicted < n {
+		var oldest *memoryStore
+		var oldestAt time.Time
+		for _, shard := range s.shards {
+			shard.lock.RLock()
+			sess := shard.oldest()
+			shard.lock.RUnlock()
+			if sess == nil {
+				continue
+			}
+			if at := sess.accessedAt(); oldest == nil || at.Before(oldestAt) {
+				oldest, oldestAt = shard, at
+			}
+		}
+		if oldest == nil {
+			break
+		}
+
+		_, err := oldest.EvictLRU(ctx, 1)
+		if err != nil {
+			return evicted, err
+		}
+		evicted++
+	}
+	return evicted, nil
+}
+
 // MemoryConfig contains options for the memory session store.
 type MemoryConfig struct {
-	nowFunc func() time.Time // For tests only
-
 	// Lifetime is the duration to have no access to a session before being
 	// recycled. Default is 3600 seconds.
 	Lifetime time.Duration
+	// NowFunc is the function to return the current time for expiry of sessions,
+	// e.g. to simulate the passage of time in integration tests. Default is
+	// time.Now.
+	NowFunc func() time.Time
+	// Shards is the number of shards to distribute sessions to by the hash of
+	// session IDs, each shard has its own lock and expiry heap. Using more shards
+	// reduces lock contention on machines with many cores, e.g. the number of
+	// CPU cores. Default is 1, i.e. not sharded.
+	Shards int
+	// GCMaxSessions is the maximum number of expired sessions to be recycled in
+	// each GC run, the rest are recycled in subsequent runs. It prevents a huge
+	// backlog of expired sessions from starving requests. Default is no limit.
+	GCMaxSessions int
+	// GCMaxDuration is the maximum duration of each GC run, the rest of expired
+	// sessions are recycled in subsequent runs. Default is no limit.
+	GCMaxDuration time.Duration
+	// GCGrowthPercent is the growth in percentage of the number of sessions since
+	// the last GC run, beyond which a GC run is triggered in the background
+	// besides the regular ones, e.g. 50 to trigger when sessions have grown by
+	// half. It keeps spikes of sessions between regular GC runs from ballooning
+	// memory. Triggered GC runs archive expired sessions in the same way as the
+	// last regular GC run (see Options.OnExpire). The threshold applies to each
+	// shard individually when sharded. Default is 0, i.e. disabled.
+	GCGrowthPercent int
+	// GCGrowthMinSessions is the minimum number of sessions (of each shard when
+	// sharded) to trigger GC runs by GCGrowthPercent, which avoids frequent GC
+	// runs while there are few sessions. Default is 1000.
+	GCGrowthMinSessions int
+	// Engine is the engine to manage expiry of sessions. Default is
+	// MemoryEngineHeap.
+	Engine MemoryEngine
+	// Persistence is the options for persisting sessions with periodic snapshots,
+	// which are restored on startup. Default is disabled.
+	Persistence MemoryPersistence
 }
 
+// MemoryEngine is the engine to manage expiry of sessions in the memory session
+// store.
+type MemoryEngine int
+
+const (
+	// MemoryEngineHeap manages expiry of sessions with a binary heap, which takes
+	// O(log n) time for inserting and touching sessions.
+	MemoryEngineHeap MemoryEngine = iota
+	// MemoryEngineTimeWheel manages expiry of sessions with a timing wheel, which
+	// takes O(1) time for inserting and touching sessions and is more suitable for
+	// millions of sessions. Sessions may be recycled by GC up to 1/256 of the
+	// lifetime later than they expire, but expired sessions are never read.
+	MemoryEngineTimeWheel
+)
+
 // MemoryIniter returns the Initer for the memory session store.
 func MemoryIniter() Initer {
-	return func(_ context.Context, args ...interface{}) (Store, error) {
+	return func(ctx context.Context, args ...interface{}) (Store, error) {
 		var cfg *MemoryConfig
 		var idWriter IDWriter
 		for i := range args {
@@ -241,13 +846,37 @@ func MemoryIniter() Initer {
 			cfg = &MemoryConfig{}
 		}
 
-		if cfg.nowFunc == nil {
-			cfg.nowFunc = time.Now
+		if cfg.NowFunc == nil {
+			cfg.NowFunc = time.Now
 		}
 		if cfg.Lifetime.Seconds() < 1 {
 			cfg.Lifetime = 3600 * time.Second
 		}
+		if cfg.GCGrowthMinSessions < 1 {
+			cfg.GCGrowthMinSessions = 1000
+		}
 
-		return newMemoryStore(*cfg, idWriter), nil
+		persister := newMemoryPersister(cfg.Persistence, cfg.NowFunc)
+		if cfg.Shards > 1 {
+			store := newShardedMemoryStore(*cfg, idWriter)
+			store.persister = persister
+			if persister != nil {
+				err := persister.restore(ctx, store.shard)
+				if err != nil {
+					return nil, fmt.Errorf("restore: %w", err)
+				}
+			}
+			return store, nil
+		}
+
+		store := newMemoryStore(*cfg, idWriter)
+		store.persister = persister
+		if persister != nil {
+			err := persister.restore(ctx, func(string) *memoryStore { return store })
+			if err != nil {
+				return nil, fmt.Errorf("restore: %w", err)
+			}
+		}
+		return store, nil
 	}
 }
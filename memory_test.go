@@ -7,8 +7,13 @@ package session
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -77,7 +82,7 @@ func TestMemoryStore_GC(t *testing.T) {
 	now := time.Now()
 	store := newMemoryStore(
 		MemoryConfig{
-			nowFunc:  func() time.Time { return now },
+			NowFunc:  func() time.Time { return now },
 			Lifetime: time.Second,
 		},
 		nil,
@@ -118,12 +123,138 @@ func TestMemoryStore_GC(t *testing.T) {
 	assert.Equal(t, wantIndex, store.index)
 }
 
+func TestMemoryStore_GCBudget(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := newMemoryStore(
+		MemoryConfig{
+			NowFunc:       func() time.Time { return now },
+			Lifetime:      time.Second,
+			GCMaxSessions: 2,
+		},
+		nil,
+	)
+
+	for _, sid := range []string{"1", "2", "3", "4", "5"} {
+		_, err := store.Read(ctx, sid)
+		require.Nil(t, err)
+	}
+
+	// Each GC run should recycle at most two sessions and resume in the next run
+	now = now.Add(2 * time.Second)
+	for _, want := range []int{3, 1, 0} {
+		err := store.GC(ctx)
+		require.Nil(t, err)
+		assert.Equal(t, want, store.Len())
+	}
+}
+
+func TestMemoryStore_GCWithArchive(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := newMemoryStore(
+		MemoryConfig{
+			NowFunc:  func() time.Time { return now },
+			Lifetime: time.Second,
+		},
+		nil,
+	)
+
+	for _, sid := range []string{"1", "2", "3"} {
+		sess, err := store.Read(ctx, sid)
+		require.Nil(t, err)
+		sess.Set("cart", "cart of "+sid)
+	}
+	now = now.Add(2 * time.Second)
+	_, err := store.Read(ctx, "4")
+	require.Nil(t, err)
+
+	archived := make(map[string]interface{})
+	err = store.GCWithArchive(ctx, 2, func(_ context.Context, sid string, data Data) {
+		archived[sid] = data["cart"]
+	})
+	require.Nil(t, err)
+	assert.Equal(t,
+		map[string]interface{}{
+			"1": "cart of 1",
+			"2": "cart of 2",
+			"3": "cart of 3",
+		},
+		archived,
+	)
+	assert.Equal(t, 1, store.Len())
+}
+
+func TestMemoryStore_GCGrowth(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := newMemoryStore(
+		MemoryConfig{
+			NowFunc:             func() time.Time { return now },
+			Lifetime:            time.Second,
+			GCGrowthPercent:     50,
+			GCGrowthMinSessions: 4,
+		},
+		nil,
+	)
+
+	for _, sid := range []string{"1", "2", "3"} {
+		_, err := store.Read(ctx, sid)
+		require.Nil(t, err)
+	}
+
+	var mu sync.Mutex
+	var archived []string
+	err := store.GCWithArchive(ctx, 0, func(_ context.Context, sid string, _ Data) {
+		mu.Lock()
+		defer mu.Unlock()
+		archived = append(archived, sid)
+	})
+	require.Nil(t, err)
+	assert.Equal(t, 3, store.Len())
+
+	// Growing from 3 to 4 sessions is within the threshold
+	now = now.Add(2 * time.Second)
+	_, err = store.Read(ctx, "4")
+	require.Nil(t, err)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 4, store.Len())
+
+	// Growing to 5 sessions triggers a GC run that archives in the same way as
+	// the last GC run
+	_, err = store.Read(ctx, "5")
+	require.Nil(t, err)
+	assert.Eventually(t,
+		func() bool {
+			n, err := store.CountActive(ctx)
+			return err == nil && n == 2
+		},
+		time.Second,
+		10*time.Millisecond,
+	)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.ElementsMatch(t, []string{"1", "2", "3"}, archived)
+}
+
+func TestGCGrowth_Exceeded(t *testing.T) {
+	g := gcGrowth{percent: 50, minSessions: 10}
+	assert.False(t, g.exceeded(0, 9))
+	assert.True(t, g.exceeded(0, 10))
+	assert.False(t, g.exceeded(10, 15))
+	assert.True(t, g.exceeded(10, 16))
+
+	// Disabled
+	assert.False(t, gcGrowth{minSessions: 10}.exceeded(0, 100))
+}
+
 func TestMemoryStore_Touch(t *testing.T) {
 	ctx := context.Background()
 	now := time.Now()
 	store := newMemoryStore(
 		MemoryConfig{
-			nowFunc:  func() time.Time { return now },
+			NowFunc:  func() time.Time { return now },
 			Lifetime: time.Second,
 		},
 		nil,
@@ -143,3 +274,331 @@ func TestMemoryStore_Touch(t *testing.T) {
 	wantHeap := []*memorySession{sess.(*memorySession)}
 	assert.Equal(t, wantHeap, store.heap)
 }
+
+func TestMemoryStore_List(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore(
+		MemoryConfig{
+			NowFunc:  time.Now,
+			Lifetime: time.Second,
+		},
+		nil,
+	)
+
+	_, err := store.Read(ctx, "1")
+	require.Nil(t, err)
+	_, err = store.Read(ctx, "2")
+	require.Nil(t, err)
+
+	sids, err := store.List(ctx)
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []string{"1", "2"}, sids)
+}
+
+func TestMemoryStore_EvictLRU(t *testing.T) {
+	for _, cfg := range []MemoryConfig{
+		{Engine: MemoryEngineHeap},
+		{Engine: MemoryEngineTimeWheel},
+		{Shards: 4},
+	} {
+		t.Run(fmt.Sprintf("engine=%d,shards=%d", cfg.Engine, cfg.Shards), func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			cfg.NowFunc = func() time.Time { return now }
+			cfg.Lifetime = time.Hour
+			store, err := MemoryIniter()(ctx, cfg, IDWriter(func(http.ResponseWriter, *http.Request, string) {}))
+			require.Nil(t, err)
+
+			for _, sid := range []string{"111", "222", "333", "444"} {
+				_, err = store.Read(ctx, sid)
+				require.Nil(t, err)
+				now = now.Add(time.Minute)
+			}
+			err = store.Touch(ctx, "111")
+			require.Nil(t, err)
+
+			n, err := store.(ActiveCounter).CountActive(ctx)
+			require.Nil(t, err)
+			assert.Equal(t, 4, n)
+
+			// The least recently used sessions are evicted first
+			evicted, err := store.(Evicter).EvictLRU(ctx, 2)
+			require.Nil(t, err)
+			assert.Equal(t, 2, evicted)
+
+			sids, err := store.(Lister).List(ctx)
+			require.Nil(t, err)
+			assert.ElementsMatch(t, []string{"111", "444"}, sids)
+
+			evicted, err = store.(Evicter).EvictLRU(ctx, 3)
+			require.Nil(t, err)
+			assert.Equal(t, 2, evicted)
+
+			n, err = store.(ActiveCounter).CountActive(ctx)
+			require.Nil(t, err)
+			assert.Zero(t, n)
+		})
+	}
+}
+
+func TestMemoryStore_TimeWheel(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store, err := MemoryIniter()(ctx,
+		MemoryConfig{
+			NowFunc:  func() time.Time { return now },
+			Lifetime: time.Minute,
+			Engine:   MemoryEngineTimeWheel,
+		},
+		IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	for _, sid := range []string{"1", "2", "3"} {
+		_, err = store.Read(ctx, sid)
+		require.Nil(t, err)
+	}
+
+	now = now.Add(30 * time.Second)
+	_, err = store.Read(ctx, "4")
+	require.Nil(t, err)
+	err = store.Touch(ctx, "2")
+	require.Nil(t, err)
+	err = store.Destroy(ctx, "3")
+	require.Nil(t, err)
+
+	// Only the session that is neither touched nor destroyed should be recycled
+	now = now.Add(31 * time.Second)
+	err = store.GC(ctx)
+	require.Nil(t, err)
+
+	sids, err := store.(Lister).List(ctx)
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []string{"2", "4"}, sids)
+
+	// GC after a long pause should not miss any session
+	now = now.Add(time.Hour)
+	err = store.GC(ctx)
+	require.Nil(t, err)
+
+	sids, err = store.(Lister).List(ctx)
+	require.Nil(t, err)
+	assert.Empty(t, sids)
+}
+
+func TestShardedMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store, err := MemoryIniter()(ctx,
+		MemoryConfig{
+			NowFunc:  func() time.Time { return now },
+			Lifetime: time.Second,
+			Shards:   4,
+		},
+		IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+	require.IsType(t, &shardedMemoryStore{}, store)
+
+	sids := []string{"1", "2", "3", "4", "5", "6", "7", "8"}
+	for _, sid := range sids {
+		sess, err := store.Read(ctx, sid)
+		require.Nil(t, err)
+		sess.Set("sid", sid)
+	}
+
+	listed, err := store.(Lister).List(ctx)
+	require.Nil(t, err)
+	assert.ElementsMatch(t, sids, listed)
+
+	sess, err := store.Read(ctx, "3")
+	require.Nil(t, err)
+	assert.Equal(t, "3", sess.Get("sid"))
+
+	now = now.Add(2 * time.Second)
+	err = store.Touch(ctx, "5")
+	require.Nil(t, err)
+	err = store.GC(ctx)
+	require.Nil(t, err)
+
+	listed, err = store.(Lister).List(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"5"}, listed)
+}
+
+func BenchmarkMemoryStore(b *testing.B) {
+	const numSessions = 10000
+	sids := make([]string, numSessions)
+	for i := range sids {
+		sids[i] = strconv.Itoa(i)
+	}
+
+	engines := []struct {
+		name   string
+		engine MemoryEngine
+	}{
+		{name: "heap", engine: MemoryEngineHeap},
+		{name: "timewheel", engine: MemoryEngineTimeWheel},
+	}
+	for _, shards := range []int{1, 16, 64} {
+		for _, e := range engines {
+			b.Run(fmt.Sprintf("shards=%d/engine=%s", shards, e.name), func(b *testing.B) {
+				benchmarkMemoryStore(b, sids, MemoryConfig{Shards: shards, Engine: e.engine})
+			})
+		}
+	}
+}
+
+func benchmarkMemoryStore(b *testing.B, sids []string, cfg MemoryConfig) {
+	ctx := context.Background()
+	store, err := MemoryIniter()(ctx, cfg, IDWriter(func(http.ResponseWriter, *http.Request, string) {}))
+	require.Nil(b, err)
+
+	for _, sid := range sids {
+		_, err = store.Read(ctx, sid)
+		require.Nil(b, err)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			sid := sids[i%len(sids)]
+			_, _ = store.Read(ctx, sid)
+			_ = store.Touch(ctx, sid)
+			i++
+		}
+	})
+}
+
+// BenchmarkMemoryStore_Shards compares the unsharded store against one shard
+// per CPU core under concurrent requests that read, update and save sessions.
+// Run with -cpu to see how the contention scales, e.g. -cpu 1,8,64.
+func BenchmarkMemoryStore_Shards(b *testing.B) {
+	const numSessions = 10000
+	sids := make([]string, numSessions)
+	for i := range sids {
+		sids[i] = strconv.Itoa(i)
+	}
+
+	for _, shards := range []int{1, runtime.GOMAXPROCS(0)} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			ctx := context.Background()
+			store, err := MemoryIniter()(ctx, MemoryConfig{Shards: shards}, IDWriter(func(http.ResponseWriter, *http.Request, string) {}))
+			require.Nil(b, err)
+
+			for _, sid := range sids {
+				_, err = store.Read(ctx, sid)
+				require.Nil(b, err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					sess, err := store.Read(ctx, sids[i%numSessions])
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					sess.Set("index", i)
+					_ = store.Save(ctx, sess)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestMemoryStore_Persistence(t *testing.T) {
+	ctx := context.Background()
+	idWriter := IDWriter(func(http.ResponseWriter, *http.Request, string) {})
+	for _, shards := range []int{1, 4} {
+		t.Run(fmt.Sprintf("shards=%d", shards), func(t *testing.T) {
+			now := time.Now()
+			cfg := MemoryConfig{
+				Shards:  shards,
+				NowFunc: func() time.Time { return now },
+				Persistence: MemoryPersistence{
+					Storage: FileSnapshotStorage(filepath.Join(t.TempDir(), "sessions.snapshot")),
+				},
+			}
+
+			store, err := MemoryIniter()(ctx, cfg, idWriter)
+			require.NoError(t, err)
+
+			for i := 0; i < 10; i++ {
+				sess, err := store.Read(ctx, fmt.Sprintf("%d", i))
+				require.NoError(t, err)
+				sess.Set("index", i)
+				Tag(sess, "group", strconv.Itoa(i%2))
+			}
+			require.NoError(t, store.(Snapshotter).Snapshot(ctx))
+
+			// Sessions are restored by a new store
+			restored, err := MemoryIniter()(ctx, cfg, idWriter)
+			require.NoError(t, err)
+
+			sids, err := restored.(Lister).List(ctx)
+			require.NoError(t, err)
+			assert.Len(t, sids, 10)
+
+			sess, err := restored.Read(ctx, "7")
+			require.NoError(t, err)
+			assert.Equal(t, 7, sess.Get("index"))
+
+			sids, err = restored.(TagFinder).FindByTag(ctx, "group", "1")
+			require.NoError(t, err)
+			assert.Len(t, sids, 5)
+
+			// Snapshots are taken at most once per interval during GC runs
+			require.NoError(t, restored.Destroy(ctx, "7"))
+			require.NoError(t, restored.GC(ctx))
+			restored, err = MemoryIniter()(ctx, cfg, idWriter)
+			require.NoError(t, err)
+			assert.True(t, restored.Exist(ctx, "7"))
+
+			require.NoError(t, store.Destroy(ctx, "7"))
+			require.NoError(t, store.(Snapshotter).Snapshot(ctx))
+			restored, err = MemoryIniter()(ctx, cfg, idWriter)
+			require.NoError(t, err)
+			assert.False(t, restored.Exist(ctx, "7"))
+
+			// The interval is measured by the configured clock
+			require.NoError(t, store.Destroy(ctx, "8"))
+			now = now.Add(2 * time.Minute)
+			require.NoError(t, store.GC(ctx))
+			restored, err = MemoryIniter()(ctx, cfg, idWriter)
+			require.NoError(t, err)
+			assert.False(t, restored.Exist(ctx, "8"))
+		})
+	}
+}
+
+func TestMemoryStore_RegenerateID(t *testing.T) {
+	ctx := context.Background()
+	idWriter := IDWriter(func(http.ResponseWriter, *http.Request, string) {})
+	for _, shards := range []int{1, 4} {
+		t.Run(fmt.Sprintf("shards=%d", shards), func(t *testing.T) {
+			store, err := MemoryIniter()(ctx, MemoryConfig{Shards: shards}, idWriter)
+			require.NoError(t, err)
+
+			for i := 0; i < 10; i++ {
+				sess, err := store.Read(ctx, fmt.Sprintf("%016d", i))
+				require.NoError(t, err)
+				sess.Set("index", i)
+
+				oldSID := sess.ID()
+				require.NoError(t, sess.RegenerateID(nil, nil))
+				assert.False(t, store.Exist(ctx, oldSID))
+
+				sess, err = store.Read(ctx, sess.ID())
+				require.NoError(t, err)
+				assert.Equal(t, i, sess.Get("index"))
+				require.NoError(t, store.Destroy(ctx, sess.ID()))
+				assert.False(t, store.Exist(ctx, sess.ID()))
+			}
+		})
+	}
+}
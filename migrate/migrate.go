@@ -0,0 +1,175 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package migrate provides a helper for moving sessions from one session store
+// to another without logging users out.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/flamego/session"
+)
+
+// ErrNotSupported is returned when the source session store is not capable of
+// listing sessions.
+var ErrNotSupported = errors.New("listing sessions is not supported by the source session store")
+
+// Options contains options for migrating sessions.
+type Options struct {
+	// Rate is the maximum number of sessions to be migrated per second. Default is
+	// no limit.
+	Rate int
+	// StartAfter is the session ID after which to start the migration, sessions
+	// are migrated in ascending order of their IDs. It is used to resume a
+	// migration with the Result.LastID of a previous run.
+	StartAfter string
+	// Overwrite indicates whether to overwrite sessions that already exist in the
+	// destination session store. Default is to skip them.
+	Overwrite bool
+	// DryRun indicates whether to only report what would be migrated without
+	// writing to the destination session store.
+	DryRun bool
+	// OnProgress is called after each session is processed, with the session ID
+	// and whether the session is migrated (or would be migrated in dry-run mode).
+	OnProgress func(sid string, migrated bool)
+}
+
+// Result is the result of a migration.
+type Result struct {
+	// Migrated is the number of sessions that are migrated, or would be migrated
+	// in dry-run mode.
+	Migrated int
+	// Skipped is the number of sessions that are skipped because they are empty,
+	// expired or already exist in the destination session store.
+	Skipped int
+	// LastID is the ID of the last session processed. Pass it as
+	// Options.StartAfter to resume an interrupted migration.
+	LastID string
+}
+
+// Migrate copies sessions from the source session store to the destination
+// session store. The source session store must implement session.Lister. Data
+// and tags of each session are re-encoded by the destination session store,
+// and the expiry time starts over with the lifetime of the destination session
+// store. Counters maintained by session stores are not migrated.
+//
+// The migration stops at the first error and returns the result so far, which
+// can be used to resume the migration.
+func Migrate(ctx context.Context, src, dst session.Store, opts Options) (Result, error) {
+	var result Result
+
+	lister, ok := session.StoreAs[session.Lister](src)
+	if !ok || !session.Capabilities(src).List {
+		return result, ErrNotSupported
+	}
+
+	sids, err := lister.List(ctx)
+	if err != nil {
+		return result, fmt.Errorf("list: %w", err)
+	}
+	sort.Strings(sids)
+
+	var ticker *time.Ticker
+	if opts.Rate > 0 {
+		ticker = time.NewTicker(time.Second / time.Duration(opts.Rate))
+		defer ticker.Stop()
+	}
+
+	for _, sid := range sids {
+		if sid <= opts.StartAfter {
+			continue
+		}
+
+		if ticker != nil {
+			select {
+			case <-ctx.Done():
+				return result, ctx.Err()
+			case <-ticker.C:
+			}
+		} else if err = ctx.Err(); err != nil {
+			return result, err
+		}
+
+		migrated, err := migrate(ctx, src, dst, sid, opts)
+		if err != nil {
+			return result, fmt.Errorf("migrate %q: %w", sid, err)
+		}
+
+		if migrated {
+			result.Migrated++
+		} else {
+			result.Skipped++
+		}
+		result.LastID = sid
+
+		if opts.OnProgress != nil {
+			opts.OnProgress(sid, migrated)
+		}
+	}
+	return result, nil
+}
+
+// migrate copies the session with given ID from the source session store to the
+// destination session store. It returns false if the session is skipped.
+func migrate(ctx context.Context, src, dst session.Store, sid string, opts Options) (bool, error) {
+	if !opts.Overwrite {
+		exist, err := session.CheckExist(ctx, dst, sid)
+		if err != nil {
+			return false, fmt.Errorf("check existence: %w", err)
+		} else if exist {
+			return false, nil
+		}
+	}
+
+	if expirer, ok := session.StoreAs[session.Expirer](src); ok {
+		expiresAt, err := expirer.ExpiresAt(ctx, sid)
+		if err != nil {
+			return false, fmt.Errorf("get expiry time: %w", err)
+		} else if !expiresAt.IsZero() && !time.Now().Before(expiresAt) {
+			return false, nil
+		}
+	}
+
+	sess, err := src.Read(ctx, sid)
+	if err != nil {
+		return false, fmt.Errorf("read: %w", err)
+	}
+
+	ds, ok := sess.(interface{ Data() session.Data })
+	if !ok {
+		return false, fmt.Errorf("session with the type %T does not expose its data", sess)
+	}
+	data := ds.Data()
+	tags := session.TagsOf(sess)
+	if len(data) == 0 && len(tags) == 0 {
+		return false, nil
+	}
+
+	if opts.DryRun {
+		return true, nil
+	}
+
+	to, err := dst.Read(ctx, sid)
+	if err != nil {
+		return false, fmt.Errorf("read destination: %w", err)
+	}
+	to.Flush()
+	for k, v := range data {
+		to.Set(k, v)
+	}
+	for k, v := range tags {
+		session.Tag(to, k, v)
+	}
+
+	err = dst.Save(ctx, to)
+	if err != nil {
+		return false, fmt.Errorf("save: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/session"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	idWriter := session.IDWriter(func(http.ResponseWriter, *http.Request, string) {})
+	src, err := session.FileIniter()(ctx, session.FileConfig{RootDir: t.TempDir()}, idWriter)
+	require.NoError(t, err)
+	dst, err := session.FileIniter()(ctx, session.FileConfig{RootDir: t.TempDir()}, idWriter)
+	require.NoError(t, err)
+
+	for sid, username := range map[string]string{"111": "alice", "222": "bob", "333": ""} {
+		sess, err := src.Read(ctx, sid)
+		require.NoError(t, err)
+		if username != "" {
+			sess.Set("username", username)
+			session.Tag(sess, "device", "mobile")
+		}
+		err = src.Save(ctx, sess)
+		require.NoError(t, err)
+	}
+
+	// Dry-run should not write anything
+	result, err := Migrate(ctx, src, dst, Options{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Migrated: 2, Skipped: 1, LastID: "333"}, result)
+	assert.False(t, dst.Exist(ctx, "111"))
+
+	// Resume after the first session
+	var progress []string
+	result, err = Migrate(ctx, src, dst,
+		Options{
+			Rate:       1000,
+			StartAfter: "111",
+			OnProgress: func(sid string, migrated bool) {
+				progress = append(progress, sid)
+			},
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Migrated: 1, Skipped: 1, LastID: "333"}, result)
+	assert.Equal(t, []string{"222", "333"}, progress)
+	assert.False(t, dst.Exist(ctx, "111"))
+	assert.False(t, dst.Exist(ctx, "333"))
+
+	sess, err := dst.Read(ctx, "222")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", sess.Get("username"))
+
+	// Existing sessions are skipped unless overwriting
+	result, err = Migrate(ctx, src, dst, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Migrated: 1, Skipped: 2, LastID: "333"}, result)
+}
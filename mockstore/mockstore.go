@@ -0,0 +1,288 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package mockstore provides a session store wrapper with scriptable behaviors
+// for chaos testing, including latency injection, error rates per method and
+// call recording.
+package mockstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/flamego/session"
+)
+
+// ErrInjected is the default error returned by methods that are failed by
+// their error rates.
+var ErrInjected = errors.New("mockstore: injected error")
+
+// Behavior is the scripted behavior of a method of the mock session store.
+type Behavior struct {
+	// Latency is the minimum time that each call of the method takes unless the
+	// context is done.
+	Latency time.Duration
+	// Jitter is the maximum random time that is added to the Latency.
+	Jitter time.Duration
+	// ErrorRate is the probability between 0 and 1 that a call of the method
+	// fails without calling the underlying session store.
+	ErrorRate float64
+	// Err is the error returned by failed calls. Default is ErrInjected.
+	Err error
+}
+
+// Call is a recorded call of a method of the mock session store.
+type Call struct {
+	// Method is the name of the method, e.g. "Save".
+	Method string
+	// SessionID is the session ID that the call is made for, empty for GC.
+	SessionID string
+	// Err is the error returned by the call. Calls of Exist that are failed are
+	// recorded with the error that is returned by CheckExist.
+	Err error
+	// Duration is the time that the call took, including the injected latency.
+	Duration time.Duration
+}
+
+// Config contains options for the mock session store.
+type Config struct {
+	// Initer is the Initer of the underlying session store. Default is
+	// session.MemoryIniter().
+	Initer session.Initer
+	// Config is the configuration of the underlying session store, e.g.
+	// session.MemoryConfig. Default is not set.
+	Config interface{}
+	// Seed is the seed of the random source that decides jitters and failures,
+	// thus the same seed reproduces the same sequence of behaviors.
+	Seed int64
+	// Behaviors are the initial behaviors indexed by method names (e.g. "Save"),
+	// an empty method name applies to methods that have no behavior of their own.
+	Behaviors map[string]Behavior
+}
+
+var _ session.Store = (*Store)(nil)
+
+// Store is a session store wrapper with scriptable behaviors. Behaviors only
+// apply to methods of the session.Store interface, optional capabilities (e.g.
+// session.Lister) of the underlying session store are reachable via
+// session.StoreAs.
+type Store struct {
+	config Config
+
+	lock      sync.Mutex          // The mutex to guard accesses to the fields below
+	store     session.Store       // The underlying session store, nil before initialized
+	rand      *rand.Rand          // The random source for jitters and failures
+	behaviors map[string]Behavior // The behaviors indexed by method names
+	calls     []Call              // The recorded calls in order
+}
+
+// New returns a new mock session store with given configuration. The
+// underlying session store is initialized by the Initer of the mock session
+// store.
+func New(cfg Config) *Store {
+	if cfg.Initer == nil {
+		cfg.Initer = session.MemoryIniter()
+	}
+
+	behaviors := make(map[string]Behavior, len(cfg.Behaviors))
+	for method, b := range cfg.Behaviors {
+		behaviors[method] = b
+	}
+	return &Store{
+		config:    cfg,
+		rand:      rand.New(rand.NewSource(cfg.Seed)),
+		behaviors: behaviors,
+	}
+}
+
+// Initer returns the Initer that initializes the underlying session store with
+// Config.Config and returns the mock session store, to be used as
+// session.Options.Initer.
+func (s *Store) Initer() session.Initer {
+	return func(ctx context.Context, args ...interface{}) (session.Store, error) {
+		var idWriter session.IDWriter
+		for _, arg := range args {
+			if v, ok := arg.(session.IDWriter); ok {
+				idWriter = v
+			}
+		}
+
+		initArgs := []interface{}{idWriter}
+		if s.config.Config != nil {
+			initArgs = append(initArgs, s.config.Config)
+		}
+		store, err := s.config.Initer(ctx, initArgs...)
+		if err != nil {
+			return nil, fmt.Errorf("init underlying store: %w", err)
+		}
+
+		s.lock.Lock()
+		s.store = store
+		s.lock.Unlock()
+		return s, nil
+	}
+}
+
+// Unwrap returns the underlying session store.
+func (s *Store) Unwrap() session.Store {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.store
+}
+
+// SetBehavior replaces the behavior of the method (e.g. "Save"), an empty
+// method name applies to methods that have no behavior of their own.
+func (s *Store) SetBehavior(method string, b Behavior) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.behaviors[method] = b
+}
+
+// Calls returns recorded calls in order. A non-empty method name only returns
+// calls of the method.
+func (s *Store) Calls(method string) []Call {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	calls := make([]Call, 0, len(s.calls))
+	for _, c := range s.calls {
+		if method == "" || c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Reset clears recorded calls.
+func (s *Store) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.calls = nil
+}
+
+// enter applies the behavior of the method. It returns the underlying session
+// store, and a non-nil error if the call is failed.
+func (s *Store) enter(ctx context.Context, method string) (session.Store, error) {
+	s.lock.Lock()
+	b, ok := s.behaviors[method]
+	if !ok {
+		b = s.behaviors[""]
+	}
+	latency := b.Latency
+	if b.Jitter > 0 {
+		latency += time.Duration(s.rand.Int63n(int64(b.Jitter) + 1))
+	}
+	failed := b.ErrorRate > 0 && s.rand.Float64() < b.ErrorRate
+	store := s.store
+	s.lock.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if failed {
+		if b.Err != nil {
+			return nil, b.Err
+		}
+		return nil, ErrInjected
+	} else if store == nil {
+		return nil, errors.New("mockstore: store not initialized")
+	}
+	return store, nil
+}
+
+// record records a call of the method that started at given time.
+func (s *Store) record(method, sid string, err error, startedAt time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.calls = append(s.calls, Call{
+		Method:    method,
+		SessionID: sid,
+		Err:       err,
+		Duration:  time.Since(startedAt),
+	})
+}
+
+func (s *Store) Exist(ctx context.Context, sid string) bool {
+	exist, _ := s.CheckExist(ctx, sid)
+	return exist
+}
+
+var _ session.ExistChecker = (*Store)(nil)
+
+func (s *Store) CheckExist(ctx context.Context, sid string) (exist bool, err error) {
+	startedAt := time.Now()
+	defer func() { s.record("Exist", sid, err, startedAt) }()
+
+	store, err := s.enter(ctx, "Exist")
+	if err != nil {
+		return false, err
+	}
+	return session.CheckExist(ctx, store, sid)
+}
+
+func (s *Store) Read(ctx context.Context, sid string) (sess session.Session, err error) {
+	startedAt := time.Now()
+	defer func() { s.record("Read", sid, err, startedAt) }()
+
+	store, err := s.enter(ctx, "Read")
+	if err != nil {
+		return nil, err
+	}
+	return store.Read(ctx, sid)
+}
+
+func (s *Store) Destroy(ctx context.Context, sid string) (err error) {
+	startedAt := time.Now()
+	defer func() { s.record("Destroy", sid, err, startedAt) }()
+
+	store, err := s.enter(ctx, "Destroy")
+	if err != nil {
+		return err
+	}
+	return store.Destroy(ctx, sid)
+}
+
+func (s *Store) Touch(ctx context.Context, sid string) (err error) {
+	startedAt := time.Now()
+	defer func() { s.record("Touch", sid, err, startedAt) }()
+
+	store, err := s.enter(ctx, "Touch")
+	if err != nil {
+		return err
+	}
+	return store.Touch(ctx, sid)
+}
+
+func (s *Store) Save(ctx context.Context, sess session.Session) (err error) {
+	startedAt := time.Now()
+	defer func() { s.record("Save", sess.ID(), err, startedAt) }()
+
+	store, err := s.enter(ctx, "Save")
+	if err != nil {
+		return err
+	}
+	return store.Save(ctx, sess)
+}
+
+func (s *Store) GC(ctx context.Context) (err error) {
+	startedAt := time.Now()
+	defer func() { s.record("GC", "", err, startedAt) }()
+
+	store, err := s.enter(ctx, "GC")
+	if err != nil {
+		return err
+	}
+	return store.GC(ctx)
+}
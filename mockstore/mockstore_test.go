@@ -0,0 +1,120 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package mockstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+	"github.com/flamego/session"
+)
+
+func TestStore(t *testing.T) {
+	store := New(Config{
+		Behaviors: map[string]Behavior{
+			"Save": {ErrorRate: 1},
+		},
+	})
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(session.Sessioner(
+		session.Options{
+			Initer: store.Initer(),
+		},
+	))
+	f.Get("/", func(s session.Session) {
+		s.Set("username", "flamego")
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	assert.Panics(t, func() { f.ServeHTTP(resp, req) }, "failed save")
+
+	calls := store.Calls("Save")
+	require.Len(t, calls, 1)
+	assert.Equal(t, ErrInjected, calls[0].Err)
+	assert.NotEmpty(t, calls[0].SessionID)
+	assert.NotEmpty(t, store.Calls("Read"))
+
+	// Optional capabilities of the underlying session store are reachable
+	lister, ok := session.StoreAs[session.Lister](store)
+	require.True(t, ok)
+	sids, err := lister.List(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{calls[0].SessionID}, sids)
+}
+
+func TestStore_Behaviors(t *testing.T) {
+	ctx := context.Background()
+	store := New(Config{})
+	_, err := store.Initer()(ctx, session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}))
+	require.NoError(t, err)
+
+	errBoom := errors.New("boom")
+	store.SetBehavior("", Behavior{ErrorRate: 1, Err: errBoom})
+	_, err = store.Read(ctx, "1")
+	assert.Equal(t, errBoom, err)
+	assert.False(t, store.Exist(ctx, "1"))
+
+	// Behaviors of methods take precedence over the default
+	store.SetBehavior("Read", Behavior{})
+	_, err = store.Read(ctx, "1")
+	assert.NoError(t, err)
+
+	store.SetBehavior("Touch", Behavior{Latency: time.Second})
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err = store.Touch(ctx, "1")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	var methods []string
+	for _, c := range store.Calls("") {
+		methods = append(methods, c.Method)
+	}
+	assert.Equal(t, []string{"Read", "Exist", "Read", "Touch"}, methods)
+
+	store.Reset()
+	assert.Empty(t, store.Calls(""))
+}
+
+func TestStore_ErrorRate(t *testing.T) {
+	ctx := context.Background()
+	failures := func(seed int64) int {
+		store := New(Config{
+			Seed: seed,
+			Behaviors: map[string]Behavior{
+				"Save": {ErrorRate: 0.5},
+			},
+		})
+		_, err := store.Initer()(ctx, session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}))
+		require.NoError(t, err)
+
+		sess, err := store.Read(ctx, "1")
+		require.NoError(t, err)
+
+		n := 0
+		for i := 0; i < 100; i++ {
+			if store.Save(ctx, sess) != nil {
+				n++
+			}
+		}
+		return n
+	}
+
+	n := failures(42)
+	assert.Greater(t, n, 20)
+	assert.Less(t, n, 80)
+	assert.Equal(t, n, failures(42), "failures with the same seed")
+}
@@ -6,14 +6,17 @@ package mongo
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
-	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 
 	"github.com/flamego/session"
 )
@@ -27,6 +30,9 @@ type mongoStore struct {
 	db         *mongo.Database  // The database connection
 	collection string           // The database collection for storing session data
 
+	collOpts *options.CollectionOptions  // The options to apply to every collection handle
+	txnOpts  *options.TransactionOptions // The options for transactions, nil if transactions are disabled
+
 	encoder  session.Encoder
 	decoder  session.Decoder
 	idWriter session.IDWriter
@@ -34,63 +40,176 @@ type mongoStore struct {
 
 // newMongoStore returns a new MongoDB session store based on given configuration.
 func newMongoStore(cfg Config, idWriter session.IDWriter) *mongoStore {
-	return &mongoStore{
-		nowFunc:    cfg.nowFunc,
+	s := &mongoStore{
+		nowFunc:    cfg.NowFunc,
 		lifetime:   cfg.Lifetime,
 		db:         cfg.db,
 		collection: cfg.Collection,
-		encoder:    cfg.Encoder,
-		decoder:    cfg.Decoder,
-		idWriter:   idWriter,
+		collOpts: options.Collection().
+			SetWriteConcern(cfg.WriteConcern).
+			SetReadConcern(cfg.ReadConcern).
+			SetReadPreference(cfg.ReadPreference),
+		encoder:  cfg.Encoder,
+		decoder:  cfg.Decoder,
+		idWriter: idWriter,
+	}
+	if cfg.EnableTransactions {
+		// Transactions must read from the primary, regardless of the read
+		// preference used for standalone reads.
+		s.txnOpts = options.Transaction().
+			SetWriteConcern(cfg.WriteConcern).
+			SetReadConcern(cfg.ReadConcern).
+			SetReadPreference(readpref.Primary())
 	}
+	return s
+}
+
+// coll returns the handle of the session collection with configured read and
+// write options applied.
+func (s *mongoStore) coll() *mongo.Collection {
+	return s.db.Collection(s.collection, s.collOpts)
+}
+
+// inTransaction runs fn in a causally consistent transaction when transactions
+// are enabled, or calls fn directly otherwise.
+func (s *mongoStore) inTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.txnOpts == nil {
+		return fn(ctx)
+	}
+
+	sess, err := s.db.Client().StartSession(options.Session().SetCausalConsistency(true))
+	if err != nil {
+		return fmt.Errorf("start session: %w", err)
+	}
+	defer sess.EndSession(ctx)
+
+	_, err = sess.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(ctx)
+	}, s.txnOpts)
+	return err
 }
 
 func (s *mongoStore) Exist(ctx context.Context, sid string) bool {
-	err := s.db.Collection(s.collection).FindOne(ctx, bson.M{"key": sid}).Err()
-	return err == nil
+	ok, _ := s.CheckExist(ctx, sid)
+	return ok
+}
+
+var _ session.ExistChecker = (*mongoStore)(nil)
+
+func (s *mongoStore) CheckExist(ctx context.Context, sid string) (bool, error) {
+	err := s.coll().
+		FindOne(ctx, bson.M{"key": sid}, options.FindOne().SetProjection(bson.M{"_id": 1})).
+		Err()
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, fmt.Errorf("find: %w", err)
+	}
+	return true, nil
 }
 
 func (s *mongoStore) Read(ctx context.Context, sid string) (session.Session, error) {
 	var result bson.M
-	err := s.db.Collection(s.collection).FindOne(ctx, bson.M{"key": sid}).Decode(&result)
+	err := s.coll().FindOne(ctx, bson.M{"key": sid}).Decode(&result)
 	if err == nil {
-		binary, ok := result["data"].(primitive.Binary)
-		if !ok {
-			return nil, errors.Errorf(`assert "data" key: want type primitive.Binary but got %T`, result["data"])
+		sess, err := s.decode(sid, result)
+		if err != nil {
+			return nil, err
+		} else if sess != nil {
+			return sess, nil
 		}
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("find: %w", err)
+	}
 
-		expiredAt, ok := result["expired_at"].(primitive.DateTime)
-		if !ok {
-			return nil, errors.Errorf(`assert "expired_at" key: want type primitive.DateTime but got %T`, result["expired_at"])
+	return session.NewBaseSession(sid, s.encoder, s.idWriter), nil
+}
+
+// decode returns the session with given ID of the document, or nil if the
+// session has expired.
+func (s *mongoStore) decode(sid string, result bson.M) (session.Session, error) {
+	binary, ok := result["data"].(primitive.Binary)
+	if !ok {
+		return nil, fmt.Errorf(`assert "data" key: want type primitive.Binary but got %T`, result["data"])
+	}
+
+	expiredAt, ok := result["expired_at"].(primitive.DateTime)
+	if !ok {
+		return nil, fmt.Errorf(`assert "expired_at" key: want type primitive.DateTime but got %T`, result["expired_at"])
+	}
+
+	// Discard existing data if it's expired
+	if !s.nowFunc().Before(expiredAt.Time()) {
+		return nil, nil
+	}
+
+	data, err := s.decoder(binary.Data)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	sess := session.NewBaseSessionWithData(sid, s.encoder, s.idWriter, data)
+	if tags, ok := result["tags"].(bson.M); ok {
+		m := make(map[string]string, len(tags))
+		for k, v := range tags {
+			if s, ok := v.(string); ok {
+				m[k] = s
+			}
 		}
+		sess.LoadTags(m)
+	}
+	return sess, nil
+}
+
+var _ session.MultiReader = (*mongoStore)(nil)
+
+// ReadMany reads unexpired sessions with a single query using the $in
+// operator.
+func (s *mongoStore) ReadMany(ctx context.Context, sids []string) (map[string]session.Session, error) {
+	sessions := make(map[string]session.Session, len(sids))
+	if len(sids) == 0 {
+		return sessions, nil
+	}
 
-		// Discard existing data if it's expired
-		if !s.nowFunc().Before(expiredAt.Time().Add(s.lifetime)) {
-			return session.NewBaseSession(sid, s.encoder, s.idWriter), nil
+	cursor, err := s.coll().Find(ctx, bson.M{"key": bson.M{"$in": sids}})
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	defer func() { _ = cursor.Close(ctx) }()
+
+	for cursor.Next(ctx) {
+		var result bson.M
+		err = cursor.Decode(&result)
+		if err != nil {
+			return nil, fmt.Errorf("decode document: %w", err)
 		}
 
-		data, err := s.decoder(binary.Data)
+		sid, _ := result["key"].(string)
+		sess, err := s.decode(sid, result)
 		if err != nil {
-			return nil, errors.Wrap(err, "decode")
+			return nil, fmt.Errorf("session %q: %w", sid, err)
+		} else if sess != nil {
+			sessions[sid] = sess
 		}
-		return session.NewBaseSessionWithData(sid, s.encoder, s.idWriter, data), nil
-	} else if err != mongo.ErrNoDocuments {
-		return nil, errors.Wrap(err, "find")
 	}
-
-	return session.NewBaseSession(sid, s.encoder, s.idWriter), nil
+	if err = cursor.Err(); err != nil {
+		return nil, fmt.Errorf("iterate: %w", err)
+	}
+	return sessions, nil
 }
 
 func (s *mongoStore) Destroy(ctx context.Context, sid string) error {
-	_, err := s.db.Collection(s.collection).DeleteOne(ctx, bson.M{"key": sid})
-	if err != nil {
-		return errors.Wrap(err, "delete")
-	}
-	return nil
+	return s.inTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.coll().DeleteOne(ctx, bson.M{"key": sid})
+		if err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+		return nil
+	})
 }
 
 func (s *mongoStore) Touch(ctx context.Context, sid string) error {
-	_, err := s.db.Collection(s.collection).
+	_, err := s.coll().
 		UpdateOne(ctx,
 			bson.M{"key": sid},
 			bson.M{"$set": bson.M{
@@ -98,7 +217,7 @@ func (s *mongoStore) Touch(ctx context.Context, sid string) error {
 			}},
 		)
 	if err != nil {
-		return errors.Wrap(err, "update")
+		return fmt.Errorf("update: %w", err)
 	}
 	return nil
 }
@@ -106,30 +225,105 @@ func (s *mongoStore) Touch(ctx context.Context, sid string) error {
 func (s *mongoStore) Save(ctx context.Context, sess session.Session) error {
 	binary, err := sess.Encode()
 	if err != nil {
-		return errors.Wrap(err, "encode")
+		return fmt.Errorf("encode: %w", err)
 	}
 
 	upsert := true
-	_, err = s.db.Collection(s.collection).
-		UpdateOne(ctx, bson.M{"key": sess.ID()}, bson.M{"$set": bson.M{
-			"key":        sess.ID(),
-			"data":       binary,
-			"expired_at": s.nowFunc().Add(s.lifetime).UTC(),
-		}}, &options.UpdateOptions{
-			Upsert: &upsert,
-		})
+	return s.inTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.coll().
+			UpdateOne(ctx, bson.M{"key": sess.ID()}, bson.M{"$set": bson.M{
+				"key":        sess.ID(),
+				"data":       binary,
+				"expired_at": s.nowFunc().Add(s.lifetime).UTC(),
+				"tags":       session.TagsOf(sess),
+			}}, &options.UpdateOptions{
+				Upsert: &upsert,
+			})
+		if err != nil {
+			return fmt.Errorf("upsert: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *mongoStore) GC(ctx context.Context) error {
+	_, err := s.coll().DeleteMany(ctx, bson.M{"expired_at": bson.M{"$lte": s.nowFunc().UTC()}})
 	if err != nil {
-		return errors.Wrap(err, "upsert")
+		return fmt.Errorf("delete: %w", err)
 	}
 	return nil
 }
 
-func (s *mongoStore) GC(ctx context.Context) error {
-	_, err := s.db.Collection(s.collection).DeleteMany(ctx, bson.M{"expired_at": bson.M{"$lte": s.nowFunc().UTC()}})
+var _ session.EncoderReporter = (*mongoStore)(nil)
+
+func (s *mongoStore) Encoder() session.Encoder {
+	return s.encoder
+}
+
+var _ session.Expirer = (*mongoStore)(nil)
+
+func (s *mongoStore) ExpiresAt(ctx context.Context, sid string) (time.Time, error) {
+	var result struct {
+		ExpiredAt time.Time `bson:"expired_at"`
+	}
+	err := s.coll().
+		FindOne(ctx, bson.M{"key": sid}, options.FindOne().SetProjection(bson.M{"expired_at": 1})).
+		Decode(&result)
 	if err != nil {
-		return errors.Wrap(err, "delete")
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("find: %w", err)
 	}
-	return nil
+	return result.ExpiredAt, nil
+}
+
+var _ session.TagFinder = (*mongoStore)(nil)
+
+func (s *mongoStore) FindByTag(ctx context.Context, key, value string) ([]string, error) {
+	cursor, err := s.coll().
+		Find(ctx, bson.M{"tags." + key: value}, options.Find().SetProjection(bson.M{"key": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	defer func() { _ = cursor.Close(ctx) }()
+
+	var sids []string
+	for cursor.Next(ctx) {
+		var result struct {
+			Key string `bson:"key"`
+		}
+		err = cursor.Decode(&result)
+		if err != nil {
+			return nil, fmt.Errorf("decode: %w", err)
+		}
+		sids = append(sids, result.Key)
+	}
+	return sids, cursor.Err()
+}
+
+var _ session.Lister = (*mongoStore)(nil)
+
+func (s *mongoStore) List(ctx context.Context) ([]string, error) {
+	cursor, err := s.coll().
+		Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"key": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+	defer func() { _ = cursor.Close(ctx) }()
+
+	var sids []string
+	for cursor.Next(ctx) {
+		var result struct {
+			Key string `bson:"key"`
+		}
+		err = cursor.Decode(&result)
+		if err != nil {
+			return nil, fmt.Errorf("decode: %w", err)
+		}
+		sids = append(sids, result.Key)
+	}
+	return sids, cursor.Err()
 }
 
 // Options keeps the settings to set up MongoDB client connection.
@@ -138,8 +332,7 @@ type Options = options.ClientOptions
 // Config contains options for the MongoDB session store.
 type Config struct {
 	// For tests only
-	nowFunc func() time.Time
-	db      *mongo.Database
+	db *mongo.Database
 
 	// Options is the settings to set up the MongoDB client connection.
 	Options *Options
@@ -150,10 +343,30 @@ type Config struct {
 	// Lifetime is the duration to have no access to a session before being
 	// recycled. Default is 3600 seconds.
 	Lifetime time.Duration
+	// NowFunc is the function to return the current time for expiry of sessions,
+	// e.g. to simulate the passage of time in integration tests. Default is
+	// time.Now.
+	NowFunc func() time.Time
 	// Encoder is the encoder to encode session data. Default is session.GobEncoder.
 	Encoder session.Encoder
 	// Decoder is the decoder to decode session data. Default is session.GobDecoder.
 	Decoder session.Decoder
+	// WriteConcern is the write concern for session writes, e.g.
+	// writeconcern.Majority() to make sure saved sessions survive a failover.
+	// Default is inherited from the client.
+	WriteConcern *writeconcern.WriteConcern
+	// ReadConcern is the read concern for session reads. Default is inherited
+	// from the client.
+	ReadConcern *readconcern.ReadConcern
+	// ReadPreference is the read preference for session reads. Be aware that
+	// reading from secondaries may return stale sessions unless paired with
+	// EnableTransactions or a majority read and write concern. Default is
+	// inherited from the client.
+	ReadPreference *readpref.ReadPref
+	// EnableTransactions indicates whether to run Save and Destroy in causally
+	// consistent transactions on the primary. It requires a replica set or a
+	// sharded cluster.
+	EnableTransactions bool
 }
 
 // Initer returns the session.Initer for the MongoDB session store.
@@ -182,13 +395,13 @@ func Initer() session.Initer {
 		if cfg.db == nil {
 			client, err := mongo.Connect(ctx, cfg.Options)
 			if err != nil {
-				return nil, errors.Wrap(err, "connect database")
+				return nil, fmt.Errorf("connect database: %w", err)
 			}
 			cfg.db = client.Database(cfg.Database)
 		}
 
-		if cfg.nowFunc == nil {
-			cfg.nowFunc = time.Now
+		if cfg.NowFunc == nil {
+			cfg.NowFunc = time.Now
 		}
 		if cfg.Lifetime.Seconds() < 1 {
 			cfg.Lifetime = 3600 * time.Second
@@ -18,8 +18,12 @@ import (
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 
 	"github.com/flamego/session"
+	"github.com/flamego/session/storetest"
 )
 
 func newTestDB(t *testing.T, ctx context.Context) (testDB *mongo.Database, cleanup func() error) {
@@ -71,7 +75,7 @@ func TestMongoStore(t *testing.T) {
 		session.Options{
 			Initer: Initer(),
 			Config: Config{
-				nowFunc: time.Now,
+				NowFunc: time.Now,
 				db:      db,
 			},
 		},
@@ -137,7 +141,7 @@ func TestMongoStore_GC(t *testing.T) {
 	now := time.Now()
 	store, err := Initer()(ctx,
 		Config{
-			nowFunc:  func() time.Time { return now },
+			NowFunc:  func() time.Time { return now },
 			db:       db,
 			Lifetime: time.Second,
 		},
@@ -189,7 +193,7 @@ func TestMongoStore_Touch(t *testing.T) {
 	now := time.Now()
 	store, err := Initer()(ctx,
 		Config{
-			nowFunc:  func() time.Time { return now },
+			NowFunc:  func() time.Time { return now },
 			db:       db,
 			Lifetime: time.Second,
 		},
@@ -217,3 +221,63 @@ func TestMongoStore_Touch(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "flamego", sess.Get("name"))
 }
+
+func TestMongoStore_Consistency(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := newTestDB(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	// Transactions are only supported by replica sets and sharded clusters
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := db.RunCommand(ctx, map[string]int{"hello": 1}).Decode(&hello)
+	require.Nil(t, err)
+	enableTransactions := hello.SetName != "" || hello.Msg == "isdbgrid"
+
+	store, err := Initer()(ctx,
+		Config{
+			NowFunc:            time.Now,
+			db:                 db,
+			WriteConcern:       writeconcern.Majority(),
+			ReadConcern:        readconcern.Majority(),
+			ReadPreference:     readpref.PrimaryPreferred(),
+			EnableTransactions: enableTransactions,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	sess, err := store.Read(ctx, "1")
+	require.Nil(t, err)
+	sess.Set("name", "flamego")
+	session.Tag(sess, session.UserTag, "alice")
+	err = store.Save(ctx, sess)
+	require.Nil(t, err)
+
+	sess, err = store.Read(ctx, "1")
+	require.Nil(t, err)
+	assert.Equal(t, "flamego", sess.Get("name"))
+	assert.Equal(t, "alice", session.TagsOf(sess)[session.UserTag])
+
+	err = store.Destroy(ctx, "1")
+	require.Nil(t, err)
+	assert.False(t, store.Exist(ctx, "1"))
+}
+
+func TestMongoStore_Conformance(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := newTestDB(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	storetest.Conformance(t, Initer(), Config{
+		NowFunc:  time.Now,
+		db:       db,
+		Lifetime: time.Second,
+	})
+}
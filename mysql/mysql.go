@@ -7,15 +7,47 @@ package mysql
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/pkg/errors"
 
 	"github.com/flamego/session"
 )
 
+// ErrDataTooLarge is returned by Save when the encoded session data exceeds the
+// capacity of the data column, which MySQL would otherwise truncate silently
+// in non-strict mode.
+var ErrDataTooLarge = errors.New("session data too large")
+
+// DataType is the column type of session data.
+type DataType string
+
+// A list of supported column types of session data.
+const (
+	DataTypeBlob       DataType = "BLOB"       // Up to 64 KiB
+	DataTypeMediumBlob DataType = "MEDIUMBLOB" // Up to 16 MiB
+	DataTypeLongBlob   DataType = "LONGBLOB"   // Up to 4 GiB
+)
+
+// capacity returns the maximum number of bytes of the column type, or 0 if the
+// column type is unknown.
+func (t DataType) capacity() int64 {
+	switch t {
+	case DataTypeBlob:
+		return 1<<16 - 1
+	case DataTypeMediumBlob:
+		return 1<<24 - 1
+	case DataTypeLongBlob:
+		return 1<<32 - 1
+	}
+	return 0
+}
+
 var _ session.Store = (*mysqlStore)(nil)
 
 // mysqlStore is a MySQL implementation of the session store.
@@ -24,6 +56,11 @@ type mysqlStore struct {
 	lifetime time.Duration    // The duration to have no access to a session before being recycled
 	db       *sql.DB          // The database connection
 	table    string           // The database table for storing session data
+	tags     bool             // Whether to persist session tags
+	counters bool             // Whether to maintain session counters
+	gcLock   bool             // Whether to skip GC when another instance is performing GC
+	replicas *replicaSet      // The read replicas, nil if reading from the primary only
+	dataType DataType         // The column type of session data
 
 	encoder  session.Encoder
 	decoder  session.Decoder
@@ -33,10 +70,15 @@ type mysqlStore struct {
 // newMySQLStore returns a new MySQL session store based on given configuration.
 func newMySQLStore(cfg Config, idWriter session.IDWriter) *mysqlStore {
 	return &mysqlStore{
-		nowFunc:  cfg.nowFunc,
+		nowFunc:  cfg.NowFunc,
 		lifetime: cfg.Lifetime,
 		db:       cfg.db,
 		table:    cfg.Table,
+		tags:     cfg.EnableTags,
+		counters: cfg.EnableCounters,
+		gcLock:   cfg.EnableGCLock,
+		replicas: newReplicaSet(cfg.ReadDBs, cfg.MaxStaleness),
+		dataType: cfg.DataType,
 		encoder:  cfg.Encoder,
 		decoder:  cfg.Decoder,
 		idWriter: idWriter,
@@ -48,69 +90,231 @@ func quoteWithBackticks(s string) string {
 }
 
 func (s *mysqlStore) Exist(ctx context.Context, sid string) bool {
+	ok, _ := s.CheckExist(ctx, sid)
+	return ok
+}
+
+var _ session.ExistChecker = (*mysqlStore)(nil)
+
+// CheckExist checks the read replica first if any, sessions that are missing
+// in the read replica are checked again in the primary as they may have not
+// been replicated yet.
+func (s *mysqlStore) CheckExist(ctx context.Context, sid string) (bool, error) {
+	if replica := s.replicas.pick(sid, s.nowFunc()); replica != nil {
+		exists, err := s.checkExist(ctx, replica, sid)
+		if err == nil && exists {
+			return true, nil
+		}
+	}
+	return s.checkExist(ctx, s.db, sid)
+}
+
+// checkExist returns true if the session with given ID exists in the database.
+func (s *mysqlStore) checkExist(ctx context.Context, db *sql.DB, sid string) (bool, error) {
 	var exists bool
 	q := fmt.Sprintf(
 		`SELECT EXISTS (SELECT 1 FROM %s WHERE %s = ?)`,
 		quoteWithBackticks(s.table),
 		quoteWithBackticks("key"),
 	)
-	err := s.db.QueryRowContext(ctx, q, sid).Scan(&exists)
-	return err == nil && exists
+	err := db.QueryRowContext(ctx, q, sid).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("query: %w", err)
+	}
+	return exists, nil
 }
 
+// Read reads from the read replica first if any, sessions that are missing,
+// expired or fail to be read in the read replica are read again from the
+// primary as they may have not been replicated yet.
 func (s *mysqlStore) Read(ctx context.Context, sid string) (session.Session, error) {
+	if replica := s.replicas.pick(sid, s.nowFunc()); replica != nil {
+		sess, err := s.read(ctx, replica, sid)
+		if err == nil && sess != nil {
+			return sess, nil
+		}
+	}
+
+	sess, err := s.read(ctx, s.db, sid)
+	if err != nil {
+		return nil, err
+	} else if sess == nil {
+		return session.NewBaseSession(sid, s.encoder, s.idWriter), nil
+	}
+	return sess, nil
+}
+
+// read returns the session with given ID in the database, or nil if no such
+// session exists or it has expired.
+func (s *mysqlStore) read(ctx context.Context, db *sql.DB, sid string) (session.Session, error) {
 	var binary []byte
 	var expiredAt time.Time
+	var tags []byte
+	columns := "data, expired_at"
+	dest := []interface{}{&binary, &expiredAt}
+	if s.tags {
+		columns += ", tags"
+		dest = append(dest, &tags)
+	}
 	q := fmt.Sprintf(
-		`SELECT data, expired_at FROM %s WHERE %s = ?`,
+		`SELECT %s FROM %s WHERE %s = ?`,
+		columns,
 		quoteWithBackticks(s.table),
 		quoteWithBackticks("key"),
 	)
-	err := s.db.QueryRowContext(ctx, q, sid).Scan(&binary, &expiredAt)
+	err := db.QueryRowContext(ctx, q, sid).Scan(dest...)
 	if err == nil {
 		// Discard existing data if it's expired
-		if !s.nowFunc().Before(expiredAt.Add(s.lifetime)) {
-			return session.NewBaseSession(sid, s.encoder, s.idWriter), nil
+		if !s.nowFunc().Before(expiredAt) {
+			return nil, nil
 		}
 
-		data, err := s.decoder(binary)
+		return s.decode(sid, binary, tags)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	return nil, nil
+}
+
+// decode returns the session with given ID of the encoded session data and the
+// tags in JSON.
+func (s *mysqlStore) decode(sid string, binary, tags []byte) (session.Session, error) {
+	data, err := s.decoder(binary)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	sess := session.NewBaseSessionWithData(sid, s.encoder, s.idWriter, data)
+	if len(tags) > 0 {
+		var m map[string]string
+		err = json.Unmarshal(tags, &m)
 		if err != nil {
-			return nil, errors.Wrap(err, "decode")
+			return nil, fmt.Errorf("unmarshal tags: %w", err)
 		}
-		return session.NewBaseSessionWithData(sid, s.encoder, s.idWriter, data), nil
-	} else if err != sql.ErrNoRows {
-		return nil, errors.Wrap(err, "select")
+		sess.LoadTags(m)
 	}
+	return sess, nil
+}
+
+var _ session.MultiReader = (*mysqlStore)(nil)
 
-	return session.NewBaseSession(sid, s.encoder, s.idWriter), nil
+// ReadMany reads unexpired sessions from the primary with a single query.
+func (s *mysqlStore) ReadMany(ctx context.Context, sids []string) (map[string]session.Session, error) {
+	sessions := make(map[string]session.Session, len(sids))
+	if len(sids) == 0 {
+		return sessions, nil
+	}
+
+	columns := quoteWithBackticks("key") + ", data"
+	if s.tags {
+		columns += ", tags"
+	}
+	args := make([]interface{}, 0, len(sids)+1)
+	for _, sid := range sids {
+		args = append(args, sid)
+	}
+	args = append(args, s.nowFunc().UTC())
+	q := fmt.Sprintf(
+		`SELECT %s FROM %s WHERE %s IN (%s) AND expired_at > ?`,
+		columns,
+		quoteWithBackticks(s.table),
+		quoteWithBackticks("key"),
+		strings.Repeat("?, ", len(sids)-1)+"?",
+	)
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var sid string
+		var binary, tags []byte
+		dest := []interface{}{&sid, &binary}
+		if s.tags {
+			dest = append(dest, &tags)
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		sessions[sid], err = s.decode(sid, binary, tags)
+		if err != nil {
+			return nil, fmt.Errorf("session %q: %w", sid, err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate: %w", err)
+	}
+	return sessions, nil
 }
 
 func (s *mysqlStore) Destroy(ctx context.Context, sid string) error {
+	s.replicas.wrote(sid, s.nowFunc())
 	q := fmt.Sprintf(
 		`DELETE FROM %s WHERE %s = ?`,
 		quoteWithBackticks(s.table),
 		quoteWithBackticks("key"),
 	)
 	_, err := s.db.ExecContext(ctx, q, sid)
+	if err != nil || !s.counters {
+		return err
+	}
+
+	q = fmt.Sprintf(
+		`DELETE FROM %s WHERE %s = ?`,
+		s.countersTable(),
+		quoteWithBackticks("key"),
+	)
+	_, err = s.db.ExecContext(ctx, q, sid)
 	return err
 }
 
 func (s *mysqlStore) Touch(ctx context.Context, sid string) error {
+	s.replicas.wrote(sid, s.nowFunc())
 	q := fmt.Sprintf(`UPDATE %s SET expired_at = ? WHERE %s = ?`,
 		quoteWithBackticks(s.table),
 		quoteWithBackticks("key"),
 	)
 	_, err := s.db.ExecContext(ctx, q, s.nowFunc().Add(s.lifetime).UTC(), sid)
 	if err != nil {
-		return errors.Wrap(err, "update")
+		return fmt.Errorf("update: %w", err)
 	}
 	return nil
 }
 
 func (s *mysqlStore) Save(ctx context.Context, sess session.Session) error {
+	s.replicas.wrote(sess.ID(), s.nowFunc())
 	binary, err := sess.Encode()
 	if err != nil {
-		return errors.Wrap(err, "encode")
+		return fmt.Errorf("encode: %w", err)
+	}
+	if capacity := s.dataType.capacity(); int64(len(binary)) > capacity {
+		return fmt.Errorf("%d bytes exceed the capacity of %d bytes of %s: %w", len(binary), capacity, s.dataType, ErrDataTooLarge)
+	}
+
+	if s.tags {
+		tags, err := json.Marshal(session.TagsOf(sess))
+		if err != nil {
+			return fmt.Errorf("marshal tags: %w", err)
+		}
+
+		q := fmt.Sprintf(`
+INSERT INTO %s (%s, data, expired_at, tags)
+VALUES (?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE
+	data       = VALUES(data),
+	expired_at = VALUES(expired_at),
+	tags       = VALUES(tags)
+`,
+			quoteWithBackticks(s.table),
+			quoteWithBackticks("key"),
+		)
+		_, err = s.db.ExecContext(ctx, q, sess.ID(), binary, s.nowFunc().Add(s.lifetime).UTC(), string(tags))
+		if err != nil {
+			return fmt.Errorf("upsert: %w", err)
+		}
+		return nil
 	}
 
 	q := fmt.Sprintf(`
@@ -125,26 +329,333 @@ ON DUPLICATE KEY UPDATE
 	)
 	_, err = s.db.ExecContext(ctx, q, sess.ID(), binary, s.nowFunc().Add(s.lifetime).UTC())
 	if err != nil {
-		return errors.Wrap(err, "upsert")
+		return fmt.Errorf("upsert: %w", err)
 	}
 	return nil
 }
 
 func (s *mysqlStore) GC(ctx context.Context) error {
+	return s.GCWithArchive(ctx, 0, nil)
+}
+
+var _ session.ExpiryArchiver = (*mysqlStore)(nil)
+
+// GCWithArchive implements `session.ExpiryArchiver.GCWithArchive`. Expired
+// sessions whose data cannot be decoded are recycled without calling the
+// onExpire.
+func (s *mysqlStore) GCWithArchive(ctx context.Context, batchSize int, onExpire session.OnExpireFunc) error {
+	if !s.gcLock {
+		return s.gc(ctx, s.db, batchSize, onExpire)
+	}
+
+	// Named locks belong to connections, thus the same connection must be used
+	// throughout.
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get connection: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	lockName := gcLockPrefix + s.table
+	var locked sql.NullInt64
+	err = conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, 0)`, lockName).Scan(&locked)
+	if err != nil {
+		return fmt.Errorf("get lock: %w", err)
+	} else if locked.Int64 != 1 {
+		return nil // Another instance is performing GC
+	}
+	defer func() {
+		_, err := conn.ExecContext(context.Background(), `DO RELEASE_LOCK(?)`, lockName)
+		if err != nil {
+			// Discard the connection to not leak the lock to the connection pool
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+	}()
+	return s.gc(ctx, conn, batchSize, onExpire)
+}
+
+// gcLockPrefix is the prefix of names of locks for GC operations, which is
+// followed by the table name.
+const gcLockPrefix = "flamego_session_gc:"
+
+// queryer executes queries on a database or a connection.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// gc recycles expired sessions and their counters with the queryer. Expired
+// sessions are passed to the onExpire in batches if it is not nil.
+func (s *mysqlStore) gc(ctx context.Context, db queryer, batchSize int, onExpire session.OnExpireFunc) error {
+	now := s.nowFunc().UTC()
+	if onExpire != nil {
+		if batchSize < 1 {
+			batchSize = 1
+		}
+		for {
+			n, err := s.archiveExpired(ctx, db, now, batchSize, onExpire)
+			if err != nil {
+				return err
+			} else if n < batchSize {
+				break
+			}
+		}
+	}
+
 	q := fmt.Sprintf(`DELETE FROM %s WHERE expired_at <= ?`, quoteWithBackticks(s.table))
-	_, err := s.db.ExecContext(ctx, q, s.nowFunc().UTC())
+	_, err := db.ExecContext(ctx, q, now)
+	if err != nil || !s.counters {
+		return err
+	}
+
+	// Recycle counters of sessions that no longer exist
+	q = fmt.Sprintf(
+		`DELETE FROM %[1]s WHERE %[2]s NOT IN (SELECT %[2]s FROM %[3]s)`,
+		s.countersTable(),
+		quoteWithBackticks("key"),
+		quoteWithBackticks(s.table),
+	)
+	_, err = db.ExecContext(ctx, q)
 	return err
 }
 
+// archiveExpired calls the onExpire with a batch of expired sessions and deletes
+// them. It returns the number of sessions in the batch.
+func (s *mysqlStore) archiveExpired(ctx context.Context, db queryer, now time.Time, batchSize int, onExpire session.OnExpireFunc) (int, error) {
+	q := fmt.Sprintf(
+		`SELECT %s, data FROM %s WHERE expired_at <= ? LIMIT ?`,
+		quoteWithBackticks("key"),
+		quoteWithBackticks(s.table),
+	)
+	rows, err := db.QueryContext(ctx, q, now, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("select: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var sids []string
+	var binaries [][]byte
+	for rows.Next() {
+		var sid string
+		var binary []byte
+		err = rows.Scan(&sid, &binary)
+		if err != nil {
+			return 0, fmt.Errorf("scan: %w", err)
+		}
+		sids = append(sids, sid)
+		binaries = append(binaries, binary)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate: %w", err)
+	}
+	_ = rows.Close()
+	if len(sids) == 0 {
+		return 0, nil
+	}
+
+	for i := range sids {
+		data, err := s.decoder(binaries[i])
+		if err == nil {
+			onExpire(ctx, sids[i], data)
+		}
+	}
+
+	args := []interface{}{now}
+	for i := range sids {
+		args = append(args, sids[i])
+	}
+	q = fmt.Sprintf(
+		`DELETE FROM %s WHERE expired_at <= ? AND %s IN (?%s)`,
+		quoteWithBackticks(s.table),
+		quoteWithBackticks("key"),
+		strings.Repeat(", ?", len(sids)-1),
+	)
+	_, err = db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return 0, fmt.Errorf("delete: %w", err)
+	}
+	return len(sids), nil
+}
+
+// countersTable returns the quoted name of the table for storing session
+// counters.
+func (s *mysqlStore) countersTable() string {
+	return quoteWithBackticks(s.table + "_counters")
+}
+
+var _ session.Incrementer = (*mysqlStore)(nil)
+
+// Incr increments the counter with an upsert and reads it back in the same
+// transaction, which is atomic in the database as the upsert locks the row
+// until committed. It requires Config.EnableCounters to be set.
+func (s *mysqlStore) Incr(ctx context.Context, sid, key string, delta int64) (n int64, err error) {
+	if !s.counters {
+		return 0, errors.New("counters are not enabled")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	q := fmt.Sprintf(`
+INSERT INTO %s (%s, name, value)
+VALUES (?, ?, ?)
+ON DUPLICATE KEY UPDATE
+	value = value + VALUES(value)
+`,
+		s.countersTable(),
+		quoteWithBackticks("key"),
+	)
+	_, err = tx.ExecContext(ctx, q, sid, key, delta)
+	if err != nil {
+		return 0, fmt.Errorf("upsert: %w", err)
+	}
+
+	q = fmt.Sprintf(
+		`SELECT value FROM %s WHERE %s = ? AND name = ?`,
+		s.countersTable(),
+		quoteWithBackticks("key"),
+	)
+	err = tx.QueryRowContext(ctx, q, sid, key).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("select: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return n, nil
+}
+
+var _ session.EncoderReporter = (*mysqlStore)(nil)
+
+func (s *mysqlStore) Encoder() session.Encoder {
+	return s.encoder
+}
+
+var _ session.Expirer = (*mysqlStore)(nil)
+
+func (s *mysqlStore) ExpiresAt(ctx context.Context, sid string) (time.Time, error) {
+	var expiredAt time.Time
+	q := fmt.Sprintf(
+		`SELECT expired_at FROM %s WHERE %s = ?`,
+		quoteWithBackticks(s.table),
+		quoteWithBackticks("key"),
+	)
+	err := s.db.QueryRowContext(ctx, q, sid).Scan(&expiredAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("select: %w", err)
+	}
+	return expiredAt, nil
+}
+
+var _ session.TagFinder = (*mysqlStore)(nil)
+
+// FindByTag returns IDs of sessions that have the tag of given key with the
+// value. It requires Config.EnableTags to be set.
+func (s *mysqlStore) FindByTag(ctx context.Context, key, value string) ([]string, error) {
+	if !s.tags {
+		return nil, errors.New("tags are not enabled")
+	}
+
+	path, err := json.Marshal(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal key: %w", err)
+	}
+
+	q := fmt.Sprintf(
+		`SELECT %s FROM %s WHERE JSON_UNQUOTE(JSON_EXTRACT(tags, ?)) = ?`,
+		quoteWithBackticks("key"),
+		quoteWithBackticks(s.table),
+	)
+	rows, err := s.db.QueryContext(ctx, q, "$."+string(path), value)
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var sids []string
+	for rows.Next() {
+		var sid string
+		err = rows.Scan(&sid)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		sids = append(sids, sid)
+	}
+	return sids, rows.Err()
+}
+
+var _ session.Lister = (*mysqlStore)(nil)
+
+func (s *mysqlStore) List(ctx context.Context) ([]string, error) {
+	q := fmt.Sprintf(`SELECT %s FROM %s`, quoteWithBackticks("key"), quoteWithBackticks(s.table))
+	rows, err := s.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var sids []string
+	for rows.Next() {
+		var sid string
+		err = rows.Scan(&sid)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		sids = append(sids, sid)
+	}
+	return sids, rows.Err()
+}
+
+var _ session.ActiveCounter = (*mysqlStore)(nil)
+
+func (s *mysqlStore) CountActive(ctx context.Context) (int, error) {
+	q := fmt.Sprintf(`SELECT COUNT(*) FROM %s`, quoteWithBackticks(s.table))
+	var n int
+	err := s.db.QueryRowContext(ctx, q).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("select: %w", err)
+	}
+	return n, nil
+}
+
+var _ session.CapabilityReporter = (*mysqlStore)(nil)
+
+// Capabilities reports tags and counters only when they are enabled.
+func (s *mysqlStore) Capabilities() session.StoreCapabilities {
+	return session.StoreCapabilities{
+		List:        true,
+		ExpiresAt:   true,
+		FindByTag:   s.tags,
+		Incr:        s.counters,
+		ArchiveOnGC: true,
+		CountActive: true,
+	}
+}
+
 // Config contains options for the MySQL session store.
 type Config struct {
 	// For tests only
-	nowFunc func() time.Time
-	db      *sql.DB
+	db *sql.DB
 
 	// Lifetime is the duration to have access to a session before being
 	// recycled. Default is 3600 seconds.
 	Lifetime time.Duration
+	// NowFunc is the function to return the current time for expiry of sessions,
+	// e.g. to simulate the passage of time in integration tests. Default is
+	// time.Now.
+	NowFunc func() time.Time
 	// DSN is the database source name to the MySQL.
 	DSN string
 	// Table is the table name for storing session data. Default is "sessions".
@@ -155,6 +666,88 @@ type Config struct {
 	Decoder session.Decoder
 	// InitTable indicates whether to create a default session table when not exists automatically.
 	InitTable bool
+	// DataType is the column type of session data, which is used by InitTable
+	// and to reject session data that exceeds the capacity of the column on save.
+	// Existing tables need to be altered manually to change the column type, e.g.
+	// `ALTER TABLE sessions MODIFY data MEDIUMBLOB NOT NULL`. Default is
+	// DataTypeBlob.
+	DataType DataType
+	// KeyLength is the maximum number of characters of session IDs, which is used
+	// by InitTable. Default is 255.
+	KeyLength int
+	// Charset is the default character set of the table created by InitTable.
+	// Default is "utf8mb4".
+	Charset string
+	// Collation is the default collation of the table created by InitTable.
+	// Default is the default collation of the Charset.
+	Collation string
+	// EnableTags indicates whether to persist session tags to the "tags" column
+	// with the type JSON, which enables finding sessions by tags. The column is
+	// created by InitTable for new tables, existing tables need to be altered
+	// manually with `ALTER TABLE sessions ADD COLUMN tags JSON`.
+	EnableTags bool
+	// EnableCounters indicates whether to maintain session counters in the table
+	// with the name of Table suffixed by "_counters", which makes session.Incr
+	// atomic across instances. The table is created by InitTable.
+	EnableCounters bool
+	// EnableGCLock indicates whether to hold a named lock (i.e. GET_LOCK) during
+	// GC operations, which makes instances skip GC when another instance is
+	// performing GC on the same table. The table name should be no longer than
+	// 45 characters as names of locks are limited to 64 characters.
+	EnableGCLock bool
+	// ReadDBs is the list of connections to read replicas, which serve reads of
+	// sessions (i.e. Exist and Read) in round-robin while writes and GC go to the
+	// primary. Sessions that are missing or expired in a read replica, or fail to
+	// be read from it, are read again from the primary. Default is reading from
+	// the primary only.
+	//
+	// Only writes made by the same instance are known to be not yet replicated
+	// (see MaxStaleness), thus sessions that are saved, touched or destroyed by
+	// other instances may be read in their previous state within the replication
+	// lag. Notably, destroying a session (e.g. signing out) is not guaranteed to
+	// take effect immediately across instances, and applications that require so
+	// must not enable read replicas.
+	ReadDBs []*sql.DB
+	// ReadDSNs is the list of database source names to read replicas, which are
+	// opened in addition to ReadDBs.
+	ReadDSNs []string
+	// MaxStaleness is the maximum replication lag of read replicas to tolerate,
+	// sessions that have been written by this instance within the duration are
+	// read from the primary, which is tracked in memory of the instance. Default
+	// is 5 seconds.
+	MaxStaleness time.Duration
+	// MaxOpenConns is the maximum number of open connections to the database,
+	// which only applies when the database is opened by the store (i.e. via DSN
+	// and ReadDSNs). Default is unlimited.
+	MaxOpenConns int
+	// MaxIdleConns is the maximum number of idle connections to the database,
+	// which only applies when the database is opened by the store. Negative value
+	// means no idle connections are retained. Default is 2.
+	MaxIdleConns int
+	// ConnMaxLifetime is the maximum duration a connection may be reused, which
+	// only applies when the database is opened by the store. Default is unlimited.
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime is the maximum duration a connection may be idle before
+	// being closed, which only applies when the database is opened by the store.
+	// Default is unlimited.
+	ConnMaxIdleTime time.Duration
+}
+
+// setPool applies the connection pool settings to the database opened by the
+// store.
+func (cfg *Config) setPool(db *sql.DB) {
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
 }
 
 // Initer returns the session.Initer for the MySQL session store.
@@ -183,34 +776,84 @@ func Initer() session.Initer {
 		if cfg.db == nil {
 			db, err := sql.Open("mysql", cfg.DSN)
 			if err != nil {
-				return nil, errors.Wrap(err, "open database")
+				return nil, fmt.Errorf("open database: %w", err)
 			}
+			cfg.setPool(db)
 			cfg.db = db
 		}
+		for _, dsn := range cfg.ReadDSNs {
+			db, err := sql.Open("mysql", dsn)
+			if err != nil {
+				return nil, fmt.Errorf("open read replica: %w", err)
+			}
+			cfg.setPool(db)
+			cfg.ReadDBs = append(cfg.ReadDBs, db)
+		}
+
+		if cfg.DataType == "" {
+			cfg.DataType = DataTypeBlob
+		} else if cfg.DataType.capacity() == 0 {
+			return nil, fmt.Errorf("unsupported data type %q", cfg.DataType)
+		}
+		if cfg.KeyLength < 1 {
+			cfg.KeyLength = 255
+		}
+		if cfg.Charset == "" {
+			cfg.Charset = "utf8mb4"
+		}
 
 		if cfg.InitTable {
+			options := "DEFAULT CHARSET=" + cfg.Charset
+			if cfg.Collation != "" {
+				options += " COLLATE=" + cfg.Collation
+			}
 			q := fmt.Sprintf(`
 CREATE TABLE IF NOT EXISTS sessions (
-	%[1]s      VARCHAR(255) NOT NULL,
-	data       BLOB NOT NULL,
+	%[1]s      VARCHAR(%[2]d) NOT NULL,
+	data       %[3]s NOT NULL,
 	expired_at DATETIME NOT NULL,
+	tags       JSON,
 	PRIMARY KEY (%[1]s)
-) DEFAULT CHARSET=utf8`,
+) %[4]s`,
 				quoteWithBackticks("key"),
+				cfg.KeyLength,
+				cfg.DataType,
+				options,
 			)
 
 			_, err := cfg.db.ExecContext(ctx, q)
 			if err != nil {
-				return nil, errors.Wrap(err, "create table")
+				return nil, fmt.Errorf("create table: %w", err)
+			}
+
+			if cfg.EnableCounters {
+				q = fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS sessions_counters (
+	%[1]s VARCHAR(%[2]d) NOT NULL,
+	name  VARCHAR(255) NOT NULL,
+	value BIGINT NOT NULL,
+	PRIMARY KEY (%[1]s, name)
+) %[3]s`,
+					quoteWithBackticks("key"),
+					cfg.KeyLength,
+					options,
+				)
+				_, err = cfg.db.ExecContext(ctx, q)
+				if err != nil {
+					return nil, fmt.Errorf("create counters table: %w", err)
+				}
 			}
 		}
 
-		if cfg.nowFunc == nil {
-			cfg.nowFunc = time.Now
+		if cfg.NowFunc == nil {
+			cfg.NowFunc = time.Now
 		}
 		if cfg.Lifetime.Seconds() < 1 {
 			cfg.Lifetime = 3600 * time.Second
 		}
+		if cfg.MaxStaleness <= 0 {
+			cfg.MaxStaleness = 5 * time.Second
+		}
 		if cfg.Table == "" {
 			cfg.Table = "sessions"
 		}
@@ -8,10 +8,12 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -21,6 +23,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/flamego/session"
+	"github.com/flamego/session/storetest"
 )
 
 func newTestDB(t *testing.T, ctx context.Context) (testDB *sql.DB, cleanup func() error) {
@@ -95,7 +98,7 @@ func TestMySQLStore(t *testing.T) {
 		session.Options{
 			Initer: Initer(),
 			Config: Config{
-				nowFunc:   time.Now,
+				NowFunc:   time.Now,
 				db:        db,
 				InitTable: true,
 			},
@@ -162,7 +165,7 @@ func TestMySQLStore_GC(t *testing.T) {
 	now := time.Now()
 	store, err := Initer()(ctx,
 		Config{
-			nowFunc:   func() time.Time { return now },
+			NowFunc:   func() time.Time { return now },
 			db:        db,
 			Lifetime:  time.Second,
 			InitTable: true,
@@ -209,6 +212,134 @@ func TestMySQLStore_GC(t *testing.T) {
 	assert.False(t, store.Exist(ctx, "3"))
 }
 
+func TestMySQLStore_GCLock(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := newTestDB(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	now := time.Now()
+	store, err := Initer()(ctx,
+		Config{
+			NowFunc:      func() time.Time { return now },
+			db:           db,
+			Lifetime:     time.Second,
+			InitTable:    true,
+			EnableGCLock: true,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	sess, err := store.Read(ctx, "1")
+	require.Nil(t, err)
+	err = store.Save(ctx, sess)
+	require.Nil(t, err)
+	now = now.Add(2 * time.Second)
+
+	// GC is skipped while another instance holds the lock
+	conn, err := db.Conn(ctx)
+	require.Nil(t, err)
+	defer func() { _ = conn.Close() }()
+	_, err = conn.ExecContext(ctx, `DO GET_LOCK(?, 0)`, gcLockPrefix+"sessions")
+	require.Nil(t, err)
+	err = store.GC(ctx)
+	require.Nil(t, err)
+	assert.True(t, store.Exist(ctx, "1"))
+
+	_, err = conn.ExecContext(ctx, `DO RELEASE_LOCK(?)`, gcLockPrefix+"sessions")
+	require.Nil(t, err)
+	err = store.GC(ctx)
+	require.Nil(t, err)
+	assert.False(t, store.Exist(ctx, "1"))
+}
+
+func TestMySQLStore_GCWithArchive(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := newTestDB(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	now := time.Now()
+	store, err := Initer()(ctx,
+		Config{
+			NowFunc:   func() time.Time { return now },
+			db:        db,
+			Lifetime:  time.Second,
+			InitTable: true,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	for _, sid := range []string{"1", "2", "3"} {
+		sess, err := store.Read(ctx, sid)
+		require.Nil(t, err)
+		sess.Set("cart", "cart of "+sid)
+		err = store.Save(ctx, sess)
+		require.Nil(t, err)
+	}
+	now = now.Add(3 * time.Second)
+	alive, err := store.Read(ctx, "4")
+	require.Nil(t, err)
+	err = store.Save(ctx, alive)
+	require.Nil(t, err)
+
+	archived := make(map[string]interface{})
+	err = store.(session.ExpiryArchiver).GCWithArchive(ctx, 2, func(_ context.Context, sid string, data session.Data) {
+		archived[sid] = data["cart"]
+	})
+	require.Nil(t, err)
+	assert.Equal(t,
+		map[string]interface{}{
+			"1": "cart of 1",
+			"2": "cart of 2",
+			"3": "cart of 3",
+		},
+		archived,
+	)
+
+	assert.False(t, store.Exist(ctx, "1"))
+	assert.False(t, store.Exist(ctx, "3"))
+	assert.True(t, store.Exist(ctx, "4"))
+}
+
+func TestMySQLStore_DataType(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := newTestDB(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	store, err := Initer()(ctx,
+		Config{
+			NowFunc:   time.Now,
+			db:        db,
+			InitTable: true,
+			DataType:  DataTypeBlob,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	sess, err := store.Read(ctx, "1")
+	require.Nil(t, err)
+	sess.Set("payload", strings.Repeat("a", 1<<16))
+	err = store.Save(ctx, sess)
+	assert.True(t, errors.Is(err, ErrDataTooLarge))
+
+	_, err = Initer()(ctx,
+		Config{
+			db:       db,
+			DataType: "TINYBLOB",
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	assert.EqualError(t, err, `unsupported data type "TINYBLOB"`)
+}
+
 func TestMySQLStore_Touch(t *testing.T) {
 	ctx := context.Background()
 	db, cleanup := newTestDB(t, ctx)
@@ -219,7 +350,7 @@ func TestMySQLStore_Touch(t *testing.T) {
 	now := time.Now()
 	store, err := Initer()(ctx,
 		Config{
-			nowFunc:   func() time.Time { return now },
+			NowFunc:   func() time.Time { return now },
 			db:        db,
 			Lifetime:  time.Second,
 			InitTable: true,
@@ -242,3 +373,20 @@ func TestMySQLStore_Touch(t *testing.T) {
 	require.Nil(t, err)
 	assert.True(t, store.Exist(ctx, sess.ID()))
 }
+
+func TestMySQLStore_Conformance(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := newTestDB(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	storetest.Conformance(t, Initer(), Config{
+		NowFunc:        time.Now,
+		db:             db,
+		Lifetime:       time.Second,
+		InitTable:      true,
+		EnableTags:     true,
+		EnableCounters: true,
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package mysql
+
+import (
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replicaSet routes reads of sessions to read replicas in round-robin, except
+// for sessions that have been written by the current instance within the
+// maximum staleness. Writes by other instances are unknown to the replica set,
+// thus reads of those sessions may be stale within the replication lag. A nil
+// replicaSet routes all reads to the primary.
+type replicaSet struct {
+	dbs          []*sql.DB     // The connections to read replicas
+	maxStaleness time.Duration // The maximum replication lag to tolerate
+	next         atomic.Uint64 // The counter to pick read replicas in round-robin
+
+	lock    sync.Mutex
+	written map[string]time.Time // The times when sessions were last written
+}
+
+// newReplicaSet returns a new replica set of given read replicas, or nil if
+// there is no read replica.
+func newReplicaSet(dbs []*sql.DB, maxStaleness time.Duration) *replicaSet {
+	if len(dbs) == 0 {
+		return nil
+	}
+	return &replicaSet{
+		dbs:          dbs,
+		maxStaleness: maxStaleness,
+		written:      make(map[string]time.Time),
+	}
+}
+
+// pick returns the read replica to read the session with given ID, or nil if
+// the session should be read from the primary.
+func (r *replicaSet) pick(sid string, now time.Time) *sql.DB {
+	if r == nil {
+		return nil
+	}
+
+	r.lock.Lock()
+	writtenAt, ok := r.written[sid]
+	r.lock.Unlock()
+	if ok && now.Sub(writtenAt) < r.maxStaleness {
+		return nil
+	}
+	return r.dbs[int(r.next.Add(1)%uint64(len(r.dbs)))]
+}
+
+// wrote records the session with given ID as written at the time.
+func (r *replicaSet) wrote(sid string, now time.Time) {
+	if r == nil || r.maxStaleness <= 0 {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// Forget sessions that are out of the maximum staleness to bound the memory
+	// usage by the number of recently written sessions.
+	if len(r.written) >= 1024 {
+		for id, at := range r.written {
+			if now.Sub(at) >= r.maxStaleness {
+				delete(r.written, id)
+			}
+		}
+	}
+	r.written[sid] = now
+}
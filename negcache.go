@@ -0,0 +1,88 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// NegativeCacheOptions contains options for caching session IDs that are
+// found missing in the session store, which keeps clients replaying dead
+// session IDs (e.g. bots) from hitting the session store on every request.
+type NegativeCacheOptions struct {
+	// Size is the maximum number of session IDs to cache. Default is 0, i.e. the
+	// negative cache is disabled.
+	Size int
+	// TTL is the duration to cache a missing session ID. Default is 1 minute.
+	TTL time.Duration
+}
+
+// negativeCache is a cache of session IDs that are missing in the session
+// store.
+type negativeCache struct {
+	size    int
+	ttl     time.Duration
+	nowFunc func() time.Time // The function to return the current time
+
+	lock    sync.Mutex           // The mutex to guard accesses to the entries
+	entries map[string]time.Time // The expiry times indexed by session IDs
+}
+
+// newNegativeCache returns a new negative cache based on given options, or nil
+// if the negative cache is disabled.
+func newNegativeCache(opts NegativeCacheOptions) *negativeCache {
+	if opts.Size <= 0 {
+		return nil
+	}
+
+	if opts.TTL <= 0 {
+		opts.TTL = time.Minute
+	}
+	return &negativeCache{
+		size:    opts.Size,
+		ttl:     opts.TTL,
+		nowFunc: time.Now,
+		entries: make(map[string]time.Time, opts.Size),
+	}
+}
+
+// contains returns true if the session ID is cached as missing.
+func (c *negativeCache) contains(sid string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	expiresAt, ok := c.entries[sid]
+	if !ok {
+		return false
+	} else if !c.nowFunc().Before(expiresAt) {
+		delete(c.entries, sid)
+		return false
+	}
+	return true
+}
+
+// add caches the session ID as missing. When the cache is full, expired
+// entries are evicted first, then arbitrary entries.
+func (c *negativeCache) add(sid string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.nowFunc()
+	if _, ok := c.entries[sid]; !ok && len(c.entries) >= c.size {
+		for k, expiresAt := range c.entries {
+			if !now.Before(expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < c.size {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[sid] = now.Add(c.ttl)
+}
@@ -0,0 +1,86 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegativeCache(t *testing.T) {
+	assert.Nil(t, newNegativeCache(NegativeCacheOptions{}))
+
+	now := time.Now()
+	c := newNegativeCache(NegativeCacheOptions{Size: 2, TTL: time.Minute})
+	c.nowFunc = func() time.Time { return now }
+
+	c.add("1")
+	assert.True(t, c.contains("1"))
+	assert.False(t, c.contains("2"))
+
+	// Expired entries are evicted first when the cache is full
+	now = now.Add(30 * time.Second)
+	c.add("2")
+	now = now.Add(40 * time.Second)
+	c.add("3")
+	assert.False(t, c.contains("1"))
+	assert.True(t, c.contains("2"))
+	assert.True(t, c.contains("3"))
+
+	// Arbitrary entries are evicted when none has expired
+	c.add("4")
+	assert.True(t, c.contains("4"))
+	assert.Len(t, c.entries, 2)
+
+	now = now.Add(time.Minute)
+	assert.False(t, c.contains("4"))
+}
+
+type existCountingStore struct {
+	noopStore
+	exists int
+}
+
+func (s *existCountingStore) Exist(context.Context, string) bool {
+	s.exists++
+	return false
+}
+
+func TestManager_NegativeCache(t *testing.T) {
+	store := &existCountingStore{}
+	m := newManager(store, Options{
+		IDLength: 16,
+		NegativeCache: NegativeCacheOptions{
+			Size: 10,
+		},
+	})
+
+	const deadSID = "0123456789abcdef"
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+
+	sess, created, err := m.load(r, deadSID)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.NotEqual(t, deadSID, sess.ID(), "missing session ID is adopted")
+	assert.Equal(t, 1, store.exists)
+
+	// Cached missing session IDs do not reach the session store
+	sess, created, err = m.load(r, deadSID)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.NotEqual(t, deadSID, sess.ID())
+	assert.Equal(t, 1, store.exists)
+
+	lazy, err := m.loadLazy(r, deadSID, func(string, bool) {})
+	require.NoError(t, err)
+	assert.NotEqual(t, deadSID, lazy.ID())
+	assert.Equal(t, 1, store.exists)
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"fmt"
+)
+
+// Open reads the session with given ID from the session store for accessing it
+// outside HTTP requests, e.g. in background jobs, gRPC handlers and message
+// consumers. A new session with the ID is returned if no such session exists.
+// Counters and lists are maintained by the session store when supported, as
+// the session.Sessioner does with the given context.
+//
+// The returned save function must be called once done with the session, which
+// saves the session if it has changed or extends its lifetime otherwise, the
+// same as at the end of a request. Unlike a request, the session information
+// (see session.InfoOf) is left untouched as there is no visitor.
+//
+// Example:
+//
+//	s, save, err := session.Open(ctx, store, sid)
+//	if err != nil {
+//		return err
+//	}
+//	s.Set("export_ready", true)
+//	return save(ctx)
+func Open(ctx context.Context, store Store, sid string) (s Session, save func(ctx context.Context) error, err error) {
+	sess, err := store.Read(ctx, sid)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read: %w", err)
+	}
+	// The session may be shared by concurrent requests, see Sessioner.
+	sess = viewOf(sess)
+
+	caps := Capabilities(store)
+	rc := &requestContext{ctx: ctx}
+	if caps.Incr {
+		inc, _ := StoreAs[Incrementer](store)
+		rc.incr = func(key string, delta int64) (int64, error) {
+			return inc.Incr(ctx, sess.ID(), key, delta)
+		}
+	}
+	if caps.Lists {
+		ls, _ := StoreAs[ListStore](store)
+		// A bare manager applies no timeouts nor retries
+		mgr := &manager{store: store}
+		rc.lists = mgr.lists(ctx, ls, sess.ID)
+	}
+	setRequestContext(sess, rc)
+
+	save = func(ctx context.Context) error {
+		if sess.HasChanged() {
+			err := store.Save(ctx, sess)
+			if err != nil {
+				return fmt.Errorf("save: %w", err)
+			}
+			return nil
+		}
+
+		err := store.Touch(ctx, sess.ID())
+		if err != nil {
+			return fmt.Errorf("touch: %w", err)
+		}
+		return nil
+	}
+	return sess, save, nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	store, err := FileIniter()(ctx,
+		FileConfig{
+			RootDir: t.TempDir(),
+		},
+		IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.NoError(t, err)
+
+	s, save, err := Open(ctx, store, "111")
+	require.NoError(t, err)
+	assert.Equal(t, "111", s.ID())
+	s.Set("job", "export")
+	require.NoError(t, save(ctx))
+	assert.True(t, store.Exist(ctx, "111"))
+
+	s, save, err = Open(ctx, store, "111")
+	require.NoError(t, err)
+	assert.Equal(t, "export", s.Get("job"))
+	assert.True(t, InfoOf(s).LastSeenAt.IsZero(), "session information is left untouched")
+
+	// Unchanged sessions are only touched
+	touches := &writeCountingStore{Store: store}
+	s, save, err = Open(ctx, touches, "111")
+	require.NoError(t, err)
+	assert.Equal(t, "export", s.Get("job"))
+	require.NoError(t, save(ctx))
+	assert.Equal(t, 0, touches.saves)
+	assert.Equal(t, 1, touches.touches)
+}
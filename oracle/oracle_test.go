@@ -0,0 +1,111 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package oracle
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/session"
+	"github.com/flamego/session/storetest"
+)
+
+func newTestDB(t *testing.T, ctx context.Context) (testDB *sql.DB, table string) {
+	db, err := sql.Open("oracle", os.Getenv("ORACLE_DSN"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	// Oracle has no cheap way to create databases, tests use a table of their own
+	// instead.
+	table = fmt.Sprintf("flamego_test_sessions_%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		defer func() { _ = db.Close() }()
+
+		if t.Failed() {
+			t.Logf("TABLE %s left intact for inspection", table)
+			return
+		}
+
+		_, err := db.ExecContext(ctx, `DROP TABLE `+quoteIdentifier(table)+` PURGE`)
+		if err != nil {
+			t.Fatalf("Failed to drop test table: %v", err)
+		}
+	})
+	return db, table
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	assert.Equal(t, `"sessions"`, quoteIdentifier("sessions"))
+	assert.Equal(t, `"app"."sessions"`, quoteIdentifier("app.sessions"))
+}
+
+func TestOracleStore_GC(t *testing.T) {
+	for _, timeType := range []TimeType{TimeTypeTimestamp, TimeTypeDate} {
+		t.Run(string(timeType), func(t *testing.T) {
+			ctx := context.Background()
+			db, table := newTestDB(t, ctx)
+
+			now := time.Now()
+			store, err := Initer()(ctx,
+				Config{
+					NowFunc:   func() time.Time { return now },
+					db:        db,
+					Table:     table,
+					Lifetime:  time.Second,
+					InitTable: true,
+					TimeType:  timeType,
+				},
+				session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+			)
+			require.Nil(t, err)
+
+			sess1, err := store.Read(ctx, "1")
+			require.Nil(t, err)
+			sess1.Set("name", "flamego")
+			err = store.Save(ctx, sess1)
+			require.Nil(t, err)
+
+			now = now.Add(2 * time.Second)
+			sess2, err := store.Read(ctx, "2")
+			require.Nil(t, err)
+			err = store.Save(ctx, sess2)
+			require.Nil(t, err)
+
+			// Read on an expired session should wipe data but preserve the record
+			tmp, err := store.Read(ctx, "1")
+			require.Nil(t, err)
+			assert.Nil(t, tmp.Get("name"))
+			assert.True(t, store.Exist(ctx, "1"))
+
+			err = store.GC(ctx) // sess1 should be recycled
+			require.Nil(t, err)
+
+			assert.False(t, store.Exist(ctx, "1"))
+			assert.True(t, store.Exist(ctx, "2"))
+		})
+	}
+}
+
+func TestOracleStore_Conformance(t *testing.T) {
+	ctx := context.Background()
+	db, table := newTestDB(t, ctx)
+
+	storetest.Conformance(t, Initer(), Config{
+		NowFunc:   time.Now,
+		db:        db,
+		Table:     table,
+		Lifetime:  time.Second,
+		InitTable: true,
+	})
+}
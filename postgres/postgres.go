@@ -7,12 +7,13 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/stdlib"
-	"github.com/pkg/errors"
 
 	"github.com/flamego/session"
 )
@@ -24,7 +25,12 @@ type postgresStore struct {
 	nowFunc  func() time.Time // The function to return the current time
 	lifetime time.Duration    // The duration to have access to a session before being recycled
 	db       *sql.DB          // The database connection
+	schema   string           // The database schema of tables, empty for the search path
 	table    string           // The database table for storing session data
+	tags     bool             // Whether to persist session tags
+	counters bool             // Whether to maintain session counters
+	gcLock   bool             // Whether to skip GC when another instance is performing GC
+	replicas *replicaSet      // The read replicas, nil if reading from the primary only
 
 	encoder  session.Encoder
 	decoder  session.Decoder
@@ -35,10 +41,15 @@ type postgresStore struct {
 // configuration.
 func newPostgresStore(cfg Config, idWriter session.IDWriter) *postgresStore {
 	return &postgresStore{
-		nowFunc:  cfg.nowFunc,
+		nowFunc:  cfg.NowFunc,
 		lifetime: cfg.Lifetime,
 		db:       cfg.db,
+		schema:   cfg.Schema,
 		table:    cfg.Table,
+		tags:     cfg.EnableTags,
+		counters: cfg.EnableCounters,
+		gcLock:   cfg.EnableGCLock,
+		replicas: newReplicaSet(cfg.ReadDBs, cfg.MaxStaleness),
 		encoder:  cfg.Encoder,
 		decoder:  cfg.Decoder,
 		idWriter: idWriter,
@@ -46,102 +57,587 @@ func newPostgresStore(cfg Config, idWriter session.IDWriter) *postgresStore {
 }
 
 func (s *postgresStore) Exist(ctx context.Context, sid string) bool {
+	ok, _ := s.CheckExist(ctx, sid)
+	return ok
+}
+
+var _ session.ExistChecker = (*postgresStore)(nil)
+
+// CheckExist checks the read replica first if any, sessions that are missing
+// in the read replica are checked again in the primary as they may have not
+// been replicated yet.
+func (s *postgresStore) CheckExist(ctx context.Context, sid string) (bool, error) {
+	if replica := s.replicas.pick(sid, s.nowFunc()); replica != nil {
+		exists, err := s.checkExist(ctx, replica, sid)
+		if err == nil && exists {
+			return true, nil
+		}
+	}
+	return s.checkExist(ctx, s.db, sid)
+}
+
+// checkExist returns true if the session with given ID exists in the database.
+func (s *postgresStore) checkExist(ctx context.Context, db *sql.DB, sid string) (bool, error) {
 	var exists bool
-	q := fmt.Sprintf(`SELECT EXISTS (SELECT FROM %q WHERE key = $1)`, s.table)
-	err := s.db.QueryRowContext(ctx, q, sid).Scan(&exists)
-	return err == nil && exists
+	q := fmt.Sprintf(`SELECT EXISTS (SELECT FROM %s WHERE key = $1)`, s.tableIdent())
+	err := db.QueryRowContext(ctx, q, sid).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("query: %w", err)
+	}
+	return exists, nil
 }
 
+// Read reads from the read replica first if any, sessions that are missing,
+// expired or fail to be read in the read replica are read again from the
+// primary as they may have not been replicated yet.
 func (s *postgresStore) Read(ctx context.Context, sid string) (session.Session, error) {
+	if replica := s.replicas.pick(sid, s.nowFunc()); replica != nil {
+		sess, err := s.read(ctx, replica, sid)
+		if err == nil && sess != nil {
+			return sess, nil
+		}
+	}
+
+	sess, err := s.read(ctx, s.db, sid)
+	if err != nil {
+		return nil, err
+	} else if sess == nil {
+		return session.NewBaseSession(sid, s.encoder, s.idWriter), nil
+	}
+	return sess, nil
+}
+
+// read returns the session with given ID in the database, or nil if no such
+// session exists or it has expired.
+func (s *postgresStore) read(ctx context.Context, db *sql.DB, sid string) (session.Session, error) {
 	var binary []byte
 	var expiredAt time.Time
-	q := fmt.Sprintf(`SELECT data, expired_at FROM %q WHERE key = $1`, s.table)
-	err := s.db.QueryRowContext(ctx, q, sid).Scan(&binary, &expiredAt)
+	var tags []byte
+	columns := "data, expired_at"
+	dest := []interface{}{&binary, &expiredAt}
+	if s.tags {
+		columns += ", tags"
+		dest = append(dest, &tags)
+	}
+	q := fmt.Sprintf(`SELECT %s FROM %s WHERE key = $1`, columns, s.tableIdent())
+	err := db.QueryRowContext(ctx, q, sid).Scan(dest...)
 	if err == nil {
 		// Discard existing data if it's expired
-		if !s.nowFunc().Before(expiredAt.Add(s.lifetime)) {
-			return session.NewBaseSession(sid, s.encoder, s.idWriter), nil
+		if !s.nowFunc().Before(expiredAt) {
+			return nil, nil
 		}
 
-		data, err := s.decoder(binary)
+		return s.decode(sid, binary, tags)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	return nil, nil
+}
+
+// decode returns the session with given ID of the encoded session data and the
+// tags in JSON.
+func (s *postgresStore) decode(sid string, binary, tags []byte) (session.Session, error) {
+	data, err := s.decoder(binary)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	sess := session.NewBaseSessionWithData(sid, s.encoder, s.idWriter, data)
+	if len(tags) > 0 {
+		var m map[string]string
+		err = json.Unmarshal(tags, &m)
 		if err != nil {
-			return nil, errors.Wrap(err, "decode")
+			return nil, fmt.Errorf("unmarshal tags: %w", err)
 		}
-		return session.NewBaseSessionWithData(sid, s.encoder, s.idWriter, data), nil
-	} else if err != sql.ErrNoRows {
-		return nil, errors.Wrap(err, "select")
+		sess.LoadTags(m)
+	}
+	return sess, nil
+}
+
+var _ session.MultiReader = (*postgresStore)(nil)
+
+// ReadMany reads unexpired sessions from the primary with a single query.
+func (s *postgresStore) ReadMany(ctx context.Context, sids []string) (map[string]session.Session, error) {
+	sessions := make(map[string]session.Session, len(sids))
+	if len(sids) == 0 {
+		return sessions, nil
 	}
 
-	return session.NewBaseSession(sid, s.encoder, s.idWriter), nil
+	columns := "key, data"
+	if s.tags {
+		columns += ", tags"
+	}
+	q := fmt.Sprintf(`SELECT %s FROM %s WHERE key = ANY($1) AND expired_at > $2`, columns, s.tableIdent())
+	rows, err := s.db.QueryContext(ctx, q, sids, s.nowFunc().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var sid string
+		var binary, tags []byte
+		dest := []interface{}{&sid, &binary}
+		if s.tags {
+			dest = append(dest, &tags)
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		sessions[sid], err = s.decode(sid, binary, tags)
+		if err != nil {
+			return nil, fmt.Errorf("session %q: %w", sid, err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate: %w", err)
+	}
+	return sessions, nil
 }
 
 func (s *postgresStore) Destroy(ctx context.Context, sid string) error {
-	q := fmt.Sprintf(`DELETE FROM %q WHERE key = $1`, s.table)
+	s.replicas.wrote(sid, s.nowFunc())
+	q := fmt.Sprintf(`DELETE FROM %s WHERE key = $1`, s.tableIdent())
 	_, err := s.db.ExecContext(ctx, q, sid)
+	if err != nil || !s.counters {
+		return err
+	}
+
+	q = fmt.Sprintf(`DELETE FROM %s WHERE key = $1`, s.countersTable())
+	_, err = s.db.ExecContext(ctx, q, sid)
 	return err
 }
 
 func (s *postgresStore) Touch(ctx context.Context, sid string) error {
-	q := fmt.Sprintf(`UPDATE %q SET expired_at = $1 WHERE key = $2`, s.table)
+	s.replicas.wrote(sid, s.nowFunc())
+	q := fmt.Sprintf(`UPDATE %s SET expired_at = $1 WHERE key = $2`, s.tableIdent())
 	_, err := s.db.ExecContext(ctx, q, s.nowFunc().Add(s.lifetime).UTC(), sid)
 	if err != nil {
-		return errors.Wrap(err, "update")
+		return fmt.Errorf("update: %w", err)
 	}
 	return nil
 }
 
 func (s *postgresStore) Save(ctx context.Context, sess session.Session) error {
+	s.replicas.wrote(sess.ID(), s.nowFunc())
 	binary, err := sess.Encode()
 	if err != nil {
-		return errors.Wrap(err, "encode")
+		return fmt.Errorf("encode: %w", err)
+	}
+
+	if s.tags {
+		tags, err := json.Marshal(session.TagsOf(sess))
+		if err != nil {
+			return fmt.Errorf("marshal tags: %w", err)
+		}
+
+		q := fmt.Sprintf(`
+INSERT INTO %s (key, data, expired_at, tags)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (key)
+DO UPDATE SET
+	data       = excluded.data,
+	expired_at = excluded.expired_at,
+	tags       = excluded.tags
+`, s.tableIdent())
+		_, err = s.db.ExecContext(ctx, q, sess.ID(), binary, s.nowFunc().Add(s.lifetime).UTC(), string(tags))
+		if err != nil {
+			return fmt.Errorf("upsert: %w", err)
+		}
+		return nil
 	}
 
 	q := fmt.Sprintf(`
-INSERT INTO %q (key, data, expired_at)
+INSERT INTO %s (key, data, expired_at)
 VALUES ($1, $2, $3)
 ON CONFLICT (key)
 DO UPDATE SET
 	data       = excluded.data,
 	expired_at = excluded.expired_at
-`, s.table)
+`, s.tableIdent())
 	_, err = s.db.ExecContext(ctx, q, sess.ID(), binary, s.nowFunc().Add(s.lifetime).UTC())
 	if err != nil {
-		return errors.Wrap(err, "upsert")
+		return fmt.Errorf("upsert: %w", err)
 	}
 	return nil
 }
 
 func (s *postgresStore) GC(ctx context.Context) error {
-	q := fmt.Sprintf(`DELETE FROM %q WHERE expired_at <= $1`, s.table)
-	_, err := s.db.ExecContext(ctx, q, s.nowFunc().UTC())
+	return s.GCWithArchive(ctx, 0, nil)
+}
+
+var _ session.ExpiryArchiver = (*postgresStore)(nil)
+
+// GCWithArchive implements `session.ExpiryArchiver.GCWithArchive`. Expired
+// sessions whose data cannot be decoded are recycled without calling the
+// onExpire.
+func (s *postgresStore) GCWithArchive(ctx context.Context, batchSize int, onExpire session.OnExpireFunc) error {
+	if !s.gcLock {
+		return s.gc(ctx, s.db, batchSize, onExpire)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// The advisory lock is released when the transaction ends
+	var locked bool
+	err = tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock(hashtext($1))`, gcLockPrefix+s.lockName()).Scan(&locked)
+	if err != nil {
+		return fmt.Errorf("try advisory lock: %w", err)
+	} else if !locked {
+		return nil // Another instance is performing GC
+	}
+
+	err = s.gc(ctx, tx, batchSize, onExpire)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// gcLockPrefix is the prefix of names of advisory locks for GC operations,
+// which is followed by the table name (qualified by the schema if any).
+const gcLockPrefix = "flamego::session::gc::"
+
+// queryer executes queries on a database connection or a transaction.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// gc recycles expired sessions and their counters with the queryer. Expired
+// sessions are passed to the onExpire in batches if it is not nil.
+func (s *postgresStore) gc(ctx context.Context, db queryer, batchSize int, onExpire session.OnExpireFunc) error {
+	now := s.nowFunc().UTC()
+	if onExpire != nil {
+		if batchSize < 1 {
+			batchSize = 1
+		}
+		for {
+			n, err := s.archiveExpired(ctx, db, now, batchSize, onExpire)
+			if err != nil {
+				return err
+			} else if n < batchSize {
+				break
+			}
+		}
+	}
+
+	q := fmt.Sprintf(`DELETE FROM %s WHERE expired_at <= $1`, s.tableIdent())
+	_, err := db.ExecContext(ctx, q, now)
+	if err != nil || !s.counters {
+		return err
+	}
+
+	// Recycle counters of sessions that no longer exist
+	q = fmt.Sprintf(`DELETE FROM %s WHERE key NOT IN (SELECT key FROM %s)`, s.countersTable(), s.tableIdent())
+	_, err = db.ExecContext(ctx, q)
 	return err
 }
 
+// archiveExpired calls the onExpire with a batch of expired sessions and deletes
+// them. It returns the number of sessions in the batch.
+func (s *postgresStore) archiveExpired(ctx context.Context, db queryer, now time.Time, batchSize int, onExpire session.OnExpireFunc) (int, error) {
+	q := fmt.Sprintf(`SELECT key, data FROM %s WHERE expired_at <= $1 LIMIT $2`, s.tableIdent())
+	rows, err := db.QueryContext(ctx, q, now, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("select: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var sids []string
+	var binaries [][]byte
+	for rows.Next() {
+		var sid string
+		var binary []byte
+		err = rows.Scan(&sid, &binary)
+		if err != nil {
+			return 0, fmt.Errorf("scan: %w", err)
+		}
+		sids = append(sids, sid)
+		binaries = append(binaries, binary)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate: %w", err)
+	}
+	_ = rows.Close()
+	if len(sids) == 0 {
+		return 0, nil
+	}
+
+	for i := range sids {
+		data, err := s.decoder(binaries[i])
+		if err == nil {
+			onExpire(ctx, sids[i], data)
+		}
+	}
+
+	q = fmt.Sprintf(`DELETE FROM %s WHERE expired_at <= $1 AND key = ANY($2)`, s.tableIdent())
+	_, err = db.ExecContext(ctx, q, now, sids)
+	if err != nil {
+		return 0, fmt.Errorf("delete: %w", err)
+	}
+	return len(sids), nil
+}
+
+// qualifiedIdent returns the quoted identifier of the table with given name,
+// which is qualified by the schema if any to be independent of the search path.
+func qualifiedIdent(schema, table string) string {
+	if schema == "" {
+		return pgx.Identifier{table}.Sanitize()
+	}
+	return pgx.Identifier{schema, table}.Sanitize()
+}
+
+// tableIdent returns the quoted identifier of the table for storing session
+// data.
+func (s *postgresStore) tableIdent() string {
+	return qualifiedIdent(s.schema, s.table)
+}
+
+// countersTable returns the quoted identifier of the table for storing session
+// counters.
+func (s *postgresStore) countersTable() string {
+	return qualifiedIdent(s.schema, s.table+"_counters")
+}
+
+// lockName returns the name of the table for advisory locks, which is qualified
+// by the schema if any.
+func (s *postgresStore) lockName() string {
+	if s.schema == "" {
+		return s.table
+	}
+	return s.schema + "." + s.table
+}
+
+var _ session.Incrementer = (*postgresStore)(nil)
+
+// Incr increments the counter with an upsert, which is atomic in the database.
+// It requires Config.EnableCounters to be set.
+func (s *postgresStore) Incr(ctx context.Context, sid, key string, delta int64) (int64, error) {
+	if !s.counters {
+		return 0, errors.New("counters are not enabled")
+	}
+
+	q := fmt.Sprintf(`
+INSERT INTO %s AS c (key, name, value)
+VALUES ($1, $2, $3)
+ON CONFLICT (key, name)
+DO UPDATE SET value = c.value + excluded.value
+RETURNING value
+`, s.countersTable())
+	var n int64
+	err := s.db.QueryRowContext(ctx, q, sid, key, delta).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("upsert: %w", err)
+	}
+	return n, nil
+}
+
+var _ session.EncoderReporter = (*postgresStore)(nil)
+
+func (s *postgresStore) Encoder() session.Encoder {
+	return s.encoder
+}
+
+var _ session.Expirer = (*postgresStore)(nil)
+
+func (s *postgresStore) ExpiresAt(ctx context.Context, sid string) (time.Time, error) {
+	var expiredAt time.Time
+	q := fmt.Sprintf(`SELECT expired_at FROM %s WHERE key = $1`, s.tableIdent())
+	err := s.db.QueryRowContext(ctx, q, sid).Scan(&expiredAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("select: %w", err)
+	}
+	return expiredAt, nil
+}
+
+var _ session.TagFinder = (*postgresStore)(nil)
+
+// FindByTag returns IDs of sessions that have the tag of given key with the
+// value. It requires Config.EnableTags to be set.
+func (s *postgresStore) FindByTag(ctx context.Context, key, value string) ([]string, error) {
+	if !s.tags {
+		return nil, errors.New("tags are not enabled")
+	}
+
+	tag, err := json.Marshal(map[string]string{key: value})
+	if err != nil {
+		return nil, fmt.Errorf("marshal tag: %w", err)
+	}
+
+	q := fmt.Sprintf(`SELECT key FROM %s WHERE tags @> $1::jsonb`, s.tableIdent())
+	rows, err := s.db.QueryContext(ctx, q, string(tag))
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var sids []string
+	for rows.Next() {
+		var sid string
+		err = rows.Scan(&sid)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		sids = append(sids, sid)
+	}
+	return sids, rows.Err()
+}
+
+var _ session.Lister = (*postgresStore)(nil)
+
+func (s *postgresStore) List(ctx context.Context) ([]string, error) {
+	q := fmt.Sprintf(`SELECT key FROM %s`, s.tableIdent())
+	rows, err := s.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var sids []string
+	for rows.Next() {
+		var sid string
+		err = rows.Scan(&sid)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		sids = append(sids, sid)
+	}
+	return sids, rows.Err()
+}
+
+var _ session.ActiveCounter = (*postgresStore)(nil)
+
+func (s *postgresStore) CountActive(ctx context.Context) (int, error) {
+	q := fmt.Sprintf(`SELECT COUNT(*) FROM %s`, s.tableIdent())
+	var n int
+	err := s.db.QueryRowContext(ctx, q).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("select: %w", err)
+	}
+	return n, nil
+}
+
+var _ session.CapabilityReporter = (*postgresStore)(nil)
+
+// Capabilities reports tags and counters only when they are enabled.
+func (s *postgresStore) Capabilities() session.StoreCapabilities {
+	return session.StoreCapabilities{
+		List:        true,
+		ExpiresAt:   true,
+		FindByTag:   s.tags,
+		Incr:        s.counters,
+		ArchiveOnGC: true,
+		CountActive: true,
+	}
+}
+
 // Config contains options for the Postgres session store.
 type Config struct {
 	// For tests only
-	nowFunc func() time.Time
-	db      *sql.DB
+	db *sql.DB
 
 	// Lifetime is the duration to have no access to a session before being
 	// recycled. Default is 3600 seconds.
 	Lifetime time.Duration
+	// NowFunc is the function to return the current time for expiry of sessions,
+	// e.g. to simulate the passage of time in integration tests. Default is
+	// time.Now.
+	NowFunc func() time.Time
 	// DSN is the database source name to the Postgres.
 	DSN string
 	// Table is the table name for storing session data. Default is "sessions".
 	Table string
+	// Schema is the schema of tables for storing session data, which qualifies
+	// names of tables in all queries to be independent of the search_path. The
+	// schema is created by InitTable. Default is unqualified, i.e. resolved by
+	// the search_path.
+	Schema string
 	// Encoder is the encoder to encode session data. Default is session.GobEncoder.
 	Encoder session.Encoder
 	// Decoder is the decoder to decode session data. Default is session.GobDecoder.
 	Decoder session.Decoder
 	// InitTable indicates whether to create a default session table when not exists automatically.
 	InitTable bool
+	// EnableTags indicates whether to persist session tags to the "tags" column
+	// with the type JSONB, which enables finding sessions by tags. The column and
+	// a GIN index on it are created by InitTable.
+	EnableTags bool
+	// EnableCounters indicates whether to maintain session counters in the table
+	// with the name of Table suffixed by "_counters", which makes session.Incr
+	// atomic across instances. The table is created by InitTable.
+	EnableCounters bool
+	// EnableGCLock indicates whether to hold a transaction-level advisory lock
+	// during GC operations, which makes instances skip GC when another instance
+	// is performing GC on the same table.
+	EnableGCLock bool
+	// ReadDBs is the list of connections to read replicas, which serve reads of
+	// sessions (i.e. Exist and Read) in round-robin while writes and GC go to the
+	// primary. Sessions that are missing or expired in a read replica, or fail to
+	// be read from it, are read again from the primary. Default is reading from
+	// the primary only.
+	//
+	// Only writes made by the same instance are known to be not yet replicated
+	// (see MaxStaleness), thus sessions that are saved, touched or destroyed by
+	// other instances may be read in their previous state within the replication
+	// lag. Notably, destroying a session (e.g. signing out) is not guaranteed to
+	// take effect immediately across instances, and applications that require so
+	// must not enable read replicas.
+	ReadDBs []*sql.DB
+	// ReadDSNs is the list of database source names to read replicas, which are
+	// opened in addition to ReadDBs.
+	ReadDSNs []string
+	// MaxStaleness is the maximum replication lag of read replicas to tolerate,
+	// sessions that have been written by this instance within the duration are
+	// read from the primary, which is tracked in memory of the instance. Default
+	// is 5 seconds.
+	MaxStaleness time.Duration
+	// MaxOpenConns is the maximum number of open connections to the database,
+	// which only applies when the database is opened by the store (i.e. via DSN
+	// and ReadDSNs). Default is unlimited.
+	MaxOpenConns int
+	// MaxIdleConns is the maximum number of idle connections to the database,
+	// which only applies when the database is opened by the store. Negative value
+	// means no idle connections are retained. Default is 2.
+	MaxIdleConns int
+	// ConnMaxLifetime is the maximum duration a connection may be reused, which
+	// only applies when the database is opened by the store. Default is unlimited.
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime is the maximum duration a connection may be idle before
+	// being closed, which only applies when the database is opened by the store.
+	// Default is unlimited.
+	ConnMaxIdleTime time.Duration
+}
+
+// setPool applies the connection pool settings to the database opened by the
+// store.
+func (cfg *Config) setPool(db *sql.DB) {
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
 }
 
 func openDB(dsn string) (*sql.DB, error) {
 	config, err := pgx.ParseConfig(dsn)
 	if err != nil {
-		return nil, errors.Wrap(err, "parse config")
+		return nil, fmt.Errorf("parse config: %w", err)
 	}
 	return stdlib.OpenDB(*config), nil
 }
@@ -172,30 +668,82 @@ func Initer() session.Initer {
 		if cfg.db == nil {
 			db, err := openDB(cfg.DSN)
 			if err != nil {
-				return nil, errors.Wrap(err, "open database")
+				return nil, fmt.Errorf("open database: %w", err)
 			}
+			cfg.setPool(db)
 			cfg.db = db
 		}
+		for _, dsn := range cfg.ReadDSNs {
+			db, err := openDB(dsn)
+			if err != nil {
+				return nil, fmt.Errorf("open read replica: %w", err)
+			}
+			cfg.setPool(db)
+			cfg.ReadDBs = append(cfg.ReadDBs, db)
+		}
 
 		if cfg.InitTable {
-			q := `
-CREATE TABLE IF NOT EXISTS sessions (
+			// The default tables are created in the schema if any, or otherwise the
+			// first schema in the search_path.
+			table := qualifiedIdent(cfg.Schema, "sessions")
+			if cfg.Schema != "" {
+				q := `CREATE SCHEMA IF NOT EXISTS ` + pgx.Identifier{cfg.Schema}.Sanitize()
+				_, err := cfg.db.ExecContext(ctx, q)
+				if err != nil {
+					return nil, fmt.Errorf("create schema: %w", err)
+				}
+			}
+
+			q := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
 	key        TEXT PRIMARY KEY,
 	data       BYTEA NOT NULL,
-	expired_at TIMESTAMP WITH TIME ZONE NOT NULL
-)`
+	expired_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	tags       JSONB
+)`, table)
 			_, err := cfg.db.ExecContext(ctx, q)
 			if err != nil {
-				return nil, errors.Wrap(err, "create table")
+				return nil, fmt.Errorf("create table: %w", err)
+			}
+
+			if cfg.EnableTags {
+				q = fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS tags JSONB`, table)
+				_, err = cfg.db.ExecContext(ctx, q)
+				if err != nil {
+					return nil, fmt.Errorf("add tags column: %w", err)
+				}
+
+				q = fmt.Sprintf(`CREATE INDEX IF NOT EXISTS sessions_tags_idx ON %s USING GIN (tags)`, table)
+				_, err = cfg.db.ExecContext(ctx, q)
+				if err != nil {
+					return nil, fmt.Errorf("create tags index: %w", err)
+				}
+			}
+
+			if cfg.EnableCounters {
+				q = fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+	key   TEXT NOT NULL,
+	name  TEXT NOT NULL,
+	value BIGINT NOT NULL,
+	PRIMARY KEY (key, name)
+)`, qualifiedIdent(cfg.Schema, "sessions_counters"))
+				_, err = cfg.db.ExecContext(ctx, q)
+				if err != nil {
+					return nil, fmt.Errorf("create counters table: %w", err)
+				}
 			}
 		}
 
-		if cfg.nowFunc == nil {
-			cfg.nowFunc = time.Now
+		if cfg.NowFunc == nil {
+			cfg.NowFunc = time.Now
 		}
 		if cfg.Lifetime.Seconds() < 1 {
 			cfg.Lifetime = 3600 * time.Second
 		}
+		if cfg.MaxStaleness <= 0 {
+			cfg.MaxStaleness = 5 * time.Second
+		}
 		if cfg.Table == "" {
 			cfg.Table = "sessions"
 		}
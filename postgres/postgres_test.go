@@ -27,6 +27,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/flamego/session"
+	"github.com/flamego/session/storetest"
 )
 
 var flagParseOnce sync.Once
@@ -113,7 +114,7 @@ func TestPostgresStore(t *testing.T) {
 		session.Options{
 			Initer: Initer(),
 			Config: Config{
-				nowFunc:   time.Now,
+				NowFunc:   time.Now,
 				db:        db,
 				InitTable: true,
 			},
@@ -180,7 +181,7 @@ func TestPostgresStore_GC(t *testing.T) {
 	now := time.Now()
 	store, err := Initer()(ctx,
 		Config{
-			nowFunc:   func() time.Time { return now },
+			NowFunc:   func() time.Time { return now },
 			db:        db,
 			Lifetime:  time.Second,
 			InitTable: true,
@@ -223,6 +224,98 @@ func TestPostgresStore_GC(t *testing.T) {
 	assert.False(t, store.Exist(ctx, "3"))
 }
 
+func TestPostgresStore_GCLock(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := newTestDB(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	now := time.Now()
+	store, err := Initer()(ctx,
+		Config{
+			NowFunc:      func() time.Time { return now },
+			db:           db,
+			Lifetime:     time.Second,
+			InitTable:    true,
+			EnableGCLock: true,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	sess, err := store.Read(ctx, "1")
+	require.Nil(t, err)
+	err = store.Save(ctx, sess)
+	require.Nil(t, err)
+	now = now.Add(2 * time.Second)
+
+	// GC is skipped while another instance holds the lock
+	tx, err := db.BeginTx(ctx, nil)
+	require.Nil(t, err)
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, gcLockPrefix+"sessions")
+	require.Nil(t, err)
+	err = store.GC(ctx)
+	require.Nil(t, err)
+	assert.True(t, store.Exist(ctx, "1"))
+
+	require.Nil(t, tx.Commit())
+	err = store.GC(ctx)
+	require.Nil(t, err)
+	assert.False(t, store.Exist(ctx, "1"))
+}
+
+func TestPostgresStore_GCWithArchive(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := newTestDB(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	now := time.Now()
+	store, err := Initer()(ctx,
+		Config{
+			NowFunc:   func() time.Time { return now },
+			db:        db,
+			Lifetime:  time.Second,
+			InitTable: true,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	for _, sid := range []string{"1", "2", "3"} {
+		sess, err := store.Read(ctx, sid)
+		require.Nil(t, err)
+		sess.Set("cart", "cart of "+sid)
+		err = store.Save(ctx, sess)
+		require.Nil(t, err)
+	}
+	now = now.Add(3 * time.Second)
+	alive, err := store.Read(ctx, "4")
+	require.Nil(t, err)
+	err = store.Save(ctx, alive)
+	require.Nil(t, err)
+
+	archived := make(map[string]interface{})
+	err = store.(session.ExpiryArchiver).GCWithArchive(ctx, 2, func(_ context.Context, sid string, data session.Data) {
+		archived[sid] = data["cart"]
+	})
+	require.Nil(t, err)
+	assert.Equal(t,
+		map[string]interface{}{
+			"1": "cart of 1",
+			"2": "cart of 2",
+			"3": "cart of 3",
+		},
+		archived,
+	)
+
+	assert.False(t, store.Exist(ctx, "1"))
+	assert.False(t, store.Exist(ctx, "3"))
+	assert.True(t, store.Exist(ctx, "4"))
+}
+
 func TestPostgresStore_Touch(t *testing.T) {
 	ctx := context.Background()
 	db, cleanup := newTestDB(t, ctx)
@@ -233,7 +326,7 @@ func TestPostgresStore_Touch(t *testing.T) {
 	now := time.Now()
 	store, err := Initer()(ctx,
 		Config{
-			nowFunc:   func() time.Time { return now },
+			NowFunc:   func() time.Time { return now },
 			db:        db,
 			Lifetime:  time.Second,
 			InitTable: true,
@@ -256,3 +349,58 @@ func TestPostgresStore_Touch(t *testing.T) {
 	require.Nil(t, err)
 	assert.True(t, store.Exist(ctx, sess.ID()))
 }
+
+func TestPostgresStore_Schema(t *testing.T) {
+	ctx := context.Background()
+	db, _ := newTestDB(t, ctx)
+
+	store, err := Initer()(ctx,
+		Config{
+			NowFunc:        time.Now,
+			db:             db,
+			Schema:         "app_auth",
+			InitTable:      true,
+			EnableCounters: true,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	sess, err := store.Read(ctx, "1")
+	require.Nil(t, err)
+	sess.Set("name", "flamego")
+	err = store.Save(ctx, sess)
+	require.Nil(t, err)
+	assert.True(t, store.Exist(ctx, sess.ID()))
+
+	n, err := store.(session.Incrementer).Incr(ctx, sess.ID(), "visits", 1)
+	require.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+
+	// Tables should be created in the schema rather than the search_path
+	var exists bool
+	err = db.QueryRowContext(ctx, `SELECT EXISTS (SELECT FROM app_auth.sessions WHERE key = $1)`, sess.ID()).Scan(&exists)
+	require.Nil(t, err)
+	assert.True(t, exists)
+
+	err = db.QueryRowContext(ctx, `SELECT to_regclass('public.sessions') IS NOT NULL`).Scan(&exists)
+	require.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestPostgresStore_Conformance(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := newTestDB(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	storetest.Conformance(t, Initer(), Config{
+		NowFunc:        time.Now,
+		db:             db,
+		Lifetime:       time.Second,
+		InitTable:      true,
+		EnableTags:     true,
+		EnableCounters: true,
+	})
+}
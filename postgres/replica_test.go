@@ -0,0 +1,43 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package postgres
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicaSet(t *testing.T) {
+	t.Run("no replica", func(t *testing.T) {
+		r := newReplicaSet(nil, time.Second)
+		assert.Nil(t, r)
+		assert.Nil(t, r.pick("1", time.Now()))
+		r.wrote("1", time.Now()) // Should not panic
+	})
+
+	t.Run("round-robin", func(t *testing.T) {
+		db1, db2 := &sql.DB{}, &sql.DB{}
+		r := newReplicaSet([]*sql.DB{db1, db2}, time.Second)
+
+		now := time.Now()
+		first := r.pick("1", now)
+		second := r.pick("1", now)
+		assert.NotSame(t, first, second)
+		assert.Same(t, first, r.pick("1", now))
+	})
+
+	t.Run("recently written", func(t *testing.T) {
+		r := newReplicaSet([]*sql.DB{{}}, time.Second)
+
+		now := time.Now()
+		r.wrote("1", now)
+		assert.Nil(t, r.pick("1", now.Add(500*time.Millisecond)))
+		assert.NotNil(t, r.pick("2", now.Add(500*time.Millisecond)))
+		assert.NotNil(t, r.pick("1", now.Add(time.Second)))
+	})
+}
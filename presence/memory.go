@@ -0,0 +1,66 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package presence
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var _ Index = (*memoryIndex)(nil)
+
+// memoryIndex is an in-memory implementation of the index, which is only
+// shared by handlers of the same application instance.
+type memoryIndex struct {
+	lock     sync.RWMutex
+	lastSeen map[string]time.Time // The last seen times indexed by user IDs
+}
+
+// NewMemoryIndex returns a new in-memory index, which is meant for development
+// and single-instance deployments.
+func NewMemoryIndex() Index {
+	return &memoryIndex{
+		lastSeen: make(map[string]time.Time),
+	}
+}
+
+func (idx *memoryIndex) Seen(_ context.Context, userID string, at time.Time) error {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	if at.After(idx.lastSeen[userID]) {
+		idx.lastSeen[userID] = at
+	}
+	return nil
+}
+
+func (idx *memoryIndex) LastSeen(_ context.Context, userID string) (time.Time, error) {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	return idx.lastSeen[userID], nil
+}
+
+func (idx *memoryIndex) CountSince(_ context.Context, since time.Time) (int, error) {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	n := 0
+	for _, at := range idx.lastSeen {
+		if !at.Before(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (idx *memoryIndex) Prune(_ context.Context, before time.Time) error {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	for userID, at := range idx.lastSeen {
+		if at.Before(before) {
+			delete(idx.lastSeen, userID)
+		}
+	}
+	return nil
+}
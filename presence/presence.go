@@ -0,0 +1,149 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package presence maintains an index of the last seen times of users bound to
+// sessions (see session.BindUser), which answers whether users are online and
+// how many users are online.
+package presence
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/flamego/flamego"
+	"github.com/flamego/session"
+)
+
+// Index is the index of the last seen times of users.
+type Index interface {
+	// Seen records the user with given ID as last seen at the time. Earlier times
+	// than the recorded time are ignored.
+	Seen(ctx context.Context, userID string, at time.Time) error
+	// LastSeen returns the time when the user with given ID was last seen, or zero
+	// time if the user has never been seen.
+	LastSeen(ctx context.Context, userID string) (time.Time, error)
+	// CountSince returns the number of users that are last seen since the time.
+	CountSince(ctx context.Context, since time.Time) (int, error)
+	// Prune removes users that are last seen before the time from the index.
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// IsOnline returns true if the user with given ID has been seen within the
+// window, e.g. 5 minutes. Only the NowFunc of the options is used.
+func IsOnline(ctx context.Context, index Index, userID string, window time.Duration, opts ...Options) (bool, error) {
+	opt := parseOptions(opts)
+	lastSeen, err := index.LastSeen(ctx, userID)
+	if err != nil {
+		return false, fmt.Errorf("get last seen: %w", err)
+	}
+	return !lastSeen.IsZero() && opt.NowFunc().Sub(lastSeen) <= window, nil
+}
+
+// OnlineCount returns the number of users that have been seen within the
+// window, e.g. 5 minutes. Only the NowFunc of the options is used.
+func OnlineCount(ctx context.Context, index Index, window time.Duration, opts ...Options) (int, error) {
+	opt := parseOptions(opts)
+	n, err := index.CountSince(ctx, opt.NowFunc().Add(-window))
+	if err != nil {
+		return 0, fmt.Errorf("count: %w", err)
+	}
+	return n, nil
+}
+
+// Options contains options for the presence.Tracker middleware.
+type Options struct {
+	// Throttle is the minimum interval of recording the same user in the index by
+	// each application instance, which keeps the middleware cheap for frequent
+	// requests. The window of IsOnline and OnlineCount should be longer than the
+	// Throttle. Default is 1 minute.
+	Throttle time.Duration
+	// NowFunc is the function to return the current time, which should be the
+	// same as the session.Options.NowFunc. Default is time.Now.
+	NowFunc func() time.Time
+	// ErrorFunc is the function used to print errors of recording users in the
+	// index. Default is to drop errors silently.
+	ErrorFunc func(err error)
+}
+
+// parseOptions returns the first of given options with defaults applied.
+func parseOptions(opts []Options) Options {
+	var opt Options
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt.Throttle <= 0 {
+		opt.Throttle = time.Minute
+	}
+	if opt.NowFunc == nil {
+		opt.NowFunc = time.Now
+	}
+	if opt.ErrorFunc == nil {
+		opt.ErrorFunc = func(error) {}
+	}
+	return opt
+}
+
+// throttle tracks when users were last recorded by the current application
+// instance.
+type throttle struct {
+	interval time.Duration
+
+	lock     sync.Mutex
+	recorded map[string]time.Time // The times when users were last recorded
+}
+
+// allow returns true if the user with given ID has not been recorded within the
+// interval, and marks the user as recorded at the time if so.
+func (t *throttle) allow(userID string, now time.Time) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if now.Sub(t.recorded[userID]) < t.interval {
+		return false
+	}
+
+	// Forget users that are out of the interval to bound the memory usage by the
+	// number of active users.
+	if len(t.recorded) >= 1024 {
+		for id, at := range t.recorded {
+			if now.Sub(at) >= t.interval {
+				delete(t.recorded, id)
+			}
+		}
+	}
+	t.recorded[userID] = now
+	return true
+}
+
+// Tracker returns a middleware handler that records the user bound to the
+// current session (see session.UserOf) as seen in the index once the request
+// is handled. It must be used after the session.Sessioner.
+//
+// Example:
+//
+//	f.Use(session.Sessioner())
+//	f.Use(presence.Tracker(presence.NewMemoryIndex()))
+func Tracker(index Index, opts ...Options) flamego.Handler {
+	opt := parseOptions(opts)
+	t := &throttle{
+		interval: opt.Throttle,
+		recorded: make(map[string]time.Time),
+	}
+	return func(c flamego.Context, s session.Session) {
+		c.Next()
+
+		userID := session.UserOf(s)
+		now := opt.NowFunc()
+		if userID == "" || !t.allow(userID, now) {
+			return
+		}
+
+		err := index.Seen(c.Request().Context(), userID, now)
+		if err != nil {
+			opt.ErrorFunc(fmt.Errorf("record %q as seen: %w", userID, err))
+		}
+	}
+}
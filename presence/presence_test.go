@@ -0,0 +1,161 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package presence
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/flamego/flamego"
+	"github.com/flamego/session"
+)
+
+func testIndex(t *testing.T, index Index) {
+	ctx := context.Background()
+	now := time.Now()
+
+	require.NoError(t, index.Seen(ctx, "alice", now.Add(-time.Minute)))
+	require.NoError(t, index.Seen(ctx, "bob", now.Add(-time.Hour)))
+	// Earlier times are ignored
+	require.NoError(t, index.Seen(ctx, "alice", now.Add(-2*time.Hour)))
+
+	lastSeen, err := index.LastSeen(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-time.Minute).UnixMilli(), lastSeen.UnixMilli())
+
+	online, err := IsOnline(ctx, index, "alice", 5*time.Minute)
+	require.NoError(t, err)
+	assert.True(t, online)
+	online, err = IsOnline(ctx, index, "bob", 5*time.Minute)
+	require.NoError(t, err)
+	assert.False(t, online)
+	online, err = IsOnline(ctx, index, "carol", 5*time.Minute)
+	require.NoError(t, err)
+	assert.False(t, online)
+
+	n, err := OnlineCount(ctx, index, 5*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = OnlineCount(ctx, index, 2*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	require.NoError(t, index.Prune(ctx, now.Add(-30*time.Minute)))
+	lastSeen, err = index.LastSeen(ctx, "bob")
+	require.NoError(t, err)
+	assert.True(t, lastSeen.IsZero())
+}
+
+func TestMemoryIndex(t *testing.T) {
+	testIndex(t, NewMemoryIndex())
+}
+
+func TestSQLIndex(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "presence.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	index, err := NewSQLIndex(ctx,
+		SQLConfig{
+			DB:        db,
+			Dialect:   DialectSQLite,
+			InitTable: true,
+		},
+	)
+	require.NoError(t, err)
+	testIndex(t, index)
+
+	_, err = NewSQLIndex(ctx, SQLConfig{DB: db, Dialect: Dialect(-1)})
+	assert.Error(t, err)
+}
+
+type countingIndex struct {
+	Index
+	seen int
+}
+
+func (idx *countingIndex) Seen(ctx context.Context, userID string, at time.Time) error {
+	idx.seen++
+	return idx.Index.Seen(ctx, userID, at)
+}
+
+func TestTracker(t *testing.T) {
+	index := &countingIndex{Index: NewMemoryIndex()}
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(session.Sessioner(session.Options{GCMode: session.GCDisabled}))
+	f.Use(Tracker(index))
+	f.Get("/", func() {})
+	f.Get("/sign-in", func(s session.Session) {
+		session.BindUser(s, "alice")
+	})
+
+	serve := func(path, cookie string) string {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Cookie", cookie)
+		f.ServeHTTP(resp, req)
+		return resp.Header().Get("Set-Cookie")
+	}
+
+	// Anonymous visitors are not recorded
+	cookie := serve("/", "")
+	assert.Equal(t, 0, index.seen)
+
+	serve("/sign-in", cookie)
+	assert.Equal(t, 1, index.seen)
+	online, err := IsOnline(context.Background(), index, "alice", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, online)
+
+	// Subsequent requests are throttled
+	serve("/", cookie)
+	assert.Equal(t, 1, index.seen)
+}
+
+func TestTracker_NowFunc(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	opt := Options{NowFunc: func() time.Time { return now }}
+	index := NewMemoryIndex()
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(session.Sessioner(session.Options{GCMode: session.GCDisabled}))
+	f.Use(Tracker(index, opt))
+	f.Get("/sign-in", func(s session.Session) {
+		session.BindUser(s, "alice")
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/sign-in", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+
+	ctx := context.Background()
+	lastSeen, err := index.LastSeen(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, now.UnixMilli(), lastSeen.UnixMilli())
+
+	online, err := IsOnline(ctx, index, "alice", time.Minute, opt)
+	require.NoError(t, err)
+	assert.True(t, online)
+	n, err := OnlineCount(ctx, index, time.Minute, opt)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	now = now.Add(time.Hour)
+	online, err = IsOnline(ctx, index, "alice", time.Minute, opt)
+	require.NoError(t, err)
+	assert.False(t, online)
+}
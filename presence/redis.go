@@ -0,0 +1,74 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package presence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var _ Index = (*redisIndex)(nil)
+
+// redisIndex is a Redis implementation of the index, which keeps the last seen
+// times in milliseconds as scores of users in a sorted set.
+type redisIndex struct {
+	client *redis.Client // The client connection
+	key    string        // The key of the sorted set
+}
+
+// NewRedisIndex returns a new Redis index that keeps the last seen times in the
+// sorted set of given key. Default key is "presence".
+func NewRedisIndex(client *redis.Client, key string) Index {
+	if key == "" {
+		key = "presence"
+	}
+	return &redisIndex{
+		client: client,
+		key:    key,
+	}
+}
+
+func (idx *redisIndex) Seen(ctx context.Context, userID string, at time.Time) error {
+	err := idx.client.ZAddGT(ctx, idx.key, redis.Z{
+		Score:  float64(at.UnixMilli()),
+		Member: userID,
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("zadd: %w", err)
+	}
+	return nil
+}
+
+func (idx *redisIndex) LastSeen(ctx context.Context, userID string) (time.Time, error) {
+	score, err := idx.client.ZScore(ctx, idx.key, userID).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("zscore: %w", err)
+	}
+	return time.UnixMilli(int64(score)), nil
+}
+
+func (idx *redisIndex) CountSince(ctx context.Context, since time.Time) (int, error) {
+	n, err := idx.client.ZCount(ctx, idx.key, strconv.FormatInt(since.UnixMilli(), 10), "+inf").Result()
+	if err != nil {
+		return 0, fmt.Errorf("zcount: %w", err)
+	}
+	return int(n), nil
+}
+
+func (idx *redisIndex) Prune(ctx context.Context, before time.Time) error {
+	err := idx.client.ZRemRangeByScore(ctx, idx.key, "-inf", "("+strconv.FormatInt(before.UnixMilli(), 10)).Err()
+	if err != nil {
+		return fmt.Errorf("zremrangebyscore: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package presence
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Dialect is the SQL dialect of the database.
+type Dialect int
+
+const (
+	// DialectPostgres is the dialect of PostgreSQL.
+	DialectPostgres Dialect = iota
+	// DialectMySQL is the dialect of MySQL.
+	DialectMySQL
+	// DialectSQLite is the dialect of SQLite.
+	DialectSQLite
+)
+
+// SQLConfig contains options for the SQL index.
+type SQLConfig struct {
+	// DB is the database connection.
+	DB *sql.DB
+	// Dialect is the SQL dialect of the database. Default is DialectPostgres.
+	Dialect Dialect
+	// Table is the table name for storing the last seen times of users, which has
+	// the "user_id" column as the primary key and the "last_seen_at" column of
+	// Unix milliseconds. Default is "presence".
+	Table string
+	// InitTable indicates whether to create the table and its index of the
+	// "last_seen_at" column when not exists automatically.
+	InitTable bool
+}
+
+var _ Index = (*sqlIndex)(nil)
+
+// sqlIndex is a SQL implementation of the index.
+type sqlIndex struct {
+	db      *sql.DB
+	dialect Dialect
+	table   string // The quoted table name
+}
+
+// NewSQLIndex returns a new SQL index based on given configuration.
+func NewSQLIndex(ctx context.Context, cfg SQLConfig) (Index, error) {
+	if cfg.DB == nil {
+		return nil, errors.New("empty DB")
+	}
+	if cfg.Table == "" {
+		cfg.Table = "presence"
+	}
+
+	idx := &sqlIndex{
+		db:      cfg.DB,
+		dialect: cfg.Dialect,
+	}
+	switch cfg.Dialect {
+	case DialectPostgres, DialectMySQL, DialectSQLite:
+	default:
+		return nil, fmt.Errorf("unknown dialect %d", cfg.Dialect)
+	}
+	idx.table = idx.quote(cfg.Table)
+
+	if cfg.InitTable {
+		err := idx.initTable(ctx, cfg.Table)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// initTable creates the table and its index of the "last_seen_at" column when
+// not exists.
+func (idx *sqlIndex) initTable(ctx context.Context, table string) error {
+	// MySQL does not support creating indexes conditionally, thus the index is
+	// created along with the table.
+	if idx.dialect == DialectMySQL {
+		q := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+	user_id      VARCHAR(255) NOT NULL PRIMARY KEY,
+	last_seen_at BIGINT NOT NULL,
+	INDEX (last_seen_at)
+)`, idx.table)
+		_, err := idx.db.ExecContext(ctx, q)
+		if err != nil {
+			return fmt.Errorf("create table: %w", err)
+		}
+		return nil
+	}
+
+	q := fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+	user_id      VARCHAR(255) NOT NULL PRIMARY KEY,
+	last_seen_at BIGINT NOT NULL
+)`, idx.table)
+	_, err := idx.db.ExecContext(ctx, q)
+	if err != nil {
+		return fmt.Errorf("create table: %w", err)
+	}
+
+	q = fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (last_seen_at)`, idx.quote(table+"_last_seen_at"), idx.table)
+	_, err = idx.db.ExecContext(ctx, q)
+	if err != nil {
+		return fmt.Errorf("create index: %w", err)
+	}
+	return nil
+}
+
+// quote returns the quoted identifier in the dialect.
+func (idx *sqlIndex) quote(name string) string {
+	if idx.dialect == DialectMySQL {
+		return "`" + name + "`"
+	}
+	return fmt.Sprintf("%q", name)
+}
+
+// placeholder returns the n-th (1-based) placeholder of query arguments in the
+// dialect.
+func (idx *sqlIndex) placeholder(n int) string {
+	if idx.dialect == DialectMySQL {
+		return "?"
+	}
+	return fmt.Sprintf("$%d", n)
+}
+
+func (idx *sqlIndex) Seen(ctx context.Context, userID string, at time.Time) error {
+	var q string
+	switch idx.dialect {
+	case DialectPostgres:
+		q = fmt.Sprintf(`
+INSERT INTO %[1]s (user_id, last_seen_at)
+VALUES ($1, $2)
+ON CONFLICT (user_id)
+DO UPDATE SET last_seen_at = GREATEST(%[1]s.last_seen_at, excluded.last_seen_at)`, idx.table)
+	case DialectSQLite:
+		q = fmt.Sprintf(`
+INSERT INTO %[1]s (user_id, last_seen_at)
+VALUES ($1, $2)
+ON CONFLICT (user_id)
+DO UPDATE SET last_seen_at = MAX(last_seen_at, excluded.last_seen_at)`, idx.table)
+	case DialectMySQL:
+		q = fmt.Sprintf(`
+INSERT INTO %s (user_id, last_seen_at)
+VALUES (?, ?)
+ON DUPLICATE KEY UPDATE last_seen_at = GREATEST(last_seen_at, VALUES(last_seen_at))`, idx.table)
+	}
+
+	_, err := idx.db.ExecContext(ctx, q, userID, at.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("upsert: %w", err)
+	}
+	return nil
+}
+
+func (idx *sqlIndex) LastSeen(ctx context.Context, userID string) (time.Time, error) {
+	var ms int64
+	q := fmt.Sprintf(`SELECT last_seen_at FROM %s WHERE user_id = %s`, idx.table, idx.placeholder(1))
+	err := idx.db.QueryRowContext(ctx, q, userID).Scan(&ms)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("select: %w", err)
+	}
+	return time.UnixMilli(ms), nil
+}
+
+func (idx *sqlIndex) CountSince(ctx context.Context, since time.Time) (int, error) {
+	var n int
+	q := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE last_seen_at >= %s`, idx.table, idx.placeholder(1))
+	err := idx.db.QueryRowContext(ctx, q, since.UnixMilli()).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("count: %w", err)
+	}
+	return n, nil
+}
+
+func (idx *sqlIndex) Prune(ctx context.Context, before time.Time) error {
+	q := fmt.Sprintf(`DELETE FROM %s WHERE last_seen_at < %s`, idx.table, idx.placeholder(1))
+	_, err := idx.db.ExecContext(ctx, q, before.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+// preservedData returns the values of the keys that are present in the
+// session, which are read as-is when possible, i.e. references (e.g. to blobs)
+// are not resolved.
+func preservedData(s Session, keys []interface{}) Data {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	get := s.Get
+	if ds, ok := s.(interface{ Data() Data }); ok {
+		data := ds.Data()
+		get = func(key interface{}) interface{} { return data[key] }
+	}
+	preserved := make(Data, len(keys))
+	for _, key := range keys {
+		if val := get(key); val != nil {
+			preserved[key] = val
+		}
+	}
+	return preserved
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestSessioner_PreserveKeys(t *testing.T) {
+	const timeout = time.Hour
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Config: FileConfig{
+				RootDir: t.TempDir(),
+			},
+			Initer:          FileIniter(),
+			GCMode:          GCDisabled,
+			AbsoluteTimeout: timeout,
+			PreserveKeys:    []interface{}{"theme"},
+		},
+	))
+	f.Get("/", func(s Session) string {
+		return fmt.Sprintf("%v %v", s.Get("theme"), s.Get("name"))
+	})
+	f.Get("/set", func(s Session) {
+		s.Set("theme", "dark")
+		s.Set("name", "flamego")
+	})
+	f.Get("/flush", func(s Session) {
+		s.Flush()
+	})
+	f.Get("/age", func(s Session) {
+		// Pretend the session was created long ago
+		info := s.Get(infoKey).(Data)
+		info["created_at"] = time.Now().Add(-timeout).UnixNano()
+		setInternal(s, infoKey, info)
+	})
+
+	var cookie string
+	request := func(path string) string {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if c := resp.Header().Values("Set-Cookie"); len(c) > 0 {
+			cookie = strings.Split(c[len(c)-1], ";")[0]
+		}
+		return resp.Body.String()
+	}
+
+	request("/set")
+	assert.Equal(t, "dark flamego", request("/"))
+	request("/flush")
+	assert.Equal(t, "dark <nil>", request("/"))
+
+	// Preserved keys survive the recreation due to the absolute timeout
+	request("/set")
+	request("/age")
+	assert.Equal(t, "dark <nil>", request("/"))
+}
+
+func TestBaseSession_FlushKeepsBookkeeping(t *testing.T) {
+	createdAt := time.Unix(1700000000, 0)
+	sess := NewBaseSession("1", nil, nil)
+	setInternal(sess, infoKey, Data{"created_at": createdAt.UnixNano()})
+	sess.Set("name", "flamego")
+
+	// Flushing never resets the creation time that the absolute timeout is based on
+	sess.Flush()
+	assert.Nil(t, sess.Get("name"))
+	assert.Equal(t, createdAt, CreatedAt(sess))
+
+	// Nor does it sign out or unlock the session, unlike session.SignOut
+	setInternal(sess, authKey, Data{"user_id": "alice"})
+	setInternal(sess, lockedKey, createdAt.UnixNano())
+	sess.Flush()
+	assert.Equal(t, "alice", currentUser(sess))
+	assert.True(t, IsLocked(sess))
+
+	signOut(sess)
+	assert.Empty(t, currentUser(sess))
+	assert.False(t, IsLocked(sess))
+}
+
+func TestSessioner_PreserveKeysConcurrentRequests(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			PreserveKeys: []interface{}{"theme"},
+		},
+	))
+	f.Get("/", func(s Session) string {
+		return fmt.Sprintf("%v %v", s.Get("theme"), s.Get("name"))
+	})
+	f.Get("/set", func(s Session) {
+		s.Set("theme", "dark")
+		s.Set("name", "flamego")
+	})
+	started, proceed := make(chan struct{}), make(chan struct{})
+	f.Get("/flush", func(s Session) {
+		close(started)
+		<-proceed
+		s.Flush()
+	})
+
+	var cookie string
+	request := func(path string) string {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if c := resp.Header().Get("Set-Cookie"); c != "" {
+			cookie = strings.Split(c, ";")[0]
+		}
+		return resp.Body.String()
+	}
+	request("/set")
+
+	// The end of another request of the same session does not stop keys from
+	// being preserved for the slow request.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		request("/flush")
+	}()
+	<-started
+	assert.Equal(t, "dark flamego", request("/"))
+	close(proceed)
+	<-done
+
+	assert.Equal(t, "dark <nil>", request("/"))
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DataError is the error of encoding or decoding session data, whose message
+// may carry fragments of the session data (e.g. values that fail to be encoded,
+// or bytes that fail to be decoded). The message is the message of the
+// underlying error as-is, see session.RedactSessionData for redacting it.
+//
+// Errors of encoding sessions via Session.Encode and errors of the
+// session.GobDecoder (and its deterministic and streaming variants) are
+// *session.DataError. Custom decoders should wrap their errors the same way to
+// make them redactable.
+type DataError struct {
+	Op    string         // The operation, either "encode" or "decode"
+	Sizes map[string]int // The sizes of encoded values by keys, -1 for unknown, only for encoding
+	Size  int            // The size of the encoded session data, -1 for unknown, only for decoding
+	Err   error          // The error of the encoder or the decoder
+}
+
+func (e *DataError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DataError) Unwrap() error {
+	return e.Err
+}
+
+// summary returns the description of the session data of the error without
+// values.
+func (e *DataError) summary() string {
+	if e.Op == "decode" {
+		if e.Size < 0 {
+			return "failed to decode session data [redacted]"
+		}
+		return fmt.Sprintf("failed to decode %d bytes of session data [redacted]", e.Size)
+	}
+
+	keys := make([]string, 0, len(e.Sizes))
+	for key := range e.Sizes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		if size := e.Sizes[key]; size >= 0 {
+			keys[i] = fmt.Sprintf("%s (%d bytes)", key, size)
+		} else {
+			keys[i] = key + " (unknown size)"
+		}
+	}
+	return "failed to encode session data with keys " + strings.Join(keys, ", ") + " [redacted]"
+}
+
+// newEncodeError returns a *session.DataError of encoding the session data,
+// which records the size of each value that can be encoded on its own.
+func newEncodeError(data Data, err error) *DataError {
+	sizes := make(map[string]int, len(data))
+	for key, val := range data {
+		size := -1
+		if binary, err := GobEncoder(Data{key: val}); err == nil {
+			size = len(binary)
+		}
+		sizes[fmt.Sprint(key)] = size
+	}
+	return &DataError{Op: "encode", Sizes: sizes, Err: err}
+}
+
+// Redactor returns the error that is safe to be logged, i.e. without session
+// data. See Options.Redactor.
+type Redactor func(err error) error
+
+// redactedError is an error whose message has been redacted, which still
+// matches the original error with errors.Is and errors.As.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// RedactSessionData is the default Redactor, which replaces the message of the
+// *session.DataError in the error chain by the key names and sizes of values
+// of the session data. Errors without a *session.DataError are returned as-is.
+func RedactSessionData(err error) error {
+	var dataErr *DataError
+	if err == nil || !errors.As(err, &dataErr) {
+		return err
+	}
+
+	msg := err.Error()
+	if raw := dataErr.Error(); raw != "" && strings.Contains(msg, raw) {
+		msg = strings.Replace(msg, raw, dataErr.summary(), 1)
+	} else {
+		msg = dataErr.summary()
+	}
+	return &redactedError{msg: msg, err: err}
+}
@@ -0,0 +1,52 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type unregistered struct {
+	Secret string
+}
+
+func TestRedactSessionData(t *testing.T) {
+	t.Run("encode", func(t *testing.T) {
+		sess := NewBaseSession("111", GobEncoder, IDWriter(func(http.ResponseWriter, *http.Request, string) {}))
+		sess.Set("name", "flamego")
+		sess.Set("secret", unregistered{Secret: "hunter2"})
+		_, err := sess.Encode()
+		require.Error(t, err)
+
+		var dataErr *DataError
+		require.True(t, errors.As(err, &dataErr))
+		assert.Equal(t, "encode", dataErr.Op)
+
+		redacted := RedactSessionData(fmt.Errorf("save: %w", err))
+		assert.Regexp(t, `^save: failed to encode session data with keys name \(\d+ bytes\), secret \(unknown size\) \[redacted\]$`, redacted.Error())
+		assert.True(t, errors.As(redacted, &dataErr), "the original error is still matched")
+	})
+
+	t.Run("decode", func(t *testing.T) {
+		_, err := GobDecoder([]byte("password=hunter2"))
+		require.Error(t, err)
+
+		redacted := RedactSessionData(fmt.Errorf("read: %w", err))
+		assert.Equal(t, "read: failed to decode 16 bytes of session data [redacted]", redacted.Error())
+		assert.NotContains(t, redacted.Error(), "hunter2")
+	})
+
+	t.Run("other errors", func(t *testing.T) {
+		err := errors.New("connection refused")
+		assert.Same(t, err, RedactSessionData(err))
+		assert.Nil(t, RedactSessionData(nil))
+	})
+}
@@ -0,0 +1,172 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package redis
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/flamego/session"
+)
+
+// Format is the storage format of session data in Redis.
+type Format int
+
+const (
+	// FormatBlob stores the encoded session data as a string.
+	FormatBlob Format = iota
+	// FormatHash stores the session data as a hash, which holds the encoded
+	// session data in the "data" field, and structured fields that are queryable
+	// in Redis: "value:<key>" for each key of Config.IndexedKeys with a scalar
+	// value, and "tag:<key>" for each session tag.
+	FormatHash
+	// FormatJSON stores the session data as a RedisJSON document, which holds the
+	// base64-encoded session data in "$.data", and structured fields that are
+	// queryable in Redis (e.g. via RediSearch): "$.values" for keys of
+	// Config.IndexedKeys with scalar values, and "$.tags" for session tags. It
+	// requires the RedisJSON module.
+	FormatJSON
+)
+
+// Names of fields that hold the encoded session data.
+const (
+	hashDataField = "data"
+	jsonDataPath  = "$.data"
+)
+
+// scalarValues returns session values of given keys that are scalars, which
+// are stored as structured fields. Times are formatted in RFC 3339.
+func scalarValues(data session.Data, keys []string) map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, key := range keys {
+		v, ok := data[key]
+		if !ok {
+			continue
+		}
+
+		switch v := v.(type) {
+		case string, bool,
+			int, int8, int16, int32, int64,
+			uint, uint8, uint16, uint32, uint64,
+			float32, float64:
+			values[key] = v
+		case time.Time:
+			values[key] = v.Format(time.RFC3339Nano)
+		}
+	}
+	return values
+}
+
+// writeData queues commands to write the encoded session data of the session
+// in the storage format to the pipeline.
+func (s *redisStore) writeData(ctx context.Context, pipe redis.Pipeliner, sess session.Session, binary []byte) error {
+	key := s.key(sess.ID())
+	switch s.format {
+	case FormatHash:
+		fields := map[string]interface{}{
+			hashDataField: binary,
+		}
+		if d, ok := sess.(interface{ Data() session.Data }); ok {
+			for k, v := range scalarValues(d.Data(), s.indexedKeys) {
+				fields["value:"+k] = fmt.Sprint(v)
+			}
+		}
+		for k, v := range session.TagsOf(sess) {
+			fields["tag:"+k] = v
+		}
+
+		// Remove stale fields of keys and tags that no longer exist
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, fields)
+		pipe.Expire(ctx, key, s.lifetime)
+	case FormatJSON:
+		doc := struct {
+			Data   string                 `json:"data"`
+			Values map[string]interface{} `json:"values"`
+			Tags   map[string]string      `json:"tags"`
+		}{
+			Data:   base64.StdEncoding.EncodeToString(binary),
+			Values: map[string]interface{}{},
+			Tags:   session.TagsOf(sess),
+		}
+		if d, ok := sess.(interface{ Data() session.Data }); ok {
+			doc.Values = scalarValues(d.Data(), s.indexedKeys)
+		}
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("marshal document: %w", err)
+		}
+
+		pipe.Do(ctx, "JSON.SET", key, "$", string(b))
+		pipe.Expire(ctx, key, s.lifetime)
+	default:
+		pipe.SetEx(ctx, key, binary, s.lifetime)
+	}
+	return nil
+}
+
+// readData returns the encoded session data of the session with given ID in the
+// storage format. It returns redis.Nil if the session does not exist.
+func (s *redisStore) readData(ctx context.Context, sid string) ([]byte, error) {
+	return s.queueReadData(ctx, s.client, sid)()
+}
+
+// cmdable is a client or a pipeline that is capable of issuing commands,
+// including arbitrary ones for modules.
+type cmdable interface {
+	redis.Cmdable
+	Do(ctx context.Context, args ...interface{}) *redis.Cmd
+}
+
+// queueReadData issues the command to read the encoded session data of the
+// session with given ID in the storage format, and returns the function to get
+// the result once the command is executed, i.e. immediately for a client and
+// after Exec for a pipeline. The result is redis.Nil if the session does not
+// exist.
+func (s *redisStore) queueReadData(ctx context.Context, c cmdable, sid string) func() ([]byte, error) {
+	key := s.key(sid)
+	switch s.format {
+	case FormatHash:
+		cmd := c.HGet(ctx, key, hashDataField)
+		return func() ([]byte, error) {
+			binary, err := cmd.Bytes()
+			if err != nil {
+				return nil, fmt.Errorf("hget: %w", err)
+			}
+			return binary, nil
+		}
+	case FormatJSON:
+		cmd := c.Do(ctx, "JSON.GET", key, jsonDataPath)
+		return func() ([]byte, error) {
+			result, err := cmd.Text()
+			if err != nil {
+				return nil, fmt.Errorf("json.get: %w", err)
+			}
+
+			var data []string
+			err = json.Unmarshal([]byte(result), &data)
+			if err != nil {
+				return nil, fmt.Errorf("unmarshal document: %w", err)
+			} else if len(data) == 0 {
+				return nil, redis.Nil
+			}
+			return base64.StdEncoding.DecodeString(data[0])
+		}
+	}
+
+	cmd := c.Get(ctx, key)
+	return func() ([]byte, error) {
+		binary, err := cmd.Bytes()
+		if err != nil {
+			return nil, fmt.Errorf("get: %w", err)
+		}
+		return binary, nil
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/flamego/session"
+)
+
+var _ session.GCLease = (*GCLease)(nil)
+
+// GCLease is a Redis implementation of the GC lease, which is shared by all
+// instances using the same Redis server. The lease is never released before
+// it expires, thus only one instance performs GC in each time interval.
+type GCLease struct {
+	client *redis.Client // The client connection
+	key    string        // The key of the lease
+}
+
+// NewGCLease returns a new Redis GC lease that is stored with the key, e.g.
+// "session:gc".
+func NewGCLease(client *redis.Client, key string) *GCLease {
+	return &GCLease{
+		client: client,
+		key:    key,
+	}
+}
+
+// Acquire implements `session.GCLease.Acquire`.
+func (l *GCLease) Acquire(ctx context.Context, ttl time.Duration) (bool, error) {
+	acquired, err := l.client.SetNX(ctx, l.key, time.Now().UnixMilli(), ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("set: %w", err)
+	}
+	return acquired, nil
+}
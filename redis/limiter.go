@@ -0,0 +1,77 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/flamego/session"
+)
+
+var _ session.CreationLimiter = (*CreationLimiter)(nil)
+
+// tokenBucketScript refills and consumes the token bucket atomically. The
+// bucket expires once it would have been refilled to full.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated_at")
+local tokens = tonumber(bucket[1]) or burst
+local updated_at = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated_at) * rate / 1000)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated_at", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate * 1000))
+return allowed
+`)
+
+// CreationLimiter is a Redis implementation of the session creation limiter
+// with a token bucket per key, which is shared by all instances using the same
+// Redis server.
+type CreationLimiter struct {
+	client    *redis.Client    // The client connection
+	keyPrefix string           // The prefix to use for keys
+	rate      float64          // The number of tokens refilled per second
+	burst     int              // The capacity of each bucket
+	nowFunc   func() time.Time // The function to return the current time
+}
+
+// NewCreationLimiter returns a new Redis creation limiter that allows creating
+// `rate` sessions per second for each key with bursts of at most `burst`
+// sessions. Buckets are stored as hashes with the key prefix followed by the
+// key, e.g. "session:limiter:" + "192.0.2.1".
+func NewCreationLimiter(client *redis.Client, keyPrefix string, rate float64, burst int) *CreationLimiter {
+	return &CreationLimiter{
+		client:    client,
+		keyPrefix: keyPrefix,
+		rate:      rate,
+		burst:     burst,
+		nowFunc:   time.Now,
+	}
+}
+
+// Allow implements `session.CreationLimiter.Allow`.
+func (l *CreationLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	allowed, err := tokenBucketScript.Run(ctx, l.client,
+		[]string{l.keyPrefix + key},
+		l.rate, l.burst, l.nowFunc().UnixMilli(),
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("run script: %w", err)
+	}
+	return allowed == 1, nil
+}
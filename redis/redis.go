@@ -6,10 +6,12 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/pkg/errors"
 	"github.com/redis/go-redis/v9"
 
 	"github.com/flamego/session"
@@ -19,9 +21,17 @@ var _ session.Store = (*redisStore)(nil)
 
 // redisStore is a Redis implementation of the session store.
 type redisStore struct {
-	client    *redis.Client // The client connection
-	keyPrefix string        // The prefix to use for keys
-	lifetime  time.Duration // The duration to have access to a session before being recycled
+	nowFunc     func() time.Time    // The function to return the current time
+	client      *redis.Client       // The client connection
+	keyPrefix   string              // The prefix to use for keys
+	keyFunc     func(string) string // The function to return the key of a session, overrides the keyPrefix when not nil
+	lifetime    time.Duration       // The duration to have access to a session before being recycled
+	tags        bool                // Whether to persist session tags
+	counters    bool                // Whether to maintain counters of sessions
+	lists       bool                // Whether to maintain lists of sessions
+	format      Format              // The storage format of session data
+	metadata    bool                // Whether to maintain the metadata hash of sessions
+	indexedKeys []string            // The session keys to store as structured fields
 
 	encoder  session.Encoder
 	decoder  session.Decoder
@@ -31,44 +41,169 @@ type redisStore struct {
 // newRedisStore returns a new Redis session store based on given configuration.
 func newRedisStore(cfg Config, idWriter session.IDWriter) *redisStore {
 	return &redisStore{
-		client:    cfg.Client,
-		keyPrefix: cfg.KeyPrefix,
-		lifetime:  cfg.Lifetime,
-		encoder:   cfg.Encoder,
-		decoder:   cfg.Decoder,
-		idWriter:  idWriter,
+		nowFunc:     cfg.NowFunc,
+		client:      cfg.Client,
+		keyPrefix:   cfg.KeyPrefix,
+		keyFunc:     cfg.KeyFunc,
+		lifetime:    cfg.Lifetime,
+		tags:        cfg.EnableTags,
+		counters:    cfg.EnableCounters,
+		lists:       cfg.EnableLists && cfg.Format != FormatBlob,
+		format:      cfg.Format,
+		metadata:    cfg.WriteMetadata,
+		indexedKeys: cfg.IndexedKeys,
+		encoder:     cfg.Encoder,
+		decoder:     cfg.Decoder,
+		idWriter:    idWriter,
 	}
 }
 
 func (s *redisStore) Exist(ctx context.Context, sid string) bool {
-	result, err := s.client.Exists(ctx, s.keyPrefix+sid).Result()
-	return err == nil && result == 1
+	ok, _ := s.CheckExist(ctx, sid)
+	return ok
+}
+
+var _ session.ExistChecker = (*redisStore)(nil)
+
+func (s *redisStore) CheckExist(ctx context.Context, sid string) (bool, error) {
+	result, err := s.client.Exists(ctx, s.key(sid)).Result()
+	if err != nil {
+		return false, fmt.Errorf("exists: %w", err)
+	}
+	return result == 1, nil
 }
 
 func (s *redisStore) Read(ctx context.Context, sid string) (session.Session, error) {
-	binary, err := s.client.Get(ctx, s.keyPrefix+sid).Result()
+	var result func() ([]byte, error)
+	var tags *redis.MapStringStringCmd
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		result = s.queueReadData(ctx, pipe, sid)
+		if s.tags {
+			tags = pipe.HGetAll(ctx, s.tagsKey(sid))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("exec: %w", err)
+	}
+
+	binary, err := result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			return session.NewBaseSession(sid, s.encoder, s.idWriter), nil
 		}
-		return nil, errors.Wrap(err, "get")
+		return nil, err
 	}
 
-	data, err := s.decoder([]byte(binary))
+	data, err := s.decoder(binary)
 	if err != nil {
-		return nil, errors.Wrap(err, "decode")
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	sess := session.NewBaseSessionWithData(sid, s.encoder, s.idWriter, data)
+	if s.tags {
+		m, err := tags.Result()
+		if err != nil {
+			return nil, fmt.Errorf("get tags: %w", err)
+		}
+		sess.LoadTags(m)
+	}
+	return sess, nil
+}
+
+var _ session.MultiReader = (*redisStore)(nil)
+
+// ReadMany reads sessions and their tags with a pipeline in a single round
+// trip.
+func (s *redisStore) ReadMany(ctx context.Context, sids []string) (map[string]session.Session, error) {
+	pipe := s.client.Pipeline()
+	results := make([]func() ([]byte, error), len(sids))
+	tags := make([]*redis.MapStringStringCmd, len(sids))
+	for i, sid := range sids {
+		results[i] = s.queueReadData(ctx, pipe, sid)
+		if s.tags {
+			tags[i] = pipe.HGetAll(ctx, s.tagsKey(sid))
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("exec: %w", err)
+	}
+
+	sessions := make(map[string]session.Session, len(sids))
+	for i, sid := range sids {
+		binary, err := results[i]()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			return nil, fmt.Errorf("read %q: %w", sid, err)
+		}
+
+		data, err := s.decoder(binary)
+		if err != nil {
+			return nil, fmt.Errorf("decode %q: %w", sid, err)
+		}
+		sess := session.NewBaseSessionWithData(sid, s.encoder, s.idWriter, data)
+		if s.tags {
+			m, err := tags[i].Result()
+			if err != nil {
+				return nil, fmt.Errorf("get tags of %q: %w", sid, err)
+			}
+			sess.LoadTags(m)
+		}
+		sessions[sid] = sess
 	}
-	return session.NewBaseSessionWithData(sid, s.encoder, s.idWriter, data), nil
+	return sessions, nil
 }
 
 func (s *redisStore) Destroy(ctx context.Context, sid string) error {
-	return s.client.Del(ctx, s.keyPrefix+sid).Err()
+	keys := []string{s.key(sid), s.metaKey(sid)}
+	if s.counters {
+		keys = append(keys, s.countersKey(sid))
+	}
+
+	var tags map[string]string
+	if s.tags {
+		var err error
+		tags, err = s.client.HGetAll(ctx, s.tagsKey(sid)).Result()
+		if err != nil {
+			return fmt.Errorf("get tags: %w", err)
+		}
+		keys = append(keys, s.tagsKey(sid))
+	}
+
+	return s.withScripts(ctx, func() error {
+		_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for k, v := range tags {
+				pipe.SRem(ctx, s.tagKey(k, v), sid)
+			}
+			s.queueDelLists(ctx, pipe, sid)
+			pipe.Del(ctx, keys...)
+			return nil
+		})
+		return err
+	})
 }
 
+// Touch extends the lifetime of the session data and all its bookkeeping keys
+// with a pipeline in a single round trip.
 func (s *redisStore) Touch(ctx context.Context, sid string) error {
-	err := s.client.Expire(ctx, s.keyPrefix+sid, s.lifetime).Err()
+	err := s.withScripts(ctx, func() error {
+		_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Expire(ctx, s.key(sid), s.lifetime)
+			s.queueExpireCounters(ctx, pipe, sid)
+			s.queueExpireLists(ctx, pipe, sid)
+			s.queueExpireTags(ctx, pipe, sid)
+			if s.metadata {
+				pipe.Expire(ctx, s.metaKey(sid), s.lifetime)
+			}
+			return nil
+		})
+		return err
+	})
 	if err != nil {
-		return errors.Wrap(err, "expire")
+		return fmt.Errorf("expire: %w", err)
 	}
 	return nil
 }
@@ -76,23 +211,468 @@ func (s *redisStore) Touch(ctx context.Context, sid string) error {
 func (s *redisStore) Save(ctx context.Context, sess session.Session) error {
 	binary, err := sess.Encode()
 	if err != nil {
-		return errors.Wrap(err, "encode")
+		return fmt.Errorf("encode: %w", err)
+	}
+
+	if !s.tags {
+		err = s.withScripts(ctx, func() error {
+			_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				err := s.writeData(ctx, pipe, sess, binary)
+				if err != nil {
+					return err
+				}
+				s.queueExpireCounters(ctx, pipe, sess.ID())
+				s.queueExpireLists(ctx, pipe, sess.ID())
+				s.writeMetadata(ctx, pipe, sess, binary)
+				return nil
+			})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("set: %w", err)
+		}
+		return nil
+	}
+
+	sid := sess.ID()
+	oldTags, err := s.client.HGetAll(ctx, s.tagsKey(sid)).Result()
+	if err != nil {
+		return fmt.Errorf("get tags: %w", err)
 	}
 
-	err = s.client.SetEx(ctx, s.keyPrefix+sess.ID(), binary, s.lifetime).Err()
+	tags := session.TagsOf(sess)
+	err = s.withScripts(ctx, func() error {
+		_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			err := s.writeData(ctx, pipe, sess, binary)
+			if err != nil {
+				return err
+			}
+			s.queueExpireCounters(ctx, pipe, sid)
+			s.queueExpireLists(ctx, pipe, sid)
+			s.writeMetadata(ctx, pipe, sess, binary)
+			for k, v := range oldTags {
+				if tags[k] != v {
+					pipe.SRem(ctx, s.tagKey(k, v), sid)
+				}
+			}
+			pipe.Del(ctx, s.tagsKey(sid))
+			if len(tags) > 0 {
+				pipe.HSet(ctx, s.tagsKey(sid), tags)
+				pipe.Expire(ctx, s.tagsKey(sid), s.lifetime)
+			}
+			// The index of each tag lives at least as long as its most recently saved
+			// session, thus it is never left behind after all its sessions expire.
+			for k, v := range tags {
+				pipe.SAdd(ctx, s.tagKey(k, v), sid)
+				pipe.Expire(ctx, s.tagKey(k, v), s.lifetime)
+			}
+			return nil
+		})
+		return err
+	})
 	if err != nil {
-		return errors.Wrap(err, "set")
+		return fmt.Errorf("set: %w", err)
 	}
 	return nil
 }
 
+// key returns the key that holds data of the session.
+func (s *redisStore) key(sid string) string {
+	if s.keyFunc != nil {
+		return s.keyFunc(sid)
+	}
+	return s.keyPrefix + sid
+}
+
+// countersKey returns the key of the hash that holds counters of the session.
+func (s *redisStore) countersKey(sid string) string {
+	return s.keyPrefix + "counters:" + sid
+}
+
+// listsKey returns the key of the set that holds keys of lists of the session.
+func (s *redisStore) listsKey(sid string) string {
+	return s.keyPrefix + "lists:" + sid
+}
+
+// listKey returns the key of the list of given key of the session.
+func (s *redisStore) listKey(sid, key string) string {
+	return s.keyPrefix + "list:" + sid + ":" + key
+}
+
+// expireListsScript extends the lifetime of the set that holds keys of lists of
+// the session (KEYS[1]) and all lists in it by ARGV[2] milliseconds, where keys
+// of lists are prefixed by ARGV[1].
+var expireListsScript = redis.NewScript(`
+local lists = redis.call("SMEMBERS", KEYS[1])
+if #lists == 0 then
+	return 0
+end
+
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+for _, key in ipairs(lists) do
+	redis.call("PEXPIRE", ARGV[1] .. key, ARGV[2])
+end
+return #lists
+`)
+
+// delListsScript deletes the set that holds keys of lists of the session
+// (KEYS[1]) and all lists in it, where keys of lists are prefixed by ARGV[1].
+var delListsScript = redis.NewScript(`
+local lists = redis.call("SMEMBERS", KEYS[1])
+for _, key in ipairs(lists) do
+	redis.call("DEL", ARGV[1] .. key)
+end
+redis.call("DEL", KEYS[1])
+return #lists
+`)
+
+// expireTagsScript extends the lifetime of the hash that holds tags of the
+// session (KEYS[1]) and the index of each tag in it by ARGV[2] milliseconds,
+// where keys of indexes are prefixed by ARGV[1].
+var expireTagsScript = redis.NewScript(`
+local tags = redis.call("HGETALL", KEYS[1])
+if #tags == 0 then
+	return 0
+end
+
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+for i = 1, #tags, 2 do
+	redis.call("PEXPIRE", ARGV[1] .. tags[i] .. ":" .. tags[i + 1], ARGV[2])
+end
+return #tags / 2
+`)
+
+// queueExpireTags queues extending the lifetime of tags of the session and
+// their indexes to the pipeline, if enabled.
+func (s *redisStore) queueExpireTags(ctx context.Context, pipe redis.Pipeliner, sid string) {
+	if !s.tags {
+		return
+	}
+	expireTagsScript.Run(ctx, pipe, []string{s.tagsKey(sid)}, s.keyPrefix+"tag:", s.lifetime.Milliseconds())
+}
+
+// queueExpireCounters queues extending the lifetime of counters of the session
+// to the pipeline, if enabled.
+func (s *redisStore) queueExpireCounters(ctx context.Context, pipe redis.Pipeliner, sid string) {
+	if !s.counters {
+		return
+	}
+	pipe.Expire(ctx, s.countersKey(sid), s.lifetime)
+}
+
+// queueExpireLists queues extending the lifetime of all lists of the session to
+// the pipeline if enabled, which saves a round trip of looking up the lists
+// beforehand.
+func (s *redisStore) queueExpireLists(ctx context.Context, pipe redis.Pipeliner, sid string) {
+	if !s.lists {
+		return
+	}
+	expireListsScript.Run(ctx, pipe, []string{s.listsKey(sid)}, s.listKey(sid, ""), s.lifetime.Milliseconds())
+}
+
+// queueDelLists queues deleting all lists of the session to the pipeline, if
+// enabled.
+func (s *redisStore) queueDelLists(ctx context.Context, pipe redis.Pipeliner, sid string) {
+	if !s.lists {
+		return
+	}
+	delListsScript.Run(ctx, pipe, []string{s.listsKey(sid)}, s.listKey(sid, ""))
+}
+
+// withScripts calls fn and calls it once again after loading scripts when they
+// are missing from the script cache of Redis, as scripts sent with EVALSHA
+// within a pipeline cannot fall back to EVAL on their own. The fn must be safe
+// to retry.
+func (s *redisStore) withScripts(ctx context.Context, fn func() error) error {
+	err := fn()
+	if !(s.lists || s.tags) || !redis.HasErrorPrefix(err, "NOSCRIPT") {
+		return err
+	}
+
+	for _, script := range []*redis.Script{expireListsScript, delListsScript, expireTagsScript} {
+		err = script.Load(ctx, s.client).Err()
+		if err != nil {
+			return fmt.Errorf("load script: %w", err)
+		}
+	}
+	return fn()
+}
+
+// metaKey returns the key of the hash that holds metadata of the session.
+func (s *redisStore) metaKey(sid string) string {
+	return s.keyPrefix + "meta:" + sid
+}
+
+// writeMetadata queues writing the metadata hash of the session with given
+// encoded session data to the pipeline, if enabled.
+func (s *redisStore) writeMetadata(ctx context.Context, pipe redis.Pipeliner, sess session.Session, binary []byte) {
+	if !s.metadata {
+		return
+	}
+
+	summary := session.SummaryOf(sess, binary)
+	fields := map[string]interface{}{
+		metaUserField: summary.UserID,
+		metaSizeField: summary.Size,
+	}
+	if !summary.CreatedAt.IsZero() {
+		fields[metaCreatedAtField] = summary.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	pipe.Del(ctx, s.metaKey(sess.ID()))
+	pipe.HSet(ctx, s.metaKey(sess.ID()), fields)
+	pipe.Expire(ctx, s.metaKey(sess.ID()), s.lifetime)
+}
+
+// Fields of the metadata hash of sessions.
+const (
+	metaCreatedAtField = "created_at"
+	metaUserField      = "user"
+	metaSizeField      = "size"
+)
+
+var _ session.Summarizer = (*redisStore)(nil)
+
+// Summarize returns the summary of the session from its metadata hash. It
+// requires the metadata to be enabled via Config.WriteMetadata.
+func (s *redisStore) Summarize(ctx context.Context, sid string) (*session.Summary, error) {
+	if !s.metadata {
+		return nil, errors.New("metadata is not enabled")
+	}
+
+	var meta *redis.MapStringStringCmd
+	var ttl *redis.DurationCmd
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		meta = pipe.HGetAll(ctx, s.metaKey(sid))
+		ttl = pipe.PTTL(ctx, s.key(sid))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get metadata: %w", err)
+	}
+
+	// Negative values indicate the key does not exist or has no expiry
+	fields := meta.Val()
+	if len(fields) == 0 || ttl.Val() < 0 {
+		return nil, nil
+	}
+
+	summary := &session.Summary{
+		ID:        sid,
+		UserID:    fields[metaUserField],
+		ExpiresAt: s.nowFunc().Add(ttl.Val()),
+	}
+	summary.Size, err = strconv.Atoi(fields[metaSizeField])
+	if err != nil {
+		return nil, fmt.Errorf("parse size: %w", err)
+	}
+	if v := fields[metaCreatedAtField]; v != "" {
+		summary.CreatedAt, err = time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("parse created at: %w", err)
+		}
+	}
+	return summary, nil
+}
+
+// tagsKey returns the key of the hash that holds tags of the session.
+func (s *redisStore) tagsKey(sid string) string {
+	return s.keyPrefix + "tags:" + sid
+}
+
+// tagKey returns the key of the set that holds IDs of sessions with the given
+// tag.
+func (s *redisStore) tagKey(key, value string) string {
+	return s.keyPrefix + "tag:" + key + ":" + value
+}
+
 func (s *redisStore) GC(_ context.Context) error {
 	return nil
 }
 
+var _ session.GCNeeder = (*redisStore)(nil)
+
+// NeedsGC returns false as sessions are expired by Redis itself via TTLs of
+// keys.
+func (s *redisStore) NeedsGC() bool {
+	return false
+}
+
+var _ session.EncoderReporter = (*redisStore)(nil)
+
+func (s *redisStore) Encoder() session.Encoder {
+	return s.encoder
+}
+
+var _ session.Expirer = (*redisStore)(nil)
+
+func (s *redisStore) ExpiresAt(ctx context.Context, sid string) (time.Time, error) {
+	ttl, err := s.client.PTTL(ctx, s.key(sid)).Result()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("pttl: %w", err)
+	}
+
+	// Negative values indicate the key does not exist or has no expiry
+	if ttl < 0 {
+		return time.Time{}, nil
+	}
+	return s.nowFunc().Add(ttl), nil
+}
+
+var _ session.Lister = (*redisStore)(nil)
+
+func (s *redisStore) List(ctx context.Context) ([]string, error) {
+	if s.keyFunc != nil {
+		return nil, errors.New("listing sessions is not supported with a custom key function")
+	}
+
+	var sids []string
+	iter := s.client.Scan(ctx, 0, s.keyPrefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		sid := strings.TrimPrefix(iter.Val(), s.keyPrefix)
+		// Skip bookkeeping keys of session tags and counters
+		if strings.Contains(sid, ":") {
+			continue
+		}
+		sids = append(sids, sid)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("scan: %w", err)
+	}
+	return sids, nil
+}
+
+var _ session.Incrementer = (*redisStore)(nil)
+
+func (s *redisStore) Incr(ctx context.Context, sid, key string, delta int64) (int64, error) {
+	if !s.counters {
+		return 0, errors.New("counters are not enabled")
+	}
+
+	var incr *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.HIncrBy(ctx, s.countersKey(sid), key, delta)
+		pipe.Expire(ctx, s.countersKey(sid), s.lifetime)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("hincrby: %w", err)
+	}
+	return incr.Val(), nil
+}
+
+var _ session.ListStore = (*redisStore)(nil)
+
+func (s *redisStore) ListAppend(ctx context.Context, sid, key string, elem []byte) error {
+	if !s.lists {
+		return errors.New("lists are not enabled")
+	}
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, s.listKey(sid, key), elem)
+		pipe.SAdd(ctx, s.listsKey(sid), key)
+		pipe.Expire(ctx, s.listKey(sid, key), s.lifetime)
+		pipe.Expire(ctx, s.listsKey(sid), s.lifetime)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("rpush: %w", err)
+	}
+	return nil
+}
+
+func (s *redisStore) ListRemove(ctx context.Context, sid, key string, elem []byte) (int, error) {
+	if !s.lists {
+		return 0, errors.New("lists are not enabled")
+	}
+
+	n, err := s.client.LRem(ctx, s.listKey(sid, key), 0, elem).Result()
+	if err != nil {
+		return 0, fmt.Errorf("lrem: %w", err)
+	}
+	return int(n), nil
+}
+
+func (s *redisStore) ListAll(ctx context.Context, sid, key string) ([][]byte, error) {
+	if !s.lists {
+		return nil, errors.New("lists are not enabled")
+	}
+
+	vals, err := s.client.LRange(ctx, s.listKey(sid, key), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("lrange: %w", err)
+	}
+
+	elems := make([][]byte, 0, len(vals))
+	for _, v := range vals {
+		elems = append(elems, []byte(v))
+	}
+	return elems, nil
+}
+
+var _ session.TagFinder = (*redisStore)(nil)
+
+// FindByTag returns IDs of sessions with the given tag. It requires tags to be
+// enabled via Config.EnableTags. Sessions that have expired since they were
+// tagged are removed from the index as they are found, which are checked with
+// a pipeline in a single round trip.
+func (s *redisStore) FindByTag(ctx context.Context, key, value string) ([]string, error) {
+	if !s.tags {
+		return nil, errors.New("tags are not enabled")
+	}
+
+	members, err := s.client.SMembers(ctx, s.tagKey(key, value)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("smembers: %w", err)
+	}
+
+	exists := make([]*redis.IntCmd, len(members))
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, sid := range members {
+			exists[i] = pipe.Exists(ctx, s.key(sid))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("exists: %w", err)
+	}
+
+	var sids, stale []string
+	for i, sid := range members {
+		if exists[i].Val() == 1 {
+			sids = append(sids, sid)
+		} else {
+			stale = append(stale, sid)
+		}
+	}
+
+	if len(stale) > 0 {
+		err = s.client.SRem(ctx, s.tagKey(key, value), stale).Err()
+		if err != nil {
+			return nil, fmt.Errorf("srem: %w", err)
+		}
+	}
+	return sids, nil
+}
+
 // Options keeps the settings to set up Redis client connection.
 type Options = redis.Options
 
+var _ session.CapabilityReporter = (*redisStore)(nil)
+
+// Capabilities reports listing sessions only without a custom key function,
+// tags, counters and summaries only when they are enabled, and lists only when
+// they are enabled in the hash or JSON format.
+func (s *redisStore) Capabilities() session.StoreCapabilities {
+	return session.StoreCapabilities{
+		List:      s.keyFunc == nil,
+		ExpiresAt: true,
+		FindByTag: s.tags,
+		Incr:      s.counters,
+		Lists:     s.lists,
+		Summarize: s.metadata,
+	}
+}
+
 // Config contains options for the Redis session store.
 type Config struct {
 	// Client is the Redis Client connection. If not set, a new client will be
@@ -102,13 +682,51 @@ type Config struct {
 	Options *Options
 	// KeyPrefix is the prefix to use for keys in Redis. Default is "session:".
 	KeyPrefix string
+	// KeyFunc returns the key of the session with given ID in Redis, which
+	// overrides KeyPrefix for keys of session data. It is useful for sharing
+	// sessions with applications that derive keys from session IDs differently.
+	// Listing sessions is not supported when it is set.
+	KeyFunc func(sid string) string
 	// Lifetime is the duration to have no access to a session before being
 	// recycled. Default is 3600 seconds.
 	Lifetime time.Duration
+	// NowFunc is the function to return the current time for reporting expiry
+	// times of sessions (see session.Expirer), while sessions are expired by
+	// Redis itself. Default is time.Now.
+	NowFunc func() time.Time
 	// Encoder is the encoder to encode session data. Default is session.GobEncoder.
 	Encoder session.Encoder
 	// Decoder is the decoder to decode session data. Default is session.GobDecoder.
 	Decoder session.Decoder
+	// EnableTags indicates whether to persist session tags and maintain an index
+	// for finding sessions by tag. Every distinct value of a tag, including the
+	// metadata captured by session.Options.MetadataFunc, becomes a set at
+	// "<KeyPrefix>tag:<key>:<value>" that lives as long as its most recently
+	// active session, thus tags should be of low cardinality.
+	EnableTags bool
+	// EnableCounters indicates whether to maintain counters of sessions (see
+	// session.Incr) as Redis hashes with the same lifetime as the session data.
+	EnableCounters bool
+	// EnableLists indicates whether to maintain lists of sessions (see
+	// session.ListAppend) as Redis lists with the same lifetime as the session
+	// data, which is only supported in FormatHash or FormatJSON.
+	EnableLists bool
+	// Format is the storage format of session data, e.g. FormatHash or FormatJSON
+	// to store structured fields that are queryable in Redis alongside the encoded
+	// session data. Default is FormatBlob.
+	Format Format
+	// IndexedKeys is the list of session keys whose scalar values are stored as
+	// structured fields in FormatHash or FormatJSON. These values are stored in
+	// plaintext regardless of the Encoder, thus keys of confidential values must
+	// not be listed when the Encoder encrypts session data, e.g.
+	// session.EncryptedEncoder. Default is none.
+	IndexedKeys []string
+	// WriteMetadata indicates whether to maintain a compact hash of metadata of
+	// each session alongside the session data with the same lifetime, i.e.
+	// "<KeyPrefix>meta:<sid>" with the "created_at", "user" and "size" fields,
+	// which is readable via redis-cli and powers reporting summaries of sessions
+	// (see session.Summarizer) for inspection tooling. Default is false.
+	WriteMetadata bool
 }
 
 // Initer returns the session.Initer for the Redis session store.
@@ -140,6 +758,9 @@ func Initer() session.Initer {
 		if cfg.KeyPrefix == "" {
 			cfg.KeyPrefix = "session:"
 		}
+		if cfg.NowFunc == nil {
+			cfg.NowFunc = time.Now
+		}
 		if cfg.Lifetime.Seconds() < 1 {
 			cfg.Lifetime = 3600 * time.Second
 		}
@@ -149,6 +770,11 @@ func Initer() session.Initer {
 		if cfg.Decoder == nil {
 			cfg.Decoder = session.GobDecoder
 		}
+		switch cfg.Format {
+		case FormatBlob, FormatHash, FormatJSON:
+		default:
+			return nil, fmt.Errorf("unknown format %d", cfg.Format)
+		}
 
 		return newRedisStore(*cfg, idWriter), nil
 	}
@@ -10,6 +10,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -19,6 +21,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/flamego/session"
+	"github.com/flamego/session/storetest"
 )
 
 func newTestClient(t *testing.T, ctx context.Context) (testClient *redis.Client, cleanup func() error) {
@@ -128,6 +131,194 @@ func TestRedisStore(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.Code)
 }
 
+func TestRedisStore_Format(t *testing.T) {
+	ctx := context.Background()
+	client, cleanup := newTestClient(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	for _, format := range []Format{FormatHash, FormatJSON} {
+		store, err := Initer()(ctx,
+			Config{
+				Client:      client,
+				Format:      format,
+				IndexedKeys: []string{"username"},
+			},
+			session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+		)
+		require.Nil(t, err)
+
+		sess, err := store.Read(ctx, "1")
+		require.Nil(t, err)
+		sess.Set("username", "flamego")
+		sess.Set("password", "secret")
+		sess.Set(1, []string{"not", "queryable"})
+		session.Tag(sess, session.UserTag, "alice")
+		err = store.Save(ctx, sess)
+		if format == FormatJSON && err != nil && strings.Contains(err.Error(), "unknown command") {
+			t.Log("Skipped FormatJSON as RedisJSON is not available")
+			continue
+		}
+		require.Nil(t, err)
+
+		sess, err = store.Read(ctx, "1")
+		require.Nil(t, err)
+		assert.Equal(t, "flamego", sess.Get("username"))
+		assert.Equal(t, []string{"not", "queryable"}, sess.Get(1))
+		assert.True(t, store.Exist(ctx, "1"))
+
+		// Structured fields are queryable in Redis
+		switch format {
+		case FormatHash:
+			fields, err := client.HGetAll(ctx, "session:1").Result()
+			require.Nil(t, err)
+			assert.Equal(t, "flamego", fields["value:username"])
+			assert.NotContains(t, fields, "value:password")
+			assert.Equal(t, "alice", fields["tag:"+session.UserTag])
+		case FormatJSON:
+			result, err := client.Do(ctx, "JSON.GET", "session:1", "$.values.username").Text()
+			require.Nil(t, err)
+			assert.Equal(t, `["flamego"]`, result)
+
+			result, err = client.Do(ctx, "JSON.GET", "session:1", "$.values.password").Text()
+			require.Nil(t, err)
+			assert.Equal(t, `[]`, result)
+		}
+
+		err = store.Destroy(ctx, "1")
+		require.Nil(t, err)
+		assert.False(t, store.Exist(ctx, "1"))
+	}
+
+	_, err := Initer()(ctx,
+		Config{
+			Client: client,
+			Format: Format(-1),
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	assert.NotNil(t, err)
+}
+
+func TestRedisStore_Lists(t *testing.T) {
+	ctx := context.Background()
+	client, cleanup := newTestClient(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	store, err := Initer()(ctx,
+		Config{
+			Client:      client,
+			Format:      FormatHash,
+			EnableLists: true,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+	assert.True(t, session.Capabilities(store).Lists)
+
+	ls := store.(session.ListStore)
+	for _, elem := range []string{"apple", "banana", "apple"} {
+		err = ls.ListAppend(ctx, "1", "cart", []byte(elem))
+		require.Nil(t, err)
+	}
+
+	n, err := ls.ListRemove(ctx, "1", "cart", []byte("apple"))
+	require.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	elems, err := ls.ListAll(ctx, "1", "cart")
+	require.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("banana")}, elems)
+
+	// Lists are touched along with the session
+	err = client.Persist(ctx, "session:list:1:cart").Err()
+	require.Nil(t, err)
+	err = store.Touch(ctx, "1")
+	require.Nil(t, err)
+	ttl, err := client.TTL(ctx, "session:list:1:cart").Result()
+	require.Nil(t, err)
+	assert.Positive(t, ttl)
+
+	// Lists are destroyed along with the session
+	err = store.Destroy(ctx, "1")
+	require.Nil(t, err)
+	elems, err = ls.ListAll(ctx, "1", "cart")
+	require.Nil(t, err)
+	assert.Empty(t, elems)
+
+	// Lists are touched with EVALSHA after the script cache is flushed
+	err = ls.ListAppend(ctx, "1", "cart", []byte("apple"))
+	require.Nil(t, err)
+	err = client.ScriptFlush(ctx).Err()
+	require.Nil(t, err)
+	err = store.Touch(ctx, "1")
+	require.Nil(t, err)
+
+	// Lists are not reported in the blob format
+	store, err = Initer()(ctx,
+		Config{
+			Client:      client,
+			EnableLists: true,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+	assert.False(t, session.Capabilities(store).Lists)
+
+	// Lists are not reported unless enabled
+	store, err = Initer()(ctx,
+		Config{
+			Client: client,
+			Format: FormatHash,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+	assert.False(t, session.Capabilities(store).Lists)
+	assert.False(t, session.Capabilities(store).Incr)
+}
+
+func TestRedisStore_ReadMany(t *testing.T) {
+	ctx := context.Background()
+	client, cleanup := newTestClient(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	for _, format := range []Format{FormatBlob, FormatHash} {
+		store, err := Initer()(ctx,
+			Config{
+				Client:     client,
+				Format:     format,
+				EnableTags: true,
+			},
+			session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+		)
+		require.Nil(t, err)
+
+		for _, sid := range []string{"1", "2"} {
+			sess, err := store.Read(ctx, sid)
+			require.Nil(t, err)
+			sess.Set("sid", sid)
+			session.Tag(sess, "plan", "pro")
+			err = store.Save(ctx, sess)
+			require.Nil(t, err)
+		}
+
+		sessions, err := store.(session.MultiReader).ReadMany(ctx, []string{"1", "2", "3"})
+		require.Nil(t, err)
+		require.Len(t, sessions, 2)
+		for _, sid := range []string{"1", "2"} {
+			assert.Equal(t, sid, sessions[sid].Get("sid"))
+			assert.Equal(t, "pro", session.TagsOf(sessions[sid])["plan"])
+		}
+		assert.Nil(t, cleanup())
+	}
+}
+
 func TestRedisStore_GC(t *testing.T) {
 	ctx := context.Background()
 	client, cleanup := newTestClient(t, ctx)
@@ -143,6 +334,7 @@ func TestRedisStore_GC(t *testing.T) {
 		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
 	)
 	require.Nil(t, err)
+	assert.False(t, store.(session.GCNeeder).NeedsGC(), "sessions are expired by Redis")
 
 	sess1, err := store.Read(ctx, "1")
 	require.Nil(t, err)
@@ -198,3 +390,165 @@ func TestRedisStore_Touch(t *testing.T) {
 	require.Nil(t, err)
 	assert.True(t, store.Exist(ctx, sess.ID()))
 }
+
+func TestRedisStore_Tags(t *testing.T) {
+	ctx := context.Background()
+	client, cleanup := newTestClient(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	store, err := Initer()(ctx,
+		Config{
+			Client:     client,
+			Lifetime:   time.Second,
+			EnableTags: true,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	for _, sid := range []string{"1", "2"} {
+		sess, err := store.Read(ctx, sid)
+		require.Nil(t, err)
+		session.Tag(sess, "plan", "pro")
+		err = store.Save(ctx, sess)
+		require.Nil(t, err)
+	}
+
+	ttl, err := client.TTL(ctx, "session:tag:plan:pro").Result()
+	require.Nil(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+
+	err = store.Destroy(ctx, "2")
+	require.Nil(t, err)
+	sids, err := store.(session.TagFinder).FindByTag(ctx, "plan", "pro")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"1"}, sids)
+
+	time.Sleep(500 * time.Millisecond)
+	err = store.Touch(ctx, "1")
+	require.Nil(t, err)
+	ttl, err = client.PTTL(ctx, "session:tag:plan:pro").Result()
+	require.Nil(t, err)
+	assert.Greater(t, ttl, 500*time.Millisecond)
+}
+
+func TestRedisStore_WriteMetadata(t *testing.T) {
+	ctx := context.Background()
+	client, cleanup := newTestClient(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	store, err := Initer()(ctx,
+		Config{
+			Client:        client,
+			Lifetime:      time.Minute,
+			WriteMetadata: true,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+	assert.True(t, session.Capabilities(store).Summarize)
+
+	sess, err := store.Read(ctx, "1")
+	require.Nil(t, err)
+	sess.Set("name", "flamego")
+	err = store.Save(ctx, sess)
+	require.Nil(t, err)
+
+	binary, err := sess.Encode()
+	require.Nil(t, err)
+	meta, err := client.HGetAll(ctx, "session:meta:1").Result()
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"user": "", "size": strconv.Itoa(len(binary))}, meta)
+
+	ttl, err := client.TTL(ctx, "session:meta:1").Result()
+	require.Nil(t, err)
+	assert.InDelta(t, time.Minute, ttl, float64(time.Second))
+
+	summary, err := store.(session.Summarizer).Summarize(ctx, "1")
+	require.Nil(t, err)
+	require.NotNil(t, summary)
+	assert.Equal(t, len(binary), summary.Size)
+	assert.False(t, summary.ExpiresAt.IsZero())
+
+	// The metadata is listed neither as a session nor kept after destroyed
+	sids, err := store.(session.Lister).List(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"1"}, sids)
+
+	err = store.Destroy(ctx, "1")
+	require.Nil(t, err)
+	summary, err = store.(session.Summarizer).Summarize(ctx, "1")
+	require.Nil(t, err)
+	assert.Nil(t, summary)
+}
+
+func TestCreationLimiter(t *testing.T) {
+	ctx := context.Background()
+	client, cleanup := newTestClient(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	now := time.Now()
+	limiter := NewCreationLimiter(client, "session:limiter:", 1, 2)
+	limiter.nowFunc = func() time.Time { return now }
+
+	for _, want := range []bool{true, true, false} {
+		allowed, err := limiter.Allow(ctx, "192.0.2.1")
+		require.NoError(t, err)
+		assert.Equal(t, want, allowed)
+	}
+
+	// Buckets of different keys are independent
+	allowed, err := limiter.Allow(ctx, "192.0.2.2")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	// A token is refilled after a second
+	now = now.Add(time.Second)
+	allowed, err = limiter.Allow(ctx, "192.0.2.1")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+func TestGCLease(t *testing.T) {
+	ctx := context.Background()
+	client, cleanup := newTestClient(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	lease := NewGCLease(client, "session:gc")
+	acquired, err := lease.Acquire(ctx, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	// Other instances cannot acquire the lease until it expires
+	acquired, err = NewGCLease(client, "session:gc").Acquire(ctx, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	time.Sleep(150 * time.Millisecond)
+	acquired, err = lease.Acquire(ctx, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+}
+
+func TestRedisStore_Conformance(t *testing.T) {
+	ctx := context.Background()
+	client, cleanup := newTestClient(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	storetest.Conformance(t, Initer(), Config{
+		Client:         client,
+		Lifetime:       time.Second,
+		EnableTags:     true,
+		EnableCounters: true,
+	})
+}
@@ -0,0 +1,419 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package replicated provides a session store wrapper that replicates sessions
+// to the session store of a remote region, for active-active deployments
+// across two regions.
+package replicated
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/flamego/session"
+)
+
+// versionKey is the session key to store the version of the session, which is
+// a nested session.Data with the "region" and the "updated_at" (in Unix
+// nanoseconds).
+const versionKey = "flamego::replicated::version"
+
+// Version is the version of a session, which is stamped when the session is
+// saved in a region.
+type Version struct {
+	// Region is the region that saved the session.
+	Region string
+	// UpdatedAt is the time when the session was saved.
+	UpdatedAt time.Time
+}
+
+// versionOf returns the version of the session data, or zero version if not
+// stamped.
+func versionOf(data session.Data) Version {
+	v, _ := data[versionKey].(session.Data)
+	region, _ := v["region"].(string)
+	ns, _ := v["updated_at"].(int64)
+	if ns == 0 {
+		return Version{Region: region}
+	}
+	return Version{Region: region, UpdatedAt: time.Unix(0, ns)}
+}
+
+// ConflictPolicy decides whether the incoming version of a session should
+// overwrite the existing version in the remote region.
+type ConflictPolicy func(incoming, existing Version) bool
+
+// LatestWrite returns the conflict policy that the version saved later wins,
+// ties are broken by the order of region names for both regions to agree.
+func LatestWrite() ConflictPolicy {
+	return func(incoming, existing Version) bool {
+		if incoming.UpdatedAt.Equal(existing.UpdatedAt) {
+			return incoming.Region >= existing.Region
+		}
+		return incoming.UpdatedAt.After(existing.UpdatedAt)
+	}
+}
+
+// RegionPriority returns the conflict policy that the version saved in the
+// region listed earlier wins, regions that are not listed have the lowest
+// priority. Versions saved in regions of the same priority fall back to
+// LatestWrite.
+func RegionPriority(regions ...string) ConflictPolicy {
+	priorities := make(map[string]int, len(regions))
+	for i, region := range regions {
+		priorities[region] = len(regions) - i
+	}
+	latestWrite := LatestWrite()
+	return func(incoming, existing Version) bool {
+		in, ex := priorities[incoming.Region], priorities[existing.Region]
+		if in != ex {
+			return in > ex
+		}
+		return latestWrite(incoming, existing)
+	}
+}
+
+// Config contains options for the replicating session store wrapper.
+type Config struct {
+	// Region is the name of the local region, which is stamped in versions of
+	// sessions saved by this wrapper.
+	Region string
+	// Remote is the session store of the remote region. It must persist sessions
+	// on Save (i.e. not the memory session store) and use the same encoding as
+	// the Encoder and Decoder.
+	Remote session.Store
+	// ConflictPolicy is the policy of resolving conflicts when replicating a
+	// session that has also been saved in the remote region. Default is
+	// LatestWrite.
+	ConflictPolicy ConflictPolicy
+	// QueueSize is the maximum number of operations that are queued to be
+	// replicated to the remote region, operations are dropped when the queue is
+	// full. Default is 1000.
+	QueueSize int
+	// Encoder is the encoder of session data for the remote region. Default is
+	// session.GobEncoder.
+	Encoder session.Encoder
+	// Decoder is the decoder of session data of both regions. Default is
+	// session.GobDecoder.
+	Decoder session.Decoder
+	// NowFunc is the function to return the current time for versions of sessions
+	// saved by this wrapper. Default is time.Now.
+	NowFunc func() time.Time
+	// ErrorFunc is the function used to print errors of replicating to the remote
+	// region, and of falling back to the remote region on read. Default is to
+	// drop errors silently.
+	ErrorFunc func(err error)
+}
+
+// operation is an operation to be replicated to the remote region.
+type operation struct {
+	sid     string            // The session ID
+	kind    string            // The kind of the operation, i.e. "save", "destroy" or "touch"
+	binary  []byte            // The encoded session data to be saved
+	tags    map[string]string // The tags of the session to be saved
+	version Version           // The version of the session to be saved
+}
+
+var _ session.Store = (*replicatedStore)(nil)
+
+// replicatedStore is a session store wrapper that writes to the local session
+// store synchronously and to the remote session store asynchronously.
+type replicatedStore struct {
+	session.Store // The local session store
+	cfg           Config
+	queue         chan operation // The queue of operations to be replicated
+}
+
+// Wrap returns a session store wrapper to be used in
+// session.Options.StoreWrappers, which writes sessions to the local session
+// store synchronously and to the remote session store asynchronously in a
+// background goroutine. Sessions are read from the local session store and
+// fall back to the remote session store when they have not been replicated
+// yet, in which case they are copied to the local session store. GC operations
+// only apply to the local session store.
+func Wrap(cfg Config) session.StoreMiddleware {
+	return func(local session.Store) session.Store {
+		s := newReplicatedStore(local, cfg)
+		go s.run()
+		return s
+	}
+}
+
+// newReplicatedStore returns a new replicating session store wrapper based on
+// given configuration, without starting the background goroutine.
+func newReplicatedStore(local session.Store, cfg Config) *replicatedStore {
+	if cfg.ConflictPolicy == nil {
+		cfg.ConflictPolicy = LatestWrite()
+	}
+	if cfg.QueueSize < 1 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.Encoder == nil {
+		cfg.Encoder = session.GobEncoder
+	}
+	if cfg.Decoder == nil {
+		cfg.Decoder = session.GobDecoder
+	}
+	if cfg.NowFunc == nil {
+		cfg.NowFunc = time.Now
+	}
+	if cfg.ErrorFunc == nil {
+		cfg.ErrorFunc = func(error) {}
+	}
+	return &replicatedStore{
+		Store: local,
+		cfg:   cfg,
+		queue: make(chan operation, cfg.QueueSize),
+	}
+}
+
+// Unwrap returns the local session store.
+func (s *replicatedStore) Unwrap() session.Store {
+	return s.Store
+}
+
+// enqueue queues the operation to be replicated, the operation is dropped with
+// the error printed when the queue is full.
+func (s *replicatedStore) enqueue(op operation) {
+	select {
+	case s.queue <- op:
+	default:
+		s.cfg.ErrorFunc(fmt.Errorf("replication queue is full, dropped %s of %q", op.kind, op.sid))
+	}
+}
+
+// run replicates queued operations to the remote session store.
+func (s *replicatedStore) run() {
+	for op := range s.queue {
+		err := s.replicate(context.Background(), op)
+		if err != nil {
+			s.cfg.ErrorFunc(fmt.Errorf("replicate %s of %q: %w", op.kind, op.sid, err))
+		}
+	}
+}
+
+// replicate applies the operation to the remote session store.
+func (s *replicatedStore) replicate(ctx context.Context, op operation) error {
+	remote := s.cfg.Remote
+	switch op.kind {
+	case "destroy":
+		return remote.Destroy(ctx, op.sid)
+	case "touch":
+		return remote.Touch(ctx, op.sid)
+	}
+
+	existing, _, err := s.readRemote(ctx, op.sid)
+	if err != nil {
+		return fmt.Errorf("read remote: %w", err)
+	}
+	if existing != nil && !s.cfg.ConflictPolicy(op.version, versionOf(existing)) {
+		return nil
+	}
+
+	data, err := s.cfg.Decoder(op.binary)
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+	sess := session.NewBaseSessionWithData(op.sid, s.cfg.Encoder, nil, data)
+	sess.LoadTags(op.tags)
+	return remote.Save(ctx, sess)
+}
+
+// readRemote returns the session data and tags of the session with given ID in
+// the remote session store, or nil data if no such session exists.
+func (s *replicatedStore) readRemote(ctx context.Context, sid string) (session.Data, map[string]string, error) {
+	ok, err := session.CheckExist(ctx, s.cfg.Remote, sid)
+	if err != nil {
+		return nil, nil, fmt.Errorf("check existence: %w", err)
+	} else if !ok {
+		return nil, nil, nil
+	}
+
+	sess, err := s.cfg.Remote.Read(ctx, sid)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read: %w", err)
+	}
+	binary, err := sess.Encode()
+	if err != nil {
+		return nil, nil, fmt.Errorf("encode: %w", err)
+	}
+	data, err := s.cfg.Decoder(binary)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode: %w", err)
+	}
+	return data, session.TagsOf(sess), nil
+}
+
+func (s *replicatedStore) Exist(ctx context.Context, sid string) bool {
+	ok, _ := s.CheckExist(ctx, sid)
+	return ok
+}
+
+var _ session.ExistChecker = (*replicatedStore)(nil)
+
+// CheckExist checks the local session store first, and falls back to the remote
+// session store for sessions that have not been replicated yet. Errors of the
+// remote session store are printed rather than returned, as the remote region
+// is not required for serving local sessions.
+func (s *replicatedStore) CheckExist(ctx context.Context, sid string) (bool, error) {
+	ok, err := session.CheckExist(ctx, s.Store, sid)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	ok, err = session.CheckExist(ctx, s.cfg.Remote, sid)
+	if err != nil {
+		s.cfg.ErrorFunc(fmt.Errorf("check existence of %q in remote: %w", sid, err))
+		return false, nil
+	}
+	return ok, nil
+}
+
+func (s *replicatedStore) Read(ctx context.Context, sid string) (session.Session, error) {
+	ok, err := session.CheckExist(ctx, s.Store, sid)
+	if err != nil {
+		return nil, fmt.Errorf("check existence: %w", err)
+	} else if !ok {
+		err = s.copyFromRemote(ctx, sid)
+		if err != nil {
+			s.cfg.ErrorFunc(fmt.Errorf("copy %q from remote: %w", sid, err))
+		}
+	}
+	return s.Store.Read(ctx, sid)
+}
+
+// copyFromRemote copies the session with given ID from the remote session store
+// to the local session store if it exists in the remote session store.
+func (s *replicatedStore) copyFromRemote(ctx context.Context, sid string) error {
+	data, tags, err := s.readRemote(ctx, sid)
+	if err != nil {
+		return err
+	} else if data == nil {
+		return nil
+	}
+	return s.copySession(ctx, sid, data, tags)
+}
+
+// copySession saves the session with given ID of the data and the tags read
+// from the remote session store to the local session store.
+func (s *replicatedStore) copySession(ctx context.Context, sid string, data session.Data, tags map[string]string) error {
+	copied := session.NewBaseSessionWithData(sid, s.cfg.Encoder, nil, data)
+	copied.LoadTags(tags)
+	err := s.Store.Save(ctx, copied)
+	if err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	return nil
+}
+
+// copyRead copies the session read from the remote session store to the local
+// session store.
+func (s *replicatedStore) copyRead(ctx context.Context, sess session.Session) error {
+	binary, err := sess.Encode()
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	data, err := s.cfg.Decoder(binary)
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+	return s.copySession(ctx, sess.ID(), data, session.TagsOf(sess))
+}
+
+var _ session.MultiReader = (*replicatedStore)(nil)
+
+// ReadMany reads sessions from the local session store, and falls back to the
+// remote session store for sessions that have not been replicated yet, in which
+// case they are copied to the local session store. Errors of the remote session
+// store are printed rather than returned.
+func (s *replicatedStore) ReadMany(ctx context.Context, sids []string) (map[string]session.Session, error) {
+	sessions, err := session.ReadMany(ctx, s.Store, sids)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, sid := range sids {
+		if _, ok := sessions[sid]; !ok {
+			missing = append(missing, sid)
+		}
+	}
+	if len(missing) == 0 {
+		return sessions, nil
+	}
+
+	remote, err := session.ReadMany(ctx, s.cfg.Remote, missing)
+	if err != nil {
+		s.cfg.ErrorFunc(fmt.Errorf("read %d sessions from remote: %w", len(missing), err))
+		return sessions, nil
+	}
+	copied := make([]string, 0, len(remote))
+	for sid, sess := range remote {
+		err = s.copyRead(ctx, sess)
+		if err != nil {
+			s.cfg.ErrorFunc(fmt.Errorf("copy %q from remote: %w", sid, err))
+			continue
+		}
+		copied = append(copied, sid)
+	}
+
+	found, err := session.ReadMany(ctx, s.Store, copied)
+	if err != nil {
+		return nil, err
+	}
+	for sid, sess := range found {
+		sessions[sid] = sess
+	}
+	return sessions, nil
+}
+
+func (s *replicatedStore) Destroy(ctx context.Context, sid string) error {
+	err := s.Store.Destroy(ctx, sid)
+	if err != nil {
+		return err
+	}
+	s.enqueue(operation{sid: sid, kind: "destroy"})
+	return nil
+}
+
+func (s *replicatedStore) Touch(ctx context.Context, sid string) error {
+	err := s.Store.Touch(ctx, sid)
+	if err != nil {
+		return err
+	}
+	s.enqueue(operation{sid: sid, kind: "touch"})
+	return nil
+}
+
+func (s *replicatedStore) Save(ctx context.Context, sess session.Session) error {
+	version := Version{
+		Region:    s.cfg.Region,
+		UpdatedAt: s.cfg.NowFunc(),
+	}
+	sess.Set(versionKey, session.Data{
+		"region":     version.Region,
+		"updated_at": version.UpdatedAt.UnixNano(),
+	})
+
+	err := s.Store.Save(ctx, sess)
+	if err != nil {
+		return err
+	}
+
+	// The session is encoded at once to replicate the snapshot as saved locally
+	binary, err := sess.Encode()
+	if err != nil {
+		s.cfg.ErrorFunc(fmt.Errorf("encode %q for replication: %w", sess.ID(), err))
+		return nil
+	}
+	s.enqueue(operation{
+		sid:     sess.ID(),
+		kind:    "save",
+		binary:  binary,
+		tags:    session.TagsOf(sess),
+		version: version,
+	})
+	return nil
+}
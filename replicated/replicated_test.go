@@ -0,0 +1,147 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package replicated
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/session"
+)
+
+func newFileStore(t *testing.T) session.Store {
+	store, err := session.FileIniter()(context.Background(),
+		session.FileConfig{RootDir: t.TempDir()},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.NoError(t, err)
+	return store
+}
+
+func TestReplicatedStore(t *testing.T) {
+	ctx := context.Background()
+	east, west := newFileStore(t), newFileStore(t)
+	errFunc := func(err error) { t.Error(err) }
+	eastStore := Wrap(Config{Region: "east", Remote: west, ErrorFunc: errFunc})(east)
+	westStore := Wrap(Config{Region: "west", Remote: east, ErrorFunc: errFunc})(west)
+
+	sess, err := eastStore.Read(ctx, "111")
+	require.NoError(t, err)
+	sess.Set("name", "flamego")
+	require.NoError(t, eastStore.Save(ctx, sess))
+
+	// The session is replicated to the remote region asynchronously
+	assert.Eventually(t, func() bool { return west.Exist(ctx, "111") }, time.Second, 10*time.Millisecond)
+	sess, err = westStore.Read(ctx, "111")
+	require.NoError(t, err)
+	assert.Equal(t, "flamego", sess.Get("name"))
+
+	require.NoError(t, westStore.Destroy(ctx, "111"))
+	assert.Eventually(t, func() bool { return !east.Exist(ctx, "111") }, time.Second, 10*time.Millisecond)
+}
+
+func TestReplicatedStore_RemoteFallback(t *testing.T) {
+	ctx := context.Background()
+	local, remote := newFileStore(t), newFileStore(t)
+	store := Wrap(Config{Region: "east", Remote: remote})(local)
+
+	sess, err := remote.Read(ctx, "111")
+	require.NoError(t, err)
+	sess.Set("name", "flamego")
+	require.NoError(t, remote.Save(ctx, sess))
+
+	assert.True(t, store.Exist(ctx, "111"))
+	sess, err = store.Read(ctx, "111")
+	require.NoError(t, err)
+	assert.Equal(t, "flamego", sess.Get("name"))
+	assert.True(t, local.Exist(ctx, "111"), "the session is copied to the local region")
+}
+
+func TestReplicatedStore_ReadMany(t *testing.T) {
+	ctx := context.Background()
+	local, remote := newFileStore(t), newFileStore(t)
+	store := newReplicatedStore(local, Config{Region: "east", Remote: remote})
+
+	save := func(s session.Store, sid string) {
+		sess, err := s.Read(ctx, sid)
+		require.NoError(t, err)
+		sess.Set("name", sid)
+		require.NoError(t, s.Save(ctx, sess))
+	}
+	save(local, "111")
+	save(remote, "222")
+
+	sessions, err := store.ReadMany(ctx, []string{"111", "222", "333"})
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, "111", sessions["111"].Get("name"))
+	assert.Equal(t, "222", sessions["222"].Get("name"))
+	assert.True(t, local.Exist(ctx, "222"), "the session is copied to the local region")
+}
+
+func TestReplicatedStore_Conflict(t *testing.T) {
+	ctx := context.Background()
+	local, remote := newFileStore(t), newFileStore(t)
+	store := newReplicatedStore(local, Config{
+		Region:         "east",
+		Remote:         remote,
+		ConflictPolicy: RegionPriority("west"),
+	})
+
+	// The remote region has priority over the local region
+	sess, err := remote.Read(ctx, "111")
+	require.NoError(t, err)
+	sess.Set("name", "west")
+	sess.Set(versionKey, session.Data{"region": "west", "updated_at": time.Now().Add(-time.Hour).UnixNano()})
+	require.NoError(t, remote.Save(ctx, sess))
+
+	sess, err = local.Read(ctx, "111")
+	require.NoError(t, err)
+	sess.Set("name", "east")
+	require.NoError(t, store.Save(ctx, sess))
+	require.NoError(t, store.replicate(ctx, <-store.queue))
+
+	sess, err = remote.Read(ctx, "111")
+	require.NoError(t, err)
+	assert.Equal(t, "west", sess.Get("name"))
+}
+
+func TestReplicatedStore_NowFunc(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	store := newReplicatedStore(newFileStore(t), Config{
+		Region:  "east",
+		Remote:  newFileStore(t),
+		NowFunc: func() time.Time { return now },
+	})
+
+	sess, err := store.Read(ctx, "111")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(ctx, sess))
+	op := <-store.queue
+	assert.True(t, now.Equal(op.version.UpdatedAt))
+}
+
+func TestConflictPolicy(t *testing.T) {
+	now := time.Now()
+	earlier := now.Add(-time.Minute)
+
+	latestWrite := LatestWrite()
+	assert.True(t, latestWrite(Version{"east", now}, Version{"west", earlier}))
+	assert.False(t, latestWrite(Version{"east", earlier}, Version{"west", now}))
+	// Ties are broken consistently in both regions
+	assert.NotEqual(t, latestWrite(Version{"east", now}, Version{"west", now}), latestWrite(Version{"west", now}, Version{"east", now}))
+
+	regionPriority := RegionPriority("east", "west")
+	assert.True(t, regionPriority(Version{"east", earlier}, Version{"west", now}))
+	assert.False(t, regionPriority(Version{"west", now}, Version{"east", earlier}))
+	assert.True(t, regionPriority(Version{"west", now}, Version{"west", earlier}))
+	assert.True(t, regionPriority(Version{"west", earlier}, Version{"north", now}))
+}
@@ -0,0 +1,214 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// RequestStore is a view of the session store scoped to a single request, which
+// is injected by the session.Sessioner alongside the Store. Reads are memoized
+// for the lifetime of the request, and saves and touches are coalesced and
+// written once the request is handled, so accessing the same session multiple
+// times within a request only hits the session store once. Reading the session
+// of the current request returns the injected Session itself.
+//
+// Destroy and GC are passed through to the session store immediately.
+type RequestStore interface {
+	Store
+	// Flush writes the pending saves and touches to the session store. It is
+	// called by the session.Sessioner once the request is handled, before the
+	// session of the current request is saved.
+	Flush(ctx context.Context) error
+}
+
+// pendingWrite is a coalesced write to a session.
+type pendingWrite struct {
+	sess Session // The session to be saved, nil if the session is only touched
+}
+
+var _ RequestStore = (*requestStore)(nil)
+
+// requestStore is the session store view of a single request.
+type requestStore struct {
+	Store           // The session store
+	current Session // The session of the current request
+
+	lock    sync.Mutex               // The mutex to guard accesses to the fields below
+	exists  map[string]bool          // The memoized existence of sessions
+	reads   map[string]Session       // The memoized sessions
+	pending map[string]*pendingWrite // The coalesced writes indexed by session IDs
+	order   []string                 // The session IDs of coalesced writes in order
+}
+
+// newRequestStore returns a new session store view of the request with given
+// session of the current request.
+func newRequestStore(store Store, current Session) *requestStore {
+	return &requestStore{
+		Store:   store,
+		current: current,
+		exists:  make(map[string]bool),
+		reads:   make(map[string]Session),
+		pending: make(map[string]*pendingWrite),
+	}
+}
+
+// Unwrap returns the session store.
+func (s *requestStore) Unwrap() Store {
+	return s.Store
+}
+
+// isCurrent returns true if the session ID belongs to the session of the
+// current request.
+func (s *requestStore) isCurrent(sid string) bool {
+	return s.current != nil && IsStarted(s.current) && s.current.ID() == sid
+}
+
+func (s *requestStore) Exist(ctx context.Context, sid string) bool {
+	ok, _ := s.CheckExist(ctx, sid)
+	return ok
+}
+
+var _ ExistChecker = (*requestStore)(nil)
+
+func (s *requestStore) CheckExist(ctx context.Context, sid string) (bool, error) {
+	s.lock.Lock()
+	ok, memoized := s.exists[sid]
+	s.lock.Unlock()
+	if memoized {
+		return ok, nil
+	}
+
+	ok, err := CheckExist(ctx, s.Store, sid)
+	if err != nil {
+		return false, err
+	}
+
+	s.lock.Lock()
+	if _, memoized := s.exists[sid]; !memoized {
+		s.exists[sid] = ok
+	}
+	s.lock.Unlock()
+	return ok, nil
+}
+
+// Read returns the session of the current request if the session ID belongs to
+// it, or the memoized session otherwise. Sessions that are not memoized are
+// read from the session store.
+func (s *requestStore) Read(ctx context.Context, sid string) (Session, error) {
+	if s.isCurrent(sid) {
+		return s.current, nil
+	}
+
+	s.lock.Lock()
+	sess, ok := s.reads[sid]
+	s.lock.Unlock()
+	if ok {
+		return sess, nil
+	}
+
+	sess, err := s.Store.Read(ctx, sid)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	// Prefer the session that was memoized by a concurrent read, so that all
+	// reads within the request share the same session.
+	if memoized, ok := s.reads[sid]; ok {
+		return memoized, nil
+	}
+	s.reads[sid] = sess
+	return sess, nil
+}
+
+// Destroy drops the pending writes and memoized reads of the session, and
+// destroys the session in the session store.
+func (s *requestStore) Destroy(ctx context.Context, sid string) error {
+	s.lock.Lock()
+	delete(s.pending, sid)
+	delete(s.reads, sid)
+	s.exists[sid] = false
+	s.lock.Unlock()
+
+	return s.Store.Destroy(ctx, sid)
+}
+
+// enqueue returns the pending write of given session ID, which is created if
+// it does not exist. It must be called with the lock held.
+func (s *requestStore) enqueue(sid string) *pendingWrite {
+	w, ok := s.pending[sid]
+	if !ok {
+		w = &pendingWrite{}
+		s.pending[sid] = w
+		s.order = append(s.order, sid)
+	}
+	return w
+}
+
+// Touch coalesces the touch of the session until flushed, which is skipped if
+// the session is saved in the same request. The session of the current request
+// is left to the session.Sessioner.
+func (s *requestStore) Touch(_ context.Context, sid string) error {
+	if s.isCurrent(sid) {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.enqueue(sid)
+	return nil
+}
+
+// Save coalesces the save of the session until flushed, only the last saved
+// session of the same session ID is written. The session of the current
+// request is left to the session.Sessioner.
+func (s *requestStore) Save(_ context.Context, sess Session) error {
+	if s.isCurrent(sess.ID()) {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.enqueue(sess.ID()).sess = sess
+	s.reads[sess.ID()] = sess
+	s.exists[sess.ID()] = true
+	return nil
+}
+
+func (s *requestStore) Flush(ctx context.Context) error {
+	s.lock.Lock()
+	pending, order := s.pending, s.order
+	s.pending = make(map[string]*pendingWrite)
+	s.order = nil
+	s.lock.Unlock()
+
+	for _, sid := range order {
+		w, ok := pending[sid]
+		if !ok {
+			continue // Destroyed after the write was coalesced, or already written
+		}
+		delete(pending, sid)
+
+		if w.sess != nil {
+			err := s.Store.Save(ctx, w.sess)
+			if err != nil {
+				return fmt.Errorf("save %q: %w", sid, err)
+			}
+			continue
+		}
+
+		err := s.Store.Touch(ctx, sid)
+		if err != nil {
+			return fmt.Errorf("touch %q: %w", sid, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+type readCountingStore struct {
+	writeCountingStore
+	reads int
+}
+
+func (s *readCountingStore) Read(ctx context.Context, sid string) (Session, error) {
+	s.reads++
+	return s.Store.Read(ctx, sid)
+}
+
+func TestRequestStore(t *testing.T) {
+	ctx := context.Background()
+	file, err := FileIniter()(ctx,
+		FileConfig{
+			RootDir: t.TempDir(),
+		},
+		IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.NoError(t, err)
+	backing := &readCountingStore{writeCountingStore: writeCountingStore{Store: file}}
+	store := newRequestStore(backing, nil)
+
+	// Reads are memoized
+	sess, err := store.Read(ctx, "111")
+	require.NoError(t, err)
+	got, err := store.Read(ctx, "111")
+	require.NoError(t, err)
+	assert.Same(t, sess, got)
+	assert.Equal(t, 1, backing.reads)
+
+	// Writes are coalesced until flushed
+	sess.Set("name", "flamego")
+	require.NoError(t, store.Save(ctx, sess))
+	require.NoError(t, store.Touch(ctx, "111"))
+	require.NoError(t, store.Save(ctx, sess))
+	require.NoError(t, store.Touch(ctx, "222"))
+	assert.True(t, store.Exist(ctx, "111"))
+	assert.False(t, file.Exist(ctx, "111"))
+
+	require.NoError(t, store.Flush(ctx))
+	assert.Equal(t, 1, backing.saves)
+	assert.Equal(t, 1, backing.touches)
+	assert.True(t, file.Exist(ctx, "111"))
+
+	// Destroy drops pending writes and is passed through
+	require.NoError(t, store.Save(ctx, sess))
+	require.NoError(t, store.Destroy(ctx, "111"))
+	assert.False(t, store.Exist(ctx, "111"))
+	require.NoError(t, store.Flush(ctx))
+	assert.Equal(t, 1, backing.saves)
+	assert.False(t, file.Exist(ctx, "111"))
+}
+
+func TestSessioner_RequestStore(t *testing.T) {
+	var store *readCountingStore
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Initer: func(ctx context.Context, args ...interface{}) (Store, error) {
+				file, err := FileIniter()(ctx, args...)
+				if err != nil {
+					return nil, err
+				}
+				store = &readCountingStore{writeCountingStore: writeCountingStore{Store: file}}
+				return store, nil
+			},
+			Config: FileConfig{
+				RootDir: t.TempDir(),
+			},
+		},
+	))
+	f.Get("/", func(c flamego.Context, session Session, rs RequestStore) string {
+		sess, err := rs.Read(c.Request().Context(), session.ID())
+		require.NoError(t, err)
+		assert.Same(t, session, sess)
+
+		other, err := rs.Read(c.Request().Context(), "other")
+		require.NoError(t, err)
+		other.Set("name", "flamego")
+		require.NoError(t, rs.Save(c.Request().Context(), other))
+		return "ok"
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, "ok", resp.Body.String())
+	assert.Equal(t, 2, store.reads, "the current and the other session")
+	assert.Equal(t, 1, store.saves, "the other session")
+	assert.Equal(t, 1, store.touches, "the current session")
+	assert.True(t, store.Exist(context.Background(), "other"))
+}
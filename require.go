@@ -0,0 +1,74 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"net/http"
+
+	"github.com/flamego/flamego"
+)
+
+// RequireOptions contains options for the session.Require middleware.
+type RequireOptions struct {
+	// RedirectTo is the location to redirect requests without the required
+	// session to, instead of responding with 401 Unauthorized. Default is not
+	// set.
+	RedirectTo string
+}
+
+// Require returns a middleware that rejects requests without a session, which
+// must be used after the session.Sessioner. When existing is true, the session
+// must have existed in the session store before the request, e.g. for APIs
+// that never start sessions on their own. Otherwise, sessions that are not
+// started (see Options.DisableAutoCreate) or ephemeral (see
+// session.IsEphemeral) are rejected.
+//
+// The session of a rejected request is not saved, and the session ID is
+// cleared from the client if it was issued by the request.
+//
+// Example:
+//
+//	f.Get("/api/me", session.Require(true), handleMe)
+func Require(existing bool, opts ...RequireOptions) flamego.Handler {
+	var opt RequireOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	return flamego.ContextInvoker(func(c flamego.Context) {
+		s, store, err := fromContext(c)
+		if err != nil {
+			panic("session: " + err.Error())
+		}
+		state := requestStateOf(c)
+
+		ok := IsStarted(s) && !IsEphemeral(s)
+		if ok && existing {
+			if state != nil && state.created {
+				ok = false
+			} else {
+				ok, err = CheckExist(c.Request().Context(), store, s.ID())
+				if err != nil {
+					panic("session: require: check existence: " + err.Error())
+				}
+			}
+		}
+		if ok {
+			return
+		}
+
+		if state != nil {
+			state.discarded = true
+			if state.created && IsStarted(s) && !IsEphemeral(s) {
+				state.clearID()
+			}
+		}
+		if opt.RedirectTo != "" {
+			c.Redirect(opt.RedirectTo)
+			return
+		}
+		c.ResponseWriter().WriteHeader(http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestRequire(t *testing.T) {
+	var store Store
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Initer: func(ctx context.Context, args ...interface{}) (Store, error) {
+				var err error
+				store, err = FileIniter()(ctx, args...)
+				return store, err
+			},
+			Config: FileConfig{
+				RootDir: t.TempDir(),
+			},
+		},
+	))
+	f.Get("/", func(s Session) string {
+		s.Set("name", "flamego")
+		return s.ID()
+	})
+	f.Get("/api", Require(true), func(s Session) string {
+		return s.Get("name").(string)
+	})
+	f.Get("/page", Require(true, RequireOptions{RedirectTo: "/sign-in"}), func() {})
+
+	request := func(path, cookie string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// New sessions are rejected and not saved
+	resp := request("/api", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	cookies := resp.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, -1, cookies[0].MaxAge, "the issued session ID is cleared")
+	sids, err := store.(Lister).List(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, sids)
+
+	// Unknown session IDs are rejected
+	resp = request("/api", "flamego_session=0123456789abcdef")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.False(t, store.Exist(context.Background(), "0123456789abcdef"))
+
+	resp = request("/page", "")
+	assert.Equal(t, http.StatusFound, resp.Code)
+	assert.Equal(t, "/sign-in", resp.Header().Get("Location"))
+
+	// Existing sessions are accepted
+	resp = request("/", "")
+	cookie := strings.Split(resp.Header().Get("Set-Cookie"), ";")[0]
+	resp = request("/api", cookie)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "flamego", resp.Body.String())
+}
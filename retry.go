@@ -0,0 +1,100 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy contains the policy of retrying operations on the session store
+// that failed with transient errors.
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts of an operation, including the
+	// first one. Default is 1, i.e. no retry.
+	Attempts int
+	// Backoff returns the duration to wait before the given retry, where the first
+	// retry is 1. Default is ExponentialBackoff(10*time.Millisecond, time.Second).
+	Backoff func(retry int) time.Duration
+	// IsRetryable returns true if the operation failed with given error should be
+	// retried. Default is IsTransientError.
+	IsRetryable func(err error) bool
+}
+
+// ExponentialBackoff returns a backoff function that doubles the duration to
+// wait on every retry starting from the base, capped at the max.
+func ExponentialBackoff(base, max time.Duration) func(retry int) time.Duration {
+	return func(retry int) time.Duration {
+		d := base
+		for i := 1; i < retry && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// IsTransientError returns true if given error looks like a transient error,
+// e.g. network timeouts and resets, database deadlocks and serialization
+// failures. Context cancellations and deadline exceeding are never considered
+// transient.
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{
+		"deadlock",              // MySQL (1213), Postgres (40P01)
+		"lock wait timeout",     // MySQL (1205)
+		"serialization failure", // Postgres (40001)
+		"could not serialize",   // Postgres (40001)
+		"database is locked",    // SQLite
+		"connection reset",
+		"broken pipe",
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// retry calls the function until it succeeds, fails with an error that is not
+// retryable, or the attempts are exhausted. It stops waiting for the next
+// retry when the context is done.
+func (p RetryPolicy) retry(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= p.Attempts || !p.IsRetryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(p.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond)
+	assert.Equal(t, 10*time.Millisecond, backoff(1))
+	assert.Equal(t, 20*time.Millisecond, backoff(2))
+	assert.Equal(t, 40*time.Millisecond, backoff(3))
+	assert.Equal(t, 50*time.Millisecond, backoff(4))
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "context canceled", err: fmt.Errorf("read: %w", context.Canceled), want: false},
+		{name: "connection reset", err: fmt.Errorf("read: %w", syscall.ECONNRESET), want: true},
+		{name: "MySQL deadlock", err: errors.New("Error 1213 (40001): Deadlock found when trying to get lock"), want: true},
+		{name: "Postgres serialization failure", err: errors.New("ERROR: could not serialize access due to concurrent update (SQLSTATE 40001)"), want: true},
+		{name: "other", err: errors.New("gob: bad data"), want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, IsTransientError(test.err))
+		})
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{
+		Attempts:    3,
+		Backoff:     func(int) time.Duration { return time.Millisecond },
+		IsRetryable: IsTransientError,
+	}
+
+	t.Run("retry until succeeded", func(t *testing.T) {
+		calls := 0
+		err := policy.retry(context.Background(), func() error {
+			calls++
+			if calls < 2 {
+				return syscall.ECONNRESET
+			}
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("attempts exhausted", func(t *testing.T) {
+		calls := 0
+		err := policy.retry(context.Background(), func() error {
+			calls++
+			return syscall.ECONNRESET
+		})
+		assert.Equal(t, syscall.ECONNRESET, err)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("not retryable", func(t *testing.T) {
+		calls := 0
+		err := policy.retry(context.Background(), func() error {
+			calls++
+			return errors.New("gob: bad data")
+		})
+		assert.NotNil(t, err)
+		assert.Equal(t, 1, calls)
+	})
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// idIssuedAt returns the time when the current ID of the session was issued,
+// i.e. the last rotation or otherwise the creation of the session. It returns
+// zero time if the time is unknown.
+func idIssuedAt(s Session) time.Time {
+	if rotatedAt, ok := s.Get(rotatedKey).(int64); ok {
+		return time.Unix(0, rotatedAt)
+	}
+
+	info, _ := s.Get(infoKey).(Data)
+	if createdAt, ok := info["created_at"].(int64); ok {
+		return time.Unix(0, createdAt)
+	}
+	return time.Time{}
+}
+
+// rotateID regenerates the ID of the session if the current ID was issued more
+// than the interval before now. It returns the old session ID if rotated, which should
+// be destroyed once the session is saved with the new ID.
+func rotateID(w http.ResponseWriter, r *http.Request, s Session, interval time.Duration, now time.Time) (oldSID string, err error) {
+	issuedAt := idIssuedAt(s)
+	if issuedAt.IsZero() || now.Sub(issuedAt) < interval {
+		return "", nil
+	}
+
+	oldSID = s.ID()
+	err = s.RegenerateID(w, r)
+	if err != nil {
+		return "", fmt.Errorf("regenerate ID: %w", err)
+	}
+	setInternal(s, rotatedKey, now.UnixNano())
+	return oldSID, nil
+}
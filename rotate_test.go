@@ -0,0 +1,90 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestSessioner_RotateIDAfter(t *testing.T) {
+	const rotateAfter = time.Hour
+	var store Store
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Config: FileConfig{
+				RootDir: t.TempDir(),
+			},
+			Initer: FileIniter(),
+			StoreWrappers: []StoreMiddleware{
+				func(s Store) Store {
+					store = s
+					return s
+				},
+			},
+			GCMode:        GCDisabled,
+			RotateIDAfter: rotateAfter,
+			ErrorFunc:     func(err error) { t.Fatalf("Unexpected error: %v", err) },
+		},
+	))
+	f.Get("/", func(s Session) string {
+		return s.ID()
+	})
+	f.Get("/set", func(s Session) {
+		s.Set("name", "flamego")
+	})
+	f.Get("/get", func(s Session) string {
+		return s.Get("name").(string)
+	})
+	f.Get("/age", func(s Session) {
+		// Pretend the session ID was issued long ago
+		setInternal(s, rotatedKey, time.Now().Add(-rotateAfter).UnixNano())
+	})
+
+	var cookie string
+	request := func(path string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if c := resp.Header().Values("Set-Cookie"); len(c) > 0 {
+			cookie = strings.Split(c[len(c)-1], ";")[0]
+		}
+		return resp
+	}
+
+	request("/set")
+	sid := request("/").Body.String()
+
+	// Session IDs that are not old enough are kept
+	assert.Equal(t, sid, request("/").Body.String())
+
+	request("/age")
+	resp := request("/get")
+	assert.Equal(t, "flamego", resp.Body.String())
+	assert.Len(t, resp.Header().Values("Set-Cookie"), 1)
+
+	rotated := request("/").Body.String()
+	assert.NotEqual(t, sid, rotated)
+	assert.False(t, store.Exist(context.Background(), sid), "session with the old ID exists")
+	assert.True(t, store.Exist(context.Background(), rotated))
+
+	// The rotated session ID is kept until it is old enough
+	assert.Equal(t, rotated, request("/").Body.String())
+}
@@ -0,0 +1,211 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+var _ Session = (*scopedSession)(nil)
+
+// scopedSession is a view of the session whose data is nested under the session
+// key of the scope. Operations that are not about the session data are passed
+// through to the session.
+type scopedSession struct {
+	Session        // The session that the scope belongs to
+	key     string // The session key of the scope
+}
+
+// newScopedSession returns a new view of the session with given scope name.
+func newScopedSession(s Session, name string) *scopedSession {
+	return &scopedSession{
+		Session: s,
+		key:     scopeKeyPrefix + name,
+	}
+}
+
+// Scope returns a view of the session with an isolated namespace of keys, e.g.
+// for plugins to not clobber keys of the application. Flush of the view only
+// wipes out data of the scope. Operations that are not about the session data
+// (e.g. RegenerateID, SetFlash and session.Tag) apply to the whole session, and
+// counters of the view are not wiped out by Flush.
+func Scope(s Session, name string) Session {
+	return newScopedSession(s, name)
+}
+
+// rootOf returns the session that the scope belongs to, or the session itself
+// if it is not a scope.
+func rootOf(s Session) Session {
+	for {
+		sc, ok := s.(*scopedSession)
+		if !ok {
+			return s
+		}
+		s = sc.Session
+	}
+}
+
+// data returns the data of the scope, which must not be modified.
+func (s *scopedSession) data() Data {
+	data, _ := s.Session.Get(s.key).(Data)
+	return data
+}
+
+// update replaces the data of the scope with a modified copy of it. Expired
+// keys are dropped from the copy, and the scope is deleted from the session
+// when it becomes empty.
+func (s *scopedSession) update(modify func(data, expiries Data)) {
+	old := s.data()
+	data := make(Data, len(old))
+	for k, v := range old {
+		data[k] = v
+	}
+
+	expiries := make(Data)
+	now := nowOf(s.Session).UnixNano()
+	oldExpiries, _ := old[expiriesKey].(Data)
+	for k, v := range oldExpiries {
+		if expiresAt, _ := v.(int64); expiresAt > now {
+			expiries[k] = v
+		} else {
+			delete(data, k)
+		}
+	}
+	delete(data, expiriesKey)
+
+	modify(data, expiries)
+	if len(expiries) > 0 {
+		data[expiriesKey] = expiries
+	}
+
+	if len(data) == 0 {
+		deleteInternal(s.Session, s.key)
+		return
+	}
+	setInternal(s.Session, s.key, data)
+}
+
+func (s *scopedSession) Get(key interface{}) interface{} {
+	val := s.get(key)
+	if ref, ok := val.(blobRef); ok {
+		// Errors of reading the blob are returned by session.GetBlob
+		b, err := s.readBlob(ref)
+		if err != nil {
+			return nil
+		}
+		return b
+	}
+	return val
+}
+
+// get returns the value of given key without resolving blobs.
+func (s *scopedSession) get(key interface{}) interface{} {
+	data := s.data()
+	expiries, _ := data[expiriesKey].(Data)
+	if expiresAt, ok := expiries[key].(int64); ok && expiresAt <= nowOf(s.Session).UnixNano() {
+		return nil
+	}
+	return data[key]
+}
+
+func (s *scopedSession) Set(key, val interface{}) {
+	checkKey(key)
+	s.setInternal(key, val, time.Time{})
+}
+
+var _ timekeeper = (*scopedSession)(nil)
+
+func (s *scopedSession) currentTime() time.Time {
+	return nowOf(s.Session)
+}
+
+var _ contextualizer = (*scopedSession)(nil)
+
+func (s *scopedSession) setRequestContext(rc *requestContext) {
+	setRequestContext(s.Session, rc)
+}
+
+var _ internalWriter = (*scopedSession)(nil)
+
+func (s *scopedSession) setInternal(key, val interface{}, expiresAt time.Time) {
+	s.update(func(data, expiries Data) {
+		data[key] = val
+		if expiresAt.IsZero() {
+			delete(expiries, key)
+		} else {
+			expiries[key] = expiresAt.UnixNano()
+		}
+	})
+}
+
+var _ counter = (*scopedSession)(nil)
+
+func (s *scopedSession) incr(key string, delta int64) (int64, error) {
+	return Incr(s.Session, s.key+"::"+key, delta)
+}
+
+var _ listKeeper = (*scopedSession)(nil)
+
+func (s *scopedSession) listAppend(key string, val interface{}) error {
+	return ListAppend(s.Session, s.key+"::"+key, val)
+}
+
+func (s *scopedSession) listRemove(key string, val interface{}) (int, error) {
+	return ListRemove(s.Session, s.key+"::"+key, val)
+}
+
+func (s *scopedSession) listAll(key string) ([]interface{}, error) {
+	return ListAll(s.Session, s.key+"::"+key)
+}
+
+var _ blobber = (*scopedSession)(nil)
+
+func (s *scopedSession) putBlob(r io.Reader) (blobRef, error) {
+	b, ok := s.Session.(blobber)
+	if !ok {
+		return blobRef{}, ErrNoBlobStore
+	}
+	return b.putBlob(r)
+}
+
+func (s *scopedSession) readBlob(ref blobRef) ([]byte, error) {
+	b, ok := s.Session.(blobber)
+	if !ok {
+		return nil, ErrNoBlobStore
+	}
+	return b.readBlob(ref)
+}
+
+func (s *scopedSession) getBlob(key interface{}) ([]byte, error) {
+	switch val := s.get(key).(type) {
+	case nil:
+		return nil, nil
+	case blobRef:
+		return s.readBlob(val)
+	default:
+		return nil, fmt.Errorf("value of %v is not a blob", key)
+	}
+}
+
+func (s *scopedSession) Delete(key interface{}) {
+	checkKey(key)
+	s.deleteInternal(key)
+}
+
+func (s *scopedSession) deleteInternal(key interface{}) {
+	if _, ok := s.data()[key]; !ok {
+		return
+	}
+	s.update(func(data, expiries Data) {
+		delete(data, key)
+		delete(expiries, key)
+	})
+}
+
+func (s *scopedSession) Flush() {
+	deleteInternal(s.Session, s.key)
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopedSession(t *testing.T) {
+	s := NewBaseSession("1", GobEncoder, nil)
+	s.Set("username", "app")
+
+	plugin := Scope(s, "plugin")
+	assert.Nil(t, plugin.Get("username"))
+	plugin.Set("username", "plugin")
+	assert.Equal(t, "plugin", plugin.Get("username"))
+	assert.Equal(t, "app", s.Get("username"), "key of the application is clobbered")
+
+	// Scopes with the same name share the data
+	assert.Equal(t, "plugin", Scope(s, "plugin").Get("username"))
+	assert.Nil(t, Scope(s, "other").Get("username"))
+
+	// Nested scopes are isolated from their parents
+	nested := Scope(plugin, "nested")
+	nested.Set("username", "nested")
+	assert.Equal(t, "nested", nested.Get("username"))
+	assert.Equal(t, "plugin", plugin.Get("username"))
+
+	SetWithTTL(plugin, "token", "secret", -time.Second)
+	assert.Nil(t, plugin.Get("token"), "expired key of the scope")
+	SetWithTTL(plugin, "token", "secret", time.Hour)
+	assert.Equal(t, "secret", plugin.Get("token"))
+
+	// The data of scopes survives encoding
+	binary, err := s.Encode()
+	require.NoError(t, err)
+	data, err := GobDecoder(binary)
+	require.NoError(t, err)
+	s = NewBaseSessionWithData("1", GobEncoder, nil, data)
+	plugin = Scope(s, "plugin")
+	assert.Equal(t, "secret", plugin.Get("token"))
+	assert.Equal(t, "nested", Scope(plugin, "nested").Get("username"))
+
+	plugin.Delete("token")
+	assert.Nil(t, plugin.Get("token"))
+
+	// Flush only wipes out the scope
+	plugin.Flush()
+	assert.Nil(t, plugin.Get("username"))
+	assert.Nil(t, Scope(plugin, "nested").Get("username"))
+	assert.Equal(t, "app", s.Get("username"))
+
+	n, err := Incr(plugin, "visits", 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	n, err = Incr(s, "visits", 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), n, "counter of the application")
+}
@@ -6,12 +6,14 @@ package session
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"net/http"
 	"reflect"
+	"strconv"
 	"time"
 
-	"github.com/pkg/errors"
-
 	"github.com/flamego/flamego"
 )
 
@@ -19,7 +21,9 @@ import (
 type Session interface {
 	// ID returns the session ID.
 	ID() string
-	// RegenerateID regenerates the session ID.
+	// RegenerateID regenerates the session ID. It returns ErrHeaderWritten and
+	// leaves the session ID unchanged if the response header has already been
+	// written, as the new session ID would never reach the client.
 	RegenerateID(w http.ResponseWriter, r *http.Request) error
 	// Get returns the value of given key in the session. It returns nil if no such
 	// key exists.
@@ -30,7 +34,9 @@ type Session interface {
 	SetFlash(val interface{})
 	// Delete deletes a key from the session.
 	Delete(key interface{})
-	// Flush wipes out all existing data in the session.
+	// Flush wipes out all existing data in the session, except for the internal
+	// bookkeeping of the session, e.g. its creation time, authentication state
+	// and idle lock.
 	Flush()
 	// Encode encodes session data to binary.
 	Encode() ([]byte, error)
@@ -38,6 +44,17 @@ type Session interface {
 	HasChanged() bool
 }
 
+// StoreTimeouts contains timeouts of operations on the session store. A
+// timeout that is not positive means no timeout.
+type StoreTimeouts struct {
+	// Read is the timeout for reading sessions, i.e. Exist and Read.
+	Read time.Duration
+	// Write is the timeout for writing sessions, i.e. Save and Touch.
+	Write time.Duration
+	// GC is the timeout for each GC operation.
+	GC time.Duration
+}
+
 // CookieOptions contains options for setting HTTP cookies.
 type CookieOptions struct {
 	// Name is the name of the cookie. Default is "flamego_session".
@@ -55,6 +72,15 @@ type CookieOptions struct {
 	// SameSite is the SameSite attribute of the cookie. Default is
 	// http.SameSiteLaxMode.
 	SameSite http.SameSite
+	// Envelope is the options for wrapping the session ID in a versioned and
+	// optionally signed envelope as the cookie value. Default is disabled.
+	Envelope CookieEnvelope
+	// Preset is the preset of cookie attributes for the kind of application, e.g.
+	// session.PresetSPA. The preset turns on its Secure and HTTPOnly, and sets its
+	// SameSite unless SameSite is set explicitly. Incompatible combinations of
+	// attributes (e.g. SameSite=None without Secure) are rejected at startup
+	// regardless of the preset. Default is session.PresetNone.
+	Preset CookiePreset
 }
 
 // Options contains options for the session.Sessioner middleware.
@@ -67,30 +93,265 @@ type Options struct {
 	Config interface{}
 	// Cookie is a set of options for setting HTTP cookies.
 	Cookie CookieOptions
-	// IDLength specifies the length of session IDs. Default is 16.
+	// IDLength specifies the length of session IDs. It is ignored when
+	// IDEntropyBytes is set. Default is 16.
 	IDLength int
-	// GCInterval is the time interval for GC operations. Default is 5 minutes.
+	// IDAlphabet is the set of characters that session IDs are made up of, which
+	// must only be characters of [0-9A-Za-z_-]. It is ignored when IDEntropyBytes
+	// is set. Beware that the file session store
+	// requires session IDs to be unique regardless of case on case-insensitive
+	// file systems. Default is session.DefaultIDAlphabet.
+	IDAlphabet string
+	// IDEntropyBytes is the number of random bytes that session IDs are encoded
+	// from with the IDEncoding, e.g. 32 for 256-bit session IDs. Default is 0,
+	// i.e. session IDs are IDLength random characters of the IDAlphabet.
+	IDEntropyBytes int
+	// IDEncoding is the encoding of random bytes into session IDs when
+	// IDEntropyBytes is set. Default is session.IDEncodingBase62.
+	IDEncoding IDEncoding
+	// GCMode is the mode of performing GC operations on the session store, e.g.
+	// session.GCExternal for serverless deployments that should not run a
+	// background goroutine per instance. Default is session.GCBackground.
+	GCMode GCMode
+	// GCInterval is the time interval for GC operations when GCMode is
+	// session.GCBackground. Default is the interval preferred by the session
+	// store (see session.GCScheduler), or 5 minutes.
 	GCInterval time.Duration
+	// GCLease is the lease shared by application instances to make only one
+	// instance perform GC in each GCInterval, e.g. redis.NewGCLease. The Postgres
+	// and MySQL session stores can also skip overlapping GC operations on their
+	// own with advisory locks (see their Config.EnableGCLock). Default is not
+	// set, i.e. every instance performs GC.
+	GCLease GCLease
+	// OnExpire is the function to be called with the data of each expired session
+	// when it is recycled by background GC operations, e.g. to archive the cart
+	// contents of abandoned sessions. It requires the session store to implement
+	// session.ExpiryArchiver, and is ignored otherwise. Default is not set.
+	OnExpire OnExpireFunc
+	// OnExpireBatchSize is the maximum number of expired sessions to be read and
+	// recycled at once when OnExpire is set. Default is 100.
+	OnExpireBatchSize int
+	// ExpirySink is the destination of events of expired sessions that are
+	// recycled by background GC operations, e.g. session.WebhookSink to notify
+	// downstream systems of users that are signed out by timeout. Events are
+	// queued and sent in the background, and errors are printed using the
+	// ErrorFunc. It requires the session store to implement
+	// session.ExpiryArchiver, and is ignored otherwise. Default is not set.
+	ExpirySink ExpirySink
+	// ExpiryQueueSize is the maximum number of expiry events that are queued to
+	// be sent to the ExpirySink, events are dropped when the queue is full.
+	// Default is 1000.
+	ExpiryQueueSize int
+	// ExpirySendTimeout is the timeout for each delivery of expiry events to the
+	// ExpirySink, which keeps an unresponsive ExpirySink from blocking the
+	// delivery of later events. Default is 30 seconds.
+	ExpirySendTimeout time.Duration
+	// StoreTimeouts is the timeouts of operations on the session store performed
+	// by the middleware. Default is no timeouts.
+	StoreTimeouts StoreTimeouts
+	// Retry is the policy of retrying operations on the session store performed by
+	// the middleware that failed with transient errors. Default is no retry.
+	Retry RetryPolicy
+	// CircuitBreaker is the options for the circuit breaker of the session store.
+	// Default is disabled.
+	CircuitBreaker CircuitBreakerOptions
+	// StoreWrappers is the list of wrappers to be applied in order over the
+	// session store returned by the Initer (and the circuit breaker if enabled),
+	// i.e. the last wrapper is the outermost. Default is not set.
+	StoreWrappers []StoreMiddleware
+	// Audit is the options for auditing changes made to the session data. Default
+	// is disabled.
+	Audit AuditOptions
+	// SessionLimit is the options for limiting the number of concurrent sessions
+	// per user. Default is unlimited.
+	SessionLimit SessionLimitOptions
+	// CreationLimiter is the rate limiter of creating new sessions per remote IP
+	// address. Visitors exceeding the rate are served ephemeral sessions, which
+	// are neither persisted to the session store nor written to the client (see
+	// session.IsEphemeral). Default is not set, i.e. unlimited.
+	CreationLimiter CreationLimiter
+	// MaxActiveSessions is the maximum number of sessions in the session store,
+	// which is checked every time a new session is created, as a safety valve
+	// against floods of sessions filling up the session store. It requires the
+	// session store to implement session.ActiveCounter, and is ignored
+	// otherwise. Default is 0, i.e. unlimited.
+	MaxActiveSessions int
+	// MaxActiveAction is the action to take when creating a new session would
+	// exceed MaxActiveSessions. Default is session.ActiveLimitEphemeral.
+	MaxActiveAction ActiveLimitAction
+	// NegativeCache is the options for caching session IDs that are found missing
+	// in the session store. When enabled, missing session IDs presented by clients
+	// are replaced with newly generated ones instead of being adopted. Default is
+	// disabled.
+	NegativeCache NegativeCacheOptions
+	// CoalesceReads indicates whether to share a single read of the session store
+	// among concurrent requests of the same session, e.g. parallel XHRs of a page.
+	// Each request gets its own copy of the session, and sessions that cannot be
+	// copied are read by each request individually. Default is false.
+	CoalesceReads bool
+	// TrackInfo indicates whether to track the information of sessions (see
+	// session.InfoOf), i.e. the remote IP address and the user agent of the
+	// request that creates a session, and the last seen time that is updated at
+	// most once a minute, or once per TouchThreshold if longer. It is required by
+	// session.ListByUser to render signed-in devices, and makes sessions saved
+	// rather than touched whenever the last seen time is updated. The creation
+	// time is tracked regardless when AbsoluteTimeout or RotateIDAfter is set, so
+	// is the last seen time when IdleLockAfter is set. Default is false.
+	TrackInfo bool
+	// MetadataFunc is the function to capture metadata from the request that
+	// creates a session, e.g. the geolocation of the remote IP address. The
+	// metadata is persisted as session tags alongside the session rather than in
+	// the session data, and is available via session.SessionInfo. It only takes
+	// effect when TrackInfo is true. As with any session tag, stores that index
+	// tags (e.g. the Redis store) keep an index per distinct value, thus values
+	// of high cardinality like raw IP addresses or user agents should be
+	// coarsened or hashed. Default is not set.
+	MetadataFunc func(r *http.Request) map[string]string
+	// DeriveFunc is the function to derive values from the session, e.g. the
+	// current user decoded from the session data, which are injected into
+	// handlers by their types. Use session.MapTo to map a value to an interface
+	// type. Default is not set.
+	DeriveFunc func(s Session) []interface{}
+	// MinLoadDuration is the minimum time that loading the session of a request
+	// takes, regardless of whether the session exists, which hardens against
+	// timing-based oracles of session IDs. It should be longer than the slowest
+	// lookup of the session store. When set, errors of loading sessions are
+	// reported via ErrorFunc rather than in the panic message. Default is 0, i.e.
+	// disabled.
+	MinLoadDuration time.Duration
+	// ImpersonationTTL is the maximum duration of impersonations (see
+	// session.Impersonate), after which the original identity is restored
+	// automatically. Default is 1 hour.
+	ImpersonationTTL time.Duration
+	// NowFunc is the function to return the current time for the session
+	// information (see session.InfoOf), AbsoluteTimeout, IdleLockAfter,
+	// TouchThreshold, RotateIDAfter, ImpersonationTTL, expiry warnings, expiry
+	// times of keys (see session.SetWithTTL), drafts, sign-ins and audit entries,
+	// e.g. to simulate the passage of time in integration tests. Session stores
+	// have their own NowFunc in their configurations. Default is time.Now.
+	NowFunc func() time.Time
 	// ErrorFunc is the function used to print errors when something went wrong on
 	// the background. Default is to drop errors silently.
 	ErrorFunc func(err error)
+	// Redactor is the function to redact session data from errors before they are
+	// reported via ErrorFunc or carried by panics, e.g. errors of decoding
+	// session data that quote the payload. Default is session.RedactSessionData,
+	// which keeps only key names and sizes of values.
+	Redactor Redactor
+	// StrictErrors indicates whether to fail loading sessions when the session
+	// store cannot determine whether a session exists (see session.ExistChecker).
+	// Otherwise, such errors are reported via ErrorFunc and the session is
+	// treated as nonexistent. Default is false.
+	StrictErrors bool
 	// ReadIDFunc is the function to read session ID from the request. Default is
 	// reading from cookie.
 	ReadIDFunc func(r *http.Request) string
 	// WriteIDFunc is the function to write session ID to the response. Default is
 	// writing to cookie. The `created` argument indicates whether a new session was
-	// created in the session store.
+	// created in the session store. When the session cookie is written more than
+	// once within a request (e.g. Session.RegenerateID is called by handlers),
+	// only the last one is sent.
 	WriteIDFunc func(w http.ResponseWriter, r *http.Request, sid string, created bool)
+	// ShouldWriteID is the function to decide whether the session ID may be
+	// written to the response of the request, e.g. skipping prefetch requests
+	// (see session.IsPrefetch) whose responses are cached by CDNs. It is only
+	// evaluated by the default WriteIDFunc. Default is to always write.
+	ShouldWriteID func(r *http.Request) bool
+	// FlashStore is the storage of flashes separate from the session data, e.g.
+	// session.CookieFlashStore, so that consuming a flash never requires saving
+	// the session. Default is not set, i.e. flashes are kept in the session
+	// data.
+	FlashStore FlashStore
+	// PreserveKeys is the list of keys of the session data to be preserved
+	// across session resets, i.e. Session.Flush (including session.SignOut),
+	// session.Promote and the recreation of sessions due to AbsoluteTimeout, e.g.
+	// cross-cutting values like preferences that should survive signing out.
+	// Default is not set, i.e. resets wipe out all keys.
+	PreserveKeys []interface{}
+	// ClearIDFunc is the function to clear the session ID from the client when
+	// the session is destroyed via session.Destroy. Default is writing an expired
+	// cookie.
+	ClearIDFunc func(w http.ResponseWriter, r *http.Request)
+	// DisableAutoCreate indicates whether to defer creating a session for visitors
+	// without an existing session until the session is first written to, or
+	// explicitly started via session.Start. No session ID is written to the client
+	// and nothing is persisted to the session store for sessions that are never
+	// started. Default is false, i.e. a session is created for every visitor.
+	DisableAutoCreate bool
+	// ExpiresInHeader is the name of the response header to carry the number of
+	// seconds remaining before the session expires, e.g. "X-Session-Expires-In".
+	// The remaining time is measured when the request is received, i.e. before the
+	// request extends the session. It requires the session store to implement
+	// session.Expirer. Default is not set, i.e. the header is not emitted.
+	ExpiresInHeader string
+	// ExpiryWarningWindow is the time window before the session expires within
+	// which OnExpiryWarning is invoked. Default is 2 minutes.
+	ExpiryWarningWindow time.Duration
+	// OnExpiryWarning is the function to be invoked when the session is within
+	// ExpiryWarningWindow of expiring when the request is received. It requires
+	// the session store to implement session.Expirer. Default is not set.
+	OnExpiryWarning func(c flamego.Context, s Session, expiresIn time.Duration)
+	// TouchThreshold is the minimum time since the last extension of the session
+	// expiry before an unchanged session is extended again, which reduces writes
+	// to the session store for chatty clients at the cost of the expiry being up
+	// to the threshold earlier. Sessions with changed data are always saved.
+	// Default is 0, i.e. the expiry is extended on every request.
+	TouchThreshold time.Duration
+	// AbsoluteTimeout is the maximum lifetime of sessions since creation, after
+	// which the session is destroyed regardless of activity and replaced with a
+	// new session. Default is 0, i.e. disabled.
+	AbsoluteTimeout time.Duration
+	// OnAbsoluteTimeout is the function to be invoked with the new session before
+	// other handlers when the session of the request has been destroyed due to
+	// AbsoluteTimeout, e.g. to set a flash message and redirect to the login page.
+	// Remaining handlers are skipped if the function writes to the response.
+	// Default is not set.
+	OnAbsoluteTimeout func(c flamego.Context, s Session)
+	// IdleLockAfter is the idle time since the session was last seen (see
+	// session.InfoOf), after which the session is locked but stays alive (see
+	// session.IsLocked), like a screen lock. The last seen time is updated at
+	// most once a minute, or once per TouchThreshold if longer. Default is 0, i.e.
+	// disabled.
+	IdleLockAfter time.Duration
+	// OnExposure is the function to be invoked every time the session is exposed
+	// to a variant of an experiment via session.Variant, e.g. to report the
+	// exposure to the analytics. Default is not set.
+	OnExposure func(c flamego.Context, experiment, variant string)
+	// DetectLocale indicates whether to set the locale of new sessions (see
+	// session.SetLocale) from the Accept-Language header of the request. Default
+	// is false.
+	DetectLocale bool
+	// SupportedLocales is the list of locales supported by the application, which
+	// makes DetectLocale pick the best match among them rather than the most
+	// preferred locale of the request. It panics if any of the locales is not a
+	// well-formed BCP 47 language tag. Default is not set.
+	SupportedLocales []string
+	// BlobStore is the storage of large values of sessions, see session.PutBlob.
+	// Default is not set, i.e. blobs are not supported.
+	BlobStore BlobStore
+	// RotateIDAfter is the maximum age of session IDs, after which the session ID
+	// is regenerated transparently with the session data carried over, regardless
+	// of privilege changes. The session with the old ID is destroyed once the
+	// session is saved with the new ID. Default is 0, i.e. disabled.
+	RotateIDAfter time.Duration
+	// SkipIdenticalSave indicates whether to skip saving sessions that have been
+	// written to but whose encoding and tags are identical to those when loaded,
+	// which are touched instead. It requires a deterministic encoder of the
+	// session store, e.g. session.DeterministicGobEncoder, to be effective, and
+	// costs an extra encoding of each session when loaded. Default is false.
+	SkipIdenticalSave bool
 }
 
 const minimumSIDLength = 3
 
-var ErrMinimumSIDLength = errors.Errorf("the SID does not have the minimum required length %d", minimumSIDLength)
+var ErrMinimumSIDLength = fmt.Errorf("the SID does not have the minimum required length %d", minimumSIDLength)
 
 // Sessioner returns a middleware handler that injects session.Session and
 // session.Store into the request context, which are used for manipulating
-// session data.
+// session data, as well as session.Info for identifying the session.
 func Sessioner(opts ...Options) flamego.Handler {
+	freezeKeyNamespace()
+
 	var opt Options
 	if len(opts) > 0 {
 		opt = opts[0]
@@ -101,7 +362,9 @@ func Sessioner(opts ...Options) flamego.Handler {
 			opts.Initer = MemoryIniter()
 		}
 
-		if reflect.DeepEqual(opts.Cookie, CookieOptions{}) {
+		if opts.Cookie.Preset != PresetNone {
+			opts.Cookie = opts.Cookie.applyPreset()
+		} else if reflect.DeepEqual(opts.Cookie, CookieOptions{}) {
 			opts.Cookie = CookieOptions{
 				HTTPOnly: true,
 			}
@@ -115,19 +378,89 @@ func Sessioner(opts ...Options) flamego.Handler {
 		if opts.Cookie.Path == "" {
 			opts.Cookie.Path = "/"
 		}
+		err := opts.Cookie.validate()
+		if err != nil {
+			panic("session: cookie: " + err.Error())
+		}
 
 		// NOTE: The file store requires at least 3 characters for the filename.
 		if opts.IDLength < minimumSIDLength {
 			opts.IDLength = 16
 		}
 
-		if opts.GCInterval.Seconds() < 1 {
-			opts.GCInterval = 5 * time.Minute
+		if opts.OnExpireBatchSize < 1 {
+			opts.OnExpireBatchSize = 100
+		}
+		if opts.ExpiryQueueSize < 1 {
+			opts.ExpiryQueueSize = 1000
+		}
+		if opts.ExpirySendTimeout <= 0 {
+			opts.ExpirySendTimeout = 30 * time.Second
+		}
+
+		switch opts.GCMode {
+		case GCBackground, GCDisabled, GCExternal:
+		default:
+			panic("session: unknown GC mode " + strconv.Itoa(int(opts.GCMode)))
+		}
+		switch opts.MaxActiveAction {
+		case ActiveLimitEphemeral, ActiveLimitReject, ActiveLimitEvictLRU:
+		default:
+			panic("session: unknown active limit action " + strconv.Itoa(int(opts.MaxActiveAction)))
+		}
+
+		if opts.Retry.Attempts < 1 {
+			opts.Retry.Attempts = 1
+		}
+		if opts.Retry.Backoff == nil {
+			opts.Retry.Backoff = ExponentialBackoff(10*time.Millisecond, time.Second)
+		}
+		if opts.Retry.IsRetryable == nil {
+			opts.Retry.IsRetryable = IsTransientError
+		}
+
+		if fs, ok := opts.FlashStore.(*CookieFlashStore); ok {
+			err := fs.validate()
+			if err != nil {
+				panic("session: flash store: " + err.Error())
+			}
+		}
+
+		if opts.Audit.RequestIDFunc == nil {
+			opts.Audit.RequestIDFunc = func(r *http.Request) string {
+				return r.Header.Get("X-Request-Id")
+			}
+		}
+		if len(opts.Audit.HashKey) == 0 {
+			opts.Audit.HashKey = make([]byte, 32)
+			_, err := rand.Read(opts.Audit.HashKey)
+			if err != nil {
+				panic("session: generate audit hash key: " + err.Error())
+			}
+		}
+
+		if opts.ImpersonationTTL <= 0 {
+			opts.ImpersonationTTL = time.Hour
 		}
 
+		if opts.NowFunc == nil {
+			opts.NowFunc = time.Now
+		}
+		if opts.CircuitBreaker.NowFunc == nil {
+			opts.CircuitBreaker.NowFunc = opts.NowFunc
+		}
 		if opts.ErrorFunc == nil {
 			opts.ErrorFunc = func(error) {}
 		}
+		if opts.Redactor == nil {
+			opts.Redactor = RedactSessionData
+		}
+		errorFunc, redact := opts.ErrorFunc, opts.Redactor
+		opts.ErrorFunc = func(err error) { errorFunc(redact(err)) }
+
+		if opts.ExpiryWarningWindow <= 0 {
+			opts.ExpiryWarningWindow = 2 * time.Minute
+		}
 
 		if opts.ReadIDFunc == nil {
 			opts.ReadIDFunc = func(r *http.Request) string {
@@ -135,18 +468,42 @@ func Sessioner(opts ...Options) flamego.Handler {
 				if err != nil {
 					return ""
 				}
-				return cookie.Value
+				if !opts.Cookie.Envelope.Enabled {
+					return cookie.Value
+				}
+				sid, _ := opts.Cookie.Envelope.decode(cookie.Value)
+				return sid
 			}
 		}
 		if opts.WriteIDFunc == nil {
 			opts.WriteIDFunc = func(w http.ResponseWriter, r *http.Request, sid string, created bool) {
-				if !created {
+				if opts.ShouldWriteID != nil && !opts.ShouldWriteID(r) {
 					return
 				}
 
+				value := sid
+				if opts.Cookie.Envelope.Enabled {
+					value = opts.Cookie.Envelope.encode(sid)
+				}
+
+				if !created {
+					// Upgrade the cookie in a legacy format of the envelope
+					if !opts.Cookie.Envelope.Enabled {
+						return
+					}
+					cookie, err := r.Cookie(opts.Cookie.Name)
+					if err != nil {
+						return
+					}
+					got, current := opts.Cookie.Envelope.decode(cookie.Value)
+					if current || got != sid {
+						return
+					}
+				}
+
 				cookie := &http.Cookie{
 					Name:     opts.Cookie.Name,
-					Value:    sid,
+					Value:    value,
 					Path:     opts.Cookie.Path,
 					Domain:   opts.Cookie.Domain,
 					MaxAge:   opts.Cookie.MaxAge,
@@ -155,57 +512,379 @@ func Sessioner(opts ...Options) flamego.Handler {
 					SameSite: opts.Cookie.SameSite,
 				}
 				http.SetCookie(w, cookie)
-				r.AddCookie(cookie)
+				setRequestCookie(r, cookie)
+			}
+		}
+		if opts.ClearIDFunc == nil {
+			opts.ClearIDFunc = func(w http.ResponseWriter, r *http.Request) {
+				http.SetCookie(w, &http.Cookie{
+					Name:     opts.Cookie.Name,
+					Path:     opts.Cookie.Path,
+					Domain:   opts.Cookie.Domain,
+					MaxAge:   -1,
+					Secure:   opts.Cookie.Secure,
+					HttpOnly: opts.Cookie.HTTPOnly,
+					SameSite: opts.Cookie.SameSite,
+				})
 			}
 		}
 		return opts
 	}
 
+	// Session IDs are only hashed in session.Info with the configured key, as
+	// hashes of the random key are not comparable across instances and restarts.
+	hashInfo := len(opt.Audit.HashKey) > 0
 	opt = parseOptions(opt)
 	ctx := context.Background()
 
-	store, err := opt.Initer(
-		ctx,
-		opt.Config,
-		IDWriter(func(w http.ResponseWriter, r *http.Request, sid string) {
-			opt.WriteIDFunc(w, r, sid, true)
-		}),
-	)
+	idWriter := IDWriter(func(w http.ResponseWriter, r *http.Request, sid string) {
+		opt.WriteIDFunc(w, r, sid, true)
+	})
+	store, err := opt.Initer(ctx, opt.Config, idWriter)
 	if err != nil {
 		panic("session: " + err.Error())
 	}
 
-	mgr := newManager(store)
-	mgr.startGC(ctx, opt.GCInterval, opt.ErrorFunc)
+	if debugStoreChecks {
+		store = newCheckedStore(store, slowStoreCall, opt.ErrorFunc)
+	}
+	if opt.CircuitBreaker.Threshold > 0 {
+		store = NewCircuitBreaker(store, opt.CircuitBreaker)
+	}
+	store = wrapStore(store, opt.StoreWrappers...)
+	caps := Capabilities(store)
+	typ := storeType(store)
+
+	locales, err := newLocaleDetector(opt.SupportedLocales)
+	if err != nil {
+		panic("session: supported locales: " + err.Error())
+	}
+
+	if opt.ExpirySink != nil && opt.GCMode == GCBackground && caps.ArchiveOnGC {
+		notifier := newExpiryNotifier(opt.ExpirySink, opt.ExpiryQueueSize, opt.ExpirySendTimeout, opt.NowFunc, opt.ErrorFunc)
+		opt.OnExpire = notifier.onExpire(opt.OnExpire)
+	}
+
+	mgr := newManager(store, opt)
+	if opt.GCMode == GCBackground && needsGC(store) {
+		mgr.startGC(ctx, gcInterval(store, opt.GCInterval), opt.ErrorFunc)
+	}
 
 	return flamego.ContextInvoker(func(c flamego.Context) {
+		// The session cookie may be written more than once within a request, e.g.
+		// RegenerateID is called by handlers, only send the final one.
+		c.ResponseWriter().Before(func(w flamego.ResponseWriter) {
+			dedupeCookies(w.Header(), opt.Cookie.Name)
+		})
+		defer func() {
+			if !c.ResponseWriter().Written() {
+				dedupeCookies(c.ResponseWriter().Header(), opt.Cookie.Name)
+			}
+		}()
+
+		loadStartedAt := time.Now()
 		sid := opt.ReadIDFunc(c.Request().Request)
-		sess, created, err := mgr.load(c.Request().Request, sid, opt.IDLength)
+
+		var sess Session
+		var created bool
+		var err error
+		if opt.DisableAutoCreate {
+			sess, err = mgr.loadLazy(c.Request().Request, sid, func(sid string, created bool) {
+				if headerWritten(c.ResponseWriter()) {
+					opt.ErrorFunc(fmt.Errorf("start session: %w", ErrHeaderWritten))
+				}
+				opt.WriteIDFunc(c.ResponseWriter(), c.Request().Request, sid, created)
+			})
+		} else {
+			sess, created, err = mgr.load(c.Request().Request, sid)
+		}
+		if err == nil {
+			// Sessions may be shared by concurrent requests, thus state of the request
+			// is kept in a view of the session.
+			sess = viewOf(sess)
+		}
+		if opt.MinLoadDuration > 0 {
+			waitUntil(c.Request().Context(), loadStartedAt.Add(opt.MinLoadDuration))
+		}
 		if err != nil {
 			if errors.Is(err, context.Canceled) {
 				c.ResponseWriter().WriteHeader(http.StatusUnprocessableEntity)
 				return
 			}
-			panic("session: load: " + err.Error())
+			if errors.Is(err, ErrTooManySessions) {
+				c.ResponseWriter().WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if opt.MinLoadDuration > 0 {
+				// Do not leak whether the session exists through the error message
+				opt.ErrorFunc(fmt.Errorf("load: %w", err))
+				panic("session: load failed")
+			}
+			panic("session: load: " + opt.Redactor(err).Error())
 		}
-		opt.WriteIDFunc(c.ResponseWriter(), c.Request().Request, sess.ID(), created)
 
-		flash := sess.Get(flashKey)
-		if flash != nil {
-			sess.Delete(flashKey)
+		timedOut := opt.AbsoluteTimeout > 0 && !created && IsStarted(sess) && !IsEphemeral(sess) &&
+			absoluteTimeoutDue(sess, opt.NowFunc(), opt.AbsoluteTimeout)
+		if timedOut {
+			preserved := preservedData(sess, opt.PreserveKeys)
+			sess, err = mgr.restart(c.Request().Context(), sess.ID())
+			if err != nil {
+				panic("session: absolute timeout: " + opt.Redactor(err).Error())
+			}
+			sess = viewOf(sess)
+			for key, val := range preserved {
+				setInternal(sess, key, val)
+			}
+			created = true
 		}
 
+		// All state of the request is set to the view of the session at once, store
+		// features are dropped by sessions that are never saved.
+		rc := &requestContext{
+			ctx:       c.Request().Context(),
+			now:       opt.NowFunc,
+			newID:     mgr.ids.generate,
+			preserved: opt.PreserveKeys,
+			blobs:     opt.BlobStore,
+		}
+		if caps.Incr {
+			inc, _ := StoreAs[Incrementer](store)
+			rc.incr = func(key string, delta int64) (int64, error) {
+				return mgr.incr(c.Request().Context(), inc, sess.ID(), key, delta)
+			}
+		}
+		if caps.Lists {
+			ls, _ := StoreAs[ListStore](store)
+			rc.lists = mgr.lists(c.Request().Context(), ls, sess.ID)
+		}
+		if opt.OnExposure != nil {
+			rc.onExposure = func(experiment, variant string) {
+				opt.OnExposure(c, experiment, variant)
+			}
+		}
+		if opt.FlashStore != nil {
+			rc.writeFlash = func(val interface{}) {
+				err := opt.FlashStore.Write(c.ResponseWriter(), c.Request().Request, val)
+				if err != nil {
+					opt.ErrorFunc(fmt.Errorf("write flash: %w", err))
+				}
+			}
+		}
+		setRequestContext(sess, rc)
+
+		if opt.IdleLockAfter > 0 && !created && IsStarted(sess) && !IsEphemeral(sess) {
+			if now := opt.NowFunc(); idleLockDue(sess, now, opt.IdleLockAfter) {
+				lock(sess, now)
+			}
+		}
+		if t, ok := sess.(encodingTracker); ok && opt.SkipIdenticalSave && !created {
+			err = t.trackEncoding()
+			if err != nil {
+				opt.ErrorFunc(fmt.Errorf("track encoding: %w", err))
+			}
+		}
+		// The new session ID is written by the session itself when rotated
+		var rotatedFrom string
+		if opt.RotateIDAfter > 0 && !created && IsStarted(sess) && !IsEphemeral(sess) {
+			rotatedFrom, err = rotateID(c.ResponseWriter(), c.Request().Request, sess, opt.RotateIDAfter, opt.NowFunc())
+			if err != nil {
+				opt.ErrorFunc(fmt.Errorf("rotate ID: %w", err))
+			}
+		}
+		if IsStarted(sess) && !IsEphemeral(sess) && rotatedFrom == "" {
+			opt.WriteIDFunc(c.ResponseWriter(), c.Request().Request, sess.ID(), created)
+		}
+
+		if a, ok := sess.(auditor); ok && opt.Audit.Sink != nil {
+			a.startAudit()
+		}
+
+		if opt.DetectLocale && created && IsStarted(sess) && !IsEphemeral(sess) {
+			if locale := locales.detect(c.Request().Request); locale != "" {
+				_ = SetLocale(sess, locale)
+			}
+		}
+
+		if caps.ExpiresAt && (opt.ExpiresInHeader != "" || opt.OnExpiryWarning != nil) {
+			expiryWarning(c, store, sess, opt)
+		}
+
+		var flash interface{}
+		if opt.FlashStore != nil {
+			flash, err = opt.FlashStore.Read(c.ResponseWriter(), c.Request().Request)
+			if err != nil {
+				opt.ErrorFunc(fmt.Errorf("read flash: %w", err))
+			}
+		}
+		// Flashes in the session data are still consumed when the flash store is
+		// set, e.g. the ones that were set before adopting the flash store. So are
+		// the ones under the DefaultKeyNamespace, e.g. the ones that were set before
+		// changing the namespace via SetKeyNamespace.
+		for _, key := range []string{flashKey, DefaultKeyNamespace + "flash"} {
+			if flash == nil && sess.Get(key) != nil {
+				flash = sess.Get(key)
+				deleteInternal(sess, key)
+			}
+		}
+
+		if IsStarted(sess) {
+			expireImpersonation(sess, opt.ImpersonationTTL, opt.NowFunc())
+		}
+
+		var userBefore string
+		if opt.SessionLimit.Max > 0 && IsStarted(sess) {
+			userBefore = UserOf(sess)
+		}
+
+		reqStore := newRequestStore(store, sess)
 		c.Map(store, sess)
+		// Map the session store to the interface explicitly, which would otherwise
+		// be ambiguous with the RequestStore that also implements it.
+		c.MapTo(store, (*Store)(nil))
+		c.MapTo(reqStore, (*RequestStore)(nil))
+		info := Info{
+			Created:   created,
+			CreatedAt: CreatedAt(sess),
+			StoreType: typ,
+		}
+		if sid := sess.ID(); hashInfo && sid != "" {
+			info.HashedID = hashSessionID(opt.Audit.HashKey, sid)
+		}
+		if created && info.CreatedAt.IsZero() {
+			info.CreatedAt = opt.NowFunc()
+		}
+		c.Map(info)
+		state := &requestState{
+			created: created,
+			freshID: created || rotatedFrom != "",
+			clearID: func() { opt.ClearIDFunc(c.ResponseWriter(), c.Request().Request) },
+			destroy: mgr.destroy,
+
+			reportError: opt.ErrorFunc,
+		}
+		c.Map(state)
 		c.MapTo(flash, (*Flash)(nil))
-		c.Next()
+		if opt.DeriveFunc != nil {
+			mapDerived(c, sess, opt.DeriveFunc)
+		}
+		handled := false
+		if timedOut && opt.OnAbsoluteTimeout != nil {
+			written := c.ResponseWriter().Written()
+			opt.OnAbsoluteTimeout(c, sess)
+			handled = !written && c.ResponseWriter().Written()
+		}
+		if !handled {
+			c.Next()
+		}
 
-		if sess.HasChanged() {
-			err = store.Save(c.Request().Context(), sess)
-		} else {
-			err = store.Touch(c.Request().Context(), sess.ID())
+		err = reqStore.Flush(c.Request().Context())
+		if err != nil {
+			opt.ErrorFunc(fmt.Errorf("flush request store: %w", err))
+		}
+
+		if !IsStarted(sess) || IsEphemeral(sess) || state.discarded {
+			return
+		}
+
+		// Take the journal before tracking the session information, which is
+		// bookkeeping of the middleware and not meant to be audited.
+		var journal []journalEntry
+		if a, ok := sess.(auditor); ok && opt.Audit.Sink != nil {
+			journal = a.takeJournal()
+		}
+
+		trackInfo(c.Request().Request, sess, opt.NowFunc(), opt)
+		changed := sess.HasChanged()
+		if t, ok := sess.(encodingTracker); ok && changed && opt.SkipIdenticalSave {
+			changed = !t.identicalEncoding()
+		}
+		switch {
+		case opt.TouchThreshold > 0:
+			if now := opt.NowFunc(); changed || extensionDue(sess, now, opt.TouchThreshold) {
+				setInternal(sess, extendedKey, now.UnixNano())
+				err = mgr.save(c.Request().Context(), sess)
+			}
+		case changed:
+			err = mgr.save(c.Request().Context(), sess)
+		default:
+			err = mgr.touch(c.Request().Context(), sess.ID())
 		}
 		if err != nil && !errors.Is(err, context.Canceled) {
-			panic("session: save: " + err.Error())
+			panic("session: save: " + opt.Redactor(err).Error())
+		}
+
+		// Only destroy the session with the old ID after the session is saved with
+		// the new ID, so the session is never lost.
+		if rotatedFrom != "" && err == nil {
+			err = mgr.destroy(c.Request().Context(), rotatedFrom)
+			if err != nil {
+				opt.ErrorFunc(fmt.Errorf("destroy rotated %q: %w", rotatedFrom, err))
+			}
+		}
+
+		if opt.SessionLimit.Max > 0 {
+			userID := UserOf(sess)
+			if userID != "" && userID != userBefore {
+				err = enforceSessionLimit(c, store, sess.ID(), userID, opt.SessionLimit)
+				if err != nil {
+					opt.ErrorFunc(fmt.Errorf("enforce session limit: %w", err))
+				}
+			}
+		}
+
+		if b, ok := sess.(binder); ok {
+			b.unbind()
+		}
+
+		if len(journal) > 0 {
+			requestID := opt.Audit.RequestIDFunc(c.Request().Request)
+			err = opt.Audit.Sink(c.Request().Context(), auditEntries(sess.ID(), requestID, journal, opt.Audit.HashKey))
+			if err != nil {
+				opt.ErrorFunc(fmt.Errorf("audit: %w", err))
+			}
 		}
 	})
 }
+
+// expiryWarning emits the remaining time before the session expires to the
+// response header and invokes the expiry warning callback as configured.
+func expiryWarning(c flamego.Context, store Store, sess Session, opt Options) {
+	expirer, ok := StoreAs[Expirer](store)
+	if !ok || !IsStarted(sess) {
+		return
+	}
+
+	expiresAt, err := expirer.ExpiresAt(c.Request().Context(), sess.ID())
+	if err != nil {
+		opt.ErrorFunc(fmt.Errorf("get expiry time: %w", err))
+		return
+	} else if expiresAt.IsZero() {
+		return
+	}
+
+	expiresIn := expiresAt.Sub(opt.NowFunc())
+	if expiresIn < 0 {
+		expiresIn = 0
+	}
+
+	if opt.ExpiresInHeader != "" {
+		c.ResponseWriter().Header().Set(opt.ExpiresInHeader, strconv.Itoa(int(expiresIn.Seconds())))
+	}
+	if opt.OnExpiryWarning != nil && expiresIn <= opt.ExpiryWarningWindow {
+		opt.OnExpiryWarning(c, sess, expiresIn)
+	}
+}
+
+// absoluteTimeoutDue returns true if the session was created more than the
+// timeout before now.
+func absoluteTimeoutDue(s Session, now time.Time, timeout time.Duration) bool {
+	createdAt := InfoOf(s).CreatedAt
+	return !createdAt.IsZero() && now.Sub(createdAt) >= timeout
+}
+
+// extensionDue returns true if more than the threshold has passed since the
+// last extension of the session expiry until now.
+func extensionDue(s Session, now time.Time, threshold time.Duration) bool {
+	extendedAt, _ := s.Get(extendedKey).(int64)
+	return now.Sub(time.Unix(0, extendedAt)) >= threshold
+}
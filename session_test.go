@@ -7,12 +7,15 @@ package session
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -126,7 +129,7 @@ func (s *noopStore) Touch(context.Context, string) error {
 
 func (s *noopStore) Save(ctx context.Context, _ Session) error {
 	if ctx.Err() != nil {
-		return errors.Wrap(ctx.Err(), "something went wrong")
+		return fmt.Errorf("something went wrong: %w", ctx.Err())
 	}
 	return nil
 }
@@ -210,3 +213,462 @@ func TestSession_Flash(t *testing.T) {
 
 	assert.Equal(t, "no flash", resp.Body.String())
 }
+
+func TestSession_SetWithTTL(t *testing.T) {
+	ctx := context.Background()
+	store, err := FileIniter()(ctx,
+		FileConfig{
+			RootDir: t.TempDir(),
+		},
+		IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.NoError(t, err)
+
+	sess, err := store.Read(ctx, "111")
+	require.NoError(t, err)
+	SetWithTTL(sess, "otp", "123456", time.Hour)
+	SetWithTTL(sess, "challenge", "abcdef", time.Millisecond)
+	SetWithTTL(sess, "username", "flamego", time.Millisecond)
+	sess.Set("username", "flamego") // Set makes the value persistent
+	time.Sleep(5 * time.Millisecond)
+
+	err = store.Save(ctx, sess)
+	require.NoError(t, err)
+
+	sess, err = store.Read(ctx, "111")
+	require.NoError(t, err)
+	assert.Equal(t, "123456", sess.Get("otp"))
+	assert.Nil(t, sess.Get("challenge"))
+	assert.Equal(t, "flamego", sess.Get("username"))
+
+	// Expiry times should survive being saved
+	SetWithTTL(sess, "otp", "654321", time.Millisecond)
+	err = store.Save(ctx, sess)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	sess, err = store.Read(ctx, "111")
+	require.NoError(t, err)
+	assert.False(t, sess.HasChanged())
+	assert.Nil(t, sess.Get("otp"))
+	assert.True(t, sess.HasChanged())
+}
+
+func TestSession_Incr(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner())
+	f.Get("/", func(s Session) string {
+		n, err := Incr(s, "visits", 1)
+		if err != nil {
+			return err.Error()
+		}
+		return strconv.FormatInt(n, 10)
+	})
+
+	var cookie string
+	for i := 1; i <= 3; i++ {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, err)
+
+		req.Header.Set("Cookie", cookie)
+		f.ServeHTTP(resp, req)
+		assert.Equal(t, strconv.Itoa(i), resp.Body.String())
+
+		if cookie == "" {
+			cookie = resp.Header().Get("Set-Cookie")
+		}
+	}
+}
+
+func TestBaseSession_IncrError(t *testing.T) {
+	sess := NewBaseSession("111", GobEncoder, nil)
+	sess.setRequestContext(&requestContext{
+		incr: func(string, int64) (int64, error) {
+			return 0, errors.New("connection refused")
+		},
+	})
+	_, err := Incr(sess, "visits", 1)
+	assert.EqualError(t, err, "incr: connection refused")
+	assert.False(t, sess.HasChanged())
+}
+
+func TestBaseSession_IncrView(t *testing.T) {
+	sess := NewBaseSession("111", GobEncoder, nil)
+	view := sess.view()
+	setRequestContext(view, &requestContext{
+		incr: func(_ string, delta int64) (int64, error) {
+			return 100 + delta, nil
+		},
+	})
+
+	// The function to increment counters is only used by the view it is set on
+	n, err := Incr(view, "visits", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(101), n)
+	n, err = Incr(sess, "visits", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Equal(t, int64(1), view.Get("visits"))
+}
+
+func TestSessioner_DisableAutoCreate(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			DisableAutoCreate: true,
+		},
+	))
+	f.Get("/", func(s Session, store Store) string {
+		return fmt.Sprintf("%v %v", IsStarted(s), s.Get("username"))
+	})
+	f.Get("/set", func(s Session) {
+		s.Set("username", "flamego")
+	})
+
+	// No session should be created for an anonymous visitor
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, "false <nil>", resp.Body.String())
+	assert.Empty(t, resp.Header().Get("Set-Cookie"))
+
+	// Writing to the session should start it
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/set", nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	cookie := resp.Header().Get("Set-Cookie")
+	assert.NotEmpty(t, cookie)
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+
+	req.Header.Set("Cookie", cookie)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, "true flamego", resp.Body.String())
+	assert.Empty(t, resp.Header().Get("Set-Cookie"))
+}
+
+func TestSessioner_DisableAutoCreate_RegenerateID(t *testing.T) {
+	newServer := func() *flamego.Flame {
+		f := flamego.NewWithLogger(&bytes.Buffer{})
+		f.Use(Sessioner(
+			Options{
+				DisableAutoCreate: true,
+				GCMode:            GCDisabled,
+			},
+		))
+		f.Get("/set", func(s Session) {
+			s.Set("username", "flamego")
+		})
+		f.Get("/sign-in", func(c flamego.Context, s Session) {
+			require.NoError(t, s.RegenerateID(c.ResponseWriter(), c.Request().Request))
+			s.Set("username", "flamego")
+		})
+		return f
+	}
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/set", nil)
+	require.NoError(t, err)
+	newServer().ServeHTTP(resp, req)
+	cookie := resp.Header().Get("Set-Cookie")
+	require.NotEmpty(t, cookie)
+
+	// The session ID from the client is valid but missing in the session store of
+	// another server, the regenerated ID must still be written to the client.
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/sign-in", nil)
+	require.NoError(t, err)
+	req.Header.Set("Cookie", cookie)
+	newServer().ServeHTTP(resp, req)
+	regenerated := resp.Header().Get("Set-Cookie")
+	assert.NotEmpty(t, regenerated)
+	assert.NotEqual(t, cookie, regenerated)
+}
+
+func TestSessioner_DisableAutoCreate_WriteIDFunc(t *testing.T) {
+	var current Session
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			DisableAutoCreate: true,
+			GCMode:            GCDisabled,
+			WriteIDFunc: func(w http.ResponseWriter, _ *http.Request, _ string, _ bool) {
+				// Accessing the session must not deadlock
+				w.Header().Set("X-Session-ID", current.ID())
+			},
+		},
+	))
+	f.Get("/set", func(s Session) {
+		current = s
+		s.Set("username", "flamego")
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/set", nil)
+		assert.NoError(t, err)
+		f.ServeHTTP(resp, req)
+		done <- resp
+	}()
+	select {
+	case resp := <-done:
+		assert.NotEmpty(t, resp.Header().Get("X-Session-ID"))
+	case <-time.After(5 * time.Second):
+		t.Fatal("Deadlocked starting the session")
+	}
+}
+
+func TestSession_RegenerateID_HeaderWritten(t *testing.T) {
+	var reported error
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			DisableAutoCreate: true,
+			ErrorFunc:         func(err error) { reported = err },
+		},
+	))
+	f.Get("/regenerate", func(c flamego.Context, s Session) {
+		s.Set("username", "flamego")
+		sid := s.ID()
+		_, _ = c.ResponseWriter().Write([]byte("partial"))
+
+		err := s.RegenerateID(c.ResponseWriter(), c.Request().Request)
+		assert.Equal(t, ErrHeaderWritten, err)
+		assert.Equal(t, sid, s.ID(), "session ID is changed")
+	})
+	f.Get("/start", func(c flamego.Context, s Session) {
+		_, _ = c.ResponseWriter().Write([]byte("partial"))
+		s.Set("username", "flamego")
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/regenerate", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	assert.NotEmpty(t, resp.Header().Get("Set-Cookie"))
+
+	// Starting a lazy session after writing the response is reported
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/start", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	assert.Empty(t, resp.Result().Cookies(), "cookies are written after the header")
+	assert.True(t, errors.Is(reported, ErrHeaderWritten))
+}
+
+func TestSessioner_ExpiryWarning(t *testing.T) {
+	var warned time.Duration
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			ExpiresInHeader:     "X-Session-Expires-In",
+			ExpiryWarningWindow: 2 * time.Hour,
+			OnExpiryWarning: func(_ flamego.Context, _ Session, expiresIn time.Duration) {
+				warned = expiresIn
+			},
+		},
+	))
+	f.Get("/", func() {})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	cookie := resp.Header().Get("Set-Cookie")
+
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+
+	req.Header.Set("Cookie", cookie)
+	f.ServeHTTP(resp, req)
+
+	expiresIn, err := strconv.Atoi(resp.Header().Get("X-Session-Expires-In"))
+	require.NoError(t, err)
+	assert.InDelta(t, 3600, expiresIn, 1)
+	assert.InDelta(t, time.Hour, warned, float64(time.Second))
+}
+
+type writeCountingStore struct {
+	Store
+	saves   int
+	touches int
+}
+
+func (s *writeCountingStore) Touch(ctx context.Context, sid string) error {
+	s.touches++
+	return s.Store.Touch(ctx, sid)
+}
+
+func (s *writeCountingStore) Save(ctx context.Context, sess Session) error {
+	s.saves++
+	return s.Store.Save(ctx, sess)
+}
+
+func TestSessioner_TouchThreshold(t *testing.T) {
+	var store *writeCountingStore
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Initer: func(ctx context.Context, args ...interface{}) (Store, error) {
+				file, err := FileIniter()(ctx, args...)
+				if err != nil {
+					return nil, err
+				}
+				store = &writeCountingStore{Store: file}
+				return store, nil
+			},
+			Config: FileConfig{
+				RootDir: t.TempDir(),
+			},
+			TouchThreshold: time.Hour,
+		},
+	))
+	f.Get("/", func() {})
+	f.Get("/set", func(s Session) {
+		s.Set("username", "flamego")
+	})
+
+	var cookie string
+	request := func(path string) {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if cookie == "" {
+			cookie = resp.Header().Get("Set-Cookie")
+		}
+	}
+
+	request("/")
+	assert.Equal(t, 1, store.saves, "saves of the new session")
+
+	// Unchanged sessions are not extended within the threshold
+	request("/")
+	assert.Equal(t, 1, store.saves)
+	assert.Equal(t, 0, store.touches)
+
+	// Changed sessions are always saved
+	request("/set")
+	assert.Equal(t, 2, store.saves)
+
+	// Unchanged sessions are extended once the threshold has passed
+	sid := strings.TrimPrefix(strings.Split(cookie, ";")[0], "flamego_session=")
+	sess, err := store.Read(context.Background(), sid)
+	require.NoError(t, err)
+	setInternal(sess, extendedKey, time.Now().Add(-2*time.Hour).UnixNano())
+	require.NoError(t, store.Store.Save(context.Background(), sess))
+
+	request("/")
+	assert.Equal(t, 3, store.saves)
+	request("/")
+	assert.Equal(t, 3, store.saves)
+	assert.Equal(t, 0, store.touches)
+}
+
+type failingReadStore struct {
+	noopStore
+}
+
+func (s *failingReadStore) Read(_ context.Context, sid string) (Session, error) {
+	return nil, fmt.Errorf("session %q is corrupted", sid)
+}
+
+func TestSessioner_MinLoadDuration(t *testing.T) {
+	t.Run("pad loading", func(t *testing.T) {
+		const minLoadDuration = 50 * time.Millisecond
+		f := flamego.NewWithLogger(&bytes.Buffer{})
+		f.Use(Sessioner(
+			Options{
+				MinLoadDuration: minLoadDuration,
+			},
+		))
+		f.Get("/", func() {})
+
+		var cookie string
+		for _, name := range []string{"new session", "existing session"} {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			require.NoError(t, err)
+			req.Header.Set("Cookie", cookie)
+
+			startedAt := time.Now()
+			f.ServeHTTP(resp, req)
+			assert.GreaterOrEqual(t, time.Since(startedAt), minLoadDuration, name)
+
+			if cookie == "" {
+				cookie = resp.Header().Get("Set-Cookie")
+			}
+		}
+	})
+
+	t.Run("generic error", func(t *testing.T) {
+		var gotErr error
+		f := flamego.NewWithLogger(&bytes.Buffer{})
+		f.Use(Sessioner(
+			Options{
+				Initer: func(context.Context, ...interface{}) (Store, error) {
+					return &failingReadStore{}, nil
+				},
+				MinLoadDuration: time.Millisecond,
+				ErrorFunc: func(err error) {
+					gotErr = err
+				},
+			},
+		))
+		f.Get("/", func() {})
+
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, err)
+		assert.PanicsWithValue(t, "session: load failed", func() { f.ServeHTTP(resp, req) })
+		require.Error(t, gotErr)
+		assert.Contains(t, gotErr.Error(), "is corrupted")
+	})
+}
+
+type namedStore struct {
+	Store
+	name string
+}
+
+func (s *namedStore) Unwrap() Store {
+	return s.Store
+}
+
+func TestSessioner_StoreWrappers(t *testing.T) {
+	named := func(name string) StoreMiddleware {
+		return func(store Store) Store {
+			return &namedStore{Store: store, name: name}
+		}
+	}
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(Options{
+		StoreWrappers: []StoreMiddleware{named("inner"), nil, named("outer")},
+	}))
+	f.Get("/", func(store Store) string {
+		outer := store.(*namedStore)
+		inner := outer.Unwrap().(*namedStore)
+		_, ok := StoreAs[*memoryStore](store)
+		return outer.name + "," + inner.name + "," + strconv.FormatBool(ok)
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, "outer,inner,true", resp.Body.String())
+}
@@ -0,0 +1,377 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package sessiontest provides utilities for testing handlers that depend on
+// sessions, including a fake session store with injectable failures and
+// latency.
+package sessiontest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flamego/flamego"
+	"github.com/flamego/session"
+)
+
+// DefaultCookieName is the default name of the session cookie.
+const DefaultCookieName = "flamego_session"
+
+var _ session.Store = (*Store)(nil)
+
+// Store is an in-memory fake session store for tests. Sessions never expire
+// and GC is a no-op. Failures and latency can be injected for each method.
+type Store struct {
+	lock     sync.Mutex               // The mutex to guard accesses to the fields below
+	sessions map[string]*entry        // The sessions indexed by session IDs
+	failures map[string]error         // The injected errors indexed by method names
+	latency  map[string]time.Duration // The injected latency indexed by method names, "" for all methods
+	idWriter session.IDWriter
+}
+
+// entry is a session in the fake session store.
+type entry struct {
+	data []byte
+	tags map[string]string
+}
+
+// NewStore returns a new fake session store.
+func NewStore() *Store {
+	return &Store{
+		sessions: make(map[string]*entry),
+		failures: make(map[string]error),
+		latency:  make(map[string]time.Duration),
+		idWriter: func(http.ResponseWriter, *http.Request, string) {},
+	}
+}
+
+// Initer returns the Initer that always returns the fake session store, to be
+// used as session.Options.Initer.
+func (s *Store) Initer() session.Initer {
+	return func(_ context.Context, args ...interface{}) (session.Store, error) {
+		for _, arg := range args {
+			if idWriter, ok := arg.(session.IDWriter); ok {
+				s.lock.Lock()
+				s.idWriter = idWriter
+				s.lock.Unlock()
+			}
+		}
+		return s, nil
+	}
+}
+
+// Fail makes subsequent calls of the method (e.g. "Save") fail with the error,
+// a nil error clears the injected failure. Methods that do not return errors
+// (i.e. Exist) report the session as nonexistent instead, failures of Exist are
+// also returned by CheckExist.
+func (s *Store) Fail(method string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err == nil {
+		delete(s.failures, method)
+		return
+	}
+	s.failures[method] = err
+}
+
+// SetLatency makes subsequent calls of the method (e.g. "Read") take at least
+// the duration unless the context is done, an empty method name applies to all
+// methods.
+func (s *Store) SetLatency(method string, d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.latency[method] = d
+}
+
+// enter applies the injected latency and failure of the method.
+func (s *Store) enter(ctx context.Context, method string) error {
+	s.lock.Lock()
+	latency, ok := s.latency[method]
+	if !ok {
+		latency = s.latency[""]
+	}
+	err := s.failures[method]
+	s.lock.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return err
+}
+
+func (s *Store) Exist(ctx context.Context, sid string) bool {
+	ok, _ := s.CheckExist(ctx, sid)
+	return ok
+}
+
+var _ session.ExistChecker = (*Store)(nil)
+
+func (s *Store) CheckExist(ctx context.Context, sid string) (bool, error) {
+	err := s.enter(ctx, "Exist")
+	if err != nil {
+		return false, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.sessions[sid]
+	return ok, nil
+}
+
+func (s *Store) Read(ctx context.Context, sid string) (session.Session, error) {
+	err := s.enter(ctx, "Read")
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, ok := s.sessions[sid]
+	if !ok {
+		return session.NewBaseSession(sid, session.GobEncoder, s.idWriter), nil
+	}
+
+	data, err := session.GobDecoder(e.data)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	sess := session.NewBaseSessionWithData(sid, session.GobEncoder, s.idWriter, data)
+	sess.LoadTags(e.tags)
+	return sess, nil
+}
+
+func (s *Store) Destroy(ctx context.Context, sid string) error {
+	err := s.enter(ctx, "Destroy")
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.sessions, sid)
+	return nil
+}
+
+func (s *Store) Touch(ctx context.Context, _ string) error {
+	return s.enter(ctx, "Touch")
+}
+
+func (s *Store) Save(ctx context.Context, sess session.Session) error {
+	err := s.enter(ctx, "Save")
+	if err != nil {
+		return err
+	}
+
+	binary, err := sess.Encode()
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sessions[sess.ID()] = &entry{
+		data: binary,
+		tags: session.TagsOf(sess),
+	}
+	return nil
+}
+
+func (s *Store) GC(ctx context.Context) error {
+	return s.enter(ctx, "GC")
+}
+
+var _ session.Lister = (*Store)(nil)
+
+func (s *Store) List(ctx context.Context) ([]string, error) {
+	err := s.enter(ctx, "List")
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sids := make([]string, 0, len(s.sessions))
+	for sid := range s.sessions {
+		sids = append(sids, sid)
+	}
+	sort.Strings(sids)
+	return sids, nil
+}
+
+var _ session.TagFinder = (*Store)(nil)
+
+func (s *Store) FindByTag(ctx context.Context, key, value string) ([]string, error) {
+	err := s.enter(ctx, "FindByTag")
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var sids []string
+	for sid, e := range s.sessions {
+		if v, ok := e.tags[key]; ok && v == value {
+			sids = append(sids, sid)
+		}
+	}
+	sort.Strings(sids)
+	return sids, nil
+}
+
+// Data returns the data of the session with given ID in the store, or nil if
+// the session does not exist.
+func (s *Store) Data(t testing.TB, sid string) session.Data {
+	t.Helper()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, ok := s.sessions[sid]
+	if !ok {
+		return nil
+	}
+	data, err := session.GobDecoder(e.data)
+	if err != nil {
+		t.Fatalf("Failed to decode session %q: %v", sid, err)
+	}
+	return data
+}
+
+// NewSession adds a new session with given data to the store and returns its
+// session ID.
+func (s *Store) NewSession(t testing.TB, data session.Data) string {
+	t.Helper()
+
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		t.Fatalf("Failed to generate session ID: %v", err)
+	}
+	sid := hex.EncodeToString(b)
+
+	binary, err := session.GobEncoder(data)
+	if err != nil {
+		t.Fatalf("Failed to encode session data: %v", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sessions[sid] = &entry{data: binary}
+	return sid
+}
+
+// NewRequestWithSession adds a new session with given data to the store, and
+// returns a new GET request to "/" that carries the session cookie with the
+// default cookie name. Change the method and URL of the request as needed.
+func (s *Store) NewRequestWithSession(t testing.TB, data session.Data) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.AddCookie(&http.Cookie{
+		Name:  DefaultCookieName,
+		Value: s.NewSession(t, data),
+	})
+	return req
+}
+
+// NewFlame returns a new Flame instance that uses the session.Sessioner with
+// given options and the fake session store, and the fake session store.
+func NewFlame(opts ...session.Options) (*flamego.Flame, *Store) {
+	var opt session.Options
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	store := NewStore()
+	opt.Initer = store.Initer()
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(session.Sessioner(opt))
+	return f, store
+}
+
+// Cookie returns the cookie of given name set by the response, or nil if there
+// is no such cookie. An empty name means the default cookie name.
+func Cookie(resp *httptest.ResponseRecorder, name string) *http.Cookie {
+	if name == "" {
+		name = DefaultCookieName
+	}
+	for _, cookie := range resp.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// SessionID returns the session ID in the session cookie with the default
+// cookie name set by the response, or empty if the cookie is not set.
+func SessionID(resp *httptest.ResponseRecorder) string {
+	cookie := Cookie(resp, "")
+	if cookie == nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// AssertNoFixation asserts that the route of given method and path does not
+// accept session IDs planted by attackers (see session.RequireFreshID), which
+// is checked with both an existing session in the store and a session ID that
+// the store has never seen. The route must respond with a new session ID and
+// the planted session must not be left in the store.
+func (s *Store) AssertNoFixation(t testing.TB, f *flamego.Flame, method, path string) {
+	t.Helper()
+
+	unknown := make([]byte, 8)
+	_, err := rand.Read(unknown)
+	if err != nil {
+		t.Fatalf("Failed to generate session ID: %v", err)
+	}
+
+	planted := map[string]string{
+		"existing session": s.NewSession(t, session.Data{}),
+		"unknown session":  hex.EncodeToString(unknown),
+	}
+	for name, sid := range planted {
+		req, err := http.NewRequest(method, path, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.AddCookie(&http.Cookie{
+			Name:  DefaultCookieName,
+			Value: sid,
+		})
+
+		resp := httptest.NewRecorder()
+		f.ServeHTTP(resp, req)
+
+		got := SessionID(resp)
+		if got == "" || got == sid {
+			t.Errorf("%s %s: the planted session ID of the %s is accepted", method, path, name)
+		}
+		if s.Data(t, sid) != nil {
+			t.Errorf("%s %s: the planted %s is left in the store", method, path, name)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sessiontest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/session"
+)
+
+func TestStore(t *testing.T) {
+	f, store := NewFlame()
+	f.Get("/", func(s session.Session) string {
+		username, _ := s.Get("username").(string)
+		s.Set("visited", true)
+		return username
+	})
+
+	req := store.NewRequestWithSession(t, session.Data{"username": "flamego"})
+	resp := httptest.NewRecorder()
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, "flamego", resp.Body.String())
+	assert.Empty(t, SessionID(resp))
+
+	sid, err := req.Cookie(DefaultCookieName)
+	require.NoError(t, err)
+	assert.Equal(t, true, store.Data(t, sid.Value)["visited"])
+
+	// A new session is created without a cookie
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+
+	f.ServeHTTP(resp, req)
+	assert.NotEmpty(t, SessionID(resp))
+	assert.NotNil(t, store.Data(t, SessionID(resp)))
+}
+
+func TestStore_Fail(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore()
+	sid := store.NewSession(t, session.Data{"username": "flamego"})
+
+	errBoom := errors.New("boom")
+	store.Fail("Read", errBoom)
+	_, err := store.Read(ctx, sid)
+	assert.Equal(t, errBoom, err)
+
+	store.Fail("Read", nil)
+	sess, err := store.Read(ctx, sid)
+	require.NoError(t, err)
+	assert.Equal(t, "flamego", sess.Get("username"))
+
+	store.SetLatency("", time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err = store.Save(ctx, sess)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestStore_AssertNoFixation(t *testing.T) {
+	f, store := NewFlame()
+	f.Post("/login", session.RequireFreshID, func(s session.Session) {
+		s.Set("username", "flamego")
+	})
+	store.AssertNoFixation(t, f, http.MethodPost, "/login")
+
+	// Routes without the guard accept planted session IDs
+	f.Post("/unguarded", func(s session.Session) {
+		s.Set("username", "flamego")
+	})
+	rec := &recordingTB{TB: t}
+	store.AssertNoFixation(rec, f, http.MethodPost, "/unguarded")
+	assert.Len(t, rec.errors, 4)
+}
+
+// recordingTB records errors instead of failing the test.
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingTB) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sharded
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// ring is a consistent hashing ring of shards, where each shard is placed at
+// multiple points as virtual nodes to balance the distribution.
+type ring struct {
+	points []uint64       // The sorted points on the ring
+	owners map[uint64]int // The indexes of shards that own the points
+	shards int            // The number of shards
+}
+
+// hashKey returns the position of the key on the ring. The FNV-1a hash is
+// finalized by the mixer of MurmurHash3 to spread similar keys (e.g. names of
+// virtual nodes) evenly over the ring.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// newRing returns a new ring of shards with given names, each of which is
+// placed at given number of virtual nodes.
+func newRing(names []string, virtualNodes int) *ring {
+	r := &ring{
+		points: make([]uint64, 0, len(names)*virtualNodes),
+		owners: make(map[uint64]int, len(names)*virtualNodes),
+		shards: len(names),
+	}
+	for i, name := range names {
+		for v := 0; v < virtualNodes; v++ {
+			p := hashKey(name + "#" + strconv.Itoa(v))
+			// Points that collide are kept by the first shard for stability
+			if _, ok := r.owners[p]; ok {
+				continue
+			}
+			r.points = append(r.points, p)
+			r.owners[p] = i
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// lookup returns indexes of at most n distinct shards that own the key, which
+// are the shards of the first points clockwise from the position of the key.
+func (r *ring) lookup(key string, n int) []int {
+	if n > r.shards {
+		n = r.shards
+	}
+
+	pos := hashKey(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= pos })
+	owners := make([]int, 0, n)
+	for i := 0; i < len(r.points) && len(owners) < n; i++ {
+		owner := r.owners[r.points[(start+i)%len(r.points)]]
+		seen := false
+		for _, o := range owners {
+			if o == owner {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			owners = append(owners, owner)
+		}
+	}
+	return owners
+}
@@ -0,0 +1,464 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package sharded provides a composite session store that distributes sessions
+// across multiple session stores (e.g. several Redis instances) by consistent
+// hashing of session IDs, for deployments outgrowing a single session store.
+package sharded
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/flamego/session"
+)
+
+// Shard is a backend session store of the sharded session store. Shards must
+// persist sessions on Save, thus the memory session store is not supported.
+type Shard struct {
+	// Name is the name of the shard, which determines its positions on the
+	// consistent hashing ring. Renaming a shard moves its sessions to other
+	// shards.
+	Name string
+	// Initer is the initialization function of the session store of the shard.
+	Initer session.Initer
+	// Config is the configuration object to be passed to the Initer.
+	Config interface{}
+}
+
+// Config contains options for the sharded session store.
+type Config struct {
+	// Shards is the list of backend session stores.
+	Shards []Shard
+	// ReplicationFactor is the number of shards that each session is written to,
+	// which keeps sessions available when some of the shards fail. Sessions are
+	// read from the first shard that has them. Default is 1.
+	ReplicationFactor int
+	// VirtualNodes is the number of positions of each shard on the consistent
+	// hashing ring, more virtual nodes distribute sessions more evenly. Default
+	// is 100.
+	VirtualNodes int
+	// MoveOnRead indicates whether to look up sessions in all shards when they are
+	// not found in the shards that own them, and move them to the owning shards
+	// on read. It keeps sessions available while shards are added or removed
+	// (see Rebalance), at the cost of querying all shards for missing sessions.
+	MoveOnRead bool
+	// OnMove is the function to be called when a session is moved from the shard
+	// to other shards by MoveOnRead or Rebalance. Default is not set.
+	OnMove func(sid, from string, to []string)
+}
+
+var _ session.Store = (*shardedStore)(nil)
+
+// shardedStore is a session store that distributes sessions across shards.
+type shardedStore struct {
+	names      []string                            // The names of shards
+	stores     []session.Store                     // The session stores of shards
+	ring       *ring                               // The consistent hashing ring of shards
+	replicas   int                                 // The number of shards that each session is written to
+	moveOnRead bool                                // Whether to move sessions found in non-owning shards on read
+	onMove     func(sid, from string, to []string) // The function to be called when a session is moved, may be nil
+}
+
+// owners returns indexes of shards that own the session with given ID.
+func (s *shardedStore) owners(sid string) []int {
+	return s.ring.lookup(sid, s.replicas)
+}
+
+// namesOf returns names of shards with given indexes.
+func (s *shardedStore) namesOf(indexes []int) []string {
+	names := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		names = append(names, s.names[i])
+	}
+	return names
+}
+
+// isOwner returns true if the shard with given index is one of the owners.
+func isOwner(owners []int, i int) bool {
+	for _, o := range owners {
+		if o == i {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the index of the first shard that has the session with given
+// ID, and whether the shard is one of the owners. Non-owning shards are only
+// looked up when MoveOnRead is enabled. It returns -1 if no shard has the
+// session.
+func (s *shardedStore) find(ctx context.Context, sid string) (index int, owned bool, err error) {
+	owners := s.owners(sid)
+	for _, i := range owners {
+		ok, err := session.CheckExist(ctx, s.stores[i], sid)
+		if err != nil {
+			return -1, false, fmt.Errorf("shard %q: %w", s.names[i], err)
+		} else if ok {
+			return i, true, nil
+		}
+	}
+
+	if !s.moveOnRead {
+		return -1, false, nil
+	}
+	for i := range s.stores {
+		if isOwner(owners, i) {
+			continue
+		}
+		ok, err := session.CheckExist(ctx, s.stores[i], sid)
+		if err != nil {
+			return -1, false, fmt.Errorf("shard %q: %w", s.names[i], err)
+		} else if ok {
+			return i, false, nil
+		}
+	}
+	return -1, false, nil
+}
+
+// move moves the session with given ID from the shard with given index to the
+// owning shards.
+func (s *shardedStore) move(ctx context.Context, sid string, from int) error {
+	sess, err := s.stores[from].Read(ctx, sid)
+	if err != nil {
+		return fmt.Errorf("read from shard %q: %w", s.names[from], err)
+	}
+
+	owners := s.owners(sid)
+	for _, i := range owners {
+		err = s.stores[i].Save(ctx, sess)
+		if err != nil {
+			return fmt.Errorf("save to shard %q: %w", s.names[i], err)
+		}
+	}
+
+	err = s.stores[from].Destroy(ctx, sid)
+	if err != nil {
+		return fmt.Errorf("destroy from shard %q: %w", s.names[from], err)
+	}
+
+	if s.onMove != nil {
+		s.onMove(sid, s.names[from], s.namesOf(owners))
+	}
+	return nil
+}
+
+func (s *shardedStore) Exist(ctx context.Context, sid string) bool {
+	ok, _ := s.CheckExist(ctx, sid)
+	return ok
+}
+
+var _ session.ExistChecker = (*shardedStore)(nil)
+
+func (s *shardedStore) CheckExist(ctx context.Context, sid string) (bool, error) {
+	i, _, err := s.find(ctx, sid)
+	if err != nil {
+		return false, err
+	}
+	return i >= 0, nil
+}
+
+func (s *shardedStore) Read(ctx context.Context, sid string) (session.Session, error) {
+	i, owned, err := s.find(ctx, sid)
+	if err != nil {
+		return nil, fmt.Errorf("find: %w", err)
+	}
+
+	switch {
+	case i < 0:
+		i = s.owners(sid)[0]
+	case !owned:
+		err = s.move(ctx, sid, i)
+		if err != nil {
+			return nil, fmt.Errorf("move: %w", err)
+		}
+		i = s.owners(sid)[0]
+	}
+
+	sess, err := s.stores[i].Read(ctx, sid)
+	if err != nil {
+		return nil, fmt.Errorf("shard %q: %w", s.names[i], err)
+	}
+	return sess, nil
+}
+
+var _ session.MultiReader = (*shardedStore)(nil)
+
+// ReadMany reads sessions from their first owning shards in one batch per shard
+// (see session.ReadMany). Sessions that are missing in their first owning
+// shards fall back to Read, which looks up other shards as configured.
+func (s *shardedStore) ReadMany(ctx context.Context, sids []string) (map[string]session.Session, error) {
+	batches := make(map[int][]string)
+	for _, sid := range sids {
+		i := s.owners(sid)[0]
+		batches[i] = append(batches[i], sid)
+	}
+
+	sessions := make(map[string]session.Session, len(sids))
+	for i, batch := range batches {
+		found, err := session.ReadMany(ctx, s.stores[i], batch)
+		if err != nil {
+			return nil, fmt.Errorf("shard %q: %w", s.names[i], err)
+		}
+		for sid, sess := range found {
+			sessions[sid] = sess
+		}
+	}
+	if s.replicas == 1 && !s.moveOnRead {
+		return sessions, nil
+	}
+
+	for _, sid := range sids {
+		if _, ok := sessions[sid]; ok {
+			continue
+		}
+
+		i, _, err := s.find(ctx, sid)
+		if err != nil {
+			return nil, fmt.Errorf("find: %w", err)
+		} else if i < 0 {
+			continue
+		}
+		sessions[sid], err = s.Read(ctx, sid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sessions, nil
+}
+
+func (s *shardedStore) Destroy(ctx context.Context, sid string) error {
+	// Sessions may be left in non-owning shards before being moved
+	shards := s.owners(sid)
+	if s.moveOnRead {
+		shards = shards[:0]
+		for i := range s.stores {
+			shards = append(shards, i)
+		}
+	}
+
+	for _, i := range shards {
+		err := s.stores[i].Destroy(ctx, sid)
+		if err != nil {
+			return fmt.Errorf("shard %q: %w", s.names[i], err)
+		}
+	}
+	return nil
+}
+
+func (s *shardedStore) Touch(ctx context.Context, sid string) error {
+	for _, i := range s.owners(sid) {
+		err := s.stores[i].Touch(ctx, sid)
+		if err != nil {
+			return fmt.Errorf("shard %q: %w", s.names[i], err)
+		}
+	}
+	return nil
+}
+
+func (s *shardedStore) Save(ctx context.Context, sess session.Session) error {
+	for _, i := range s.owners(sess.ID()) {
+		err := s.stores[i].Save(ctx, sess)
+		if err != nil {
+			return fmt.Errorf("shard %q: %w", s.names[i], err)
+		}
+	}
+	return nil
+}
+
+// shardNeedsGC returns false if the session store of the shard tells it does
+// not need GC operations.
+func shardNeedsGC(store session.Store) bool {
+	n, ok := session.StoreAs[session.GCNeeder](store)
+	return !ok || n.NeedsGC()
+}
+
+var _ session.GCNeeder = (*shardedStore)(nil)
+
+// NeedsGC returns true if any of the shards needs GC operations.
+func (s *shardedStore) NeedsGC() bool {
+	for _, store := range s.stores {
+		if shardNeedsGC(store) {
+			return true
+		}
+	}
+	return false
+}
+
+var _ session.GCScheduler = (*shardedStore)(nil)
+
+// GCInterval returns the shortest interval preferred by the shards that need GC
+// operations.
+func (s *shardedStore) GCInterval() time.Duration {
+	var interval time.Duration
+	for _, store := range s.stores {
+		if !shardNeedsGC(store) {
+			continue
+		}
+		if sc, ok := session.StoreAs[session.GCScheduler](store); ok {
+			if d := sc.GCInterval(); d > 0 && (interval == 0 || d < interval) {
+				interval = d
+			}
+		}
+	}
+	return interval
+}
+
+// GC performs GC operations on the shards that need them.
+func (s *shardedStore) GC(ctx context.Context) error {
+	for i := range s.stores {
+		if !shardNeedsGC(s.stores[i]) {
+			continue
+		}
+		err := s.stores[i].GC(ctx)
+		if err != nil {
+			return fmt.Errorf("shard %q: %w", s.names[i], err)
+		}
+	}
+	return nil
+}
+
+var _ session.Lister = (*shardedStore)(nil)
+
+// List returns IDs of sessions in all shards, sessions that are replicated in
+// multiple shards are only listed once.
+func (s *shardedStore) List(ctx context.Context) ([]string, error) {
+	seen := make(map[string]struct{})
+	var sids []string
+	for i := range s.stores {
+		lister, ok := session.StoreAs[session.Lister](s.stores[i])
+		if !ok || !session.Capabilities(s.stores[i]).List {
+			return nil, fmt.Errorf("shard %q is not capable of listing sessions", s.names[i])
+		}
+
+		list, err := lister.List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("shard %q: %w", s.names[i], err)
+		}
+		for _, sid := range list {
+			if _, ok := seen[sid]; !ok {
+				seen[sid] = struct{}{}
+				sids = append(sids, sid)
+			}
+		}
+	}
+	return sids, nil
+}
+
+var _ session.CapabilityReporter = (*shardedStore)(nil)
+
+// Capabilities reports listing sessions only when all shards are capable of
+// it.
+func (s *shardedStore) Capabilities() session.StoreCapabilities {
+	list := true
+	for i := range s.stores {
+		list = list && session.Capabilities(s.stores[i]).List
+	}
+	return session.StoreCapabilities{List: list}
+}
+
+// rebalance moves sessions in non-owning shards to the owning shards, and
+// returns the number of moved sessions.
+func (s *shardedStore) rebalance(ctx context.Context) (int, error) {
+	moved := 0
+	for i := range s.stores {
+		lister, ok := session.StoreAs[session.Lister](s.stores[i])
+		if !ok || !session.Capabilities(s.stores[i]).List {
+			return moved, fmt.Errorf("shard %q is not capable of listing sessions", s.names[i])
+		}
+
+		sids, err := lister.List(ctx)
+		if err != nil {
+			return moved, fmt.Errorf("list shard %q: %w", s.names[i], err)
+		}
+		for _, sid := range sids {
+			if isOwner(s.owners(sid), i) {
+				continue
+			}
+
+			err = s.move(ctx, sid, i)
+			if err != nil {
+				return moved, fmt.Errorf("move %q: %w", sid, err)
+			}
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// Rebalance moves sessions that are not in their owning shards to the owning
+// shards, e.g. after adding or removing shards, and returns the number of moved
+// sessions. Config.OnMove is called for each moved session. It requires all
+// shards to be capable of listing sessions (see session.Lister).
+func Rebalance(ctx context.Context, store session.Store) (int, error) {
+	s, ok := session.StoreAs[*shardedStore](store)
+	if !ok {
+		return 0, errors.New("not a sharded session store")
+	}
+	return s.rebalance(ctx)
+}
+
+// Initer returns the session.Initer for the sharded session store.
+func Initer() session.Initer {
+	return func(ctx context.Context, args ...interface{}) (session.Store, error) {
+		var cfg *Config
+		var idWriter session.IDWriter
+		for i := range args {
+			switch v := args[i].(type) {
+			case Config:
+				cfg = &v
+			case session.IDWriter:
+				idWriter = v
+			}
+		}
+		if idWriter == nil {
+			return nil, errors.New("IDWriter not given")
+		}
+
+		if cfg == nil {
+			return nil, fmt.Errorf("config object with the type '%T' not found", Config{})
+		} else if len(cfg.Shards) == 0 {
+			return nil, errors.New("empty Shards")
+		}
+
+		if cfg.ReplicationFactor < 1 {
+			cfg.ReplicationFactor = 1
+		} else if cfg.ReplicationFactor > len(cfg.Shards) {
+			return nil, fmt.Errorf("replication factor %d exceeds the number of shards %d", cfg.ReplicationFactor, len(cfg.Shards))
+		}
+		if cfg.VirtualNodes < 1 {
+			cfg.VirtualNodes = 100
+		}
+
+		s := &shardedStore{
+			names:      make([]string, 0, len(cfg.Shards)),
+			stores:     make([]session.Store, 0, len(cfg.Shards)),
+			replicas:   cfg.ReplicationFactor,
+			moveOnRead: cfg.MoveOnRead,
+			onMove:     cfg.OnMove,
+		}
+		seen := make(map[string]struct{}, len(cfg.Shards))
+		for _, shard := range cfg.Shards {
+			if shard.Name == "" {
+				return nil, errors.New("empty shard name")
+			} else if _, ok := seen[shard.Name]; ok {
+				return nil, fmt.Errorf("duplicated shard name %q", shard.Name)
+			} else if shard.Initer == nil {
+				return nil, fmt.Errorf("empty Initer of shard %q", shard.Name)
+			}
+			seen[shard.Name] = struct{}{}
+
+			store, err := shard.Initer(ctx, shard.Config, idWriter)
+			if err != nil {
+				return nil, fmt.Errorf("init shard %q: %w", shard.Name, err)
+			}
+			s.names = append(s.names, shard.Name)
+			s.stores = append(s.stores, store)
+		}
+		s.ring = newRing(s.names, cfg.VirtualNodes)
+		return s, nil
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sharded
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/session"
+)
+
+func newFileShards(t *testing.T, names ...string) []Shard {
+	shards := make([]Shard, 0, len(names))
+	for _, name := range names {
+		shards = append(shards, Shard{
+			Name:   name,
+			Initer: session.FileIniter(),
+			Config: session.FileConfig{RootDir: t.TempDir()},
+		})
+	}
+	return shards
+}
+
+func newTestStore(t *testing.T, cfg Config) session.Store {
+	store, err := Initer()(context.Background(),
+		cfg,
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.NoError(t, err)
+	return store
+}
+
+func TestRing(t *testing.T) {
+	r := newRing([]string{"a", "b", "c"}, 100)
+
+	counts := make(map[int]int)
+	for i := 0; i < 3000; i++ {
+		owners := r.lookup(fmt.Sprintf("sid-%d", i), 2)
+		require.Len(t, owners, 2)
+		assert.NotEqual(t, owners[0], owners[1])
+		counts[owners[0]]++
+	}
+	for shard, n := range counts {
+		assert.InDelta(t, 1000, n, 300, "shard %d", shard)
+	}
+
+	// Adding a shard only moves sessions to the new shard
+	grown := newRing([]string{"a", "b", "c", "d"}, 100)
+	for i := 0; i < 1000; i++ {
+		sid := fmt.Sprintf("sid-%d", i)
+		before, after := r.lookup(sid, 1)[0], grown.lookup(sid, 1)[0]
+		if before != after {
+			assert.Equal(t, 3, after)
+		}
+	}
+}
+
+func TestShardedStore(t *testing.T) {
+	ctx := context.Background()
+	shards := newFileShards(t, "a", "b", "c")
+	store := newTestStore(t, Config{
+		Shards:            shards,
+		ReplicationFactor: 2,
+	})
+
+	sess, err := store.Read(ctx, "111")
+	require.NoError(t, err)
+	sess.Set("name", "flamego")
+	require.NoError(t, store.Save(ctx, sess))
+	assert.True(t, store.Exist(ctx, "111"))
+
+	// The session is replicated to two shards
+	s := store.(*shardedStore)
+	replicas := 0
+	for i := range s.stores {
+		if s.stores[i].Exist(ctx, "111") {
+			replicas++
+		}
+	}
+	assert.Equal(t, 2, replicas)
+
+	// The session is still available when the primary shard loses it
+	primary := s.owners("111")[0]
+	require.NoError(t, s.stores[primary].Destroy(ctx, "111"))
+	sess, err = store.Read(ctx, "111")
+	require.NoError(t, err)
+	assert.Equal(t, "flamego", sess.Get("name"))
+
+	sids, err := s.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"111"}, sids)
+
+	require.NoError(t, store.Destroy(ctx, "111"))
+	assert.False(t, store.Exist(ctx, "111"))
+}
+
+func TestShardedStore_ReadMany(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t, Config{
+		Shards:            newFileShards(t, "a", "b", "c"),
+		ReplicationFactor: 2,
+	})
+
+	var sids []string
+	for i := 0; i < 10; i++ {
+		sid := fmt.Sprintf("sid-%d", i)
+		sess, err := store.Read(ctx, sid)
+		require.NoError(t, err)
+		sess.Set("index", i)
+		require.NoError(t, store.Save(ctx, sess))
+		sids = append(sids, sid)
+	}
+
+	// Sessions missing in their primary shards are read from other owners
+	s := store.(*shardedStore)
+	require.NoError(t, s.stores[s.owners("sid-0")[0]].Destroy(ctx, "sid-0"))
+
+	sessions, err := s.ReadMany(ctx, append(sids, "nonexistent"))
+	require.NoError(t, err)
+	require.Len(t, sessions, len(sids))
+	for i, sid := range sids {
+		assert.Equal(t, i, sessions[sid].Get("index"))
+	}
+}
+
+func TestShardedStore_Rebalance(t *testing.T) {
+	ctx := context.Background()
+	shards := newFileShards(t, "a", "b")
+	store := newTestStore(t, Config{Shards: shards})
+
+	sids := make([]string, 0, 20)
+	for i := 0; i < 20; i++ {
+		sid := fmt.Sprintf("sid-%03d", i)
+		sess, err := store.Read(ctx, sid)
+		require.NoError(t, err)
+		sess.Set("i", i)
+		require.NoError(t, store.Save(ctx, sess))
+		sids = append(sids, sid)
+	}
+
+	// Add a shard and move sessions on read
+	var moves []string
+	grown := newTestStore(t, Config{
+		Shards:     append(shards, newFileShards(t, "c")...),
+		MoveOnRead: true,
+		OnMove: func(sid, from string, to []string) {
+			moves = append(moves, sid)
+			assert.Equal(t, []string{"c"}, to)
+		},
+	})
+	var owned []string
+	for _, sid := range sids {
+		if grown.(*shardedStore).owners(sid)[0] == 2 {
+			owned = append(owned, sid)
+		}
+	}
+	require.NotEmpty(t, owned, "no session is owned by the new shard")
+	require.True(t, grown.Exist(ctx, owned[0]))
+	sess, err := grown.Read(ctx, owned[0])
+	require.NoError(t, err)
+	assert.NotNil(t, sess.Get("i"))
+	assert.Equal(t, owned[:1], moves)
+
+	// Rebalance the rest
+	n, err := Rebalance(ctx, grown)
+	require.NoError(t, err)
+	assert.Equal(t, len(owned)-1, n)
+	n, err = Rebalance(ctx, grown)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	for _, sid := range sids {
+		sess, err := grown.Read(ctx, sid)
+		require.NoError(t, err)
+		assert.NotNil(t, sess.Get("i"), sid)
+	}
+}
+
+// ttlStore is a session store that expires sessions on its own.
+type ttlStore struct {
+	session.Store
+	gcs int
+}
+
+func (s *ttlStore) NeedsGC() bool {
+	return false
+}
+
+func (s *ttlStore) GC(context.Context) error {
+	s.gcs++
+	return nil
+}
+
+func TestShardedStore_NeedsGC(t *testing.T) {
+	ttl := &ttlStore{}
+	ttlShard := Shard{
+		Name: "ttl",
+		Initer: func(context.Context, ...interface{}) (session.Store, error) {
+			return ttl, nil
+		},
+	}
+
+	store := newTestStore(t, Config{Shards: []Shard{ttlShard}})
+	assert.False(t, store.(session.GCNeeder).NeedsGC())
+
+	store = newTestStore(t, Config{Shards: append(newFileShards(t, "file"), ttlShard)})
+	assert.True(t, store.(session.GCNeeder).NeedsGC())
+
+	// Shards that do not need GC are skipped
+	require.NoError(t, store.GC(context.Background()))
+	assert.Zero(t, ttl.gcs)
+}
+
+func TestIniter(t *testing.T) {
+	idWriter := session.IDWriter(func(http.ResponseWriter, *http.Request, string) {})
+	for _, cfg := range []Config{
+		{},
+		{Shards: []Shard{{Initer: session.FileIniter()}}},
+		{Shards: []Shard{{Name: "a"}}},
+		{Shards: newFileShards(t, "a", "a")},
+		{Shards: newFileShards(t, "a"), ReplicationFactor: 2},
+	} {
+		_, err := Initer()(context.Background(), cfg, idWriter)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SnapshotStorage is a storage of snapshots of the memory session store.
+type SnapshotStorage interface {
+	// ReadSnapshot returns the latest snapshot. It returns nil if there is no
+	// snapshot.
+	ReadSnapshot(ctx context.Context) ([]byte, error)
+	// WriteSnapshot replaces the latest snapshot with given one.
+	WriteSnapshot(ctx context.Context, snapshot []byte) error
+}
+
+var _ SnapshotStorage = FileSnapshotStorage("")
+
+// FileSnapshotStorage is a snapshot storage that stores the snapshot in the
+// file of the path.
+type FileSnapshotStorage string
+
+// ReadSnapshot implements `SnapshotStorage.ReadSnapshot`.
+func (path FileSnapshotStorage) ReadSnapshot(context.Context) ([]byte, error) {
+	snapshot, err := os.ReadFile(string(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	return snapshot, nil
+}
+
+// WriteSnapshot implements `SnapshotStorage.WriteSnapshot`. The file is
+// replaced atomically, thus a crash in the middle never leaves a partial
+// snapshot behind.
+func (path FileSnapshotStorage) WriteSnapshot(_ context.Context, snapshot []byte) error {
+	dir := filepath.Dir(string(path))
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(dir, filepath.Base(string(path))+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	_, err = f.Write(snapshot)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write: %w", err)
+	}
+	err = f.Sync()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("sync: %w", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	return os.Rename(f.Name(), string(path))
+}
+
+// MemoryPersistence contains options for persisting the memory session store
+// to survive restarts, e.g. redeployments.
+type MemoryPersistence struct {
+	// Storage is the storage to write snapshots of all sessions to and restore
+	// sessions from on startup, e.g. session.FileSnapshotStorage. Default is not
+	// set, i.e. the persistence is disabled.
+	Storage SnapshotStorage
+	// Interval is the minimum time interval of taking snapshots. Snapshots are
+	// taken during GC runs, thus no more often than Options.GCInterval. Default is
+	// 1 minute.
+	Interval time.Duration
+	// Encoder is the encoder to encode session data in snapshots. Default is
+	// session.GobEncoder.
+	Encoder Encoder
+	// Decoder is the decoder to decode session data in snapshots. Default is
+	// session.GobDecoder.
+	Decoder Decoder
+}
+
+// Snapshotter is a session store that is capable of taking snapshots of its
+// sessions on demand, e.g. upon graceful shutdown.
+type Snapshotter interface {
+	// Snapshot takes a snapshot of all sessions and writes it to the storage.
+	Snapshot(ctx context.Context) error
+}
+
+// snapshotEntry is a session in a snapshot.
+type snapshotEntry struct {
+	ID             string
+	LastAccessedAt time.Time
+	Data           []byte
+	Tags           map[string]string
+}
+
+// memoryPersister takes and restores snapshots of the memory session store.
+type memoryPersister struct {
+	storage  SnapshotStorage
+	interval time.Duration
+	encoder  Encoder
+	decoder  Decoder
+	nowFunc  func() time.Time // The function to return the current time
+
+	lock    sync.Mutex // The mutex to guard accesses to the takenAt
+	takenAt time.Time  // The last time of taking a snapshot
+}
+
+// newMemoryPersister returns a new persister based on given options and clock,
+// or nil if the persistence is disabled.
+func newMemoryPersister(opts MemoryPersistence, nowFunc func() time.Time) *memoryPersister {
+	if opts.Storage == nil {
+		return nil
+	}
+
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	if opts.Encoder == nil {
+		opts.Encoder = GobEncoder
+	}
+	if opts.Decoder == nil {
+		opts.Decoder = GobDecoder
+	}
+	return &memoryPersister{
+		storage:  opts.Storage,
+		interval: opts.Interval,
+		encoder:  opts.Encoder,
+		decoder:  opts.Decoder,
+		nowFunc:  nowFunc,
+		takenAt:  nowFunc(),
+	}
+}
+
+// snapshot takes a snapshot of given memory stores and writes it to the
+// storage. Unless `force` is true, it does nothing if the last snapshot was
+// taken within the interval.
+func (p *memoryPersister) snapshot(ctx context.Context, shards []*memoryStore, force bool) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := p.nowFunc()
+	if !force && now.Sub(p.takenAt) < p.interval {
+		return nil
+	}
+	p.takenAt = now
+
+	var entries []snapshotEntry
+	for _, shard := range shards {
+		shardEntries, err := shard.snapshot(p.encoder)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, shardEntries...)
+	}
+
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(entries)
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+
+	err = p.storage.WriteSnapshot(ctx, buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	return nil
+}
+
+// restore reads the latest snapshot from the storage and restores sessions
+// that have not expired to the memory store chosen by the `shard`.
+func (p *memoryPersister) restore(ctx context.Context, shard func(sid string) *memoryStore) error {
+	snapshot, err := p.storage.ReadSnapshot(ctx)
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	} else if snapshot == nil {
+		return nil
+	}
+
+	var entries []snapshotEntry
+	err = gob.NewDecoder(bytes.NewReader(snapshot)).Decode(&entries)
+	if err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+
+	for _, e := range entries {
+		data, err := p.decoder(e.Data)
+		if err != nil {
+			return fmt.Errorf("decode session %q: %w", e.ID, err)
+		}
+		shard(e.ID).restore(e, data)
+	}
+	return nil
+}
+
+// snapshot returns all sessions that have not expired as snapshot entries.
+func (s *memoryStore) snapshot(encoder Encoder) ([]snapshotEntry, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	now := s.nowFunc()
+	entries := make([]snapshotEntry, 0, len(s.index))
+	for sid, sess := range s.index {
+		lastAccessedAt := sess.accessedAt()
+		if !now.Before(lastAccessedAt.Add(s.lifetime)) {
+			continue
+		}
+
+		data, err := encoder(sess.Data())
+		if err != nil {
+			return nil, fmt.Errorf("encode session %q: %w", sid, err)
+		}
+		entries = append(entries, snapshotEntry{
+			ID:             sid,
+			LastAccessedAt: lastAccessedAt,
+			Data:           data,
+			Tags:           sess.Tags(),
+		})
+	}
+	return entries, nil
+}
+
+// restore adds the session in the snapshot entry with given data unless it has
+// expired or already exists.
+func (s *memoryStore) restore(e snapshotEntry, data Data) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.index[e.ID]; ok || !s.nowFunc().Before(e.LastAccessedAt.Add(s.lifetime)) {
+		return
+	}
+
+	sess := newMemorySession(e.ID, s.idWriter)
+	sess.rekeyer = s.rekeyer
+	sess.data = data
+	sess.LoadTags(e.Tags)
+	sess.setAccessedAt(e.LastAccessedAt)
+	s.add(sess)
+}
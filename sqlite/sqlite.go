@@ -7,15 +7,24 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/pkg/errors"
 	_ "modernc.org/sqlite"
 
 	"github.com/flamego/session"
 )
 
+// timeFormat is the format of expired_at in UTC, which has fixed-width
+// nanoseconds for times to be compared exactly as strings. Times in the legacy
+// format without fractional seconds (i.e. time.DateTime) are padded by
+// InitTable, both formats are parsed by time.Parse with time.DateTime.
+const timeFormat = "2006-01-02 15:04:05.000000000"
+
 var _ session.Store = (*sqliteStore)(nil)
 
 // sqliteStore is a SQLite implementation of the session store.
@@ -24,6 +33,8 @@ type sqliteStore struct {
 	lifetime time.Duration    // The duration to have access to a session before being recycled
 	db       *sql.DB          // The database connection
 	table    string           // The database table for storing session data
+	tags     bool             // Whether to persist session tags
+	counters bool             // Whether to maintain session counters
 
 	encoder  session.Encoder
 	decoder  session.Decoder
@@ -34,10 +45,12 @@ type sqliteStore struct {
 // configuration.
 func newSQLiteStore(cfg Config, idWriter session.IDWriter) *sqliteStore {
 	return &sqliteStore{
-		nowFunc:  cfg.nowFunc,
+		nowFunc:  cfg.NowFunc,
 		lifetime: cfg.Lifetime,
 		db:       cfg.db,
 		table:    cfg.Table,
+		tags:     cfg.EnableTags,
+		counters: cfg.EnableCounters,
 		encoder:  cfg.Encoder,
 		decoder:  cfg.Decoder,
 		idWriter: idWriter,
@@ -45,47 +58,138 @@ func newSQLiteStore(cfg Config, idWriter session.IDWriter) *sqliteStore {
 }
 
 func (s *sqliteStore) Exist(ctx context.Context, sid string) bool {
+	ok, _ := s.CheckExist(ctx, sid)
+	return ok
+}
+
+var _ session.ExistChecker = (*sqliteStore)(nil)
+
+func (s *sqliteStore) CheckExist(ctx context.Context, sid string) (bool, error) {
 	var exists bool
 	q := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %q WHERE key = $1)`, s.table)
 	err := s.db.QueryRowContext(ctx, q, sid).Scan(&exists)
-	return err == nil && exists
+	if err != nil {
+		return false, fmt.Errorf("query: %w", err)
+	}
+	return exists, nil
 }
 
 func (s *sqliteStore) Read(ctx context.Context, sid string) (session.Session, error) {
 	var binary []byte
 	var expiredAtStr string
-	q := fmt.Sprintf(`SELECT data, expired_at FROM %q WHERE key = $1`, s.table)
-	err := s.db.QueryRowContext(ctx, q, sid).Scan(&binary, &expiredAtStr)
+	var tags sql.NullString
+	columns := "data, expired_at"
+	dest := []interface{}{&binary, &expiredAtStr}
+	if s.tags {
+		columns += ", tags"
+		dest = append(dest, &tags)
+	}
+	q := fmt.Sprintf(`SELECT %s FROM %q WHERE key = $1`, columns, s.table)
+	err := s.db.QueryRowContext(ctx, q, sid).Scan(dest...)
 	if err == nil {
 		expiredAt, _ := time.Parse(time.DateTime, expiredAtStr)
 		// Discard existing data if it's expired
-		if !s.nowFunc().Before(expiredAt.Add(s.lifetime)) {
+		if !s.nowFunc().Before(expiredAt) {
 			return session.NewBaseSession(sid, s.encoder, s.idWriter), nil
 		}
 
-		data, err := s.decoder(binary)
+		return s.decode(sid, binary, tags.String)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+
+	return session.NewBaseSession(sid, s.encoder, s.idWriter), nil
+}
+
+// decode returns the session with given ID of the encoded session data and the
+// tags in JSON.
+func (s *sqliteStore) decode(sid string, binary []byte, tags string) (session.Session, error) {
+	data, err := s.decoder(binary)
+	if err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	sess := session.NewBaseSessionWithData(sid, s.encoder, s.idWriter, data)
+	if tags != "" {
+		var m map[string]string
+		err = json.Unmarshal([]byte(tags), &m)
 		if err != nil {
-			return nil, errors.Wrap(err, "decode")
+			return nil, fmt.Errorf("unmarshal tags: %w", err)
 		}
-		return session.NewBaseSessionWithData(sid, s.encoder, s.idWriter, data), nil
-	} else if err != sql.ErrNoRows {
-		return nil, errors.Wrap(err, "select")
+		sess.LoadTags(m)
 	}
+	return sess, nil
+}
 
-	return session.NewBaseSession(sid, s.encoder, s.idWriter), nil
+var _ session.MultiReader = (*sqliteStore)(nil)
+
+// ReadMany reads unexpired sessions with a single query.
+func (s *sqliteStore) ReadMany(ctx context.Context, sids []string) (map[string]session.Session, error) {
+	sessions := make(map[string]session.Session, len(sids))
+	if len(sids) == 0 {
+		return sessions, nil
+	}
+
+	columns := "key, data"
+	if s.tags {
+		columns += ", tags"
+	}
+	args := []interface{}{s.nowFunc().UTC().Format(timeFormat)}
+	placeholders := make([]string, len(sids))
+	for i := range sids {
+		args = append(args, sids[i])
+		placeholders[i] = "$" + strconv.Itoa(i+2)
+	}
+	q := fmt.Sprintf(
+		`SELECT %s FROM %q WHERE expired_at > $1 AND key IN (%s)`,
+		columns, s.table, strings.Join(placeholders, ", "),
+	)
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var sid string
+		var binary []byte
+		var tags sql.NullString
+		dest := []interface{}{&sid, &binary}
+		if s.tags {
+			dest = append(dest, &tags)
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		sessions[sid], err = s.decode(sid, binary, tags.String)
+		if err != nil {
+			return nil, fmt.Errorf("session %q: %w", sid, err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate: %w", err)
+	}
+	return sessions, nil
 }
 
 func (s *sqliteStore) Destroy(ctx context.Context, sid string) error {
 	q := fmt.Sprintf(`DELETE FROM %q WHERE key = $1`, s.table)
 	_, err := s.db.ExecContext(ctx, q, sid)
+	if err != nil || !s.counters {
+		return err
+	}
+
+	q = fmt.Sprintf(`DELETE FROM %q WHERE key = $1`, s.countersTable())
+	_, err = s.db.ExecContext(ctx, q, sid)
 	return err
 }
 
 func (s *sqliteStore) Touch(ctx context.Context, sid string) error {
 	q := fmt.Sprintf(`UPDATE %q SET expired_at = $1 WHERE key = $2`, s.table)
-	_, err := s.db.ExecContext(ctx, q, s.nowFunc().Add(s.lifetime).UTC().Format(time.DateTime), sid)
+	_, err := s.db.ExecContext(ctx, q, s.nowFunc().Add(s.lifetime).UTC().Format(timeFormat), sid)
 	if err != nil {
-		return errors.Wrap(err, "update")
+		return fmt.Errorf("update: %w", err)
 	}
 	return nil
 }
@@ -93,7 +197,29 @@ func (s *sqliteStore) Touch(ctx context.Context, sid string) error {
 func (s *sqliteStore) Save(ctx context.Context, sess session.Session) error {
 	binary, err := sess.Encode()
 	if err != nil {
-		return errors.Wrap(err, "encode")
+		return fmt.Errorf("encode: %w", err)
+	}
+
+	if s.tags {
+		tags, err := json.Marshal(session.TagsOf(sess))
+		if err != nil {
+			return fmt.Errorf("marshal tags: %w", err)
+		}
+
+		q := fmt.Sprintf(`
+INSERT INTO %q (key, data, expired_at, tags)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (key)
+DO UPDATE SET
+	data       = excluded.data,
+	expired_at = excluded.expired_at,
+	tags       = excluded.tags
+`, s.table)
+		_, err = s.db.ExecContext(ctx, q, sess.ID(), binary, s.nowFunc().Add(s.lifetime).UTC().Format(timeFormat), string(tags))
+		if err != nil {
+			return fmt.Errorf("upsert: %w", err)
+		}
+		return nil
 	}
 
 	q := fmt.Sprintf(`
@@ -104,28 +230,254 @@ DO UPDATE SET
 	data       = excluded.data,
 	expired_at = excluded.expired_at
 `, s.table)
-	_, err = s.db.ExecContext(ctx, q, sess.ID(), binary, s.nowFunc().Add(s.lifetime).UTC().Format(time.DateTime))
+	_, err = s.db.ExecContext(ctx, q, sess.ID(), binary, s.nowFunc().Add(s.lifetime).UTC().Format(timeFormat))
 	if err != nil {
-		return errors.Wrap(err, "upsert")
+		return fmt.Errorf("upsert: %w", err)
 	}
 	return nil
 }
 
 func (s *sqliteStore) GC(ctx context.Context) error {
-	q := fmt.Sprintf(`DELETE FROM %q WHERE datetime(expired_at) <= datetime($1)`, s.table)
-	_, err := s.db.ExecContext(ctx, q, s.nowFunc().UTC().Format(time.DateTime))
+	return s.GCWithArchive(ctx, 0, nil)
+}
+
+var _ session.ExpiryArchiver = (*sqliteStore)(nil)
+
+// GCWithArchive implements `session.ExpiryArchiver.GCWithArchive`. Expired
+// sessions whose data cannot be decoded are recycled without calling the
+// onExpire.
+func (s *sqliteStore) GCWithArchive(ctx context.Context, batchSize int, onExpire session.OnExpireFunc) error {
+	now := s.nowFunc().UTC().Format(timeFormat)
+	if onExpire != nil {
+		if batchSize < 1 {
+			batchSize = 1
+		}
+		for {
+			n, err := s.archiveExpired(ctx, now, batchSize, onExpire)
+			if err != nil {
+				return err
+			} else if n < batchSize {
+				break
+			}
+		}
+	}
+
+	q := fmt.Sprintf(`DELETE FROM %q WHERE expired_at <= $1`, s.table)
+	_, err := s.db.ExecContext(ctx, q, now)
+	if err != nil || !s.counters {
+		return err
+	}
+
+	// Recycle counters of sessions that no longer exist
+	q = fmt.Sprintf(`DELETE FROM %q WHERE key NOT IN (SELECT key FROM %q)`, s.countersTable(), s.table)
+	_, err = s.db.ExecContext(ctx, q)
 	return err
 }
 
+// archiveExpired calls the onExpire with a batch of expired sessions and deletes
+// them. It returns the number of sessions in the batch.
+func (s *sqliteStore) archiveExpired(ctx context.Context, now string, batchSize int, onExpire session.OnExpireFunc) (int, error) {
+	q := fmt.Sprintf(`SELECT key, data FROM %q WHERE expired_at <= $1 LIMIT $2`, s.table)
+	rows, err := s.db.QueryContext(ctx, q, now, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("select: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var sids []string
+	var binaries [][]byte
+	for rows.Next() {
+		var sid string
+		var binary []byte
+		err = rows.Scan(&sid, &binary)
+		if err != nil {
+			return 0, fmt.Errorf("scan: %w", err)
+		}
+		sids = append(sids, sid)
+		binaries = append(binaries, binary)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate: %w", err)
+	}
+	_ = rows.Close()
+	if len(sids) == 0 {
+		return 0, nil
+	}
+
+	for i := range sids {
+		data, err := s.decoder(binaries[i])
+		if err == nil {
+			onExpire(ctx, sids[i], data)
+		}
+	}
+
+	args := []interface{}{now}
+	placeholders := make([]string, len(sids))
+	for i := range sids {
+		args = append(args, sids[i])
+		placeholders[i] = "$" + strconv.Itoa(i+2)
+	}
+	q = fmt.Sprintf(
+		`DELETE FROM %q WHERE expired_at <= $1 AND key IN (%s)`,
+		s.table, strings.Join(placeholders, ", "),
+	)
+	_, err = s.db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return 0, fmt.Errorf("delete: %w", err)
+	}
+	return len(sids), nil
+}
+
+// countersTable returns the name of the table for storing session counters.
+func (s *sqliteStore) countersTable() string {
+	return s.table + "_counters"
+}
+
+var _ session.Incrementer = (*sqliteStore)(nil)
+
+// Incr increments the counter with an upsert, which is atomic in the database.
+// It requires Config.EnableCounters to be set.
+func (s *sqliteStore) Incr(ctx context.Context, sid, key string, delta int64) (int64, error) {
+	if !s.counters {
+		return 0, errors.New("counters are not enabled")
+	}
+
+	q := fmt.Sprintf(`
+INSERT INTO %q (key, name, value)
+VALUES ($1, $2, $3)
+ON CONFLICT (key, name)
+DO UPDATE SET value = %q.value + excluded.value
+RETURNING value
+`, s.countersTable(), s.countersTable())
+	var n int64
+	err := s.db.QueryRowContext(ctx, q, sid, key, delta).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("upsert: %w", err)
+	}
+	return n, nil
+}
+
+var _ session.EncoderReporter = (*sqliteStore)(nil)
+
+func (s *sqliteStore) Encoder() session.Encoder {
+	return s.encoder
+}
+
+var _ session.Expirer = (*sqliteStore)(nil)
+
+func (s *sqliteStore) ExpiresAt(ctx context.Context, sid string) (time.Time, error) {
+	var expiredAtStr string
+	q := fmt.Sprintf(`SELECT expired_at FROM %q WHERE key = $1`, s.table)
+	err := s.db.QueryRowContext(ctx, q, sid).Scan(&expiredAtStr)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("select: %w", err)
+	}
+
+	expiredAt, err := time.Parse(time.DateTime, expiredAtStr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse time: %w", err)
+	}
+	return expiredAt, nil
+}
+
+var _ session.TagFinder = (*sqliteStore)(nil)
+
+// FindByTag returns IDs of sessions that have the tag of given key with the
+// value. It requires Config.EnableTags to be set.
+func (s *sqliteStore) FindByTag(ctx context.Context, key, value string) ([]string, error) {
+	if !s.tags {
+		return nil, errors.New("tags are not enabled")
+	}
+
+	q := fmt.Sprintf(`SELECT key FROM %q WHERE json_extract(tags, $1) = $2`, s.table)
+	rows, err := s.db.QueryContext(ctx, q, jsonPath(key), value)
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var sids []string
+	for rows.Next() {
+		var sid string
+		err = rows.Scan(&sid)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		sids = append(sids, sid)
+	}
+	return sids, rows.Err()
+}
+
+// jsonPath returns the JSON path to the member of given key of the top-level
+// object.
+func jsonPath(key string) string {
+	p, _ := json.Marshal(key)
+	return "$." + string(p)
+}
+
+var _ session.Lister = (*sqliteStore)(nil)
+
+func (s *sqliteStore) List(ctx context.Context) ([]string, error) {
+	q := fmt.Sprintf(`SELECT key FROM %q`, s.table)
+	rows, err := s.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var sids []string
+	for rows.Next() {
+		var sid string
+		err = rows.Scan(&sid)
+		if err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		sids = append(sids, sid)
+	}
+	return sids, rows.Err()
+}
+
+var _ session.ActiveCounter = (*sqliteStore)(nil)
+
+func (s *sqliteStore) CountActive(ctx context.Context) (int, error) {
+	q := fmt.Sprintf(`SELECT COUNT(*) FROM %q`, s.table)
+	var n int
+	err := s.db.QueryRowContext(ctx, q).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("select: %w", err)
+	}
+	return n, nil
+}
+
+var _ session.CapabilityReporter = (*sqliteStore)(nil)
+
+// Capabilities reports tags and counters only when they are enabled.
+func (s *sqliteStore) Capabilities() session.StoreCapabilities {
+	return session.StoreCapabilities{
+		List:        true,
+		ExpiresAt:   true,
+		FindByTag:   s.tags,
+		Incr:        s.counters,
+		ArchiveOnGC: true,
+		CountActive: true,
+	}
+}
+
 // Config contains options for the SQLite session store.
 type Config struct {
 	// For tests only
-	nowFunc func() time.Time
-	db      *sql.DB
+	db *sql.DB
 
 	// Lifetime is the duration to have no access to a session before being
 	// recycled. Default is 3600 seconds.
 	Lifetime time.Duration
+	// NowFunc is the function to return the current time for expiry of sessions,
+	// e.g. to simulate the passage of time in integration tests. Default is
+	// time.Now.
+	NowFunc func() time.Time
 	// DSN is the database source name to the SQLite.
 	DSN string
 	// Table is the table name for storing session data. Default is "sessions".
@@ -136,6 +488,46 @@ type Config struct {
 	Decoder session.Decoder
 	// InitTable indicates whether to create a default session table when not exists automatically.
 	InitTable bool
+	// EnableTags indicates whether to persist session tags to the "tags" column
+	// as JSON text, which enables finding sessions by tags. The column is created
+	// by InitTable.
+	EnableTags bool
+	// EnableCounters indicates whether to maintain session counters in the table
+	// with the name of Table suffixed by "_counters", which makes session.Incr
+	// atomic across instances. The table is created by InitTable.
+	EnableCounters bool
+	// MaxOpenConns is the maximum number of open connections to the database,
+	// which only applies when the database is opened by the store (i.e. via DSN).
+	// Default is unlimited.
+	MaxOpenConns int
+	// MaxIdleConns is the maximum number of idle connections to the database,
+	// which only applies when the database is opened by the store. Negative value
+	// means no idle connections are retained. Default is 2.
+	MaxIdleConns int
+	// ConnMaxLifetime is the maximum duration a connection may be reused, which
+	// only applies when the database is opened by the store. Default is unlimited.
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime is the maximum duration a connection may be idle before
+	// being closed, which only applies when the database is opened by the store.
+	// Default is unlimited.
+	ConnMaxIdleTime time.Duration
+}
+
+// setPool applies the connection pool settings to the database opened by the
+// store.
+func (cfg *Config) setPool(db *sql.DB) {
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
 }
 
 // Initer returns the session.Initer for the SQLite session store.
@@ -164,8 +556,9 @@ func Initer() session.Initer {
 		if cfg.db == nil {
 			db, err := sql.Open("sqlite", cfg.DSN)
 			if err != nil {
-				return nil, errors.Wrap(err, "open database")
+				return nil, fmt.Errorf("open database: %w", err)
 			}
+			cfg.setPool(db)
 			cfg.db = db
 		}
 
@@ -174,16 +567,54 @@ func Initer() session.Initer {
 CREATE TABLE IF NOT EXISTS sessions (
 	key        TEXT PRIMARY KEY,
 	data       BLOB NOT NULL,
-	expired_at TEXT NOT NULL
+	expired_at TEXT NOT NULL,
+	tags       TEXT
 )`
 			_, err := cfg.db.ExecContext(ctx, q)
 			if err != nil {
-				return nil, errors.Wrap(err, "create table")
+				return nil, fmt.Errorf("create table: %w", err)
+			}
+
+			// Pad times in the legacy format with fractional seconds for exact
+			// comparisons as strings, see timeFormat.
+			q = `UPDATE sessions SET expired_at = expired_at || '.000000000' WHERE length(expired_at) = 19`
+			_, err = cfg.db.ExecContext(ctx, q)
+			if err != nil {
+				return nil, fmt.Errorf("migrate expired_at: %w", err)
+			}
+
+			if cfg.EnableTags {
+				var exists bool
+				q = `SELECT EXISTS (SELECT 1 FROM pragma_table_info('sessions') WHERE name = 'tags')`
+				err = cfg.db.QueryRowContext(ctx, q).Scan(&exists)
+				if err != nil {
+					return nil, fmt.Errorf("check tags column: %w", err)
+				}
+				if !exists {
+					_, err = cfg.db.ExecContext(ctx, `ALTER TABLE sessions ADD COLUMN tags TEXT`)
+					if err != nil {
+						return nil, fmt.Errorf("add tags column: %w", err)
+					}
+				}
+			}
+
+			if cfg.EnableCounters {
+				q = `
+CREATE TABLE IF NOT EXISTS sessions_counters (
+	key   TEXT NOT NULL,
+	name  TEXT NOT NULL,
+	value INTEGER NOT NULL,
+	PRIMARY KEY (key, name)
+)`
+				_, err = cfg.db.ExecContext(ctx, q)
+				if err != nil {
+					return nil, fmt.Errorf("create counters table: %w", err)
+				}
 			}
 		}
 
-		if cfg.nowFunc == nil {
-			cfg.nowFunc = time.Now
+		if cfg.NowFunc == nil {
+			cfg.NowFunc = time.Now
 		}
 		if cfg.Lifetime.Seconds() < 1 {
 			cfg.Lifetime = 3600 * time.Second
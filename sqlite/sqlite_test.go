@@ -13,6 +13,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -21,6 +22,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/flamego/session"
+	"github.com/flamego/session/storetest"
 )
 
 func newTestDB(t *testing.T, ctx context.Context) (testDB *sql.DB, cleanup func() error) {
@@ -72,7 +74,7 @@ func TestSQLiteStore(t *testing.T) {
 		session.Options{
 			Initer: Initer(),
 			Config: Config{
-				nowFunc:   time.Now,
+				NowFunc:   time.Now,
 				db:        db,
 				InitTable: true,
 			},
@@ -139,7 +141,7 @@ func TestSQLiteStore_GC(t *testing.T) {
 	now := time.Now()
 	store, err := Initer()(ctx,
 		Config{
-			nowFunc:   func() time.Time { return now },
+			NowFunc:   func() time.Time { return now },
 			db:        db,
 			Lifetime:  time.Second,
 			InitTable: true,
@@ -184,6 +186,67 @@ func TestSQLiteStore_GC(t *testing.T) {
 	assert.False(t, store.Exist(ctx, "3"))
 }
 
+func TestSQLiteStore_GCWithArchive(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := newTestDB(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	now := time.Now()
+	store, err := Initer()(ctx,
+		Config{
+			NowFunc:   func() time.Time { return now },
+			db:        db,
+			Lifetime:  time.Second,
+			InitTable: true,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	for _, sid := range []string{"1", "2", "3"} {
+		sess, err := store.Read(ctx, sid)
+		require.Nil(t, err)
+		sess.Set("cart", "cart of "+sid)
+		err = store.Save(ctx, sess)
+		require.Nil(t, err)
+	}
+	now = now.Add(3 * time.Second)
+	alive, err := store.Read(ctx, "4")
+	require.Nil(t, err)
+	err = store.Save(ctx, alive)
+	require.Nil(t, err)
+
+	caps := session.Capabilities(store)
+	assert.True(t, caps.ArchiveOnGC)
+	assert.True(t, caps.CountActive)
+	assert.False(t, caps.FindByTag, "tags are not enabled")
+
+	// Expired sessions are counted until recycled
+	n, err := store.(session.ActiveCounter).CountActive(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 4, n)
+
+	archived := make(map[string]interface{})
+	err = store.(session.ExpiryArchiver).GCWithArchive(ctx, 2, func(_ context.Context, sid string, data session.Data) {
+		archived[sid] = data["cart"]
+	})
+	require.Nil(t, err)
+	assert.Equal(t,
+		map[string]interface{}{
+			"1": "cart of 1",
+			"2": "cart of 2",
+			"3": "cart of 3",
+		},
+		archived,
+	)
+
+	assert.False(t, store.Exist(ctx, "1"))
+	assert.False(t, store.Exist(ctx, "3"))
+	assert.True(t, store.Exist(ctx, "4"))
+}
+
 func TestSQLiteStore_Touch(t *testing.T) {
 	ctx := context.Background()
 	db, cleanup := newTestDB(t, ctx)
@@ -194,7 +257,7 @@ func TestSQLiteStore_Touch(t *testing.T) {
 	now := time.Now()
 	store, err := Initer()(ctx,
 		Config{
-			nowFunc:   func() time.Time { return now },
+			NowFunc:   func() time.Time { return now },
 			db:        db,
 			Lifetime:  time.Second,
 			InitTable: true,
@@ -217,3 +280,175 @@ func TestSQLiteStore_Touch(t *testing.T) {
 	require.Nil(t, err)
 	assert.True(t, store.Exist(ctx, sess.ID()))
 }
+
+func TestSQLiteStore_ConnectionPool(t *testing.T) {
+	ctx := context.Background()
+	store, err := Initer()(ctx,
+		Config{
+			DSN:          filepath.Join(t.TempDir(), "sessions.db"),
+			InitTable:    true,
+			MaxOpenConns: 3,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	db := store.(*sqliteStore).db
+	t.Cleanup(func() { _ = db.Close() })
+	assert.Equal(t, 3, db.Stats().MaxOpenConnections)
+}
+
+func TestSQLiteStore_SubSecondExpiry(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := newTestDB(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, int(900*time.Millisecond), time.UTC)
+	store, err := Initer()(ctx,
+		Config{
+			NowFunc:   func() time.Time { return now },
+			db:        db,
+			Lifetime:  time.Second,
+			InitTable: true,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	sess, err := store.Read(ctx, "1")
+	require.Nil(t, err)
+	err = store.Save(ctx, sess)
+	require.Nil(t, err)
+
+	expiresAt, err := store.(session.Expirer).ExpiresAt(ctx, sess.ID())
+	require.Nil(t, err)
+	assert.Equal(t, now.Add(time.Second), expiresAt)
+
+	// The session should survive the GC within the same second of its expiry
+	now = now.Add(500 * time.Millisecond)
+	err = store.GC(ctx)
+	require.Nil(t, err)
+	assert.True(t, store.Exist(ctx, sess.ID()))
+
+	now = now.Add(500 * time.Millisecond)
+	err = store.GC(ctx)
+	require.Nil(t, err)
+	assert.False(t, store.Exist(ctx, sess.ID()))
+
+	// Times in the legacy format should be migrated by InitTable
+	_, err = db.ExecContext(ctx, `INSERT INTO sessions (key, data, expired_at) VALUES ('2', x'', '2023-01-01 00:00:05')`)
+	require.Nil(t, err)
+	_, err = Initer()(ctx,
+		Config{
+			db:        db,
+			InitTable: true,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	var expiredAt string
+	err = db.QueryRowContext(ctx, `SELECT expired_at FROM sessions WHERE key = '2'`).Scan(&expiredAt)
+	require.Nil(t, err)
+	assert.Equal(t, "2023-01-01 00:00:05.000000000", expiredAt)
+}
+
+func TestSQLiteStore_FindByTag(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := newTestDB(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	store, err := Initer()(ctx,
+		Config{
+			NowFunc:    time.Now,
+			db:         db,
+			InitTable:  true,
+			EnableTags: true,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	for sid, device := range map[string]string{"1": "mobile", "2": "desktop", "3": "mobile"} {
+		sess, err := store.Read(ctx, sid)
+		require.Nil(t, err)
+		session.Tag(sess, "device", device)
+		err = store.Save(ctx, sess)
+		require.Nil(t, err)
+	}
+
+	sess, err := store.Read(ctx, "1")
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"device": "mobile"}, session.TagsOf(sess))
+
+	sids, err := store.(session.TagFinder).FindByTag(ctx, "device", "mobile")
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []string{"1", "3"}, sids)
+}
+
+func TestSQLiteStore_Incr(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := newTestDB(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+
+	store, err := Initer()(ctx,
+		Config{
+			NowFunc:        time.Now,
+			db:             db,
+			InitTable:      true,
+			EnableCounters: true,
+		},
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.Nil(t, err)
+
+	// SQLite only allows one writer at a time
+	db.SetMaxOpenConns(1)
+
+	inc := store.(session.Incrementer)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := inc.Incr(ctx, "1", "requests", 2)
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	n, err := inc.Incr(ctx, "1", "requests", 0)
+	require.Nil(t, err)
+	assert.Equal(t, int64(20), n)
+
+	// Counters should be destroyed along with the session
+	err = store.Destroy(ctx, "1")
+	require.Nil(t, err)
+	n, err = inc.Incr(ctx, "1", "requests", 0)
+	require.Nil(t, err)
+	assert.Equal(t, int64(0), n)
+}
+
+func TestSQLiteStore_Conformance(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := newTestDB(t, ctx)
+	t.Cleanup(func() {
+		assert.Nil(t, cleanup())
+	})
+	db.SetMaxOpenConns(1)
+
+	storetest.Conformance(t, Initer(), Config{
+		NowFunc:        time.Now,
+		db:             db,
+		Lifetime:       time.Second,
+		InitTable:      true,
+		EnableTags:     true,
+		EnableCounters: true,
+	})
+}
@@ -0,0 +1,311 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package storetest provides a conformance test suite for session store
+// implementations, which verifies the behaviors that the session.Sessioner
+// middleware expects from session stores.
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/session"
+)
+
+// maxExpiryLifetime is the maximum lifetime of sessions for the expiry to be
+// tested, which is waited for.
+const maxExpiryLifetime = 3 * time.Second
+
+// sequence is the sequence to generate unique session IDs across runs.
+var sequence atomic.Int64
+
+// newSID returns a new unique session ID that is valid for all session stores.
+func newSID() string {
+	return fmt.Sprintf("%016x", time.Now().UnixNano()+sequence.Add(1))
+}
+
+// Conformance runs the conformance test suite against the session store
+// initialized by the initer with given configuration. Optional capabilities
+// (session.Lister, session.Expirer, session.TagFinder, session.Incrementer and
+// session.MultiReader) are tested when implemented by the session store, and
+// counters only when reported by session.Capabilities.
+//
+// The expiry of sessions is only tested when the configuration has a
+// `Lifetime` field of at most 3 seconds, because the test has to wait for
+// sessions to expire.
+//
+// Example:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Conformance(t, mystore.Initer(), mystore.Config{Lifetime: time.Second})
+//	}
+func Conformance(t *testing.T, initer session.Initer, config interface{}) {
+	ctx := context.Background()
+	idWriter := session.IDWriter(func(http.ResponseWriter, *http.Request, string) {})
+	store, err := initer(ctx, config, idWriter)
+	require.NoError(t, err, "initialize the session store")
+
+	t.Run("Read a new session", func(t *testing.T) {
+		sid := newSID()
+		sess, err := store.Read(ctx, sid)
+		require.NoError(t, err)
+		assert.Equal(t, sid, sess.ID(), "session ID of a new session")
+		assert.Nil(t, sess.Get("username"), "data of a new session")
+	})
+
+	t.Run("Save and read", func(t *testing.T) {
+		sid := newSID()
+		sess, err := store.Read(ctx, sid)
+		require.NoError(t, err)
+		sess.Set("username", "flamego")
+		require.NoError(t, store.Save(ctx, sess))
+		assert.True(t, store.Exist(ctx, sid), "saved session exists")
+
+		sess, err = store.Read(ctx, sid)
+		require.NoError(t, err)
+		assert.Equal(t, "flamego", sess.Get("username"), "data of the saved session")
+
+		// Saving again overwrites the data
+		sess.Delete("username")
+		sess.Set("age", 18)
+		require.NoError(t, store.Save(ctx, sess))
+		sess, err = store.Read(ctx, sid)
+		require.NoError(t, err)
+		assert.Nil(t, sess.Get("username"), "deleted data of the saved session")
+		assert.Equal(t, 18, sess.Get("age"), "data of the saved session")
+	})
+
+	t.Run("Destroy", func(t *testing.T) {
+		sid := newSID()
+		sess, err := store.Read(ctx, sid)
+		require.NoError(t, err)
+		sess.Set("username", "flamego")
+		require.NoError(t, store.Save(ctx, sess))
+
+		require.NoError(t, store.Destroy(ctx, sid))
+		assert.False(t, store.Exist(ctx, sid), "destroyed session exists")
+
+		sess, err = store.Read(ctx, sid)
+		require.NoError(t, err)
+		assert.Nil(t, sess.Get("username"), "data of the destroyed session")
+
+		assert.NoError(t, store.Destroy(ctx, newSID()), "destroy a nonexistent session")
+	})
+
+	t.Run("Touch a nonexistent session", func(t *testing.T) {
+		sid := newSID()
+		require.NoError(t, store.Touch(ctx, sid))
+		assert.False(t, store.Exist(ctx, sid), "touching a nonexistent session creates it")
+	})
+
+	t.Run("GC", func(t *testing.T) {
+		sid := newSID()
+		sess, err := store.Read(ctx, sid)
+		require.NoError(t, err)
+		sess.Set("username", "flamego")
+		require.NoError(t, store.Save(ctx, sess))
+
+		require.NoError(t, store.GC(ctx))
+		assert.True(t, store.Exist(ctx, sid), "GC recycles a session that is not expired")
+	})
+
+	t.Run("Canceled context", func(t *testing.T) {
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		// Session stores may ignore the context, but errors caused by it must be
+		// recognizable.
+		_, err := store.Read(canceled, newSID())
+		if err != nil {
+			assert.ErrorIs(t, err, context.Canceled, "read with a canceled context")
+		}
+	})
+
+	if checker, ok := store.(session.ExistChecker); ok {
+		t.Run("CheckExist", func(t *testing.T) {
+			sid := newSID()
+			exist, err := checker.CheckExist(ctx, sid)
+			require.NoError(t, err)
+			assert.False(t, exist, "nonexistent session exists")
+
+			sess, err := store.Read(ctx, sid)
+			require.NoError(t, err)
+			sess.Set("username", "flamego")
+			require.NoError(t, store.Save(ctx, sess))
+
+			exist, err = checker.CheckExist(ctx, sid)
+			require.NoError(t, err)
+			assert.True(t, exist, "saved session exists")
+		})
+	}
+
+	if reader, ok := store.(session.MultiReader); ok {
+		t.Run("ReadMany", func(t *testing.T) {
+			var want []string
+			for i := 0; i < 2; i++ {
+				sid := newSID()
+				sess, err := store.Read(ctx, sid)
+				require.NoError(t, err)
+				sess.Set("index", i)
+				require.NoError(t, store.Save(ctx, sess))
+				want = append(want, sid)
+			}
+
+			got, err := reader.ReadMany(ctx, append(want, newSID()))
+			require.NoError(t, err)
+			assert.Len(t, got, len(want), "nonexistent sessions are omitted")
+			for i, sid := range want {
+				require.Contains(t, got, sid, "read sessions")
+				assert.Equal(t, sid, got[sid].ID(), "session ID of the read session")
+				assert.Equal(t, i, got[sid].Get("index"), "data of the read session")
+			}
+
+			got, err = reader.ReadMany(ctx, nil)
+			require.NoError(t, err)
+			assert.Empty(t, got, "read no session")
+		})
+	}
+
+	if lister, ok := session.StoreAs[session.Lister](store); ok {
+		t.Run("List", func(t *testing.T) {
+			var want []string
+			for i := 0; i < 3; i++ {
+				sid := newSID()
+				sess, err := store.Read(ctx, sid)
+				require.NoError(t, err)
+				sess.Set("index", i)
+				require.NoError(t, store.Save(ctx, sess))
+				want = append(want, sid)
+			}
+
+			got, err := lister.List(ctx)
+			require.NoError(t, err)
+			sort.Strings(got)
+			for _, sid := range want {
+				assert.Contains(t, got, sid, "listed sessions")
+			}
+		})
+	}
+
+	if expirer, ok := session.StoreAs[session.Expirer](store); ok {
+		t.Run("ExpiresAt", func(t *testing.T) {
+			expiresAt, err := expirer.ExpiresAt(ctx, newSID())
+			require.NoError(t, err)
+			assert.True(t, expiresAt.IsZero(), "expiry time of a nonexistent session")
+
+			sid := newSID()
+			sess, err := store.Read(ctx, sid)
+			require.NoError(t, err)
+			sess.Set("username", "flamego")
+			require.NoError(t, store.Save(ctx, sess))
+
+			expiresAt, err = expirer.ExpiresAt(ctx, sid)
+			require.NoError(t, err)
+			assert.True(t, expiresAt.After(time.Now()), "expiry time of the saved session")
+		})
+	}
+
+	if finder, ok := session.StoreAs[session.TagFinder](store); ok {
+		t.Run("FindByTag", func(t *testing.T) {
+			value := newSID()
+			sid := newSID()
+			sess, err := store.Read(ctx, sid)
+			require.NoError(t, err)
+			session.Tag(sess, "storetest", value)
+			require.NoError(t, store.Save(ctx, sess))
+
+			sess, err = store.Read(ctx, sid)
+			require.NoError(t, err)
+			assert.Equal(t, value, session.TagsOf(sess)["storetest"], "tag of the saved session")
+
+			sids, err := finder.FindByTag(ctx, "storetest", value)
+			require.NoError(t, err)
+			assert.Equal(t, []string{sid}, sids, "sessions found by the tag")
+
+			require.NoError(t, store.Destroy(ctx, sid))
+			sids, err = finder.FindByTag(ctx, "storetest", value)
+			require.NoError(t, err)
+			assert.Empty(t, sids, "destroyed sessions found by the tag")
+		})
+	}
+
+	if inc, ok := session.StoreAs[session.Incrementer](store); ok && session.Capabilities(store).Incr {
+		t.Run("Incr", func(t *testing.T) {
+			sid := newSID()
+			sess, err := store.Read(ctx, sid)
+			require.NoError(t, err)
+			require.NoError(t, store.Save(ctx, sess))
+
+			for i, want := range []int64{1, 3, 0} {
+				delta := []int64{1, 2, -3}[i]
+				got, err := inc.Incr(ctx, sid, "visits", delta)
+				require.NoError(t, err)
+				assert.Equal(t, want, got, "counter value")
+			}
+		})
+	}
+
+	lifetime := lifetimeOf(config)
+	t.Run("Expiry", func(t *testing.T) {
+		if lifetime <= 0 || lifetime > maxExpiryLifetime {
+			t.Skipf("Set the Lifetime of the configuration to at most %s to test expiry", maxExpiryLifetime)
+		}
+
+		var sids []string
+		for i := 0; i < 2; i++ {
+			sid := newSID()
+			sess, err := store.Read(ctx, sid)
+			require.NoError(t, err)
+			sess.Set("username", "flamego")
+			require.NoError(t, store.Save(ctx, sess))
+			sids = append(sids, sid)
+		}
+
+		time.Sleep(lifetime + 500*time.Millisecond)
+
+		// Expired sessions are read as new sessions with the same ID before being
+		// recycled by GC
+		sess, err := store.Read(ctx, sids[1])
+		require.NoError(t, err)
+		assert.Equal(t, sids[1], sess.ID(), "session ID of the expired session")
+		assert.Nil(t, sess.Get("username"), "data of the expired session")
+
+		if reader, ok := store.(session.MultiReader); ok {
+			got, err := reader.ReadMany(ctx, sids)
+			require.NoError(t, err)
+			assert.Empty(t, got, "expired sessions are omitted")
+		}
+
+		require.NoError(t, store.GC(ctx))
+		assert.False(t, store.Exist(ctx, sids[0]), "expired session exists after GC")
+	})
+}
+
+// lifetimeOf returns the value of the `Lifetime` field of the configuration, or
+// zero if there is no such field.
+func lifetimeOf(config interface{}) time.Duration {
+	v := reflect.ValueOf(config)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return 0
+	}
+
+	f := v.FieldByName("Lifetime")
+	if !f.IsValid() || f.Type() != reflect.TypeOf(time.Duration(0)) {
+		return 0
+	}
+	return time.Duration(f.Int())
+}
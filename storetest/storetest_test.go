@@ -0,0 +1,35 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package storetest
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flamego/session"
+	"github.com/flamego/session/sessiontest"
+)
+
+func TestConformance(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		Conformance(t, session.MemoryIniter(), session.MemoryConfig{Lifetime: time.Second})
+	})
+	t.Run("memory time wheel", func(t *testing.T) {
+		Conformance(t, session.MemoryIniter(), session.MemoryConfig{
+			Lifetime: time.Second,
+			Engine:   session.MemoryEngineTimeWheel,
+		})
+	})
+	t.Run("file", func(t *testing.T) {
+		Conformance(t, session.FileIniter(), session.FileConfig{
+			Lifetime: time.Second,
+			RootDir:  filepath.Join(t.TempDir(), "sessions"),
+		})
+	})
+	t.Run("sessiontest", func(t *testing.T) {
+		Conformance(t, sessiontest.NewStore().Initer(), nil)
+	})
+}
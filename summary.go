@@ -0,0 +1,48 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"time"
+)
+
+// Summary is the metadata of a session that is maintained by the session store
+// alongside the session data, which is readable without decoding the session
+// data, e.g. for inspection tooling.
+type Summary struct {
+	// ID is the session ID.
+	ID string
+	// CreatedAt is the time when the session was created (see session.InfoOf), or
+	// zero if unknown.
+	CreatedAt time.Time
+	// UserID is the ID of the user that is signed in to the session via
+	// session.SignIn, or empty if not signed in.
+	UserID string
+	// Size is the size of the encoded session data in bytes.
+	Size int
+	// ExpiresAt is the time when the session expires, or zero if unknown.
+	ExpiresAt time.Time
+}
+
+// Summarizer is a session store that is capable of reporting summaries of
+// sessions.
+type Summarizer interface {
+	// Summarize returns the summary of the session with given ID, or nil if the
+	// session does not exist.
+	Summarize(ctx context.Context, sid string) (*Summary, error)
+}
+
+// SummaryOf returns the summary of the session with given encoded session data,
+// which is meant for session stores to maintain summaries when saving sessions.
+// The ExpiresAt is left for session stores to fill.
+func SummaryOf(s Session, binary []byte) Summary {
+	return Summary{
+		ID:        s.ID(),
+		CreatedAt: InfoOf(s).CreatedAt,
+		UserID:    currentUser(s),
+		Size:      len(binary),
+	}
+}
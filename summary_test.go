@@ -0,0 +1,28 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummaryOf(t *testing.T) {
+	sess := NewBaseSession("111", GobEncoder, nil)
+	assert.Equal(t, Summary{ID: "111", Size: 3}, SummaryOf(sess, []byte("abc")))
+
+	createdAt := time.Unix(0, time.Now().UnixNano())
+	setInternal(sess, infoKey, Data{"created_at": createdAt.UnixNano()})
+	setInternal(sess, authKey, Data{"user_id": "alice"})
+	want := Summary{
+		ID:        "111",
+		CreatedAt: createdAt,
+		UserID:    "alice",
+		Size:      3,
+	}
+	assert.Equal(t, want, SummaryOf(sess, []byte("abc")))
+}
@@ -0,0 +1,33 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+// tagger is a session that is capable of keeping tags, e.g. session.BaseSession.
+type tagger interface {
+	// Tag sets the tag of given key to be the value, an empty value removes the
+	// tag.
+	Tag(key, value string)
+	// Tags returns a copy of all tags of the session.
+	Tags() map[string]string
+}
+
+// Tag sets the tag of given key of the session to be the value, which is
+// persisted as indexable metadata alongside the session data by session stores
+// that implement session.TagFinder. An empty value removes the tag. It is a
+// no-op for sessions that are not capable of keeping tags.
+func Tag(s Session, key, value string) {
+	if t, ok := rootOf(s).(tagger); ok {
+		t.Tag(key, value)
+	}
+}
+
+// TagsOf returns a copy of all tags of the session. It returns an empty map for
+// sessions that are not capable of keeping tags.
+func TagsOf(s Session) map[string]string {
+	if t, ok := rootOf(s).(tagger); ok {
+		return t.Tags()
+	}
+	return make(map[string]string)
+}
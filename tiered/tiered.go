@@ -0,0 +1,352 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package tiered provides a two-tier session store that caches sessions of a
+// backing session store in memory, which can be preloaded ahead of traffic
+// spikes (see session.Preloader).
+package tiered
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/flamego/session"
+)
+
+// Config contains options for the two-tier session store.
+type Config struct {
+	// Initer is the initialization function of the backing session store.
+	Initer session.Initer
+	// Config is the configuration object to be passed to the Initer.
+	Config interface{}
+	// TTL is the duration that cached sessions are served without reading the
+	// backing session store, which bounds the staleness of sessions that are
+	// changed by other instances. Default is 1 minute.
+	TTL time.Duration
+	// MaxSessions is the maximum number of cached sessions, least recently used
+	// sessions are evicted when the cache is full. Default is 10000.
+	MaxSessions int
+	// NowFunc is the function to return the current time for expiry of cached
+	// sessions. Default is time.Now.
+	NowFunc func() time.Time
+	// Encoder is the encoder of cached session data, which must be the same as
+	// the encoder of the backing session store. Default is session.GobEncoder.
+	Encoder session.Encoder
+	// Decoder is the decoder of cached session data, which must be the same as
+	// the decoder of the backing session store. Default is session.GobDecoder.
+	Decoder session.Decoder
+}
+
+// entry is a cached session.
+type entry struct {
+	sid      string            // The session ID
+	binary   []byte            // The encoded session data
+	tags     map[string]string // The tags of the session
+	cachedAt time.Time         // The time when the session was cached
+}
+
+var _ session.Store = (*tieredStore)(nil)
+
+// tieredStore is a session store that caches sessions of the backing session
+// store in memory.
+type tieredStore struct {
+	session.Store // The backing session store
+
+	ttl         time.Duration
+	maxSessions int
+	nowFunc     func() time.Time
+	encoder     session.Encoder
+	decoder     session.Decoder
+	idWriter    session.IDWriter
+
+	lock    sync.Mutex
+	lru     *list.List               // The cached sessions, most recently used first
+	entries map[string]*list.Element // The elements of cached sessions in the lru
+}
+
+// Unwrap returns the backing session store.
+func (s *tieredStore) Unwrap() session.Store {
+	return s.Store
+}
+
+// get returns the cached session with given ID, or nil if it is not cached or
+// has expired.
+func (s *tieredStore) get(sid string) *entry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	elem, ok := s.entries[sid]
+	if !ok {
+		return nil
+	}
+	e := elem.Value.(*entry)
+	if s.nowFunc().Sub(e.cachedAt) >= s.ttl {
+		s.lru.Remove(elem)
+		delete(s.entries, sid)
+		return nil
+	}
+	s.lru.MoveToFront(elem)
+	return e
+}
+
+// put caches the session, the session is evicted if it cannot be encoded.
+func (s *tieredStore) put(sess session.Session) {
+	binary, err := sess.Encode()
+	if err != nil {
+		s.evict(sess.ID())
+		return
+	}
+	e := &entry{
+		sid:      sess.ID(),
+		binary:   binary,
+		tags:     session.TagsOf(sess),
+		cachedAt: s.nowFunc(),
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if elem, ok := s.entries[e.sid]; ok {
+		elem.Value = e
+		s.lru.MoveToFront(elem)
+		return
+	}
+	s.entries[e.sid] = s.lru.PushFront(e)
+	for s.lru.Len() > s.maxSessions {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*entry).sid)
+	}
+}
+
+// evict removes the session with given ID from the cache.
+func (s *tieredStore) evict(sid string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if elem, ok := s.entries[sid]; ok {
+		s.lru.Remove(elem)
+		delete(s.entries, sid)
+	}
+}
+
+func (s *tieredStore) Exist(ctx context.Context, sid string) bool {
+	ok, _ := s.CheckExist(ctx, sid)
+	return ok
+}
+
+var _ session.ExistChecker = (*tieredStore)(nil)
+
+func (s *tieredStore) CheckExist(ctx context.Context, sid string) (bool, error) {
+	if s.get(sid) != nil {
+		return true, nil
+	}
+	return session.CheckExist(ctx, s.Store, sid)
+}
+
+// cached returns the cached session with given ID, or nil if it is not cached,
+// has expired or cannot be decoded.
+func (s *tieredStore) cached(sid string) session.Session {
+	e := s.get(sid)
+	if e == nil {
+		return nil
+	}
+
+	data, err := s.decoder(e.binary)
+	if err != nil {
+		s.evict(sid)
+		return nil
+	}
+	sess := session.NewBaseSessionWithData(sid, s.encoder, s.idWriter, data)
+	sess.LoadTags(e.tags)
+	return sess
+}
+
+// Read reads from the cache first, sessions that are not cached are read from
+// the backing session store and cached if they exist.
+func (s *tieredStore) Read(ctx context.Context, sid string) (session.Session, error) {
+	sessions, err := s.ReadMany(ctx, []string{sid})
+	if err != nil {
+		return nil, err
+	}
+	sess, ok := sessions[sid]
+	if !ok {
+		return session.NewBaseSession(sid, s.encoder, s.idWriter), nil
+	}
+	return sess, nil
+}
+
+var _ session.MultiReader = (*tieredStore)(nil)
+
+// ReadMany reads from the cache first, sessions that are not cached are read
+// from the backing session store at once and cached.
+func (s *tieredStore) ReadMany(ctx context.Context, sids []string) (map[string]session.Session, error) {
+	sessions := make(map[string]session.Session, len(sids))
+	var missing []string
+	for _, sid := range sids {
+		if sess := s.cached(sid); sess != nil {
+			sessions[sid] = sess
+		} else {
+			missing = append(missing, sid)
+		}
+	}
+	if len(missing) == 0 {
+		return sessions, nil
+	}
+
+	found, err := session.ReadMany(ctx, s.Store, missing)
+	if err != nil {
+		return nil, err
+	}
+	for sid, sess := range found {
+		s.put(sess)
+		sessions[sid] = sess
+	}
+	return sessions, nil
+}
+
+func (s *tieredStore) Destroy(ctx context.Context, sid string) error {
+	s.evict(sid)
+	return s.Store.Destroy(ctx, sid)
+}
+
+func (s *tieredStore) Save(ctx context.Context, sess session.Session) error {
+	err := s.Store.Save(ctx, sess)
+	if err != nil {
+		s.evict(sess.ID())
+		return err
+	}
+	s.put(sess)
+	return nil
+}
+
+var _ session.EncoderReporter = (*tieredStore)(nil)
+
+func (s *tieredStore) Encoder() session.Encoder {
+	return s.encoder
+}
+
+var _ session.GCNeeder = (*tieredStore)(nil)
+
+// NeedsGC returns true as expired sessions are dropped from the cache by GC
+// operations, even if the backing session store expires sessions on its own.
+func (s *tieredStore) NeedsGC() bool {
+	return true
+}
+
+// GC performs a GC operation on the backing session store and drops expired
+// sessions from the cache.
+func (s *tieredStore) GC(ctx context.Context) error {
+	s.lock.Lock()
+	now := s.nowFunc()
+	for elem := s.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if e := elem.Value.(*entry); now.Sub(e.cachedAt) >= s.ttl {
+			s.lru.Remove(elem)
+			delete(s.entries, e.sid)
+		}
+		elem = prev
+	}
+	s.lock.Unlock()
+
+	return s.Store.GC(ctx)
+}
+
+// preloadBatchSize is the maximum number of sessions to be read from the
+// backing session store at once when preloading.
+const preloadBatchSize = 1000
+
+var _ session.Preloader = (*tieredStore)(nil)
+
+// Preload reads sessions that are not cached from the backing session store in
+// batches (see session.ReadMany), and caches the ones that exist. Sessions
+// beyond Config.MaxSessions evict the least recently used ones.
+func (s *tieredStore) Preload(ctx context.Context, sids []string) (int, error) {
+	missing := make([]string, 0, len(sids))
+	for _, sid := range sids {
+		if s.get(sid) == nil {
+			missing = append(missing, sid)
+		}
+	}
+
+	loaded := 0
+	for len(missing) > 0 {
+		if err := ctx.Err(); err != nil {
+			return loaded, err
+		}
+
+		batch := missing[:min(preloadBatchSize, len(missing))]
+		missing = missing[len(batch):]
+		sessions, err := session.ReadMany(ctx, s.Store, batch)
+		if err != nil {
+			return loaded, fmt.Errorf("read many: %w", err)
+		}
+		for _, sess := range sessions {
+			s.put(sess)
+		}
+		loaded += len(sessions)
+	}
+	return loaded, nil
+}
+
+// Initer returns the session.Initer for the two-tier session store.
+func Initer() session.Initer {
+	return func(ctx context.Context, args ...interface{}) (session.Store, error) {
+		var cfg *Config
+		var idWriter session.IDWriter
+		for i := range args {
+			switch v := args[i].(type) {
+			case Config:
+				cfg = &v
+			case session.IDWriter:
+				idWriter = v
+			}
+		}
+		if idWriter == nil {
+			return nil, errors.New("IDWriter not given")
+		}
+
+		if cfg == nil {
+			return nil, fmt.Errorf("config object with the type '%T' not found", Config{})
+		} else if cfg.Initer == nil {
+			return nil, errors.New("empty Initer")
+		}
+
+		if cfg.TTL <= 0 {
+			cfg.TTL = time.Minute
+		}
+		if cfg.MaxSessions < 1 {
+			cfg.MaxSessions = 10000
+		}
+		if cfg.NowFunc == nil {
+			cfg.NowFunc = time.Now
+		}
+		if cfg.Encoder == nil {
+			cfg.Encoder = session.GobEncoder
+		}
+		if cfg.Decoder == nil {
+			cfg.Decoder = session.GobDecoder
+		}
+
+		backing, err := cfg.Initer(ctx, cfg.Config, idWriter)
+		if err != nil {
+			return nil, fmt.Errorf("init backing store: %w", err)
+		}
+		return &tieredStore{
+			Store:       backing,
+			ttl:         cfg.TTL,
+			maxSessions: cfg.MaxSessions,
+			nowFunc:     cfg.NowFunc,
+			encoder:     cfg.Encoder,
+			decoder:     cfg.Decoder,
+			idWriter:    idWriter,
+			lru:         list.New(),
+			entries:     make(map[string]*list.Element),
+		}, nil
+	}
+}
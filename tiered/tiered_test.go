@@ -0,0 +1,103 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package tiered
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/session"
+)
+
+func newTestStore(t *testing.T, cfg Config) *tieredStore {
+	cfg.Initer = session.FileIniter()
+	cfg.Config = session.FileConfig{RootDir: t.TempDir()}
+	store, err := Initer()(context.Background(),
+		cfg,
+		session.IDWriter(func(http.ResponseWriter, *http.Request, string) {}),
+	)
+	require.NoError(t, err)
+	return store.(*tieredStore)
+}
+
+// saveToBacking saves a session with given ID and name to the backing session
+// store without going through the cache.
+func saveToBacking(t *testing.T, s *tieredStore, sid, name string) {
+	ctx := context.Background()
+	sess, err := s.Store.Read(ctx, sid)
+	require.NoError(t, err)
+	sess.Set("name", name)
+	require.NoError(t, s.Store.Save(ctx, sess))
+}
+
+func TestTieredStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := newTestStore(t, Config{
+		TTL:     time.Minute,
+		NowFunc: func() time.Time { return now },
+	})
+
+	sess, err := store.Read(ctx, "111")
+	require.NoError(t, err)
+	sess.Set("name", "flamego")
+	require.NoError(t, store.Save(ctx, sess))
+
+	// Cached sessions are served without reading the backing session store
+	saveToBacking(t, store, "111", "changed")
+	got, err := store.Read(ctx, "111")
+	require.NoError(t, err)
+	assert.Equal(t, "flamego", got.Get("name"))
+
+	// Cached sessions are read again from the backing session store after TTL
+	now = now.Add(time.Minute)
+	got, err = store.Read(ctx, "111")
+	require.NoError(t, err)
+	assert.Equal(t, "changed", got.Get("name"))
+
+	require.NoError(t, store.Destroy(ctx, "111"))
+	assert.False(t, store.Exist(ctx, "111"))
+
+	// Nonexistent sessions are not cached
+	_, err = store.Read(ctx, "222")
+	require.NoError(t, err)
+	assert.Empty(t, store.entries)
+}
+
+func TestTieredStore_Preload(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t, Config{MaxSessions: 2})
+
+	saveToBacking(t, store, "111", "a")
+	saveToBacking(t, store, "222", "b")
+	saveToBacking(t, store, "333", "c")
+
+	n, err := store.Preload(ctx, []string{"111", "222", "444"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.NotNil(t, store.get("111"))
+	assert.NotNil(t, store.get("222"))
+
+	// Cached sessions are not loaded again
+	n, err = store.Preload(ctx, []string{"111", "222"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// The least recently used session is evicted
+	n, err = store.Preload(ctx, []string{"333"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Nil(t, store.get("111"))
+	assert.NotNil(t, store.get("333"))
+
+	preloader, ok := session.StoreAs[session.Preloader](store)
+	require.True(t, ok)
+	assert.Equal(t, store, preloader)
+}
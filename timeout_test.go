@@ -0,0 +1,192 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestSessioner_AbsoluteTimeout(t *testing.T) {
+	const timeout = time.Hour
+	var store Store
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Config: FileConfig{
+				RootDir: t.TempDir(),
+			},
+			Initer: FileIniter(),
+			StoreWrappers: []StoreMiddleware{
+				func(s Store) Store {
+					store = s
+					return s
+				},
+			},
+			GCMode:          GCDisabled,
+			AbsoluteTimeout: timeout,
+			OnAbsoluteTimeout: func(c flamego.Context, s Session) {
+				s.SetFlash("Your session has expired, please sign in again.")
+				c.Redirect("/sign-in")
+			},
+			ErrorFunc: func(err error) { t.Fatalf("Unexpected error: %v", err) },
+		},
+	))
+	f.Get("/", func(s Session) string {
+		return s.ID()
+	})
+	f.Get("/set", func(s Session) {
+		s.Set("name", "flamego")
+	})
+	f.Get("/age", func(s Session) {
+		// Pretend the session was created long ago
+		info := s.Get(infoKey).(Data)
+		info["created_at"] = time.Now().Add(-timeout).UnixNano()
+		setInternal(s, infoKey, info)
+	})
+	f.Get("/sign-in", func(s Session, flash Flash) string {
+		name, _ := s.Get("name").(string)
+		return name + "|" + flash.(string)
+	})
+
+	var cookie string
+	request := func(path string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if c := resp.Header().Values("Set-Cookie"); len(c) > 0 {
+			cookie = strings.Split(c[len(c)-1], ";")[0]
+		}
+		return resp
+	}
+
+	request("/set")
+	sid := request("/").Body.String()
+
+	// Sessions that are not old enough are kept
+	assert.Equal(t, sid, request("/").Body.String())
+
+	request("/age")
+	resp := request("/")
+	assert.Equal(t, http.StatusFound, resp.Code, "handlers are not skipped")
+	assert.Equal(t, "/sign-in", resp.Header().Get("Location"))
+	assert.False(t, store.Exist(context.Background(), sid), "timed out session exists")
+
+	// The new session carries nothing but the flash
+	resp = request("/sign-in")
+	assert.Equal(t, "|Your session has expired, please sign in again.", resp.Body.String())
+	assert.NotEqual(t, sid, request("/").Body.String())
+}
+
+func TestSessioner_NowFunc(t *testing.T) {
+	const timeout = time.Hour
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	nowFunc := func() time.Time { return now }
+
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Config: FileConfig{
+				NowFunc:  nowFunc,
+				RootDir:  t.TempDir(),
+				Lifetime: 2 * timeout,
+			},
+			Initer:          FileIniter(),
+			NowFunc:         nowFunc,
+			GCMode:          GCDisabled,
+			AbsoluteTimeout: timeout,
+			ErrorFunc:       func(err error) { t.Fatalf("Unexpected error: %v", err) },
+		},
+	))
+	f.Get("/", func(s Session) string {
+		return s.ID() + "|" + InfoOf(s).CreatedAt.UTC().Format(time.RFC3339)
+	})
+
+	var cookie string
+	request := func() string {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if c := resp.Header().Values("Set-Cookie"); len(c) > 0 {
+			cookie = strings.Split(c[len(c)-1], ";")[0]
+		}
+		return resp.Body.String()
+	}
+
+	// The session information is tracked with the simulated clock, which is
+	// only visible from the second request.
+	request()
+	first := request()
+	sid, createdAt, _ := strings.Cut(first, "|")
+	assert.Equal(t, "2023-01-01T00:00:00Z", createdAt)
+
+	// The session times out once the simulated clock passes the absolute timeout
+	now = now.Add(timeout)
+	request()
+	second := request()
+	assert.NotEqual(t, sid, strings.Split(second, "|")[0])
+	assert.Equal(t, "2023-01-01T01:00:00Z", strings.Split(second, "|")[1])
+}
+
+func TestSessioner_NowFunc_Expiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			NowFunc: func() time.Time { return now },
+			GCMode:  GCDisabled,
+		},
+	))
+	f.Get("/set", func(c flamego.Context, s Session) {
+		require.NoError(t, SignIn(c, "alice"))
+		SetWithTTL(s, "otp", "123456", time.Minute)
+		SetWithTTL(Scope(s, "plugin"), "otp", "654321", time.Minute)
+	})
+	f.Get("/get", func(s Session) string {
+		auth, _ := s.Get(authKey).(Data)
+		return fmt.Sprintf("%v,%v,%v", s.Get("otp"), Scope(s, "plugin").Get("otp"), auth["signed_in_at"])
+	})
+
+	var cookie string
+	request := func(path string) string {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if c := resp.Header().Get("Set-Cookie"); c != "" {
+			cookie = c
+		}
+		return resp.Body.String()
+	}
+
+	request("/set")
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, "123456,654321,1700000000000000000", request("/get"))
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, "<nil>,<nil>,1700000000000000000", request("/get"))
+}
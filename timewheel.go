@@ -0,0 +1,105 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"container/list"
+	"time"
+)
+
+// timeWheelSlots is the number of slots that spans the lifetime of sessions in
+// a timing wheel.
+const timeWheelSlots = 256
+
+// timeWheel is a timing wheel to manage expiry of sessions with O(1) insertions,
+// updates and removals. Each slot holds sessions that expire within the same
+// tick. Because all sessions share the same lifetime, no session expires further
+// than one lifetime ahead, thus a single level of slots spanning the lifetime
+// is sufficient and there is no need to cascade sessions between levels as in
+// hierarchical timing wheels.
+//
+// It is not concurrent-safe and is the caller's responsibility to ensure it is
+// guarded by a mutex.
+type timeWheel struct {
+	tick   time.Duration // The duration of each slot
+	slots  []*list.List  // The lists of sessions in each slot
+	cursor int64         // The tick of the next slot to look for expired sessions
+}
+
+// newTimeWheel returns a new timing wheel for sessions with given lifetime,
+// starting at given time.
+func newTimeWheel(lifetime time.Duration, now time.Time) *timeWheel {
+	tick := lifetime/timeWheelSlots + 1
+	// One more slot to make sure the slots span more than the lifetime
+	slots := make([]*list.List, timeWheelSlots+1)
+	for i := range slots {
+		slots[i] = list.New()
+	}
+	return &timeWheel{
+		tick:   tick,
+		slots:  slots,
+		cursor: now.UnixNano() / int64(tick),
+	}
+}
+
+// add adds the session that expires at given time to the wheel.
+func (w *timeWheel) add(sess *memorySession, expiresAt time.Time) {
+	sess.slot = int((expiresAt.UnixNano() / int64(w.tick)) % int64(len(w.slots)))
+	sess.elem = w.slots[sess.slot].PushBack(sess)
+}
+
+// remove removes the session from the wheel.
+func (w *timeWheel) remove(sess *memorySession) {
+	if sess.elem == nil {
+		return
+	}
+	w.slots[sess.slot].Remove(sess.elem)
+	sess.elem = nil
+}
+
+// move moves the session to the slot of the new expiry time.
+func (w *timeWheel) move(sess *memorySession, expiresAt time.Time) {
+	w.remove(sess)
+	w.add(sess, expiresAt)
+}
+
+// expired returns a session that has expired at given time, or nil if there
+// is none. The `expiresAt` returns the expiry time of a session. Slots that no
+// longer have expired sessions are skipped in subsequent calls.
+func (w *timeWheel) expired(now time.Time, expiresAt func(*memorySession) time.Time) *memorySession {
+	current := now.UnixNano() / int64(w.tick)
+	// There is no need to visit any slot more than once
+	if current-w.cursor >= int64(len(w.slots)) {
+		w.cursor = current - int64(len(w.slots)) + 1
+	}
+
+	for ; w.cursor <= current; w.cursor++ {
+		// Sessions are checked individually because the slot of the current tick
+		// may have sessions yet to expire.
+		for e := w.slots[w.cursor%int64(len(w.slots))].Front(); e != nil; e = e.Next() {
+			sess := e.Value.(*memorySession)
+			if !now.Before(expiresAt(sess)) {
+				return sess
+			}
+		}
+
+		if w.cursor == current {
+			break
+		}
+	}
+	return nil
+}
+
+// oldest returns the session that expires the earliest, or nil if there is
+// none. Sessions in the same slot are in the order of being added, thus the
+// session returned is only the oldest within the precision of a tick.
+func (w *timeWheel) oldest() *memorySession {
+	for i := int64(0); i < int64(len(w.slots)); i++ {
+		if e := w.slots[(w.cursor+i)%int64(len(w.slots))].Front(); e != nil {
+			return e.Value.(*memorySession)
+		}
+	}
+	return nil
+}
@@ -6,11 +6,15 @@ package session
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"reflect"
 	"sync"
-
-	"github.com/pkg/errors"
+	"time"
 )
 
 // Data is the data structure for storing session data.
@@ -22,18 +26,46 @@ type Encoder func(Data) ([]byte, error)
 // Decoder is a decoder to decode binary to session data.
 type Decoder func([]byte) (Data, error)
 
+// StreamEncoder is an encoder to encode session data to a stream, which spares
+// materializing the whole encoding in memory for very large sessions. It is
+// used by session stores that are capable of streaming (e.g. the file store),
+// and must produce the same encoding as the Encoder of the session store.
+type StreamEncoder func(w io.Writer, data Data) error
+
+// StreamDecoder is a decoder to decode session data from a stream, the
+// counterpart of the StreamEncoder.
+type StreamDecoder func(r io.Reader) (Data, error)
+
 // IDWriter is a function that writes the session ID to client (browser).
 type IDWriter func(w http.ResponseWriter, r *http.Request, sid string)
 
 var _ Session = (*BaseSession)(nil)
 
-// BaseSession implements basic operations for the session data.
+// BaseSession implements basic operations for the session data. The session
+// data is shared by all views of the session (see viewOf), while each view
+// keeps the state of the request it serves.
 type BaseSession struct {
+	*sessionState
+
+	bindings map[reflect.Type]*binding // The structs bound to the session data
+	auditing bool                      // Whether to journal changes made to the session data
+	journal  []journalEntry            // The journal of changes made to the session data
+	rc       *requestContext           // The state of the request that the view serves, may be nil
+}
+
+// sessionState is the state of a session that is shared by all views of the
+// session.
+type sessionState struct {
 	sid     string       // The session ID
-	lock    sync.RWMutex // The mutex to guard accesses to the data
+	lock    sync.RWMutex // The mutex to guard accesses to the state and views of the session
 	data    Data         // The map of the session data
 	changed bool         // Whether the session has changed since read
 
+	tags map[string]string // The tags of the session
+
+	onRegenerate func(oldSID string) // The function to be called after the session ID is regenerated, may be nil
+	loadedDigest []byte              // The digest of the encoding when loaded, nil if not tracked
+
 	encoder  Encoder
 	idWriter IDWriter
 }
@@ -41,87 +73,789 @@ type BaseSession struct {
 // NewBaseSession returns a new BaseSession with given session ID.
 func NewBaseSession(sid string, encoder Encoder, idWriter IDWriter) *BaseSession {
 	return &BaseSession{
-		sid:      sid,
-		data:     make(Data),
-		encoder:  encoder,
-		idWriter: idWriter,
+		sessionState: &sessionState{
+			sid:      sid,
+			data:     make(Data),
+			encoder:  encoder,
+			idWriter: idWriter,
+		},
 	}
 }
 
 // NewBaseSessionWithData returns a new BaseSession with given session ID and
-// initial data.
+// initial data. Sessions whose data was decoded with outdated keys (see
+// session.KeyRing) are marked as changed to be upgraded when saved.
 func NewBaseSessionWithData(sid string, encoder Encoder, idWriter IDWriter, data Data) *BaseSession {
+	_, stale := data[staleKey]
+	if stale {
+		delete(data, staleKey)
+	}
 	return &BaseSession{
-		sid:      sid,
-		data:     data,
-		encoder:  encoder,
-		idWriter: idWriter,
+		sessionState: &sessionState{
+			sid:      sid,
+			data:     data,
+			changed:  stale,
+			encoder:  encoder,
+			idWriter: idWriter,
+		},
 	}
 }
 
+// viewer is a session that may be shared by concurrent requests, e.g. sessions
+// of the memory session store, which is served to each request via a view that
+// keeps the state of the request, e.g. the function to write flashes.
+type viewer interface {
+	// view returns a new view of the session.
+	view() Session
+}
+
+// viewOf returns a new view of the session if it may be shared by concurrent
+// requests, or the session itself otherwise.
+func viewOf(s Session) Session {
+	if v, ok := s.(viewer); ok {
+		return v.view()
+	}
+	return s
+}
+
+var _ viewer = (*BaseSession)(nil)
+
+func (s *BaseSession) view() Session {
+	return &BaseSession{sessionState: s.sessionState}
+}
+
+// requestContext is the state of the request that a view of the session serves,
+// e.g. the function to write flashes. Nil fields disable the corresponding
+// features, e.g. counters fall back to the session data without incr. It must
+// not be modified once set to a view.
+type requestContext struct {
+	ctx        context.Context                              // The context to be used for accessing the session store and the blob store
+	now        func() time.Time                             // The function to return the current time, may be nil
+	newID      func() (string, error)                       // The function to generate new session IDs, may be nil
+	preserved  []interface{}                                // The keys to be preserved across Flush
+	incr       func(key string, delta int64) (int64, error) // The function to increment counters in the session store, may be nil
+	lists      *listOps                                     // The functions to operate lists in the session store, may be nil
+	blobs      BlobStore                                    // The blob store of large values, may be nil
+	onExposure func(experiment, variant string)             // The function to report exposures to variants, may be nil
+	writeFlash func(val interface{})                        // The function to write flashes to the flash store, may be nil
+}
+
+// withoutStore returns a copy of the request context without the features that
+// are maintained by the session store.
+func (rc *requestContext) withoutStore() *requestContext {
+	c := *rc
+	c.incr = nil
+	c.lists = nil
+	return &c
+}
+
+// contextualizer is a session that keeps the state of the request it serves.
+type contextualizer interface {
+	// setRequestContext sets the state of the request, a nil request context
+	// disables all features of the request.
+	setRequestContext(rc *requestContext)
+}
+
+// setRequestContext sets the state of the request to the session if it keeps
+// one.
+func setRequestContext(s Session, rc *requestContext) {
+	if c, ok := s.(contextualizer); ok {
+		c.setRequestContext(rc)
+	}
+}
+
+var _ contextualizer = (*BaseSession)(nil)
+
+func (s *BaseSession) setRequestContext(rc *requestContext) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.rc = rc
+}
+
+// request returns a copy of the request context of the view, which is zero if
+// not set. It is not concurrent-safe and is the caller's responsibility to ensure the lock is
+// held.
+func (s *BaseSession) request() requestContext {
+	if s.rc == nil {
+		return requestContext{}
+	}
+	return *s.rc
+}
+
 func (s *BaseSession) ID() string {
 	return s.sid
 }
 
+// ErrHeaderWritten is returned when the session ID cannot be written to the
+// response because the response header has already been written.
+var ErrHeaderWritten = errors.New("response header already written")
+
+// headerWritten returns true if the response header is known to have been
+// written, which is only detectable for response writers with the
+// `Written() bool` method, e.g. flamego.ResponseWriter.
+func headerWritten(w http.ResponseWriter) bool {
+	ww, ok := w.(interface{ Written() bool })
+	return ok && ww.Written()
+}
+
 func (s *BaseSession) RegenerateID(w http.ResponseWriter, r *http.Request) error {
-	s.lock.Lock()
-	defer s.lock.Unlock()
+	if headerWritten(w) {
+		return ErrHeaderWritten
+	}
 
-	// Re-use the session ID with the same length, the length must already be valid
-	// for the code to run to this point.
-	sid, err := randomChars(len(s.sid))
+	s.lock.Lock()
+	oldSID := s.sid
+	newID := s.request().newID
+	if newID == nil {
+		// Re-use the session ID with the same length, the length must already be
+		// valid for the code to run to this point.
+		newID = func() (string, error) { return randomChars(len(oldSID)) }
+	}
+	sid, err := newID()
 	if err != nil {
-		return errors.Wrap(err, "new ID")
+		s.lock.Unlock()
+		return fmt.Errorf("new ID: %w", err)
 	}
 
 	s.idWriter(w, r, sid)
 	s.sid = sid
+	onRegenerate := s.onRegenerate
+	s.lock.Unlock()
+
+	// The hook is called without holding the lock, as it may access the session.
+	if onRegenerate != nil {
+		onRegenerate(oldSID)
+	}
 	return nil
 }
 
 func (s *BaseSession) Get(key interface{}) interface{} {
+	val := s.get(key)
+	if ref, ok := val.(blobRef); ok {
+		// Errors of reading the blob are returned by session.GetBlob
+		b, err := s.readBlob(ref)
+		if err != nil {
+			return nil
+		}
+		return b
+	}
+	return val
+}
+
+// get returns the value of given key without resolving blobs.
+func (s *BaseSession) get(key interface{}) interface{} {
+	// The write lock is only taken when there are bound structs to be synced or
+	// expired keys to be deleted.
 	s.lock.RLock()
-	defer s.lock.RUnlock()
-	return s.data[key]
+	val := s.data[key]
+	clean := len(s.bindings) == 0 && !s.hasExpired()
+	s.lock.RUnlock()
+
+	if !clean {
+		s.lock.Lock()
+		s.syncBindings()
+		s.expire()
+		val = s.data[key]
+		s.lock.Unlock()
+	}
+	return val
 }
 
 func (s *BaseSession) Set(key, val interface{}) {
+	checkKey(key)
+	s.setInternal(key, val, time.Time{})
+}
+
+var _ internalWriter = (*BaseSession)(nil)
+
+func (s *BaseSession) setInternal(key, val interface{}, expiresAt time.Time) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	s.syncBindings()
 	s.changed = true
+	s.record(AuditOpSet, key, val, true)
 	s.data[key] = val
+	s.setExpiry(key, expiresAt)
+	s.loadBindings()
 }
 
 func (s *BaseSession) SetFlash(val interface{}) {
 	s.lock.Lock()
+	if write := s.request().writeFlash; write != nil {
+		s.lock.Unlock()
+		write(val)
+		return
+	}
 	defer s.lock.Unlock()
 	s.changed = true
+	s.record(AuditOpSet, flashKey, val, true)
 	s.data[flashKey] = val
 }
 
+var _ counter = (*BaseSession)(nil)
+
+func (s *BaseSession) incr(key string, delta int64) (int64, error) {
+	s.lock.RLock()
+	incr := s.request().incr
+	s.lock.RUnlock()
+
+	if incr != nil {
+		n, err := incr(key, delta)
+		if err != nil {
+			return 0, fmt.Errorf("incr: %w", err)
+		}
+
+		// Make sure the session is persisted and kept alive along with its counters
+		s.lock.Lock()
+		s.changed = true
+		s.lock.Unlock()
+		return n, nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.syncBindings()
+	s.expire()
+	n, _ := s.data[key].(int64)
+	if delta == 0 {
+		return n, nil
+	}
+
+	n += delta
+	s.changed = true
+	s.record(AuditOpSet, key, n, true)
+	s.data[key] = n
+	s.loadBindings()
+	return n, nil
+}
+
+var _ listKeeper = (*BaseSession)(nil)
+
+func (s *BaseSession) listAppend(key string, val interface{}) error {
+	elem, err := encodeListElem(val)
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+
+	s.lock.RLock()
+	lists := s.request().lists
+	s.lock.RUnlock()
+
+	if lists != nil {
+		err = lists.append(key, elem)
+		if err != nil {
+			return fmt.Errorf("append: %w", err)
+		}
+
+		// Make sure the session is persisted and kept alive along with its lists
+		s.lock.Lock()
+		s.changed = true
+		s.lock.Unlock()
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.syncBindings()
+	s.expire()
+	// Lists are copied on write as the underlying array may be shared with
+	// readers of the previous list.
+	old, _ := s.data[key].([]interface{})
+	list := make([]interface{}, len(old), len(old)+1)
+	copy(list, old)
+	list = append(list, val)
+	s.changed = true
+	s.record(AuditOpSet, key, list, true)
+	s.data[key] = list
+	s.loadBindings()
+	return nil
+}
+
+func (s *BaseSession) listRemove(key string, val interface{}) (int, error) {
+	elem, err := encodeListElem(val)
+	if err != nil {
+		return 0, fmt.Errorf("encode: %w", err)
+	}
+
+	s.lock.RLock()
+	lists := s.request().lists
+	s.lock.RUnlock()
+
+	if lists != nil {
+		n, err := lists.remove(key, elem)
+		if err != nil {
+			return 0, fmt.Errorf("remove: %w", err)
+		}
+
+		s.lock.Lock()
+		s.changed = true
+		s.lock.Unlock()
+		return n, nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.syncBindings()
+	s.expire()
+	old, _ := s.data[key].([]interface{})
+	list, n := removeListElems(old, elem)
+	if n == 0 {
+		return 0, nil
+	}
+
+	s.changed = true
+	s.record(AuditOpSet, key, list, true)
+	s.data[key] = list
+	s.loadBindings()
+	return n, nil
+}
+
+func (s *BaseSession) listAll(key string) ([]interface{}, error) {
+	s.lock.RLock()
+	lists := s.request().lists
+	s.lock.RUnlock()
+
+	if lists != nil {
+		elems, err := lists.all(key)
+		if err != nil {
+			return nil, fmt.Errorf("all: %w", err)
+		}
+
+		list := make([]interface{}, 0, len(elems))
+		for i := range elems {
+			v, err := decodeListElem(elems[i])
+			if err != nil {
+				return nil, fmt.Errorf("decode element %d: %w", i, err)
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.syncBindings()
+	s.expire()
+	old, _ := s.data[key].([]interface{})
+	list := make([]interface{}, len(old))
+	copy(list, old)
+	return list, nil
+}
+
+var _ blobber = (*BaseSession)(nil)
+
+func (s *BaseSession) putBlob(r io.Reader) (blobRef, error) {
+	s.lock.RLock()
+	rc := s.request()
+	s.lock.RUnlock()
+	ctx, store := rc.ctx, rc.blobs
+	if store == nil {
+		return blobRef{}, ErrNoBlobStore
+	}
+
+	id, err := randomChars(blobIDLength)
+	if err != nil {
+		return blobRef{}, fmt.Errorf("new blob ID: %w", err)
+	}
+	err = store.PutBlob(ctx, id, r)
+	if err != nil {
+		return blobRef{}, fmt.Errorf("put blob: %w", err)
+	}
+	return blobRef{ID: id}, nil
+}
+
+func (s *BaseSession) readBlob(ref blobRef) ([]byte, error) {
+	s.lock.RLock()
+	rc := s.request()
+	s.lock.RUnlock()
+	ctx, store := rc.ctx, rc.blobs
+	if store == nil {
+		return nil, ErrNoBlobStore
+	}
+
+	b, err := store.GetBlob(ctx, ref.ID)
+	if err != nil {
+		return nil, fmt.Errorf("get blob: %w", err)
+	}
+	return b, nil
+}
+
+func (s *BaseSession) getBlob(key interface{}) ([]byte, error) {
+	switch val := s.get(key).(type) {
+	case nil:
+		return nil, nil
+	case blobRef:
+		return s.readBlob(val)
+	default:
+		return nil, fmt.Errorf("value of %v is not a blob", key)
+	}
+}
+
+var _ exposer = (*BaseSession)(nil)
+
+func (s *BaseSession) expose(experiment, variant string) {
+	s.lock.RLock()
+	onExposure := s.request().onExposure
+	s.lock.RUnlock()
+	if onExposure != nil {
+		onExposure(experiment, variant)
+	}
+}
+
 func (s *BaseSession) Delete(key interface{}) {
+	checkKey(key)
+	s.deleteInternal(key)
+}
+
+func (s *BaseSession) deleteInternal(key interface{}) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	s.syncBindings()
 	s.changed = true
+	s.record(AuditOpDelete, key, nil, false)
 	delete(s.data, key)
+	s.setExpiry(key, time.Time{})
+	s.loadBindings()
 }
 
 func (s *BaseSession) Flush() {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	s.changed = true
-	s.data = make(Data)
+
+	preserved := s.request().preserved
+	kept := make(Data, len(preserved))
+	for _, keys := range [][]interface{}{bookkeepingKeys(), preserved} {
+		for _, key := range keys {
+			if val, ok := s.data[key]; ok {
+				kept[key] = val
+			}
+		}
+	}
+	for key := range s.data {
+		if _, ok := kept[key]; !ok {
+			s.record(AuditOpFlush, key, nil, false)
+		}
+	}
+
+	expiries, _ := s.data[expiriesKey].(Data)
+	s.data = kept
+	for key := range kept {
+		if expiresAt, ok := expiries[key].(int64); ok {
+			s.setExpiry(key, time.Unix(0, expiresAt))
+		}
+	}
+	s.loadBindings()
 }
 
-func (s *BaseSession) Encode() ([]byte, error) {
+// timekeeper is a session that is capable of using a clock other than
+// time.Now, i.e. Options.NowFunc.
+type timekeeper interface {
+	// currentTime returns the current time of the session clock.
+	currentTime() time.Time
+}
+
+// nowOf returns the current time of the session clock, or time.Now if the
+// session does not keep a clock.
+func nowOf(s Session) time.Time {
+	if k, ok := s.(timekeeper); ok {
+		return k.currentTime()
+	}
+	return time.Now()
+}
+
+func (s *BaseSession) currentTime() time.Time {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
-	return s.encoder(s.data)
+	return s.now()
 }
 
-func (s *BaseSession) HasChanged() bool {
+// now returns the current time of the session clock. It is not concurrent-safe
+// and is the caller's responsibility to ensure the lock is held.
+func (s *BaseSession) now() time.Time {
+	if now := s.request().now; now != nil {
+		return now()
+	}
+	return time.Now()
+}
+
+// setExpiry sets the expiry time of given key, a zero time removes the expiry.
+// It is not concurrent-safe and is the caller's responsibility to ensure the
+// lock is held.
+func (s *BaseSession) setExpiry(key interface{}, t time.Time) {
+	expiries, _ := s.data[expiriesKey].(Data)
+	if t.IsZero() {
+		if _, ok := expiries[key]; !ok {
+			return
+		}
+		delete(expiries, key)
+		if len(expiries) == 0 {
+			delete(s.data, expiriesKey)
+		}
+		return
+	}
+
+	if expiries == nil {
+		expiries = make(Data)
+		s.data[expiriesKey] = expiries
+	}
+	expiries[key] = t.UnixNano()
+}
+
+// hasExpired returns true if any key has expired. It is not concurrent-safe and
+// is the caller's responsibility to ensure the lock is held.
+func (s *BaseSession) hasExpired() bool {
+	expiries, _ := s.data[expiriesKey].(Data)
+	if len(expiries) == 0 {
+		return false
+	}
+
+	now := s.now().UnixNano()
+	for _, v := range expiries {
+		if expiresAt, _ := v.(int64); expiresAt <= now {
+			return true
+		}
+	}
+	return false
+}
+
+// expire deletes keys that have expired from the session data. It is not
+// concurrent-safe and is the caller's responsibility to ensure the lock is
+// held.
+func (s *BaseSession) expire() {
+	expiries, ok := s.data[expiriesKey].(Data)
+	if !ok {
+		return
+	}
+
+	now := s.now().UnixNano()
+	expired := false
+	for key, v := range expiries {
+		expiresAt, _ := v.(int64)
+		if expiresAt > now {
+			continue
+		}
+
+		s.record(AuditOpDelete, key, nil, false)
+		delete(s.data, key)
+		delete(expiries, key)
+		expired = true
+	}
+	if !expired {
+		return
+	}
+
+	s.changed = true
+	if len(expiries) == 0 {
+		delete(s.data, expiriesKey)
+	}
+	s.loadBindings()
+}
+
+// record journals a change to be made to the value of given key when auditing.
+// It must be called before the change is applied to the session data. It is
+// not concurrent-safe and is the caller's responsibility to ensure the lock is
+// held.
+func (s *BaseSession) record(op AuditOp, key, val interface{}, hasVal bool) {
+	if !s.auditing {
+		return
+	}
+
+	old, hasOld := s.data[key]
+	s.journal = append(s.journal, journalEntry{
+		op:     op,
+		key:    key,
+		old:    old,
+		new:    val,
+		hasOld: hasOld,
+		hasNew: hasVal,
+		time:   s.now(),
+	})
+}
+
+func (s *BaseSession) startAudit() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.auditing = true
+	s.journal = nil
+}
+
+func (s *BaseSession) takeJournal() []journalEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.syncBindings()
+
+	journal := s.journal
+	s.auditing = false
+	s.journal = nil
+	return journal
+}
+
+func (s *BaseSession) bind(typ reflect.Type) reflect.Value {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	b, ok := s.bindings[typ]
+	if ok {
+		return b.ptr
+	}
+
+	if s.bindings == nil {
+		s.bindings = make(map[reflect.Type]*binding)
+	}
+	s.expire()
+	b = newBinding(typ)
+	b.load(s.data)
+	s.bindings[typ] = b
+	return b.ptr
+}
+
+func (s *BaseSession) unbind() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.syncBindings()
+	s.bindings = nil
+}
+
+// syncBindings writes changes made to bound structs back to the session data.
+// It is not concurrent-safe and is the caller's responsibility to ensure the
+// lock is held.
+func (s *BaseSession) syncBindings() {
+	for _, b := range s.bindings {
+		if b.sync(s.data, s.record) {
+			s.changed = true
+		}
+	}
+}
+
+// loadBindings reloads bound structs from the session data. It is not
+// concurrent-safe and is the caller's responsibility to ensure the lock is
+// held.
+func (s *BaseSession) loadBindings() {
+	for _, b := range s.bindings {
+		b.load(s.data)
+	}
+}
+
+var _ tagger = (*BaseSession)(nil)
+
+// Tag sets the tag of given key to be the value, see session.Tag.
+func (s *BaseSession) Tag(key, value string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.changed = true
+
+	if value == "" {
+		delete(s.tags, key)
+		return
+	}
+	if s.tags == nil {
+		s.tags = make(map[string]string)
+	}
+	s.tags[key] = value
+}
+
+// Tags returns a copy of all tags of the session.
+func (s *BaseSession) Tags() map[string]string {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
+
+	tags := make(map[string]string, len(s.tags))
+	for k, v := range s.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// LoadTags initializes tags of the session without marking the session as
+// changed. It is meant to be called by session stores when reading sessions.
+func (s *BaseSession) LoadTags(tags map[string]string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.tags = make(map[string]string, len(tags))
+	for k, v := range tags {
+		s.tags[k] = v
+	}
+}
+
+// Data returns a shallow copy of the session data.
+func (s *BaseSession) Data() Data {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.syncBindings()
+	s.expire()
+
+	data := make(Data, len(s.data))
+	for k, v := range s.data {
+		data[k] = v
+	}
+	return data
+}
+
+// clone returns a copy of the session that shares nothing mutable with it, i.e.
+// nested Data and lists are copied recursively. Bindings and functions that are
+// set per request are not copied.
+func (s *BaseSession) clone() *BaseSession {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.syncBindings()
+
+	c := &BaseSession{
+		sessionState: &sessionState{
+			sid:          s.sid,
+			data:         cloneValue(s.data).(Data),
+			changed:      s.changed,
+			loadedDigest: s.loadedDigest,
+			encoder:      s.encoder,
+			idWriter:     s.idWriter,
+		},
+	}
+	if s.tags != nil {
+		c.tags = make(map[string]string, len(s.tags))
+		for k, v := range s.tags {
+			c.tags[k] = v
+		}
+	}
+	return c
+}
+
+// cloneValue returns a copy of the value with nested Data and lists copied
+// recursively, other values are returned as-is.
+func cloneValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case Data:
+		c := make(Data, len(v))
+		for k, val := range v {
+			c[k] = cloneValue(val)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, val := range v {
+			c[i] = cloneValue(val)
+		}
+		return c
+	}
+	return v
+}
+
+func (s *BaseSession) Encode() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.syncBindings()
+	s.expire()
+	binary, err := s.encoder(s.data)
+	if err != nil {
+		return nil, newEncodeError(s.data, err)
+	}
+	return binary, nil
+}
+
+func (s *BaseSession) HasChanged() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.syncBindings()
 	return s.changed
 }
 
@@ -139,11 +873,43 @@ func GobEncoder(data Data) ([]byte, error) {
 func GobDecoder(binary []byte) (Data, error) {
 	buf := bytes.NewBuffer(binary)
 	var data Data
-	return data, gob.NewDecoder(buf).Decode(&data)
+	err := gob.NewDecoder(buf).Decode(&data)
+	if err != nil {
+		return nil, &DataError{Op: "decode", Size: len(binary), Err: err}
+	}
+	return data, nil
+}
+
+// GobStreamEncoder is a session data stream encoder using Gob, which produces
+// the same encoding as the session.GobEncoder.
+func GobStreamEncoder(w io.Writer, data Data) error {
+	return gob.NewEncoder(w).Encode(data)
+}
+
+// GobStreamDecoder is a session data stream decoder using Gob, which decodes
+// the encoding of both the session.GobEncoder and the session.GobStreamEncoder.
+func GobStreamDecoder(r io.Reader) (Data, error) {
+	var data Data
+	err := gob.NewDecoder(r).Decode(&data)
+	if err != nil {
+		return nil, &DataError{Op: "decode", Size: -1, Err: err}
+	}
+	return data, nil
 }
 
 // Flash is anything that gets retrieved and deleted as soon as the next request
 // happens.
 type Flash interface{}
 
-const flashKey = "flamego::session::flash"
+// markStale marks the session data as decoded with outdated keys.
+func markStale(data Data) {
+	if data != nil {
+		data[staleKey] = true
+	}
+}
+
+func init() {
+	// Expiry times are stored as a nested Data, which needs to be registered to be
+	// encoded as an interface value.
+	gob.Register(Data{})
+}
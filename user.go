@@ -0,0 +1,376 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flamego/flamego"
+)
+
+// UserTag is the key of the session tag that binds sessions to users, which
+// makes up the index of sessions by users in session stores that implement
+// session.TagFinder.
+const UserTag = "flamego::user"
+
+// MetadataTagPrefix is the prefix of keys of the session tags that hold the
+// metadata captured by Options.MetadataFunc.
+const MetadataTagPrefix = "flamego::meta::"
+
+// lastSeenInterval is the minimum interval of updating the last seen time of
+// sessions, which avoids persisting sessions on every request. The
+// Options.TouchThreshold takes precedence when it is longer.
+const lastSeenInterval = time.Minute
+
+// BindUser binds the session to the user with given ID, typically upon signing
+// in. An empty user ID unbinds the session from any user.
+func BindUser(s Session, userID string) {
+	Tag(s, UserTag, userID)
+}
+
+// UserOf returns the ID of the user that the session is bound to, or empty if
+// the session is not bound to any user.
+func UserOf(s Session) string {
+	return TagsOf(s)[UserTag]
+}
+
+// FindByUser returns IDs of sessions that are bound to the user with given ID.
+// It requires the session store to implement session.TagFinder.
+func FindByUser(ctx context.Context, store Store, userID string) ([]string, error) {
+	finder, ok := StoreAs[TagFinder](store)
+	if !ok || !Capabilities(store).FindByTag {
+		return nil, fmt.Errorf("session store with the type %T does not support finding by tags", store)
+	}
+	return finder.FindByTag(ctx, UserTag, userID)
+}
+
+// SessionInfo is the information of a session, e.g. for rendering the list of
+// signed-in devices of a user.
+type SessionInfo struct {
+	// ID is the session ID.
+	ID string
+	// CreatedAt is the time when the session was created.
+	CreatedAt time.Time
+	// LastSeenAt is the time when the session was last used, which is updated at
+	// most once per minute.
+	LastSeenAt time.Time
+	// IP is the remote IP address of the request that created the session.
+	IP string
+	// UserAgent is the user agent of the request that created the session.
+	UserAgent string
+	// Metadata is the metadata captured by Options.MetadataFunc when the session
+	// was created.
+	Metadata map[string]string
+}
+
+// InfoOf returns the information of the session. Fields other than the ID are
+// zero values if the session has not been through the middleware, or the
+// information is not tracked (see Options.TrackInfo).
+func InfoOf(s Session) SessionInfo {
+	s = rootOf(s)
+	info, _ := s.Get(infoKey).(Data)
+	createdAt, _ := info["created_at"].(int64)
+	lastSeenAt, _ := info["last_seen_at"].(int64)
+	ip, _ := info["ip"].(string)
+	userAgent, _ := info["user_agent"].(string)
+
+	si := SessionInfo{
+		ID:        s.ID(),
+		IP:        ip,
+		UserAgent: userAgent,
+	}
+	if createdAt > 0 {
+		si.CreatedAt = time.Unix(0, createdAt)
+	}
+	if lastSeenAt > 0 {
+		si.LastSeenAt = time.Unix(0, lastSeenAt)
+	}
+	for k, v := range TagsOf(s) {
+		if strings.HasPrefix(k, MetadataTagPrefix) {
+			if si.Metadata == nil {
+				si.Metadata = make(map[string]string)
+			}
+			si.Metadata[strings.TrimPrefix(k, MetadataTagPrefix)] = v
+		}
+	}
+	return si
+}
+
+// CreatedAt returns the time when the session was created, e.g. to require
+// re-entering the password for sessions older than 12 hours. It is recorded by
+// the middleware alongside the session data (see session.InfoOf) when the
+// session is first saved, thus it returns zero time for sessions that have not
+// been saved yet.
+func CreatedAt(s Session) time.Time {
+	return InfoOf(s).CreatedAt
+}
+
+// LastAccessedAt returns the time when the session was last accessed before the
+// current request, which is updated by the middleware at most once per minute
+// or Options.TouchThreshold whichever is longer. It returns zero time for
+// sessions that have not been saved yet.
+func LastAccessedAt(s Session) time.Time {
+	return InfoOf(s).LastSeenAt
+}
+
+// ListByUser returns the information of sessions that are bound to the user
+// with given ID, ordered by the last seen time with the most recent first. It
+// requires the session store to implement session.TagFinder, and the
+// information is only tracked with Options.TrackInfo.
+func ListByUser(ctx context.Context, store Store, userID string) ([]SessionInfo, error) {
+	sids, err := FindByUser(ctx, store, userID)
+	if err != nil {
+		return nil, fmt.Errorf("find by user: %w", err)
+	}
+
+	infos := make([]SessionInfo, 0, len(sids))
+	for _, sid := range sids {
+		sess, err := store.Read(ctx, sid)
+		if err != nil {
+			return nil, fmt.Errorf("read %q: %w", sid, err)
+		}
+		infos = append(infos, InfoOf(sess))
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].LastSeenAt.After(infos[j].LastSeenAt)
+	})
+	return infos, nil
+}
+
+// trackInfo captures the information of the session from the request when the
+// session is created, and updates the last seen time of the session to now at
+// most once a minute or per Options.TouchThreshold whichever is longer. The
+// metadata returned by the Options.MetadataFunc is persisted as session tags,
+// i.e. outside of the session data. Nothing is tracked unless required by the
+// options, see Options.TrackInfo.
+func trackInfo(r *http.Request, s Session, now time.Time, opt Options) {
+	trackCreated := opt.TrackInfo || opt.AbsoluteTimeout > 0 || opt.RotateIDAfter > 0 || opt.IdleLockAfter > 0
+	trackLastSeen := opt.TrackInfo || opt.IdleLockAfter > 0
+	if !trackCreated {
+		return
+	}
+
+	info, ok := s.Get(infoKey).(Data)
+	if ok {
+		lastSeenAt, _ := info["last_seen_at"].(int64)
+		if !trackLastSeen || now.Sub(time.Unix(0, lastSeenAt)) < max(lastSeenInterval, opt.TouchThreshold) {
+			return
+		}
+
+		updated := make(Data, len(info))
+		for k, v := range info {
+			updated[k] = v
+		}
+		updated["last_seen_at"] = now.UnixNano()
+		setInternal(s, infoKey, updated)
+		return
+	}
+
+	info = Data{"created_at": now.UnixNano()}
+	if trackLastSeen {
+		info["last_seen_at"] = now.UnixNano()
+	}
+	if !opt.TrackInfo {
+		setInternal(s, infoKey, info)
+		return
+	}
+
+	info["ip"] = remoteIP(r)
+	info["user_agent"] = r.UserAgent()
+	setInternal(s, infoKey, info)
+	if opt.MetadataFunc != nil {
+		for k, v := range opt.MetadataFunc(r) {
+			// The key used to hold the impersonation marker, which must not be spoofed
+			// for readers that are not aware of the ImpersonatorTag.
+			if k == "impersonator" {
+				continue
+			}
+			Tag(s, MetadataTagPrefix+k, v)
+		}
+	}
+}
+
+// IsDisplaced returns true if the session has been displaced by newer sessions
+// of the same user and flagged as configured by SessionLimitOptions.
+func IsDisplaced(s Session) bool {
+	displaced, _ := s.Get(displacedKey).(bool)
+	return displaced
+}
+
+// DestroyAllResponse is the response body of the handler returned by the
+// DestroyAllHandler.
+type DestroyAllResponse struct {
+	// Destroyed is the number of sessions that are destroyed, excluding the
+	// current session.
+	Destroyed int `json:"destroyed"`
+}
+
+// DestroyAllHandler returns a handler that signs out the currently signed-in
+// user everywhere, i.e. destroys all other sessions of the user, and
+// regenerates the ID of the current session. It responds with the number of
+// destroyed sessions as JSON (see DestroyAllResponse), or 401 if the current
+// session is not bound to any user. The `userKey` is the key of the session tag
+// that binds sessions to users, default is session.UserTag (see
+// session.BindUser). It requires the session store to implement
+// session.TagFinder and must be used after the session.Sessioner. Sessions are
+// destroyed through the session.Sessioner with its timeouts and retries, and
+// errors are reported via Options.ErrorFunc with a generic error in response.
+//
+// Example:
+//
+//	f.Post("/logout-everywhere", session.DestroyAllHandler(""))
+func DestroyAllHandler(userKey string) flamego.Handler {
+	if userKey == "" {
+		userKey = UserTag
+	}
+	return func(c flamego.Context, s Session, store Store) {
+		w := c.ResponseWriter()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		userID := TagsOf(s)[userKey]
+		if userID == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "not signed in"})
+			return
+		}
+
+		state := requestStateOf(c)
+		fail := func(err error) {
+			if state != nil && state.reportError != nil {
+				state.reportError(fmt.Errorf("destroy all: %w", err))
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "internal error"})
+		}
+
+		ctx := c.Request().Context()
+		finder, ok := StoreAs[TagFinder](store)
+		if !ok || !Capabilities(store).FindByTag {
+			fail(fmt.Errorf("session store with the type %T does not support finding by tags", store))
+			return
+		}
+		sids, err := finder.FindByTag(ctx, userKey, userID)
+		if err != nil {
+			fail(fmt.Errorf("find by tag: %w", err))
+			return
+		}
+
+		destroy := store.Destroy
+		if state != nil {
+			destroy = state.destroy
+		}
+		var resp DestroyAllResponse
+		for _, sid := range sids {
+			if sid == s.ID() {
+				continue
+			}
+			err = destroy(ctx, sid)
+			if err != nil {
+				fail(fmt.Errorf("destroy %q: %w", sid, err))
+				return
+			}
+			resp.Destroyed++
+		}
+
+		// Regenerate the ID so that the current session cannot be used with the old
+		// ID that may have been leaked.
+		err = renew(c, s, store)
+		if err != nil {
+			fail(err)
+			return
+		}
+		// Make sure the session is persisted with the new ID
+		Tag(s, userKey, userID)
+
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// SessionLimitOptions contains options for limiting the number of concurrent
+// sessions per user, which is built on the index of sessions by users (see
+// session.BindUser) and requires the session store to implement
+// session.TagFinder.
+type SessionLimitOptions struct {
+	// Max is the maximum number of concurrent sessions per user. When a session
+	// is bound to a user that already has Max sessions, the least recently used
+	// sessions are displaced. Default is 0, i.e. unlimited.
+	Max int
+	// Flag indicates whether to flag displaced sessions instead of destroying
+	// them, which are unbound from the user and reported by session.IsDisplaced
+	// from then on. Default is false, i.e. displaced sessions are destroyed.
+	Flag bool
+	// OnDisplaced is the function to be invoked for each displaced session, e.g.
+	// to inform the displaced device. Default is not set.
+	OnDisplaced func(c flamego.Context, userID, sid string)
+}
+
+// enforceSessionLimit displaces the least recently used sessions of the user
+// other than the current session, until the user has no more sessions than the
+// limit. Sessions are ordered by their expiry time when the session store
+// implements session.Expirer, and in the order returned by the session store
+// otherwise.
+func enforceSessionLimit(c flamego.Context, store Store, sid, userID string, opt SessionLimitOptions) error {
+	ctx := c.Request().Context()
+	sids, err := FindByUser(ctx, store, userID)
+	if err != nil {
+		return fmt.Errorf("find by user: %w", err)
+	}
+
+	others := make([]string, 0, len(sids))
+	for _, id := range sids {
+		if id != sid {
+			others = append(others, id)
+		}
+	}
+	excess := len(others) + 1 - opt.Max
+	if excess <= 0 {
+		return nil
+	}
+
+	if expirer, ok := StoreAs[Expirer](store); ok && Capabilities(store).ExpiresAt {
+		expiries := make(map[string]time.Time, len(others))
+		for _, id := range others {
+			expiries[id], err = expirer.ExpiresAt(ctx, id)
+			if err != nil {
+				return fmt.Errorf("get expiry time of %q: %w", id, err)
+			}
+		}
+		sort.SliceStable(others, func(i, j int) bool {
+			return expiries[others[i]].Before(expiries[others[j]])
+		})
+	}
+
+	for _, id := range others[:excess] {
+		if opt.Flag {
+			sess, err := store.Read(ctx, id)
+			if err != nil {
+				return fmt.Errorf("read %q: %w", id, err)
+			}
+			setInternal(sess, displacedKey, true)
+			BindUser(sess, "")
+			err = store.Save(ctx, sess)
+			if err != nil {
+				return fmt.Errorf("save %q: %w", id, err)
+			}
+		} else {
+			err = store.Destroy(ctx, id)
+			if err != nil {
+				return fmt.Errorf("destroy %q: %w", id, err)
+			}
+		}
+
+		if opt.OnDisplaced != nil {
+			opt.OnDisplaced(c, userID, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestSessioner_SessionLimit(t *testing.T) {
+	for _, flag := range []bool{false, true} {
+		t.Run("flag="+strconv.FormatBool(flag), func(t *testing.T) {
+			var displaced []string
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(Sessioner(
+				Options{
+					SessionLimit: SessionLimitOptions{
+						Max:  2,
+						Flag: flag,
+						OnDisplaced: func(_ flamego.Context, userID, sid string) {
+							assert.Equal(t, "alice", userID)
+							displaced = append(displaced, sid)
+						},
+					},
+				},
+			))
+			f.Get("/signin", func(s Session) string {
+				BindUser(s, "alice")
+				return s.ID()
+			})
+			f.Get("/", func(s Session) string {
+				return UserOf(s) + " " + strconv.FormatBool(IsDisplaced(s))
+			})
+
+			var sids, cookies []string
+			for i := 0; i < 3; i++ {
+				resp := httptest.NewRecorder()
+				req, err := http.NewRequest(http.MethodGet, "/signin", nil)
+				require.NoError(t, err)
+
+				f.ServeHTTP(resp, req)
+				sids = append(sids, resp.Body.String())
+				cookies = append(cookies, resp.Header().Get("Set-Cookie"))
+			}
+
+			// The least recently used session is displaced by the third one
+			assert.Equal(t, []string{sids[0]}, displaced)
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			require.NoError(t, err)
+
+			req.Header.Set("Cookie", cookies[0])
+			f.ServeHTTP(resp, req)
+			assert.Equal(t, " "+strconv.FormatBool(flag), resp.Body.String())
+
+			for _, cookie := range cookies[1:] {
+				resp = httptest.NewRecorder()
+				req, err = http.NewRequest(http.MethodGet, "/", nil)
+				require.NoError(t, err)
+
+				req.Header.Set("Cookie", cookie)
+				f.ServeHTTP(resp, req)
+				assert.Equal(t, "alice false", resp.Body.String())
+			}
+		})
+	}
+}
+
+func TestListByUser(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			TrackInfo: true,
+			MetadataFunc: func(r *http.Request) map[string]string {
+				return map[string]string{"country": "NZ"}
+			},
+		},
+	))
+	f.Get("/signin", func(s Session) string {
+		BindUser(s, "alice")
+		return s.ID()
+	})
+	f.Get("/sessions", func(c flamego.Context, s Session, store Store) string {
+		infos, err := ListByUser(c.Request().Context(), store, UserOf(s))
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		for _, info := range infos {
+			assert.False(t, info.CreatedAt.IsZero())
+			assert.False(t, info.LastSeenAt.IsZero())
+			assert.Equal(t, map[string]string{"country": "NZ"}, info.Metadata)
+			buf.WriteString(info.ID + " " + info.IP + " " + info.UserAgent + "\n")
+		}
+		return buf.String()
+	})
+
+	var sids, cookies []string
+	for _, userAgent := range []string{"Firefox", "Safari"} {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/signin", nil)
+		require.NoError(t, err)
+
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("User-Agent", userAgent)
+		f.ServeHTTP(resp, req)
+		sids = append(sids, resp.Body.String())
+		cookies = append(cookies, resp.Header().Get("Set-Cookie"))
+	}
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/sessions", nil)
+	require.NoError(t, err)
+
+	req.Header.Set("Cookie", cookies[0])
+	f.ServeHTTP(resp, req)
+
+	// The most recently seen session comes first
+	want := sids[1] + " 192.0.2.1 Safari\n" +
+		sids[0] + " 192.0.2.1 Firefox\n"
+	assert.Equal(t, want, resp.Body.String())
+}
+
+func TestDestroyAllHandler(t *testing.T) {
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner())
+	f.Get("/signin", func(s Session) {
+		BindUser(s, "alice")
+	})
+	f.Get("/", func(s Session) string {
+		return UserOf(s)
+	})
+	f.Post("/logout-everywhere", DestroyAllHandler(""))
+
+	var cookies []string
+	for i := 0; i < 3; i++ {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/signin", nil)
+		require.NoError(t, err)
+
+		f.ServeHTTP(resp, req)
+		cookies = append(cookies, resp.Header().Get("Set-Cookie"))
+	}
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/logout-everywhere", nil)
+	require.NoError(t, err)
+
+	req.Header.Set("Cookie", cookies[0])
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"destroyed":2}`, resp.Body.String())
+
+	cookie := resp.Header().Get("Set-Cookie")
+	assert.NotEmpty(t, cookie)
+	assert.NotEqual(t, cookies[0], cookie)
+
+	for _, cookie := range cookies {
+		resp = httptest.NewRecorder()
+		req, err = http.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, err)
+
+		req.Header.Set("Cookie", cookie)
+		f.ServeHTTP(resp, req)
+		assert.Empty(t, resp.Body.String())
+	}
+
+	// The user is not signed in anymore with the old session ID
+	resp = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodPost, "/logout-everywhere", nil)
+	require.NoError(t, err)
+
+	req.Header.Set("Cookie", cookies[0])
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+// destroyFailingStore is a session store that fails to destroy sessions.
+type destroyFailingStore struct {
+	Store
+}
+
+func (s *destroyFailingStore) Unwrap() Store { return s.Store }
+
+func (*destroyFailingStore) Destroy(context.Context, string) error {
+	return errors.New("unreachable")
+}
+
+func TestDestroyAllHandler_Error(t *testing.T) {
+	var errs []string
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			StoreWrappers: []StoreMiddleware{
+				func(store Store) Store { return &destroyFailingStore{Store: store} },
+			},
+			ErrorFunc: func(err error) { errs = append(errs, err.Error()) },
+		},
+	))
+	f.Get("/signin", func(s Session) {
+		BindUser(s, "alice")
+	})
+	f.Post("/logout-everywhere", DestroyAllHandler(""))
+
+	var cookies []string
+	for i := 0; i < 2; i++ {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/signin", nil)
+		require.NoError(t, err)
+
+		f.ServeHTTP(resp, req)
+		cookies = append(cookies, resp.Header().Get("Set-Cookie"))
+	}
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/logout-everywhere", nil)
+	require.NoError(t, err)
+
+	req.Header.Set("Cookie", cookies[0])
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	// Session IDs are only reported via ErrorFunc
+	assert.JSONEq(t, `{"error":"internal error"}`, resp.Body.String())
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0], "destroy all: destroy ")
+	assert.Contains(t, errs[0], "unreachable")
+}
+
+func TestSession_CreatedAt(t *testing.T) {
+	start := time.Now()
+	now := start
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			Config: FileConfig{
+				RootDir: t.TempDir(),
+				NowFunc: func() time.Time { return now },
+			},
+			Initer:    FileIniter(),
+			GCMode:    GCDisabled,
+			NowFunc:   func() time.Time { return now },
+			TrackInfo: true,
+		},
+	))
+	format := func(createdAt, lastAccessedAt time.Time) string {
+		return createdAt.Format(time.RFC3339Nano) + " " + lastAccessedAt.Format(time.RFC3339Nano)
+	}
+	f.Get("/", func(s Session) string {
+		return format(CreatedAt(s), LastAccessedAt(s))
+	})
+
+	var cookie string
+	request := func() string {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, err)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		f.ServeHTTP(resp, req)
+		if c := resp.Header().Get("Set-Cookie"); c != "" {
+			cookie = strings.Split(c, ";")[0]
+		}
+		return resp.Body.String()
+	}
+
+	// Times are not recorded until the session is saved
+	assert.Equal(t, format(time.Time{}, time.Time{}), request())
+
+	now = start.Add(5 * time.Minute)
+	assert.Equal(t, format(start, start), request())
+
+	// The last access time is that of the previous request
+	now = start.Add(10 * time.Minute)
+	assert.Equal(t, format(start, start.Add(5*time.Minute)), request())
+}
+
+func TestSessioner_TrackInfo(t *testing.T) {
+	for _, trackInfo := range []bool{false, true} {
+		t.Run(strconv.FormatBool(trackInfo), func(t *testing.T) {
+			var store *writeCountingStore
+			f := flamego.NewWithLogger(&bytes.Buffer{})
+			f.Use(Sessioner(
+				Options{
+					Initer: func(ctx context.Context, args ...interface{}) (Store, error) {
+						memory, err := MemoryIniter()(ctx, args...)
+						if err != nil {
+							return nil, err
+						}
+						store = &writeCountingStore{Store: memory}
+						return store, nil
+					},
+					TrackInfo: trackInfo,
+					MetadataFunc: func(r *http.Request) map[string]string {
+						// Metadata cannot spoof the impersonation marker
+						return map[string]string{"country": "NZ", "impersonator": "admin"}
+					},
+				},
+			))
+			var info SessionInfo
+			var impersonator string
+			f.Get("/", func(s Session) {
+				info = InfoOf(s)
+				impersonator = Impersonator(s)
+			})
+
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/", nil)
+			require.NoError(t, err)
+			req.Header.Set("User-Agent", "flamego")
+			f.ServeHTTP(resp, req)
+
+			req.Header.Set("Cookie", resp.Header().Get("Set-Cookie"))
+			f.ServeHTTP(httptest.NewRecorder(), req)
+			if !trackInfo {
+				// Nothing is stored and sessions are only touched by default
+				assert.Equal(t, SessionInfo{ID: info.ID}, info)
+				assert.Equal(t, 0, store.saves)
+				assert.Equal(t, 2, store.touches)
+				return
+			}
+			assert.Equal(t, "flamego", info.UserAgent)
+			assert.Equal(t, map[string]string{"country": "NZ"}, info.Metadata)
+			assert.Empty(t, impersonator)
+			assert.False(t, info.CreatedAt.IsZero())
+			assert.NotZero(t, store.saves)
+		})
+	}
+}
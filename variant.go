@@ -0,0 +1,82 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// exposer is a session that is capable of reporting exposures to variants of
+// experiments.
+type exposer interface {
+	// expose reports the exposure of the session to the variant of the
+	// experiment.
+	expose(experiment, variant string)
+}
+
+// Variant returns the variant of the experiment that is assigned to the session,
+// which is picked deterministically in proportion to the weights of variants on
+// first exposure and persisted in the session. Variants are reassigned if the
+// assigned variant is no longer weighted. Every call is reported to
+// Options.OnExposure. It returns an empty string if there is no variant with a
+// positive weight.
+func Variant(s Session, experiment string, weights map[string]int) string {
+	s = rootOf(s)
+	variant := assignVariant(s, experiment, weights)
+	if e, ok := s.(exposer); ok && variant != "" {
+		e.expose(experiment, variant)
+	}
+	return variant
+}
+
+// assignVariant returns the variant of the experiment for the session. The
+// persisted variant is returned if it is still one of the weighted variants,
+// otherwise a new variant is picked deterministically from the session ID and
+// the experiment name in proportion to the weights and persisted. It returns an
+// empty string if there is no variant with a positive weight.
+func assignVariant(s Session, experiment string, weights map[string]int) string {
+	variants, _ := s.Get(variantsKey).(Data)
+	if v, ok := variants[experiment].(string); ok && weights[v] > 0 {
+		return v
+	}
+
+	names := make([]string, 0, len(weights))
+	total := uint64(0)
+	for name, weight := range weights {
+		if weight > 0 {
+			names = append(names, name)
+			total += uint64(weight)
+		}
+	}
+	if total == 0 {
+		return ""
+	}
+	sort.Strings(names)
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s.ID()))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(experiment))
+	bucket := h.Sum64() % total
+
+	var variant string
+	for _, name := range names {
+		w := uint64(weights[name])
+		if bucket < w {
+			variant = name
+			break
+		}
+		bucket -= w
+	}
+
+	updated := make(Data, len(variants)+1)
+	for k, v := range variants {
+		updated[k] = v
+	}
+	updated[experiment] = variant
+	setInternal(s, variantsKey, updated)
+	return variant
+}
@@ -0,0 +1,119 @@
+// Copyright 2021 Flamego. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package session
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flamego/flamego"
+)
+
+func TestSession_Variant(t *testing.T) {
+	weights := map[string]int{"control": 1, "treatment": 1}
+
+	// Assignments are deterministic for the session ID and the experiment
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		sid := strconv.Itoa(i)
+		v := Variant(NewBaseSession(sid, GobEncoder, nil), "checkout", weights)
+		assert.Equal(t, v, Variant(NewBaseSession(sid, GobEncoder, nil), "checkout", weights))
+		counts[v]++
+	}
+	assert.Len(t, counts, 2)
+	assert.InDelta(t, 500, counts["control"], 100, "variants are not assigned in proportion to weights")
+
+	s := NewBaseSession("1", GobEncoder, nil)
+	v := Variant(s, "checkout", weights)
+	assert.True(t, s.HasChanged())
+
+	// Assigned variants are kept even when the weights change
+	assert.Equal(t, v, Variant(s, "checkout", map[string]int{"control": 1, "treatment": 1, "other": 100}))
+
+	// Variants that are no longer weighted are reassigned
+	assert.Equal(t, "other", Variant(s, "checkout", map[string]int{v: 0, "other": 1}))
+
+	assert.Empty(t, Variant(s, "empty", map[string]int{"control": 0}))
+}
+
+func TestSessioner_OnExposure(t *testing.T) {
+	var exposures []string
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			OnExposure: func(c flamego.Context, experiment, variant string) {
+				exposures = append(exposures, c.Request().URL.Path+" "+experiment+" "+variant)
+			},
+		},
+	))
+	f.Get("/", func(s Session) string {
+		return Variant(s, "checkout", map[string]int{"treatment": 1})
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	assert.Equal(t, "treatment", resp.Body.String())
+	assert.Equal(t, []string{"/ checkout treatment"}, exposures)
+}
+
+func TestSessioner_OnExposureConcurrentRequests(t *testing.T) {
+	var mu sync.Mutex
+	var exposures []string
+	f := flamego.NewWithLogger(&bytes.Buffer{})
+	f.Use(Sessioner(
+		Options{
+			OnExposure: func(c flamego.Context, experiment, variant string) {
+				mu.Lock()
+				defer mu.Unlock()
+				exposures = append(exposures, c.Request().URL.Path+" "+experiment+" "+variant)
+			},
+		},
+	))
+	f.Get("/", func(s Session) {})
+	started, proceed := make(chan struct{}), make(chan struct{})
+	f.Get("/slow", func(s Session) string {
+		close(started)
+		<-proceed
+		return Variant(s, "checkout", map[string]int{"treatment": 1})
+	})
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, err)
+	f.ServeHTTP(resp, req)
+	cookie := resp.Result().Cookies()[0]
+
+	request := func(path string) {
+		resp := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		req.AddCookie(cookie)
+		f.ServeHTTP(resp, req)
+	}
+
+	// Exposures are reported with the request that is exposed
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		request("/slow")
+	}()
+	<-started
+	request("/")
+	close(proceed)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"/slow checkout treatment"}, exposures)
+}